@@ -7,6 +7,8 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/rancher/go-rancher/api"
 	"github.com/rancher/go-rancher/client"
+
+	"github.com/rancher/longhorn-manager/types"
 )
 
 type HandleFuncWithError func(http.ResponseWriter, *http.Request) error
@@ -24,30 +26,63 @@ func HandleError(s *client.Schemas, t HandleFuncWithError) http.Handler {
 }
 
 func Handler(s *Server) http.Handler {
+	t := newRouter(s)
+	handler := StaleHandler(s.rev, ETagHandler(s.rev, t.r))
+	if AuthEnabled {
+		handler = AuthHandler(t, s.man.AuthenticateAPIToken, handler)
+	}
+	return DrainHandler(s, handler)
+}
+
+// newRouter registers the routes, each with the role it requires
+func newRouter(s *Server) *routeTable {
 	r := mux.NewRouter().StrictSlash(true)
+	t := newRouteTable(r)
 	schemas := NewSchema()
 	f := HandleError
 
+	const (
+		public   = RoutePublic
+		readonly = types.APIRoleReadonly
+		operator = types.APIRoleOperator
+		admin    = types.APIRoleAdmin
+		internal = RouteInternal
+	)
+
 	versionsHandler := api.VersionsHandler(schemas, "v1")
 	versionHandler := api.VersionHandler(schemas, "v1")
-	r.Methods("GET").Path("/").Handler(versionsHandler)
-	r.Methods("GET").Path("/v1").Handler(versionHandler)
-	r.Methods("GET").Path("/v1/apiversions").Handler(versionsHandler)
-	r.Methods("GET").Path("/v1/apiversions/v1").Handler(versionHandler)
-	r.Methods("GET").Path("/v1/schemas").Handler(api.SchemasHandler(schemas))
-	r.Methods("GET").Path("/v1/schemas/{id}").Handler(api.SchemaHandler(schemas))
-
-	r.Methods("GET").Path("/v1/settings").Handler(f(schemas, s.settings.List))
-	r.Methods("GET").Path("/v1/settings/{name}").Handler(f(schemas, s.settings.Get))
-	r.Methods("PUT").Path("/v1/settings/{name}").Handler(f(schemas, s.settings.Set))
-
-	r.Methods("GET").Path("/v1/volumes").Handler(f(schemas, s.ListVolume))
-	r.Methods("GET").Path("/v1/volumes/{name}").Handler(f(schemas, s.GetVolume))
-	r.Methods("DELETE").Path("/v1/volumes/{name}").Handler(f(schemas, s.DeleteVolume))
-	r.Methods("POST").Path("/v1/volumes").Handler(f(schemas, s.CreateVolume))
+	t.route(public, "GET", "/").Handler(versionsHandler)
+	t.route(public, "GET", "/v1").Handler(versionHandler)
+	t.route(public, "GET", "/v1/apiversions").Handler(versionsHandler)
+	t.route(public, "GET", "/v1/apiversions/v1").Handler(versionHandler)
+	t.route(public, "GET", "/v1/ready").Handler(f(schemas, s.Ready))
+	t.route(readonly, "GET", "/metrics").HandlerFunc(s.Metrics)
+	t.route(readonly, "GET", "/v1/info").Handler(f(schemas, s.Info))
+	t.route(public, "GET", "/v1/schemas").Handler(api.SchemasHandler(schemas))
+	t.route(public, "GET", "/v1/schemas/{id}").Handler(api.SchemaHandler(schemas))
+
+	t.route(readonly, "GET", "/v1/settings").Handler(f(schemas, s.settings.List))
+	t.route(admin, "PUT", "/v1/settings").Handler(f(schemas, s.settings.SetAll))
+	t.route(readonly, "GET", "/v1/settingdefinitions").Handler(f(schemas, s.settings.Definitions))
+	t.route(readonly, "GET", "/v1/settings/history").Handler(f(schemas, s.settings.History))
+	t.route(admin, "POST", "/v1/settings/rollback/{revision}").Handler(f(schemas, s.settings.Rollback))
+	t.route(admin, "POST", "/v1/settings/history/{revision}/keep").Handler(f(schemas, s.settings.KeepRevision))
+	t.route(readonly, "GET", "/v1/settings/{name}").Handler(f(schemas, s.settings.Get))
+	t.route(admin, "PUT", "/v1/settings/{name}").Handler(f(schemas, s.settings.Set))
+
+	t.route(readonly, "GET", "/v1/volumes").Handler(f(schemas, s.ListVolume))
+	t.route(readonly, "GET", "/v1/volumes/{name}").Handler(f(schemas, s.GetVolume))
+	t.route(operator, "DELETE", "/v1/volumes/{name}").Handler(f(schemas, s.DeleteVolume))
+	t.route(operator, "POST", "/v1/volumes").Handler(f(schemas, s.CreateVolume))
+	t.route(readonly, "GET", "/v1/volumes/{name}/events").Handler(f(schemas, s.ListVolumeEvents))
+	t.route(readonly, "GET", "/v1/volumes/{name}/history").Handler(f(schemas, s.GetVolumeHistory))
+	t.route(operator, "POST", "/v1/volumes/{name}/events/{batch}/keep").Handler(f(schemas, s.KeepVolumeEvents))
+	t.route(operator, "POST", "/v1/volumes/{name}/history/{seq}/keep").Handler(f(schemas, s.KeepVolumeState))
+	t.route(operator, "POST", "/v1/volumes/{name}/migrate-controller").Handler(f(schemas, s.fwd.Handler(HostIDFromMigrateReq, s.MigrateController)))
+	t.route(readonly, "GET", "/v1/volumes/{name}/instances/{instance}/engine-status").Handler(f(schemas, s.fwd.Handler(HostIDFromInstance(s.man), s.EngineStatus)))
 
 	volumeActions := map[string]func(http.ResponseWriter, *http.Request) error{
-		"attach":          s.fwd.Handler(HostIDFromAttachReq, s.AttachVolume),
+		"attach":          s.fwd.Handler(HostIDFromAttachReq(s.man), s.AttachVolume),
 		"detach":          s.fwd.Handler(HostIDFromVolume(s.man), s.DetachVolume),
 		"snapshotPurge":   s.fwd.Handler(HostIDFromVolume(s.man), s.snapshots.Purge),
 		"snapshotCreate":  s.fwd.Handler(HostIDFromVolume(s.man), s.snapshots.Create),
@@ -59,27 +94,132 @@ func Handler(s *Server) http.Handler {
 		"recurringUpdate": s.fwd.Handler(HostIDFromVolume(s.man), s.UpdateRecurring),
 		"bgTaskQueue":     s.fwd.Handler(HostIDFromVolume(s.man), s.BgTaskQueue),
 		"replicaRemove":   s.fwd.Handler(HostIDFromVolume(s.man), s.ReplicaRemove),
+		"replicaMigrate":  s.fwd.Handler(HostIDFromVolume(s.man), s.ReplicaMigrate),
+		"convert":         s.fwd.Handler(HostIDFromVolume(s.man), s.ConvertVolume),
+		"rebuildCancel":   s.fwd.Handler(HostIDFromVolume(s.man), s.CancelRebuild),
+		"standbyCreate":   s.fwd.Handler(HostIDFromVolume(s.man), s.CreateStandbyReplica),
+		"standbyPromote":  s.fwd.Handler(HostIDFromVolume(s.man), s.PromoteStandbyReplica),
+
+		"replicaDiskUsage": s.fwd.Handler(HostIDFromReplicaReq(s.man), s.ReplicaDiskUsage),
+
+		"preferredHostUpdate": s.UpdatePreferredHost,
+		"autoReattachUpdate":  s.UpdateAutoReattach,
+		"pinReplicasUpdate":   s.UpdatePinReplicas,
+		"salvage":             s.Salvage,
+		"replan":              s.Replan,
+		"abortCreation":       s.AbortVolumeCreation,
+		"snapshotCompact":     s.CompactSnapshots,
+
+		"engineVersionConstraintUpdate": s.UpdateEngineVersionConstraint,
+		"backupCompressionUpdate":       s.UpdateBackupCompression,
+	}
+	// the volume actions require operator, except these
+	volumeActionRoles := map[string]types.APIRole{
+		"snapshotList":     readonly,
+		"snapshotGet":      readonly,
+		"bgTaskQueue":      readonly,
+		"replicaDiskUsage": readonly,
+		"salvage":          admin,
 	}
 	for name, action := range volumeActions {
-		r.Methods("POST").Path("/v1/volumes/{name}").Queries("action", name).Handler(f(schemas, action))
+		role := operator
+		if r, ok := volumeActionRoles[name]; ok {
+			role = r
+		}
+		t.action(role, "/v1/volumes/{name}", name).Handler(f(schemas, action))
 	}
 
-	r.Methods("GET").Path("/v1/backupvolumes").Handler(f(schemas, s.backups.ListVolume))
-	r.Methods("GET").Path("/v1/backupvolumes/{volName}").Handler(f(schemas, s.backups.GetVolume))
+	t.route(readonly, "GET", "/v1/backupvolumes").Handler(f(schemas, s.backups.ListVolume))
+	t.route(readonly, "GET", "/v1/backupvolumes/{volName}").Handler(f(schemas, s.backups.GetVolume))
 	backupActions := map[string]func(http.ResponseWriter, *http.Request) error{
 		"backupList":   s.backups.List,
 		"backupGet":    s.backups.Get,
 		"backupDelete": s.backups.Delete,
 	}
+	backupActionRoles := map[string]types.APIRole{
+		"backupList":   readonly,
+		"backupGet":    readonly,
+		"backupDelete": operator,
+	}
 	for name, action := range backupActions {
-		r.Methods("POST").Path("/v1/backupvolumes/{volName}").Queries("action", name).Handler(f(schemas, action))
+		t.action(backupActionRoles[name], "/v1/backupvolumes/{volName}", name).Handler(f(schemas, action))
+	}
+	t.route(operator, "DELETE", "/v1/backuptargets/default/volumes/{volName}/backups/{backupName}").Handler(f(schemas, s.backups.DeleteBackup))
+
+	t.route(readonly, "GET", "/v1/volumegroups").Handler(f(schemas, s.ListVolumeGroups))
+	t.route(operator, "POST", "/v1/volumegroups").Handler(f(schemas, s.CreateVolumeGroup))
+	t.route(readonly, "GET", "/v1/volumegroups/{name}").Handler(f(schemas, s.GetVolumeGroup))
+	t.route(operator, "DELETE", "/v1/volumegroups/{name}").Handler(f(schemas, s.DeleteVolumeGroup))
+	volumeGroupActions := map[string]func(http.ResponseWriter, *http.Request) error{
+		"volumeAdd":      s.AddVolumeGroupVolumes,
+		"volumeRemove":   s.RemoveVolumeGroupVolumes,
+		"selectorUpdate": s.UpdateVolumeGroupSelector,
+		"attach":         s.AttachVolumeGroup,
+		"detach":         s.DetachVolumeGroup,
+		"snapshotCreate": s.SnapshotVolumeGroup,
+		"backup":         s.BackupVolumeGroup,
+	}
+	for name, action := range volumeGroupActions {
+		t.action(operator, "/v1/volumegroups/{name}", name).Handler(f(schemas, action))
 	}
 
-	r.Methods("GET").Path("/v1/hosts").Handler(f(schemas, s.ListHost))
-	r.Methods("GET").Path("/v1/hosts/{id}").Handler(f(schemas, s.GetHost))
+	t.route(readonly, "GET", "/v1/hosts").Handler(f(schemas, s.ListHost))
+	t.route(readonly, "GET", "/v1/schedulerstatus").Handler(f(schemas, s.SchedulerStatus))
+	t.route(readonly, "GET", "/v1/consistencyreport").Handler(f(schemas, s.ConsistencyReport))
+	t.route(admin, "POST", "/v1/admin/capacity-check").Handler(f(schemas, s.CapacityCheck))
+	t.route(admin, "GET", "/v1/admin/reconcile").Handler(f(schemas, s.ReconcileStatus))
+	t.route(admin, "POST", "/v1/admin/reconcile/pause").Handler(f(schemas, s.PauseReconcile))
+	t.route(admin, "POST", "/v1/admin/reconcile/resume").Handler(f(schemas, s.ResumeReconcile))
+	t.route(admin, "GET", "/v1/admin/events").Handler(f(schemas, s.EventRecorderStatus))
+	t.route(admin, "GET", "/v1/admin/backup-reads").Handler(f(schemas, s.BackupReadStats))
+	t.route(admin, "GET", "/v1/admin/slow-operations").Handler(f(schemas, s.ListSlowOperations))
+	t.route(admin, "GET", "/v1/admin/runners").Handler(f(schemas, s.ListRunners))
+	t.route(admin, "POST", "/v1/admin/backup-gc").Handler(f(schemas, s.GarbageCollectBackupTarget))
+	t.route(admin, "POST", "/v1/admin/smoke-test").Handler(f(schemas, s.SmokeTest))
+	t.route(admin, "GET", "/v1/admin/locks").Handler(f(schemas, s.ListLocks))
+	t.route(admin, "GET", "/v1/admin/config").Handler(f(schemas, s.EffectiveConfig))
+	t.route(admin, "GET", "/v1/admin/render/controller").Handler(f(schemas, s.fwd.Handler(HostIDFromQuery, s.RenderController)))
+	t.route(admin, "GET", "/v1/admin/render/replica").Handler(f(schemas, s.fwd.Handler(HostIDFromQuery, s.RenderReplica)))
+	t.route(admin, "GET", "/v1/admin/writers").Handler(f(schemas, s.WriterReport))
+	t.route(admin, "GET", "/v1/admin/bootstrap").Handler(f(schemas, s.BootstrapReport))
+	t.route(admin, "POST", "/v1/admin/ca/rotate").Handler(f(schemas, s.RotateClusterCA))
+	t.route(admin, "DELETE", "/v1/admin/locks/{name}").Handler(f(schemas, s.BreakLock))
+	t.route(admin, "GET", "/v1/admin/volumes/{name}/raw").Handler(f(schemas, s.ListVolumeRawRecords))
+	t.route(admin, "PUT", "/v1/admin/volumes/{name}/raw").Handler(f(schemas, s.SetVolumeRawRecord))
+	t.route(admin, "GET", "/v1/admin/tokens").Handler(f(schemas, s.ListAPITokens))
+	t.route(admin, "POST", "/v1/admin/tokens").Handler(f(schemas, s.CreateAPIToken))
+	t.route(admin, "DELETE", "/v1/admin/tokens/{id}").Handler(f(schemas, s.RevokeAPIToken))
+	t.route(readonly, "GET", "/v1/hosts/{id}").Handler(f(schemas, s.GetHost))
+	t.route(admin, "DELETE", "/v1/hosts/{id}").Handler(f(schemas, s.DeleteHost))
+	hostActions := map[string]func(http.ResponseWriter, *http.Request) error{
+		"drain":                  s.DrainHost,
+		"drainProgress":          s.DrainProgress,
+		"schedulableUpdate":      s.UpdateHostSchedulable,
+		"failureDomainUpdate":    s.UpdateHostFailureDomain,
+		"schedulingWeightUpdate": s.UpdateHostSchedulingWeight,
+		"roleUpdate":             s.UpdateHostRole,
+		"resolveConflict":        s.ResolveHostConflict,
+		"maintenanceSchedule":    s.ScheduleMaintenance,
+		"maintenanceCancel":      s.CancelMaintenance,
+		"instancesStop":          s.StopHostInstances,
+		"instancesStart":         s.StartHostInstances,
+	}
+	// the host actions require admin, except these
+	hostActionRoles := map[string]types.APIRole{
+		"drainProgress": readonly,
+	}
+	for name, action := range hostActions {
+		role := admin
+		if r, ok := hostActionRoles[name]; ok {
+			role = r
+		}
+		t.action(role, "/v1/hosts/{id}", name).Handler(f(schemas, action))
+	}
 
 	// Internal API
-	r.Methods("POST").Path("/v1/schedule").Handler(f(schemas, s.Schedule))
+	t.route(internal, "POST", "/v1/schedule").Handler(f(schemas, s.Schedule))
+	t.route(internal, "GET", "/v1/hosts/{id}/reachable").Handler(f(schemas, s.HostReachable))
+	t.route(internal, "GET", "/v1/localinstances").Handler(f(schemas, s.LocalInstances))
 
-	return r
+	return t
 }
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+var (
+	// AuthEnabled requires a token with the role of the route on every
+	// request, except over the unix socket and from the other managers
+	// presenting a certificate of the internal TLS. It takes effect on
+	// Handler().
+	AuthEnabled = false
+)
+
+const (
+	// the routes anyone can call, e.g. the health checks
+	RoutePublic = types.APIRole("public")
+	// the routes only the managers call on each other, the other clients
+	// require an admin token
+	RouteInternal = types.APIRole("internal")
+)
+
+// RouteRole is the role required by a route
+type RouteRole struct {
+	Method string
+	Path   string
+	Action string
+	Role   types.APIRole
+}
+
+// routeTable registers the routes of the router with the role they require
+type routeTable struct {
+	r     *mux.Router
+	roles map[*mux.Route]*RouteRole
+}
+
+func newRouteTable(r *mux.Router) *routeTable {
+	return &routeTable{
+		r:     r,
+		roles: map[*mux.Route]*RouteRole{},
+	}
+}
+
+func (t *routeTable) route(role types.APIRole, method, path string) *mux.Route {
+	route := t.r.Methods(method).Path(path)
+	t.roles[route] = &RouteRole{Method: method, Path: path, Role: role}
+	return route
+}
+
+func (t *routeTable) action(role types.APIRole, path, action string) *mux.Route {
+	route := t.r.Methods("POST").Path(path).Queries("action", action)
+	t.roles[route] = &RouteRole{Method: "POST", Path: path, Action: action, Role: role}
+	return route
+}
+
+type authContextKey int
+
+const authTokenKey authContextKey = 0
+
+// requestToken is the token authenticating the request, nil if there is none
+func requestToken(req *http.Request) *types.APIToken {
+	token, _ := req.Context().Value(authTokenKey).(*types.APIToken)
+	return token
+}
+
+// trustedRequest is true for the requests over the unix socket, guarded by
+// its file permissions, and from the other managers, whose certificate is
+// verified by the internal TLS
+func trustedRequest(req *http.Request) bool {
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && addr.Network() == "unix" {
+		return true
+	}
+	return req.TLS != nil && len(req.TLS.PeerCertificates) > 0
+}
+
+// AuthHandler authorizes the requests with the bearer token, for the role
+// required by the route matched. The tokens scoped to a namespace are
+// refused. A route registered without a role is
+// refused, the requests matching no route are left to the router.
+func AuthHandler(t *routeTable, authenticate func(secret string) (*types.APIToken, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var match mux.RouteMatch
+		if !t.r.Match(req, &match) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		required := t.roles[match.Route]
+		if required == nil {
+			http.Error(rw, "route without role", http.StatusForbidden)
+			return
+		}
+		if required.Role == RoutePublic || trustedRequest(req) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		secret := ""
+		if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+			secret = strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+		}
+		if secret == "" {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(rw, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		token, err := authenticate(secret)
+		if err != nil {
+			logrus.Warnf("unable to authenticate API request: %v", err)
+			http.Error(rw, "unable to authenticate", http.StatusServiceUnavailable)
+			return
+		}
+		if token == nil {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(rw, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		// e.g. written to the store by another version, it must not be
+		// granted more than its namespace
+		if token.Namespace != "" {
+			http.Error(rw, "token "+token.Name+" is scoped to namespace "+token.Namespace+", namespace-scoped tokens are not supported", http.StatusForbidden)
+			return
+		}
+		role := required.Role
+		if role == RouteInternal {
+			role = types.APIRoleAdmin
+		}
+		if !token.Role.Allows(role) {
+			http.Error(rw, "role "+string(token.Role)+" of token "+token.Name+" is not allowed, requires "+string(role), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), authTokenKey, token)))
+	})
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// routeRoles is the role required by every route, a new route must be added
+// here with its role
+var routeRoles = map[string]types.APIRole{
+	"GET /":                      RoutePublic,
+	"GET /v1":                    RoutePublic,
+	"GET /v1/apiversions":        RoutePublic,
+	"GET /v1/apiversions/v1":     RoutePublic,
+	"GET /v1/ready":              RoutePublic,
+	"GET /v1/schemas":            RoutePublic,
+	"GET /v1/schemas/{id}":       RoutePublic,
+	"GET /metrics":               types.APIRoleReadonly,
+	"GET /v1/info":               types.APIRoleReadonly,
+	"GET /v1/settings":           types.APIRoleReadonly,
+	"GET /v1/settingdefinitions": types.APIRoleReadonly,
+	"GET /v1/settings/history":   types.APIRoleReadonly,
+	"GET /v1/settings/{name}":    types.APIRoleReadonly,
+	"PUT /v1/settings":           types.APIRoleAdmin,
+	"PUT /v1/settings/{name}":    types.APIRoleAdmin,
+
+	"POST /v1/settings/rollback/{revision}":     types.APIRoleAdmin,
+	"POST /v1/settings/history/{revision}/keep": types.APIRoleAdmin,
+
+	"GET /v1/volumes":                                           types.APIRoleReadonly,
+	"POST /v1/volumes":                                          types.APIRoleOperator,
+	"GET /v1/volumes/{name}":                                    types.APIRoleReadonly,
+	"DELETE /v1/volumes/{name}":                                 types.APIRoleOperator,
+	"GET /v1/volumes/{name}/events":                             types.APIRoleReadonly,
+	"GET /v1/volumes/{name}/history":                            types.APIRoleReadonly,
+	"POST /v1/volumes/{name}/events/{batch}/keep":               types.APIRoleOperator,
+	"POST /v1/volumes/{name}/history/{seq}/keep":                types.APIRoleOperator,
+	"POST /v1/volumes/{name}/migrate-controller":                types.APIRoleOperator,
+	"GET /v1/volumes/{name}/instances/{instance}/engine-status": types.APIRoleReadonly,
+
+	"POST /v1/volumes/{name}?action=attach":                        types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=detach":                        types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=snapshotPurge":                 types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=snapshotCreate":                types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=snapshotList":                  types.APIRoleReadonly,
+	"POST /v1/volumes/{name}?action=snapshotGet":                   types.APIRoleReadonly,
+	"POST /v1/volumes/{name}?action=snapshotDelete":                types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=snapshotRevert":                types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=snapshotBackup":                types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=recurringUpdate":               types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=bgTaskQueue":                   types.APIRoleReadonly,
+	"POST /v1/volumes/{name}?action=replicaRemove":                 types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=replicaMigrate":                types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=convert":                       types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=rebuildCancel":                 types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=standbyCreate":                 types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=standbyPromote":                types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=replicaDiskUsage":              types.APIRoleReadonly,
+	"POST /v1/volumes/{name}?action=preferredHostUpdate":           types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=autoReattachUpdate":            types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=backupCompressionUpdate":       types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=pinReplicasUpdate":             types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=salvage":                       types.APIRoleAdmin,
+	"POST /v1/volumes/{name}?action=replan":                        types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=abortCreation":                 types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=snapshotCompact":               types.APIRoleOperator,
+	"POST /v1/volumes/{name}?action=engineVersionConstraintUpdate": types.APIRoleOperator,
+
+	"GET /v1/backupvolumes":                                types.APIRoleReadonly,
+	"GET /v1/backupvolumes/{volName}":                      types.APIRoleReadonly,
+	"POST /v1/backupvolumes/{volName}?action=backupList":   types.APIRoleReadonly,
+	"POST /v1/backupvolumes/{volName}?action=backupGet":    types.APIRoleReadonly,
+	"POST /v1/backupvolumes/{volName}?action=backupDelete": types.APIRoleOperator,
+
+	"DELETE /v1/backuptargets/default/volumes/{volName}/backups/{backupName}": types.APIRoleOperator,
+
+	"GET /v1/volumegroups":                               types.APIRoleReadonly,
+	"POST /v1/volumegroups":                              types.APIRoleOperator,
+	"GET /v1/volumegroups/{name}":                        types.APIRoleReadonly,
+	"DELETE /v1/volumegroups/{name}":                     types.APIRoleOperator,
+	"POST /v1/volumegroups/{name}?action=volumeAdd":      types.APIRoleOperator,
+	"POST /v1/volumegroups/{name}?action=volumeRemove":   types.APIRoleOperator,
+	"POST /v1/volumegroups/{name}?action=selectorUpdate": types.APIRoleOperator,
+	"POST /v1/volumegroups/{name}?action=attach":         types.APIRoleOperator,
+	"POST /v1/volumegroups/{name}?action=detach":         types.APIRoleOperator,
+	"POST /v1/volumegroups/{name}?action=snapshotCreate": types.APIRoleOperator,
+	"POST /v1/volumegroups/{name}?action=backup":         types.APIRoleOperator,
+
+	"GET /v1/hosts":             types.APIRoleReadonly,
+	"GET /v1/schedulerstatus":   types.APIRoleReadonly,
+	"GET /v1/consistencyreport": types.APIRoleReadonly,
+
+	"POST /v1/admin/capacity-check":    types.APIRoleAdmin,
+	"GET /v1/admin/reconcile":          types.APIRoleAdmin,
+	"POST /v1/admin/reconcile/pause":   types.APIRoleAdmin,
+	"POST /v1/admin/reconcile/resume":  types.APIRoleAdmin,
+	"GET /v1/admin/events":             types.APIRoleAdmin,
+	"GET /v1/admin/backup-reads":       types.APIRoleAdmin,
+	"GET /v1/admin/slow-operations":    types.APIRoleAdmin,
+	"GET /v1/admin/runners":            types.APIRoleAdmin,
+	"POST /v1/admin/backup-gc":         types.APIRoleAdmin,
+	"POST /v1/admin/smoke-test":        types.APIRoleAdmin,
+	"GET /v1/admin/locks":              types.APIRoleAdmin,
+	"GET /v1/admin/config":             types.APIRoleAdmin,
+	"GET /v1/admin/render/controller":  types.APIRoleAdmin,
+	"GET /v1/admin/render/replica":     types.APIRoleAdmin,
+	"GET /v1/admin/bootstrap":          types.APIRoleAdmin,
+	"GET /v1/admin/writers":            types.APIRoleAdmin,
+	"POST /v1/admin/ca/rotate":         types.APIRoleAdmin,
+	"DELETE /v1/admin/locks/{name}":    types.APIRoleAdmin,
+	"GET /v1/admin/volumes/{name}/raw": types.APIRoleAdmin,
+	"PUT /v1/admin/volumes/{name}/raw": types.APIRoleAdmin,
+	"GET /v1/admin/tokens":             types.APIRoleAdmin,
+	"POST /v1/admin/tokens":            types.APIRoleAdmin,
+	"DELETE /v1/admin/tokens/{id}":     types.APIRoleAdmin,
+
+	"GET /v1/hosts/{id}":                                types.APIRoleReadonly,
+	"DELETE /v1/hosts/{id}":                             types.APIRoleAdmin,
+	"POST /v1/hosts/{id}?action=drain":                  types.APIRoleAdmin,
+	"POST /v1/hosts/{id}?action=drainProgress":          types.APIRoleReadonly,
+	"POST /v1/hosts/{id}?action=schedulableUpdate":      types.APIRoleAdmin,
+	"POST /v1/hosts/{id}?action=failureDomainUpdate":    types.APIRoleAdmin,
+	"POST /v1/hosts/{id}?action=schedulingWeightUpdate": types.APIRoleAdmin,
+	"POST /v1/hosts/{id}?action=roleUpdate":             types.APIRoleAdmin,
+	"POST /v1/hosts/{id}?action=resolveConflict":        types.APIRoleAdmin,
+	"POST /v1/hosts/{id}?action=maintenanceSchedule":    types.APIRoleAdmin,
+	"POST /v1/hosts/{id}?action=maintenanceCancel":      types.APIRoleAdmin,
+	"POST /v1/hosts/{id}?action=instancesStop":          types.APIRoleAdmin,
+	"POST /v1/hosts/{id}?action=instancesStart":         types.APIRoleAdmin,
+
+	"POST /v1/schedule":            RouteInternal,
+	"GET /v1/hosts/{id}/reachable": RouteInternal,
+	"GET /v1/localinstances":       RouteInternal,
+}
+
+func newTestRouter() *routeTable {
+	return newRouter(&Server{fwd: &Fwd{}})
+}
+
+func TestRouteRoles(t *testing.T) {
+	assert := require.New(t)
+
+	table := newTestRouter()
+	registered := map[string]types.APIRole{}
+	assert.Nil(table.r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		assert.Nil(err)
+		role := table.roles[route]
+		assert.NotNil(role, "route %v registered without role", path)
+		key := role.Method + " " + role.Path
+		if role.Action != "" {
+			key += "?action=" + role.Action
+		}
+		assert.Equal(path, role.Path)
+		registered[key] = role.Role
+		return nil
+	}))
+	assert.Equal(routeRoles, registered)
+}
+
+func TestAuthHandler(t *testing.T) {
+	assert := require.New(t)
+
+	tokens := map[string]*types.APIToken{
+		"ro-secret":    {Name: "monitoring", Role: types.APIRoleReadonly},
+		"op-secret":    {Name: "provisioner", Role: types.APIRoleOperator},
+		"admin-secret": {Name: "ops", Role: types.APIRoleAdmin},
+		"ns-secret":    {Name: "team-a", Role: types.APIRoleAdmin, Namespace: "team-a"},
+	}
+	author := ""
+	handler := AuthHandler(newTestRouter(), func(secret string) (*types.APIToken, error) {
+		return tokens[secret], nil
+	}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		author = requestAuthor(req)
+	}))
+
+	serve := func(method, target, secret string, setup func(req *http.Request) *http.Request) int {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = "10.0.0.9:1234"
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		if setup != nil {
+			req = setup(req)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	for _, c := range []struct {
+		method, target, secret string
+		code                   int
+	}{
+		{"GET", "/v1/ready", "", http.StatusOK},
+		{"GET", "/v1/volumes", "", http.StatusUnauthorized},
+		{"GET", "/v1/volumes", "other", http.StatusUnauthorized},
+		{"GET", "/v1/volumes", "ro-secret", http.StatusOK},
+		{"POST", "/v1/volumes", "ro-secret", http.StatusForbidden},
+		{"POST", "/v1/volumes", "op-secret", http.StatusOK},
+		{"POST", "/v1/volumes/vol?action=snapshotList", "ro-secret", http.StatusOK},
+		{"POST", "/v1/volumes/vol?action=attach", "ro-secret", http.StatusForbidden},
+		{"POST", "/v1/volumes/vol?action=attach", "op-secret", http.StatusOK},
+		{"POST", "/v1/volumes/vol?action=salvage", "op-secret", http.StatusForbidden},
+		{"POST", "/v1/volumes/vol?action=salvage", "admin-secret", http.StatusOK},
+		{"PUT", "/v1/settings/engineImage", "op-secret", http.StatusForbidden},
+		{"PUT", "/v1/settings/engineImage", "admin-secret", http.StatusOK},
+		{"GET", "/v1/admin/tokens", "op-secret", http.StatusForbidden},
+		{"POST", "/v1/admin/tokens", "admin-secret", http.StatusOK},
+		// scoping to a namespace is not supported
+		{"GET", "/v1/volumes", "ns-secret", http.StatusForbidden},
+		// left to the router
+		{"GET", "/v1/nothing", "", http.StatusOK},
+		// without a certificate of the internal TLS, only for admins
+		{"GET", "/v1/localinstances", "", http.StatusUnauthorized},
+		{"GET", "/v1/localinstances", "op-secret", http.StatusForbidden},
+		{"GET", "/v1/localinstances", "admin-secret", http.StatusOK},
+	} {
+		assert.Equal(c.code, serve(c.method, c.target, c.secret, nil), "%v %v with %q", c.method, c.target, c.secret)
+	}
+
+	serve("PUT", "/v1/settings/engineImage", "admin-secret", nil)
+	assert.Equal("token ops", author)
+
+	// with internal TLS, a manager presents its certificate
+	overTLS := func(certs ...*x509.Certificate) func(req *http.Request) *http.Request {
+		return func(req *http.Request) *http.Request {
+			req.TLS = &tls.ConnectionState{PeerCertificates: certs}
+			return req
+		}
+	}
+	assert.Equal(http.StatusUnauthorized, serve("GET", "/v1/localinstances", "", overTLS()))
+	assert.Equal(http.StatusForbidden, serve("GET", "/v1/localinstances", "op-secret", overTLS()))
+	assert.Equal(http.StatusOK, serve("GET", "/v1/localinstances", "admin-secret", overTLS()))
+	assert.Equal(http.StatusOK, serve("GET", "/v1/localinstances", "", overTLS(&x509.Certificate{})))
+	assert.Equal(http.StatusOK, serve("PUT", "/v1/settings/engineImage", "", overTLS(&x509.Certificate{})))
+
+	// the unix socket is guarded by its file permissions
+	overSocket := func(req *http.Request) *http.Request {
+		addr := &net.UnixAddr{Name: "/var/run/longhorn/manager.sock", Net: "unix"}
+		return req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, addr))
+	}
+	assert.Equal(http.StatusOK, serve("PUT", "/v1/settings/engineImage", "", overSocket))
+	assert.Equal(http.StatusOK, serve("POST", "/v1/volumes/vol?action=salvage", "", overSocket))
+}
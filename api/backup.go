@@ -1,14 +1,15 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/rancher/go-rancher/api"
 
+	"github.com/rancher/longhorn-manager/backups"
 	"github.com/rancher/longhorn-manager/types"
 )
 
@@ -27,6 +28,9 @@ func (bh *BackupsHandlers) ListVolume(w http.ResponseWriter, req *http.Request)
 	if backupTarget == "" {
 		return errors.New("cannot backup: backupTarget not set")
 	}
+	if backups.IsTargetTemplate(backupTarget) {
+		return errors.Errorf("cannot list backup volumes: backupTarget '%s' differs by volume", backupTarget)
+	}
 
 	backups := bh.man.ManagerBackupOps(backupTarget)
 
@@ -44,13 +48,9 @@ func (bh *BackupsHandlers) GetVolume(w http.ResponseWriter, req *http.Request) e
 
 	volName := mux.Vars(req)["volName"]
 
-	settings, err := bh.man.Settings().GetSettings()
-	if err != nil || settings == nil {
-		return errors.New("cannot backup: unable to read settings")
-	}
-	backupTarget := settings.BackupTarget
-	if backupTarget == "" {
-		return errors.New("cannot backup: backupTarget not set")
+	backupTarget, err := bh.man.VolumeBackupTarget(volName)
+	if err != nil {
+		return err
 	}
 
 	backups := bh.man.ManagerBackupOps(backupTarget)
@@ -67,13 +67,9 @@ func (bh *BackupsHandlers) GetVolume(w http.ResponseWriter, req *http.Request) e
 func (bh *BackupsHandlers) List(w http.ResponseWriter, req *http.Request) error {
 	volName := mux.Vars(req)["volName"]
 
-	settings, err := bh.man.Settings().GetSettings()
-	if err != nil || settings == nil {
-		return errors.New("cannot backup: unable to read settings")
-	}
-	backupTarget := settings.BackupTarget
-	if backupTarget == "" {
-		return errors.New("cannot backup: backupTarget not set")
+	backupTarget, err := bh.man.VolumeBackupTarget(volName)
+	if err != nil {
+		return err
 	}
 
 	backups := bh.man.ManagerBackupOps(backupTarget)
@@ -87,10 +83,6 @@ func (bh *BackupsHandlers) List(w http.ResponseWriter, req *http.Request) error
 	return nil
 }
 
-func backupURL(backupTarget, backupName, volName string) string {
-	return fmt.Sprintf("%s?backup=%s&volume=%s", backupTarget, backupName, volName)
-}
-
 func (bh *BackupsHandlers) Get(w http.ResponseWriter, req *http.Request) error {
 	var input BackupInput
 
@@ -104,19 +96,14 @@ func (bh *BackupsHandlers) Get(w http.ResponseWriter, req *http.Request) error {
 	}
 	volName := mux.Vars(req)["volName"]
 
-	settings, err := bh.man.Settings().GetSettings()
-	if err != nil || settings == nil {
-		return errors.New("cannot backup: unable to read settings")
-	}
-	backupTarget := settings.BackupTarget
-	if backupTarget == "" {
-		return errors.New("cannot backup: backupTarget not set")
+	backupTarget, err := bh.man.VolumeBackupTarget(volName)
+	if err != nil {
+		return err
 	}
 
-	backups := bh.man.ManagerBackupOps(backupTarget)
-
-	url := backupURL(backupTarget, input.Name, volName)
-	backup, err := backups.Get(url)
+	url := backups.URL(backupTarget, input.Name, volName)
+	backupOps := bh.man.ManagerBackupOps(backupTarget)
+	backup, err := backupOps.Get(url)
 	if err != nil {
 		return errors.Wrapf(err, "error getting backup '%s'", url)
 	}
@@ -141,25 +128,27 @@ func (bh *BackupsHandlers) Delete(w http.ResponseWriter, req *http.Request) erro
 	if input.Name == "" {
 		return errors.Errorf("empty backup name is not allowed")
 	}
+	return bh.deleteBackup(w, req, mux.Vars(req)["volName"], input.Name)
+}
 
-	volName := mux.Vars(req)["volName"]
+// DeleteBackup serves DELETE on the backup, with removeVolume=true the last
+// backup of the volume takes the volume directory with it
+func (bh *BackupsHandlers) DeleteBackup(w http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	return bh.deleteBackup(w, req, vars["volName"], vars["backupName"])
+}
 
-	settings, err := bh.man.Settings().GetSettings()
-	if err != nil || settings == nil {
-		return errors.New("cannot backup: unable to read settings")
-	}
-	backupTarget := settings.BackupTarget
-	if backupTarget == "" {
-		return errors.New("cannot backup: backupTarget not set")
+func (bh *BackupsHandlers) deleteBackup(w http.ResponseWriter, req *http.Request, volName, backupName string) error {
+	backupTarget, err := bh.man.VolumeBackupTarget(volName)
+	if err != nil {
+		return err
 	}
 
-	backups := bh.man.ManagerBackupOps(backupTarget)
-
-	url := backupURL(backupTarget, input.Name, volName)
-	if err := backups.Delete(url); err != nil {
-		return errors.Wrapf(err, "error deleting backup '%s'", url)
+	removeVolume, _ := strconv.ParseBool(req.URL.Query().Get("removeVolume"))
+	if err := bh.man.DeleteBackup(backupTarget, volName, backupName, removeVolume); err != nil {
+		return err
 	}
-	logrus.Debugf("success: removed backup '%s'", url)
-	apiContext.Write(&Empty{})
+	logrus.Debugf("success: removed backup '%s'", backups.URL(backupTarget, backupName, volName))
+	api.GetApiContext(req).Write(&Empty{})
 	return nil
 }
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/api"
+	"gopkg.in/yaml.v3"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// bootstrapVolume is a volume of the bootstrap file
+type bootstrapVolume struct {
+	Name             string               `yaml:"name"`
+	Size             string               `yaml:"size"`
+	NumberOfReplicas int                  `yaml:"numberOfReplicas"`
+	Labels           map[string]string    `yaml:"labels"`
+	RecurringJobs    []types.RecurringJob `yaml:"recurringJobs"`
+}
+
+var (
+	bootstrapFields       = []string{"settings", "volumes"}
+	bootstrapVolumeFields = []string{"name", "size", "numberOfReplicas", "labels", "recurringJobs"}
+	bootstrapJobFields    = []string{"name", "cron", "task", "retain"}
+)
+
+// LoadBootstrapFile reads and validates a bootstrap file, YAML or JSON, e.g.
+//
+//	settings:
+//	  backupTarget: s3://backups@us-east-1/
+//	volumes:
+//	- name: db
+//	  size: 10Gi
+//	  numberOfReplicas: 3
+//	  labels: {team: billing}
+//	  recurringJobs:
+//	  - {name: nightly, cron: "0 2 * * *", task: backup, retain: 7}
+//
+// The errors give the line of the file.
+func LoadBootstrapFile(path string) (*types.Bootstrap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read bootstrap file %v", path)
+	}
+	b, err := parseBootstrap(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid bootstrap file %v", path)
+	}
+	sum := sha256.Sum256(data)
+	b.File, b.Digest = path, hex.EncodeToString(sum[:])
+	return b, nil
+}
+
+func parseBootstrap(data []byte) (*types.Bootstrap, error) {
+	b := &types.Bootstrap{
+		Settings:     []*types.BootstrapSetting{},
+		Volumes:      []*types.BootstrapVolume{},
+		ApplySetting: applySetting,
+	}
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return b, nil
+	}
+	root := doc.Content[0]
+	if err := checkMappingFields(root, bootstrapFields); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(root.Content); i += 2 {
+		var err error
+		switch key, value := root.Content[i], root.Content[i+1]; key.Value {
+		case "settings":
+			b.Settings, err = parseBootstrapSettings(value)
+		case "volumes":
+			b.Volumes, err = parseBootstrapVolumes(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func parseBootstrapSettings(node *yaml.Node) ([]*types.BootstrapSetting, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, errors.Errorf("line %v: settings must be a mapping of setting names to values", node.Line)
+	}
+	settings := []*types.BootstrapSetting{}
+	scratch := &types.SettingsInfo{}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		s := &types.BootstrapSetting{Line: key.Line, Name: key.Value}
+		switch value.Kind {
+		case yaml.ScalarNode:
+			s.Value = value.Value
+		case yaml.MappingNode:
+			m := map[string]string{}
+			if err := value.Decode(&m); err != nil {
+				return nil, errors.Wrapf(err, "line %v: setting %v", key.Line, key.Value)
+			}
+			// the map settings take a JSON object of strings
+			encoded, _ := json.Marshal(m)
+			s.Value = string(encoded)
+		default:
+			return nil, errors.Errorf("line %v: setting %v must be a value", value.Line, key.Value)
+		}
+		if err := applySetting(scratch, s.Name, s.Value); err != nil {
+			return nil, errors.Wrapf(err, "line %v", value.Line)
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+func parseBootstrapVolumes(node *yaml.Node) ([]*types.BootstrapVolume, error) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, errors.Errorf("line %v: volumes must be a list", node.Line)
+	}
+	volumes := []*types.BootstrapVolume{}
+	lines := map[string]int{}
+	for _, item := range node.Content {
+		if err := checkMappingFields(item, bootstrapVolumeFields); err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(item.Content); i += 2 {
+			if key, value := item.Content[i], item.Content[i+1]; key.Value == "recurringJobs" && value.Kind == yaml.SequenceNode {
+				for _, job := range value.Content {
+					if err := checkMappingFields(job, bootstrapJobFields); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		var v bootstrapVolume
+		if err := item.Decode(&v); err != nil {
+			return nil, err
+		}
+		if v.Name == "" {
+			return nil, errors.Errorf("line %v: volume without name", item.Line)
+		}
+		if line, ok := lines[v.Name]; ok {
+			return nil, errors.Errorf("line %v: volume %v is already declared on line %v", item.Line, v.Name, line)
+		}
+		lines[v.Name] = item.Line
+		if v.Size == "" {
+			return nil, errors.Errorf("line %v: volume %v without size", item.Line, v.Name)
+		}
+		if v.NumberOfReplicas < 1 {
+			return nil, errors.Errorf("line %v: volume %v needs numberOfReplicas of at least 1", item.Line, v.Name)
+		}
+		volume, err := filterCreateVolumeInput(&Volume{
+			Name:             v.Name,
+			Size:             v.Size,
+			NumberOfReplicas: v.NumberOfReplicas,
+			Labels:           v.Labels,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %v: volume %v", item.Line, v.Name)
+		}
+		for i := range v.RecurringJobs {
+			volume.RecurringJobs = append(volume.RecurringJobs, &v.RecurringJobs[i])
+		}
+		volumes = append(volumes, &types.BootstrapVolume{Line: item.Line, Volume: volume})
+	}
+	return volumes, nil
+}
+
+// checkMappingFields fails if the node isn't a mapping of the fields
+func checkMappingFields(node *yaml.Node, fields []string) error {
+	if node.Kind != yaml.MappingNode {
+		return errors.Errorf("line %v: expecting a mapping of %v", node.Line, strings.Join(fields, ", "))
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		known := false
+		for _, field := range fields {
+			known = known || key.Value == field
+		}
+		if !known {
+			return errors.Errorf("line %v: unknown field %v, expecting one of %v", key.Line, key.Value, strings.Join(fields, ", "))
+		}
+	}
+	return nil
+}
+
+func (s *Server) BootstrapReport(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+
+	report, err := s.man.BootstrapReport()
+	if err != nil {
+		return errors.Wrap(err, "fail to get the bootstrap report")
+	}
+	if report == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	apiContext.Write(toBootstrapReportResource(report))
+	return nil
+}
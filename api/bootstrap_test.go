@@ -0,0 +1,101 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const bootstrapYAML = `settings:
+  backupTarget: s3://backups@us-east-1/
+  snapshotMaxCount: 10
+  instanceEnv:
+    HTTPS_PROXY: http://proxy:3128
+volumes:
+- name: db
+  size: 10Gi
+  numberOfReplicas: 3
+  labels: {team: billing}
+  recurringJobs:
+  - {name: nightly, cron: "0 2 * * *", task: backup, retain: 7}
+- name: logs
+  size: 1Gi
+  numberOfReplicas: 2
+`
+
+func TestLoadBootstrapFile(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "bootstrap")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bootstrap.yaml")
+	assert.Nil(ioutil.WriteFile(path, []byte(bootstrapYAML), 0600))
+
+	b, err := LoadBootstrapFile(path)
+	assert.Nil(err)
+	assert.Equal(path, b.File)
+	assert.Len(b.Digest, 64)
+	assert.Equal([]*types.BootstrapSetting{
+		{Line: 2, Name: "backupTarget", Value: "s3://backups@us-east-1/"},
+		{Line: 3, Name: "snapshotMaxCount", Value: "10"},
+		{Line: 4, Name: "instanceEnv", Value: `{"HTTPS_PROXY":"http://proxy:3128"}`},
+	}, b.Settings)
+	assert.Len(b.Volumes, 2)
+	assert.Equal(7, b.Volumes[0].Line)
+	db := b.Volumes[0].Volume
+	assert.Equal("db", db.Name)
+	assert.Equal(int64(10*1024*1024*1024), db.Size)
+	assert.Equal(3, db.NumberOfReplicas)
+	assert.Equal(map[string]string{"team": "billing"}, db.Labels)
+	assert.Equal([]*types.RecurringJob{{Name: "nightly", Cron: "0 2 * * *", Task: "backup", Retain: 7}}, db.RecurringJobs)
+	assert.Equal(13, b.Volumes[1].Line)
+	assert.Equal("logs", b.Volumes[1].Volume.Name)
+
+	si := &types.SettingsInfo{}
+	for _, s := range b.Settings {
+		assert.Nil(b.ApplySetting(si, s.Name, s.Value))
+	}
+	assert.Equal(10, si.SnapshotMaxCount)
+
+	// JSON is YAML
+	b, err = parseBootstrap([]byte(`{"volumes": [{"name": "db", "size": "1Gi", "numberOfReplicas": 1}]}`))
+	assert.Nil(err)
+	assert.Len(b.Volumes, 1)
+
+	b, err = parseBootstrap([]byte(""))
+	assert.Nil(err)
+	assert.Len(b.Settings, 0)
+	assert.Len(b.Volumes, 0)
+
+	_, err = LoadBootstrapFile(filepath.Join(dir, "missing.yaml"))
+	assert.NotNil(err)
+}
+
+func TestParseBootstrapErrors(t *testing.T) {
+	assert := require.New(t)
+
+	for doc, msg := range map[string]string{
+		"volume:\n- name: db\n":                                        "line 1: unknown field volume",
+		"settings:\n  snapshotMaxCount: -1\n":                          "line 2: invalid value -1 for setting snapshotMaxCount",
+		"settings:\n  noSuchSetting: 1\n":                              "line 2: invalid setting name noSuchSetting",
+		"settings:\n- backupTarget\n":                                  "line 2: settings must be a mapping",
+		"volumes:\n- name: db\n  size: 1Gi\n  replicas: 2\n":           "line 4: unknown field replicas",
+		"volumes:\n- name: db\n  size: 1Gi\n  numberOfReplicas: two\n": "line 4: cannot unmarshal",
+		"volumes:\n- name: db\n  size: 1Gi\n":                          "line 2: volume db needs numberOfReplicas",
+		"volumes:\n- size: 1Gi\n  numberOfReplicas: 1\n":               "line 2: volume without name",
+		"volumes:\n- name: db\n  size: lots\n  numberOfReplicas: 1\n":  "line 2: volume db",
+		"volumes:\n- name: db\n  size: 1Gi\n  numberOfReplicas: 1\n  recurringJobs:\n  - {name: a, schedule: x}\n":   "line 6: unknown field schedule",
+		"volumes:\n- name: db\n  size: 1Gi\n  numberOfReplicas: 1\n- name: db\n  size: 1Gi\n  numberOfReplicas: 1\n": "line 5: volume db is already declared on line 2",
+		"volumes: [\n": "yaml: line 1",
+	} {
+		_, err := parseBootstrap([]byte(doc))
+		assert.NotNil(err, doc)
+		assert.Contains(err.Error(), msg, doc)
+	}
+}
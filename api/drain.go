@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+type ReadyOutput struct {
+	Ready bool `json:"ready"`
+}
+
+// Drain flips the readiness to not ready, so the load balancers stop sending
+// requests, and asks the clients to close their connections after the
+// requests in flight.
+func (s *Server) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+func (s *Server) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) != 0
+}
+
+// Ready answers 503 Service Unavailable once the server is draining
+func (s *Server) Ready(rw http.ResponseWriter, req *http.Request) error {
+	ready := !s.isDraining()
+	rw.Header().Set("Content-Type", "application/json")
+	if !ready {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(rw).Encode(ReadyOutput{Ready: ready})
+	return nil
+}
+
+// DrainHandler closes the connection after each response once the server is
+// draining, so the clients reconnect to another manager
+func DrainHandler(s *Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if s.isDraining() {
+			rw.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrain(t *testing.T) {
+	assert := require.New(t)
+
+	s := &Server{}
+	handler := DrainHandler(s, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		s.Ready(rw, req)
+	}))
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/v1/ready", nil))
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.JSONEq(`{"ready":true}`, rw.Body.String())
+	assert.Equal("", rw.Header().Get("Connection"))
+
+	s.Drain()
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/v1/ready", nil))
+	assert.Equal(http.StatusServiceUnavailable, rw.Code)
+	assert.JSONEq(`{"ready":false}`, rw.Body.String())
+	assert.Equal("close", rw.Header().Get("Connection"))
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// stateKey maps a GET request to the key of the resource in the state store,
+// and tells if the request is for a list. The responses with data from
+// elsewhere than the store have no key: the hosts, with their clock skew
+// and details, and the volumes listed by the containers of an engine image
+// or with the hosts of their instances.
+func stateKey(req *http.Request) (key string, list bool, ok bool) {
+	if req.Method != "GET" {
+		return "", false, false
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" {
+		return "", false, false
+	}
+	switch parts[1] {
+	case "volumes":
+		switch len(parts) {
+		case 2:
+			if req.URL.Query().Get("engineImage") != "" || volumeDetail(req) {
+				return "", false, false
+			}
+			return "volumes", true, true
+		case 3:
+			return "volumes/" + parts[2], false, true
+		}
+	case "settings":
+		// all the settings are kept in one key
+		switch len(parts) {
+		case 2:
+			return "settings", true, true
+		case 3:
+			return "settings", false, true
+		}
+	}
+	return "", false, false
+}
+
+// ETagHandler tags GET responses of the resources in the state store with
+// the revision of the state, and answers If-None-Match with 304 Not Modified
+// if the state hasn't changed. Lists get weak ETags, since the order of the
+// elements isn't stable.
+func ETagHandler(rev types.StateRevisioner, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		key, list, ok := stateKey(req)
+		if !ok {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		revision, err := rev.StateRevision(key)
+		if err != nil {
+			logrus.Warnf("%v", errors.Wrapf(err, "unable to get ETag for %v", req.URL.Path))
+			next.ServeHTTP(rw, req)
+			return
+		}
+		etag := fmt.Sprintf("%q", revision)
+		if list {
+			etag = "W/" + etag
+		}
+		if etagMatch(req.Header.Get("If-None-Match"), etag) {
+			setETag(rw, etag)
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		next.ServeHTTP(&etagWriter{ResponseWriter: rw, etag: etag}, req)
+	})
+}
+
+// etagMatch uses the weak comparison required for If-None-Match
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func setETag(rw http.ResponseWriter, etag string) {
+	rw.Header().Set("ETag", etag)
+	rw.Header().Set("Cache-Control", "no-cache")
+}
+
+// etagWriter only tags successful responses
+type etagWriter struct {
+	http.ResponseWriter
+
+	etag        string
+	wroteHeader bool
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if code == http.StatusOK {
+			setETag(w.ResponseWriter, w.etag)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
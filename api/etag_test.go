@@ -0,0 +1,177 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRevisioner struct {
+	sync.Mutex
+
+	revisions  map[string]string
+	staleSince time.Time
+}
+
+func (f *fakeRevisioner) StateRevision(key string) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.revisions[key], nil
+}
+
+func (f *fakeRevisioner) StateStaleSince() time.Time {
+	f.Lock()
+	defer f.Unlock()
+	return f.staleSince
+}
+
+func (f *fakeRevisioner) set(key, revision string) {
+	f.Lock()
+	defer f.Unlock()
+	f.revisions[key] = revision
+}
+
+func newETagTestHandler(rev *fakeRevisioner) (http.Handler, *int) {
+	served := 0
+	return ETagHandler(rev, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		served++
+		if req.URL.Path == "/v1/volumes/missing" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Write([]byte(`{"name":"vol"}`))
+	})), &served
+}
+
+func doETagRequest(h http.Handler, method, path, ifNoneMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestETagNotModified(t *testing.T) {
+	assert := require.New(t)
+
+	rev := &fakeRevisioner{revisions: map[string]string{"volumes/vol": "1"}}
+	h, served := newETagTestHandler(rev)
+
+	rw := doETagRequest(h, "GET", "/v1/volumes/vol", "")
+	assert.Equal(http.StatusOK, rw.Code)
+	etag := rw.Header().Get("ETag")
+	assert.Equal(`"1"`, etag)
+	assert.Equal(1, *served)
+
+	rw = doETagRequest(h, "GET", "/v1/volumes/vol", etag)
+	assert.Equal(http.StatusNotModified, rw.Code)
+	assert.Equal(etag, rw.Header().Get("ETag"))
+	assert.Empty(rw.Body.Bytes())
+	assert.Equal(1, *served)
+
+	rw = doETagRequest(h, "GET", "/v1/volumes/vol", `"0", `+etag)
+	assert.Equal(http.StatusNotModified, rw.Code)
+
+	rw = doETagRequest(h, "GET", "/v1/volumes/vol", "*")
+	assert.Equal(http.StatusNotModified, rw.Code)
+	assert.Equal(1, *served)
+}
+
+func TestETagChangedAfterWrite(t *testing.T) {
+	assert := require.New(t)
+
+	rev := &fakeRevisioner{revisions: map[string]string{"volumes/vol": "1"}}
+	h, served := newETagTestHandler(rev)
+
+	rw := doETagRequest(h, "GET", "/v1/volumes/vol", "")
+	etag := rw.Header().Get("ETag")
+
+	rev.set("volumes/vol", "2")
+
+	rw = doETagRequest(h, "GET", "/v1/volumes/vol", etag)
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Equal(`"2"`, rw.Header().Get("ETag"))
+	assert.NotEmpty(rw.Body.Bytes())
+	assert.Equal(2, *served)
+}
+
+func TestETagWeakList(t *testing.T) {
+	assert := require.New(t)
+
+	rev := &fakeRevisioner{revisions: map[string]string{"volumes": "1"}}
+	h, served := newETagTestHandler(rev)
+
+	rw := doETagRequest(h, "GET", "/v1/volumes", "")
+	assert.Equal(http.StatusOK, rw.Code)
+	etag := rw.Header().Get("ETag")
+	assert.Equal(`W/"1"`, etag)
+
+	rw = doETagRequest(h, "GET", "/v1/volumes", etag)
+	assert.Equal(http.StatusNotModified, rw.Code)
+
+	// If-None-Match uses weak comparison
+	rw = doETagRequest(h, "GET", "/v1/volumes", `"1"`)
+	assert.Equal(http.StatusNotModified, rw.Code)
+	assert.Equal(1, *served)
+
+	// same volumes in the list, but one of them was updated
+	rev.set("volumes", "2")
+
+	rw = doETagRequest(h, "GET", "/v1/volumes", etag)
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Equal(`W/"2"`, rw.Header().Get("ETag"))
+	assert.Equal(2, *served)
+}
+
+func TestETagSkipped(t *testing.T) {
+	assert := require.New(t)
+
+	rev := &fakeRevisioner{revisions: map[string]string{}}
+	h, served := newETagTestHandler(rev)
+
+	rw := doETagRequest(h, "GET", "/v1/volumes/missing", "")
+	assert.Equal(http.StatusNotFound, rw.Code)
+	assert.Empty(rw.Header().Get("ETag"))
+
+	rw = doETagRequest(h, "POST", "/v1/volumes/vol", `""`)
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Empty(rw.Header().Get("ETag"))
+
+	rw = doETagRequest(h, "GET", "/v1/backupvolumes", `""`)
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Empty(rw.Header().Get("ETag"))
+	assert.Equal(3, *served)
+}
+
+func TestETagSkippedBeyondStore(t *testing.T) {
+	assert := require.New(t)
+
+	rev := &fakeRevisioner{revisions: map[string]string{
+		"volumes": "1", "hosts": "1", "hosts/host-1": "1",
+	}}
+	h, served := newETagTestHandler(rev)
+
+	// the clock skew, details and containers aren't in the revisions, an
+	// unchanged revision doesn't mean an unchanged response
+	for _, path := range []string{
+		"/v1/hosts",
+		"/v1/hosts/host-1",
+		"/v1/hosts?detail=false",
+		"/v1/volumes?engineImage=rancher/longhorn:v1",
+		"/v1/volumes?detail=true",
+	} {
+		rw := doETagRequest(h, "GET", path, `"1"`)
+		assert.Equal(http.StatusOK, rw.Code, path)
+		assert.Empty(rw.Header().Get("ETag"), path)
+	}
+	assert.Equal(5, *served)
+
+	rw := doETagRequest(h, "GET", "/v1/volumes?detail=false", `"1"`)
+	assert.Equal(http.StatusNotModified, rw.Code)
+}
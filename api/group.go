@@ -0,0 +1,183 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/api"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func (s *Server) ListVolumeGroups(rw http.ResponseWriter, req *http.Request) error {
+	groups, err := s.man.ListVolumeGroups()
+	if err != nil {
+		return errors.Wrap(err, "fail to list volume groups")
+	}
+	api.GetApiContext(req).Write(toVolumeGroupCollection(groups))
+	return nil
+}
+
+func (s *Server) GetVolumeGroup(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	name := mux.Vars(req)["name"]
+
+	group, err := s.man.GetVolumeGroup(name)
+	if err != nil {
+		return errors.Wrapf(err, "fail to get volume group '%s'", name)
+	}
+	if group == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	status, err := s.man.VolumeGroupStatus(name)
+	if err != nil {
+		return errors.Wrapf(err, "fail to get status of volume group '%s'", name)
+	}
+	apiContext.Write(toVolumeGroupResource(group, status))
+	return nil
+}
+
+func (s *Server) CreateVolumeGroup(rw http.ResponseWriter, req *http.Request) error {
+	var input VolumeGroup
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrap(err, "error read volumeGroup")
+	}
+
+	group, err := s.man.CreateVolumeGroup(&types.VolumeGroup{
+		Name:     input.Name,
+		Volumes:  input.Volumes,
+		Selector: input.Selector,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to create volume group")
+	}
+	apiContext.Write(toVolumeGroupResource(group, nil))
+	return nil
+}
+
+// DeleteVolumeGroup deletes the group only, unless deleteVolumes is set and
+// confirm is the name of the group
+func (s *Server) DeleteVolumeGroup(rw http.ResponseWriter, req *http.Request) error {
+	name := mux.Vars(req)["name"]
+
+	deleteVolumes, _ := strconv.ParseBool(req.URL.Query().Get("deleteVolumes"))
+	results, err := s.man.DeleteVolumeGroup(name, deleteVolumes, req.URL.Query().Get("confirm"))
+	if err != nil {
+		return errors.Wrap(err, "unable to delete volume group")
+	}
+	if deleteVolumes {
+		api.GetApiContext(req).Write(toVolumeGroupOperation(name, "", results))
+	}
+	return nil
+}
+
+func (s *Server) AddVolumeGroupVolumes(rw http.ResponseWriter, req *http.Request) error {
+	return s.updateVolumeGroupVolumes(rw, req, true)
+}
+
+func (s *Server) RemoveVolumeGroupVolumes(rw http.ResponseWriter, req *http.Request) error {
+	return s.updateVolumeGroupVolumes(rw, req, false)
+}
+
+func (s *Server) updateVolumeGroupVolumes(rw http.ResponseWriter, req *http.Request, add bool) error {
+	var input VolumeGroupVolumesInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrap(err, "error read volumeGroupVolumesInput")
+	}
+	name := mux.Vars(req)["name"]
+
+	var err error
+	if add {
+		err = s.man.UpdateVolumeGroupVolumes(name, input.Volumes, nil)
+	} else {
+		err = s.man.UpdateVolumeGroupVolumes(name, nil, input.Volumes)
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to update volumes of volume group")
+	}
+	return s.GetVolumeGroup(rw, req)
+}
+
+func (s *Server) UpdateVolumeGroupSelector(rw http.ResponseWriter, req *http.Request) error {
+	var input VolumeGroupSelectorInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrap(err, "error read volumeGroupSelectorInput")
+	}
+	name := mux.Vars(req)["name"]
+
+	if err := s.man.UpdateVolumeGroupSelector(name, input.Selector); err != nil {
+		return errors.Wrap(err, "unable to update selector of volume group")
+	}
+	return s.GetVolumeGroup(rw, req)
+}
+
+func (s *Server) AttachVolumeGroup(rw http.ResponseWriter, req *http.Request) error {
+	var input VolumeGroupAttachInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrap(err, "error read volumeGroupAttachInput")
+	}
+	name := mux.Vars(req)["name"]
+
+	results, err := s.man.AttachVolumeGroup(name, input.HostID, input.Hosts)
+	if err != nil {
+		return errors.Wrap(err, "unable to attach volume group")
+	}
+	apiContext.Write(toVolumeGroupOperation(name, "", results))
+	return nil
+}
+
+func (s *Server) DetachVolumeGroup(rw http.ResponseWriter, req *http.Request) error {
+	name := mux.Vars(req)["name"]
+
+	results, err := s.man.DetachVolumeGroup(name)
+	if err != nil {
+		return errors.Wrap(err, "unable to detach volume group")
+	}
+	api.GetApiContext(req).Write(toVolumeGroupOperation(name, "", results))
+	return nil
+}
+
+func (s *Server) SnapshotVolumeGroup(rw http.ResponseWriter, req *http.Request) error {
+	var input SnapshotInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrap(err, "error read snapshotInput")
+	}
+	name := mux.Vars(req)["name"]
+
+	snapshot, results, err := s.man.SnapshotVolumeGroup(name, input.Name)
+	if err != nil {
+		return errors.Wrap(err, "unable to snapshot volume group")
+	}
+	apiContext.Write(toVolumeGroupOperation(name, snapshot, results))
+	return nil
+}
+
+func (s *Server) BackupVolumeGroup(rw http.ResponseWriter, req *http.Request) error {
+	var input SnapshotInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrap(err, "error read snapshotInput")
+	}
+	name := mux.Vars(req)["name"]
+
+	snapshot, results, err := s.man.BackupVolumeGroup(name, input.Name)
+	if err != nil {
+		return errors.Wrap(err, "unable to backup volume group")
+	}
+	apiContext.Write(toVolumeGroupOperation(name, snapshot, results))
+	return nil
+}
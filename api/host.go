@@ -2,10 +2,15 @@ package api
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/rancher/go-rancher/api"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
 )
 
 func (s *Server) ListHost(rw http.ResponseWriter, req *http.Request) error {
@@ -15,6 +20,11 @@ func (s *Server) ListHost(rw http.ResponseWriter, req *http.Request) error {
 	if err != nil {
 		return errors.Wrap(err, "fail to list host")
 	}
+	if hostDetail(req) {
+		if err := s.man.HostDetails(hosts); err != nil {
+			return errors.Wrap(err, "fail to get host details")
+		}
+	}
 	apiContext.Write(toHostCollection(hosts))
 	return nil
 }
@@ -27,6 +37,220 @@ func (s *Server) GetHost(rw http.ResponseWriter, req *http.Request) error {
 	if err != nil {
 		return errors.Wrap(err, "fail to get host")
 	}
+	if host != nil && hostDetail(req) {
+		if err := s.man.HostDetails(map[string]*types.HostInfo{id: host}); err != nil {
+			return errors.Wrap(err, "fail to get host details")
+		}
+	}
 	apiContext.Write(toHostResource(host))
 	return nil
 }
+
+// hostDetail is false with ?detail=false, for the payload without the
+// computed fields
+func hostDetail(req *http.Request) bool {
+	detail, err := strconv.ParseBool(req.URL.Query().Get("detail"))
+	return err != nil || detail
+}
+
+func (s *Server) DeleteHost(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["id"]
+
+	force, _ := strconv.ParseBool(req.URL.Query().Get("force"))
+	if err := s.man.DeleteHost(id, force); err != nil {
+		return errors.Wrap(err, "fail to delete host")
+	}
+	return nil
+}
+
+func (s *Server) DrainHost(rw http.ResponseWriter, req *http.Request) error {
+	var input DrainInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read drainInput")
+	}
+	id := mux.Vars(req)["id"]
+
+	var deadline time.Duration
+	if input.Deadline != "" {
+		var err error
+		if deadline, err = time.ParseDuration(input.Deadline); err != nil {
+			return errors.Wrapf(err, "error parsing deadline '%s'", input.Deadline)
+		}
+	}
+	progress, err := s.man.DrainHost(id, deadline)
+	if err != nil {
+		return errors.Wrap(err, "fail to drain host")
+	}
+	apiContext.Write(toDrainProgressResource(progress))
+	return nil
+}
+
+func (s *Server) DrainProgress(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+
+	progress, err := s.man.GetDrainProgress(id)
+	if err != nil {
+		return errors.Wrap(err, "fail to get drain progress")
+	}
+	if progress == nil {
+		return errors.Errorf("host %v was never drained", id)
+	}
+	apiContext.Write(toDrainProgressResource(progress))
+	return nil
+}
+
+func (s *Server) UpdateHostSchedulable(rw http.ResponseWriter, req *http.Request) error {
+	var input SchedulableInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read schedulableInput")
+	}
+	id := mux.Vars(req)["id"]
+
+	if err := s.man.UpdateHostSchedulable(id, input.Schedulable); err != nil {
+		return errors.Wrap(err, "fail to update host")
+	}
+	return s.GetHost(rw, req)
+}
+
+func (s *Server) UpdateHostFailureDomain(rw http.ResponseWriter, req *http.Request) error {
+	var input FailureDomainInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read failureDomainInput")
+	}
+	id := mux.Vars(req)["id"]
+
+	if err := s.man.UpdateHostFailureDomain(id, input.FailureDomain); err != nil {
+		return errors.Wrap(err, "fail to update host")
+	}
+	return s.GetHost(rw, req)
+}
+
+func (s *Server) UpdateHostSchedulingWeight(rw http.ResponseWriter, req *http.Request) error {
+	var input SchedulingWeightInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read schedulingWeightInput")
+	}
+	id := mux.Vars(req)["id"]
+
+	if err := s.man.UpdateHostSchedulingWeight(id, input.SchedulingWeight); err != nil {
+		return errors.Wrap(err, "fail to update host")
+	}
+	return s.GetHost(rw, req)
+}
+
+func (s *Server) UpdateHostRole(rw http.ResponseWriter, req *http.Request) error {
+	var input HostRoleInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read hostRoleInput")
+	}
+	id := mux.Vars(req)["id"]
+
+	if err := s.man.UpdateHostRole(id, types.HostRole(input.Role)); err != nil {
+		return errors.Wrap(err, "fail to update host")
+	}
+	return s.GetHost(rw, req)
+}
+
+func (s *Server) ResolveHostConflict(rw http.ResponseWriter, req *http.Request) error {
+	var input HostConflictInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read hostConflictInput")
+	}
+	id := mux.Vars(req)["id"]
+
+	if err := s.man.ResolveHostConflict(id, input.Nonce); err != nil {
+		return errors.Wrap(err, "fail to resolve host conflict")
+	}
+	return s.GetHost(rw, req)
+}
+
+func (s *Server) CapacityCheck(rw http.ResponseWriter, req *http.Request) error {
+	var input CapacityCheckInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read capacityCheckInput")
+	}
+	size, err := util.ConvertSize(input.Size)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing size %v", input.Size)
+	}
+
+	result, err := s.man.CapacityCheck(&types.CapacityCheck{
+		Count:                      input.Count,
+		Size:                       size,
+		Replicas:                   input.Replicas,
+		NodeSelector:               input.NodeSelector,
+		OverProvisioningPercentage: input.OverProvisioningPercentage,
+		MaxReplicasPerHost:         input.MaxReplicasPerHost,
+	})
+	if err != nil {
+		return errors.Wrap(err, "fail to check capacity")
+	}
+	apiContext.Write(toCapacityCheckResultResource(result))
+	return nil
+}
+
+func (s *Server) ScheduleMaintenance(rw http.ResponseWriter, req *http.Request) error {
+	var input MaintenanceInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read maintenanceInput")
+	}
+	id := mux.Vars(req)["id"]
+
+	duration, err := time.ParseDuration(input.Duration)
+	if err != nil {
+		return errors.Wrapf(err, "invalid maintenance duration %q", input.Duration)
+	}
+	if _, err := s.man.ScheduleMaintenance(id, &types.MaintenanceWindow{
+		Start:    input.Start,
+		Duration: duration,
+		Policy:   types.MaintenancePolicy(input.Policy),
+		Restore:  input.Restore,
+	}); err != nil {
+		return errors.Wrap(err, "fail to schedule host maintenance")
+	}
+	return s.GetHost(rw, req)
+}
+
+func (s *Server) CancelMaintenance(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["id"]
+
+	if err := s.man.CancelMaintenance(id); err != nil {
+		return errors.Wrap(err, "fail to cancel host maintenance")
+	}
+	return s.GetHost(rw, req)
+}
+
+func (s *Server) StopHostInstances(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["id"]
+
+	if err := s.man.StopAllInstancesOnHost(id); err != nil {
+		return errors.Wrap(err, "fail to stop host instances")
+	}
+	return s.GetHost(rw, req)
+}
+
+func (s *Server) StartHostInstances(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["id"]
+
+	if err := s.man.StartAllInstancesOnHost(id); err != nil {
+		return errors.Wrap(err, "fail to start host instances")
+	}
+	return s.GetHost(rw, req)
+}
@@ -15,12 +15,46 @@ import (
 
 type HostIDFunc func(req *http.Request) (string, error)
 
-func HostIDFromAttachReq(req *http.Request) (string, error) {
-	attachInput := AttachInput{}
-	if err := json.NewDecoder(req.Body).Decode(&attachInput); err != nil {
-		return "", errors.Wrap(err, "error parsing request body")
+// HostIDFromAttachReq uses the first available of the preferred hosts from
+// the request, then the host from the request, falling back to the volume's
+// preferred host. A pending rehome overrides the requested host.
+func HostIDFromAttachReq(man types.VolumeManager) func(req *http.Request) (string, error) {
+	return func(req *http.Request) (string, error) {
+		attachInput := AttachInput{}
+		if err := json.NewDecoder(req.Body).Decode(&attachInput); err != nil {
+			return "", errors.Wrap(err, "error parsing request body")
+		}
+		name := mux.Vars(req)["name"]
+		volume, err := man.Get(name)
+		if err != nil {
+			return "", errors.Wrapf(err, "error getting volume '%s'", name)
+		}
+		if len(attachInput.PreferredHostIDs) != 0 && volume != nil && volume.Mode != types.VolumeModeLocal {
+			hostID, err := man.PickAttachHost(name, attachInput.PreferredHostIDs)
+			if err != nil {
+				return "", errors.Wrapf(err, "error picking the host to attach volume '%s'", name)
+			}
+			if hostID != "" {
+				return hostID, nil
+			}
+		}
+		if volume == nil || volume.PreferredHostID == "" {
+			return attachInput.HostID, nil
+		}
+		if volume.Mode == types.VolumeModeLocal {
+			return volume.PreferredHostID, nil
+		}
+		if attachInput.HostID != "" && !volume.RehomePending {
+			return attachInput.HostID, nil
+		}
+		host, err := man.GetHost(volume.PreferredHostID)
+		if err != nil || host == nil {
+			logrus.Warnf("preferred host %v of volume '%s' unavailable, attaching to %v",
+				volume.PreferredHostID, name, attachInput.HostID)
+			return attachInput.HostID, nil
+		}
+		return volume.PreferredHostID, nil
 	}
-	return attachInput.HostID, nil
 }
 
 func HostIDFromVolume(man types.VolumeManager) func(req *http.Request) (string, error) {
@@ -37,6 +71,63 @@ func HostIDFromVolume(man types.VolumeManager) func(req *http.Request) (string,
 	}
 }
 
+func HostIDFromReplicaReq(man types.VolumeManager) func(req *http.Request) (string, error) {
+	return func(req *http.Request) (string, error) {
+		input := ReplicaInput{}
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			return "", errors.Wrap(err, "error parsing request body")
+		}
+		name := mux.Vars(req)["name"]
+		volume, err := man.Get(name)
+		if err != nil {
+			return "", errors.Wrapf(err, "error getting volume '%s'", name)
+		}
+		if volume == nil || volume.Replicas[input.Name] == nil {
+			return "", nil
+		}
+		return volume.Replicas[input.Name].HostID, nil
+	}
+}
+
+// HostIDFromMigrateReq uses the target host of the controller migration
+func HostIDFromMigrateReq(req *http.Request) (string, error) {
+	input := MigrateControllerInput{}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		return "", errors.Wrap(err, "error parsing request body")
+	}
+	if input.HostID == "" {
+		return "", errors.New("target host of the controller migration required")
+	}
+	return input.HostID, nil
+}
+
+func HostIDFromInstance(man types.VolumeManager) func(req *http.Request) (string, error) {
+	return func(req *http.Request) (string, error) {
+		name := mux.Vars(req)["name"]
+		instance := mux.Vars(req)["instance"]
+		volume, err := man.Get(name)
+		if err != nil {
+			return "", errors.Wrapf(err, "error getting volume '%s'", name)
+		}
+		if volume == nil {
+			return "", nil
+		}
+		if volume.Controller != nil && volume.Controller.Name == instance {
+			return volume.Controller.HostID, nil
+		}
+		if replica := volume.Replicas[instance]; replica != nil {
+			return replica.HostID, nil
+		}
+		return "", nil
+	}
+}
+
+// HostIDFromQuery uses the host of the "host" query parameter, the current
+// one if empty
+func HostIDFromQuery(req *http.Request) (string, error) {
+	return req.URL.Query().Get("host"), nil
+}
+
 type Fwd struct {
 	sl    types.ServiceLocator
 	proxy http.Handler
@@ -56,7 +147,7 @@ func (f *Fwd) Handler(getHostID HostIDFunc, h HandleFuncWithError) HandleFuncWit
 			if targetHost != req.Host {
 				req.Host = targetHost
 				req.URL.Host = targetHost
-				req.URL.Scheme = "http"
+				req.URL.Scheme = util.ManagerScheme
 				logrus.Debugf("Forwarding request to %v", targetHost)
 				f.proxy.ServeHTTP(w, req)
 				return nil
@@ -66,6 +157,11 @@ func (f *Fwd) Handler(getHostID HostIDFunc, h HandleFuncWithError) HandleFuncWit
 	}
 }
 
+// Proxy forwards the requests to the other managers with
+// util.ManagerTransport as of now
 func Proxy() http.Handler {
-	return &httputil.ReverseProxy{Director: func(r *http.Request) {}}
+	return &httputil.ReverseProxy{
+		Director:  func(r *http.Request) {},
+		Transport: util.ManagerTransport,
+	}
 }
@@ -3,8 +3,10 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/rancher/go-rancher/api"
 
@@ -16,10 +18,18 @@ type ScheduleInput struct {
 	Item types.ScheduleItem
 }
 
+type HostReachableOutput struct {
+	Reachable bool `json:"reachable"`
+}
+
 type ScheduleOutput struct {
 	Instance types.InstanceInfo
 }
 
+type LocalInstancesOutput struct {
+	Instances []*types.LocalInstance `json:"instances"`
+}
+
 func (s *Server) Schedule(rw http.ResponseWriter, req *http.Request) error {
 	var input ScheduleInput
 
@@ -40,3 +50,231 @@ func (s *Server) Schedule(rw http.ResponseWriter, req *http.Request) error {
 	json.NewEncoder(rw).Encode(output)
 	return nil
 }
+
+// HostReachable is asked by another host before fencing the host, as a
+// witness of the partition
+func (s *Server) HostReachable(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["id"]
+
+	reachable, err := s.man.ProbeHost(id)
+	if err != nil {
+		return errors.Wrapf(err, "fail to probe host %v", id)
+	}
+	json.NewEncoder(rw).Encode(HostReachableOutput{Reachable: reachable})
+	return nil
+}
+
+func (s *Server) SchedulerStatus(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+
+	status, err := s.man.SchedulerStatus()
+	if err != nil {
+		return errors.Wrap(err, "fail to get scheduler status")
+	}
+	apiContext.Write(toSchedulerStatusResource(status))
+	return nil
+}
+
+// ReconcileStatus, PauseReconcile and ResumeReconcile apply to the manager
+// serving the request only
+func (s *Server) ReconcileStatus(rw http.ResponseWriter, req *http.Request) error {
+	api.GetApiContext(req).Write(toReconcileStatusResource(s.man.IsReconcilePaused()))
+	return nil
+}
+
+func (s *Server) PauseReconcile(rw http.ResponseWriter, req *http.Request) error {
+	s.man.PauseReconcile()
+	return s.ReconcileStatus(rw, req)
+}
+
+func (s *Server) ResumeReconcile(rw http.ResponseWriter, req *http.Request) error {
+	s.man.ResumeReconcile()
+	return s.ReconcileStatus(rw, req)
+}
+
+// EventRecorderStatus reports the events buffered by the manager serving the
+// request
+func (s *Server) EventRecorderStatus(rw http.ResponseWriter, req *http.Request) error {
+	api.GetApiContext(req).Write(toEventRecorderStatusResource(s.man.EventRecorderStatus()))
+	return nil
+}
+
+// BackupReadStats counts the backups started by the manager serving the
+// request, by where they read the data from
+func (s *Server) BackupReadStats(rw http.ResponseWriter, req *http.Request) error {
+	api.GetApiContext(req).Write(toBackupReadStatsResource(s.man.BackupReadStats()))
+	return nil
+}
+
+// GarbageCollectBackupTarget removes the blocks of the backup target no
+// backup references
+func (s *Server) GarbageCollectBackupTarget(rw http.ResponseWriter, req *http.Request) error {
+	var input BackupGCInput
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrap(err, "error read backupGCInput")
+	}
+	result, err := s.man.GarbageCollectBackupTarget(input.BackupTarget)
+	if err != nil {
+		return err
+	}
+	apiContext.Write(toGCResultResource(result))
+	return nil
+}
+
+// ListSlowOperations lists the latest slow operations of the manager serving
+// the request with their traces, the latest first
+func (s *Server) ListSlowOperations(rw http.ResponseWriter, req *http.Request) error {
+	api.GetApiContext(req).Write(toSlowOperationCollection(s.man.SlowOperations()))
+	return nil
+}
+
+// ListRunners reports the background loops of the manager serving the
+// request, in the order they were started
+func (s *Server) ListRunners(rw http.ResponseWriter, req *http.Request) error {
+	api.GetApiContext(req).Write(toRunnerStatusCollection(s.man.RunnerStatus()))
+	return nil
+}
+
+// SmokeTest runs the smoke test on the host of the manager serving the
+// request, it fails if the volume cannot be used end to end
+func (s *Server) SmokeTest(rw http.ResponseWriter, req *http.Request) error {
+	if err := s.man.SmokeTest(req.Context()); err != nil {
+		return err
+	}
+	api.GetApiContext(req).Write(&Empty{})
+	return nil
+}
+
+// EffectiveConfig reports the config of the manager serving the request
+func (s *Server) EffectiveConfig(rw http.ResponseWriter, req *http.Request) error {
+	config, err := s.man.GetEffectiveConfig()
+	if err != nil {
+		return errors.Wrap(err, "fail to get effective config")
+	}
+	config.APIAuth = AuthEnabled
+	api.GetApiContext(req).Write(toRuntimeConfigResource(config))
+	return nil
+}
+
+// RenderController returns the container the manager of the host would
+// create for the controller of the volume on attach, without creating it
+func (s *Server) RenderController(rw http.ResponseWriter, req *http.Request) error {
+	return s.renderInstance(types.InstanceTypeController, rw, req)
+}
+
+// RenderReplica returns the container the manager of the host would create
+// for a new replica of the volume, without creating it
+func (s *Server) RenderReplica(rw http.ResponseWriter, req *http.Request) error {
+	return s.renderInstance(types.InstanceTypeReplica, rw, req)
+}
+
+func (s *Server) renderInstance(instanceType types.InstanceType, rw http.ResponseWriter, req *http.Request) error {
+	volumeName := req.URL.Query().Get("volume")
+	if volumeName == "" {
+		return errors.New("missing volume")
+	}
+	render, err := s.man.RenderInstance(instanceType, volumeName)
+	if err != nil {
+		return errors.Wrapf(err, "fail to render %v of volume %v", instanceType, volumeName)
+	}
+	api.GetApiContext(req).Write(toInstanceRenderResource(render))
+	return nil
+}
+
+// Info describes the manager serving the request, with the expiries of the
+// certificates of the internal TLS
+func (s *Server) Info(rw http.ResponseWriter, req *http.Request) error {
+	api.GetApiContext(req).Write(toInfoResource(s.sl.GetCurrentHostID(), s.man.TLSStatus()))
+	return nil
+}
+
+// RotateClusterCA replaces the cluster CA, the managers trust the previous
+// one for the transition window
+func (s *Server) RotateClusterCA(rw http.ResponseWriter, req *http.Request) error {
+	if err := s.man.RotateClusterCA(); err != nil {
+		return errors.Wrap(err, "fail to rotate cluster CA")
+	}
+	return s.Info(rw, req)
+}
+
+func (s *Server) ListLocks(rw http.ResponseWriter, req *http.Request) error {
+	locks, err := s.man.ListLocks()
+	if err != nil {
+		return errors.Wrap(err, "fail to list locks")
+	}
+	api.GetApiContext(req).Write(toLockCollection(locks, time.Now()))
+	return nil
+}
+
+// BreakLock revokes a lock, the query parameter confirm must be the operation
+// ID of the holder as listed
+func (s *Server) BreakLock(rw http.ResponseWriter, req *http.Request) error {
+	name := mux.Vars(req)["name"]
+	if _, err := s.man.BreakLock(name, req.URL.Query().Get("confirm"), requestAuthor(req)); err != nil {
+		return errors.Wrap(err, "fail to break lock")
+	}
+	return nil
+}
+
+// ListVolumeRawRecords dumps the records of a volume in the store, for
+// support
+func (s *Server) ListVolumeRawRecords(rw http.ResponseWriter, req *http.Request) error {
+	name := mux.Vars(req)["name"]
+	records, err := s.man.ListVolumeRawRecords(name)
+	if err != nil {
+		return errors.Wrap(err, "fail to get raw records")
+	}
+	api.GetApiContext(req).Write(toRawRecordCollection(records))
+	return nil
+}
+
+// SetVolumeRawRecord writes a single record as listed, with the modified
+// index it was listed at. The manager must run with --enable-raw-editing.
+func (s *Server) SetVolumeRawRecord(rw http.ResponseWriter, req *http.Request) error {
+	var record RawRecord
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&record); err != nil {
+		return err
+	}
+	name := mux.Vars(req)["name"]
+	if err := s.man.SetVolumeRawRecord(name, &record.RawRecord, requestAuthor(req)); err != nil {
+		return errors.Wrap(err, "fail to set raw record")
+	}
+	apiContext.Write(toRawRecordCollection([]*types.RawRecord{&record.RawRecord}))
+	return nil
+}
+
+// LocalInstances lists the containers on this host, for the consistency
+// audit run by another host
+func (s *Server) LocalInstances(rw http.ResponseWriter, req *http.Request) error {
+	instances, err := s.man.ListLocalInstances()
+	if err != nil {
+		return errors.Wrap(err, "fail to list local instances")
+	}
+	json.NewEncoder(rw).Encode(LocalInstancesOutput{Instances: instances})
+	return nil
+}
+
+func (s *Server) WriterReport(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+
+	report, err := s.man.WriterReport()
+	if err != nil {
+		return errors.Wrap(err, "fail to count the record writers")
+	}
+	apiContext.Write(toWriterReportResource(report))
+	return nil
+}
+
+func (s *Server) ConsistencyReport(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+
+	report, err := s.man.AuditConsistency()
+	if err != nil {
+		return errors.Wrap(err, "fail to audit consistency")
+	}
+	apiContext.Write(toConsistencyReportResource(report))
+	return nil
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const metricsContentType = "text/plain; version=0.0.4"
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Metrics serves the metrics of the manager in the Prometheus text format
+func (s *Server) Metrics(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", metricsContentType)
+	if err := writeMetrics(rw, s.man.Metrics()); err != nil {
+		logrus.Warnf("error writing metrics: %v", err)
+	}
+}
+
+func writeMetrics(w io.Writer, families []*types.MetricFamily) error {
+	for _, f := range families {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.Name, f.Help, f.Name, f.Type); err != nil {
+			return err
+		}
+		for _, sample := range f.Samples {
+			if _, err := fmt.Fprintf(w, "%s%s %s\n", f.Name, formatLabels(sample.Labels),
+				strconv.FormatFloat(sample.Value, 'g', -1, 64)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formatLabels renders the labels sorted by name, empty without any
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := []string{}
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := []string{}
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(labels[name])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
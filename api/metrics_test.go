@@ -0,0 +1,41 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	assert := require.New(t)
+
+	buf := &bytes.Buffer{}
+	assert.Nil(writeMetrics(buf, []*types.MetricFamily{
+		{
+			Name: "longhorn_volume_capacity_bytes",
+			Help: "The size of the volume",
+			Type: types.MetricTypeGauge,
+			Samples: []*types.MetricSample{
+				{Labels: map[string]string{"volume": "vol-1"}, Value: 10737418240},
+				{Labels: map[string]string{"volume": `odd"name`, "host": "host-1"}, Value: 0.5},
+			},
+		},
+		{
+			Name:    "longhorn_volume_metrics_dropped_volumes",
+			Help:    "The volumes without samples",
+			Type:    types.MetricTypeGauge,
+			Samples: []*types.MetricSample{{Value: 0}},
+		},
+	}))
+	assert.Equal(`# HELP longhorn_volume_capacity_bytes The size of the volume
+# TYPE longhorn_volume_capacity_bytes gauge
+longhorn_volume_capacity_bytes{volume="vol-1"} 1.073741824e+10
+longhorn_volume_capacity_bytes{host="host-1",volume="odd\"name"} 0.5
+# HELP longhorn_volume_metrics_dropped_volumes The volumes without samples
+# TYPE longhorn_volume_metrics_dropped_volumes gauge
+longhorn_volume_metrics_dropped_volumes 0
+`, buf.String())
+}
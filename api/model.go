@@ -6,6 +6,8 @@ import (
 	"github.com/rancher/go-rancher/api"
 	"github.com/rancher/go-rancher/client"
 	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/runner"
 	"net/http"
 	"strconv"
 	"time"
@@ -18,12 +20,55 @@ type Volume struct {
 	Size                string `json:"size,omitempty"`
 	BaseImage           string `json:"baseImage,omitempty"`
 	FromBackup          string `json:"fromBackup,omitempty"`
+	Standby             bool   `json:"standby,omitempty"`
 	NumberOfReplicas    int    `json:"numberOfReplicas,omitempty"`
 	StaleReplicaTimeout int    `json:"staleReplicaTimeout,omitempty"`
 	State               string `json:"state,omitempty"`
+	Unschedulable       string `json:"unschedulable,omitempty"`
 	EngineImage         string `json:"engineImage,omitempty"`
+	Mode                string `json:"mode,omitempty"`
 	Endpoint            string `json:"endpoint,omitemtpy"`
 	Created             string `json:"created,omitemtpy"`
+	DataIntegrity       string `json:"dataIntegrity,omitempty"`
+	ReplicaBacking      string `json:"replicaBacking,omitempty"`
+	CacheMode           string `json:"cacheMode,omitempty"`
+	CacheModeWarning    string `json:"cacheModeWarning,omitempty"`
+	FsType              string `json:"fsType,omitempty"`
+	FsInitialized       bool   `json:"fsInitialized,omitempty"`
+	PinReplicas         bool   `json:"pinReplicas,omitempty"`
+	SnapshotMaxCount    int    `json:"snapshotMaxCount,omitempty"`
+	SnapshotMaxAge      string `json:"snapshotMaxAge,omitempty"`
+	PreferredHostID     string `json:"preferredHostId,omitempty"`
+	CurrentHostID       string `json:"currentHostId,omitempty"`
+	RehomePolicy        string `json:"rehomePolicy,omitempty"`
+	RehomePending       bool   `json:"rehomePending,omitempty"`
+	AutoReattach        string `json:"autoReattach,omitempty"`
+	BackupCompression   string `json:"backupCompression,omitempty"`
+	SalvageRequired     bool   `json:"salvageRequired,omitempty"`
+	SalvageReason       string `json:"salvageReason,omitempty"`
+	RebuildFailures     int    `json:"rebuildFailures,omitempty"`
+	RebuildCondition    string `json:"rebuildCondition,omitempty"`
+	Generation          int64  `json:"generation"`
+
+	EngineVersionConstraint string `json:"engineVersionConstraint,omitempty"`
+
+	MaxReplicasPerZone int `json:"maxReplicasPerZone,omitempty"`
+	MinZones           int `json:"minZones,omitempty"`
+
+	AttachHistory []*types.AttachRecord `json:"attachHistory,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	ScheduleOnCreate bool               `json:"scheduleOnCreate,omitempty"`
+	ReplicaPlan      *types.ReplicaPlan `json:"replicaPlan,omitempty"`
+
+	InstanceEnv map[string]string `json:"instanceEnv,omitempty"`
+	AttachEnv   map[string]string `json:"attachEnv,omitempty"`
+
+	Conversion *types.VolumeConversion    `json:"conversion,omitempty"`
+	Migration  *types.ControllerMigration `json:"migration,omitempty"`
+
+	Conditions []*types.VolumeCondition `json:"conditions,omitempty"`
 
 	RecurringJobs []*types.RecurringJob `json:"recurringJobs,omitempty"`
 
@@ -39,9 +84,38 @@ type Snapshot struct {
 type Host struct {
 	client.Resource
 
-	UUID    string `json:"uuid,omitempty"`
-	Name    string `json:"name,omitempty"`
-	Address string `json:"address,omitempty"`
+	UUID         string `json:"uuid,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Address      string `json:"address,omitempty"`
+	Heartbeat    string `json:"heartbeat,omitempty"`
+	ClockSkew    string `json:"clockSkew,omitempty"`
+	SkewDetected bool   `json:"skewDetected,omitempty"`
+
+	Unschedulable bool   `json:"unschedulable,omitempty"`
+	FailureDomain string `json:"failureDomain,omitempty"`
+	// the effective weight, 1 for a host without one
+	SchedulingWeight int    `json:"schedulingWeight"`
+	Role             string `json:"role,omitempty"`
+
+	Conflicted     bool     `json:"conflicted,omitempty"`
+	ConflictNonces []string `json:"conflictNonces,omitempty"`
+
+	Maintenance *types.MaintenanceWindow `json:"maintenance,omitempty"`
+
+	Recovered string `json:"recovered,omitempty"`
+	// left of the cooldown after the recovery, the host takes no new
+	// replicas until it's over
+	RecoveryCooldown string `json:"recoveryCooldown,omitempty"`
+
+	// omitted with ?detail=false
+	Online               *bool    `json:"online,omitempty"`
+	Conditions           []string `json:"conditions,omitempty"`
+	Controllers          int      `json:"controllers,omitempty"`
+	Replicas             int      `json:"replicas,omitempty"`
+	ReservedBytes        int64    `json:"reservedBytes,omitempty"`
+	AvailableBytes       int64    `json:"availableBytes,omitempty"`
+	Schedulable          *bool    `json:"schedulable,omitempty"`
+	UnschedulableReasons []string `json:"unschedulableReasons,omitempty"`
 }
 
 type BackupVolume struct {
@@ -58,12 +132,54 @@ type Setting struct {
 	client.Resource
 	Name  string `json:"name"`
 	Value string `json:"value"`
+
+	// required to change engineImage or backupTarget with volumes attached
+	Confirm bool `json:"confirm,omitempty"`
+}
+
+type SettingDefinition struct {
+	client.Resource
+	Name        string   `json:"name"`
+	SettingType string   `json:"settingType"` // "type" is the resource type
+	Default     string   `json:"default"`
+	Description string   `json:"description"`
+	Options     []string `json:"options,omitempty"`
+	Validation  string   `json:"validation,omitempty"`
+	Value       string   `json:"value"`
+}
+
+type SettingsInput struct {
+	Settings map[string]string `json:"settings"`
+
+	// required to change engineImage or backupTarget with volumes attached
+	Confirm bool `json:"confirm,omitempty"`
+}
+
+type SettingsRevision struct {
+	client.Resource
+	types.SettingsRevision
+}
+
+type SettingsRollbackInput struct {
+	Confirm bool `json:"confirm,omitempty"`
+}
+
+// RecordKeepInput exempts a record from the limit of its list and the
+// record GC, or not
+type RecordKeepInput struct {
+	Keep bool `json:"keep"`
 }
 
 type Instance struct {
 	HostID  string `json:"hostId,omitempty"`
 	Address string `json:"address,omitempty"`
 	Running bool   `json:"running,omitempty"`
+	// Env is the effective environment, with the secrets redacted
+	Env []string `json:"env,omitempty"`
+
+	// the name and address of the host, listed with ?detail=true
+	HostName    string `json:"hostName,omitempty"`
+	HostAddress string `json:"hostAddress,omitempty"`
 }
 
 type Controller struct {
@@ -73,13 +189,20 @@ type Controller struct {
 type Replica struct {
 	Instance
 
-	Name         string `json:"name,omitempty"`
-	Mode         string `json:"mode,omitempty"`
-	BadTimestamp string `json:"badTimestamp,omitempty"`
+	Name            string `json:"name,omitempty"`
+	Mode            string `json:"mode,omitempty"`
+	BadTimestamp    string `json:"badTimestamp,omitempty"`
+	PreferredHostID string `json:"preferredHostId,omitempty"`
+	Standby         bool   `json:"standby,omitempty"`
+	StandbySynced   string `json:"standbySynced,omitempty"`
+	BlockDevice     string `json:"blockDevice,omitempty"`
 }
 
 type AttachInput struct {
-	HostID string `json:"hostId,omitempty"`
+	HostID string            `json:"hostId,omitempty"`
+	Env    map[string]string `json:"env,omitempty"`
+	// PreferredHostIDs are tried in order before HostID
+	PreferredHostIDs []string `json:"preferredHostIds,omitempty"`
 }
 
 type Empty struct {
@@ -109,6 +232,270 @@ type ReplicaRemoveInput struct {
 	Name string `json:"name"`
 }
 
+type ReplicaInput struct {
+	Name string `json:"name"`
+}
+
+type MigrateControllerInput struct {
+	HostID string `json:"hostId"`
+}
+
+type ControllerMigration struct {
+	client.Resource
+	types.ControllerMigration
+}
+
+type DiskUsage struct {
+	client.Resource
+	types.DiskUsage
+}
+
+type PreferredHostInput struct {
+	HostID       string `json:"hostId,omitempty"`
+	RehomePolicy string `json:"rehomePolicy,omitempty"`
+}
+
+type ConvertInput struct {
+	Mode             string `json:"mode"`
+	NumberOfReplicas int    `json:"numberOfReplicas,omitempty"`
+}
+
+type PinReplicasInput struct {
+	Pinned bool `json:"pinned"`
+}
+
+type EngineVersionConstraintInput struct {
+	Constraint string `json:"constraint"`
+}
+
+type AutoReattachInput struct {
+	Policy string `json:"policy,omitempty"`
+}
+
+type BackupCompressionInput struct {
+	Compression string `json:"compression,omitempty"`
+}
+
+type DrainInput struct {
+	Deadline string `json:"deadline,omitempty"`
+}
+
+type SchedulableInput struct {
+	Schedulable bool `json:"schedulable"`
+}
+
+type FailureDomainInput struct {
+	FailureDomain string `json:"failureDomain"`
+}
+
+type SchedulingWeightInput struct {
+	SchedulingWeight int `json:"schedulingWeight"`
+}
+
+type HostRoleInput struct {
+	Role string `json:"role"`
+}
+
+type MaintenanceInput struct {
+	// RFC3339
+	Start string `json:"start"`
+	// a Go duration, like 2h
+	Duration string `json:"duration"`
+	Policy   string `json:"policy"`
+	Restore  bool   `json:"restore"`
+}
+
+type HostConflictInput struct {
+	Nonce string `json:"nonce"`
+}
+
+type DrainProgress struct {
+	client.Resource
+	types.DrainProgress
+}
+
+type SchedulerStatus struct {
+	client.Resource
+	Queued   int                         `json:"queued"`
+	InFlight int                         `json:"inFlight"`
+	Items    []*types.ScheduleItemStatus `json:"items"`
+	Latency  map[string]*ScheduleLatency `json:"latency"`
+
+	Timeouts    map[string]int              `json:"timeouts"`
+	Abandoned   []*types.AbandonedSchedule  `json:"abandoned"`
+	Leaked      int                         `json:"leaked"`
+	Quarantined []*types.ScheduleQuarantine `json:"quarantined"`
+
+	Unschedulable map[string]int `json:"unschedulable"`
+}
+
+type ReconcileStatus struct {
+	client.Resource
+	Paused bool `json:"paused"`
+}
+
+type VolumeEvent struct {
+	client.Resource
+	types.VolumeEvent
+}
+
+type StateTransition struct {
+	client.Resource
+	types.StateTransition
+}
+
+type EngineStatus struct {
+	client.Resource
+	types.EngineStatus
+}
+
+type EventRecorderStatus struct {
+	client.Resource
+	types.EventRecorderStatus
+}
+
+type RuntimeConfig struct {
+	client.Resource
+	types.RuntimeConfig
+}
+
+type InstanceRender struct {
+	client.Resource
+	types.InstanceRender
+}
+
+type BackupReadStats struct {
+	client.Resource
+	types.BackupReadStats
+}
+
+// Info describes the manager serving the request
+type Info struct {
+	client.Resource
+	HostID      string          `json:"hostId"`
+	InternalTLS types.TLSStatus `json:"internalTLS"`
+}
+
+type RawRecord struct {
+	client.Resource
+	types.RawRecord
+}
+
+type Lock struct {
+	client.Resource
+	types.LockInfo
+	TTLRemaining string `json:"ttlRemaining"`
+}
+
+type RunnerStatus struct {
+	client.Resource
+	runner.Status
+}
+
+type SlowOperation struct {
+	client.Resource
+	types.SlowOperation
+}
+
+type BackupGCInput struct {
+	// BackupTarget is the backupTarget setting if empty
+	BackupTarget string `json:"backupTarget"`
+}
+
+type GCResult struct {
+	client.Resource
+	types.GCResult
+}
+
+type CapacityCheckInput struct {
+	Count                      int               `json:"count"`
+	Size                       string            `json:"size"`
+	Replicas                   int               `json:"replicas"`
+	NodeSelector               map[string]string `json:"nodeSelector,omitempty"`
+	OverProvisioningPercentage int               `json:"overProvisioningPercentage,omitempty"`
+	MaxReplicasPerHost         int               `json:"maxReplicasPerHost,omitempty"`
+}
+
+type CapacityCheckResult struct {
+	client.Resource
+	types.CapacityCheckResult
+}
+
+type ConsistencyReport struct {
+	client.Resource
+	types.ConsistencyReport
+}
+
+type WriterReport struct {
+	client.Resource
+	types.WriterReport
+}
+
+type BootstrapReport struct {
+	client.Resource
+	types.BootstrapReport
+}
+
+type APIToken struct {
+	client.Resource
+
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	Hash    string `json:"hash"`
+	Created string `json:"created,omitempty"`
+	Source  string `json:"source"`
+
+	// the secret, only on creation
+	Token string `json:"token,omitempty"`
+}
+
+type APITokenInput struct {
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type ScheduleLatency struct {
+	Count   int    `json:"count"`
+	Average string `json:"average"`
+	Max     string `json:"max"`
+	Last    string `json:"last"`
+}
+
+type VolumeGroup struct {
+	client.Resource
+
+	Name     string                   `json:"name"`
+	Volumes  []string                 `json:"volumes"`
+	Selector map[string]string        `json:"selector,omitempty"`
+	Created  string                   `json:"created"`
+	Status   *types.VolumeGroupStatus `json:"status,omitempty"`
+}
+
+type VolumeGroupVolumesInput struct {
+	Volumes []string `json:"volumes"`
+}
+
+type VolumeGroupSelectorInput struct {
+	Selector map[string]string `json:"selector"`
+}
+
+type VolumeGroupAttachInput struct {
+	HostID string            `json:"hostId,omitempty"`
+	Hosts  map[string]string `json:"hosts,omitempty"`
+}
+
+type VolumeGroupOperation struct {
+	client.Resource
+
+	Snapshot string                     `json:"snapshot,omitempty"`
+	Results  []*types.VolumeGroupResult `json:"results"`
+}
+
+type SalvageInput struct {
+	ReplicaNames []string `json:"replicaNames,omitempty"`
+}
+
 func NewSchema() *client.Schemas {
 	schemas := &client.Schemas{}
 
@@ -123,9 +510,66 @@ func NewSchema() *client.Schemas {
 	schemas.AddType("recurringJob", types.RecurringJob{})
 	schemas.AddType("bgTask", BgTask{})
 	schemas.AddType("replicaRemoveInput", ReplicaRemoveInput{})
+	schemas.AddType("replicaInput", ReplicaInput{})
+	schemas.AddType("diskUsage", DiskUsage{})
+	schemas.AddType("preferredHostInput", PreferredHostInput{})
+	schemas.AddType("autoReattachInput", AutoReattachInput{})
+	schemas.AddType("backupCompressionInput", BackupCompressionInput{})
+	schemas.AddType("pinReplicasInput", PinReplicasInput{})
+	schemas.AddType("convertInput", ConvertInput{})
+	schemas.AddType("engineVersionConstraintInput", EngineVersionConstraintInput{})
+	schemas.AddType("salvageInput", SalvageInput{})
+	schemas.AddType("attachRecord", types.AttachRecord{})
+	schemas.AddType("drainInput", DrainInput{})
+	schemas.AddType("schedulableInput", SchedulableInput{})
+	schemas.AddType("failureDomainInput", FailureDomainInput{})
+	schemas.AddType("schedulingWeightInput", SchedulingWeightInput{})
+	schemas.AddType("hostRoleInput", HostRoleInput{})
+	schemas.AddType("maintenanceInput", MaintenanceInput{})
+	schemas.AddType("hostConflictInput", HostConflictInput{})
+	schemas.AddType("drainProgress", DrainProgress{})
+	schemas.AddType("schedulerStatus", SchedulerStatus{})
+	schemas.AddType("consistencyReport", ConsistencyReport{})
+	schemas.AddType("writerReport", WriterReport{})
+	schemas.AddType("bootstrapReport", BootstrapReport{})
+	schemas.AddType("apiToken", APIToken{})
+	schemas.AddType("apiTokenInput", APITokenInput{})
+	schemas.AddType("capacityCheckInput", CapacityCheckInput{})
+	schemas.AddType("reconcileStatus", ReconcileStatus{})
+	schemas.AddType("volumeEvent", VolumeEvent{})
+	schemas.AddType("stateTransition", StateTransition{})
+	schemas.AddType("eventRecorderStatus", EventRecorderStatus{})
+	schemas.AddType("lock", Lock{})
+	schemas.AddType("slowOperation", SlowOperation{})
+	schemas.AddType("runnerStatus", RunnerStatus{})
+	schemas.AddType("backupGCInput", BackupGCInput{})
+	schemas.AddType("gcResult", GCResult{})
+	schemas.AddType("migrateControllerInput", MigrateControllerInput{})
+	schemas.AddType("controllerMigration", ControllerMigration{})
+	schemas.AddType("runtimeConfig", RuntimeConfig{})
+	schemas.AddType("rawRecord", RawRecord{})
+	schemas.AddType("backupReadStats", BackupReadStats{})
+	schemas.AddType("instanceRender", InstanceRender{})
+	schemas.AddType("tlsStatus", types.TLSStatus{})
+	schemas.AddType("info", Info{})
+	schemas.AddType("engineReplicaConnection", types.EngineReplicaConnection{})
+	schemas.AddType("engineStatus", EngineStatus{})
+	schemas.AddType("capacityCheckResult", CapacityCheckResult{})
+	schemas.AddType("settingsRevision", SettingsRevision{})
+	schemas.AddType("settingsRollbackInput", SettingsRollbackInput{})
+	schemas.AddType("recordKeepInput", RecordKeepInput{})
+	schemas.AddType("settingDefinition", SettingDefinition{})
+	schemas.AddType("settingsInput", SettingsInput{})
+	schemas.AddType("volumeGroupStatus", types.VolumeGroupStatus{})
+	schemas.AddType("volumeGroupResult", types.VolumeGroupResult{})
+	schemas.AddType("volumeGroupVolumesInput", VolumeGroupVolumesInput{})
+	schemas.AddType("volumeGroupSelectorInput", VolumeGroupSelectorInput{})
+	schemas.AddType("volumeGroupAttachInput", VolumeGroupAttachInput{})
+	schemas.AddType("volumeGroupOperation", VolumeGroupOperation{})
 
 	hostSchema(schemas.AddType("host", Host{}))
 	volumeSchema(schemas.AddType("volume", Volume{}))
+	volumeGroupSchema(schemas.AddType("volumeGroup", VolumeGroup{}))
 	backupVolumeSchema(schemas.AddType("backupVolume", BackupVolume{}))
 	settingSchema(schemas.AddType("setting", Setting{}))
 	recurringSchema(schemas.AddType("recurringInput", RecurringInput{}))
@@ -156,7 +600,92 @@ func settingSchema(setting *client.Schema) {
 
 func hostSchema(host *client.Schema) {
 	host.CollectionMethods = []string{"GET"}
-	host.ResourceMethods = []string{"GET"}
+	host.ResourceMethods = []string{"GET", "DELETE"}
+	host.ResourceActions = map[string]client.Action{
+		"drain": {
+			Input:  "drainInput",
+			Output: "drainProgress",
+		},
+		"drainProgress": {
+			Output: "drainProgress",
+		},
+		"schedulableUpdate": {
+			Input:  "schedulableInput",
+			Output: "host",
+		},
+		"failureDomainUpdate": {
+			Input:  "failureDomainInput",
+			Output: "host",
+		},
+		"schedulingWeightUpdate": {
+			Input:  "schedulingWeightInput",
+			Output: "host",
+		},
+		"roleUpdate": {
+			Input:  "hostRoleInput",
+			Output: "host",
+		},
+		"resolveConflict": {
+			Input:  "hostConflictInput",
+			Output: "host",
+		},
+		"maintenanceSchedule": {
+			Input:  "maintenanceInput",
+			Output: "host",
+		},
+		"maintenanceCancel": {
+			Output: "host",
+		},
+		"instancesStop": {
+			Output: "host",
+		},
+		"instancesStart": {
+			Output: "host",
+		},
+	}
+}
+
+func volumeGroupSchema(group *client.Schema) {
+	group.CollectionMethods = []string{"GET", "POST"}
+	group.ResourceMethods = []string{"GET", "DELETE"}
+	group.ResourceActions = map[string]client.Action{
+		"volumeAdd": {
+			Input:  "volumeGroupVolumesInput",
+			Output: "volumeGroup",
+		},
+		"volumeRemove": {
+			Input:  "volumeGroupVolumesInput",
+			Output: "volumeGroup",
+		},
+		"selectorUpdate": {
+			Input:  "volumeGroupSelectorInput",
+			Output: "volumeGroup",
+		},
+		"attach": {
+			Input:  "volumeGroupAttachInput",
+			Output: "volumeGroupOperation",
+		},
+		"detach": {
+			Output: "volumeGroupOperation",
+		},
+		"snapshotCreate": {
+			Input:  "snapshotInput",
+			Output: "volumeGroupOperation",
+		},
+		"backup": {
+			Input:  "snapshotInput",
+			Output: "volumeGroupOperation",
+		},
+	}
+	group.ResourceFields["status"] = client.Field{
+		Type:     "volumeGroupStatus",
+		Nullable: true,
+	}
+	for _, name := range []string{"name", "volumes", "selector"} {
+		field := group.ResourceFields[name]
+		field.Create = true
+		group.ResourceFields[name] = field
+	}
 }
 
 func volumeSchema(volume *client.Schema) {
@@ -200,6 +729,59 @@ func volumeSchema(volume *client.Schema) {
 			Input:  "replicaRemoveInput",
 			Output: "volume",
 		},
+		"replicaMigrate": {
+			Input:  "replicaInput",
+			Output: "volume",
+		},
+		"replicaDiskUsage": {
+			Input:  "replicaInput",
+			Output: "diskUsage",
+		},
+		"preferredHostUpdate": {
+			Input:  "preferredHostInput",
+			Output: "volume",
+		},
+		"autoReattachUpdate": {
+			Input:  "autoReattachInput",
+			Output: "volume",
+		},
+		"backupCompressionUpdate": {
+			Input:  "backupCompressionInput",
+			Output: "volume",
+		},
+		"pinReplicasUpdate": {
+			Input:  "pinReplicasInput",
+			Output: "volume",
+		},
+		"engineVersionConstraintUpdate": {
+			Input:  "engineVersionConstraintInput",
+			Output: "volume",
+		},
+		"salvage": {
+			Input:  "salvageInput",
+			Output: "volume",
+		},
+		"replan": {
+			Output: "volume",
+		},
+		"abortCreation": {},
+		"snapshotCompact": {
+			Output: "volume",
+		},
+		"rebuildCancel": {
+			Output: "volume",
+		},
+		"standbyCreate": {
+			Output: "volume",
+		},
+		"standbyPromote": {
+			Input:  "replicaRemoveInput",
+			Output: "volume",
+		},
+		"convert": {
+			Input:  "convertInput",
+			Output: "volume",
+		},
 	}
 	volume.ResourceFields["controller"] = client.Field{
 		Type:     "struct",
@@ -221,6 +803,22 @@ func volumeSchema(volume *client.Schema) {
 	volumeFromBackup.Create = true
 	volume.ResourceFields["fromBackup"] = volumeFromBackup
 
+	volumeStandby := volume.ResourceFields["standby"]
+	volumeStandby.Create = true
+	volume.ResourceFields["standby"] = volumeStandby
+
+	volumeLabels := volume.ResourceFields["labels"]
+	volumeLabels.Create = true
+	volume.ResourceFields["labels"] = volumeLabels
+
+	volumeScheduleOnCreate := volume.ResourceFields["scheduleOnCreate"]
+	volumeScheduleOnCreate.Create = true
+	volume.ResourceFields["scheduleOnCreate"] = volumeScheduleOnCreate
+
+	volumeInstanceEnv := volume.ResourceFields["instanceEnv"]
+	volumeInstanceEnv.Create = true
+	volume.ResourceFields["instanceEnv"] = volumeInstanceEnv
+
 	volumeNumberOfReplicas := volume.ResourceFields["numberOfReplicas"]
 	volumeNumberOfReplicas.Create = true
 	volumeNumberOfReplicas.Required = true
@@ -231,6 +829,109 @@ func volumeSchema(volume *client.Schema) {
 	volumeStaleReplicaTimeout.Create = true
 	volumeStaleReplicaTimeout.Default = 20
 	volume.ResourceFields["staleReplicaTimeout"] = volumeStaleReplicaTimeout
+
+	volumeDataIntegrity := volume.ResourceFields["dataIntegrity"]
+	volumeDataIntegrity.Create = true
+	volumeDataIntegrity.Type = "enum"
+	volumeDataIntegrity.Options = []string{
+		string(types.DataIntegrityDisabled),
+		string(types.DataIntegrityFastCheck),
+		string(types.DataIntegrityFull),
+	}
+	volumeDataIntegrity.Default = string(types.DataIntegrityFastCheck)
+	volume.ResourceFields["dataIntegrity"] = volumeDataIntegrity
+
+	volumeReplicaBacking := volume.ResourceFields["replicaBacking"]
+	volumeReplicaBacking.Create = true
+	volumeReplicaBacking.Type = "enum"
+	volumeReplicaBacking.Options = []string{
+		string(types.ReplicaBackingFile),
+		string(types.ReplicaBackingBlock),
+	}
+	volumeReplicaBacking.Default = string(types.ReplicaBackingFile)
+	volume.ResourceFields["replicaBacking"] = volumeReplicaBacking
+
+	volumeCacheMode := volume.ResourceFields["cacheMode"]
+	volumeCacheMode.Create = true
+	volumeCacheMode.Type = "enum"
+	volumeCacheMode.Options = []string{
+		string(types.CacheModeWriteThrough),
+		string(types.CacheModeWriteBack),
+	}
+	volumeCacheMode.Default = string(types.CacheModeWriteThrough)
+	volume.ResourceFields["cacheMode"] = volumeCacheMode
+
+	volumeFsType := volume.ResourceFields["fsType"]
+	volumeFsType.Create = true
+	volumeFsType.Type = "enum"
+	volumeFsType.Options = []string{
+		string(types.FsTypeExt4),
+		string(types.FsTypeXFS),
+	}
+	volume.ResourceFields["fsType"] = volumeFsType
+
+	volumeMode := volume.ResourceFields["mode"]
+	volumeMode.Create = true
+	volumeMode.Type = "enum"
+	volumeMode.Options = []string{
+		string(types.VolumeModeReplicated),
+		string(types.VolumeModeLocal),
+	}
+	volumeMode.Default = string(types.VolumeModeReplicated)
+	volume.ResourceFields["mode"] = volumeMode
+
+	volumeEngineVersionConstraint := volume.ResourceFields["engineVersionConstraint"]
+	volumeEngineVersionConstraint.Create = true
+	volume.ResourceFields["engineVersionConstraint"] = volumeEngineVersionConstraint
+
+	volumeMaxReplicasPerZone := volume.ResourceFields["maxReplicasPerZone"]
+	volumeMaxReplicasPerZone.Create = true
+	volume.ResourceFields["maxReplicasPerZone"] = volumeMaxReplicasPerZone
+
+	volumeMinZones := volume.ResourceFields["minZones"]
+	volumeMinZones.Create = true
+	volume.ResourceFields["minZones"] = volumeMinZones
+
+	volumeSnapshotMaxCount := volume.ResourceFields["snapshotMaxCount"]
+	volumeSnapshotMaxCount.Create = true
+	volume.ResourceFields["snapshotMaxCount"] = volumeSnapshotMaxCount
+
+	volumeSnapshotMaxAge := volume.ResourceFields["snapshotMaxAge"]
+	volumeSnapshotMaxAge.Create = true
+	volume.ResourceFields["snapshotMaxAge"] = volumeSnapshotMaxAge
+
+	volumePreferredHostID := volume.ResourceFields["preferredHostId"]
+	volumePreferredHostID.Create = true
+	volume.ResourceFields["preferredHostId"] = volumePreferredHostID
+
+	volumeRehomePolicy := volume.ResourceFields["rehomePolicy"]
+	volumeRehomePolicy.Create = true
+	volumeRehomePolicy.Type = "enum"
+	volumeRehomePolicy.Options = []string{
+		string(types.RehomePolicyReattach),
+		string(types.RehomePolicyWindow),
+	}
+	volume.ResourceFields["rehomePolicy"] = volumeRehomePolicy
+
+	volumeAutoReattach := volume.ResourceFields["autoReattach"]
+	volumeAutoReattach.Create = true
+	volumeAutoReattach.Type = "enum"
+	volumeAutoReattach.Options = []string{
+		string(types.AutoReattachPolicyDisabled),
+		string(types.AutoReattachPolicyAlways),
+		string(types.AutoReattachPolicyIfClean),
+	}
+	volume.ResourceFields["autoReattach"] = volumeAutoReattach
+
+	volumeBackupCompression := volume.ResourceFields["backupCompression"]
+	volumeBackupCompression.Create = true
+	volumeBackupCompression.Type = "enum"
+	volumeBackupCompression.Options = []string{
+		string(types.BackupCompressionNone),
+		string(types.BackupCompressionLZ4),
+		string(types.BackupCompressionGzip),
+	}
+	volume.ResourceFields["backupCompression"] = volumeBackupCompression
 }
 
 func backupVolumeSchema(backupVolume *client.Schema) {
@@ -260,10 +961,73 @@ func toSettingResource(name, value string) *Setting {
 	}
 }
 
+func toSettingDefinitionCollection(definitions []types.SettingDefinition, settings *types.SettingsInfo) (*client.GenericCollection, error) {
+	data := []interface{}{}
+	for _, d := range definitions {
+		value, err := settingValue(settings, d.Name)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, &SettingDefinition{
+			Resource: client.Resource{
+				Id:   d.Name,
+				Type: "settingDefinition",
+			},
+			Name:        d.Name,
+			SettingType: string(d.Type),
+			Default:     d.Default,
+			Description: d.Description,
+			Options:     d.Options,
+			Validation:  d.Validation,
+			Value:       value,
+		})
+	}
+	return &client.GenericCollection{Data: data, Collection: client.Collection{ResourceType: "settingDefinition"}}, nil
+}
+
+func toSettingsRevisionCollection(history []*types.SettingsRevision) *client.GenericCollection {
+	data := []interface{}{}
+	for _, r := range history {
+		data = append(data, &SettingsRevision{
+			Resource: client.Resource{
+				Id:   strconv.FormatInt(r.Revision, 10),
+				Type: "settingsRevision",
+			},
+			SettingsRevision: *r,
+		})
+	}
+	return &client.GenericCollection{Data: data, Collection: client.Collection{ResourceType: "settingsRevision"}}
+}
+
 func toSettingCollection(settings *types.SettingsInfo) *client.GenericCollection {
+	// a map of strings always marshals
+	instanceEnv, _ := settingValue(settings, "instanceEnv")
 	data := []interface{}{
 		toSettingResource("backupTarget", settings.BackupTarget),
 		toSettingResource("engineImage", settings.EngineImage),
+		toSettingResource("rehomeWindow", settings.RehomeWindow),
+		toSettingResource("rehomeWindowLive", strconv.FormatBool(settings.RehomeWindowLive)),
+		toSettingResource("autoReattach", string(settings.AutoReattach)),
+		toSettingResource("snapshotMaxCount", strconv.Itoa(settings.SnapshotMaxCount)),
+		toSettingResource("snapshotMaxAge", settings.SnapshotMaxAge),
+		toSettingResource("snapshotPruneStrategy", string(settings.SnapshotPruneStrategy)),
+		toSettingResource("clockSkewThreshold", settings.ClockSkewThreshold),
+		toSettingResource("autoDetach", strconv.FormatBool(settings.AutoDetach)),
+		toSettingResource("autoDetachTimeout", settings.AutoDetachTimeout),
+		toSettingResource("replicaRestartPolicy", string(settings.ReplicaRestartPolicy)),
+		toSettingResource("controllerRestartPolicy", string(settings.ControllerRestartPolicy)),
+		toSettingResource("replicaQuota", strconv.FormatBool(settings.ReplicaQuota)),
+		toSettingResource("replicaQuotaOverhead", strconv.Itoa(settings.ReplicaQuotaOverhead)),
+		toSettingResource("instanceEnv", instanceEnv),
+		toSettingResource("controllerRecreate", strconv.FormatBool(settings.ControllerRecreate)),
+		toSettingResource("controllerUnresponsiveThreshold", settings.ControllerUnresponsiveThreshold),
+		toSettingResource("backupCompression", string(settings.BackupCompression)),
+		toSettingResource("healthcheckInterval", settings.HealthcheckInterval),
+		toSettingResource("healthcheckRetries", strconv.Itoa(settings.HealthcheckRetries)),
+		toSettingResource("backupConcurrencyLimit", strconv.Itoa(settings.BackupConcurrencyLimit)),
+		toSettingResource("backupTargetConcurrencyLimit", strconv.Itoa(settings.BackupTargetConcurrencyLimit)),
+		toSettingResource("recordRetentionSucceeded", settings.RecordRetentionSucceeded),
+		toSettingResource("recordRetentionFailed", settings.RecordRetentionFailed),
 	}
 	return &client.GenericCollection{Data: data, Collection: client.Collection{ResourceType: "setting"}}
 }
@@ -275,29 +1039,47 @@ func toVolumeResource(v *types.VolumeInfo, apiContext *api.ApiContext) *Volume {
 		if r.Running {
 			mode = string(r.Mode)
 		}
+		standby := v.StandbyReplicas[r.Name]
+		synced := ""
+		if standby != nil {
+			synced = standby.Synced
+		}
 		replicas = append(replicas, Replica{
 			Instance: Instance{
 				Running: r.Running,
 				Address: r.Address,
 				HostID:  r.HostID,
+				Env:     util.RedactEnv(r.Env),
 			},
-			Name:         r.Name,
-			Mode:         mode,
-			BadTimestamp: r.BadTimestamp,
+			Name:            r.Name,
+			Mode:            mode,
+			BadTimestamp:    r.BadTimestamp,
+			PreferredHostID: r.PreferredHostID,
+			Standby:         standby != nil,
+			StandbySynced:   synced,
+			BlockDevice:     r.BlockDevice,
 		})
 	}
 
 	var controller *Controller
+	currentHostID := ""
 	if v.Controller != nil {
+		currentHostID = v.Controller.HostID
 		controller = &Controller{Instance{
 			Running: v.Controller.Running,
 			HostID:  v.Controller.HostID,
 			Address: v.Controller.Address,
+			Env:     util.RedactEnv(v.Controller.Env),
 		}}
 	}
 
 	logrus.Debugf("controller: %+v", controller)
 
+	snapshotMaxAge := ""
+	if v.SnapshotMaxAge != 0 {
+		snapshotMaxAge = v.SnapshotMaxAge.String()
+	}
+
 	r := &Volume{
 		Resource: client.Resource{
 			Id:      v.Name,
@@ -309,25 +1091,89 @@ func toVolumeResource(v *types.VolumeInfo, apiContext *api.ApiContext) *Volume {
 		Size:                strconv.FormatInt(v.Size, 10),
 		BaseImage:           v.BaseImage,
 		FromBackup:          v.FromBackup,
+		Standby:             v.Standby,
 		NumberOfReplicas:    v.NumberOfReplicas,
 		State:               string(v.State),
 		EngineImage:         v.EngineImage,
+		Mode:                string(v.Mode),
 		RecurringJobs:       v.RecurringJobs,
 		StaleReplicaTimeout: int(v.StaleReplicaTimeout / time.Minute),
 		Endpoint:            v.Endpoint,
 		Created:             v.Created,
+		DataIntegrity:       string(v.DataIntegrity),
+		ReplicaBacking:      string(v.ReplicaBacking),
+		CacheMode:           string(v.CacheMode),
+		FsType:              string(v.FsType),
+		FsInitialized:       v.FsInitialized,
+		PinReplicas:         v.PinReplicas,
+		SnapshotMaxCount:    v.SnapshotMaxCount,
+		SnapshotMaxAge:      snapshotMaxAge,
+		PreferredHostID:     v.PreferredHostID,
+		CurrentHostID:       currentHostID,
+		RehomePolicy:        string(v.RehomePolicy),
+		RehomePending:       v.RehomePending,
+		AutoReattach:        string(v.AutoReattach),
+		BackupCompression:   string(v.BackupCompression),
+		SalvageRequired:     v.SalvageRequired,
+		SalvageReason:       v.SalvageReason,
+		RebuildFailures:     v.RebuildFailures,
+		RebuildCondition:    v.RebuildCondition,
+		Generation:          v.Generation,
+		AttachHistory:       v.AttachHistory,
+
+		EngineVersionConstraint: v.EngineVersionConstraint,
+
+		MaxReplicasPerZone: v.MaxReplicasPerZone,
+		MinZones:           v.MinZones,
+
+		Labels: v.Labels,
+
+		ScheduleOnCreate: v.ScheduleOnCreate,
+		ReplicaPlan:      v.ReplicaPlan,
+
+		InstanceEnv: util.RedactOpts(v.InstanceEnv),
+		AttachEnv:   util.RedactOpts(v.AttachEnv),
+
+		Conversion: v.Conversion,
+		Migration:  v.Migration,
+
+		Conditions: v.Conditions,
 
 		Controller: controller,
 		Replicas:   replicas,
 	}
 
+	if v.CacheMode == types.CacheModeWriteBack {
+		r.CacheModeWarning = types.CacheModeWriteBackWarning
+	}
+	if c := v.Condition(types.VolumeConditionScheduled); c != nil {
+		r.Unschedulable = c.Message
+	}
+
 	actions := map[string]struct{}{}
 
 	switch v.State {
 	case types.VolumeStateDetached:
-		actions["attach"] = struct{}{}
+		if v.SalvageRequired {
+			actions["salvage"] = struct{}{}
+		} else {
+			actions["attach"] = struct{}{}
+		}
 		actions["recurringUpdate"] = struct{}{}
 		actions["replicaRemove"] = struct{}{}
+		actions["replicaDiskUsage"] = struct{}{}
+		actions["preferredHostUpdate"] = struct{}{}
+		actions["autoReattachUpdate"] = struct{}{}
+		actions["backupCompressionUpdate"] = struct{}{}
+		actions["pinReplicasUpdate"] = struct{}{}
+		actions["engineVersionConstraintUpdate"] = struct{}{}
+		actions["convert"] = struct{}{}
+		if !v.SalvageRequired {
+			actions["snapshotCompact"] = struct{}{}
+		}
+		if v.ReplicaPlan.Pending() {
+			actions["replan"] = struct{}{}
+		}
 	case types.VolumeStateHealthy:
 		actions["detach"] = struct{}{}
 		actions["snapshotPurge"] = struct{}{}
@@ -340,6 +1186,16 @@ func toVolumeResource(v *types.VolumeInfo, apiContext *api.ApiContext) *Volume {
 		actions["recurringUpdate"] = struct{}{}
 		actions["bgTaskQueue"] = struct{}{}
 		actions["replicaRemove"] = struct{}{}
+		actions["replicaMigrate"] = struct{}{}
+		actions["replicaDiskUsage"] = struct{}{}
+		actions["preferredHostUpdate"] = struct{}{}
+		actions["autoReattachUpdate"] = struct{}{}
+		actions["backupCompressionUpdate"] = struct{}{}
+		actions["pinReplicasUpdate"] = struct{}{}
+		actions["engineVersionConstraintUpdate"] = struct{}{}
+		actions["convert"] = struct{}{}
+		actions["standbyCreate"] = struct{}{}
+		actions["standbyPromote"] = struct{}{}
 	case types.VolumeStateDegraded:
 		actions["detach"] = struct{}{}
 		actions["snapshotPurge"] = struct{}{}
@@ -352,9 +1208,35 @@ func toVolumeResource(v *types.VolumeInfo, apiContext *api.ApiContext) *Volume {
 		actions["recurringUpdate"] = struct{}{}
 		actions["bgTaskQueue"] = struct{}{}
 		actions["replicaRemove"] = struct{}{}
+		actions["replicaMigrate"] = struct{}{}
+		actions["replicaDiskUsage"] = struct{}{}
+		actions["preferredHostUpdate"] = struct{}{}
+		actions["autoReattachUpdate"] = struct{}{}
+		actions["backupCompressionUpdate"] = struct{}{}
+		actions["pinReplicasUpdate"] = struct{}{}
+		actions["engineVersionConstraintUpdate"] = struct{}{}
+		actions["convert"] = struct{}{}
+		actions["rebuildCancel"] = struct{}{}
+		actions["standbyCreate"] = struct{}{}
+		actions["standbyPromote"] = struct{}{}
+	case types.VolumeStateCreating:
+		actions["abortCreation"] = struct{}{}
 	case types.VolumeStateCreated:
 		actions["recurringUpdate"] = struct{}{}
+		actions["preferredHostUpdate"] = struct{}{}
+		actions["autoReattachUpdate"] = struct{}{}
+		actions["backupCompressionUpdate"] = struct{}{}
+		actions["pinReplicasUpdate"] = struct{}{}
+		actions["engineVersionConstraintUpdate"] = struct{}{}
 	case types.VolumeStateFaulted:
+		actions["preferredHostUpdate"] = struct{}{}
+		actions["autoReattachUpdate"] = struct{}{}
+		actions["backupCompressionUpdate"] = struct{}{}
+		actions["pinReplicasUpdate"] = struct{}{}
+		actions["engineVersionConstraintUpdate"] = struct{}{}
+	}
+	if v.Mode == types.VolumeModeLocal {
+		delete(actions, "preferredHostUpdate")
 	}
 
 	for action := range actions {
@@ -378,6 +1260,345 @@ func toSnapshotResource(s *types.SnapshotInfo) *Snapshot {
 	}
 }
 
+func toDiskUsageResource(u *types.DiskUsage) *DiskUsage {
+	return &DiskUsage{
+		Resource: client.Resource{
+			Id:   u.Path,
+			Type: "diskUsage",
+		},
+		DiskUsage: *u,
+	}
+}
+
+func toDrainProgressResource(p *types.DrainProgress) *DrainProgress {
+	return &DrainProgress{
+		Resource: client.Resource{
+			Id:   p.HostID,
+			Type: "drainProgress",
+		},
+		DrainProgress: *p,
+	}
+}
+
+func toReconcileStatusResource(paused bool) *ReconcileStatus {
+	return &ReconcileStatus{
+		Resource: client.Resource{
+			Id:   "reconcile",
+			Type: "reconcileStatus",
+		},
+		Paused: paused,
+	}
+}
+
+func toVolumeEventCollection(name string, events []*types.VolumeEvent) *client.GenericCollection {
+	data := []interface{}{}
+	for i, e := range events {
+		data = append(data, &VolumeEvent{
+			Resource: client.Resource{
+				Id:   fmt.Sprintf("%v-%v", name, i),
+				Type: "volumeEvent",
+			},
+			VolumeEvent: *e,
+		})
+	}
+	return &client.GenericCollection{Data: data, Collection: client.Collection{ResourceType: "volumeEvent"}}
+}
+
+func toStateTransitionCollection(name string, transitions []types.StateTransition) *client.GenericCollection {
+	data := []interface{}{}
+	for i, t := range transitions {
+		data = append(data, &StateTransition{
+			Resource: client.Resource{
+				Id:   fmt.Sprintf("%v-%v", name, i),
+				Type: "stateTransition",
+			},
+			StateTransition: t,
+		})
+	}
+	return &client.GenericCollection{Data: data, Collection: client.Collection{ResourceType: "stateTransition"}}
+}
+
+func toEngineStatusResource(status *types.EngineStatus) *EngineStatus {
+	return &EngineStatus{
+		Resource: client.Resource{
+			Id:   status.InstanceName,
+			Type: "engineStatus",
+		},
+		EngineStatus: *status,
+	}
+}
+
+func toEventRecorderStatusResource(status *types.EventRecorderStatus) *EventRecorderStatus {
+	return &EventRecorderStatus{
+		Resource: client.Resource{
+			Id:   "events",
+			Type: "eventRecorderStatus",
+		},
+		EventRecorderStatus: *status,
+	}
+}
+
+func toRuntimeConfigResource(config *types.RuntimeConfig) *RuntimeConfig {
+	return &RuntimeConfig{
+		Resource: client.Resource{
+			Id:   "config",
+			Type: "runtimeConfig",
+		},
+		RuntimeConfig: *config,
+	}
+}
+
+func toLockCollection(locks []*types.LockInfo, now time.Time) *client.GenericCollection {
+	data := []interface{}{}
+	for _, l := range locks {
+		remaining := time.Duration(0)
+		if expires, err := util.ParseTimeZ(l.Expires); err == nil && now.Before(expires) {
+			remaining = expires.Sub(now)
+		}
+		data = append(data, &Lock{
+			Resource: client.Resource{
+				Id:   l.Name,
+				Type: "lock",
+			},
+			LockInfo:     *l,
+			TTLRemaining: remaining.Round(time.Second).String(),
+		})
+	}
+	return &client.GenericCollection{Data: data, Collection: client.Collection{ResourceType: "lock"}}
+}
+
+func toControllerMigrationResource(name string, migration *types.ControllerMigration) *ControllerMigration {
+	return &ControllerMigration{
+		Resource: client.Resource{
+			Id:   name,
+			Type: "controllerMigration",
+		},
+		ControllerMigration: *migration,
+	}
+}
+
+func toRunnerStatusCollection(statuses []*runner.Status) *client.GenericCollection {
+	data := []interface{}{}
+	for _, status := range statuses {
+		data = append(data, &RunnerStatus{
+			Resource: client.Resource{
+				Id:   status.Name,
+				Type: "runnerStatus",
+			},
+			Status: *status,
+		})
+	}
+	return &client.GenericCollection{Data: data, Collection: client.Collection{ResourceType: "runnerStatus"}}
+}
+
+func toSlowOperationCollection(ops []*types.SlowOperation) *client.GenericCollection {
+	data := []interface{}{}
+	for _, op := range ops {
+		data = append(data, &SlowOperation{
+			Resource: client.Resource{
+				Id:   op.ID,
+				Type: "slowOperation",
+			},
+			SlowOperation: *op,
+		})
+	}
+	return &client.GenericCollection{Data: data, Collection: client.Collection{ResourceType: "slowOperation"}}
+}
+
+func toInstanceRenderResource(render *types.InstanceRender) *InstanceRender {
+	return &InstanceRender{
+		Resource: client.Resource{
+			Id:   render.InstanceName,
+			Type: "instanceRender",
+		},
+		InstanceRender: *render,
+	}
+}
+
+func toBackupReadStatsResource(stats *types.BackupReadStats) *BackupReadStats {
+	return &BackupReadStats{
+		Resource: client.Resource{
+			Id:   "backupReads",
+			Type: "backupReadStats",
+		},
+		BackupReadStats: *stats,
+	}
+}
+
+func toInfoResource(hostID string, tls *types.TLSStatus) *Info {
+	return &Info{
+		Resource: client.Resource{
+			Id:   hostID,
+			Type: "info",
+		},
+		HostID:      hostID,
+		InternalTLS: *tls,
+	}
+}
+
+func toVolumeGroupResource(g *types.VolumeGroup, status *types.VolumeGroupStatus) *VolumeGroup {
+	volumes := g.Volumes
+	if volumes == nil {
+		volumes = []string{}
+	}
+	return &VolumeGroup{
+		Resource: client.Resource{
+			Id:      g.Name,
+			Type:    "volumeGroup",
+			Actions: map[string]string{},
+		},
+		Name:     g.Name,
+		Volumes:  volumes,
+		Selector: g.Selector,
+		Created:  g.Created,
+		Status:   status,
+	}
+}
+
+func toVolumeGroupCollection(groups map[string]*types.VolumeGroup) *client.GenericCollection {
+	data := []interface{}{}
+	for _, g := range groups {
+		data = append(data, toVolumeGroupResource(g, nil))
+	}
+	return &client.GenericCollection{Data: data}
+}
+
+func toVolumeGroupOperation(name, snapshot string, results []*types.VolumeGroupResult) *VolumeGroupOperation {
+	if results == nil {
+		results = []*types.VolumeGroupResult{}
+	}
+	return &VolumeGroupOperation{
+		Resource: client.Resource{
+			Id:   name,
+			Type: "volumeGroupOperation",
+		},
+		Snapshot: snapshot,
+		Results:  results,
+	}
+}
+
+func toRawRecordCollection(records []*types.RawRecord) *client.GenericCollection {
+	data := []interface{}{}
+	for _, r := range records {
+		data = append(data, &RawRecord{
+			Resource: client.Resource{
+				Id:   r.Key,
+				Type: "rawRecord",
+			},
+			RawRecord: *r,
+		})
+	}
+	return &client.GenericCollection{Data: data, Collection: client.Collection{ResourceType: "rawRecord"}}
+}
+
+func toGCResultResource(result *types.GCResult) *GCResult {
+	return &GCResult{
+		Resource: client.Resource{
+			Id:   "backup-gc",
+			Type: "gcResult",
+		},
+		GCResult: *result,
+	}
+}
+
+func toCapacityCheckResultResource(result *types.CapacityCheckResult) *CapacityCheckResult {
+	return &CapacityCheckResult{
+		Resource: client.Resource{
+			Id:   "capacity",
+			Type: "capacityCheckResult",
+		},
+		CapacityCheckResult: *result,
+	}
+}
+
+func toConsistencyReportResource(report *types.ConsistencyReport) *ConsistencyReport {
+	return &ConsistencyReport{
+		Resource: client.Resource{
+			Id:   "consistency",
+			Type: "consistencyReport",
+		},
+		ConsistencyReport: *report,
+	}
+}
+
+func toAPITokenResource(token *types.APIToken, secret string) *APIToken {
+	return &APIToken{
+		Resource: client.Resource{
+			Id:   token.ID,
+			Type: "apiToken",
+		},
+		Name:    token.Name,
+		Role:    string(token.Role),
+		Hash:    token.Hash,
+		Created: token.Created,
+		Source:  token.Source,
+		Token:   secret,
+	}
+}
+
+func toAPITokenCollection(tokens []*types.APIToken) *client.GenericCollection {
+	data := []interface{}{}
+	for _, t := range tokens {
+		data = append(data, toAPITokenResource(t, ""))
+	}
+	return &client.GenericCollection{Data: data, Collection: client.Collection{ResourceType: "apiToken"}}
+}
+
+func toWriterReportResource(report *types.WriterReport) *WriterReport {
+	return &WriterReport{
+		Resource: client.Resource{
+			Id:   "writers",
+			Type: "writerReport",
+		},
+		WriterReport: *report,
+	}
+}
+
+func toBootstrapReportResource(report *types.BootstrapReport) *BootstrapReport {
+	return &BootstrapReport{
+		Resource: client.Resource{
+			Id:   "bootstrap",
+			Type: "bootstrapReport",
+		},
+		BootstrapReport: *report,
+	}
+}
+
+func toSchedulerStatusResource(status *types.SchedulerStatus) *SchedulerStatus {
+	r := &SchedulerStatus{
+		Resource: client.Resource{
+			Id:   "scheduler",
+			Type: "schedulerStatus",
+		},
+		Queued:   status.Queued,
+		InFlight: status.InFlight,
+		Items:    status.Items,
+		Latency:  map[string]*ScheduleLatency{},
+
+		Timeouts:    map[string]int{},
+		Abandoned:   status.Abandoned,
+		Leaked:      status.Leaked,
+		Quarantined: status.Quarantined,
+
+		Unschedulable: map[string]int{},
+	}
+	for action, count := range status.Timeouts {
+		r.Timeouts[string(action)] = count
+	}
+	for reason, count := range status.Unschedulable {
+		r.Unschedulable[string(reason)] = count
+	}
+	for action, l := range status.Latency {
+		r.Latency[string(action)] = &ScheduleLatency{
+			Count:   l.Count,
+			Average: l.Average.String(),
+			Max:     l.Max.String(),
+			Last:    l.Last.String(),
+		}
+	}
+	return r
+}
+
 func toBgTaskRes(bt *types.BgTask) *BgTask {
 	return &BgTask{
 		Resource: client.Resource{
@@ -413,16 +1634,54 @@ func toHostCollection(hosts map[string]*types.HostInfo) *client.GenericCollectio
 }
 
 func toHostResource(h *types.HostInfo) *Host {
-	return &Host{
+	r := &Host{
 		Resource: client.Resource{
 			Id:      h.UUID,
 			Type:    "host",
 			Actions: map[string]string{},
 		},
-		UUID:    h.UUID,
-		Name:    h.Name,
-		Address: h.Address,
+		Unschedulable: h.Unschedulable,
+		FailureDomain: h.FailureDomain,
+		Role:          string(h.Role),
+		UUID:          h.UUID,
+		Name:          h.Name,
+		Address:       h.Address,
+		Heartbeat:     h.Heartbeat,
+		ClockSkew:     h.ClockSkew.String(),
+		SkewDetected:  h.SkewDetected,
+
+		Conflicted:     h.Conflicted,
+		ConflictNonces: h.ConflictNonces,
+
+		Maintenance: h.Maintenance,
+
+		Recovered: h.Recovered,
+	}
+	if h.RecoveryCooldown > 0 {
+		r.RecoveryCooldown = h.RecoveryCooldown.String()
+	}
+	r.SchedulingWeight = h.SchedulingWeight
+	if r.SchedulingWeight <= 0 {
+		r.SchedulingWeight = 1
 	}
+	if d := h.Detail; d != nil {
+		online, schedulable := d.Online, d.Schedulable
+		r.Online = &online
+		r.Schedulable = &schedulable
+		r.Conditions = []string{}
+		for _, c := range d.Conditions {
+			r.Conditions = append(r.Conditions, string(c))
+		}
+		r.UnschedulableReasons = []string{}
+		for _, c := range d.UnschedulableReasons {
+			r.UnschedulableReasons = append(r.UnschedulableReasons, string(c))
+		}
+		r.Controllers = d.Controllers
+		r.Replicas = d.Replicas
+		r.ReservedBytes = d.ReservedBytes
+		r.AvailableBytes = d.AvailableBytes
+	}
+	return r
 }
 
 func toBackupVolumeResource(bv *types.BackupVolumeInfo, apiContext *api.ApiContext) *BackupVolume {
@@ -480,24 +1739,29 @@ func toBackupCollection(bs []*types.BackupInfo) *client.GenericCollection {
 type Server struct {
 	man       types.VolumeManager
 	sl        types.ServiceLocator
+	rev       types.StateRevisioner
 	proxy     http.Handler
 	fwd       *Fwd
 	snapshots *SnapshotHandlers
 	settings  *SettingsHandlers
 	backups   *BackupsHandlers
+
+	draining int32
 }
 
-func NewServer(m types.VolumeManager, sl types.ServiceLocator, proxy http.Handler) *Server {
+func NewServer(m types.VolumeManager, orc types.Orchestrator, proxy http.Handler) *Server {
 	return &Server{
 		man:   m,
-		sl:    sl,
+		sl:    orc,
+		rev:   orc,
 		proxy: proxy,
-		fwd:   &Fwd{sl, proxy},
+		fwd:   &Fwd{orc, proxy},
 		snapshots: &SnapshotHandlers{
 			m,
 		},
 		settings: &SettingsHandlers{
 			m.Settings(),
+			m,
 		},
 		backups: &BackupsHandlers{
 			m,
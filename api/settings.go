@@ -1,17 +1,24 @@
 package api
 
 import (
+	"encoding/json"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/rancher/go-rancher/api"
 
+	"github.com/rancher/longhorn-manager/backups"
 	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
 )
 
 type SettingsHandlers struct {
 	settings types.Settings
+	man      types.VolumeManager
 }
 
 func (s *SettingsHandlers) List(w http.ResponseWriter, req *http.Request) error {
@@ -33,17 +40,94 @@ func (s *SettingsHandlers) Get(w http.ResponseWriter, req *http.Request) error {
 	if err != nil || si == nil {
 		return errors.Wrap(err, "fail to read settings")
 	}
-	var value string
+	value, err := settingValue(si, name)
+	if err != nil {
+		return err
+	}
+	apiContext.Write(toSettingResource(name, value))
+	return nil
+}
+
+// Definitions lists the definitions of all the settings with their current
+// values
+func (s *SettingsHandlers) Definitions(w http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+
+	definitions, err := s.man.ListSettingsDefinitions()
+	if err != nil {
+		return errors.Wrap(err, "fail to list settings definitions")
+	}
+	si, err := s.settings.GetSettings()
+	if err != nil || si == nil {
+		return errors.Wrap(err, "fail to read settings")
+	}
+	collection, err := toSettingDefinitionCollection(definitions, si)
+	if err != nil {
+		return err
+	}
+	apiContext.Write(collection)
+	return nil
+}
+
+func settingValue(si *types.SettingsInfo, name string) (string, error) {
 	switch name {
 	case "backupTarget":
-		value = si.BackupTarget
+		return si.BackupTarget, nil
 	case "engineImage":
-		value = si.EngineImage
+		return si.EngineImage, nil
+	case "rehomeWindow":
+		return si.RehomeWindow, nil
+	case "rehomeWindowLive":
+		return strconv.FormatBool(si.RehomeWindowLive), nil
+	case "autoReattach":
+		return string(si.AutoReattach), nil
+	case "snapshotMaxCount":
+		return strconv.Itoa(si.SnapshotMaxCount), nil
+	case "snapshotMaxAge":
+		return si.SnapshotMaxAge, nil
+	case "snapshotPruneStrategy":
+		return string(si.SnapshotPruneStrategy), nil
+	case "clockSkewThreshold":
+		return si.ClockSkewThreshold, nil
+	case "autoDetach":
+		return strconv.FormatBool(si.AutoDetach), nil
+	case "autoDetachTimeout":
+		return si.AutoDetachTimeout, nil
+	case "replicaRestartPolicy":
+		return string(si.ReplicaRestartPolicy), nil
+	case "controllerRestartPolicy":
+		return string(si.ControllerRestartPolicy), nil
+	case "replicaQuota":
+		return strconv.FormatBool(si.ReplicaQuota), nil
+	case "replicaQuotaOverhead":
+		return strconv.Itoa(si.ReplicaQuotaOverhead), nil
+	case "instanceEnv":
+		if len(si.InstanceEnv) == 0 {
+			return "", nil
+		}
+		value, err := json.Marshal(si.InstanceEnv)
+		return string(value), err
+	case "controllerRecreate":
+		return strconv.FormatBool(si.ControllerRecreate), nil
+	case "controllerUnresponsiveThreshold":
+		return si.ControllerUnresponsiveThreshold, nil
+	case "backupCompression":
+		return string(si.BackupCompression), nil
+	case "healthcheckInterval":
+		return si.HealthcheckInterval, nil
+	case "healthcheckRetries":
+		return strconv.Itoa(si.HealthcheckRetries), nil
+	case "backupConcurrencyLimit":
+		return strconv.Itoa(si.BackupConcurrencyLimit), nil
+	case "backupTargetConcurrencyLimit":
+		return strconv.Itoa(si.BackupTargetConcurrencyLimit), nil
+	case "recordRetentionSucceeded":
+		return si.RecordRetentionSucceeded, nil
+	case "recordRetentionFailed":
+		return si.RecordRetentionFailed, nil
 	default:
-		return errors.Errorf("invalid setting name %v", name)
+		return "", errors.Errorf("invalid setting name %v", name)
 	}
-	apiContext.Write(toSettingResource(name, value))
-	return nil
 }
 
 func (s *SettingsHandlers) Set(w http.ResponseWriter, req *http.Request) error {
@@ -56,23 +140,329 @@ func (s *SettingsHandlers) Set(w http.ResponseWriter, req *http.Request) error {
 
 	name := mux.Vars(req)["name"]
 
-	si, err := s.settings.GetSettings()
-	if err != nil || si == nil {
-		return errors.Wrap(err, "fail to read settings")
+	attached, err := s.attachedVolumes()
+	if err != nil {
+		return err
+	}
+	if err := s.settings.UpdateSettings(requestAuthor(req), func(si *types.SettingsInfo) error {
+		before := *si
+		if err := applySetting(si, name, setting.Value); err != nil {
+			return err
+		}
+		return checkHighImpactChange(&before, si, attached, setting.Confirm)
+	}); err != nil {
+		return errors.Wrapf(err, "fail to set setting %v", name)
+	}
+
+	apiContext.Write(toSettingResource(name, setting.Value))
+	return nil
+}
+
+// SetAll changes the settings in the input at once, none of them is changed
+// if any is invalid
+func (s *SettingsHandlers) SetAll(w http.ResponseWriter, req *http.Request) error {
+	var input SettingsInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read settingsInput")
+	}
+
+	attached, err := s.attachedVolumes()
+	if err != nil {
+		return err
+	}
+	if err := s.settings.UpdateSettings(requestAuthor(req), func(si *types.SettingsInfo) error {
+		before := *si
+		for name, value := range input.Settings {
+			if err := applySetting(si, name, value); err != nil {
+				return err
+			}
+		}
+		return checkHighImpactChange(&before, si, attached, input.Confirm)
+	}); err != nil {
+		return errors.Wrap(err, "fail to set settings")
 	}
+	return s.List(w, req)
+}
 
+func applySetting(si *types.SettingsInfo, name, value string) error {
 	switch name {
 	case "backupTarget":
-		si.BackupTarget = setting.Value
+		if err := backups.ValidateTargetTemplate(value); err != nil {
+			return err
+		}
+		si.BackupTarget = value
 	case "engineImage":
-		si.EngineImage = setting.Value
+		si.EngineImage = value
+	case "rehomeWindow":
+		if value != "" {
+			if _, err := util.ParseTimeWindow(value); err != nil {
+				return err
+			}
+		}
+		si.RehomeWindow = value
+	case "rehomeWindowLive":
+		live, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value for setting %v", name)
+		}
+		si.RehomeWindowLive = live
+	case "autoReattach":
+		switch policy := types.AutoReattachPolicy(value); policy {
+		case types.AutoReattachPolicyDefault, types.AutoReattachPolicyDisabled,
+			types.AutoReattachPolicyAlways, types.AutoReattachPolicyIfClean:
+			si.AutoReattach = policy
+		default:
+			return errors.Errorf("invalid value %v for setting %v", value, name)
+		}
+	case "snapshotMaxCount":
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return errors.Errorf("invalid value %v for setting %v, expecting a number such as 32", value, name)
+		}
+		si.SnapshotMaxCount = count
+	case "snapshotMaxAge":
+		if value != "" {
+			if _, err := time.ParseDuration(value); err != nil {
+				return errors.Wrapf(err, "invalid value for setting %v, expecting a duration such as 720h", name)
+			}
+		}
+		si.SnapshotMaxAge = value
+	case "snapshotPruneStrategy":
+		switch strategy := types.SnapshotPruneStrategy(value); strategy {
+		case types.SnapshotPruneDefault, types.SnapshotPruneBeforeCreate, types.SnapshotPruneAfterCreate:
+			si.SnapshotPruneStrategy = strategy
+		default:
+			return errors.Errorf("invalid value %v for setting %v", value, name)
+		}
+	case "clockSkewThreshold":
+		if value != "" {
+			if threshold, err := time.ParseDuration(value); err != nil || threshold <= 0 {
+				return errors.Errorf("invalid value %v for setting %v, expecting a duration such as 30s", value, name)
+			}
+		}
+		si.ClockSkewThreshold = value
+	case "autoDetach":
+		autoDetach, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value for setting %v", name)
+		}
+		si.AutoDetach = autoDetach
+	case "autoDetachTimeout":
+		if value != "" {
+			if timeout, err := time.ParseDuration(value); err != nil || timeout <= 0 {
+				return errors.Errorf("invalid value %v for setting %v, expecting a duration such as 1h", value, name)
+			}
+		}
+		si.AutoDetachTimeout = value
+	case "replicaRestartPolicy", "controllerRestartPolicy":
+		policy := types.RestartPolicy(value)
+		switch policy {
+		case types.RestartPolicyDefault, types.RestartPolicyNo,
+			types.RestartPolicyUnlessStopped, types.RestartPolicyAlways:
+		default:
+			return errors.Errorf("invalid value %v for setting %v", value, name)
+		}
+		if name == "replicaRestartPolicy" {
+			si.ReplicaRestartPolicy = policy
+		} else {
+			si.ControllerRestartPolicy = policy
+		}
+	case "replicaQuota":
+		quota, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value for setting %v", name)
+		}
+		si.ReplicaQuota = quota
+	case "replicaQuotaOverhead":
+		overhead, err := strconv.Atoi(value)
+		if err != nil || overhead < 0 {
+			return errors.Errorf("invalid value %v for setting %v, expecting a percentage such as 100", value, name)
+		}
+		si.ReplicaQuotaOverhead = overhead
+	case "instanceEnv":
+		env := map[string]string{}
+		if value != "" {
+			if err := json.Unmarshal([]byte(value), &env); err != nil {
+				return errors.Errorf("invalid value %v for setting %v, expecting a JSON object of strings", value, name)
+			}
+		}
+		if err := util.ValidateEnv(env); err != nil {
+			return errors.Wrapf(err, "invalid value for setting %v", name)
+		}
+		si.InstanceEnv = env
+	case "controllerRecreate":
+		recreate, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value for setting %v", name)
+		}
+		si.ControllerRecreate = recreate
+	case "controllerUnresponsiveThreshold":
+		if value != "" {
+			if threshold, err := time.ParseDuration(value); err != nil || threshold <= 0 {
+				return errors.Errorf("invalid value %v for setting %v, expecting a duration such as 30s", value, name)
+			}
+		}
+		si.ControllerUnresponsiveThreshold = value
+	case "backupCompression":
+		compression := types.BackupCompression(value)
+		if compression != types.BackupCompressionDefault && !compression.Valid() {
+			return errors.Errorf("invalid value %v for setting %v, expecting one of %v", value, name, types.BackupCompressions)
+		}
+		si.BackupCompression = compression
+	case "healthcheckInterval":
+		if value != "" {
+			if interval, err := time.ParseDuration(value); err != nil || interval <= 0 {
+				return errors.Errorf("invalid value %v for setting %v, expecting a duration such as 10s", value, name)
+			}
+		}
+		si.HealthcheckInterval = value
+	case "healthcheckRetries":
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return errors.Errorf("invalid value %v for setting %v, expecting a number of retries such as 3", value, name)
+		}
+		si.HealthcheckRetries = retries
+	case "backupConcurrencyLimit":
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return errors.Errorf("invalid value %v for setting %v, expecting a number of backups such as 4, 0 for unlimited", value, name)
+		}
+		si.BackupConcurrencyLimit = limit
+	case "backupTargetConcurrencyLimit":
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return errors.Errorf("invalid value %v for setting %v, expecting a number of backups such as 4, 0 for unlimited", value, name)
+		}
+		si.BackupTargetConcurrencyLimit = limit
+	case "recordRetentionSucceeded", "recordRetentionFailed":
+		if value != "" {
+			if age, err := time.ParseDuration(value); err != nil || age < 0 {
+				return errors.Errorf("invalid value %v for setting %v, expecting a duration such as 168h, 0 for no limit", value, name)
+			}
+		}
+		if name == "recordRetentionSucceeded" {
+			si.RecordRetentionSucceeded = value
+		} else {
+			si.RecordRetentionFailed = value
+		}
 	default:
-		return errors.Wrapf(err, "invalid setting name %v", name)
+		return errors.Errorf("invalid setting name %v", name)
 	}
-	if err := s.settings.SetSettings(si); err != nil {
-		return errors.Wrapf(err, "fail to set settings %v", si)
+	return nil
+}
+
+func (s *SettingsHandlers) attachedVolumes() ([]string, error) {
+	volumes, err := s.man.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to list volumes")
 	}
+	attached := []string{}
+	for _, v := range volumes {
+		if v.Controller != nil {
+			attached = append(attached, v.Name)
+		}
+	}
+	return attached, nil
+}
 
-	apiContext.Write(toSettingResource(name, setting.Value))
+// checkHighImpactChange requires confirmation for changing the settings
+// which affect attached volumes
+func checkHighImpactChange(before, after *types.SettingsInfo, attached []string, confirmed bool) error {
+	if confirmed || len(attached) == 0 {
+		return nil
+	}
+	if before.EngineImage != after.EngineImage || before.BackupTarget != after.BackupTarget {
+		return errors.Errorf("changing engineImage or backupTarget while volumes %v are attached requires confirm", attached)
+	}
+	return nil
+}
+
+// requestAuthor identifies who changes the settings, by the name of the
+// token authenticating the request, or else by the address of the client
+func requestAuthor(req *http.Request) string {
+	if token := requestToken(req); token != nil {
+		return "token " + token.Name
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (s *SettingsHandlers) History(w http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+
+	history, err := s.settings.GetSettingsHistory()
+	if err != nil {
+		return errors.Wrap(err, "fail to read settings history")
+	}
+	counts, err := s.settings.SettingsHistoryCounts()
+	if err != nil {
+		return errors.Wrap(err, "fail to count settings history")
+	}
+	setRecordCounts(w, counts)
+	apiContext.Write(toSettingsRevisionCollection(history))
 	return nil
 }
+
+// KeepRevision exempts the revision from the limit of the history and the
+// record GC, or not
+func (s *SettingsHandlers) KeepRevision(w http.ResponseWriter, req *http.Request) error {
+	var input RecordKeepInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read recordKeepInput")
+	}
+	revision, err := strconv.ParseInt(mux.Vars(req)["revision"], 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "invalid settings revision %v", mux.Vars(req)["revision"])
+	}
+	if err := s.settings.KeepSettingsRevision(revision, input.Keep); err != nil {
+		return errors.Wrapf(err, "fail to keep settings revision %v", revision)
+	}
+	return s.History(w, req)
+}
+
+func (s *SettingsHandlers) Rollback(w http.ResponseWriter, req *http.Request) error {
+	var input SettingsRollbackInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read settingsRollbackInput")
+	}
+
+	revision, err := strconv.ParseInt(mux.Vars(req)["revision"], 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "invalid settings revision %v", mux.Vars(req)["revision"])
+	}
+	history, err := s.settings.GetSettingsHistory()
+	if err != nil {
+		return errors.Wrap(err, "fail to read settings history")
+	}
+	var target *types.SettingsInfo
+	for _, r := range history {
+		if r.Revision == revision {
+			target = &r.Settings
+		}
+	}
+	if target == nil {
+		return errors.Errorf("cannot find settings revision %v in the history", revision)
+	}
+
+	attached, err := s.attachedVolumes()
+	if err != nil {
+		return err
+	}
+	if err := s.settings.UpdateSettings(requestAuthor(req), func(si *types.SettingsInfo) error {
+		before := *si
+		*si = *target
+		return checkHighImpactChange(&before, si, attached, input.Confirm)
+	}); err != nil {
+		return errors.Wrapf(err, "fail to roll back settings to revision %v", revision)
+	}
+	return s.List(w, req)
+}
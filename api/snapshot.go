@@ -201,21 +201,12 @@ func (sh *SnapshotHandlers) Backup(w http.ResponseWriter, req *http.Request) err
 		return errors.Errorf("volume name required")
 	}
 
-	settings, err := sh.man.Settings().GetSettings()
-	if err != nil || settings == nil {
-		return errors.New("cannot backup: unable to read settings")
-	}
-	backupTarget := settings.BackupTarget
-	if backupTarget == "" {
-		return errors.New("cannot backup: backupTarget not set")
-	}
-
-	backups, err := sh.man.VolumeBackupOps(volName)
+	backupTarget, err := sh.man.VolumeBackupTarget(volName)
 	if err != nil {
-		return errors.Wrapf(err, "error getting VolumeBackupOps for volume '%s'", volName)
+		return err
 	}
 
-	if err := backups.StartBackup(input.Name, backupTarget); err != nil {
+	if err := sh.man.StartBackup(volName, &types.BackupBgTask{Snapshot: input.Name, BackupTarget: backupTarget}); err != nil {
 		return errors.Wrapf(err, "error creating backup: snapshot '%s', volume '%s', dest '%s'", input.Name, volName, backupTarget)
 	}
 	logrus.Debugf("success: started backup: snapshot '%s', volume '%s', dest '%s'", input.Name, volName, backupTarget)
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+const (
+	// StaleSinceHeader is set on the GET responses while the state is read
+	// from the cache of the store, to when the reads started being stale
+	StaleSinceHeader = "X-Longhorn-Stale-Since"
+
+	staleWarning = `110 - "Response is Stale"`
+)
+
+// StaleHandler marks the GET responses as stale while the reads of the state
+// are served from the cache of the store, e.g. while etcd is unreachable
+func StaleHandler(rev types.StateRevisioner, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		next.ServeHTTP(&staleWriter{ResponseWriter: rw, rev: rev}, req)
+	})
+}
+
+// staleWriter checks the staleness once the response is written, after the
+// reads of the request
+type staleWriter struct {
+	http.ResponseWriter
+
+	rev         types.StateRevisioner
+	wroteHeader bool
+}
+
+func (w *staleWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if since := w.rev.StateStaleSince(); !since.IsZero() {
+			w.Header().Set("Warning", staleWarning)
+			w.Header().Set(StaleSinceHeader, util.FormatTimeZ(since))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *staleWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
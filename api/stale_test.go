@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaleHeader(t *testing.T) {
+	assert := require.New(t)
+
+	rev := &fakeRevisioner{revisions: map[string]string{}}
+	handler := Handler(&Server{rev: rev, fwd: &Fwd{}})
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/v1/ready", nil))
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Empty(rw.Header().Get("Warning"))
+	assert.Empty(rw.Header().Get(StaleSinceHeader))
+
+	// etcd is unreachable, the reads are served from the cache
+	rev.Lock()
+	rev.staleSince = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	rev.Unlock()
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/v1/ready", nil))
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Equal(`110 - "Response is Stale"`, rw.Header().Get("Warning"))
+	assert.Equal("2017-01-01T00:00:00Z", rw.Header().Get(StaleSinceHeader))
+
+	// only the reads are stale
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("POST", "/v1/nothing", nil))
+	assert.Empty(rw.Header().Get(StaleSinceHeader))
+
+	h, _ := newETagTestHandler(rev)
+	handler = StaleHandler(rev, h)
+	rw = doETagRequest(handler, "GET", "/v1/volumes/vol", `""`)
+	assert.Equal(http.StatusNotModified, rw.Code)
+	assert.Equal("2017-01-01T00:00:00Z", rw.Header().Get(StaleSinceHeader))
+}
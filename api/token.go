@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/rancher/go-rancher/api"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func (s *Server) ListAPITokens(rw http.ResponseWriter, req *http.Request) error {
+	tokens, err := s.man.ListAPITokens()
+	if err != nil {
+		return errors.Wrap(err, "fail to list API tokens")
+	}
+	api.GetApiContext(req).Write(toAPITokenCollection(tokens))
+	return nil
+}
+
+// CreateAPIToken answers the new token with its secret, the only time it's
+// returned
+func (s *Server) CreateAPIToken(rw http.ResponseWriter, req *http.Request) error {
+	var input APITokenInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read apiTokenInput")
+	}
+	token, secret, err := s.man.CreateAPIToken(input.Name, types.APIRole(input.Role), input.Namespace)
+	if err != nil {
+		return errors.Wrap(err, "fail to create API token")
+	}
+	apiContext.Write(toAPITokenResource(token, secret))
+	return nil
+}
+
+func (s *Server) RevokeAPIToken(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["id"]
+	if err := s.man.RevokeAPIToken(id); err != nil {
+		return errors.Wrap(err, "fail to revoke API token")
+	}
+	return nil
+}
@@ -1,7 +1,11 @@
 package api
 
 import (
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -18,13 +22,58 @@ func (s *Server) ListVolume(rw http.ResponseWriter, req *http.Request) error {
 
 	resp := &client.GenericCollection{}
 
-	volumes, err := s.man.List()
+	var volumes []*types.VolumeInfo
+	query := req.URL.Query()
+	filter, err := volumeFilter(query)
 	if err != nil {
+		return err
+	}
+	if image := query.Get("engineImage"); image != "" {
+		if filter != nil || query.Get("limit") != "" {
+			return errors.New("unable to list: the engine image cannot be combined with the filters or limit")
+		}
+		if volumes, err = s.man.ListVolumesUsingImage(image); err != nil {
+			return errors.Wrapf(err, "unable to list")
+		}
+	} else if filter != nil {
+		if query.Get("limit") != "" {
+			return errors.New("unable to list: the filters cannot be combined with limit")
+		}
+		if volumes, err = s.man.ListVolumesFiltered(*filter); err != nil {
+			return errors.Wrapf(err, "unable to list")
+		}
+	} else if query.Get("limit") != "" {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 {
+			return errors.Errorf("invalid limit %v, expecting a positive number", query.Get("limit"))
+		}
+		marker := query.Get("marker")
+		next := ""
+		if volumes, next, err = s.man.ListPage(marker, limit); err != nil {
+			return errors.Wrapf(err, "unable to list")
+		}
+		pageLimit := int64(limit)
+		resp.Pagination = &client.Pagination{Marker: marker, Limit: &pageLimit, Partial: next != ""}
+		if next != "" {
+			nextQuery := url.Values{"limit": {strconv.Itoa(limit)}, "marker": {next}}
+			resp.Pagination.Next = apiContext.UrlBuilder.Collection("volume") + "?" + nextQuery.Encode()
+		}
+	} else if volumes, err = s.man.List(); err != nil {
 		return errors.Wrapf(err, "unable to list")
 	}
 
+	var hosts map[string]*types.HostInfo
+	if volumeDetail(req) {
+		if hosts, err = s.man.GetHosts(instanceHostIDs(volumes)); err != nil {
+			return errors.Wrapf(err, "unable to list")
+		}
+	}
 	for _, v := range volumes {
-		resp.Data = append(resp.Data, toVolumeResource(v, apiContext))
+		r := toVolumeResource(v, apiContext)
+		if hosts != nil {
+			setInstanceHosts(r, hosts)
+		}
+		resp.Data = append(resp.Data, r)
 	}
 	resp.ResourceType = "volume"
 	resp.CreateTypes = map[string]string{
@@ -35,6 +84,76 @@ func (s *Server) ListVolume(rw http.ResponseWriter, req *http.Request) error {
 	return nil
 }
 
+// volumeDetail is true with ?detail=true, for the hosts of the instances
+// in the list of volumes
+func volumeDetail(req *http.Request) bool {
+	detail, _ := strconv.ParseBool(req.URL.Query().Get("detail"))
+	return detail
+}
+
+// instanceHostIDs lists the hosts of the instances of the volumes, once each
+func instanceHostIDs(volumes []*types.VolumeInfo) []string {
+	seen := map[string]bool{}
+	ids := []string{}
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, v := range volumes {
+		if v.Controller != nil {
+			add(v.Controller.HostID)
+		}
+		for _, r := range v.Replicas {
+			add(r.HostID)
+		}
+	}
+	return ids
+}
+
+// setInstanceHosts fills in the name and address of the hosts of the
+// instances, the hosts not found are left out
+func setInstanceHosts(v *Volume, hosts map[string]*types.HostInfo) {
+	set := func(instance *Instance) {
+		if host := hosts[instance.HostID]; host != nil {
+			instance.HostName = host.Name
+			instance.HostAddress = host.Address
+		}
+	}
+	if v.Controller != nil {
+		set(&v.Controller.Instance)
+	}
+	for i := range v.Replicas {
+		set(&v.Replicas[i].Instance)
+	}
+}
+
+// volumeFilter reads the filters of the volumes listed, e.g.
+// ?state=attached&health=degraded&host=<id>&selector=team=billing,tier=db.
+// It's nil if there are none.
+func volumeFilter(query url.Values) (*types.VolumeFilter, error) {
+	filter := &types.VolumeFilter{
+		State:  types.VolumeAttachState(query.Get("state")),
+		Health: types.VolumeState(query.Get("health")),
+		HostID: query.Get("host"),
+	}
+	if selector := query.Get("selector"); selector != "" {
+		filter.Selector = map[string]string{}
+		for _, term := range strings.Split(selector, ",") {
+			kv := strings.SplitN(term, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, errors.Errorf("invalid selector %v, expecting labels such as team=billing,tier=db", selector)
+			}
+			filter.Selector[kv[0]] = kv[1]
+		}
+	}
+	if filter.State == "" && filter.Health == "" && filter.HostID == "" && filter.Selector == nil {
+		return nil, nil
+	}
+	return filter, nil
+}
+
 func (s *Server) GetVolume(rw http.ResponseWriter, req *http.Request) error {
 	apiContext := api.GetApiContext(req)
 	id := mux.Vars(req)["name"]
@@ -54,6 +173,137 @@ func (s *Server) GetVolume(rw http.ResponseWriter, req *http.Request) error {
 	return nil
 }
 
+// EventFlushIntervalHeader tells how long the recent events may take to be
+// listed, as they are written in batches
+const EventFlushIntervalHeader = "X-Longhorn-Event-Flush-Interval"
+
+// RecordsTotalHeader and RecordsRetainedHeader count the records of a list
+// written in all and those retained, the others were removed by the limit
+// of the list or the record GC
+const (
+	RecordsTotalHeader    = "X-Longhorn-Records-Total"
+	RecordsRetainedHeader = "X-Longhorn-Records-Retained"
+)
+
+func setRecordCounts(rw http.ResponseWriter, counts *types.RecordCounts) {
+	rw.Header().Set(RecordsTotalHeader, strconv.FormatInt(counts.Total, 10))
+	rw.Header().Set(RecordsRetainedHeader, strconv.FormatInt(counts.Retained, 10))
+}
+
+func (s *Server) ListVolumeEvents(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["name"]
+
+	v, err := s.man.Get(id)
+	if err != nil {
+		return errors.Wrap(err, "unable to get volume")
+	}
+	if v == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		apiContext.Write(&Empty{})
+		return nil
+	}
+
+	events, err := s.man.ListVolumeEvents(id)
+	if err != nil {
+		return errors.Wrap(err, "unable to list volume events")
+	}
+	counts, err := s.man.VolumeEventCounts(id)
+	if err != nil {
+		return errors.Wrap(err, "unable to count volume events")
+	}
+	rw.Header().Set(EventFlushIntervalHeader, s.man.EventRecorderStatus().FlushInterval)
+	setRecordCounts(rw, counts)
+	apiContext.Write(toVolumeEventCollection(id, events))
+	return nil
+}
+
+func (s *Server) GetVolumeHistory(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["name"]
+
+	limit := 0
+	if l := req.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			return errors.Errorf("invalid limit %v, expecting a positive number", l)
+		}
+	}
+
+	v, err := s.man.Get(id)
+	if err != nil {
+		return errors.Wrap(err, "unable to get volume")
+	}
+	if v == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		apiContext.Write(&Empty{})
+		return nil
+	}
+
+	transitions, err := s.man.GetVolumeHistory(id, limit)
+	if err != nil {
+		return errors.Wrap(err, "unable to get volume history")
+	}
+	counts, err := s.man.VolumeHistoryCounts(id)
+	if err != nil {
+		return errors.Wrap(err, "unable to count volume history")
+	}
+	setRecordCounts(rw, counts)
+	apiContext.Write(toStateTransitionCollection(id, transitions))
+	return nil
+}
+
+func (s *Server) KeepVolumeEvents(rw http.ResponseWriter, req *http.Request) error {
+	var input RecordKeepInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read recordKeepInput")
+	}
+	vars := mux.Vars(req)
+	if err := s.man.KeepVolumeEvents(vars["name"], vars["batch"], input.Keep); err != nil {
+		return errors.Wrap(err, "unable to keep volume events")
+	}
+	return s.ListVolumeEvents(rw, req)
+}
+
+func (s *Server) KeepVolumeState(rw http.ResponseWriter, req *http.Request) error {
+	var input RecordKeepInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read recordKeepInput")
+	}
+	vars := mux.Vars(req)
+	seq, err := strconv.ParseInt(vars["seq"], 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "invalid state transition %v", vars["seq"])
+	}
+	if err := s.man.KeepVolumeState(vars["name"], seq, input.Keep); err != nil {
+		return errors.Wrap(err, "unable to keep volume state transition")
+	}
+	return s.GetVolumeHistory(rw, req)
+}
+
+func (s *Server) EngineStatus(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	name := mux.Vars(req)["name"]
+	instance := mux.Vars(req)["instance"]
+
+	status, err := s.man.EngineStatus(name, instance)
+	if err != nil {
+		if limited, ok := errors.Cause(err).(*types.ErrEngineStatusRateLimited); ok {
+			rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+			rw.WriteHeader(http.StatusTooManyRequests)
+			apiContext.Write(&Empty{})
+			return nil
+		}
+		return errors.Wrap(err, "unable to get engine status")
+	}
+	apiContext.Write(toEngineStatusResource(status))
+	return nil
+}
+
 func (s *Server) UpdateRecurring(rw http.ResponseWriter, req *http.Request) error {
 	apiContext := api.GetApiContext(req)
 	id := mux.Vars(req)["name"]
@@ -124,20 +374,57 @@ func filterCreateVolumeInput(v *Volume) (*types.VolumeInfo, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "error converting size '%s'", v.Size)
 	}
+	var snapshotMaxAge time.Duration
+	if v.SnapshotMaxAge != "" {
+		if snapshotMaxAge, err = time.ParseDuration(v.SnapshotMaxAge); err != nil {
+			return nil, errors.Wrapf(err, "error parsing snapshotMaxAge '%s'", v.SnapshotMaxAge)
+		}
+	}
 	return &types.VolumeInfo{
 		Name:                v.Name,
 		Size:                util.RoundUpSize(size),
 		BaseImage:           v.BaseImage,
 		FromBackup:          v.FromBackup,
+		Standby:             v.Standby,
 		NumberOfReplicas:    v.NumberOfReplicas,
 		StaleReplicaTimeout: time.Duration(v.StaleReplicaTimeout) * time.Minute,
+		DataIntegrity:       types.DataIntegrity(v.DataIntegrity),
+		ReplicaBacking:      types.ReplicaBacking(v.ReplicaBacking),
+		CacheMode:           types.CacheMode(v.CacheMode),
+		FsType:              types.FsType(v.FsType),
+		Mode:                types.VolumeMode(v.Mode),
+		SnapshotMaxCount:    v.SnapshotMaxCount,
+		SnapshotMaxAge:      snapshotMaxAge,
+		PreferredHostID:     v.PreferredHostID,
+		PreferredHostPinned: v.PreferredHostID != "",
+		RehomePolicy:        types.RehomePolicy(v.RehomePolicy),
+		AutoReattach:        types.AutoReattachPolicy(v.AutoReattach),
+		BackupCompression:   types.BackupCompression(v.BackupCompression),
+
+		EngineVersionConstraint: v.EngineVersionConstraint,
+
+		MaxReplicasPerZone: v.MaxReplicasPerZone,
+		MinZones:           v.MinZones,
+
+		Labels: v.Labels,
+
+		ScheduleOnCreate: v.ScheduleOnCreate,
+
+		InstanceEnv: v.InstanceEnv,
 	}, nil
 }
 
 func (s *Server) AttachVolume(rw http.ResponseWriter, req *http.Request) error {
+	var input AttachInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read attachInput")
+	}
+
 	id := mux.Vars(req)["name"]
 
-	if err := s.man.Attach(id); err != nil {
+	if err := s.man.AttachPreferred(id, input.Env, input.PreferredHostIDs); err != nil {
 		return errors.Wrap(err, "unable to attach volume")
 	}
 
@@ -170,3 +457,248 @@ func (s *Server) ReplicaRemove(rw http.ResponseWriter, req *http.Request) error
 
 	return s.GetVolume(rw, req)
 }
+
+func (s *Server) ReplicaMigrate(rw http.ResponseWriter, req *http.Request) error {
+	var input ReplicaInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read replicaInput")
+	}
+
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.MigrateReplica(id, input.Name); err != nil {
+		return errors.Wrap(err, "unable to migrate replica")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+func (s *Server) ReplicaDiskUsage(rw http.ResponseWriter, req *http.Request) error {
+	var input ReplicaInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read replicaInput")
+	}
+
+	id := mux.Vars(req)["name"]
+
+	usage, err := s.man.GetReplicaDiskUsage(id, input.Name)
+	if err != nil {
+		return errors.Wrap(err, "unable to get replica disk usage")
+	}
+
+	apiContext.Write(toDiskUsageResource(usage))
+	return nil
+}
+
+func (s *Server) UpdatePreferredHost(rw http.ResponseWriter, req *http.Request) error {
+	var input PreferredHostInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read preferredHostInput")
+	}
+
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.UpdatePreferredHost(id, input.HostID, types.RehomePolicy(input.RehomePolicy)); err != nil {
+		return errors.Wrap(err, "unable to update preferred host")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+func (s *Server) UpdateAutoReattach(rw http.ResponseWriter, req *http.Request) error {
+	var input AutoReattachInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read autoReattachInput")
+	}
+
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.UpdateAutoReattach(id, types.AutoReattachPolicy(input.Policy)); err != nil {
+		return errors.Wrap(err, "unable to update auto reattach policy")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+func (s *Server) UpdateBackupCompression(rw http.ResponseWriter, req *http.Request) error {
+	var input BackupCompressionInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read backupCompressionInput")
+	}
+
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.UpdateBackupCompression(id, types.BackupCompression(input.Compression)); err != nil {
+		return errors.Wrap(err, "unable to update backup compression")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+func (s *Server) Salvage(rw http.ResponseWriter, req *http.Request) error {
+	var input SalvageInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read salvageInput")
+	}
+
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.Salvage(id, input.ReplicaNames); err != nil {
+		return errors.Wrap(err, "unable to salvage volume")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+func (s *Server) Replan(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.ReplanReplicas(id); err != nil {
+		return errors.Wrap(err, "unable to replan replicas")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+func (s *Server) AbortVolumeCreation(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.AbortVolumeCreation(id); err != nil {
+		return errors.Wrap(err, "unable to abort volume creation")
+	}
+
+	return nil
+}
+
+func (s *Server) CompactSnapshots(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.CompactSnapshots(id); err != nil {
+		return errors.Wrap(err, "unable to compact snapshots")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+func (s *Server) CancelRebuild(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.CancelRebuild(id); err != nil {
+		return errors.Wrap(err, "unable to cancel rebuild")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+func (s *Server) CreateStandbyReplica(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["name"]
+
+	if _, err := s.man.CreateStandbyReplica(id); err != nil {
+		return errors.Wrap(err, "unable to create standby replica")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+func (s *Server) PromoteStandbyReplica(rw http.ResponseWriter, req *http.Request) error {
+	var input ReplicaRemoveInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read replicaRemoveInput")
+	}
+
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.PromoteStandbyReplica(id, input.Name); err != nil {
+		return errors.Wrap(err, "unable to promote standby replica")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+func (s *Server) ConvertVolume(rw http.ResponseWriter, req *http.Request) error {
+	var input ConvertInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read convertInput")
+	}
+
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.ConvertVolume(id, types.VolumeMode(input.Mode), input.NumberOfReplicas); err != nil {
+		return errors.Wrap(err, "unable to convert volume")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+// MigrateController moves the controller of the volume to the current host,
+// the request is forwarded to the target host
+func (s *Server) MigrateController(rw http.ResponseWriter, req *http.Request) error {
+	var input MigrateControllerInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read migrateControllerInput")
+	}
+	if input.HostID != s.sl.GetCurrentHostID() {
+		return errors.Errorf("unable to migrate controller: request for host %v reached host %v", input.HostID, s.sl.GetCurrentHostID())
+	}
+
+	id := mux.Vars(req)["name"]
+
+	migration, err := s.man.MigrateController(id)
+	if err != nil {
+		return errors.Wrap(err, "unable to migrate controller")
+	}
+	apiContext.Write(toControllerMigrationResource(id, migration))
+	return nil
+}
+
+func (s *Server) UpdatePinReplicas(rw http.ResponseWriter, req *http.Request) error {
+	var input PinReplicasInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read pinReplicasInput")
+	}
+
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.UpdatePinReplicas(id, input.Pinned); err != nil {
+		return errors.Wrap(err, "unable to update replica pinning")
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+func (s *Server) UpdateEngineVersionConstraint(rw http.ResponseWriter, req *http.Request) error {
+	var input EngineVersionConstraintInput
+
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return errors.Wrapf(err, "error read engineVersionConstraintInput")
+	}
+
+	id := mux.Vars(req)["name"]
+
+	if err := s.man.UpdateEngineVersionConstraint(id, input.Constraint); err != nil {
+		return errors.Wrap(err, "unable to update engine version constraint")
+	}
+
+	return s.GetVolume(rw, req)
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestSetInstanceHosts(t *testing.T) {
+	assert := require.New(t)
+
+	volumes := []*types.VolumeInfo{
+		{
+			Name:       "vol-1",
+			Controller: &types.ControllerInfo{InstanceInfo: types.InstanceInfo{HostID: "host-1"}},
+			Replicas: map[string]*types.ReplicaInfo{
+				"vol-1-r1": {InstanceInfo: types.InstanceInfo{HostID: "host-1", Name: "vol-1-r1"}},
+			},
+		},
+		{
+			Name: "vol-2",
+			Replicas: map[string]*types.ReplicaInfo{
+				"vol-2-r1": {InstanceInfo: types.InstanceInfo{HostID: "host-2", Name: "vol-2-r1"}},
+			},
+		},
+	}
+	assert.Equal([]string{"host-1", "host-2"}, instanceHostIDs(volumes))
+
+	// host-2 is gone
+	hosts := map[string]*types.HostInfo{
+		"host-1": {UUID: "host-1", Name: "node-1", Address: "10.0.0.1:9500"},
+		"host-2": nil,
+	}
+	v := &Volume{
+		Controller: &Controller{Instance{HostID: "host-1"}},
+		Replicas:   []Replica{{Instance: Instance{HostID: "host-1"}}, {Instance: Instance{HostID: "host-2"}}},
+	}
+	setInstanceHosts(v, hosts)
+	assert.Equal("node-1", v.Controller.HostName)
+	assert.Equal("10.0.0.1:9500", v.Controller.HostAddress)
+	assert.Equal("node-1", v.Replicas[0].HostName)
+	assert.Equal("", v.Replicas[1].HostName)
+	assert.Equal("", v.Replicas[1].HostAddress)
+}
@@ -3,6 +3,7 @@ package backups
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
@@ -29,6 +30,18 @@ func New(backupTarget string) types.ManagerBackupOps {
 	return &backups{backupTarget}
 }
 
+// URL of the backup of the volume on the backup target
+func URL(backupTarget, backupName, volumeName string) string {
+	return fmt.Sprintf("%s?backup=%s&volume=%s", backupTarget, backupName, volumeName)
+}
+
+// IsNotFound checks the output of the engine backup commands for a missing
+// backup or volume. The backup target is eventually consistent, so a backup
+// just deleted may still be listed and deleting it again fails this way.
+func IsNotFound(output string) bool {
+	return strings.Contains(strings.ToLower(output), "cannot find ")
+}
+
 func parseBackup(v interface{}) (*types.BackupInfo, error) {
 	backup := new(types.BackupInfo)
 	if err := mapstructure.Decode(v, backup); err != nil {
@@ -180,8 +193,7 @@ func (b *backups) Delete(url string) error {
 	cmd.Stderr = errBuff
 	out, err := cmd.Output()
 	if err != nil {
-		s := string(out)
-		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(s)), "cannot find ") {
+		if IsNotFound(string(out)) || IsNotFound(errBuff.String()) {
 			logrus.Warnf("delete: could not find the backup: '%s'", url)
 			return nil
 		}
@@ -189,3 +201,18 @@ func (b *backups) Delete(url string) error {
 	}
 	return nil
 }
+
+func (b *backups) DeleteVolume(volumeName string) error {
+	cmd := exec.Command("longhorn", "backup", "rm", "--volume", volumeName, b.BackupTarget)
+	errBuff := new(bytes.Buffer)
+	cmd.Stderr = errBuff
+	out, err := cmd.Output()
+	if err != nil {
+		if IsNotFound(string(out)) || IsNotFound(errBuff.String()) {
+			logrus.Warnf("delete: could not find the backup volume: '%s'", volumeName)
+			return nil
+		}
+		return errors.Wrapf(err, "Error deleting backup volume: %s", errBuff)
+	}
+	return nil
+}
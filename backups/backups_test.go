@@ -17,7 +17,8 @@ const oneBackupText = `
 	"Size": "169869312",
 	"VolumeName": "qq",
 	"VolumeSize": "10737418240",
-	"VolumeCreated": "2017-03-25T02:25:53Z"
+	"VolumeCreated": "2017-03-25T02:25:53Z",
+	"CompressionMethod": "gzip"
 }
 `
 
@@ -67,6 +68,8 @@ func TestParseOneBackup(t *testing.T) {
 		VolumeName:      "qq",
 		VolumeSize:      "10737418240",
 		VolumeCreated:   "2017-03-25T02:25:53Z",
+
+		CompressionMethod: "gzip",
 	}, *b)
 }
 
@@ -103,3 +106,36 @@ func TestParseBackupsList2(t *testing.T) {
 	assert.Nil(err)
 	assert.Nil(bs)
 }
+
+func TestResolveTarget(t *testing.T) {
+	assert := require.New(t)
+
+	volume := &types.VolumeInfo{
+		Name:   "vol",
+		Labels: map[string]string{"env": "prod", "app": "db"},
+	}
+	target, err := ResolveTarget("s3://bucket@us-east-1/{env}/{app}/", volume)
+	assert.Nil(err)
+	assert.Equal("s3://bucket@us-east-1/prod/db/", target)
+
+	target, err = ResolveTarget("nfs://1.2.3.4:/backups/{env}-{volume}", volume)
+	assert.Nil(err)
+	assert.Equal("nfs://1.2.3.4:/backups/prod-vol", target)
+
+	target, err = ResolveTarget("vfs:///var/lib/longhorn/backups", volume)
+	assert.Nil(err)
+	assert.Equal("vfs:///var/lib/longhorn/backups", target)
+
+	_, err = ResolveTarget("s3://bucket/{env}/{team}/{region}", volume)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "[region team]")
+
+	volume.Labels["app"] = "../db"
+	_, err = ResolveTarget("s3://bucket/{app}", volume)
+	assert.NotNil(err)
+
+	for _, target := range []string{"s3://bucket/{env", "s3://bucket/env}", "s3://bucket/{}", "s3://bucket/{a{b}}"} {
+		assert.NotNil(ValidateTargetTemplate(target), target)
+	}
+	assert.Nil(ValidateTargetTemplate("s3://bucket/{env}/{app}/"))
+}
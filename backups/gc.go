@@ -0,0 +1,150 @@
+package backups
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// The layout of the engine on a vfs backup target: a volume is a directory
+// with its volume.cfg, its backups are backups/backup_<name>.cfg listing the
+// checksums of their blocks, stored as blocks/<xx>/<yy>/<checksum>.blk
+const (
+	backupStoreDir   = "backupstore"
+	volumesDir       = "volumes"
+	volumeConfigFile = "volume.cfg"
+	backupsDir       = "backups"
+	backupConfigGlob = "backup_*.cfg"
+	blocksDir        = "blocks"
+	blockSuffix      = ".blk"
+)
+
+// BlockGCGracePeriod protects the blocks written recently from the garbage
+// collection, a backup in progress writes its blocks before its config
+var BlockGCGracePeriod = 6 * time.Hour
+
+type backupConfig struct {
+	Name   string
+	Blocks []struct {
+		Offset        int64
+		BlockChecksum string
+	}
+}
+
+// vfsPath is the directory of a vfs backup target, the other targets are
+// only reached by the engine
+func vfsPath(backupTarget, what string) (string, error) {
+	if IsTargetTemplate(backupTarget) {
+		return "", errors.Errorf("backup target %q is a template, %v need a single target", backupTarget, what)
+	}
+	u, err := url.Parse(backupTarget)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid backup target %q", backupTarget)
+	}
+	if u.Scheme != "vfs" {
+		return "", errors.Errorf("%v are not supported on backup target %q, only on vfs:// targets", what, backupTarget)
+	}
+	return u.Path, nil
+}
+
+// GarbageCollect removes the blocks of the backup target no backup of their
+// volume references, unless written within BlockGCGracePeriod before now.
+// A volume with a backup config which cannot be read is left untouched and
+// reported.
+func GarbageCollect(backupTarget string, now time.Time) (*types.GCResult, error) {
+	dir, err := vfsPath(backupTarget, "backup garbage collections")
+	if err != nil {
+		return nil, err
+	}
+	result := &types.GCResult{
+		BackupTarget:  backupTarget,
+		RemovedBlocks: []string{},
+		Errors:        []string{},
+	}
+	volumeDirs := []string{}
+	root := filepath.Join(dir, backupStoreDir, volumesDir)
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.IsDir() && info.Name() == volumeConfigFile {
+			volumeDirs = append(volumeDirs, filepath.Dir(path))
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list the volumes of backup target %v", backupTarget)
+	}
+	sort.Strings(volumeDirs)
+	for _, volumeDir := range volumeDirs {
+		if err := gcVolume(dir, volumeDir, now, result); err != nil {
+			result.Errors = append(result.Errors, err.Error())
+		}
+	}
+	return result, nil
+}
+
+func gcVolume(dir, volumeDir string, now time.Time, result *types.GCResult) error {
+	volumeName := filepath.Base(volumeDir)
+	configs, err := filepath.Glob(filepath.Join(volumeDir, backupsDir, backupConfigGlob))
+	if err != nil {
+		return errors.Wrapf(err, "unable to list the backups of volume %v", volumeName)
+	}
+	referenced := map[string]bool{}
+	for _, file := range configs {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrapf(err, "skipped volume %v, unable to read backup %v", volumeName, filepath.Base(file))
+		}
+		config := &backupConfig{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return errors.Wrapf(err, "skipped volume %v, unable to parse backup %v", volumeName, filepath.Base(file))
+		}
+		for _, block := range config.Blocks {
+			referenced[block.BlockChecksum] = true
+		}
+	}
+	result.Volumes++
+	result.Backups += len(configs)
+
+	return filepath.Walk(filepath.Join(volumeDir, blocksDir), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return errors.Wrapf(err, "unable to list the blocks of volume %v", volumeName)
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), blockSuffix) {
+			return nil
+		}
+		result.Blocks++
+		if referenced[strings.TrimSuffix(info.Name(), blockSuffix)] {
+			result.ReferencedBlocks++
+			return nil
+		}
+		if now.Sub(info.ModTime()) < BlockGCGracePeriod {
+			result.RecentBlocks++
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return errors.Wrapf(err, "unable to remove block %v of volume %v", info.Name(), volumeName)
+		}
+		rel, _ := filepath.Rel(dir, path)
+		result.RemovedBlocks = append(result.RemovedBlocks, rel)
+		result.BytesReclaimed += info.Size()
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+package backups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTargetFile(assert *require.Assertions, path, content string, modified time.Time) {
+	assert.Nil(os.MkdirAll(filepath.Dir(path), 0700))
+	assert.Nil(ioutil.WriteFile(path, []byte(content), 0600))
+	assert.Nil(os.Chtimes(path, modified, modified))
+}
+
+func TestGarbageCollect(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "backup-gc")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	old := now.Add(-2 * BlockGCGracePeriod)
+	block := func(volumeDir, checksum string) string {
+		return filepath.Join(volumeDir, "blocks", checksum[0:2], checksum[2:4], checksum+".blk")
+	}
+
+	vol := filepath.Join(dir, "backupstore", "volumes", "a1", "b2", "vol")
+	writeTargetFile(assert, filepath.Join(vol, "volume.cfg"), `{"Name": "vol"}`, old)
+	writeTargetFile(assert, filepath.Join(vol, "backups", "backup_b1.cfg"),
+		`{"Name": "b1", "Blocks": [{"Offset": 0, "BlockChecksum": "aaaa01"}, {"Offset": 2097152, "BlockChecksum": "bbbb01"}]}`, old)
+	writeTargetFile(assert, filepath.Join(vol, "backups", "backup_b2.cfg"),
+		`{"Name": "b2", "Blocks": [{"Offset": 0, "BlockChecksum": "cccc01"}]}`, old)
+	writeTargetFile(assert, block(vol, "aaaa01"), "referenced", old)
+	writeTargetFile(assert, block(vol, "bbbb01"), "referenced", old)
+	writeTargetFile(assert, block(vol, "cccc01"), "referenced", old)
+	writeTargetFile(assert, block(vol, "dddd01"), "orphaned", old)
+	// a backup in progress, its config not written yet
+	writeTargetFile(assert, block(vol, "eeee01"), "recent", now)
+
+	// a block referenced by another volume only is still an orphan
+	other := filepath.Join(dir, "backupstore", "volumes", "c3", "d4", "other")
+	writeTargetFile(assert, filepath.Join(other, "volume.cfg"), `{"Name": "other"}`, old)
+	writeTargetFile(assert, filepath.Join(other, "backups", "backup_b3.cfg"),
+		`{"Name": "b3", "Blocks": [{"Offset": 0, "BlockChecksum": "dddd01"}]}`, old)
+	writeTargetFile(assert, block(other, "aaaa01"), "orphaned-too", old)
+
+	// unreadable backups, nothing of the volume is removed
+	broken := filepath.Join(dir, "backupstore", "volumes", "e5", "f6", "broken")
+	writeTargetFile(assert, filepath.Join(broken, "volume.cfg"), `{"Name": "broken"}`, old)
+	writeTargetFile(assert, filepath.Join(broken, "backups", "backup_b4.cfg"), `{"Name": `, old)
+	writeTargetFile(assert, block(broken, "ffff01"), "kept", old)
+
+	result, err := GarbageCollect("vfs://"+dir, now)
+	assert.Nil(err)
+	assert.Equal(2, result.Volumes)
+	assert.Equal(3, result.Backups)
+	assert.Equal(6, result.Blocks)
+	assert.Equal(3, result.ReferencedBlocks)
+	assert.Equal(1, result.RecentBlocks)
+	assert.Equal([]string{
+		"backupstore/volumes/a1/b2/vol/blocks/dd/dd/dddd01.blk",
+		"backupstore/volumes/c3/d4/other/blocks/aa/aa/aaaa01.blk",
+	}, result.RemovedBlocks)
+	assert.Equal(int64(len("orphaned")+len("orphaned-too")), result.BytesReclaimed)
+	assert.Len(result.Errors, 1)
+	assert.Contains(result.Errors[0], "skipped volume broken")
+
+	for _, path := range []string{
+		block(vol, "aaaa01"), block(vol, "bbbb01"), block(vol, "cccc01"), block(vol, "eeee01"), block(broken, "ffff01"),
+	} {
+		_, err := os.Stat(path)
+		assert.Nil(err, path)
+	}
+	for _, path := range []string{block(vol, "dddd01"), block(other, "aaaa01")} {
+		_, err := os.Stat(path)
+		assert.True(os.IsNotExist(err), path)
+	}
+
+	// an empty target
+	empty, err := ioutil.TempDir("", "backup-gc-empty")
+	assert.Nil(err)
+	defer os.RemoveAll(empty)
+	result, err = GarbageCollect("vfs://"+empty, now)
+	assert.Nil(err)
+	assert.Equal(0, result.Blocks)
+
+	_, err = GarbageCollect("s3://bucket@us-east-1/", now)
+	assert.NotNil(err)
+}
@@ -0,0 +1,233 @@
+package backups
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const (
+	// MetadataDir is the directory of the metadata snapshots on the backup
+	// target, next to the backups of the volumes
+	MetadataDir = "longhorn-metadata"
+
+	metadataPrefix     = "metadata-"
+	metadataSuffix     = ".json"
+	metadataTimeFormat = "20060102T150405Z"
+)
+
+var (
+	// MetadataRetain is the number of metadata snapshots kept, the oldest
+	// are removed beyond it
+	MetadataRetain = 24
+)
+
+// metadataFile is a metadata snapshot as written on the backup target. The
+// snapshot is gzipped JSON, sealed with AES-GCM, the nonce first, if
+// encrypted.
+type metadataFile struct {
+	Version   int    `json:"version"`
+	Created   string `json:"created"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	Data      []byte `json:"data"`
+}
+
+// MetadataName is the name of the snapshot created at t, the names sort by
+// time
+func MetadataName(t time.Time) string {
+	return metadataPrefix + t.UTC().Format(metadataTimeFormat) + metadataSuffix
+}
+
+// MetadataTime is when the snapshot of the name was created
+func MetadataTime(name string) (time.Time, error) {
+	if !strings.HasPrefix(name, metadataPrefix) || !strings.HasSuffix(name, metadataSuffix) {
+		return time.Time{}, errors.Errorf("invalid metadata snapshot name %v", name)
+	}
+	return time.Parse(metadataTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, metadataPrefix), metadataSuffix))
+}
+
+// LoadMetadataKey derives the key encrypting the metadata snapshots from the
+// secret in the file
+func LoadMetadataKey(file string) ([]byte, error) {
+	secret, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read metadata key file %v", file)
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return nil, errors.Errorf("metadata key file %v is empty", file)
+	}
+	key := sha256.Sum256(secret)
+	return key[:], nil
+}
+
+// EncodeMetadata compresses the snapshot, and encrypts it if the key isn't
+// empty
+func EncodeMetadata(snapshot *types.MetadataSnapshot, key []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		return nil, errors.Wrap(err, "unable to encode metadata snapshot")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "unable to compress metadata snapshot")
+	}
+	f := &metadataFile{
+		Version: snapshot.Version,
+		Created: snapshot.Created,
+		Data:    buf.Bytes(),
+	}
+	if len(key) != 0 {
+		gcm, err := metadataCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, errors.Wrap(err, "unable to generate nonce")
+		}
+		f.Data = gcm.Seal(nonce, nonce, f.Data, nil)
+		f.Encrypted = true
+	}
+	return json.Marshal(f)
+}
+
+// DecodeMetadata reads the snapshot encoded, the key must be the one it was
+// encrypted with
+func DecodeMetadata(data, key []byte) (*types.MetadataSnapshot, error) {
+	f := &metadataFile{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, errors.Wrap(err, "invalid metadata snapshot")
+	}
+	if f.Version > types.MetadataSnapshotVersion {
+		return nil, errors.Errorf("metadata snapshot version %v is newer than the supported version %v", f.Version, types.MetadataSnapshotVersion)
+	}
+	compressed := f.Data
+	if f.Encrypted {
+		if len(key) == 0 {
+			return nil, errors.Errorf("metadata snapshot is encrypted, a key is required")
+		}
+		gcm, err := metadataCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(f.Data) < gcm.NonceSize() {
+			return nil, errors.Errorf("invalid encrypted metadata snapshot")
+		}
+		nonce, sealed := f.Data[:gcm.NonceSize()], f.Data[gcm.NonceSize():]
+		if compressed, err = gcm.Open(nil, nonce, sealed, nil); err != nil {
+			return nil, errors.Wrap(err, "unable to decrypt metadata snapshot, wrong key?")
+		}
+	}
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decompress metadata snapshot")
+	}
+	snapshot := &types.MetadataSnapshot{}
+	if err := json.NewDecoder(r).Decode(snapshot); err != nil {
+		return nil, errors.Wrap(err, "unable to decode metadata snapshot")
+	}
+	return snapshot, nil
+}
+
+func metadataCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid metadata key")
+	}
+	return cipher.NewGCM(block)
+}
+
+// vfsMetadataStore keeps the snapshots in a directory of a vfs target,
+// mounted at the same path on the hosts
+type vfsMetadataStore struct {
+	dir string
+}
+
+// NewMetadataStore is the store of the metadata snapshots on the backup
+// target. Only vfs targets are supported: the other targets are reached by
+// the engine, which only reads and writes volume backups.
+func NewMetadataStore(backupTarget string) (types.MetadataStore, error) {
+	dir, err := vfsPath(backupTarget, "metadata snapshots")
+	if err != nil {
+		return nil, err
+	}
+	return &vfsMetadataStore{dir: filepath.Join(dir, MetadataDir)}, nil
+}
+
+// Put writes the snapshot in a temporary file first, so a partial one is
+// never the latest, then removes the oldest ones beyond MetadataRetain
+func (s *vfsMetadataStore) Put(name string, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return errors.Wrapf(err, "unable to create metadata directory %v", s.dir)
+	}
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrapf(err, "unable to write metadata snapshot %v", tmp)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "unable to write metadata snapshot %v", name)
+	}
+	names, err := s.list()
+	if err != nil {
+		return err
+	}
+	for len(names) > MetadataRetain {
+		if err := os.Remove(filepath.Join(s.dir, names[0])); err != nil {
+			return errors.Wrapf(err, "unable to remove metadata snapshot %v", names[0])
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// list is the names of the snapshots, the oldest first
+func (s *vfsMetadataStore) list() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "unable to list metadata directory %v", s.dir)
+	}
+	names := []string{}
+	for _, f := range files {
+		if _, err := MetadataTime(f.Name()); err == nil && !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *vfsMetadataStore) Latest() (string, []byte, error) {
+	names, err := s.list()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(names) == 0 {
+		return "", nil, nil
+	}
+	latest := names[len(names)-1]
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, latest))
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "unable to read metadata snapshot %v", latest)
+	}
+	return latest, data, nil
+}
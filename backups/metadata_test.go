@@ -0,0 +1,107 @@
+package backups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestMetadataEncoding(t *testing.T) {
+	assert := require.New(t)
+
+	snapshot := &types.MetadataSnapshot{
+		Version:  types.MetadataSnapshotVersion,
+		Created:  "2017-03-25T02:27:00Z",
+		HostID:   "host-1",
+		Settings: &types.SettingsInfo{BackupTarget: "vfs:///var/lib/longhorn/backups"},
+		Hosts:    []*types.HostInfo{{UUID: "host-1", Name: "node-1", Address: "10.0.0.1:9500"}},
+		Volumes: []*types.VolumeInfo{{Name: "qq", Size: 10737418240, NumberOfReplicas: 1,
+			Replicas: map[string]*types.ReplicaInfo{"qq-replica-1": {InstanceInfo: types.InstanceInfo{Name: "qq-replica-1", HostID: "host-1"}}}}},
+	}
+
+	data, err := EncodeMetadata(snapshot, nil)
+	assert.Nil(err)
+	decoded, err := DecodeMetadata(data, nil)
+	assert.Nil(err)
+	assert.Equal(snapshot, decoded)
+
+	dir, err := ioutil.TempDir("", "metadata-key")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key")
+	assert.Nil(ioutil.WriteFile(keyFile, []byte("secret\n"), 0600))
+	key, err := LoadMetadataKey(keyFile)
+	assert.Nil(err)
+	assert.Len(key, 32)
+
+	data, err = EncodeMetadata(snapshot, key)
+	assert.Nil(err)
+	assert.NotContains(string(data), "node-1")
+	decoded, err = DecodeMetadata(data, key)
+	assert.Nil(err)
+	assert.Equal(snapshot, decoded)
+
+	_, err = DecodeMetadata(data, nil)
+	assert.NotNil(err)
+	assert.Nil(ioutil.WriteFile(keyFile, []byte("other"), 0600))
+	other, err := LoadMetadataKey(keyFile)
+	assert.Nil(err)
+	_, err = DecodeMetadata(data, other)
+	assert.NotNil(err)
+
+	newer := *snapshot
+	newer.Version = types.MetadataSnapshotVersion + 1
+	data, err = EncodeMetadata(&newer, nil)
+	assert.Nil(err)
+	_, err = DecodeMetadata(data, nil)
+	assert.NotNil(err)
+}
+
+func TestMetadataStore(t *testing.T) {
+	assert := require.New(t)
+
+	for _, target := range []string{
+		"s3://backups@us-east-1/",
+		"vfs:///var/lib/longhorn/backups/{team}",
+	} {
+		_, err := NewMetadataStore(target)
+		assert.NotNil(err, target)
+	}
+
+	dir, err := ioutil.TempDir("", "metadata-store")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	store, err := NewMetadataStore("vfs://" + dir)
+	assert.Nil(err)
+
+	name, data, err := store.Latest()
+	assert.Nil(err)
+	assert.Equal("", name)
+	assert.Nil(data)
+
+	retain := MetadataRetain
+	defer func() { MetadataRetain = retain }()
+	MetadataRetain = 2
+
+	start := time.Date(2017, 3, 25, 2, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		assert.Nil(store.Put(MetadataName(start.Add(time.Duration(i)*time.Hour)), []byte{byte(i)}))
+	}
+	name, data, err = store.Latest()
+	assert.Nil(err)
+	assert.Equal("metadata-20170325T040000Z.json", name)
+	assert.Equal([]byte{2}, data)
+	created, err := MetadataTime(name)
+	assert.Nil(err)
+	assert.Equal(start.Add(2*time.Hour), created)
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, MetadataDir))
+	assert.Nil(err)
+	assert.Len(files, 2)
+}
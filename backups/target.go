@@ -0,0 +1,84 @@
+package backups
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// TargetVariableVolume is the name of the volume in a backup target
+// template, unless the volume has a label of the same name
+const TargetVariableVolume = "volume"
+
+// parseTargetTemplate splits the backup target into the literal text and the
+// names of the {variables} in between
+func parseTargetTemplate(target string) ([]string, []string, error) {
+	texts := []string{}
+	vars := []string{}
+	rest := target
+	for {
+		open := strings.Index(rest, "{")
+		close := strings.Index(rest, "}")
+		if open < 0 {
+			if close >= 0 {
+				return nil, nil, errors.Errorf("invalid backup target %q, unexpected '}'", target)
+			}
+			return append(texts, rest), vars, nil
+		}
+		if close < open {
+			return nil, nil, errors.Errorf("invalid backup target %q, unbalanced braces", target)
+		}
+		name := rest[open+1 : close]
+		if name == "" || strings.ContainsAny(name, "{/") {
+			return nil, nil, errors.Errorf("invalid backup target %q, bad variable %q", target, name)
+		}
+		texts = append(texts, rest[:open])
+		vars = append(vars, name)
+		rest = rest[close+1:]
+	}
+}
+
+// IsTargetTemplate is true if the backup target has variables to be
+// resolved for each volume
+func IsTargetTemplate(target string) bool {
+	return strings.Contains(target, "{")
+}
+
+func ValidateTargetTemplate(target string) error {
+	_, _, err := parseTargetTemplate(target)
+	return err
+}
+
+// ResolveTarget replaces the {variables} in the backup target with the
+// labels of the volume, or its name for {volume}. Every variable must be
+// set, to a value usable as a path segment.
+func ResolveTarget(target string, volume *types.VolumeInfo) (string, error) {
+	texts, vars, err := parseTargetTemplate(target)
+	if err != nil {
+		return "", err
+	}
+	missing := []string{}
+	resolved := texts[0]
+	for i, name := range vars {
+		value, ok := volume.Labels[name]
+		if !ok && name == TargetVariableVolume {
+			value, ok = volume.Name, true
+		}
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		if value == "" || value == "." || value == ".." || strings.Contains(value, "/") {
+			return "", errors.Errorf("label %v=%q of volume '%s' cannot be used in backup target %q", name, value, volume.Name, target)
+		}
+		resolved += value + texts[i+1]
+	}
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return "", errors.Errorf("volume '%s' has no labels %v used by backup target %q", volume.Name, missing, target)
+	}
+	return resolved, nil
+}
@@ -1,7 +1,9 @@
 package controller
 
 import (
+	"github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
+	"github.com/rancher/longhorn-manager/backups"
 	"github.com/rancher/longhorn-manager/types"
 	"github.com/rancher/longhorn-manager/util"
 )
@@ -10,20 +12,14 @@ func (c *controller) BackupOps() types.VolumeBackupOps {
 	return c
 }
 
-func (c *controller) StartBackup(snapName, backupTarget string) error {
-	snap, err := c.Get(snapName)
-	if err != nil {
-		return errors.Wrapf(err, "error getting snapshot '%s', volume '%s'", snapName, c.name)
+// Restore restores the backup with the compression it was taken with, the
+// engine default if unknown
+func (c *controller) Restore(backup string, compression types.BackupCompression) error {
+	args := []string{"--url", c.url, "backup", "restore"}
+	if compression != types.BackupCompressionDefault {
+		args = append(args, BackupCompressionFlag, string(compression))
 	}
-	if snap == nil {
-		return errors.Errorf("could not find snapshot '%s' to backup, volume '%s'", snapName, c.name)
-	}
-	c.bgTaskQueue.Put(&types.BgTask{Task: &types.BackupBgTask{Snapshot: snapName, BackupTarget: backupTarget}})
-	return nil
-}
-
-func (c *controller) Restore(backup string) error {
-	if _, err := util.Execute("longhorn", "--url", c.url, "backup", "restore", backup); err != nil {
+	if _, err := util.Execute("longhorn", append(args, backup)...); err != nil {
 		return errors.Wrapf(err, "error restoring backup '%s'", backup)
 	}
 	return nil
@@ -31,6 +27,10 @@ func (c *controller) Restore(backup string) error {
 
 func (c *controller) DeleteBackup(backup string) error {
 	if _, err := util.Execute("longhorn", "--url", c.url, "backup", "rm", backup); err != nil {
+		if backups.IsNotFound(err.Error()) {
+			logrus.Warnf("delete: could not find the backup: '%s'", backup)
+			return nil
+		}
 		return errors.Wrapf(err, "error deleting backup '%s'", backup)
 	}
 	return nil
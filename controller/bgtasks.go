@@ -10,6 +10,14 @@ import (
 	"time"
 )
 
+// LocalReplicaBackupFlag makes the engine read the data of a backup from the
+// replica given instead of through the controller
+const LocalReplicaBackupFlag = "--from-replica"
+
+// BackupCompressionFlag sets the compression of the blocks of a backup, and
+// of the blocks restored
+const BackupCompressionFlag = "--compression-method"
+
 func (c *controller) LatestBgTasks() []*types.BgTask {
 	c.bgTaskLock.Lock()
 	defer c.bgTaskLock.Unlock()
@@ -72,6 +80,13 @@ func (c *controller) runTask(t *types.BgTask) {
 }
 
 func (c *controller) runBackup(t *types.BackupBgTask) error {
+	if t.WaitTurn != nil {
+		done, err := t.WaitTurn()
+		if err != nil {
+			return errors.Wrapf(err, "error waiting for the turn of the backup of snapshot '%s'", t.Snapshot)
+		}
+		defer done()
+	}
 	if t.CleanupHook != nil {
 		defer func() {
 			if err := t.CleanupHook(); err != nil {
@@ -81,14 +96,26 @@ func (c *controller) runBackup(t *types.BackupBgTask) error {
 	}
 
 	var stdout, stderr bytes.Buffer
-	cmd := exec.Command("longhorn", "--url", c.url, "backup", "create", "--dest", t.BackupTarget, t.Snapshot)
+	cmd := exec.Command("longhorn", backupArgs(c.url, t)...)
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
 
 	if err == nil {
-		logrus.Infof("completed backup: volume '%s', snapshot '%s', backupTarget '%s'", c.name, t.Snapshot, t.BackupTarget)
+		logrus.Infof("completed backup: volume '%s', snapshot '%s', backupTarget '%s', read from %v, compression %v", c.name, t.Snapshot, t.BackupTarget, t.ReadStrategy, t.Compression)
 	}
 	return errors.Wrapf(err, "error creating backup for snapshot '%s', backupTarget '%s': %s", t.Snapshot, t.BackupTarget, &stderr)
 }
+
+// backupArgs are the arguments of the engine command creating the backup
+func backupArgs(url string, t *types.BackupBgTask) []string {
+	args := []string{"--url", url, "backup", "create", "--dest", t.BackupTarget}
+	if t.ReadStrategy == types.BackupReadLocalReplica {
+		args = append(args, LocalReplicaBackupFlag, getReplicaURL(t.ReplicaAddress))
+	}
+	if t.Compression != types.BackupCompressionDefault {
+		args = append(args, BackupCompressionFlag, string(t.Compression))
+	}
+	return append(args, t.Snapshot)
+}
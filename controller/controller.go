@@ -2,9 +2,11 @@ package controller
 
 import (
 	"encoding/json"
+	"net/http"
 	"os/exec"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
@@ -16,6 +18,12 @@ func init() {
 	go holdControllers()
 }
 
+var (
+	// AddReplicaTimeout bounds adding a replica to a controller, which
+	// includes rebuilding its data
+	AddReplicaTimeout = 24 * time.Hour
+)
+
 var reqCh = make(chan *req)
 
 type req struct {
@@ -35,6 +43,10 @@ func getReplicaURL(address string) string {
 	return "tcp://" + address + ":9502"
 }
 
+func getReplicaAPIURL(address string) string {
+	return "http://" + address + ":9502/v1"
+}
+
 func getIPFromURL(url string) string {
 	// tcp, \/\/<address>, 9502
 	return strings.TrimPrefix(strings.Split(url, ":")[1], "//")
@@ -160,9 +172,15 @@ func (c *controller) GetReplicaStates() ([]*types.ReplicaInfo, error) {
 	return replicas, nil
 }
 
+// GetReplicaStates lists the replicas connected to the controller at address
+func GetReplicaStates(address string) ([]*types.ReplicaInfo, error) {
+	c := &controller{url: getControllerURL(address)}
+	return c.GetReplicaStates()
+}
+
 func (c *controller) AddReplica(replica *types.ReplicaInfo) error {
 	rURL := getReplicaURL(replica.Address)
-	if _, err := util.Execute("longhorn", "--url", c.url, "add", rURL); err != nil {
+	if _, err := util.ExecuteWithTimeout(AddReplicaTimeout, "longhorn", "--url", c.url, "add", rURL); err != nil {
 		return errors.Wrapf(err, "failed to add replica address='%s' to controller '%s'", rURL, c.name)
 	}
 	return nil
@@ -198,3 +216,43 @@ func (c *controller) info() (*volumeInfo, error) {
 	}
 	return info, nil
 }
+
+type replicaInfo struct {
+	RevisionCounter int64 `json:"revisioncounter"`
+}
+
+// GetReplicaRebuildProgress returns the part of the replica info a rebuild
+// changes as it syncs the snapshot files, i.e. the chain and the sizes of
+// the disks. The writes to the volume don't change it, so the same value
+// over time means the rebuild is not progressing.
+func GetReplicaRebuildProgress(address string) (string, error) {
+	url := getReplicaAPIURL(address) + "/replicas/1"
+	info := rawStatus{}
+	if err := getJSON(url, &info); err != nil {
+		return "", errors.Wrapf(err, "cannot get replica info from %v", url)
+	}
+	progress := ""
+	for _, field := range []string{"chain", "disks"} {
+		progress += field + "=" + string(info[field]) + ";"
+	}
+	return progress, nil
+}
+
+// GetReplicaRevisionCounter asks the replica directly, so it works even if
+// the controller is gone.
+func GetReplicaRevisionCounter(address string) (int64, error) {
+	url := getReplicaAPIURL(address) + "/replicas/1"
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot get replica info from %v", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("cannot get replica info from %v: %v", url, resp.Status)
+	}
+	info := &replicaInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return 0, errors.Wrapf(err, "cannot decode replica info from %v", url)
+	}
+	return info.RevisionCounter, nil
+}
@@ -1,9 +1,12 @@
 package controller
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
 	"github.com/rancher/longhorn-manager/types"
 	"github.com/stretchr/testify/require"
-	"testing"
 )
 
 func TestParseReplica(t *testing.T) {
@@ -21,3 +24,99 @@ func TestParseReplica(t *testing.T) {
 	assert.Equal("replica-79VrD86STQ.volume-qq", replica.Address)
 	assert.Equal(types.ReplicaModeRW, replica.Mode)
 }
+
+func TestParseBlockStat(t *testing.T) {
+	assert := require.New(t)
+
+	reads, writes, err := parseBlockStat("    1234        0    98723      520     5678        3   409600     1200        0     1600     1720\n")
+	assert.Nil(err)
+	assert.Equal(int64(1234), reads)
+	assert.Equal(int64(5678), writes)
+
+	_, _, err = parseBlockStat("1 2 3")
+	assert.NotNil(err)
+}
+
+func newEngineServer(responses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, ok := responses[req.URL.Path]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Write([]byte(body))
+	}))
+}
+
+func TestControllerStatus(t *testing.T) {
+	assert := require.New(t)
+
+	replicas := `{"data": [
+		{"address": "tcp://10.0.0.2:9502", "mode": "RW"},
+		{"address": "tcp://10.0.0.3:9502", "mode": "WO"}]}`
+
+	// an engine not reporting the frontend state
+	old := newEngineServer(map[string]string{
+		"/v1/volumes":  `{"data": [{"name": "vol", "replicaCount": 2, "endpoint": "/dev/longhorn/vol"}]}`,
+		"/v1/replicas": replicas,
+	})
+	defer old.Close()
+	status := &types.EngineStatus{}
+	assert.Nil(getControllerStatus(old.URL+"/v1", status))
+	assert.Nil(status.FrontendState)
+	assert.Nil(status.QueueDepth)
+	assert.Equal("/dev/longhorn/vol", *status.Endpoint)
+	assert.Equal([]*types.EngineReplicaConnection{
+		{Address: "10.0.0.2", Mode: types.ReplicaModeRW},
+		{Address: "10.0.0.3", Mode: types.ReplicaModeWO},
+	}, status.Connections)
+
+	current := newEngineServer(map[string]string{
+		"/v1/volumes":  `{"data": [{"name": "vol", "frontend": "tgt-blockdev", "frontend_state": "up", "pendingIO": 3}]}`,
+		"/v1/replicas": replicas,
+	})
+	defer current.Close()
+	status = &types.EngineStatus{}
+	assert.Nil(getControllerStatus(current.URL+"/v1", status))
+	assert.Equal("tgt-blockdev", *status.Frontend)
+	assert.Equal("up", *status.FrontendState)
+	assert.Equal(int64(3), *status.QueueDepth)
+	assert.Nil(status.Endpoint)
+
+	missing := newEngineServer(map[string]string{})
+	defer missing.Close()
+	assert.NotNil(getControllerStatus(missing.URL+"/v1", &types.EngineStatus{}))
+}
+
+func TestReplicaStatus(t *testing.T) {
+	assert := require.New(t)
+
+	srv := newEngineServer(map[string]string{
+		"/v1/replicas/1": `{"state": "open", "rebuilding": false, "revisioncounter": 42}`,
+	})
+	defer srv.Close()
+	status := &types.EngineStatus{}
+	assert.Nil(getReplicaStatus(srv.URL+"/v1", status))
+	assert.Equal("open", *status.ReplicaState)
+	assert.False(*status.Rebuilding)
+	assert.Equal(int64(42), *status.RevisionCounter)
+	assert.Nil(status.Connections)
+}
+
+func TestBackupArgs(t *testing.T) {
+	assert := require.New(t)
+
+	url := "http://10.0.0.1:9501"
+	assert.Equal([]string{"--url", url, "backup", "create", "--dest", "s3://bucket@us-east-1/", "snap1"},
+		backupArgs(url, &types.BackupBgTask{Snapshot: "snap1", BackupTarget: "s3://bucket@us-east-1/"}))
+
+	assert.Equal([]string{"--url", url, "backup", "create", "--dest", "s3://bucket@us-east-1/",
+		LocalReplicaBackupFlag, "tcp://10.0.0.2:9502", BackupCompressionFlag, "gzip", "snap1"},
+		backupArgs(url, &types.BackupBgTask{
+			Snapshot:       "snap1",
+			BackupTarget:   "s3://bucket@us-east-1/",
+			ReadStrategy:   types.BackupReadLocalReplica,
+			ReplicaAddress: "10.0.0.2",
+			Compression:    types.BackupCompressionGzip,
+		}))
+}
@@ -37,6 +37,16 @@ func (c *controller) Create(name string, labels map[string]string) (string, erro
 }
 
 func (c *controller) list() (map[string]*types.SnapshotInfo, error) {
+	data, err := c.snapshotInfo()
+	if err != nil {
+		return nil, err
+	}
+	delete(data, VolumeHeadName)
+	return data, nil
+}
+
+// snapshotInfo returns the snapshots with the volume head
+func (c *controller) snapshotInfo() (map[string]*types.SnapshotInfo, error) {
 	cmd := exec.Command("longhorn", "--url", c.url, "snapshot", "info")
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -54,7 +64,6 @@ func (c *controller) list() (map[string]*types.SnapshotInfo, error) {
 	if err := json.NewDecoder(stdout).Decode(&data); err != nil {
 		return nil, errors.Wrapf(err, "error parsing data from cmd '%v'", cmd)
 	}
-	delete(data, VolumeHeadName)
 	return data, nil
 }
 
@@ -113,3 +122,47 @@ func (c *controller) Purge() error {
 	}
 	return nil
 }
+
+// CreateSnapshot snapshots the volume of the controller at address
+func CreateSnapshot(address, name string) (*types.SnapshotInfo, error) {
+	c := &controller{url: getControllerURL(address)}
+	created, err := c.Create(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := c.Get(created)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get snapshot '%s'", created)
+	}
+	if snapshot == nil {
+		return nil, errors.Errorf("snapshot '%s' not found once created", created)
+	}
+	return snapshot, nil
+}
+
+// ListSnapshots lists the snapshots of the volume of the controller at
+// address, and returns the snapshot the volume head is on
+func ListSnapshots(address string) ([]*types.SnapshotInfo, string, error) {
+	c := &controller{url: getControllerURL(address)}
+	data, err := c.snapshotInfo()
+	if err != nil {
+		return nil, "", err
+	}
+	head := ""
+	if h := data[VolumeHeadName]; h != nil {
+		head = h.Parent
+	}
+	delete(data, VolumeHeadName)
+	snapshots := []*types.SnapshotInfo{}
+	for _, s := range data {
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, head, nil
+}
+
+// DeleteSnapshot removes the snapshot of the volume of the controller at
+// address
+func DeleteSnapshot(address, name string) error {
+	c := &controller{url: getControllerURL(address)}
+	return c.Delete(name)
+}
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+var (
+	sysBlockDir = "/sys/block"
+	mountsFile  = "/proc/mounts"
+)
+
+// parseBlockStat returns the completed reads and writes from the content of
+// /sys/block/<dev>/stat
+func parseBlockStat(content string) (int64, int64, error) {
+	fields := strings.Fields(content)
+	if len(fields) < 5 {
+		return 0, 0, errors.Errorf("invalid block device stat %q", content)
+	}
+	reads, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid block device stat %q", content)
+	}
+	writes, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid block device stat %q", content)
+	}
+	return reads, writes, nil
+}
+
+func isMounted(devices ...string) (bool, error) {
+	f, err := os.Open(mountsFile)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		for _, dev := range devices {
+			if fields[0] == dev {
+				return true, nil
+			}
+		}
+	}
+	return false, scanner.Err()
+}
+
+// Stats reads the I/O counters of the frontend device from the kernel, so
+// it has to run on the host of the controller
+func (c *controller) Stats() (*types.VolumeStats, error) {
+	endpoint := c.Endpoint()
+	if endpoint == "" {
+		return nil, errors.Errorf("no frontend device for volume '%s'", c.name)
+	}
+	dev, err := filepath.EvalSymlinks(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot resolve device %v of volume '%s'", endpoint, c.name)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(sysBlockDir, filepath.Base(dev), "stat"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read stats of device %v of volume '%s'", dev, c.name)
+	}
+	stats := &types.VolumeStats{}
+	if stats.ReadOps, stats.WriteOps, err = parseBlockStat(string(content)); err != nil {
+		return nil, err
+	}
+	if stats.Mounted, err = isMounted(dev, endpoint); err != nil {
+		return nil, errors.Wrapf(err, "cannot check mounts of device %v of volume '%s'", dev, c.name)
+	}
+	return stats, nil
+}
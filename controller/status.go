@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+var (
+	EngineStatusTimeout = 5 * time.Second
+)
+
+// statusFields are the names the engine versions use in their API for each
+// field of the engine status. The first one present is used, a field none
+// of them is present for is left nil.
+var statusFields = map[string][]string{
+	"frontend":        {"frontend"},
+	"frontendState":   {"frontendState", "frontend_state"},
+	"endpoint":        {"endpoint"},
+	"queueDepth":      {"queueDepth", "pendingIO", "inflightIO"},
+	"replicaState":    {"state"},
+	"rebuilding":      {"rebuilding"},
+	"revisionCounter": {"revisionCounter", "revisioncounter"},
+}
+
+// rawStatus is a resource of the engine API, decoded without assuming the
+// fields of any engine version
+type rawStatus map[string]json.RawMessage
+
+func (r rawStatus) lookup(field string, v interface{}) bool {
+	for _, name := range statusFields[field] {
+		raw, ok := r[name]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+		if err := json.Unmarshal(raw, v); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r rawStatus) str(field string) *string {
+	var s string
+	if !r.lookup(field, &s) {
+		return nil
+	}
+	return &s
+}
+
+func (r rawStatus) int(field string) *int64 {
+	var i int64
+	if !r.lookup(field, &i) {
+		return nil
+	}
+	return &i
+}
+
+func (r rawStatus) bool(field string) *bool {
+	var b bool
+	if !r.lookup(field, &b) {
+		return nil
+	}
+	return &b
+}
+
+type rawCollection struct {
+	Data []rawStatus `json:"data"`
+}
+
+func getJSON(url string, v interface{}) error {
+	client := http.Client{Timeout: EngineStatusTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return errors.Wrapf(err, "cannot get %v", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("cannot get %v: %v", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "cannot decode %v", url)
+	}
+	return nil
+}
+
+// GetEngineStatus asks the engine process of the running instance for its
+// state, through the controller or replica API
+func GetEngineStatus(instance *types.InstanceInfo) (*types.EngineStatus, error) {
+	status := &types.EngineStatus{
+		InstanceName: instance.Name,
+		InstanceType: instance.Type,
+		HostID:       instance.HostID,
+	}
+	switch instance.Type {
+	case types.InstanceTypeController:
+		return status, getControllerStatus(getControllerURL(instance.Address)+"/v1", status)
+	case types.InstanceTypeReplica:
+		return status, getReplicaStatus(getReplicaAPIURL(instance.Address), status)
+	}
+	return nil, errors.Errorf("unknown type %v of instance %v", instance.Type, instance.Name)
+}
+
+func getControllerStatus(url string, status *types.EngineStatus) error {
+	volumes := &rawCollection{}
+	if err := getJSON(url+"/volumes", volumes); err != nil {
+		return err
+	}
+	if len(volumes.Data) == 0 {
+		return errors.Errorf("no volume reported by %v", url)
+	}
+	volume := volumes.Data[0]
+	status.Frontend = volume.str("frontend")
+	status.FrontendState = volume.str("frontendState")
+	status.Endpoint = volume.str("endpoint")
+	status.QueueDepth = volume.int("queueDepth")
+
+	replicas := &rawCollection{}
+	if err := getJSON(url+"/replicas", replicas); err != nil {
+		return err
+	}
+	status.Connections = []*types.EngineReplicaConnection{}
+	for _, r := range replicas.Data {
+		var address, mode string
+		if err := json.Unmarshal(r["address"], &address); err != nil {
+			return errors.Wrapf(err, "cannot decode replica address from %v", url)
+		}
+		json.Unmarshal(r["mode"], &mode)
+		m, ok := modes[mode]
+		if !ok {
+			m = types.ReplicaModeERR
+		}
+		status.Connections = append(status.Connections, &types.EngineReplicaConnection{
+			Address: getIPFromURL(address),
+			Mode:    m,
+		})
+	}
+	return nil
+}
+
+func getReplicaStatus(url string, status *types.EngineStatus) error {
+	replica := rawStatus{}
+	if err := getJSON(url+"/replicas/1", &replica); err != nil {
+		return err
+	}
+	status.ReplicaState = replica.str("replicaState")
+	status.Rebuilding = replica.bool("rebuilding")
+	status.RevisionCounter = replica.int("revisionCounter")
+	return nil
+}
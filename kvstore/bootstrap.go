@@ -0,0 +1,33 @@
+package kvstore
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const (
+	keyBootstrap = "bootstrap"
+)
+
+func (s *KVStore) bootstrapKey() string {
+	return s.key(keyBootstrap)
+}
+
+func (s *KVStore) GetBootstrapReport() (*types.BootstrapReport, error) {
+	report := &types.BootstrapReport{}
+	if err := s.b.Get(s.bootstrapKey(), report); err != nil {
+		if s.b.IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "unable to get bootstrap report")
+	}
+	return report, nil
+}
+
+func (s *KVStore) SetBootstrapReport(report *types.BootstrapReport) error {
+	if err := s.b.Set(s.bootstrapKey(), report); err != nil {
+		return errors.Wrap(err, "unable to set bootstrap report")
+	}
+	return nil
+}
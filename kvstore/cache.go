@@ -0,0 +1,265 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ReadCacheBackend remembers the results of the successful reads of a
+// backend, and serves them again when the same read fails, e.g. while etcd
+// is unreachable, so the volumes and settings just read can still be
+// listed. The store is stale from the first read served from the cache
+// until a read of the backend succeeds again. The writes are never cached:
+// they fail as the backend does, and drop the cached reads they change.
+//
+// With a watcher of the store, the failed reads are served from its values
+// instead once synced, so the writes of the other managers and the keys not
+// read yet are served too.
+type ReadCacheBackend struct {
+	Backend
+
+	mutex   sync.RWMutex
+	watcher *Watcher
+	// the results of Get by key, and of Keys and Values by prefix
+	gets   map[string][]byte
+	keys   map[string][]string
+	values map[string]map[string][]byte
+
+	staleSince time.Time
+}
+
+func NewReadCacheBackend(backend Backend) *ReadCacheBackend {
+	return &ReadCacheBackend{
+		Backend: backend,
+		gets:    map[string][]byte{},
+		keys:    map[string][]string{},
+		values:  map[string]map[string][]byte{},
+	}
+}
+
+// Watch serves the failed reads from the values of the watcher once it's
+// synced, it's run by the caller
+func (c *ReadCacheBackend) Watch(w *Watcher) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.watcher = w
+}
+
+// watched returns the watcher to serve the reads from, nil if none or not
+// synced yet
+func (c *ReadCacheBackend) watched() *Watcher {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.watcher == nil || !c.watcher.Synced() {
+		return nil
+	}
+	return c.watcher
+}
+
+// StaleSince is when the backend started failing the reads served from the
+// cache since, zero if the reads are fresh
+func (c *ReadCacheBackend) StaleSince() time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.staleSince
+}
+
+func (c *ReadCacheBackend) fresh() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.staleSince.IsZero() {
+		logrus.Infof("Reads of the kv store are fresh again, stale since %v", c.staleSince)
+	}
+	c.staleSince = time.Time{}
+}
+
+func (c *ReadCacheBackend) stale(read, key string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.staleSince.IsZero() {
+		c.staleSince = time.Now()
+	}
+	logrus.Warnf("Serving stale %v of %v from the cache, read at least %v ago: %v",
+		read, key, time.Since(c.staleSince), err)
+}
+
+func (c *ReadCacheBackend) Get(key string, obj interface{}) error {
+	err := c.Backend.Get(key, obj)
+	if err == nil {
+		value, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		c.mutex.Lock()
+		c.gets[key] = value
+		c.mutex.Unlock()
+		c.fresh()
+		return nil
+	}
+	if c.Backend.IsNotFoundError(err) {
+		c.mutex.Lock()
+		delete(c.gets, key)
+		c.mutex.Unlock()
+		c.fresh()
+		return err
+	}
+	var (
+		value []byte
+		ok    bool
+	)
+	if w := c.watched(); w != nil {
+		value, ok = w.Get(key)
+	} else {
+		c.mutex.RLock()
+		value, ok = c.gets[key]
+		c.mutex.RUnlock()
+	}
+	if !ok {
+		return err
+	}
+	if jsonErr := json.Unmarshal(value, obj); jsonErr != nil {
+		return err
+	}
+	c.stale("get", key, err)
+	return nil
+}
+
+func (c *ReadCacheBackend) Keys(prefix string) ([]string, error) {
+	keys, err := c.Backend.Keys(prefix)
+	if err == nil {
+		c.mutex.Lock()
+		c.keys[prefix] = append([]string{}, keys...)
+		c.mutex.Unlock()
+		c.fresh()
+		return keys, nil
+	}
+	if w := c.watched(); w != nil {
+		c.stale("keys", prefix, err)
+		return childKeys(w.Under(prefix), prefix), nil
+	}
+	c.mutex.RLock()
+	cached, ok := c.keys[prefix]
+	c.mutex.RUnlock()
+	if !ok {
+		return nil, err
+	}
+	c.stale("keys", prefix, err)
+	return append([]string{}, cached...), nil
+}
+
+func (c *ReadCacheBackend) Values(prefix string) (map[string][]byte, error) {
+	values, err := c.Backend.Values(prefix)
+	if err == nil {
+		c.mutex.Lock()
+		c.values[prefix] = copyValues(values)
+		c.mutex.Unlock()
+		c.fresh()
+		return values, nil
+	}
+	if w := c.watched(); w != nil {
+		c.stale("values", prefix, err)
+		return w.Under(prefix), nil
+	}
+	c.mutex.RLock()
+	cached, ok := c.values[prefix]
+	c.mutex.RUnlock()
+	if !ok {
+		return nil, err
+	}
+	c.stale("values", prefix, err)
+	return copyValues(cached), nil
+}
+
+// childKeys lists the keys right under prefix of the values, as Keys does
+func childKeys(values map[string][]byte, prefix string) []string {
+	prefix = strings.TrimSuffix(prefix, Separator) + Separator
+	children := map[string]struct{}{}
+	for k := range values {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		children[prefix+strings.SplitN(strings.TrimPrefix(k, prefix), Separator, 2)[0]] = struct{}{}
+	}
+	keys := []string{}
+	for k := range children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func copyValues(values map[string][]byte) map[string][]byte {
+	copied := map[string][]byte{}
+	for k, v := range values {
+		copied[k] = v
+	}
+	return copied
+}
+
+func (c *ReadCacheBackend) Set(key string, obj interface{}) error {
+	if err := c.Backend.Set(key, obj); err != nil {
+		return err
+	}
+	c.invalidate(key)
+	return nil
+}
+
+func (c *ReadCacheBackend) SetIfRevision(key string, obj interface{}, revision uint64) error {
+	if err := c.Backend.SetIfRevision(key, obj, revision); err != nil {
+		return err
+	}
+	c.invalidate(key)
+	return nil
+}
+
+func (c *ReadCacheBackend) Delete(key string) error {
+	if err := c.Backend.Delete(key); err != nil {
+		return err
+	}
+	c.invalidate(key)
+	return nil
+}
+
+func (c *ReadCacheBackend) DeleteIfRevision(key string, revision uint64) error {
+	if err := c.Backend.DeleteIfRevision(key, revision); err != nil {
+		return err
+	}
+	c.invalidate(key)
+	return nil
+}
+
+// invalidate drops the cached reads of the key, of the keys under it and of
+// the prefixes it's under
+func (c *ReadCacheBackend) invalidate(key string) {
+	related := func(k string) bool {
+		return isUnder(k, key) || isUnder(key, k)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for k := range c.gets {
+		if related(k) {
+			delete(c.gets, k)
+		}
+	}
+	for prefix := range c.keys {
+		if related(prefix) {
+			delete(c.keys, prefix)
+		}
+	}
+	for prefix := range c.values {
+		if related(prefix) {
+			delete(c.values, prefix)
+		}
+	}
+}
+
+// isUnder is true if key is prefix or a key under it
+func isUnder(key, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, Separator)
+	return key == prefix || strings.HasPrefix(key, prefix+Separator)
+}
@@ -0,0 +1,191 @@
+package kvstore
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+
+	. "gopkg.in/check.v1"
+)
+
+var errFakeUnavailable = errors.Errorf("client: etcd cluster is unavailable or misconfigured")
+
+// downBackend fails all the calls while down
+type downBackend struct {
+	Backend
+	down bool
+}
+
+func (b *downBackend) Set(key string, obj interface{}) error {
+	if b.down {
+		return errFakeUnavailable
+	}
+	return b.Backend.Set(key, obj)
+}
+
+func (b *downBackend) Get(key string, obj interface{}) error {
+	if b.down {
+		return errFakeUnavailable
+	}
+	return b.Backend.Get(key, obj)
+}
+
+func (b *downBackend) Keys(prefix string) ([]string, error) {
+	if b.down {
+		return nil, errFakeUnavailable
+	}
+	return b.Backend.Keys(prefix)
+}
+
+func (b *downBackend) Values(prefix string) (map[string][]byte, error) {
+	if b.down {
+		return nil, errFakeUnavailable
+	}
+	return b.Backend.Values(prefix)
+}
+
+func (s *TestSuite) TestReadCache(c *C) {
+	memory, err := NewMemoryBackend()
+	c.Assert(err, IsNil)
+	backend := &downBackend{Backend: memory}
+	st, err := NewKVStore("/longhorn", NewReadCacheBackend(backend))
+	c.Assert(err, IsNil)
+
+	volume := generateTestVolume("volume1")
+	volume.Controller = generateTestController(volume.Name)
+	replica := generateTestReplica(volume.Name, "replica1")
+	volume.Replicas = map[string]*types.ReplicaInfo{replica.Name: replica}
+	c.Assert(st.SetVolume(volume), IsNil)
+	c.Assert(st.SetVolume(generateTestVolume("volume2")), IsNil)
+	c.Assert(st.SetSettings(&types.SettingsInfo{BackupTarget: "s3://backups@us-east-1/"}), IsNil)
+
+	// nothing was read yet
+	backend.down = true
+	_, err = st.GetVolume(volume.Name)
+	c.Assert(err, NotNil)
+	c.Assert(st.StaleSince().IsZero(), Equals, true)
+
+	backend.down = false
+	volumes, err := st.ListVolumes()
+	c.Assert(err, IsNil)
+	c.Assert(volumes, HasLen, 2)
+	settings, err := st.GetSettings()
+	c.Assert(err, IsNil)
+	c.Assert(st.StaleSince().IsZero(), Equals, true)
+
+	backend.down = true
+	comp, err := st.GetVolume(volume.Name)
+	c.Assert(err, IsNil)
+	c.Assert(comp, DeepEquals, volume)
+	c.Assert(st.StaleSince().IsZero(), Equals, false)
+	stale, err := st.ListVolumes()
+	c.Assert(err, IsNil)
+	c.Assert(stale, DeepEquals, volumes)
+	cached, err := st.GetSettings()
+	c.Assert(err, IsNil)
+	c.Assert(cached, DeepEquals, settings)
+
+	// the writes still fail
+	c.Assert(st.SetVolume(volume), NotNil)
+
+	backend.down = false
+	_, err = st.GetVolume(volume.Name)
+	c.Assert(err, IsNil)
+	c.Assert(st.StaleSince().IsZero(), Equals, true)
+
+	// the reads changed by a write are dropped
+	c.Assert(st.DeleteVolume(volume.Name), IsNil)
+	backend.down = true
+	_, err = st.GetVolume(volume.Name)
+	c.Assert(err, NotNil)
+}
+
+func (s *TestSuite) TestReadCacheWatched(c *C) {
+	memory, err := NewMemoryBackend()
+	c.Assert(err, IsNil)
+	backend := &downBackend{Backend: memory}
+	cache := NewReadCacheBackend(backend)
+	st, err := NewKVStore("/longhorn", cache)
+	c.Assert(err, IsNil)
+	// the other manager writes to the store directly
+	other, err := NewKVStore("/longhorn", memory)
+	c.Assert(err, IsNil)
+
+	c.Assert(st.SetVolume(generateTestVolume("volume1")), IsNil)
+	c.Assert(st.SetSettings(&types.SettingsInfo{BackupTarget: "s3://backups@us-east-1/"}), IsNil)
+	values, err := memory.Values("/longhorn")
+	c.Assert(err, IsNil)
+	b := &fakeWatchBackend{values: values, index: 10, events: make(chan interface{})}
+	w := NewWatcher(b, "/longhorn")
+	cache.Watch(w)
+
+	// not synced yet, only what was read is served
+	backend.down = true
+	_, err = st.GetSettings()
+	c.Assert(err, NotNil)
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		w.Run(stopCh)
+		close(done)
+	}()
+	defer func() {
+		close(stopCh)
+		<-done
+	}()
+	waitFor(c, w.Synced)
+
+	// send the changes of the other manager to the watcher
+	changed := func(key string, index uint64) {
+		values, err := memory.Values(key)
+		c.Assert(err, IsNil)
+		for k, v := range values {
+			b.events <- &WatchEvent{Key: k, Value: v, Index: index}
+		}
+		waitFor(c, func() bool { return w.Index() == index })
+	}
+	c.Assert(other.SetVolume(generateTestVolume("volume2")), IsNil)
+	changed("/longhorn/volumes/volume2", 11)
+	c.Assert(other.SetSettings(&types.SettingsInfo{BackupTarget: "s3://other@us-east-1/"}), IsNil)
+	changed(st.settingsKey(), 12)
+
+	volumes, err := st.ListVolumes()
+	c.Assert(err, IsNil)
+	c.Assert(volumes, HasLen, 2)
+	c.Assert(volumes[1].Name, Equals, "volume2")
+	settings, err := st.GetSettings()
+	c.Assert(err, IsNil)
+	c.Assert(settings.BackupTarget, Equals, "s3://other@us-east-1/")
+	c.Assert(st.StaleSince().IsZero(), Equals, false)
+
+	c.Assert(other.DeleteVolume("volume1"), IsNil)
+	b.events <- &WatchEvent{Key: "/longhorn/volumes/volume1", Deleted: true, Index: 13}
+	waitFor(c, func() bool { return w.Index() == 13 })
+	volumes, err = st.ListVolumes()
+	c.Assert(err, IsNil)
+	c.Assert(volumes, HasLen, 1)
+	c.Assert(volumes[0].Name, Equals, "volume2")
+
+	// the changes in between were compacted away, the values read again are
+	// served
+	c.Assert(other.DeleteVolume("volume2"), IsNil)
+	c.Assert(other.SetVolume(generateTestVolume("volume3")), IsNil)
+	values, err = memory.Values("/longhorn")
+	c.Assert(err, IsNil)
+	b.Lock()
+	b.values = values
+	b.index = 20
+	b.Unlock()
+	b.events <- errFakeCompacted
+	waitFor(c, func() bool { return w.Resyncs() == 1 })
+	volumes, err = st.ListVolumes()
+	c.Assert(err, IsNil)
+	c.Assert(volumes, HasLen, 1)
+	c.Assert(volumes[0].Name, Equals, "volume3")
+
+	backend.down = false
+	_, err = st.ListVolumes()
+	c.Assert(err, IsNil)
+	c.Assert(st.StaleSince().IsZero(), Equals, true)
+}
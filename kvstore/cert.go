@@ -0,0 +1,33 @@
+package kvstore
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const (
+	keyClusterCA = "clusterca"
+)
+
+func (s *KVStore) clusterCAKey() string {
+	return s.key(keyClusterCA)
+}
+
+func (s *KVStore) GetClusterCA() (*types.ClusterCA, error) {
+	ca := &types.ClusterCA{}
+	if err := s.b.Get(s.clusterCAKey(), ca); err != nil {
+		if s.b.IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "unable to get cluster CA")
+	}
+	return ca, nil
+}
+
+func (s *KVStore) SetClusterCA(ca *types.ClusterCA) error {
+	if err := s.b.Set(s.clusterCAKey(), ca); err != nil {
+		return errors.Wrap(err, "unable to set cluster CA")
+	}
+	return nil
+}
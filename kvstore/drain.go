@@ -0,0 +1,35 @@
+package kvstore
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const (
+	keyDrains = "drains"
+)
+
+func (s *KVStore) drainKey(hostID string) string {
+	return filepath.Join(s.key(keyDrains), hostID)
+}
+
+func (s *KVStore) GetDrainProgress(hostID string) (*types.DrainProgress, error) {
+	progress := &types.DrainProgress{}
+	if err := s.b.Get(s.drainKey(hostID), progress); err != nil {
+		if s.b.IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "unable to get drain progress of host %v", hostID)
+	}
+	return progress, nil
+}
+
+func (s *KVStore) SetDrainProgress(progress *types.DrainProgress) error {
+	if err := s.b.Set(s.drainKey(progress.HostID), progress); err != nil {
+		return errors.Wrapf(err, "unable to set drain progress of host %v", progress.HostID)
+	}
+	return nil
+}
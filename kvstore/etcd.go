@@ -1,7 +1,14 @@
 package kvstore
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -13,13 +20,44 @@ import (
 type ETCDBackend struct {
 	Servers []string
 
-	kapi eCli.KeysAPI
+	username string
+	kapi     eCli.KeysAPI
 }
 
-func NewETCDBackend(servers []string) (*ETCDBackend, error) {
+// ETCDOptions are how the backend reaches the servers besides their
+// addresses
+type ETCDOptions struct {
+	// TLS is the client config for the https servers, with the client
+	// certificate and the CA the servers are verified against. The servers
+	// are verified against the CAs of the system if nil.
+	TLS *tls.Config
+	// Username and Password authenticate the requests if the servers have
+	// authentication enabled
+	Username string
+	Password string
+}
+
+// ErrETCDUnauthorized is the servers refusing the credentials, or asking for
+// some
+type ErrETCDUnauthorized struct {
+	Servers  []string
+	Username string
+	Err      error
+}
+
+func (e *ErrETCDUnauthorized) Error() string {
+	if e.Username == "" {
+		return fmt.Sprintf("etcd servers %v require authentication: %v", e.Servers, e.Err)
+	}
+	return fmt.Sprintf("etcd servers %v refused the credentials of user %v: %v", e.Servers, e.Username, e.Err)
+}
+
+func NewETCDBackend(servers []string, opts ETCDOptions) (*ETCDBackend, error) {
 	eCfg := eCli.Config{
 		Endpoints:               servers,
-		Transport:               eCli.DefaultTransport,
+		Transport:               newETCDTransport(opts.TLS),
+		Username:                opts.Username,
+		Password:                opts.Password,
 		HeaderTimeoutPerRequest: time.Second,
 	}
 
@@ -30,11 +68,74 @@ func NewETCDBackend(servers []string) (*ETCDBackend, error) {
 	backend := &ETCDBackend{
 		Servers: servers,
 
-		kapi: eCli.NewKeysAPI(etcdc),
+		username: opts.Username,
+		kapi:     eCli.NewKeysAPI(etcdc),
 	}
 	return backend, nil
 }
 
+// Check reads the prefix, to fail early if the servers can't be reached or
+// refuse the credentials, the latter with ErrETCDUnauthorized
+func (s *ETCDBackend) Check(prefix string) error {
+	_, err := s.kapi.Get(context.Background(), prefix, nil)
+	if err == nil || eCli.IsKeyNotFound(err) {
+		return nil
+	}
+	if isUnauthorizedError(err) {
+		return &ErrETCDUnauthorized{Servers: s.Servers, Username: s.username, Err: err}
+	}
+	return errors.Wrapf(err, "cannot connect to etcd servers %v", s.Servers)
+}
+
+// isUnauthorizedError is true for the keys API refusing the credentials, or
+// the HTTP 401 of the servers, which only has a message
+func isUnauthorizedError(err error) bool {
+	cErr, ok := err.(eCli.Error)
+	if !ok {
+		return false
+	}
+	return cErr.Code == eCli.ErrorCodeUnauthorized ||
+		(cErr.Code == 0 && strings.Contains(strings.ToLower(cErr.Message), "credentials"))
+}
+
+// newETCDTransport is the default transport of the etcd client, with the TLS
+// config if any
+func newETCDTransport(tlsConfig *tls.Config) eCli.CancelableTransport {
+	if tlsConfig == nil {
+		return eCli.DefaultTransport
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}
+}
+
+// NewETCDTLSConfig loads the client certificate and key presented to the
+// etcd servers, and the CA in PEM the servers are verified against
+func NewETCDTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to load etcd client certificate %v with key %v", certFile, keyFile)
+	}
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to read etcd CA %v", caFile)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.Errorf("no certificate found in etcd CA %v", caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}, nil
+}
+
 func (s *ETCDBackend) Set(key string, obj interface{}) error {
 	value, err := json.Marshal(obj)
 	if err != nil {
@@ -46,10 +147,32 @@ func (s *ETCDBackend) Set(key string, obj interface{}) error {
 	return nil
 }
 
+func (s *ETCDBackend) SetIfRevision(key string, obj interface{}, revision uint64) error {
+	value, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	opts := &eCli.SetOptions{PrevIndex: revision}
+	if revision == 0 {
+		opts = &eCli.SetOptions{PrevExist: eCli.PrevNoExist}
+	}
+	if _, err := s.kapi.Set(context.Background(), key, string(value), opts); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *ETCDBackend) IsNotFoundError(err error) bool {
 	return eCli.IsKeyNotFound(err)
 }
 
+func (s *ETCDBackend) IsConflictError(err error) bool {
+	if cErr, ok := err.(eCli.Error); ok {
+		return cErr.Code == eCli.ErrorCodeTestFailed || cErr.Code == eCli.ErrorCodeNodeExist
+	}
+	return false
+}
+
 func (s *ETCDBackend) Get(key string, obj interface{}) error {
 	resp, err := s.kapi.Get(context.Background(), key, nil)
 	if err != nil {
@@ -66,6 +189,22 @@ func (s *ETCDBackend) Get(key string, obj interface{}) error {
 	return nil
 }
 
+func (s *ETCDBackend) GetWithRevision(key string, obj interface{}) (uint64, error) {
+	resp, err := s.kapi.Get(context.Background(), key, nil)
+	if err != nil {
+		return 0, err
+	}
+	node := resp.Node
+	if node.Dir {
+		return 0, errors.Errorf("invalid node %v is a directory",
+			node.Key)
+	}
+	if err := json.Unmarshal([]byte(node.Value), obj); err != nil {
+		return 0, errors.Wrap(err, "fail to unmarshal json")
+	}
+	return node.ModifiedIndex, nil
+}
+
 func (s *ETCDBackend) Keys(prefix string) ([]string, error) {
 	resp, err := s.kapi.Get(context.Background(), prefix, nil)
 	if err != nil {
@@ -86,6 +225,73 @@ func (s *ETCDBackend) Keys(prefix string) ([]string, error) {
 	return ret, nil
 }
 
+func (s *ETCDBackend) Revisions(prefix string) (map[string]uint64, error) {
+	resp, err := s.kapi.Get(context.Background(), prefix, &eCli.GetOptions{
+		Recursive: true,
+	})
+	if err != nil {
+		if eCli.IsKeyNotFound(err) {
+			return map[string]uint64{}, nil
+		}
+		return nil, err
+	}
+	ret := map[string]uint64{}
+	collectRevisions(resp.Node, ret)
+	return ret, nil
+}
+
+func collectRevisions(node *eCli.Node, revisions map[string]uint64) {
+	if !node.Dir {
+		revisions[node.Key] = node.ModifiedIndex
+		return
+	}
+	for _, n := range node.Nodes {
+		collectRevisions(n, revisions)
+	}
+}
+
+func (s *ETCDBackend) Values(prefix string) (map[string][]byte, error) {
+	resp, err := s.kapi.Get(context.Background(), prefix, &eCli.GetOptions{
+		Recursive: true,
+	})
+	if err != nil {
+		if eCli.IsKeyNotFound(err) {
+			return map[string][]byte{}, nil
+		}
+		return nil, err
+	}
+	ret := map[string][]byte{}
+	collectValues(resp.Node, ret)
+	return ret, nil
+}
+
+func collectValues(node *eCli.Node, values map[string][]byte) {
+	if !node.Dir {
+		values[node.Key] = []byte(node.Value)
+		return
+	}
+	for _, n := range node.Nodes {
+		collectValues(n, values)
+	}
+}
+
+func (s *ETCDBackend) ValuesWithRevisions(prefix string) (map[string][]byte, map[string]uint64, error) {
+	resp, err := s.kapi.Get(context.Background(), prefix, &eCli.GetOptions{
+		Recursive: true,
+	})
+	if err != nil {
+		if eCli.IsKeyNotFound(err) {
+			return map[string][]byte{}, map[string]uint64{}, nil
+		}
+		return nil, nil, err
+	}
+	values := map[string][]byte{}
+	revisions := map[string]uint64{}
+	collectValues(resp.Node, values)
+	collectRevisions(resp.Node, revisions)
+	return values, revisions, nil
+}
+
 func (s *ETCDBackend) Delete(key string) error {
 	_, err := s.kapi.Delete(context.Background(), key, &eCli.DeleteOptions{
 		Recursive: true,
@@ -98,3 +304,70 @@ func (s *ETCDBackend) Delete(key string) error {
 	}
 	return nil
 }
+
+func (s *ETCDBackend) DeleteIfRevision(key string, revision uint64) error {
+	_, err := s.kapi.Delete(context.Background(), key, &eCli.DeleteOptions{
+		PrevIndex: revision,
+	})
+	return err
+}
+
+func (s *ETCDBackend) Snapshot(prefix string) (map[string][]byte, uint64, error) {
+	resp, err := s.kapi.Get(context.Background(), prefix, &eCli.GetOptions{
+		Recursive: true,
+	})
+	if err != nil {
+		if cErr, ok := err.(eCli.Error); ok && cErr.Code == eCli.ErrorCodeKeyNotFound {
+			return map[string][]byte{}, cErr.Index, nil
+		}
+		return nil, 0, err
+	}
+	values := map[string][]byte{}
+	collectValues(resp.Node, values)
+	return values, resp.Index, nil
+}
+
+func (s *ETCDBackend) Watch(prefix string, afterIndex uint64) Watch {
+	return &etcdWatch{
+		w: s.kapi.Watcher(prefix, &eCli.WatcherOptions{
+			AfterIndex: afterIndex,
+			Recursive:  true,
+		}),
+	}
+}
+
+// IsCompactedError is true for "the event in requested index is outdated
+// and cleared", etcd keeps the last 1000 events only
+func (s *ETCDBackend) IsCompactedError(err error) bool {
+	if cErr, ok := err.(eCli.Error); ok {
+		return cErr.Code == eCli.ErrorCodeEventIndexCleared
+	}
+	return false
+}
+
+type etcdWatch struct {
+	w eCli.Watcher
+}
+
+func (w *etcdWatch) Next(ctx context.Context) (*WatchEvent, error) {
+	for {
+		resp, err := w.w.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		event := &WatchEvent{
+			Key:   resp.Node.Key,
+			Value: []byte(resp.Node.Value),
+			Index: resp.Node.ModifiedIndex,
+		}
+		switch resp.Action {
+		case "delete", "compareAndDelete", "expire":
+			event.Deleted = true
+		}
+		// a directory has no value, only its removal matters
+		if resp.Node.Dir && !event.Deleted {
+			continue
+		}
+		return event, nil
+	}
+}
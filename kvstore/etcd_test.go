@@ -0,0 +1,175 @@
+package kvstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+
+	. "gopkg.in/check.v1"
+)
+
+// fakeETCD serves the get and set of single keys of the etcd v2 API, to the
+// user only if set
+type fakeETCD struct {
+	mutex    sync.Mutex
+	values   map[string]string
+	index    int
+	username string
+	password string
+}
+
+func (f *fakeETCD) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := strings.TrimPrefix(req.URL.Path, "/v2/keys")
+	rw.Header().Set("Content-Type", "application/json")
+	if f.username != "" {
+		if username, password, ok := req.BasicAuth(); !ok || username != f.username || password != f.password {
+			rw.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(rw).Encode(map[string]interface{}{"message": "Insufficient credentials"})
+			return
+		}
+	}
+	switch req.Method {
+	case "PUT":
+		f.index++
+		f.values[key] = req.FormValue("value")
+		rw.Header().Set("X-Etcd-Index", fmt.Sprint(f.index))
+		rw.WriteHeader(http.StatusCreated)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"action": "set",
+			"node":   map[string]interface{}{"key": key, "value": f.values[key], "modifiedIndex": f.index},
+		})
+	case "GET":
+		value, ok := f.values[key]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(rw).Encode(map[string]interface{}{
+				"errorCode": 100, "message": "Key not found", "cause": key, "index": f.index,
+			})
+			return
+		}
+		rw.Header().Set("X-Etcd-Index", fmt.Sprint(f.index))
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"action": "get",
+			"node":   map[string]interface{}{"key": key, "value": value, "modifiedIndex": f.index},
+		})
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// writeCert issues a certificate of the CA for the hosts, and writes it with
+// its key into dir
+func writeCert(c *C, dir, name, caCert, caKey string, hosts []string) (string, string) {
+	cert, key, err := util.IssueCert(caCert, caKey, name, hosts, time.Hour)
+	c.Assert(err, IsNil)
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	c.Assert(ioutil.WriteFile(certFile, []byte(cert), 0600), IsNil)
+	c.Assert(ioutil.WriteFile(keyFile, []byte(key), 0600), IsNil)
+	return certFile, keyFile
+}
+
+func (s *TestSuite) TestETCDTLS(c *C) {
+	dir := c.MkDir()
+	caCert, caKey, err := util.GenerateCA("etcd-ca", time.Hour)
+	c.Assert(err, IsNil)
+	caFile := filepath.Join(dir, "ca.pem")
+	c.Assert(ioutil.WriteFile(caFile, []byte(caCert), 0600), IsNil)
+	serverCertFile, serverKeyFile := writeCert(c, dir, "etcd", caCert, caKey, []string{"127.0.0.1"})
+	clientCertFile, clientKeyFile := writeCert(c, dir, "client", caCert, caKey, nil)
+
+	// the server requires a client certificate of the CA
+	serverCert, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+	c.Assert(err, IsNil)
+	pool := x509.NewCertPool()
+	c.Assert(pool.AppendCertsFromPEM([]byte(caCert)), Equals, true)
+	server := httptest.NewUnstartedServer(&fakeETCD{values: map[string]string{}})
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	tlsConfig, err := NewETCDTLSConfig(clientCertFile, clientKeyFile, caFile)
+	c.Assert(err, IsNil)
+	backend, err := NewETCDBackend([]string{server.URL}, ETCDOptions{TLS: tlsConfig})
+	c.Assert(err, IsNil)
+	host := &types.HostInfo{UUID: "host-1", Name: "host-1", Address: "10.0.0.1:9500"}
+	c.Assert(backend.Set("/longhorn/hosts/host-1", host), IsNil)
+	stored := &types.HostInfo{}
+	c.Assert(backend.Get("/longhorn/hosts/host-1", stored), IsNil)
+	c.Assert(stored, DeepEquals, host)
+	err = backend.Get("/longhorn/hosts/host-2", stored)
+	c.Assert(backend.IsNotFoundError(err), Equals, true)
+
+	// no client certificate
+	backend, err = NewETCDBackend([]string{server.URL}, ETCDOptions{TLS: &tls.Config{RootCAs: tlsConfig.RootCAs}})
+	c.Assert(err, IsNil)
+	c.Assert(backend.Set("/longhorn/hosts/host-1", host), NotNil)
+
+	// the server isn't of the CA
+	otherCA, _, err := util.GenerateCA("other-ca", time.Hour)
+	c.Assert(err, IsNil)
+	otherCAFile := filepath.Join(dir, "other-ca.pem")
+	c.Assert(ioutil.WriteFile(otherCAFile, []byte(otherCA), 0600), IsNil)
+	otherConfig, err := NewETCDTLSConfig(clientCertFile, clientKeyFile, otherCAFile)
+	c.Assert(err, IsNil)
+	backend, err = NewETCDBackend([]string{server.URL}, ETCDOptions{TLS: otherConfig})
+	c.Assert(err, IsNil)
+	err = backend.Get("/longhorn/hosts/host-1", stored)
+	c.Assert(err, NotNil)
+	c.Assert(backend.IsNotFoundError(err), Equals, false)
+
+	_, err = NewETCDTLSConfig(clientCertFile, serverKeyFile, caFile)
+	c.Assert(err, ErrorMatches, "fail to load etcd client certificate .*")
+	_, err = NewETCDTLSConfig(clientCertFile, clientKeyFile, filepath.Join(dir, "missing.pem"))
+	c.Assert(err, ErrorMatches, "fail to read etcd CA .*")
+	_, err = NewETCDTLSConfig(clientCertFile, clientKeyFile, clientKeyFile)
+	c.Assert(err, ErrorMatches, "no certificate found in etcd CA .*")
+}
+
+func (s *TestSuite) TestETCDAuth(c *C) {
+	server := httptest.NewServer(&fakeETCD{values: map[string]string{}, username: "longhorn", password: "secret"})
+	defer server.Close()
+
+	backend, err := NewETCDBackend([]string{server.URL}, ETCDOptions{Username: "longhorn", Password: "secret"})
+	c.Assert(err, IsNil)
+	c.Assert(backend.Check("/longhorn"), IsNil)
+	host := &types.HostInfo{UUID: "host-1", Name: "host-1", Address: "10.0.0.1:9500"}
+	c.Assert(backend.Set("/longhorn/hosts/host-1", host), IsNil)
+	stored := &types.HostInfo{}
+	c.Assert(backend.Get("/longhorn/hosts/host-1", stored), IsNil)
+	c.Assert(stored, DeepEquals, host)
+
+	backend, err = NewETCDBackend([]string{server.URL}, ETCDOptions{Username: "longhorn", Password: "wrong"})
+	c.Assert(err, IsNil)
+	err = backend.Check("/longhorn")
+	c.Assert(err, FitsTypeOf, &ErrETCDUnauthorized{})
+	c.Assert(err, ErrorMatches, ".*refused the credentials of user longhorn.*")
+
+	backend, err = NewETCDBackend([]string{server.URL}, ETCDOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(backend.Check("/longhorn"), ErrorMatches, ".*require authentication.*")
+
+	// not an authentication failure
+	server.Close()
+	err = backend.Check("/longhorn")
+	c.Assert(err, ErrorMatches, "(?s)cannot connect to etcd servers .*connection refused.*")
+	_, ok := err.(*ErrETCDUnauthorized)
+	c.Assert(ok, Equals, false)
+}
@@ -0,0 +1,283 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+const (
+	keyEvents    = "events"
+	keyEventMeta = "eventmeta"
+)
+
+// eventBatch is written to a key of its own, so appending events takes a
+// single write without reading the earlier ones
+type eventBatch struct {
+	Events []*types.VolumeEvent `json:"events"`
+}
+
+// eventMeta is kept by volume next to its batches: the batches kept, those
+// claimed for removal but not removed yet, and the number of events removed.
+// Claiming the batches first counts them once when managers remove the same
+// batches at once.
+type eventMeta struct {
+	Keep     map[string]bool `json:"keep,omitempty"`
+	Removing map[string]bool `json:"removing,omitempty"`
+	Removed  int64           `json:"removed,omitempty"`
+}
+
+func (s *KVStore) volumeEventsKey(volumeName string) string {
+	return filepath.Join(s.key(keyEvents), volumeName)
+}
+
+func (s *KVStore) volumeEventMetaKey(volumeName string) string {
+	return filepath.Join(s.key(keyEventMeta), volumeName)
+}
+
+// AppendVolumeEvents writes the events as one batch, under a key sorted after
+// the earlier batches. The oldest batches beyond VolumeEventBatchesLimit are
+// removed, except those kept.
+func (s *KVStore) AppendVolumeEvents(volumeName string, events []*types.VolumeEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	prefix := s.volumeEventsKey(volumeName)
+	key := filepath.Join(prefix, fmt.Sprintf("%020d-%v", time.Now().UnixNano(), util.RandomID()))
+	if err := s.b.Set(key, &eventBatch{Events: events}); err != nil {
+		return errors.Wrapf(err, "unable to append events of volume %v", volumeName)
+	}
+
+	keys, err := s.b.Keys(prefix)
+	if err != nil {
+		return errors.Wrapf(err, "unable to list event batches of volume %v", volumeName)
+	}
+	if len(keys) <= VolumeEventBatchesLimit {
+		return nil
+	}
+	if _, err := s.removeEventBatches(volumeName, func(ids []string, retained int, batches map[string]*eventBatch) []string {
+		excess := retained - VolumeEventBatchesLimit
+		if excess <= 0 {
+			return nil
+		}
+		if excess > len(ids) {
+			excess = len(ids)
+		}
+		return ids[:excess]
+	}); err != nil {
+		return errors.Wrapf(err, "unable to remove old event batches of volume %v", volumeName)
+	}
+	return nil
+}
+
+// readEventBatches returns the batches of the volume by ID, and their IDs
+// oldest first
+func (s *KVStore) readEventBatches(volumeName string) ([]string, map[string]*eventBatch, error) {
+	prefix := s.volumeEventsKey(volumeName)
+	values, err := s.b.Values(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := []string{}
+	batches := map[string]*eventBatch{}
+	for k, v := range values {
+		batch := &eventBatch{}
+		if err := json.Unmarshal(v, batch); err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to unmarshal event batch %v", k)
+		}
+		id := strings.TrimPrefix(k, prefix+Separator)
+		ids = append(ids, id)
+		batches[id] = batch
+	}
+	sort.Strings(ids)
+	return ids, batches, nil
+}
+
+func (s *KVStore) getEventMeta(volumeName string) (*eventMeta, uint64, error) {
+	meta := &eventMeta{}
+	revision, err := s.b.GetWithRevision(s.volumeEventMetaKey(volumeName), meta)
+	if err != nil {
+		if !s.b.IsNotFoundError(err) {
+			return nil, 0, errors.Wrapf(err, "unable to get event meta of volume %v", volumeName)
+		}
+		return &eventMeta{}, 0, nil
+	}
+	return meta, revision, nil
+}
+
+// removeEventBatches removes the batches picked among the IDs of those not
+// kept, oldest first, and returns their events. retained is the number of
+// batches not removed yet, kept or not.
+func (s *KVStore) removeEventBatches(volumeName string, pick func(ids []string, retained int, batches map[string]*eventBatch) []string) ([][]*types.VolumeEvent, error) {
+	for {
+		meta, revision, err := s.getEventMeta(volumeName)
+		if err != nil {
+			return nil, err
+		}
+		ids, batches, err := s.readEventBatches(volumeName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list event batches of volume %v", volumeName)
+		}
+		claimed := []string{}
+		candidates := []string{}
+		for _, id := range ids {
+			if meta.Removing[id] {
+				claimed = append(claimed, id)
+			} else if !meta.Keep[id] {
+				candidates = append(candidates, id)
+			}
+		}
+		picked := pick(candidates, len(ids)-len(claimed), batches)
+
+		removed := [][]*types.VolumeEvent{}
+		if len(picked) != 0 {
+			meta.Removing = map[string]bool{}
+			for _, id := range append(claimed, picked...) {
+				meta.Removing[id] = true
+			}
+			for _, id := range picked {
+				meta.Removed += int64(len(batches[id].Events))
+				removed = append(removed, batches[id].Events)
+			}
+			err := s.b.SetIfRevision(s.volumeEventMetaKey(volumeName), meta, revision)
+			if err != nil {
+				if s.b.IsConflictError(err) {
+					logrus.Debugf("event meta of volume %v modified concurrently, retrying", volumeName)
+					continue
+				}
+				return nil, errors.Wrapf(err, "unable to set event meta of volume %v", volumeName)
+			}
+		}
+		// the batches claimed before are removed again, in case their
+		// manager failed to
+		for _, id := range append(claimed, picked...) {
+			if err := s.b.Delete(filepath.Join(s.volumeEventsKey(volumeName), id)); err != nil {
+				return removed, errors.Wrapf(err, "unable to remove event batch %v of volume %v", id, volumeName)
+			}
+		}
+		return removed, nil
+	}
+}
+
+func (s *KVStore) ListVolumeEvents(volumeName string) ([]*types.VolumeEvent, error) {
+	meta, _, err := s.getEventMeta(volumeName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list events of volume %v", volumeName)
+	}
+	ids, batches, err := s.readEventBatches(volumeName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list events of volume %v", volumeName)
+	}
+
+	events := []*types.VolumeEvent{}
+	for _, id := range ids {
+		if meta.Removing[id] {
+			continue
+		}
+		keep := meta.Keep[id]
+		for _, e := range batches[id].Events {
+			e.Batch = id
+			e.Keep = keep
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// PruneVolumeEvents removes the batches for which expired is true, except
+// those kept, and returns their events
+func (s *KVStore) PruneVolumeEvents(volumeName string, expired func(events []*types.VolumeEvent) bool) ([][]*types.VolumeEvent, error) {
+	removed, err := s.removeEventBatches(volumeName, func(ids []string, retained int, batches map[string]*eventBatch) []string {
+		picked := []string{}
+		for _, id := range ids {
+			if expired(batches[id].Events) {
+				picked = append(picked, id)
+			}
+		}
+		return picked
+	})
+	if err != nil {
+		return removed, errors.Wrapf(err, "unable to prune events of volume %v", volumeName)
+	}
+	return removed, nil
+}
+
+// KeepVolumeEvents sets if the batch is exempt from the limit and the record
+// GC
+func (s *KVStore) KeepVolumeEvents(volumeName, batch string, keep bool) error {
+	for {
+		meta, revision, err := s.getEventMeta(volumeName)
+		if err != nil {
+			return err
+		}
+		found := &eventBatch{}
+		if err := s.b.Get(filepath.Join(s.volumeEventsKey(volumeName), batch), found); err != nil {
+			if s.b.IsNotFoundError(err) {
+				return errors.Errorf("cannot find event batch %v of volume %v", batch, volumeName)
+			}
+			return errors.Wrapf(err, "unable to get event batch %v of volume %v", batch, volumeName)
+		}
+		if meta.Removing[batch] {
+			return errors.Errorf("cannot find event batch %v of volume %v", batch, volumeName)
+		}
+		if meta.Keep[batch] == keep {
+			return nil
+		}
+		if meta.Keep == nil {
+			meta.Keep = map[string]bool{}
+		}
+		if keep {
+			meta.Keep[batch] = true
+		} else {
+			delete(meta.Keep, batch)
+		}
+		err = s.b.SetIfRevision(s.volumeEventMetaKey(volumeName), meta, revision)
+		if err == nil {
+			return nil
+		}
+		if !s.b.IsConflictError(err) {
+			return errors.Wrapf(err, "unable to set event meta of volume %v", volumeName)
+		}
+		logrus.Debugf("event meta of volume %v modified concurrently, retrying", volumeName)
+	}
+}
+
+// VolumeEventCounts counts the events of the volume, those removed included
+// in the total
+func (s *KVStore) VolumeEventCounts(volumeName string) (*types.RecordCounts, error) {
+	meta, _, err := s.getEventMeta(volumeName)
+	if err != nil {
+		return nil, err
+	}
+	ids, batches, err := s.readEventBatches(volumeName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to count events of volume %v", volumeName)
+	}
+	counts := &types.RecordCounts{}
+	for _, id := range ids {
+		if !meta.Removing[id] {
+			counts.Retained += int64(len(batches[id].Events))
+		}
+	}
+	counts.Total = counts.Retained + meta.Removed
+	return counts, nil
+}
+
+func (s *KVStore) DeleteVolumeEvents(volumeName string) error {
+	if err := s.b.Delete(s.volumeEventsKey(volumeName)); err != nil {
+		return errors.Wrapf(err, "unable to remove events of volume %v", volumeName)
+	}
+	if err := s.b.Delete(s.volumeEventMetaKey(volumeName)); err != nil {
+		return errors.Wrapf(err, "unable to remove event meta of volume %v", volumeName)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package kvstore
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const (
+	keyVolumeGroups = "volumegroups"
+)
+
+func (s *KVStore) volumeGroupKey(name string) string {
+	return filepath.Join(s.key(keyVolumeGroups), name)
+}
+
+func (s *KVStore) GetVolumeGroup(name string) (*types.VolumeGroup, error) {
+	group := &types.VolumeGroup{}
+	if err := s.b.Get(s.volumeGroupKey(name), group); err != nil {
+		if s.b.IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "unable to get volume group %v", name)
+	}
+	return group, nil
+}
+
+func (s *KVStore) ListVolumeGroups() (map[string]*types.VolumeGroup, error) {
+	keys, err := s.b.Keys(s.key(keyVolumeGroups))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list volume groups")
+	}
+	groups := map[string]*types.VolumeGroup{}
+	for _, key := range keys {
+		group, err := s.GetVolumeGroup(filepath.Base(key))
+		if err != nil {
+			return nil, err
+		}
+		if group != nil {
+			groups[group.Name] = group
+		}
+	}
+	return groups, nil
+}
+
+func (s *KVStore) SetVolumeGroup(group *types.VolumeGroup) error {
+	if err := s.b.Set(s.volumeGroupKey(group.Name), group); err != nil {
+		return errors.Wrapf(err, "unable to set volume group %v", group.Name)
+	}
+	return nil
+}
+
+func (s *KVStore) DeleteVolumeGroup(name string) error {
+	if err := s.b.Delete(s.volumeGroupKey(name)); err != nil {
+		return errors.Wrapf(err, "unable to delete volume group %v", name)
+	}
+	return nil
+}
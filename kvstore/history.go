@@ -0,0 +1,205 @@
+package kvstore
+
+import (
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const (
+	keyHistory = "history"
+)
+
+type historyRecord struct {
+	Transitions []types.StateTransition `json:"transitions"`
+	// Removed counts the transitions removed by the limit or the record GC
+	Removed int64 `json:"removed,omitempty"`
+}
+
+// number assigns their sequence number to the transitions recorded before
+// they had one
+func (r *historyRecord) number() {
+	for i := range r.Transitions {
+		if r.Transitions[i].Seq == 0 {
+			r.Transitions[i].Seq = r.Removed + int64(i) + 1
+		}
+	}
+}
+
+// remove drops the transitions picked, oldest first, among those not kept
+// except the latest one, the state of the volume. It returns them.
+func (r *historyRecord) remove(pick func(t *types.StateTransition) bool) []types.StateTransition {
+	kept := []types.StateTransition{}
+	removed := []types.StateTransition{}
+	for i := range r.Transitions {
+		t := r.Transitions[i]
+		if i != len(r.Transitions)-1 && !t.Keep && pick(&t) {
+			removed = append(removed, t)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	r.Transitions = kept
+	r.Removed += int64(len(removed))
+	return removed
+}
+
+func (s *KVStore) volumeHistoryKey(volumeName string) string {
+	return filepath.Join(s.key(keyHistory), volumeName)
+}
+
+// RecordVolumeState appends the transition at the time of the change to the
+// history of the volume, a transition recorded already isn't recorded again.
+// The oldest transitions beyond VolumeHistoryLimit are removed, except those
+// kept.
+func (s *KVStore) RecordVolumeState(volumeName string, state types.VolumeState, hostID, at string) (bool, error) {
+	key := s.volumeHistoryKey(volumeName)
+	for {
+		record := &historyRecord{}
+		revision, err := s.b.GetWithRevision(key, record)
+		if err != nil {
+			if !s.b.IsNotFoundError(err) {
+				return false, errors.Wrapf(err, "unable to get history of volume %v", volumeName)
+			}
+			record, revision = &historyRecord{}, 0
+		}
+		from := types.VolumeStateNone
+		if n := len(record.Transitions); n != 0 {
+			from = record.Transitions[n-1].To
+		}
+		if from == state {
+			return false, nil
+		}
+		record.number()
+		record.Transitions = append(record.Transitions, types.StateTransition{
+			Time:   at,
+			From:   from,
+			To:     state,
+			HostID: hostID,
+			Seq:    record.Removed + int64(len(record.Transitions)) + 1,
+		})
+		excess := len(record.Transitions) - VolumeHistoryLimit
+		record.remove(func(t *types.StateTransition) bool {
+			excess--
+			return excess >= 0
+		})
+
+		err = s.b.SetIfRevision(key, record, revision)
+		if err == nil {
+			return true, nil
+		}
+		if !s.b.IsConflictError(err) {
+			return false, errors.Wrapf(err, "unable to set history of volume %v", volumeName)
+		}
+		logrus.Debugf("history of volume %v modified concurrently, retrying", volumeName)
+	}
+}
+
+func (s *KVStore) GetVolumeHistory(volumeName string, limit int) ([]types.StateTransition, error) {
+	record := &historyRecord{}
+	if err := s.b.Get(s.volumeHistoryKey(volumeName), record); err != nil {
+		if s.b.IsNotFoundError(err) {
+			return []types.StateTransition{}, nil
+		}
+		return nil, errors.Wrapf(err, "unable to get history of volume %v", volumeName)
+	}
+	record.number()
+	transitions := record.Transitions
+	if limit > 0 && len(transitions) > limit {
+		transitions = transitions[len(transitions)-limit:]
+	}
+	if transitions == nil {
+		transitions = []types.StateTransition{}
+	}
+	return transitions, nil
+}
+
+// updateVolumeHistory applies update to the history of the volume, unless
+// it has none, and retries if it's modified concurrently
+func (s *KVStore) updateVolumeHistory(volumeName string, update func(record *historyRecord) (bool, error)) error {
+	key := s.volumeHistoryKey(volumeName)
+	for {
+		record := &historyRecord{}
+		revision, err := s.b.GetWithRevision(key, record)
+		if err != nil {
+			if s.b.IsNotFoundError(err) {
+				return nil
+			}
+			return errors.Wrapf(err, "unable to get history of volume %v", volumeName)
+		}
+		record.number()
+		changed, err := update(record)
+		if err != nil || !changed {
+			return err
+		}
+		err = s.b.SetIfRevision(key, record, revision)
+		if err == nil {
+			return nil
+		}
+		if !s.b.IsConflictError(err) {
+			return errors.Wrapf(err, "unable to set history of volume %v", volumeName)
+		}
+		logrus.Debugf("history of volume %v modified concurrently, retrying", volumeName)
+	}
+}
+
+// PruneVolumeHistory removes the transitions for which expired is true,
+// except those kept and the latest one, and returns them
+func (s *KVStore) PruneVolumeHistory(volumeName string, expired func(transition *types.StateTransition) bool) ([]types.StateTransition, error) {
+	removed := []types.StateTransition{}
+	err := s.updateVolumeHistory(volumeName, func(record *historyRecord) (bool, error) {
+		removed = record.remove(expired)
+		return len(removed) != 0, nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to prune history of volume %v", volumeName)
+	}
+	return removed, nil
+}
+
+// KeepVolumeState sets if the transition of the sequence number is exempt
+// from the limit and the record GC
+func (s *KVStore) KeepVolumeState(volumeName string, seq int64, keep bool) error {
+	found := false
+	if err := s.updateVolumeHistory(volumeName, func(record *historyRecord) (bool, error) {
+		for i := range record.Transitions {
+			if record.Transitions[i].Seq == seq {
+				found = true
+				changed := record.Transitions[i].Keep != keep
+				record.Transitions[i].Keep = keep
+				return changed, nil
+			}
+		}
+		return false, nil
+	}); err != nil {
+		return err
+	}
+	if !found {
+		return errors.Errorf("cannot find state transition %v of volume %v", seq, volumeName)
+	}
+	return nil
+}
+
+// VolumeHistoryCounts counts the transitions of the volume, those removed
+// included in the total
+func (s *KVStore) VolumeHistoryCounts(volumeName string) (*types.RecordCounts, error) {
+	record := &historyRecord{}
+	if err := s.b.Get(s.volumeHistoryKey(volumeName), record); err != nil {
+		if s.b.IsNotFoundError(err) {
+			return &types.RecordCounts{}, nil
+		}
+		return nil, errors.Wrapf(err, "unable to get history of volume %v", volumeName)
+	}
+	retained := int64(len(record.Transitions))
+	return &types.RecordCounts{Total: retained + record.Removed, Retained: retained}, nil
+}
+
+func (s *KVStore) DeleteVolumeHistory(volumeName string) error {
+	if err := s.b.Delete(s.volumeHistoryKey(volumeName)); err != nil {
+		return errors.Wrapf(err, "unable to remove history of volume %v", volumeName)
+	}
+	return nil
+}
@@ -1,12 +1,29 @@
 package kvstore
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"path/filepath"
+	"sort"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
 
 	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	SettingsHistoryLimit = 20
+
+	// the oldest batches of events of a volume beyond the limit are removed
+	VolumeEventBatchesLimit = 100
+
+	// the oldest state transitions of a volume beyond the limit are removed
+	VolumeHistoryLimit = 100
 )
 
 type Backend interface {
@@ -14,13 +31,34 @@ type Backend interface {
 	Get(key string, obj interface{}) error
 	Delete(key string) error
 	Keys(prefix string) ([]string, error)
+	Revisions(prefix string) (map[string]uint64, error) // modification revisions of all the keys under prefix
+	Values(prefix string) (map[string][]byte, error)    // values of all the keys under prefix, in a single read
+	// ValuesWithRevisions returns the values and the modification revisions
+	// of all the keys under prefix, in a single read
+	ValuesWithRevisions(prefix string) (map[string][]byte, map[string]uint64, error)
 	IsNotFoundError(err error) bool
+
+	GetWithRevision(key string, obj interface{}) (uint64, error)
+	// SetIfRevision fails with a conflict error if key was modified after
+	// revision. Revision 0 only sets a key which doesn't exist.
+	SetIfRevision(key string, obj interface{}, revision uint64) error
+	// DeleteIfRevision removes the key, not a directory, and fails with a
+	// conflict error if it was modified after revision, or a not found
+	// error if it doesn't exist
+	DeleteIfRevision(key string, revision uint64) error
+	IsConflictError(err error) bool
 }
 
 type KVStore struct {
 	Prefix string
 
 	b Backend
+
+	// writer stamps the records written, none if nil
+	writer *types.WriterInfo
+
+	// writes throttles the writes, unlimited if nil
+	writes *writeLimiter
 }
 
 const (
@@ -38,23 +76,113 @@ func NewKVStore(prefix string, backend Backend) (*KVStore, error) {
 	}, nil
 }
 
+// SetWriter sets the stamp of the records written from now on
+func (s *KVStore) SetWriter(writer *types.WriterInfo) {
+	s.writer = writer
+}
+
+// stamp returns the writer of a record, the previous one if the store has
+// none
+func (s *KVStore) stamp(previous *types.WriterInfo) *types.WriterInfo {
+	if s.writer == nil {
+		return previous
+	}
+	w := *s.writer
+	return &w
+}
+
+// StaleSince is when the reads started being served from the cache of the
+// backend, zero if they are fresh or the backend has no cache
+func (s *KVStore) StaleSince() time.Time {
+	if c, ok := s.b.(*ReadCacheBackend); ok {
+		return c.StaleSince()
+	}
+	return time.Time{}
+}
+
 func (s *KVStore) key(key string) string {
 	// It's not file path, but we use it to deal with '/'
 	return filepath.Join(s.Prefix, key)
 }
 
+// Revision returns a digest of the revisions of all the keys under key. It
+// changes whenever any of them is set or deleted.
+func (s *KVStore) Revision(key string) (string, error) {
+	revisions, err := s.b.Revisions(s.key(key))
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to get revisions of %v", key)
+	}
+	keys := []string{}
+	for k := range revisions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha1.New()
+	fmt.Fprintf(h, "%v\n", s.key(key))
+	for _, k := range keys {
+		fmt.Fprintf(h, "%v=%v\n", k, revisions[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func (s *KVStore) hostKey(id string) string {
 	return filepath.Join(s.key(keyHosts), id)
 }
 
 func (s *KVStore) SetHost(host *types.HostInfo) error {
-	if err := s.b.Set(s.hostKey(host.UUID), host); err != nil {
+	h := *host
+	h.Writer = s.stamp(host.Writer)
+	s.writes.wait()
+	if err := s.b.Set(s.hostKey(host.UUID), &h); err != nil {
 		return err
 	}
 	logrus.Infof("Add host %v name %v longhorn-manager address %v", host.UUID, host.Name, host.Address)
 	return nil
 }
 
+// UpdateHost applies update to the record of the host, retrying if the
+// record is modified concurrently. update is given nil if the host has no
+// record, and nothing is written if it returns nil.
+func (s *KVStore) UpdateHost(id string, update func(*types.HostInfo) (*types.HostInfo, error)) error {
+	key := s.hostKey(id)
+	for {
+		host := &types.HostInfo{}
+		revision, err := s.b.GetWithRevision(key, host)
+		if err != nil {
+			if !s.b.IsNotFoundError(err) {
+				return errors.Wrapf(err, "unable to get host %v", id)
+			}
+			host, revision = nil, 0
+		}
+		updated, err := update(host)
+		if err != nil {
+			return err
+		}
+		if updated == nil {
+			return nil
+		}
+		h := *updated
+		h.Writer = s.stamp(updated.Writer)
+		s.writes.wait()
+		err = s.b.SetIfRevision(key, &h, revision)
+		if err == nil {
+			return nil
+		}
+		if !s.b.IsConflictError(err) {
+			return errors.Wrapf(err, "unable to set host %v", id)
+		}
+		logrus.Debugf("host %v modified concurrently, retrying", id)
+	}
+}
+
+func (s *KVStore) DeleteHost(id string) error {
+	if err := s.b.Delete(s.hostKey(id)); err != nil {
+		return errors.Wrapf(err, "unable to remove host %v", id)
+	}
+	logrus.Infof("Removed host %v", id)
+	return nil
+}
+
 func (s *KVStore) GetHost(id string) (*types.HostInfo, error) {
 	host, err := s.getHostByKey(s.hostKey(id))
 	if err != nil {
@@ -93,17 +221,210 @@ func (s *KVStore) ListHosts() (map[string]*types.HostInfo, error) {
 	return hosts, nil
 }
 
+// GetHosts reads the hosts in a single read. Every ID is in the result, with
+// nil for the hosts not found.
+func (s *KVStore) GetHosts(ids []string) (map[string]*types.HostInfo, error) {
+	start := time.Now()
+	values, err := s.b.Values(s.key(keyHosts))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get hosts")
+	}
+	hosts := map[string]*types.HostInfo{}
+	for _, id := range ids {
+		hosts[id] = nil
+		value, ok := values[s.hostKey(id)]
+		if !ok {
+			continue
+		}
+		host := &types.HostInfo{}
+		if err := json.Unmarshal(value, host); err != nil {
+			return nil, errors.Wrapf(err, "invalid host %v", id)
+		}
+		hosts[id] = host
+	}
+	logrus.Debugf("got %v hosts in %v", len(ids), time.Since(start))
+	return hosts, nil
+}
+
 func (s *KVStore) settingsKey() string {
 	return s.key(keySettings)
 }
 
+// settingsRecord keeps the history in the same key as the settings, so they
+// are always written together
+type settingsRecord struct {
+	types.SettingsInfo
+
+	Revision int64                     `json:"revision,omitempty"`
+	History  []*types.SettingsRevision `json:"history,omitempty"`
+}
+
+// remove drops the revisions picked, oldest first, among those not kept
+// except the latest one, the current settings. It returns them.
+func (r *settingsRecord) remove(pick func(r *types.SettingsRevision) bool) []*types.SettingsRevision {
+	kept := []*types.SettingsRevision{}
+	removed := []*types.SettingsRevision{}
+	for i, revision := range r.History {
+		if i != len(r.History)-1 && !revision.Keep && pick(revision) {
+			removed = append(removed, revision)
+			continue
+		}
+		kept = append(kept, revision)
+	}
+	r.History = kept
+	return removed
+}
+
 func (s *KVStore) SetSettings(settings *types.SettingsInfo) error {
-	if err := s.b.Set(s.settingsKey(), settings); err != nil {
+	return s.UpdateSettings("", nil, func(si *types.SettingsInfo) error {
+		*si = *settings
+		return nil
+	})
+}
+
+// UpdateSettings applies update to the current settings, or to defaults if
+// there are none yet, and records the change in the history. It retries if
+// the settings are modified concurrently.
+func (s *KVStore) UpdateSettings(author string, defaults *types.SettingsInfo, update func(*types.SettingsInfo) error) error {
+	for {
+		record := &settingsRecord{}
+		revision, err := s.b.GetWithRevision(s.settingsKey(), record)
+		if err != nil {
+			if !s.b.IsNotFoundError(err) {
+				return errors.Wrap(err, "unable to get settings")
+			}
+			record = &settingsRecord{}
+			if defaults != nil {
+				record.SettingsInfo = *defaults
+			}
+			revision = 0
+		}
+
+		previous := record.SettingsInfo
+		if err := update(&record.SettingsInfo); err != nil {
+			return err
+		}
+		record.Revision++
+		record.History = append(record.History, &types.SettingsRevision{
+			Revision: record.Revision,
+			Author:   author,
+			Time:     util.Now(),
+			Previous: previous,
+			Settings: record.SettingsInfo,
+			Writer:   s.stamp(nil),
+		})
+		excess := len(record.History) - SettingsHistoryLimit
+		record.remove(func(r *types.SettingsRevision) bool {
+			excess--
+			return excess >= 0
+		})
+
+		s.writes.wait()
+		err = s.b.SetIfRevision(s.settingsKey(), record, revision)
+		if err == nil {
+			logrus.Infof("Updated settings to revision %v by %v", record.Revision, author)
+			return nil
+		}
+		if !s.b.IsConflictError(err) {
+			return errors.Wrap(err, "unable to set settings")
+		}
+		logrus.Debugf("settings modified concurrently, retrying")
+	}
+}
+
+// GetSettingsHistory returns the latest revisions of the settings, oldest
+// first
+func (s *KVStore) GetSettingsHistory() ([]*types.SettingsRevision, error) {
+	record := &settingsRecord{}
+	if err := s.b.Get(s.settingsKey(), record); err != nil {
+		if s.b.IsNotFoundError(err) {
+			return []*types.SettingsRevision{}, nil
+		}
+		return nil, errors.Wrap(err, "unable to get settings history")
+	}
+	if record.History == nil {
+		return []*types.SettingsRevision{}, nil
+	}
+	return record.History, nil
+}
+
+// updateSettingsRecord applies update to the settings record, unless there
+// is none, and retries if it's modified concurrently
+func (s *KVStore) updateSettingsRecord(update func(record *settingsRecord) (bool, error)) error {
+	for {
+		record := &settingsRecord{}
+		revision, err := s.b.GetWithRevision(s.settingsKey(), record)
+		if err != nil {
+			if s.b.IsNotFoundError(err) {
+				return nil
+			}
+			return errors.Wrap(err, "unable to get settings")
+		}
+		changed, err := update(record)
+		if err != nil || !changed {
+			return err
+		}
+		s.writes.wait()
+		err = s.b.SetIfRevision(s.settingsKey(), record, revision)
+		if err == nil {
+			return nil
+		}
+		if !s.b.IsConflictError(err) {
+			return errors.Wrap(err, "unable to set settings")
+		}
+		logrus.Debugf("settings modified concurrently, retrying")
+	}
+}
+
+// PruneSettingsHistory removes the revisions for which expired is true,
+// except those kept and the latest one, and returns them
+func (s *KVStore) PruneSettingsHistory(expired func(revision *types.SettingsRevision) bool) ([]*types.SettingsRevision, error) {
+	removed := []*types.SettingsRevision{}
+	if err := s.updateSettingsRecord(func(record *settingsRecord) (bool, error) {
+		removed = record.remove(expired)
+		return len(removed) != 0, nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "unable to prune settings history")
+	}
+	return removed, nil
+}
+
+// KeepSettingsRevision sets if the revision is exempt from the limit and the
+// record GC
+func (s *KVStore) KeepSettingsRevision(revision int64, keep bool) error {
+	found := false
+	if err := s.updateSettingsRecord(func(record *settingsRecord) (bool, error) {
+		for _, r := range record.History {
+			if r.Revision == revision {
+				found = true
+				changed := r.Keep != keep
+				r.Keep = keep
+				return changed, nil
+			}
+		}
+		return false, nil
+	}); err != nil {
 		return err
 	}
+	if !found {
+		return errors.Errorf("cannot find settings revision %v in the history", revision)
+	}
 	return nil
 }
 
+// SettingsHistoryCounts counts the revisions of the settings, the revisions
+// are numbered from 1
+func (s *KVStore) SettingsHistoryCounts() (*types.RecordCounts, error) {
+	record := &settingsRecord{}
+	if err := s.b.Get(s.settingsKey(), record); err != nil {
+		if s.b.IsNotFoundError(err) {
+			return &types.RecordCounts{}, nil
+		}
+		return nil, errors.Wrap(err, "unable to get settings history")
+	}
+	return &types.RecordCounts{Total: record.Revision, Retained: int64(len(record.History))}, nil
+}
+
 func (s *KVStore) GetSettings() (*types.SettingsInfo, error) {
 	settings := &types.SettingsInfo{}
 	if err := s.b.Get(s.settingsKey(), &settings); err != nil {
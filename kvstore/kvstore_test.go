@@ -1,10 +1,14 @@
 package kvstore
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/rancher/longhorn-manager/types"
 	"github.com/rancher/longhorn-manager/util"
 
@@ -62,7 +66,7 @@ func (s *TestSuite) SetUpTest(c *C) {
 	s.engineImage = os.Getenv(EnvEngineImage)
 	c.Assert(s.engineImage, Not(Equals), "")
 
-	etcdBackend, err := NewETCDBackend([]string{"http://" + etcdIP + ":2379"})
+	etcdBackend, err := NewETCDBackend([]string{"http://" + etcdIP + ":2379"}, ETCDOptions{})
 	c.Assert(err, IsNil)
 
 	etcd, err := NewKVStore("/longhorn", etcdBackend)
@@ -151,6 +155,134 @@ func (s *TestSuite) testHost(c *C, st *KVStore) {
 	host, err = st.GetHost("random")
 	c.Assert(err, IsNil)
 	c.Assert(host, IsNil)
+
+	err = st.DeleteHost(host3.UUID)
+	c.Assert(err, IsNil)
+
+	host, err = st.GetHost(host3.UUID)
+	c.Assert(err, IsNil)
+	c.Assert(host, IsNil)
+
+	hosts, err = st.ListHosts()
+	c.Assert(err, IsNil)
+	c.Assert(hosts, HasLen, 2)
+
+	hosts, err = st.GetHosts([]string{host1.UUID, host3.UUID, host2.UUID})
+	c.Assert(err, IsNil)
+	c.Assert(hosts, DeepEquals, map[string]*types.HostInfo{
+		host1.UUID: host1,
+		host2.UUID: host2,
+		host3.UUID: nil,
+	})
+}
+
+func (s *TestSuite) TestUpdateHost(c *C) {
+	s.testUpdateHost(c, s.memory)
+
+	if s.etcd != nil {
+		s.testUpdateHost(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testUpdateHost(c *C, st *KVStore) {
+	id := util.UUID()
+	err := st.UpdateHost(id, func(host *types.HostInfo) (*types.HostInfo, error) {
+		c.Assert(host, IsNil)
+		return &types.HostInfo{UUID: id, Name: "host-1", Address: "127.0.1.1"}, nil
+	})
+	c.Assert(err, IsNil)
+
+	// the change written concurrently is kept
+	calls := 0
+	err = st.UpdateHost(id, func(host *types.HostInfo) (*types.HostInfo, error) {
+		calls++
+		if calls == 1 {
+			concurrent := *host
+			concurrent.Unschedulable = true
+			c.Assert(st.SetHost(&concurrent), IsNil)
+		}
+		host.Heartbeat = "2017-05-01T00:00:00Z"
+		return host, nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 2)
+	host, err := st.GetHost(id)
+	c.Assert(err, IsNil)
+	c.Assert(host.Unschedulable, Equals, true)
+	c.Assert(host.Heartbeat, Equals, "2017-05-01T00:00:00Z")
+
+	// nothing written
+	err = st.UpdateHost(id, func(host *types.HostInfo) (*types.HostInfo, error) {
+		host.Heartbeat = ""
+		return nil, nil
+	})
+	c.Assert(err, IsNil)
+	host, err = st.GetHost(id)
+	c.Assert(err, IsNil)
+	c.Assert(host.Heartbeat, Equals, "2017-05-01T00:00:00Z")
+
+	c.Assert(st.DeleteHost(id), IsNil)
+}
+
+func (s *TestSuite) TestRevision(c *C) {
+	s.testRevision(c, s.memory)
+
+	if s.etcd != nil {
+		s.testRevision(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testRevision(c *C, st *KVStore) {
+	host1 := &types.HostInfo{
+		UUID:    util.UUID(),
+		Name:    "host-1",
+		Address: "127.0.1.1",
+	}
+	host2 := &types.HostInfo{
+		UUID:    util.UUID(),
+		Name:    "host-2",
+		Address: "127.0.1.2",
+	}
+
+	err := st.SetHost(host1)
+	c.Assert(err, IsNil)
+
+	listRev1, err := st.Revision(keyHosts)
+	c.Assert(err, IsNil)
+	hostRev1, err := st.Revision(keyHosts + "/" + host1.UUID)
+	c.Assert(err, IsNil)
+
+	rev, err := st.Revision(keyHosts)
+	c.Assert(err, IsNil)
+	c.Assert(rev, Equals, listRev1)
+
+	err = st.SetHost(host2)
+	c.Assert(err, IsNil)
+
+	listRev2, err := st.Revision(keyHosts)
+	c.Assert(err, IsNil)
+	c.Assert(listRev2, Not(Equals), listRev1)
+	rev, err = st.Revision(keyHosts + "/" + host1.UUID)
+	c.Assert(err, IsNil)
+	c.Assert(rev, Equals, hostRev1)
+
+	host1.Address = "127.0.2.2"
+	err = st.SetHost(host1)
+	c.Assert(err, IsNil)
+
+	listRev3, err := st.Revision(keyHosts)
+	c.Assert(err, IsNil)
+	c.Assert(listRev3, Not(Equals), listRev2)
+	rev, err = st.Revision(keyHosts + "/" + host1.UUID)
+	c.Assert(err, IsNil)
+	c.Assert(rev, Not(Equals), hostRev1)
+
+	err = st.DeleteHost(host2.UUID)
+	c.Assert(err, IsNil)
+
+	rev, err = st.Revision(keyHosts)
+	c.Assert(err, IsNil)
+	c.Assert(rev, Not(Equals), listRev3)
 }
 
 func (s *TestSuite) TestSettings(c *C) {
@@ -180,6 +312,82 @@ func (s *TestSuite) testSettings(c *C, st *KVStore) {
 	c.Assert(newSettings.EngineImage, Equals, settings.EngineImage)
 }
 
+func (s *TestSuite) TestSettingsHistory(c *C) {
+	s.testSettingsHistory(c, s.memory)
+
+	if s.etcd != nil {
+		s.testSettingsHistory(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testSettingsHistory(c *C, st *KVStore) {
+	defer func(limit int) { SettingsHistoryLimit = limit }(SettingsHistoryLimit)
+	SettingsHistoryLimit = 3
+
+	history, err := st.GetSettingsHistory()
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 0)
+
+	defaults := &types.SettingsInfo{EngineImage: "rancher/longhorn"}
+	err = st.UpdateSettings("admin", defaults, func(si *types.SettingsInfo) error {
+		si.BackupTarget = "nfs://1.2.3.4:/test"
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	history, err = st.GetSettingsHistory()
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 1)
+	c.Assert(history[0].Revision, Equals, int64(1))
+	c.Assert(history[0].Author, Equals, "admin")
+	c.Assert(history[0].Previous, DeepEquals, *defaults)
+	c.Assert(history[0].Settings.BackupTarget, Equals, "nfs://1.2.3.4:/test")
+	c.Assert(history[0].Settings.EngineImage, Equals, "rancher/longhorn")
+
+	// a concurrent write makes the first attempt fail, the update is retried
+	// on top of it
+	attempts := 0
+	err = st.UpdateSettings("admin", defaults, func(si *types.SettingsInfo) error {
+		attempts++
+		if attempts == 1 {
+			concurrent := *si
+			concurrent.SnapshotMaxCount = 10
+			c.Assert(st.SetSettings(&concurrent), IsNil)
+		}
+		si.EngineImage = "rancher/longhorn:new"
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(attempts, Equals, 2)
+
+	settings, err := st.GetSettings()
+	c.Assert(err, IsNil)
+	c.Assert(settings.SnapshotMaxCount, Equals, 10)
+	c.Assert(settings.EngineImage, Equals, "rancher/longhorn:new")
+
+	history, err = st.GetSettingsHistory()
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 3)
+	c.Assert(history[2].Revision, Equals, int64(3))
+	c.Assert(history[2].Previous.EngineImage, Equals, "rancher/longhorn")
+	c.Assert(history[2].Previous.SnapshotMaxCount, Equals, 10)
+
+	for i := 0; i < 2; i++ {
+		c.Assert(st.SetSettings(settings), IsNil)
+	}
+	history, err = st.GetSettingsHistory()
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 3)
+	c.Assert(history[0].Revision, Equals, int64(3))
+
+	c.Assert(st.UpdateSettings("admin", defaults, func(si *types.SettingsInfo) error {
+		return errors.Errorf("invalid")
+	}), NotNil)
+	history, err = st.GetSettingsHistory()
+	c.Assert(err, IsNil)
+	c.Assert(history[2].Revision, Equals, int64(5))
+}
+
 func generateTestVolume(name string) *types.VolumeInfo {
 	return &types.VolumeInfo{
 		Name:                name,
@@ -282,6 +490,14 @@ func (s *TestSuite) testVolume(c *C, st *KVStore) {
 	c.Assert(err, IsNil)
 	c.Assert(len(volumes), Equals, 2)
 
+	names := []string{}
+	err = st.ForEachVolume(volume1.Name, func(v *types.VolumeInfo) error {
+		names = append(names, v.Name)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{volume2.Name})
+
 	volume2.Controller = nil
 	err = st.SetVolume(volume2)
 	c.Assert(err, IsNil)
@@ -364,3 +580,799 @@ func (s *TestSuite) testVolume(c *C, st *KVStore) {
 	c.Assert(err, IsNil)
 	c.Assert(len(volumes), Equals, 0)
 }
+
+func (s *TestSuite) TestVolumeManyReplicas(c *C) {
+	s.testVolumeManyReplicas(c, s.memory)
+
+	if s.etcd != nil {
+		s.testVolumeManyReplicas(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testVolumeManyReplicas(c *C, st *KVStore) {
+	volume := generateTestVolume("volume-many-replicas")
+	volume.Controller = generateTestController(volume.Name)
+	volume.Replicas = map[string]*types.ReplicaInfo{}
+	for i := 0; i < 500; i++ {
+		replica := generateTestReplica(volume.Name, fmt.Sprintf("replica%03d", i))
+		volume.Replicas[replica.Name] = replica
+	}
+	c.Assert(st.SetVolume(volume), IsNil)
+	s.verifyVolume(c, st, volume)
+
+	// each replica is under its own key, the base stays small
+	keys, err := st.b.Keys(st.NewVolumeKeyFromName(volume.Name).Replicas())
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 500)
+	base := &types.VolumeInfo{}
+	c.Assert(st.b.Get(st.NewVolumeKeyFromName(volume.Name).Base(), base), IsNil)
+	c.Assert(base.Controller, IsNil)
+	c.Assert(base.Replicas, IsNil)
+
+	// only the replicas removed are deleted
+	for i := 0; i < 250; i++ {
+		delete(volume.Replicas, generateTestReplica(volume.Name, fmt.Sprintf("replica%03d", i)).Name)
+	}
+	c.Assert(st.SetVolume(volume), IsNil)
+	s.verifyVolume(c, st, volume)
+
+	name := generateTestReplica(volume.Name, "replica499").Name
+	err = st.UpdateVolumeReplica(volume.Name, name, func(r *types.ReplicaInfo) error {
+		r.BadTimestamp = "2017-01-01T00:00:00Z"
+		return nil
+	})
+	c.Assert(err, IsNil)
+	replica, err := st.GetVolumeReplica(volume.Name, name)
+	c.Assert(err, IsNil)
+	c.Assert(replica.BadTimestamp, Equals, "2017-01-01T00:00:00Z")
+	c.Assert(st.UpdateVolumeReplica(volume.Name, "nonexistent", func(r *types.ReplicaInfo) error { return nil }), NotNil)
+
+	c.Assert(st.DeleteVolume(volume.Name), IsNil)
+}
+
+func (s *TestSuite) TestUpdateVolumeBase(c *C) {
+	s.testUpdateVolumeBase(c, s.memory)
+
+	if s.etcd != nil {
+		s.testUpdateVolumeBase(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testUpdateVolumeBase(c *C, st *KVStore) {
+	volume := generateTestVolume("volume-update-base")
+	volume.Controller = generateTestController(volume.Name)
+	replica := generateTestReplica(volume.Name, "replica1")
+	volume.Replicas = map[string]*types.ReplicaInfo{replica.Name: replica}
+	c.Assert(st.SetVolume(volume), IsNil)
+
+	// the base written concurrently is updated again, its change kept
+	calls := 0
+	updated, err := st.UpdateVolumeBase(volume.Name, func(v *types.VolumeInfo) error {
+		calls++
+		if calls == 1 {
+			concurrent := *volume
+			concurrent.Generation = 5
+			c.Assert(st.SetVolumeBase(&concurrent), IsNil)
+		}
+		c.Assert(v.Controller, IsNil)
+		c.Assert(v.Replicas, IsNil)
+		v.Generation++
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 2)
+	c.Assert(updated.Generation, Equals, int64(6))
+	volume.Generation = 6
+	s.verifyVolume(c, st, volume)
+
+	_, err = st.UpdateVolumeBase(volume.Name, func(v *types.VolumeInfo) error {
+		return fmt.Errorf("refused")
+	})
+	c.Assert(err, ErrorMatches, "refused")
+	_, err = st.UpdateVolumeBase("nonexistent", func(v *types.VolumeInfo) error { return nil })
+	c.Assert(err, ErrorMatches, "cannot find volume nonexistent")
+
+	c.Assert(st.DeleteVolume(volume.Name), IsNil)
+}
+
+func (s *TestSuite) TestSplitVolumeRecords(c *C) {
+	s.testSplitVolumeRecords(c, s.memory)
+
+	if s.etcd != nil {
+		s.testSplitVolumeRecords(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testSplitVolumeRecords(c *C, st *KVStore) {
+	// a volume written with its instances in the base
+	volume := generateTestVolume("volume-monolithic")
+	volume.Controller = generateTestController(volume.Name)
+	replica1 := generateTestReplica(volume.Name, "replica1")
+	replica2 := generateTestReplica(volume.Name, "replica2")
+	volume.Replicas = map[string]*types.ReplicaInfo{
+		replica1.Name: replica1,
+		replica2.Name: replica2,
+	}
+	volumeKey := st.NewVolumeKeyFromName(volume.Name)
+	c.Assert(st.b.Set(volumeKey.Base(), volume), IsNil)
+	// written since under its own key, which wins
+	updated := generateTestReplica(volume.Name, "replica2")
+	updated.Mode = types.ReplicaModeWO
+	c.Assert(st.b.Set(volumeKey.Replica(updated.Name), updated), IsNil)
+
+	split := generateTestVolume("volume-split")
+	c.Assert(st.SetVolume(split), IsNil)
+
+	n, err := st.SplitVolumeRecords()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	base := &types.VolumeInfo{}
+	c.Assert(st.b.Get(volumeKey.Base(), base), IsNil)
+	c.Assert(base.Controller, IsNil)
+	c.Assert(base.Replicas, IsNil)
+	volume.Replicas[updated.Name] = updated
+	s.verifyVolume(c, st, volume)
+	s.verifyVolume(c, st, split)
+
+	n, err = st.SplitVolumeRecords()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	c.Assert(st.DeleteVolume(volume.Name), IsNil)
+	c.Assert(st.DeleteVolume(split.Name), IsNil)
+}
+
+func (s *TestSuite) TestVolumeEvents(c *C) {
+	s.testVolumeEvents(c, s.memory)
+
+	if s.etcd != nil {
+		s.testVolumeEvents(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testVolumeEvents(c *C, st *KVStore) {
+	defer func(limit int) { VolumeEventBatchesLimit = limit }(VolumeEventBatchesLimit)
+	VolumeEventBatchesLimit = 3
+
+	events, err := st.ListVolumeEvents("vol")
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 0)
+
+	newEvent := func(volume, reason string) *types.VolumeEvent {
+		return &types.VolumeEvent{Volume: volume, Severity: types.EventSeverityInfo, Reason: reason}
+	}
+	err = st.AppendVolumeEvents("vol", []*types.VolumeEvent{newEvent("vol", "1"), newEvent("vol", "2")})
+	c.Assert(err, IsNil)
+	err = st.AppendVolumeEvents("vol2", []*types.VolumeEvent{newEvent("vol2", "1")})
+	c.Assert(err, IsNil)
+	err = st.AppendVolumeEvents("vol", []*types.VolumeEvent{newEvent("vol", "3")})
+	c.Assert(err, IsNil)
+
+	events, err = st.ListVolumeEvents("vol")
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 3)
+	for i, e := range events {
+		c.Assert(e.Volume, Equals, "vol")
+		c.Assert(e.Reason, Equals, strconv.Itoa(i+1))
+	}
+
+	// only the latest batches are kept
+	for i := 4; i <= 6; i++ {
+		err = st.AppendVolumeEvents("vol", []*types.VolumeEvent{newEvent("vol", strconv.Itoa(i))})
+		c.Assert(err, IsNil)
+	}
+	events, err = st.ListVolumeEvents("vol")
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 3)
+	c.Assert(events[0].Reason, Equals, "4")
+	c.Assert(events[2].Reason, Equals, "6")
+
+	err = st.DeleteVolumeEvents("vol")
+	c.Assert(err, IsNil)
+	events, err = st.ListVolumeEvents("vol")
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 0)
+
+	events, err = st.ListVolumeEvents("vol2")
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 1)
+	err = st.DeleteVolumeEvents("vol2")
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TestVolumeSnapshot(c *C) {
+	s.testVolumeSnapshot(c, s.memory)
+
+	if s.etcd != nil {
+		s.testVolumeSnapshot(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testVolumeSnapshot(c *C, st *KVStore) {
+	volume := generateTestVolume("vol-snapshot")
+	c.Assert(st.SetVolume(volume), IsNil)
+
+	snapshot, err := st.GetVolumeSnapshot(volume.Name, "snap-1")
+	c.Assert(err, IsNil)
+	c.Assert(snapshot, IsNil)
+
+	created := &types.SnapshotInfo{Name: "snap-1", Created: "2017-03-01T10:00:00Z", Size: "4096"}
+	c.Assert(st.CreateVolumeSnapshot(volume.Name, created), IsNil)
+	snapshot, err = st.GetVolumeSnapshot(volume.Name, "snap-1")
+	c.Assert(err, IsNil)
+	c.Assert(snapshot, DeepEquals, created)
+
+	err = st.CreateVolumeSnapshot(volume.Name, &types.SnapshotInfo{Name: "snap-1", Size: "0"})
+	c.Assert(err, FitsTypeOf, &types.ErrSnapshotExists{})
+	snapshot, err = st.GetVolumeSnapshot(volume.Name, "snap-1")
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.Size, Equals, "4096")
+
+	// not read as a part of the volume
+	stored, err := st.GetVolume(volume.Name)
+	c.Assert(err, IsNil)
+	c.Assert(stored.Name, Equals, volume.Name)
+
+	c.Assert(st.CreateVolumeSnapshot(volume.Name, &types.SnapshotInfo{Name: "snap-2", Parent: "snap-1"}), IsNil)
+	snapshots, err := st.ListVolumeSnapshots(volume.Name)
+	c.Assert(err, IsNil)
+	c.Assert(snapshots, HasLen, 2)
+	c.Assert(snapshots["snap-2"].Parent, Equals, "snap-1")
+	c.Assert(st.DeleteVolumeSnapshot(volume.Name, "snap-1"), IsNil)
+	snapshots, err = st.ListVolumeSnapshots(volume.Name)
+	c.Assert(err, IsNil)
+	c.Assert(snapshots, HasLen, 1)
+	c.Assert(snapshots["snap-1"], IsNil)
+	snapshots, err = st.ListVolumeSnapshots("vol-none")
+	c.Assert(err, IsNil)
+	c.Assert(snapshots, HasLen, 0)
+
+	c.Assert(st.DeleteVolume(volume.Name), IsNil)
+}
+
+func (s *TestSuite) TestVolumeHistory(c *C) {
+	s.testVolumeHistory(c, s.memory)
+
+	if s.etcd != nil {
+		s.testVolumeHistory(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testVolumeHistory(c *C, st *KVStore) {
+	defer func(limit int) { VolumeHistoryLimit = limit }(VolumeHistoryLimit)
+	VolumeHistoryLimit = 3
+
+	history, err := st.GetVolumeHistory("vol", 0)
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 0)
+
+	states := []types.VolumeState{
+		types.VolumeStateCreating,
+		types.VolumeStateDetached,
+		types.VolumeStateHealthy,
+	}
+	for _, state := range states {
+		recorded, err := st.RecordVolumeState("vol", state, "host-1", util.Now())
+		c.Assert(err, IsNil)
+		c.Assert(recorded, Equals, true)
+	}
+	// observed by another manager too
+	recorded, err := st.RecordVolumeState("vol", types.VolumeStateHealthy, "host-2", util.Now())
+	c.Assert(err, IsNil)
+	c.Assert(recorded, Equals, false)
+
+	history, err = st.GetVolumeHistory("vol", 0)
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 3)
+	c.Assert(history[0].From, Equals, types.VolumeStateNone)
+	for i, transition := range history {
+		c.Assert(transition.To, Equals, states[i])
+		c.Assert(transition.HostID, Equals, "host-1")
+		if i > 0 {
+			c.Assert(transition.From, Equals, history[i-1].To)
+		}
+	}
+
+	// only the latest transitions are kept
+	recorded, err = st.RecordVolumeState("vol", types.VolumeStateDegraded, "host-2", util.Now())
+	c.Assert(err, IsNil)
+	c.Assert(recorded, Equals, true)
+	history, err = st.GetVolumeHistory("vol", 0)
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 3)
+	c.Assert(history[0].To, Equals, types.VolumeStateDetached)
+	c.Assert(history[2].From, Equals, types.VolumeStateHealthy)
+	c.Assert(history[2].To, Equals, types.VolumeStateDegraded)
+	c.Assert(history[2].HostID, Equals, "host-2")
+
+	history, err = st.GetVolumeHistory("vol", 1)
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 1)
+	c.Assert(history[0].To, Equals, types.VolumeStateDegraded)
+
+	err = st.DeleteVolumeHistory("vol")
+	c.Assert(err, IsNil)
+	history, err = st.GetVolumeHistory("vol", 0)
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 0)
+}
+
+func (s *TestSuite) TestRecordRetention(c *C) {
+	s.testRecordRetention(c, s.memory)
+
+	if s.etcd != nil {
+		s.testRecordRetention(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testRecordRetention(c *C, st *KVStore) {
+	defer func(limit int) { VolumeEventBatchesLimit = limit }(VolumeEventBatchesLimit)
+	VolumeEventBatchesLimit = 2
+	defer func(limit int) { VolumeHistoryLimit = limit }(VolumeHistoryLimit)
+	VolumeHistoryLimit = 3
+
+	// a kept batch isn't trimmed by the limit, nor pruned
+	newEvent := func(reason string) *types.VolumeEvent {
+		return &types.VolumeEvent{Volume: "vol", Severity: types.EventSeverityInfo, Reason: reason}
+	}
+	c.Assert(st.AppendVolumeEvents("vol", []*types.VolumeEvent{newEvent("1"), newEvent("2")}), IsNil)
+	events, err := st.ListVolumeEvents("vol")
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 2)
+	c.Assert(events[0].Batch, Not(Equals), "")
+	c.Assert(st.KeepVolumeEvents("vol", events[0].Batch, true), IsNil)
+	c.Assert(st.KeepVolumeEvents("vol", "none", true), ErrorMatches, "cannot find event batch .*")
+	for i := 3; i <= 5; i++ {
+		c.Assert(st.AppendVolumeEvents("vol", []*types.VolumeEvent{newEvent(strconv.Itoa(i))}), IsNil)
+	}
+	events, err = st.ListVolumeEvents("vol")
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 3)
+	c.Assert(events[0].Reason, Equals, "1")
+	c.Assert(events[0].Keep, Equals, true)
+	c.Assert(events[2].Reason, Equals, "5")
+	counts, err := st.VolumeEventCounts("vol")
+	c.Assert(err, IsNil)
+	c.Assert(counts, DeepEquals, &types.RecordCounts{Total: 5, Retained: 3})
+
+	removed, err := st.PruneVolumeEvents("vol", func(events []*types.VolumeEvent) bool { return true })
+	c.Assert(err, IsNil)
+	c.Assert(removed, HasLen, 1)
+	c.Assert(removed[0][0].Reason, Equals, "5")
+	events, err = st.ListVolumeEvents("vol")
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 2)
+	counts, err = st.VolumeEventCounts("vol")
+	c.Assert(err, IsNil)
+	c.Assert(counts, DeepEquals, &types.RecordCounts{Total: 5, Retained: 2})
+
+	c.Assert(st.KeepVolumeEvents("vol", events[0].Batch, false), IsNil)
+	removed, err = st.PruneVolumeEvents("vol", func(events []*types.VolumeEvent) bool { return true })
+	c.Assert(err, IsNil)
+	c.Assert(removed, HasLen, 1)
+	c.Assert(removed[0], HasLen, 2)
+	c.Assert(st.DeleteVolumeEvents("vol"), IsNil)
+	counts, err = st.VolumeEventCounts("vol")
+	c.Assert(err, IsNil)
+	c.Assert(counts, DeepEquals, &types.RecordCounts{})
+
+	// a kept transition isn't trimmed by the limit, the latest one is never
+	// pruned
+	states := []types.VolumeState{
+		types.VolumeStateCreating,
+		types.VolumeStateDetached,
+		types.VolumeStateHealthy,
+		types.VolumeStateDegraded,
+		types.VolumeStateHealthy,
+	}
+	for i, state := range states {
+		_, err := st.RecordVolumeState("vol", state, "host-1", util.Now())
+		c.Assert(err, IsNil)
+		if i == 0 {
+			c.Assert(st.KeepVolumeState("vol", 1, true), IsNil)
+		}
+	}
+	c.Assert(st.KeepVolumeState("vol", 9, true), ErrorMatches, "cannot find state transition .*")
+	history, err := st.GetVolumeHistory("vol", 0)
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 3)
+	c.Assert(history[0].Seq, Equals, int64(1))
+	c.Assert(history[0].Keep, Equals, true)
+	c.Assert(history[1].Seq, Equals, int64(4))
+	c.Assert(history[2].Seq, Equals, int64(5))
+	hcounts, err := st.VolumeHistoryCounts("vol")
+	c.Assert(err, IsNil)
+	c.Assert(hcounts, DeepEquals, &types.RecordCounts{Total: 5, Retained: 3})
+
+	pruned, err := st.PruneVolumeHistory("vol", func(t *types.StateTransition) bool { return true })
+	c.Assert(err, IsNil)
+	c.Assert(pruned, HasLen, 1)
+	c.Assert(pruned[0].Seq, Equals, int64(4))
+	history, err = st.GetVolumeHistory("vol", 0)
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 2)
+	c.Assert(history[1].To, Equals, types.VolumeStateHealthy)
+	_, err = st.RecordVolumeState("vol", types.VolumeStateDetached, "host-1", util.Now())
+	c.Assert(err, IsNil)
+	history, err = st.GetVolumeHistory("vol", 1)
+	c.Assert(err, IsNil)
+	c.Assert(history[0].Seq, Equals, int64(6))
+
+	// the settings revisions too
+	for i := 1; i <= 3; i++ {
+		err := st.UpdateSettings("admin", &types.SettingsInfo{}, func(si *types.SettingsInfo) error {
+			si.BackupTarget = fmt.Sprintf("nfs://1.2.3.4:/test%d", i)
+			return nil
+		})
+		c.Assert(err, IsNil)
+	}
+	c.Assert(st.KeepSettingsRevision(2, true), IsNil)
+	c.Assert(st.KeepSettingsRevision(9, true), ErrorMatches, "cannot find settings revision .*")
+	revisions, err := st.PruneSettingsHistory(func(r *types.SettingsRevision) bool { return true })
+	c.Assert(err, IsNil)
+	c.Assert(revisions, HasLen, 1)
+	c.Assert(revisions[0].Revision, Equals, int64(1))
+	settingsHistory, err := st.GetSettingsHistory()
+	c.Assert(err, IsNil)
+	c.Assert(settingsHistory, HasLen, 2)
+	c.Assert(settingsHistory[0].Keep, Equals, true)
+	scounts, err := st.SettingsHistoryCounts()
+	c.Assert(err, IsNil)
+	c.Assert(scounts, DeepEquals, &types.RecordCounts{Total: 3, Retained: 2})
+}
+
+func (s *TestSuite) TestLocks(c *C) {
+	s.testLocks(c, s.memory)
+
+	if s.etcd != nil {
+		s.testLocks(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testLocks(c *C, st *KVStore) {
+	locks, err := st.ListLocks()
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 0)
+
+	err = st.AcquireLock(&types.LockInfo{Name: "drain-host-1", HolderID: "host-1", OperationID: "op-1"}, time.Minute)
+	c.Assert(err, IsNil)
+	err = st.AcquireLock(&types.LockInfo{Name: "drain-host-1", HolderID: "host-2", OperationID: "op-2"}, time.Minute)
+	held, ok := err.(*types.ErrLockHeld)
+	c.Assert(ok, Equals, true)
+	c.Assert(held.Lock.OperationID, Equals, "op-1")
+	c.Assert(st.RefreshLock("drain-host-1", "op-1", time.Minute), IsNil)
+
+	// an expired lock is free, and removed by the listing
+	err = st.AcquireLock(&types.LockInfo{Name: "expired", HolderID: "host-1", OperationID: "op-3"}, -time.Second)
+	c.Assert(err, IsNil)
+	locks, err = st.ListLocks()
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 1)
+	c.Assert(locks[0].Name, Equals, "drain-host-1")
+	c.Assert(locks[0].HolderID, Equals, "host-1")
+	err = st.RefreshLock("expired", "op-3", time.Minute)
+	_, ok = err.(*types.ErrLockLost)
+	c.Assert(ok, Equals, true)
+
+	// a revoked lock is free, and the holder learns it on refresh
+	_, err = st.RevokeLock("drain-host-1", "op-2", "admin")
+	c.Assert(err, NotNil)
+	lock, err := st.RevokeLock("drain-host-1", "op-1", "admin")
+	c.Assert(err, IsNil)
+	c.Assert(lock.RevokedBy, Equals, "admin")
+	err = st.RefreshLock("drain-host-1", "op-1", time.Minute)
+	lost, ok := err.(*types.ErrLockLost)
+	c.Assert(ok, Equals, true)
+	c.Assert(lost.RevokedBy, Equals, "admin")
+	err = st.AcquireLock(&types.LockInfo{Name: "drain-host-1", HolderID: "host-2", OperationID: "op-2"}, time.Minute)
+	c.Assert(err, IsNil)
+
+	// releasing a lock taken over leaves it alone
+	c.Assert(st.ReleaseLock("drain-host-1", "op-1"), IsNil)
+	locks, err = st.ListLocks()
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 1)
+	c.Assert(locks[0].OperationID, Equals, "op-2")
+	c.Assert(st.ReleaseLock("drain-host-1", "op-2"), IsNil)
+	locks, err = st.ListLocks()
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 0)
+
+	// a lock is only removed at the revision read
+	err = st.AcquireLock(&types.LockInfo{Name: "drain-host-2", HolderID: "host-1", OperationID: "op-4"}, -time.Second)
+	c.Assert(err, IsNil)
+	_, revision, err := st.getLock("drain-host-2")
+	c.Assert(err, IsNil)
+	err = st.AcquireLock(&types.LockInfo{Name: "drain-host-2", HolderID: "host-2", OperationID: "op-5"}, time.Minute)
+	c.Assert(err, IsNil)
+	err = st.b.DeleteIfRevision(st.lockKey("drain-host-2"), revision)
+	c.Assert(st.b.IsConflictError(err), Equals, true)
+	locks, err = st.ListLocks()
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 1)
+	c.Assert(locks[0].OperationID, Equals, "op-5")
+	c.Assert(st.ReleaseLock("drain-host-2", "op-5"), IsNil)
+	err = st.b.DeleteIfRevision(st.lockKey("drain-host-2"), revision)
+	c.Assert(st.b.IsNotFoundError(err), Equals, true)
+}
+
+func (s *TestSuite) TestClusterCA(c *C) {
+	s.testClusterCA(c, s.memory)
+
+	if s.etcd != nil {
+		s.testClusterCA(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testClusterCA(c *C, st *KVStore) {
+	ca, err := st.GetClusterCA()
+	c.Assert(err, IsNil)
+	c.Assert(ca, IsNil)
+
+	ca = &types.ClusterCA{Cert: "cert-1", SealedKey: "key-1", Created: "2017-01-01T00:00:00Z"}
+	c.Assert(st.SetClusterCA(ca), IsNil)
+	got, err := st.GetClusterCA()
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, ca)
+
+	ca = &types.ClusterCA{Cert: "cert-2", SealedKey: "key-2", PreviousCert: "cert-1", PreviousUntil: "2017-01-03T00:00:00Z"}
+	c.Assert(st.SetClusterCA(ca), IsNil)
+	got, err = st.GetClusterCA()
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, ca)
+}
+
+func (s *TestSuite) TestAPITokens(c *C) {
+	s.testAPITokens(c, s.memory)
+
+	if s.etcd != nil {
+		s.testAPITokens(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testAPITokens(c *C, st *KVStore) {
+	tokens, err := st.ListAPITokens()
+	c.Assert(err, IsNil)
+	c.Assert(tokens, HasLen, 0)
+
+	ro := &types.APIToken{ID: "id-1", Name: "monitoring", Role: types.APIRoleReadonly, Hash: "hash-1", Source: types.APITokenSourceStore}
+	op := &types.APIToken{ID: "id-2", Name: "provisioner", Role: types.APIRoleOperator, Hash: "hash-2", Source: types.APITokenSourceStore}
+	c.Assert(st.SetAPIToken(ro), IsNil)
+	c.Assert(st.SetAPIToken(op), IsNil)
+	tokens, err = st.ListAPITokens()
+	c.Assert(err, IsNil)
+	c.Assert(tokens, DeepEquals, map[string]*types.APIToken{"id-1": ro, "id-2": op})
+
+	c.Assert(st.DeleteAPIToken("id-1"), IsNil)
+	tokens, err = st.ListAPITokens()
+	c.Assert(err, IsNil)
+	c.Assert(tokens, DeepEquals, map[string]*types.APIToken{"id-2": op})
+	c.Assert(st.DeleteAPIToken("id-2"), IsNil)
+}
+
+func (s *TestSuite) TestBootstrapReport(c *C) {
+	s.testBootstrapReport(c, s.memory)
+
+	if s.etcd != nil {
+		s.testBootstrapReport(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testBootstrapReport(c *C, st *KVStore) {
+	report, err := st.GetBootstrapReport()
+	c.Assert(err, IsNil)
+	c.Assert(report, IsNil)
+
+	report = &types.BootstrapReport{
+		File:     "/etc/longhorn/bootstrap.yaml",
+		Digest:   "digest-1",
+		HostID:   "host-1",
+		Started:  "2017-01-01T00:00:00Z",
+		Finished: "2017-01-01T00:00:01Z",
+		Items: []*types.BootstrapItem{
+			{Kind: "setting", Name: "backupTarget", Line: 2, Result: types.BootstrapResultUpdated},
+			{Kind: "volume", Name: "db", Line: 4, Result: types.BootstrapResultFailed, Reason: "no space"},
+		},
+	}
+	c.Assert(st.SetBootstrapReport(report), IsNil)
+	got, err := st.GetBootstrapReport()
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, report)
+}
+
+func (s *TestSuite) TestDrainProgress(c *C) {
+	s.testDrainProgress(c, s.memory)
+
+	if s.etcd != nil {
+		s.testDrainProgress(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testDrainProgress(c *C, st *KVStore) {
+	progress, err := st.GetDrainProgress("host-1")
+	c.Assert(err, IsNil)
+	c.Assert(progress, IsNil)
+
+	progress = &types.DrainProgress{
+		JobID:     "op-1",
+		HostID:    "host-1",
+		DrainerID: "host-2",
+		Total:     2,
+		Migrated:  1,
+		Pending:   []string{"vol-1-replica-a"},
+		Running:   true,
+		Pinned:    []string{"vol-2"},
+	}
+	c.Assert(st.SetDrainProgress(progress), IsNil)
+	got, err := st.GetDrainProgress("host-1")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, progress)
+
+	got, err = st.GetDrainProgress("host-2")
+	c.Assert(err, IsNil)
+	c.Assert(got, IsNil)
+}
+
+func (s *TestSuite) TestVolumeGroups(c *C) {
+	s.testVolumeGroups(c, s.memory)
+
+	if s.etcd != nil {
+		s.testVolumeGroups(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testVolumeGroups(c *C, st *KVStore) {
+	groups, err := st.ListVolumeGroups()
+	c.Assert(err, IsNil)
+	c.Assert(groups, HasLen, 0)
+	group, err := st.GetVolumeGroup("app")
+	c.Assert(err, IsNil)
+	c.Assert(group, IsNil)
+
+	app := &types.VolumeGroup{Name: "app", Volumes: []string{"vol-1", "vol-2"}, Created: "2017-01-01T00:00:00Z"}
+	db := &types.VolumeGroup{Name: "db", Selector: map[string]string{"app": "db"}, Created: "2017-01-01T00:00:00Z"}
+	c.Assert(st.SetVolumeGroup(app), IsNil)
+	c.Assert(st.SetVolumeGroup(db), IsNil)
+	group, err = st.GetVolumeGroup("app")
+	c.Assert(err, IsNil)
+	c.Assert(group, DeepEquals, app)
+	groups, err = st.ListVolumeGroups()
+	c.Assert(err, IsNil)
+	c.Assert(groups, DeepEquals, map[string]*types.VolumeGroup{"app": app, "db": db})
+
+	c.Assert(st.DeleteVolumeGroup("app"), IsNil)
+	c.Assert(st.DeleteVolumeGroup("db"), IsNil)
+	groups, err = st.ListVolumeGroups()
+	c.Assert(err, IsNil)
+	c.Assert(groups, HasLen, 0)
+}
+
+func (s *TestSuite) TestVolumeRawRecords(c *C) {
+	s.testVolumeRawRecords(c, s.memory)
+
+	if s.etcd != nil {
+		s.testVolumeRawRecords(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testVolumeRawRecords(c *C, st *KVStore) {
+	volume := generateTestVolume("raw-vol")
+	volume.Controller = generateTestController(volume.Name)
+	c.Assert(st.SetVolume(volume), IsNil)
+	c.Assert(st.SetVolume(generateTestVolume("raw-vol2")), IsNil)
+	c.Assert(st.AppendVolumeEvents(volume.Name, []*types.VolumeEvent{{Reason: "Attached"}}), IsNil)
+	c.Assert(st.AcquireLock(&types.LockInfo{Name: "replace-raw-vol", OperationID: "op-1", VolumeName: volume.Name}, time.Minute), IsNil)
+	c.Assert(st.AcquireLock(&types.LockInfo{Name: "drain-host-1", OperationID: "op-2"}, time.Minute), IsNil)
+
+	records, err := st.ListVolumeRawRecords(volume.Name)
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 4)
+	c.Assert(records[0].Key, Matches, "/longhorn/events/raw-vol/.*")
+	c.Assert(records[1].Key, Equals, "/longhorn/locks/replace-raw-vol")
+	c.Assert(records[2].Key, Equals, "/longhorn/volumes/raw-vol/base")
+	c.Assert(records[3].Key, Equals, "/longhorn/volumes/raw-vol/instances/controller")
+	for _, r := range records {
+		c.Assert(r.ModifiedIndex, Not(Equals), uint64(0))
+	}
+
+	base := *records[2]
+	base.Value = `{"name":"raw-vol","size":2048}`
+	c.Assert(st.SetVolumeRawRecord(volume.Name, &base), IsNil)
+	v, err := st.GetVolumeBase(volume.Name)
+	c.Assert(err, IsNil)
+	c.Assert(v.Size, Equals, int64(2048))
+	c.Assert(v.Controller, IsNil)
+
+	// the index moved on
+	c.Assert(st.SetVolumeRawRecord(volume.Name, &base), NotNil)
+	// only the keys of the volume
+	other := &types.RawRecord{Key: "/longhorn/volumes/raw-vol2/base", Value: "{}", ModifiedIndex: base.ModifiedIndex}
+	c.Assert(st.SetVolumeRawRecord(volume.Name, other), NotNil)
+	other = &types.RawRecord{Key: "/longhorn/locks/drain-host-1", Value: "{}", ModifiedIndex: base.ModifiedIndex}
+	c.Assert(st.SetVolumeRawRecord(volume.Name, other), NotNil)
+	lock := *records[1]
+	lock.Value = "{"
+	c.Assert(st.SetVolumeRawRecord(volume.Name, &lock), NotNil)
+}
+
+func (s *TestSuite) TestWriter(c *C) {
+	s.testWriter(c, s.memory)
+
+	if s.etcd != nil {
+		s.testWriter(c, s.etcd)
+	}
+}
+
+func (s *TestSuite) testWriter(c *C, st *KVStore) {
+	defer st.SetWriter(nil)
+
+	volume := generateTestVolume("writer-vol")
+	volume.Controller = generateTestController(volume.Name)
+	volume.Replicas = map[string]*types.ReplicaInfo{
+		"r1": generateTestReplica(volume.Name, "r1"),
+	}
+	c.Assert(st.SetVolume(volume), IsNil)
+	comp, err := st.GetVolume(volume.Name)
+	c.Assert(err, IsNil)
+	c.Assert(comp.Writer, IsNil)
+
+	writer := &types.WriterInfo{Version: "0.2.0", HostID: "host-1"}
+	st.SetWriter(writer)
+	c.Assert(st.SetVolume(volume), IsNil)
+	c.Assert(st.SetHost(&types.HostInfo{UUID: "host-1"}), IsNil)
+
+	// the records are stamped, not the objects given
+	c.Assert(volume.Writer, IsNil)
+	comp, err = st.GetVolume(volume.Name)
+	c.Assert(err, IsNil)
+	c.Assert(comp.Writer, DeepEquals, writer)
+	c.Assert(comp.Controller.Writer, DeepEquals, writer)
+	c.Assert(comp.Replicas["replica-name-r1-writer-vol"].Writer, DeepEquals, writer)
+	host, err := st.GetHost("host-1")
+	c.Assert(err, IsNil)
+	c.Assert(host.Writer, DeepEquals, writer)
+
+	c.Assert(st.DeleteVolume(volume.Name), IsNil)
+	c.Assert(st.DeleteHost("host-1"), IsNil)
+}
+
+func (s *TestSuite) TestRestoreMetadata(c *C) {
+	memory, err := NewMemoryBackend()
+	c.Assert(err, IsNil)
+	st, err := NewKVStore("/longhorn", memory)
+	c.Assert(err, IsNil)
+
+	volume := generateTestVolume("volume1")
+	volume.Controller = generateTestController(volume.Name)
+	replica := generateTestReplica(volume.Name, "replica1")
+	replica.Running = true
+	volume.Replicas = map[string]*types.ReplicaInfo{replica.Name: replica}
+	snapshot := &types.MetadataSnapshot{
+		Version:  types.MetadataSnapshotVersion,
+		Settings: &types.SettingsInfo{BackupTarget: "vfs:///var/lib/longhorn/backups"},
+		Hosts:    []*types.HostInfo{{UUID: "host-1", Name: "node-1", Heartbeat: "2017-03-25T02:27:00Z", FailureDomain: "rack-1"}},
+		Volumes:  []*types.VolumeInfo{volume},
+	}
+	c.Assert(st.RestoreMetadata(snapshot, "recover-metadata test"), IsNil)
+
+	restored, err := st.GetVolume(volume.Name)
+	c.Assert(err, IsNil)
+	c.Assert(restored.Controller, IsNil)
+	c.Assert(restored.State, Equals, types.VolumeStateDetached)
+	c.Assert(restored.Replicas, HasLen, 1)
+	c.Assert(restored.Replicas[replica.Name].Running, Equals, false)
+	c.Assert(restored.Replicas[replica.Name].HostID, Equals, replica.HostID)
+	c.Assert(volume.Replicas[replica.Name].Running, Equals, true)
+
+	host, err := st.GetHost("host-1")
+	c.Assert(err, IsNil)
+	c.Assert(host.Heartbeat, Equals, "")
+	c.Assert(host.FailureDomain, Equals, "rack-1")
+	settings, err := st.GetSettings()
+	c.Assert(err, IsNil)
+	c.Assert(settings.BackupTarget, Equals, "vfs:///var/lib/longhorn/backups")
+
+	// the prefix isn't empty anymore
+	c.Assert(st.RestoreMetadata(snapshot, "recover-metadata test"), NotNil)
+}
@@ -0,0 +1,160 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+const (
+	keyLocks = "locks"
+)
+
+func (s *KVStore) lockKey(name string) string {
+	return filepath.Join(s.key(keyLocks), name)
+}
+
+func (s *KVStore) getLock(name string) (*types.LockInfo, uint64, error) {
+	lock := &types.LockInfo{}
+	revision, err := s.b.GetWithRevision(s.lockKey(name), lock)
+	if err != nil {
+		if s.b.IsNotFoundError(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, errors.Wrapf(err, "unable to get lock %v", name)
+	}
+	return lock, revision, nil
+}
+
+// AcquireLock takes the lock if it's free, expired or revoked
+func (s *KVStore) AcquireLock(lock *types.LockInfo, ttl time.Duration) error {
+	for {
+		current, revision, err := s.getLock(lock.Name)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if current != nil && current.Held(now) {
+			return &types.ErrLockHeld{Lock: current}
+		}
+		lock.Acquired = util.FormatTimeZ(now)
+		lock.Expires = util.FormatTimeZ(now.Add(ttl))
+		lock.RevokedBy = ""
+		err = s.b.SetIfRevision(s.lockKey(lock.Name), lock, revision)
+		if err == nil {
+			return nil
+		}
+		if !s.b.IsConflictError(err) {
+			return errors.Wrapf(err, "unable to set lock %v", lock.Name)
+		}
+	}
+}
+
+func (s *KVStore) RefreshLock(name, operationID string, ttl time.Duration) error {
+	for {
+		lock, revision, err := s.getLock(name)
+		if err != nil {
+			return err
+		}
+		if lock == nil || lock.OperationID != operationID {
+			return &types.ErrLockLost{Name: name, OperationID: operationID}
+		}
+		if lock.RevokedBy != "" {
+			return &types.ErrLockLost{Name: name, OperationID: operationID, RevokedBy: lock.RevokedBy}
+		}
+		lock.Expires = util.FormatTimeZ(time.Now().Add(ttl))
+		err = s.b.SetIfRevision(s.lockKey(name), lock, revision)
+		if err == nil {
+			return nil
+		}
+		if !s.b.IsConflictError(err) {
+			return errors.Wrapf(err, "unable to set lock %v", name)
+		}
+	}
+}
+
+// ReleaseLock removes the lock if the operation still holds it. The lock
+// is only removed at the revision read, so a lock taken over meanwhile is
+// left alone.
+func (s *KVStore) ReleaseLock(name, operationID string) error {
+	lock, revision, err := s.getLock(name)
+	if err != nil {
+		return err
+	}
+	if lock == nil || lock.OperationID != operationID {
+		return nil
+	}
+	if err := s.b.DeleteIfRevision(s.lockKey(name), revision); err != nil {
+		if s.b.IsConflictError(err) || s.b.IsNotFoundError(err) {
+			logrus.Debugf("lock %v no longer held by operation %v, not removed", name, operationID)
+			return nil
+		}
+		return errors.Wrapf(err, "unable to remove lock %v", name)
+	}
+	return nil
+}
+
+func (s *KVStore) ListLocks() ([]*types.LockInfo, error) {
+	values, revisions, err := s.b.ValuesWithRevisions(s.key(keyLocks))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list locks")
+	}
+	now := time.Now()
+	locks := []*types.LockInfo{}
+	for key, value := range values {
+		lock := &types.LockInfo{}
+		if err := json.Unmarshal(value, lock); err != nil {
+			return nil, errors.Wrapf(err, "invalid lock %v", key)
+		}
+		expires, err := util.ParseTimeZ(lock.Expires)
+		if err == nil && !now.Before(expires) {
+			err := s.b.DeleteIfRevision(key, revisions[key])
+			if err == nil {
+				logrus.Infof("Removed lock %v of operation %v, expired at %v", lock.Name, lock.OperationID, lock.Expires)
+				continue
+			}
+			if !s.b.IsConflictError(err) && !s.b.IsNotFoundError(err) {
+				return nil, errors.Wrapf(err, "unable to remove expired lock %v", lock.Name)
+			}
+			// taken or removed since the listing
+			current, _, err := s.getLock(lock.Name)
+			if err != nil {
+				return nil, err
+			}
+			if current == nil {
+				continue
+			}
+			lock = current
+		}
+		locks = append(locks, lock)
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Name < locks[j].Name })
+	return locks, nil
+}
+
+func (s *KVStore) RevokeLock(name, operationID, revokedBy string) (*types.LockInfo, error) {
+	for {
+		lock, revision, err := s.getLock(name)
+		if err != nil {
+			return nil, err
+		}
+		if lock == nil || lock.OperationID != operationID {
+			return nil, errors.Errorf("operation %v doesn't hold lock %v", operationID, name)
+		}
+		lock.RevokedBy = revokedBy
+		err = s.b.SetIfRevision(s.lockKey(name), lock, revision)
+		if err == nil {
+			return lock, nil
+		}
+		if !s.b.IsConflictError(err) {
+			return nil, errors.Wrapf(err, "unable to set lock %v", name)
+		}
+	}
+}
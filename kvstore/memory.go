@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 
@@ -12,18 +13,25 @@ import (
 
 var (
 	MemoryKeyNotFoundError = errors.Errorf("key not found")
+	MemoryConflictError    = errors.Errorf("key modified")
 
 	Separator = "/"
 )
 
 type MemoryBackend struct {
 	c *cache.Cache
+
+	// mimic etcd modified index
+	revisionLock sync.Mutex
+	revision     uint64
+	revisions    map[string]uint64
 }
 
 func NewMemoryBackend() (*MemoryBackend, error) {
 	c := cache.New(cache.NoExpiration, cache.NoExpiration)
 	return &MemoryBackend{
-		c: c,
+		c:         c,
+		revisions: map[string]uint64{},
 	}, nil
 }
 
@@ -32,7 +40,11 @@ func (m *MemoryBackend) Set(key string, obj interface{}) error {
 	if err != nil {
 		return err
 	}
+	m.revisionLock.Lock()
+	defer m.revisionLock.Unlock()
 	m.c.SetDefault(key, string(value))
+	m.revision++
+	m.revisions[key] = m.revision
 	return nil
 }
 
@@ -47,6 +59,32 @@ func (m *MemoryBackend) Get(key string, obj interface{}) error {
 	return nil
 }
 
+func (m *MemoryBackend) GetWithRevision(key string, obj interface{}) (uint64, error) {
+	m.revisionLock.Lock()
+	defer m.revisionLock.Unlock()
+	if err := m.Get(key, obj); err != nil {
+		return 0, err
+	}
+	return m.revisions[key], nil
+}
+
+func (m *MemoryBackend) SetIfRevision(key string, obj interface{}, revision uint64) error {
+	value, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	m.revisionLock.Lock()
+	defer m.revisionLock.Unlock()
+	_, exists := m.c.Get(key)
+	if (revision == 0 && exists) || (revision != 0 && m.revisions[key] != revision) {
+		return MemoryConflictError
+	}
+	m.c.SetDefault(key, string(value))
+	m.revision++
+	m.revisions[key] = m.revision
+	return nil
+}
+
 func (m *MemoryBackend) Delete(key string) error {
 	keys, err := m.Keys(key)
 	if err != nil {
@@ -56,12 +94,29 @@ func (m *MemoryBackend) Delete(key string) error {
 		return nil
 	}
 
+	m.revisionLock.Lock()
+	defer m.revisionLock.Unlock()
 	for _, key := range keys {
 		m.c.Delete(key)
+		delete(m.revisions, key)
 	}
 	return nil
 }
 
+func (m *MemoryBackend) DeleteIfRevision(key string, revision uint64) error {
+	m.revisionLock.Lock()
+	defer m.revisionLock.Unlock()
+	if _, exists := m.c.Get(key); !exists {
+		return MemoryKeyNotFoundError
+	}
+	if m.revisions[key] != revision {
+		return MemoryConflictError
+	}
+	m.c.Delete(key)
+	delete(m.revisions, key)
+	return nil
+}
+
 func (m *MemoryBackend) Keys(prefix string) ([]string, error) {
 	keys := []string{}
 
@@ -79,7 +134,7 @@ func (m *MemoryBackend) Keys(prefix string) ([]string, error) {
 			continue
 		}
 
-		if !strings.HasPrefix(key, prefix) {
+		if key != prefix && !strings.HasPrefix(key, strings.TrimSuffix(prefix, Separator)+Separator) {
 			continue
 		}
 
@@ -104,6 +159,46 @@ func (m *MemoryBackend) Keys(prefix string) ([]string, error) {
 	return keys, nil
 }
 
+func (m *MemoryBackend) Revisions(prefix string) (map[string]uint64, error) {
+	m.revisionLock.Lock()
+	defer m.revisionLock.Unlock()
+	ret := map[string]uint64{}
+	for key := range m.c.Items() {
+		if key == prefix || strings.HasPrefix(key, strings.TrimSuffix(prefix, Separator)+Separator) {
+			ret[key] = m.revisions[key]
+		}
+	}
+	return ret, nil
+}
+
+func (m *MemoryBackend) Values(prefix string) (map[string][]byte, error) {
+	ret := map[string][]byte{}
+	for key, item := range m.c.Items() {
+		if key == prefix || strings.HasPrefix(key, strings.TrimSuffix(prefix, Separator)+Separator) {
+			ret[key] = []byte(item.Object.(string))
+		}
+	}
+	return ret, nil
+}
+
+func (m *MemoryBackend) ValuesWithRevisions(prefix string) (map[string][]byte, map[string]uint64, error) {
+	m.revisionLock.Lock()
+	defer m.revisionLock.Unlock()
+	values := map[string][]byte{}
+	revisions := map[string]uint64{}
+	for key, item := range m.c.Items() {
+		if key == prefix || strings.HasPrefix(key, strings.TrimSuffix(prefix, Separator)+Separator) {
+			values[key] = []byte(item.Object.(string))
+			revisions[key] = m.revisions[key]
+		}
+	}
+	return values, revisions, nil
+}
+
 func (m *MemoryBackend) IsNotFoundError(err error) bool {
 	return err == MemoryKeyNotFoundError
 }
+
+func (m *MemoryBackend) IsConflictError(err error) bool {
+	return err == MemoryConflictError
+}
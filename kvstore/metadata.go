@@ -0,0 +1,54 @@
+package kvstore
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// RestoreMetadata writes the volumes, hosts and settings of a metadata
+// snapshot into the prefix, which must be empty. The volumes are detached
+// with their replicas stopped, the hosts have no heartbeat so they're
+// unknown until their managers register them again.
+func (s *KVStore) RestoreMetadata(snapshot *types.MetadataSnapshot, author string) error {
+	keys, err := s.b.Keys(s.key(""))
+	if err != nil {
+		return errors.Wrapf(err, "unable to list keys under %v", s.Prefix)
+	}
+	if len(keys) != 0 {
+		return errors.Errorf("refused to restore metadata into %v, it's not empty", s.Prefix)
+	}
+
+	for _, volume := range snapshot.Volumes {
+		v := *volume
+		v.Controller = nil
+		v.State = types.VolumeStateDetached
+		v.Endpoint = ""
+		v.AttachEnv = nil
+		v.Replicas = map[string]*types.ReplicaInfo{}
+		for _, replica := range volume.Replicas {
+			r := *replica
+			r.Running = false
+			v.Replicas[r.Name] = &r
+		}
+		if err := s.SetVolume(&v); err != nil {
+			return errors.Wrapf(err, "unable to restore volume %v", v.Name)
+		}
+	}
+	for _, host := range snapshot.Hosts {
+		h := *host
+		h.Heartbeat = ""
+		if err := s.SetHost(&h); err != nil {
+			return errors.Wrapf(err, "unable to restore host %v", h.UUID)
+		}
+	}
+	if snapshot.Settings != nil {
+		if err := s.UpdateSettings(author, nil, func(si *types.SettingsInfo) error {
+			*si = *snapshot.Settings
+			return nil
+		}); err != nil {
+			return errors.Wrap(err, "unable to restore settings")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package kvstore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// writeLimiter is a token bucket throttling the writes of a store: each
+// write takes a token, the bucket holds up to burst tokens and is refilled
+// at rate tokens a second. The writes beyond the burst reserve the tokens to
+// come and wait for them, in the order they came.
+type writeLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newWriteLimiter(rate float64, burst int) *writeLimiter {
+	return &writeLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// wait takes a token, waiting for it if the bucket is empty
+func (l *writeLimiter) wait() {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	delay := time.Duration(0)
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mutex.Unlock()
+
+	if delay > 0 {
+		logrus.Debugf("write to the kv store throttled for %v", delay)
+		l.sleep(delay)
+	}
+}
+
+// SetWriteLimit throttles the writes of the volumes, hosts and settings to
+// rate a second, after a burst of burst writes. The reads are never
+// throttled. A rate of 0 lifts the limit.
+func (s *KVStore) SetWriteLimit(rate float64, burst int) {
+	if rate <= 0 {
+		s.writes = nil
+		return
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	s.writes = newWriteLimiter(rate, burst)
+}
@@ -0,0 +1,83 @@
+package kvstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rancher/longhorn-manager/types"
+
+	. "gopkg.in/check.v1"
+)
+
+// timedBackend records when each write reached the backend
+type timedBackend struct {
+	Backend
+	now    func() time.Time
+	writes []time.Time
+}
+
+func (b *timedBackend) Set(key string, obj interface{}) error {
+	b.writes = append(b.writes, b.now())
+	return b.Backend.Set(key, obj)
+}
+
+func (b *timedBackend) SetIfRevision(key string, obj interface{}, revision uint64) error {
+	b.writes = append(b.writes, b.now())
+	return b.Backend.SetIfRevision(key, obj, revision)
+}
+
+func (s *TestSuite) TestWriteLimit(c *C) {
+	start := time.Date(2017, 5, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	clock := func() time.Time { return now }
+
+	memory, err := NewMemoryBackend()
+	c.Assert(err, IsNil)
+	backend := &timedBackend{Backend: memory, now: clock}
+	st, err := NewKVStore("/longhorn", backend)
+	c.Assert(err, IsNil)
+	st.SetWriteLimit(10, 5)
+	st.writes.now = clock
+	st.writes.last = start
+	st.writes.sleep = func(d time.Duration) { now = now.Add(d) }
+
+	// the burst goes through at once, the following writes at the rate
+	for i := 0; i < 25; i++ {
+		host := &types.HostInfo{UUID: fmt.Sprintf("host-%v", i), Name: "node", Address: "127.0.0.1:9500"}
+		c.Assert(st.SetHost(host), IsNil)
+	}
+	c.Assert(backend.writes, HasLen, 25)
+	for i, t := range backend.writes[:5] {
+		c.Assert(t, Equals, start, Commentf("write %v", i))
+	}
+	for i, t := range backend.writes[5:] {
+		c.Assert(t.Sub(start), Equals, time.Duration(i+1)*100*time.Millisecond, Commentf("write %v", i+5))
+	}
+	c.Assert(now.Sub(start), Equals, 2*time.Second)
+
+	// the reads aren't throttled
+	for i := 0; i < 25; i++ {
+		_, err := st.GetHost(fmt.Sprintf("host-%v", i))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(now.Sub(start), Equals, 2*time.Second)
+
+	// the bucket refills while idle, up to the burst
+	now = now.Add(time.Minute)
+	idle := now
+	volume := generateTestVolume("volume1")
+	volume.Controller = generateTestController(volume.Name)
+	volume.Replicas = map[string]*types.ReplicaInfo{}
+	for i := 0; i < 3; i++ {
+		replica := generateTestReplica(volume.Name, fmt.Sprintf("replica%v", i))
+		volume.Replicas[replica.Name] = replica
+	}
+	c.Assert(st.SetVolume(volume), IsNil)
+	c.Assert(st.SetSettings(&types.SettingsInfo{BackupTarget: "vfs:///var/lib/longhorn/backups"}), IsNil)
+	c.Assert(now.Sub(idle), Equals, 100*time.Millisecond)
+
+	// unlimited
+	st.SetWriteLimit(0, 0)
+	c.Assert(st.SetVolume(volume), IsNil)
+	c.Assert(now.Sub(idle), Equals, 100*time.Millisecond)
+}
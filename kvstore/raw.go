@@ -0,0 +1,89 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// volumeRawPrefixes are the keys under which all the records belong to the
+// volume
+func (s *KVStore) volumeRawPrefixes(volumeName string) []string {
+	return []string{
+		s.volumeRootKey(volumeName),
+		s.volumeEventsKey(volumeName),
+	}
+}
+
+func (s *KVStore) ListVolumeRawRecords(volumeName string) ([]*types.RawRecord, error) {
+	records := []*types.RawRecord{}
+	for _, prefix := range s.volumeRawPrefixes(volumeName) {
+		values, revisions, err := s.b.ValuesWithRevisions(prefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to get records under %v", prefix)
+		}
+		for key, value := range values {
+			records = append(records, &types.RawRecord{
+				Key:           key,
+				Value:         string(value),
+				ModifiedIndex: revisions[key],
+			})
+		}
+	}
+
+	values, revisions, err := s.b.ValuesWithRevisions(s.key(keyLocks))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get locks")
+	}
+	for key, value := range values {
+		lock := &types.LockInfo{}
+		if err := json.Unmarshal(value, lock); err != nil || lock.VolumeName != volumeName {
+			continue
+		}
+		records = append(records, &types.RawRecord{
+			Key:           key,
+			Value:         string(value),
+			ModifiedIndex: revisions[key],
+		})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records, nil
+}
+
+func (s *KVStore) SetVolumeRawRecord(volumeName string, record *types.RawRecord) error {
+	if record.ModifiedIndex == 0 {
+		return errors.Errorf("modified index of %v is required", record.Key)
+	}
+	if !json.Valid([]byte(record.Value)) {
+		return errors.Errorf("value of %v isn't valid JSON", record.Key)
+	}
+	if !s.isVolumeRawKey(volumeName, record.Key) {
+		return errors.Errorf("key %v doesn't belong to volume %v", record.Key, volumeName)
+	}
+	if err := s.b.SetIfRevision(record.Key, json.RawMessage(record.Value), record.ModifiedIndex); err != nil {
+		if s.b.IsConflictError(err) {
+			return errors.Errorf("key %v was modified after index %v", record.Key, record.ModifiedIndex)
+		}
+		return errors.Wrapf(err, "unable to set %v", record.Key)
+	}
+	return nil
+}
+
+func (s *KVStore) isVolumeRawKey(volumeName, key string) bool {
+	for _, prefix := range s.volumeRawPrefixes(volumeName) {
+		if strings.HasPrefix(key, prefix+Separator) {
+			return true
+		}
+	}
+	if strings.HasPrefix(key, s.key(keyLocks)+Separator) {
+		lock := &types.LockInfo{}
+		if err := s.b.Get(key, lock); err == nil && lock.VolumeName == volumeName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const (
+	keyVolumeSnapshots = "snapshots"
+)
+
+func (k *VolumeKey) Snapshots() string {
+	return filepath.Join(k.rootKey, keyVolumeSnapshots)
+}
+
+func (k *VolumeKey) Snapshot(snapshotName string) string {
+	return filepath.Join(k.Snapshots(), snapshotName)
+}
+
+// CreateVolumeSnapshot records the snapshot of the volume, removed with the
+// volume. It fails with ErrSnapshotExists if the volume has a snapshot of
+// that name recorded already.
+func (s *KVStore) CreateVolumeSnapshot(volumeName string, snapshot *types.SnapshotInfo) error {
+	key := s.NewVolumeKeyFromName(volumeName).Snapshot(snapshot.Name)
+	if err := s.b.SetIfRevision(key, snapshot, 0); err != nil {
+		if s.b.IsConflictError(err) {
+			return &types.ErrSnapshotExists{VolumeName: volumeName, SnapshotName: snapshot.Name}
+		}
+		return errors.Wrapf(err, "unable to record snapshot %v of volume %v", snapshot.Name, volumeName)
+	}
+	return nil
+}
+
+// GetVolumeSnapshot returns nil if the volume has no snapshot of that name
+// recorded
+func (s *KVStore) GetVolumeSnapshot(volumeName, snapshotName string) (*types.SnapshotInfo, error) {
+	snapshot := &types.SnapshotInfo{}
+	if err := s.b.Get(s.NewVolumeKeyFromName(volumeName).Snapshot(snapshotName), snapshot); err != nil {
+		if s.b.IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "unable to get snapshot %v of volume %v", snapshotName, volumeName)
+	}
+	return snapshot, nil
+}
+
+// ListVolumeSnapshots returns the snapshots of the volume recorded, by name
+func (s *KVStore) ListVolumeSnapshots(volumeName string) (map[string]*types.SnapshotInfo, error) {
+	values, err := s.b.Values(s.NewVolumeKeyFromName(volumeName).Snapshots())
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list snapshots of volume %v", volumeName)
+	}
+	snapshots := map[string]*types.SnapshotInfo{}
+	for key, value := range values {
+		snapshot := &types.SnapshotInfo{}
+		if err := json.Unmarshal(value, snapshot); err != nil {
+			return nil, errors.Wrapf(err, "fail to unmarshal json of %v", key)
+		}
+		snapshots[snapshot.Name] = snapshot
+	}
+	return snapshots, nil
+}
+
+func (s *KVStore) DeleteVolumeSnapshot(volumeName, snapshotName string) error {
+	if err := s.b.Delete(s.NewVolumeKeyFromName(volumeName).Snapshot(snapshotName)); err != nil {
+		return errors.Wrapf(err, "unable to remove snapshot %v of volume %v", snapshotName, volumeName)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package kvstore
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const (
+	keyAPITokens = "apitokens"
+)
+
+func (s *KVStore) apiTokenKey(id string) string {
+	return filepath.Join(s.key(keyAPITokens), id)
+}
+
+func (s *KVStore) ListAPITokens() (map[string]*types.APIToken, error) {
+	keys, err := s.b.Keys(s.key(keyAPITokens))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list API tokens")
+	}
+	tokens := map[string]*types.APIToken{}
+	for _, key := range keys {
+		token := &types.APIToken{}
+		if err := s.b.Get(key, token); err != nil {
+			if s.b.IsNotFoundError(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "unable to get API token %v", filepath.Base(key))
+		}
+		tokens[token.ID] = token
+	}
+	return tokens, nil
+}
+
+func (s *KVStore) SetAPIToken(token *types.APIToken) error {
+	if err := s.b.Set(s.apiTokenKey(token.ID), token); err != nil {
+		return errors.Wrapf(err, "unable to set API token %v", token.ID)
+	}
+	return nil
+}
+
+func (s *KVStore) DeleteAPIToken(id string) error {
+	if err := s.b.Delete(s.apiTokenKey(id)); err != nil {
+		return errors.Wrapf(err, "unable to delete API token %v", id)
+	}
+	return nil
+}
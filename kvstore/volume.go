@@ -1,13 +1,25 @@
 package kvstore
 
 import (
+	"encoding/json"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
 
 	"github.com/rancher/longhorn-manager/types"
 )
 
+var (
+	// VolumeListPageSize is the number of volumes read at a time by a
+	// volume scan, VolumeListWorkers of them concurrently
+	VolumeListPageSize = 100
+	VolumeListWorkers  = 8
+)
+
 const (
 	keyVolumes = "volumes"
 
@@ -67,14 +79,51 @@ func (s *KVStore) SetVolumeBase(volume *types.VolumeInfo) error {
 	volumeBase := *volume
 	volumeBase.Controller = nil
 	volumeBase.Replicas = nil
+	volumeBase.Writer = s.stamp(volume.Writer)
+	s.writes.wait()
 	return s.b.Set(s.NewVolumeKeyFromName(volume.Name).Base(), &volumeBase)
 }
 
+// UpdateVolumeBase applies update to the base of the volume, retrying if the
+// base is modified concurrently, and returns the base written. The instances
+// of the volume are left as they are.
+func (s *KVStore) UpdateVolumeBase(volumeName string, update func(*types.VolumeInfo) error) (*types.VolumeInfo, error) {
+	key := s.NewVolumeKeyFromName(volumeName).Base()
+	for {
+		volume := &types.VolumeInfo{}
+		revision, err := s.b.GetWithRevision(key, volume)
+		if err != nil {
+			if s.b.IsNotFoundError(err) {
+				return nil, errors.Errorf("cannot find volume %v", volumeName)
+			}
+			return nil, errors.Wrapf(err, "unable to get volume %v", volumeName)
+		}
+		volume.Controller, volume.Replicas = nil, nil
+		if err := update(volume); err != nil {
+			return nil, err
+		}
+		volume.Controller, volume.Replicas = nil, nil
+		volume.Writer = s.stamp(volume.Writer)
+		s.writes.wait()
+		err = s.b.SetIfRevision(key, volume, revision)
+		if err == nil {
+			return volume, nil
+		}
+		if !s.b.IsConflictError(err) {
+			return nil, errors.Wrapf(err, "unable to set volume %v", volumeName)
+		}
+		logrus.Debugf("volume %v modified concurrently, retrying", volumeName)
+	}
+}
+
 func (s *KVStore) SetVolumeController(controller *types.ControllerInfo) error {
 	if controller.VolumeName == "" {
 		return errors.Errorf("controller doesn't have valid volume name: %+v", controller)
 	}
-	return s.b.Set(s.NewVolumeKeyFromName(controller.VolumeName).Controller(), controller)
+	c := *controller
+	c.Writer = s.stamp(controller.Writer)
+	s.writes.wait()
+	return s.b.Set(s.NewVolumeKeyFromName(controller.VolumeName).Controller(), &c)
 }
 
 func (s *KVStore) SetVolumeReplicas(replicas map[string]*types.ReplicaInfo) error {
@@ -90,7 +139,10 @@ func (s *KVStore) SetVolumeReplica(replica *types.ReplicaInfo) error {
 	if replica.VolumeName == "" {
 		return errors.Errorf("replica doesn't have valid volume name: %+v", replica)
 	}
-	return s.b.Set(s.NewVolumeKeyFromName(replica.VolumeName).Replica(replica.Name), replica)
+	r := *replica
+	r.Writer = s.stamp(replica.Writer)
+	s.writes.wait()
+	return s.b.Set(s.NewVolumeKeyFromName(replica.VolumeName).Replica(replica.Name), &r)
 }
 
 func (s *KVStore) GetVolumeBase(id string) (*types.VolumeInfo, error) {
@@ -101,6 +153,8 @@ func (s *KVStore) GetVolumeBase(id string) (*types.VolumeInfo, error) {
 	return volume, nil
 }
 
+// getVolumeBaseByKey reads the base of the volume, without the instances
+// of a base in the monolithic format
 func (s *KVStore) getVolumeBaseByKey(key string) (*types.VolumeInfo, error) {
 	volume := types.VolumeInfo{}
 	if err := s.b.Get(key, &volume); err != nil {
@@ -109,9 +163,7 @@ func (s *KVStore) getVolumeBaseByKey(key string) (*types.VolumeInfo, error) {
 		}
 		return nil, err
 	}
-	if volume.Controller != nil || volume.Replicas != nil {
-		return nil, errors.Errorf("BUG: volume base shouldn't have instances info: %+v", volume)
-	}
+	volume.Controller, volume.Replicas = nil, nil
 	return &volume, nil
 }
 
@@ -201,10 +253,13 @@ func (s *KVStore) DeleteVolumeReplica(volumeName, replicaName string) error {
 	return nil
 }
 
+// SetVolume writes the base of the volume and each of its instances under
+// its own key, then removes the replicas the volume no longer has. The
+// replicas kept are never missing in between.
 func (s *KVStore) SetVolume(volume *types.VolumeInfo) (err error) {
 	defer func() {
 		if err != nil {
-			err = errors.Wrapf(err, "unable to set volume %+v", volume)
+			err = errors.Wrapf(err, "unable to set volume %+v", volume.Name)
 		}
 	}()
 
@@ -212,16 +267,16 @@ func (s *KVStore) SetVolume(volume *types.VolumeInfo) (err error) {
 		return err
 	}
 
-	if err := s.DeleteVolumeController(volume.Name); err != nil {
-		return err
-	}
 	if volume.Controller != nil {
 		if err := s.SetVolumeController(volume.Controller); err != nil {
 			return err
 		}
+	} else if err := s.DeleteVolumeController(volume.Name); err != nil {
+		return err
 	}
 
-	if err := s.DeleteVolumeReplicas(volume.Name); err != nil {
+	replicaKeys, err := s.b.Keys(s.NewVolumeKeyFromName(volume.Name).Replicas())
+	if err != nil {
 		return err
 	}
 	if volume.Replicas != nil {
@@ -229,9 +284,51 @@ func (s *KVStore) SetVolume(volume *types.VolumeInfo) (err error) {
 			return err
 		}
 	}
+	current := map[string]bool{}
+	for _, replica := range volume.Replicas {
+		current[replica.Name] = true
+	}
+	for _, key := range replicaKeys {
+		if current[filepath.Base(key)] {
+			continue
+		}
+		if err := s.b.Delete(key); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// UpdateVolumeReplica applies update to the replica of the volume, retrying
+// if the replica is modified concurrently. The other records of the volume
+// are left as they are.
+func (s *KVStore) UpdateVolumeReplica(volumeName, replicaName string, update func(*types.ReplicaInfo) error) error {
+	key := s.NewVolumeKeyFromName(volumeName).Replica(replicaName)
+	for {
+		replica := &types.ReplicaInfo{}
+		revision, err := s.b.GetWithRevision(key, replica)
+		if err != nil {
+			if s.b.IsNotFoundError(err) {
+				return errors.Errorf("cannot find replica %v of volume %v", replicaName, volumeName)
+			}
+			return errors.Wrapf(err, "unable to get replica %v of volume %v", replicaName, volumeName)
+		}
+		if err := update(replica); err != nil {
+			return err
+		}
+		replica.Writer = s.stamp(replica.Writer)
+		s.writes.wait()
+		err = s.b.SetIfRevision(key, replica, revision)
+		if err == nil {
+			return nil
+		}
+		if !s.b.IsConflictError(err) {
+			return errors.Wrapf(err, "unable to set replica %v of volume %v", replicaName, volumeName)
+		}
+		logrus.Debugf("replica %v of volume %v modified concurrently, retrying", replicaName, volumeName)
+	}
+}
+
 func (s *KVStore) GetVolume(id string) (*types.VolumeInfo, error) {
 	volume, err := s.getVolumeByKey(s.volumeRootKey(id))
 	if err != nil {
@@ -240,34 +337,51 @@ func (s *KVStore) GetVolume(id string) (*types.VolumeInfo, error) {
 	return volume, nil
 }
 
+// getVolumeByKey reads the volume with its instances in a single read
 func (s *KVStore) getVolumeByKey(key string) (*types.VolumeInfo, error) {
-	volumeKey := s.NewVolumeKeyFromRootKey(key)
-	volume, err := s.getVolumeBaseByKey(volumeKey.Base())
+	values, err := s.b.Values(key)
 	if err != nil {
 		return nil, err
 	}
-	if volume == nil {
+	return s.decodeVolume(key, values)
+}
+
+// decodeVolume builds the volume from the values of the keys under its root
+// key, nil if it has no base
+func (s *KVStore) decodeVolume(key string, values map[string][]byte) (*types.VolumeInfo, error) {
+	volumeKey := s.NewVolumeKeyFromRootKey(key)
+	base, ok := values[volumeKey.Base()]
+	if !ok {
 		return nil, nil
 	}
-
-	volume.Controller = nil
-	controller, err := s.getVolumeControllerByKey(volumeKey.Controller())
-	if err != nil {
-		return nil, err
+	volume := &types.VolumeInfo{}
+	if err := json.Unmarshal(base, volume); err != nil {
+		return nil, errors.Wrapf(err, "fail to unmarshal json of %v", volumeKey.Base())
 	}
-	if controller != nil {
+	// the instances of a base in the monolithic format, not split yet, are
+	// overridden by the ones under their own keys
+	if value, ok := values[volumeKey.Controller()]; ok {
+		controller := &types.ControllerInfo{}
+		if err := json.Unmarshal(value, controller); err != nil {
+			return nil, errors.Wrapf(err, "fail to unmarshal json of %v", volumeKey.Controller())
+		}
 		volume.Controller = controller
 	}
 
-	volume.Replicas = nil
-	replicas, err := s.getVolumeReplicasByKey(volumeKey.Replicas())
-	if err != nil {
-		return nil, err
-	}
-	if replicas != nil && len(replicas) != 0 {
-		volume.Replicas = replicas
+	replicasPrefix := volumeKey.Replicas() + "/"
+	for k, value := range values {
+		if !strings.HasPrefix(k, replicasPrefix) {
+			continue
+		}
+		replica := &types.ReplicaInfo{}
+		if err := json.Unmarshal(value, replica); err != nil {
+			return nil, errors.Wrapf(err, "fail to unmarshal json of %v", k)
+		}
+		if volume.Replicas == nil {
+			volume.Replicas = map[string]*types.ReplicaInfo{}
+		}
+		volume.Replicas[replica.Name] = replica
 	}
-
 	return volume, nil
 }
 
@@ -279,19 +393,151 @@ func (s *KVStore) DeleteVolume(id string) error {
 }
 
 func (s *KVStore) ListVolumes() ([]*types.VolumeInfo, error) {
+	volumes := []*types.VolumeInfo{}
+	if err := s.ForEachVolume("", func(volume *types.VolumeInfo) error {
+		volumes = append(volumes, volume)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
+
+type volumeReadResult struct {
+	volume *types.VolumeInfo
+	err    error
+}
+
+// ForEachVolume calls fn with each volume named after the given name, in
+// name order, until fn returns an error. The volumes are read a page at a
+// time, so the whole set is never held at once.
+func (s *KVStore) ForEachVolume(after string, fn func(volume *types.VolumeInfo) error) error {
 	volumeKeys, err := s.b.Keys(s.key(keyVolumes))
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to list volumes")
+		return errors.Wrap(err, "unable to list volumes")
 	}
-	volumes := []*types.VolumeInfo{}
+	sort.Strings(volumeKeys)
+	if after != "" {
+		first := sort.SearchStrings(volumeKeys, s.volumeRootKey(after))
+		if first < len(volumeKeys) && volumeKeys[first] == s.volumeRootKey(after) {
+			first++
+		}
+		volumeKeys = volumeKeys[first:]
+	}
+	for len(volumeKeys) > 0 {
+		page := volumeKeys
+		if len(page) > VolumeListPageSize {
+			page = page[:VolumeListPageSize]
+		}
+		volumeKeys = volumeKeys[len(page):]
+		for _, result := range s.readVolumes(page) {
+			if result.err != nil {
+				return errors.Wrap(result.err, "unable to list volumes")
+			}
+			if result.volume == nil {
+				continue
+			}
+			if err := fn(result.volume); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readVolumes reads the volumes of the keys with VolumeListWorkers
+// concurrent reads, the results are in the order of the keys
+func (s *KVStore) readVolumes(keys []string) []*volumeReadResult {
+	results := make([]*volumeReadResult, len(keys))
+	indexes := make(chan int)
+	wg := &sync.WaitGroup{}
+	workers := VolumeListWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				volume, err := s.getVolumeByKey(keys[i])
+				results[i] = &volumeReadResult{volume: volume, err: err}
+			}
+		}()
+	}
+	for i := range keys {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// SplitVolumeRecords moves the instances of the volume bases in the
+// monolithic format, written before the controller and each replica had
+// their own key, to their keys. The base is rewritten only if it wasn't
+// modified since it was read, and the instances already under their keys
+// are kept. It returns the number of volumes split.
+func (s *KVStore) SplitVolumeRecords() (int, error) {
+	volumeKeys, err := s.b.Keys(s.key(keyVolumes))
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to list volumes")
+	}
+	split := 0
 	for _, key := range volumeKeys {
-		volume, err := s.getVolumeByKey(key)
+		done, err := s.splitVolumeRecord(s.NewVolumeKeyFromRootKey(key))
 		if err != nil {
-			return nil, errors.Wrapf(err, "unable to list volumes")
+			return split, errors.Wrapf(err, "unable to split record of volume %v", filepath.Base(key))
 		}
-		if volume != nil {
-			volumes = append(volumes, volume)
+		if done {
+			split++
+		}
+	}
+	return split, nil
+}
+
+func (s *KVStore) splitVolumeRecord(volumeKey *VolumeKey) (bool, error) {
+	for {
+		volume := &types.VolumeInfo{}
+		revision, err := s.b.GetWithRevision(volumeKey.Base(), volume)
+		if err != nil {
+			if s.b.IsNotFoundError(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		if volume.Controller == nil && volume.Replicas == nil {
+			return false, nil
+		}
+		if volume.Controller != nil {
+			controller, err := s.getVolumeControllerByKey(volumeKey.Controller())
+			if err != nil {
+				return false, err
+			}
+			if controller == nil {
+				if err := s.b.Set(volumeKey.Controller(), volume.Controller); err != nil {
+					return false, err
+				}
+			}
+		}
+		for name, replica := range volume.Replicas {
+			existing, err := s.getVolumeReplicaByKey(volumeKey.Replica(name))
+			if err != nil {
+				return false, err
+			}
+			if existing == nil {
+				if err := s.b.Set(volumeKey.Replica(name), replica); err != nil {
+					return false, err
+				}
+			}
+		}
+		volume.Controller, volume.Replicas = nil, nil
+		err = s.b.SetIfRevision(volumeKey.Base(), volume, revision)
+		if err == nil {
+			return true, nil
+		}
+		if !s.b.IsConflictError(err) {
+			return false, err
 		}
 	}
-	return volumes, nil
 }
@@ -0,0 +1,112 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const benchmarkVolumes = 10000
+
+// newBenchmarkStore fills a store with the synthetic volumes, on the etcd
+// server of the component tests. It skips without one, the round trips to
+// etcd are what the paged reads save, the memory backend has none.
+func newBenchmarkStore(b *testing.B) *KVStore {
+	etcdIP := os.Getenv(EnvEtcdServer)
+	if etcdIP == "" {
+		b.Skipf("no etcd server, %v not set", EnvEtcdServer)
+	}
+	backend, err := NewETCDBackend([]string{"http://" + etcdIP + ":2379"}, ETCDOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	st, err := NewKVStore("/longhorn-benchmark", backend)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := st.kvNuclear("nuke key value store"); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchmarkVolumes; i++ {
+		name := fmt.Sprintf("volume-%05d", i)
+		volume := generateTestVolume(name)
+		volume.Controller = generateTestController(name)
+		volume.Replicas = map[string]*types.ReplicaInfo{
+			"r1": generateTestReplica(name, "r1"),
+			"r2": generateTestReplica(name, "r2"),
+		}
+		if err := st.SetVolume(volume); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return st
+}
+
+// listVolumesPerKey is how the volumes were listed before the paged reads:
+// one read for each of the base, controller and replicas of every volume
+func (s *KVStore) listVolumesPerKey() ([]*types.VolumeInfo, error) {
+	keys, err := s.b.Keys(s.key(keyVolumes))
+	if err != nil {
+		return nil, err
+	}
+	volumes := []*types.VolumeInfo{}
+	for _, key := range keys {
+		volumeKey := s.NewVolumeKeyFromRootKey(key)
+		volume, err := s.getVolumeBaseByKey(volumeKey.Base())
+		if err != nil {
+			return nil, err
+		}
+		if volume == nil {
+			continue
+		}
+		if volume.Controller, err = s.getVolumeControllerByKey(volumeKey.Controller()); err != nil {
+			return nil, err
+		}
+		if volume.Replicas, err = s.getVolumeReplicasByKey(volumeKey.Replicas()); err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
+
+func BenchmarkListVolumesPerKey(b *testing.B) {
+	st := newBenchmarkStore(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		volumes, err := st.listVolumesPerKey()
+		if err != nil || len(volumes) != benchmarkVolumes {
+			b.Fatalf("listed %v volumes: %v", len(volumes), err)
+		}
+	}
+}
+
+func BenchmarkListVolumes(b *testing.B) {
+	st := newBenchmarkStore(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		volumes, err := st.ListVolumes()
+		if err != nil || len(volumes) != benchmarkVolumes {
+			b.Fatalf("listed %v volumes: %v", len(volumes), err)
+		}
+	}
+}
+
+func BenchmarkForEachVolume(b *testing.B) {
+	st := newBenchmarkStore(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count := 0
+		if err := st.ForEachVolume("", func(*types.VolumeInfo) error {
+			count++
+			return nil
+		}); err != nil || count != benchmarkVolumes {
+			b.Fatalf("scanned %v volumes: %v", count, err)
+		}
+	}
+}
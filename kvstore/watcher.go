@@ -0,0 +1,202 @@
+package kvstore
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+var (
+	// WatchRetryInterval is how long the watcher waits before watching or
+	// resyncing again after an error
+	WatchRetryInterval = 5 * time.Second
+)
+
+// WatchEvent is a change of a key
+type WatchEvent struct {
+	Key     string
+	Value   []byte
+	Deleted bool // the key, or the directory with the keys under it
+	Index   uint64
+}
+
+// Watch returns the changes under a prefix one by one
+type Watch interface {
+	// Next blocks for the next change, or until ctx is done
+	Next(ctx context.Context) (*WatchEvent, error)
+}
+
+// WatchBackend is a backend whose changes can be watched
+type WatchBackend interface {
+	// Snapshot returns the values of all the keys under prefix, and the
+	// index of the store they were read at
+	Snapshot(prefix string) (map[string][]byte, uint64, error)
+	// Watch returns the changes under prefix after afterIndex
+	Watch(prefix string, afterIndex uint64) Watch
+	// IsCompactedError is true if the changes after the index asked for
+	// were compacted away, so the watch cannot go on from there
+	IsCompactedError(err error) bool
+}
+
+// Watcher keeps a cache of the values under a prefix up to date. Once its
+// watch falls behind the compaction of the store, the changes in between
+// are lost, so it reads all the values again and watches from there.
+type Watcher struct {
+	sync.RWMutex
+
+	b      WatchBackend
+	prefix string
+
+	values  map[string][]byte
+	index   uint64
+	synced  bool
+	resyncs int
+}
+
+func NewWatcher(b WatchBackend, prefix string) *Watcher {
+	return &Watcher{
+		b:      b,
+		prefix: prefix,
+		values: map[string][]byte{},
+	}
+}
+
+// Run syncs the cache and watches until stopCh is closed, forever if nil
+func (w *Watcher) Run(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for ctx.Err() == nil {
+		if err := w.resync(); err != nil {
+			logrus.Errorf("%+v", err)
+			w.wait(ctx)
+			continue
+		}
+		err := w.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if w.b.IsCompactedError(err) {
+			logrus.Warnf("Watch of %v fell behind the compaction of the store after index %v, resyncing: %v", w.prefix, w.Index(), err)
+			continue
+		}
+		logrus.Errorf("Watch of %v failed after index %v, resyncing: %v", w.prefix, w.Index(), err)
+		w.wait(ctx)
+	}
+}
+
+func (w *Watcher) wait(ctx context.Context) {
+	select {
+	case <-time.After(WatchRetryInterval):
+	case <-ctx.Done():
+	}
+}
+
+func (w *Watcher) resync() error {
+	values, index, err := w.b.Snapshot(w.prefix)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read %v", w.prefix)
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.synced {
+		w.resyncs++
+	}
+	w.values = values
+	w.index = index
+	w.synced = true
+	return nil
+}
+
+// watch applies the changes until an error
+func (w *Watcher) watch(ctx context.Context) error {
+	watch := w.b.Watch(w.prefix, w.Index())
+	for {
+		event, err := watch.Next(ctx)
+		if err != nil {
+			return err
+		}
+		w.apply(event)
+	}
+}
+
+func (w *Watcher) apply(event *WatchEvent) {
+	w.Lock()
+	defer w.Unlock()
+	if event.Deleted {
+		for key := range w.values {
+			if key == event.Key || strings.HasPrefix(key, strings.TrimSuffix(event.Key, Separator)+Separator) {
+				delete(w.values, key)
+			}
+		}
+	} else {
+		w.values[event.Key] = event.Value
+	}
+	if event.Index > w.index {
+		w.index = event.Index
+	}
+}
+
+// Get returns the cached value of key, and false if there is none
+func (w *Watcher) Get(key string) ([]byte, bool) {
+	w.RLock()
+	defer w.RUnlock()
+	value, ok := w.values[key]
+	return value, ok
+}
+
+// Under returns a copy of the cached values of prefix and the keys under it
+func (w *Watcher) Under(prefix string) map[string][]byte {
+	w.RLock()
+	defer w.RUnlock()
+	values := map[string][]byte{}
+	for k, v := range w.values {
+		if isUnder(k, prefix) {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+// Values returns a copy of the cache
+func (w *Watcher) Values() map[string][]byte {
+	w.RLock()
+	defer w.RUnlock()
+	values := map[string][]byte{}
+	for k, v := range w.values {
+		values[k] = v
+	}
+	return values
+}
+
+// Synced is true once the cache was read
+func (w *Watcher) Synced() bool {
+	w.RLock()
+	defer w.RUnlock()
+	return w.synced
+}
+
+// Index is the index of the store the cache is at
+func (w *Watcher) Index() uint64 {
+	w.RLock()
+	defer w.RUnlock()
+	return w.index
+}
+
+// Resyncs is the number of times the cache was read again after the first
+func (w *Watcher) Resyncs() int {
+	w.RLock()
+	defer w.RUnlock()
+	return w.resyncs
+}
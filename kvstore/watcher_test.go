@@ -0,0 +1,112 @@
+package kvstore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	. "gopkg.in/check.v1"
+)
+
+var errFakeCompacted = errors.Errorf("the event in requested index is outdated and cleared")
+
+// fakeWatchBackend serves the snapshot set by the test, and the changes or
+// errors sent to its channel
+type fakeWatchBackend struct {
+	sync.Mutex
+
+	values    map[string][]byte
+	index     uint64
+	snapshots int
+	watches   []uint64 // the index of each watch
+
+	events chan interface{}
+}
+
+func (b *fakeWatchBackend) Snapshot(prefix string) (map[string][]byte, uint64, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.snapshots++
+	values := map[string][]byte{}
+	for k, v := range b.values {
+		values[k] = v
+	}
+	return values, b.index, nil
+}
+
+func (b *fakeWatchBackend) Watch(prefix string, afterIndex uint64) Watch {
+	b.Lock()
+	defer b.Unlock()
+	b.watches = append(b.watches, afterIndex)
+	return b
+}
+
+func (b *fakeWatchBackend) Next(ctx context.Context) (*WatchEvent, error) {
+	select {
+	case e := <-b.events:
+		if err, ok := e.(error); ok {
+			return nil, err
+		}
+		return e.(*WatchEvent), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *fakeWatchBackend) IsCompactedError(err error) bool {
+	return err == errFakeCompacted
+}
+
+func waitFor(c *C, cond func() bool) {
+	for i := 0; i < 100 && !cond(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(cond(), Equals, true)
+}
+
+func (s *TestSuite) TestWatcherResyncOnCompaction(c *C) {
+	b := &fakeWatchBackend{
+		values: map[string][]byte{"/longhorn/volumes/vol1/base": []byte("1")},
+		index:  10,
+		events: make(chan interface{}),
+	}
+	w := NewWatcher(b, "/longhorn/volumes")
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		w.Run(stopCh)
+		close(done)
+	}()
+
+	b.events <- &WatchEvent{Key: "/longhorn/volumes/vol2/base", Value: []byte("2"), Index: 11}
+	b.events <- &WatchEvent{Key: "/longhorn/volumes/vol1", Deleted: true, Index: 12}
+	waitFor(c, func() bool { return w.Index() == 12 })
+	c.Assert(w.Values(), DeepEquals, map[string][]byte{"/longhorn/volumes/vol2/base": []byte("2")})
+
+	// the changes up to 20 were compacted away before the watcher saw them
+	b.Lock()
+	b.values = map[string][]byte{"/longhorn/volumes/vol3/base": []byte("3")}
+	b.index = 20
+	b.Unlock()
+	b.events <- errFakeCompacted
+
+	waitFor(c, func() bool { return w.Resyncs() == 1 })
+	c.Assert(w.Index(), Equals, uint64(20))
+	c.Assert(w.Values(), DeepEquals, map[string][]byte{"/longhorn/volumes/vol3/base": []byte("3")})
+
+	// and it keeps watching from there
+	b.events <- &WatchEvent{Key: "/longhorn/volumes/vol3/base", Value: []byte("4"), Index: 21}
+	waitFor(c, func() bool { return w.Index() == 21 })
+	value, ok := w.Get("/longhorn/volumes/vol3/base")
+	c.Assert(ok, Equals, true)
+	c.Assert(string(value), Equals, "4")
+
+	close(stopCh)
+	<-done
+	b.Lock()
+	defer b.Unlock()
+	c.Assert(b.snapshots, Equals, 2)
+	c.Assert(b.watches, DeepEquals, []uint64{10, 20})
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -10,10 +16,13 @@ import (
 	"github.com/rancher/longhorn-manager/api"
 	"github.com/rancher/longhorn-manager/backups"
 	"github.com/rancher/longhorn-manager/controller"
+	"github.com/rancher/longhorn-manager/kvstore"
 	"github.com/rancher/longhorn-manager/manager"
 	"github.com/rancher/longhorn-manager/orch"
 	"github.com/rancher/longhorn-manager/orch/docker"
+	"github.com/rancher/longhorn-manager/scheduler"
 	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
 	"github.com/rancher/longhorn-manager/util/daemon"
 	"github.com/rancher/longhorn-manager/util/server"
 )
@@ -27,6 +36,12 @@ var VERSION = "0.1.0"
 func main() {
 	logrus.SetFormatter(&logrus.TextFormatter{ForceColors: true})
 
+	if err := newApp().Run(os.Args); err != nil {
+		logrus.Fatalf("Critical error: %v", err)
+	}
+}
+
+func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Version = VERSION
 	app.Usage = "Rancher Longhorn storage driver/orchestration"
@@ -60,16 +75,316 @@ func main() {
 			Usage: "the prefix using with etcd server",
 			Value: "/longhorn",
 		},
+		cli.StringFlag{
+			Name:  "etcd-cert",
+			Usage: "client certificate `file` in PEM presented to the etcd servers over https, with --etcd-key and --etcd-cacert",
+		},
+		cli.StringFlag{
+			Name:  "etcd-key",
+			Usage: "`file` of the key of --etcd-cert in PEM",
+		},
+		cli.StringFlag{
+			Name:  "etcd-cacert",
+			Usage: "CA certificate `file` in PEM the etcd servers are verified against",
+		},
+		cli.StringFlag{
+			Name:  "etcd-username",
+			Usage: "user authenticating to the etcd servers with authentication enabled, with --etcd-password",
+		},
+		cli.StringFlag{
+			Name:   "etcd-password",
+			Usage:  "password of --etcd-username, better given in the environment to keep it out of the process list",
+			EnvVar: docker.ETCDPasswordEnv,
+		},
+		cli.BoolFlag{
+			Name:  "etcd-read-cache",
+			Usage: "serve the volumes, hosts and settings from a copy in memory kept up to date by watching etcd, flagged stale, while etcd is unreachable. The writes still fail",
+		},
+		cli.Float64Flag{
+			Name:  "etcd-write-rate",
+			Usage: "maximum writes of the volumes, hosts and settings to etcd a second once the burst is spent, 0 for unlimited. The reads are never throttled",
+		},
+		cli.IntFlag{
+			Name:  "etcd-write-burst",
+			Usage: "number of writes to etcd let through at once before --etcd-write-rate applies",
+			Value: 100,
+		},
 		cli.StringFlag{
 			Name:  "docker-network",
 			Usage: "use specified docker network, can be omitted for auto detection",
 		},
+		cli.StringFlag{
+			Name:  "instance-log-driver",
+			Usage: "log driver of the controller and replica containers, e.g. `syslog`, Docker's default if omitted",
+		},
+		cli.StringSliceFlag{
+			Name:  "instance-log-opts",
+			Usage: "option of the instance log driver as `key=value`, can be repeated",
+		},
+		cli.BoolFlag{
+			Name:  "replica-dns-alias",
+			Usage: "address new replicas by a network alias derived from their name instead of their IP, if the docker network has embedded DNS",
+		},
+		cli.StringSliceFlag{
+			Name:  "replica-block-device",
+			Usage: "block device of the host, e.g. a partition or an LVM logical volume, the replicas of the volumes with the block replica backing can use. Can be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "replica-mountpoint",
+			Usage: "`path` of the host which must be a mount, writable and with the space of the volume free before a replica is created, e.g. the filesystem of the Docker volumes. A bind mount is marked by a .longhorn-mount file at its root. Can be repeated",
+		},
+		cli.BoolFlag{
+			Name:  "enable-raw-editing",
+			Usage: "allow writing the raw records of the volumes in etcd through the admin API, for break-glass repairs",
+		},
+		cli.IntFlag{
+			Name:  "max-concurrent-provisioning",
+			Usage: "maximum number of volumes being provisioned at the same time, 0 for unlimited",
+			Value: 0,
+		},
+		cli.StringFlag{
+			Name:  "shutdown-drain-timeout",
+			Usage: "how long the API requests in flight may take to finish on exit, after the host is reported not ready, e.g. `30s`",
+			Value: "30s",
+		},
+		cli.StringFlag{
+			Name:  "evacuate-on-exit",
+			Usage: "migrate everything off the host and deregister it before exiting on a signal, for at most the given time, e.g. `30m`. 0 to exit right away",
+			Value: "0",
+		},
+		cli.StringFlag{
+			Name:  "event-flush-interval",
+			Usage: "how often the buffered volume events are written, e.g. `2s`",
+			Value: manager.EventFlushInterval.String(),
+		},
+		cli.IntFlag{
+			Name:  "event-batch-size",
+			Usage: "number of buffered volume events written without waiting for the flush interval",
+			Value: manager.EventBatchSize,
+		},
+		cli.IntFlag{
+			Name:  "event-queue-size",
+			Usage: "maximum number of buffered volume events, the lowest severity ones are dropped beyond it",
+			Value: manager.EventQueueSize,
+		},
+		cli.IntFlag{
+			Name:  "volume-history-limit",
+			Usage: "number of the latest state transitions kept by volume",
+			Value: kvstore.VolumeHistoryLimit,
+		},
+		cli.StringFlag{
+			Name:  "replica-plan-reservation",
+			Usage: "how long the replicas planned on create of a volume keep its size reserved on the hosts if it's not attached, e.g. `24h`",
+			Value: manager.ReplicaPlanReservation.String(),
+		},
+		cli.StringFlag{
+			Name:  "replica-rebuild-timeout",
+			Usage: "maximum time of adding a replica to a controller, including rebuilding its data, e.g. `24h`",
+			Value: controller.AddReplicaTimeout.String(),
+		},
+		cli.StringFlag{
+			Name:  "rebuild-stall-timeout",
+			Usage: "how long a rebuild may go without progress before it's torn down as stuck, e.g. `30m`, 0 to never",
+			Value: manager.RebuildStallTimeout.String(),
+		},
+		cli.IntFlag{
+			Name:  "rebuild-max-retries",
+			Usage: "number of stuck or cancelled rebuilds a volume retries on other hosts before it's left degraded",
+			Value: manager.RebuildMaxRetries,
+		},
+		cli.IntFlag{
+			Name:  "max-concurrent-schedules",
+			Usage: "maximum number of instances being scheduled at the same time, the others are queued, 0 for unlimited",
+			Value: 0,
+		},
+		cli.StringSliceFlag{
+			Name:  "listen",
+			Usage: fmt.Sprintf("address to serve the API at, can be repeated, e.g. `0.0.0.0:%v`. The first port is advertised (default: \":%v\")", api.DefaultPort, api.DefaultPort),
+		},
+		cli.StringSliceFlag{
+			Name:  "listen-unix-socket",
+			Usage: fmt.Sprintf("unix socket to serve the API at for the local clients, can be repeated, e.g. `%v`. \"none\" to disable (default: %q)", sockFile, sockFile),
+		},
+		cli.BoolFlag{
+			Name:  "internal-tls",
+			Usage: "serve and reach the other managers over TLS at the --listen addresses, with certificates from a cluster CA generated in etcd. All the managers must have it",
+		},
+		cli.StringFlag{
+			Name:  "cluster-ca-key-file",
+			Usage: "`file` of the secret sealing the key of the cluster CA in etcd, the same on all the managers. Required by --internal-tls",
+		},
+		cli.BoolFlag{
+			Name:  "api-auth",
+			Usage: "require a bearer token with a role allowing the endpoint on the API, except over the unix socket and between the managers. Requires --internal-tls",
+		},
+		cli.StringFlag{
+			Name:  "api-tokens-file",
+			Usage: "file of API tokens, one `token,role,name` per line with role readonly, operator or admin, in addition to the tokens created through the API",
+		},
+		cli.StringFlag{
+			Name:  "bootstrap-file",
+			Usage: "YAML or JSON `file` of settings and volumes applied on start, the missing volumes are created and the existing ones left as they are",
+		},
+		cli.StringFlag{
+			Name:  "metadata-export-interval",
+			Usage: "how often a snapshot of the volumes, hosts and settings is exported to a vfs backup target for recover-metadata, e.g. `1h`. 0 to never",
+			Value: manager.MetadataExportInterval.String(),
+		},
+		cli.StringFlag{
+			Name:  "metadata-key-file",
+			Usage: "`file` of the secret encrypting the exported metadata snapshots, they're only compressed if omitted",
+		},
+		cli.StringFlag{
+			Name:  "slow-operation-threshold",
+			Usage: "duration beyond which an operation of the manager is logged and listed with the trace of its steps, e.g. `30s`. 0 to never",
+			Value: manager.SlowOperationThreshold.String(),
+		},
+		cli.StringFlag{
+			Name:  "migration-pause-budget",
+			Usage: "longest pause of the IO of a volume while its controller is migrated to another host, e.g. `10s`. The migration is rolled back beyond it",
+			Value: manager.MigrationPauseBudget.String(),
+		},
+		cli.StringFlag{
+			Name:  "ca-transition-window",
+			Usage: "how long the previous cluster CA is still trusted after a rotation, e.g. `48h`",
+			Value: manager.CATransitionWindow.String(),
+		},
+		cli.StringFlag{
+			Name:  "advertise-address",
+			Usage: "address of the API recorded for the host and used by the other hosts, e.g. `10.0.0.1:9500`. The detected IP with the first --listen port if empty",
+		},
+		cli.IntFlag{
+			Name:  "host-conflict-threshold",
+			Usage: "number of heartbeats of other machines with the same host UUID within --host-conflict-window marking the host conflicted",
+			Value: docker.HostConflictThreshold,
+		},
+		cli.StringFlag{
+			Name:  "host-conflict-window",
+			Usage: "window counting the heartbeats of other machines with the same host UUID, e.g. `5m`",
+			Value: docker.HostConflictWindow.String(),
+		},
+		cli.StringFlag{
+			Name:  "host-uuid-collision",
+			Usage: fmt.Sprintf("what to do at start when the host UUID is used by another machine, e.g. a cloned VM image, one of %v", docker.HostUUIDCollisions),
+			Value: docker.HostUUIDCollision,
+		},
+		cli.StringSliceFlag{
+			Name:  "schedule-timeout",
+			Usage: fmt.Sprintf("deadline of processing a schedule item before it's abandoned, `action=duration` for an action such as create-replica=5m, or a duration for the other actions, can be repeated (default: %v)", scheduler.DefaultProcessTimeout),
+		},
+		cli.StringFlag{
+			Name:  "schedule-quarantine-period",
+			Usage: "how long the instance of an abandoned schedule item, and its host for a create, are refused new items, e.g. `10m`",
+			Value: scheduler.QuarantinePeriod.String(),
+		},
+		cli.StringFlag{
+			Name:  "host-recovery-cooldown",
+			Usage: "how long a host back from an outage takes no new replicas, e.g. `5m`, 0 to place them right away",
+			Value: scheduler.HostRecoveryCooldown.String(),
+		},
+		cli.StringFlag{
+			Name:  orch.WaitDeviceTimeoutParam,
+			Usage: "timeout waiting for the volume device to show up, e.g. `30s`",
+			Value: orch.DefaultTimeouts.WaitDevice.String(),
+		},
+		cli.StringFlag{
+			Name:  orch.WaitAPITimeoutParam,
+			Usage: "timeout waiting for the controller API to be ready, e.g. `30s`",
+			Value: orch.DefaultTimeouts.WaitAPI.String(),
+		},
+		cli.StringFlag{
+			Name:  orch.ContainerStopTimeoutParam,
+			Usage: "timeout waiting for an instance container to stop, e.g. `1m`",
+			Value: orch.DefaultTimeouts.ContainerStop.String(),
+		},
 	}
 
-	if err := app.Run(os.Args); err != nil {
-		logrus.Fatalf("Critical error: %v", err)
+	app.Commands = []cli.Command{
+		{
+			Name:      "recover-metadata",
+			Usage:     "restore the latest metadata snapshot exported to the backup target into an empty etcd prefix, the volumes detached and the hosts unknown until they register again",
+			ArgsUsage: " ",
+			Action:    RecoverMetadata,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "from-target",
+					Usage: "backup target the metadata snapshots were exported to, e.g. `vfs:///var/lib/longhorn/backups`",
+				},
+				cli.StringSliceFlag{
+					Name:  "etcd-servers",
+					Usage: "etcd server ip and port, in format `http://etcd1:2379,http://etcd2:2379`",
+				},
+				cli.StringFlag{
+					Name:  "etcd-prefix",
+					Usage: "the prefix using with etcd server, must be empty",
+					Value: "/longhorn",
+				},
+				cli.StringFlag{
+					Name:  "etcd-cert",
+					Usage: "client certificate `file` in PEM presented to the etcd servers over https, with --etcd-key and --etcd-cacert",
+				},
+				cli.StringFlag{
+					Name:  "etcd-key",
+					Usage: "`file` of the key of --etcd-cert in PEM",
+				},
+				cli.StringFlag{
+					Name:  "etcd-cacert",
+					Usage: "CA certificate `file` in PEM the etcd servers are verified against",
+				},
+				cli.StringFlag{
+					Name:  "etcd-username",
+					Usage: "user authenticating to the etcd servers with authentication enabled, with --etcd-password",
+				},
+				cli.StringFlag{
+					Name:   "etcd-password",
+					Usage:  "password of --etcd-username, better given in the environment to keep it out of the process list",
+					EnvVar: docker.ETCDPasswordEnv,
+				},
+				cli.StringFlag{
+					Name:  "metadata-key-file",
+					Usage: "`file` of the secret the metadata snapshots were encrypted with",
+				},
+			},
+		},
 	}
+	return app
+}
 
+// RecoverMetadata restores the metadata lost with etcd from the backup
+// target, before the managers are started again
+func RecoverMetadata(c *cli.Context) error {
+	target := c.String("from-target")
+	if target == "" {
+		return fmt.Errorf("Must specify --from-target")
+	}
+	var key []byte
+	if file := c.String("metadata-key-file"); file != "" {
+		var err error
+		if key, err = backups.LoadMetadataKey(file); err != nil {
+			return err
+		}
+	}
+	store, err := backups.NewMetadataStore(target)
+	if err != nil {
+		return err
+	}
+	name, data, err := store.Latest()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("no metadata snapshot found on backup target %v", target)
+	}
+	snapshot, err := backups.DecodeMetadata(data, key)
+	if err != nil {
+		return err
+	}
+	if err := docker.RecoverMetadata(c, snapshot, "recover-metadata "+name); err != nil {
+		return err
+	}
+	logrus.Infof("Recovered %v volumes and %v hosts from metadata snapshot %v, created %v by host %v",
+		len(snapshot.Volumes), len(snapshot.Hosts), name, snapshot.Created, snapshot.HostID)
+	return nil
 }
 
 func RunManager(c *cli.Context) error {
@@ -86,6 +401,47 @@ func RunManager(c *cli.Context) error {
 		return fmt.Errorf("Must specify %v", orch.EngineImageParam)
 	}
 
+	// without internal TLS the managers cannot be told apart from the
+	// other clients on the internal endpoints
+	if c.Bool("api-auth") && !c.Bool("internal-tls") {
+		return fmt.Errorf("--api-auth requires --internal-tls")
+	}
+	if c.Bool("internal-tls") && c.String("cluster-ca-key-file") == "" {
+		return fmt.Errorf("--internal-tls requires --cluster-ca-key-file")
+	}
+
+	if c.Int("max-concurrent-schedules") < 0 {
+		return fmt.Errorf("invalid value %v for --max-concurrent-schedules, expecting a number such as 4", c.Int("max-concurrent-schedules"))
+	}
+	scheduler.MaxConcurrentSchedules = c.Int("max-concurrent-schedules")
+	if err := parseScheduleTimeouts(c); err != nil {
+		return err
+	}
+
+	if c.Int("host-conflict-threshold") < 1 {
+		return fmt.Errorf("invalid value %v for --host-conflict-threshold, expecting a number such as 3", c.Int("host-conflict-threshold"))
+	}
+	docker.HostConflictThreshold = c.Int("host-conflict-threshold")
+	window, err := time.ParseDuration(c.String("host-conflict-window"))
+	if err != nil || window <= 0 {
+		return fmt.Errorf("invalid value %v for --host-conflict-window, expecting a duration such as \"5m\"", c.String("host-conflict-window"))
+	}
+	docker.HostConflictWindow = window
+	collision := c.String("host-uuid-collision")
+	valid := false
+	for _, v := range docker.HostUUIDCollisions {
+		valid = valid || v == collision
+	}
+	if !valid {
+		return fmt.Errorf("invalid value %v for --host-uuid-collision, expecting one of %v", collision, docker.HostUUIDCollisions)
+	}
+	docker.HostUUIDCollision = collision
+
+	listeners, err := newListeners(c)
+	if err != nil {
+		return err
+	}
+
 	orcName := c.String("orchestrator")
 	if orcName == "docker" {
 		orc, err = docker.New(c)
@@ -96,17 +452,242 @@ func RunManager(c *cli.Context) error {
 		return err
 	}
 
+	if c.Int("max-concurrent-provisioning") < 0 {
+		return fmt.Errorf("invalid value %v for --max-concurrent-provisioning, expecting a number such as 4", c.Int("max-concurrent-provisioning"))
+	}
+	manager.MaxConcurrentProvisioning = c.Int("max-concurrent-provisioning")
+	manager.RawEditingEnabled = c.Bool("enable-raw-editing")
+	if err := parseEventBuffering(c); err != nil {
+		return err
+	}
+	evacuateTimeout, err := time.ParseDuration(c.String("evacuate-on-exit"))
+	if err != nil || evacuateTimeout < 0 {
+		return fmt.Errorf("invalid value %v for --evacuate-on-exit, expecting a duration such as \"30m\"", c.String("evacuate-on-exit"))
+	}
+	drainTimeout, err := time.ParseDuration(c.String("shutdown-drain-timeout"))
+	if err != nil || drainTimeout < 0 {
+		return fmt.Errorf("invalid value %v for --shutdown-drain-timeout, expecting a duration such as \"30s\"", c.String("shutdown-drain-timeout"))
+	}
+	reservation, err := time.ParseDuration(c.String("replica-plan-reservation"))
+	if err != nil || reservation <= 0 {
+		return fmt.Errorf("invalid value %v for --replica-plan-reservation, expecting a duration such as \"24h\"", c.String("replica-plan-reservation"))
+	}
+	manager.ReplicaPlanReservation = reservation
+	rebuildTimeout, err := time.ParseDuration(c.String("replica-rebuild-timeout"))
+	if err != nil || rebuildTimeout <= 0 {
+		return fmt.Errorf("invalid value %v for --replica-rebuild-timeout, expecting a duration such as \"24h\"", c.String("replica-rebuild-timeout"))
+	}
+	controller.AddReplicaTimeout = rebuildTimeout
+	stallTimeout, err := time.ParseDuration(c.String("rebuild-stall-timeout"))
+	if err != nil || stallTimeout < 0 {
+		return fmt.Errorf("invalid value %v for --rebuild-stall-timeout, expecting a duration such as \"30m\"", c.String("rebuild-stall-timeout"))
+	}
+	manager.RebuildStallTimeout = stallTimeout
+	if c.Int("rebuild-max-retries") < 0 {
+		return fmt.Errorf("invalid value %v for --rebuild-max-retries, expecting a number such as 2", c.Int("rebuild-max-retries"))
+	}
+	manager.RebuildMaxRetries = c.Int("rebuild-max-retries")
+	transitionWindow, err := time.ParseDuration(c.String("ca-transition-window"))
+	if err != nil || transitionWindow <= 0 {
+		return fmt.Errorf("invalid value %v for --ca-transition-window, expecting a duration such as \"48h\"", c.String("ca-transition-window"))
+	}
+	manager.CATransitionWindow = transitionWindow
+	exportInterval, err := time.ParseDuration(c.String("metadata-export-interval"))
+	if err != nil || exportInterval < 0 {
+		return fmt.Errorf("invalid value %v for --metadata-export-interval, expecting a duration such as \"1h\"", c.String("metadata-export-interval"))
+	}
+	manager.MetadataExportInterval = exportInterval
+	if file := c.String("metadata-key-file"); file != "" {
+		if manager.MetadataKey, err = backups.LoadMetadataKey(file); err != nil {
+			return err
+		}
+	}
+	slowThreshold, err := time.ParseDuration(c.String("slow-operation-threshold"))
+	if err != nil || slowThreshold < 0 {
+		return fmt.Errorf("invalid value %v for --slow-operation-threshold, expecting a duration such as \"30s\"", c.String("slow-operation-threshold"))
+	}
+	manager.SlowOperationThreshold = slowThreshold
+	pauseBudget, err := time.ParseDuration(c.String("migration-pause-budget"))
+	if err != nil || pauseBudget <= 0 {
+		return fmt.Errorf("invalid value %v for --migration-pause-budget, expecting a duration such as \"10s\"", c.String("migration-pause-budget"))
+	}
+	manager.MigrationPauseBudget = pauseBudget
+	manager.InternalTLSEnabled = c.Bool("internal-tls")
+	if file := c.String("cluster-ca-key-file"); file != "" {
+		if manager.ClusterCAKey, err = util.LoadSecretKey(file); err != nil {
+			return err
+		}
+	}
+	manager.APITokensFile = c.String("api-tokens-file")
+	manager.BootstrapFile = c.String("bootstrap-file")
+	var bootstrap *types.Bootstrap
+	if manager.BootstrapFile != "" {
+		if bootstrap, err = api.LoadBootstrapFile(manager.BootstrapFile); err != nil {
+			return err
+		}
+	}
+	api.AuthEnabled = c.Bool("api-auth")
 	man := manager.New(orc, manager.Monitor(controller.Get), controller.Get, backups.New)
+	if manager.InternalTLSEnabled {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = man.ClientTLSConfig()
+		util.ManagerScheme, util.ManagerTransport = "https", transport
+		for _, l := range listeners {
+			if s, ok := l.(*server.TCPServer); ok {
+				s.SetTLSConfig(man.ServerTLSConfig())
+			}
+		}
+	}
 	if err := man.Start(); err != nil {
 		return err
 	}
+	if bootstrap != nil {
+		if _, err := man.Bootstrap(bootstrap); err != nil {
+			logrus.Errorf("%v", err)
+		}
+	}
 
 	proxy := api.Proxy()
 
 	s := api.NewServer(man, orc, proxy)
 
-	go server.NewUnixServer(sockFile).Serve(api.Handler(s))
-	go server.NewTCPServer(fmt.Sprintf(":%v", api.DefaultPort)).Serve(api.Handler(s))
+	handler := api.Handler(s)
+	for _, l := range listeners {
+		go l.Serve(handler)
+	}
+
+	err = daemon.WaitForExit()
+	// not ready from now on, but still serving the other managers while
+	// evacuating
+	s.Drain()
+	if evacuateTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), evacuateTimeout)
+		if err := man.EvacuateCurrentHost(ctx); err != nil {
+			logrus.Errorf("%v", err)
+		}
+		cancel()
+	}
+	shutdownListeners(listeners, drainTimeout)
+	man.Shutdown()
+	return err
+}
+
+type listener interface {
+	Serve(handler http.Handler)
+	Shutdown(ctx context.Context) error
+}
+
+// shutdownListeners stops accepting connections, and lets the requests in
+// flight finish within timeout
+func shutdownListeners(listeners []listener, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	wg := &sync.WaitGroup{}
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l listener) {
+			defer wg.Done()
+			if err := l.Shutdown(ctx); err != nil {
+				logrus.Warnf("%v", err)
+			}
+		}(l)
+	}
+	wg.Wait()
+}
+
+// parseScheduleTimeouts sets the deadlines of the schedule actions, the
+// quarantine after an abandoned item and the cooldown of a recovered host
+func parseScheduleTimeouts(c *cli.Context) error {
+	timeouts := map[types.ScheduleAction]time.Duration{}
+	for _, value := range c.StringSlice("schedule-timeout") {
+		action, duration := types.ScheduleAction(""), value
+		if parts := strings.SplitN(value, "=", 2); len(parts) == 2 {
+			action, duration = types.ScheduleAction(parts[0]), parts[1]
+			if !action.Valid() {
+				return fmt.Errorf("invalid action %v for --schedule-timeout, expecting one of %v", action, types.ScheduleActions)
+			}
+		}
+		timeout, err := time.ParseDuration(duration)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid value %v for --schedule-timeout, expecting a duration such as \"5m\" or \"create-replica=5m\"", value)
+		}
+		if action == "" {
+			scheduler.DefaultProcessTimeout = timeout
+		} else {
+			timeouts[action] = timeout
+		}
+	}
+	scheduler.ProcessTimeouts = timeouts
 
-	return daemon.WaitForExit()
+	period, err := time.ParseDuration(c.String("schedule-quarantine-period"))
+	if err != nil || period < 0 {
+		return fmt.Errorf("invalid value %v for --schedule-quarantine-period, expecting a duration such as \"10m\"", c.String("schedule-quarantine-period"))
+	}
+	scheduler.QuarantinePeriod = period
+
+	cooldown, err := time.ParseDuration(c.String("host-recovery-cooldown"))
+	if err != nil || cooldown < 0 {
+		return fmt.Errorf("invalid value %v for --host-recovery-cooldown, expecting a duration such as \"5m\"", c.String("host-recovery-cooldown"))
+	}
+	scheduler.HostRecoveryCooldown = cooldown
+	return nil
+}
+
+// parseEventBuffering sets how the volume events are buffered before being
+// written
+func parseEventBuffering(c *cli.Context) error {
+	interval, err := time.ParseDuration(c.String("event-flush-interval"))
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid value %v for --event-flush-interval, expecting a duration such as \"2s\"", c.String("event-flush-interval"))
+	}
+	if c.Int("event-batch-size") < 1 {
+		return fmt.Errorf("invalid value %v for --event-batch-size, expecting a number such as 100", c.Int("event-batch-size"))
+	}
+	if c.Int("event-queue-size") < c.Int("event-batch-size") {
+		return fmt.Errorf("invalid value %v for --event-queue-size, expecting a number no less than --event-batch-size", c.Int("event-queue-size"))
+	}
+	manager.EventFlushInterval = interval
+	manager.EventBatchSize = c.Int("event-batch-size")
+	manager.EventQueueSize = c.Int("event-queue-size")
+	if c.Int("volume-history-limit") < 1 {
+		return fmt.Errorf("invalid value %v for --volume-history-limit, expecting a number such as 100", c.Int("volume-history-limit"))
+	}
+	kvstore.VolumeHistoryLimit = c.Int("volume-history-limit")
+	return nil
+}
+
+// newListeners binds all the --listen and --listen-unix-socket addresses,
+// they share the API handler
+func newListeners(c *cli.Context) ([]listener, error) {
+	addrs := c.StringSlice("listen")
+	if len(addrs) == 0 {
+		addrs = []string{fmt.Sprintf(":%v", api.DefaultPort)}
+	}
+	sockFiles := c.StringSlice("listen-unix-socket")
+	if len(sockFiles) == 0 {
+		sockFiles = []string{sockFile}
+	}
+
+	listeners := []listener{}
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid value %v for --listen, expecting an address such as \":%v\"", addr, api.DefaultPort)
+		}
+		s := server.NewTCPServer(addr)
+		if err := s.Listen(); err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, s)
+	}
+	for _, f := range sockFiles {
+		if f == "none" {
+			continue
+		}
+		s := server.NewUnixServer(f)
+		if err := s.Listen(); err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, s)
+	}
+	return listeners, nil
 }
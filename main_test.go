@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli"
+
+	"github.com/rancher/longhorn-manager/orch"
+)
+
+// flagExamples has a valid value for every registered flag, or "" for boolean ones
+var flagExamples = map[string]string{
+	"debug":                        "",
+	"orchestrator":                 "docker",
+	orch.EngineImageParam:          "rancher/longhorn",
+	"etcd-servers":                 "http://etcd1:2379",
+	"etcd-prefix":                  "/longhorn",
+	"etcd-cert":                    "/etc/longhorn/etcd-client.pem",
+	"etcd-key":                     "/etc/longhorn/etcd-client-key.pem",
+	"etcd-cacert":                  "/etc/longhorn/etcd-ca.pem",
+	"etcd-username":                "longhorn",
+	"etcd-password":                "secret",
+	"etcd-read-cache":              "",
+	"docker-network":               "longhorn-net",
+	"replica-dns-alias":            "",
+	"replica-block-device":         "/dev/vg0/longhorn-1",
+	"replica-mountpoint":           "/var/lib/docker",
+	"enable-raw-editing":           "",
+	"instance-log-driver":          "syslog",
+	"instance-log-opts":            "tag=longhorn",
+	"max-concurrent-provisioning":  "4",
+	"max-concurrent-schedules":     "4",
+	"evacuate-on-exit":             "30m",
+	"shutdown-drain-timeout":       "1m",
+	"event-flush-interval":         "5s",
+	"event-batch-size":             "50",
+	"event-queue-size":             "500",
+	"volume-history-limit":         "50",
+	"replica-plan-reservation":     "12h",
+	"replica-rebuild-timeout":      "48h",
+	"rebuild-stall-timeout":        "1h",
+	"rebuild-max-retries":          "3",
+	"listen":                       "0.0.0.0:9600",
+	"listen-unix-socket":           "/var/run/longhorn/manager.sock",
+	"advertise-address":            "10.0.0.1:9600",
+	"internal-tls":                 "",
+	"cluster-ca-key-file":          "/etc/longhorn/cluster-ca.key",
+	"api-auth":                     "",
+	"api-tokens-file":              "/etc/longhorn/tokens",
+	"bootstrap-file":               "/etc/longhorn/bootstrap.yaml",
+	"ca-transition-window":         "24h",
+	"metadata-export-interval":     "30m",
+	"metadata-key-file":            "/etc/longhorn/metadata.key",
+	"slow-operation-threshold":     "1m",
+	"etcd-write-rate":              "50",
+	"etcd-write-burst":             "200",
+	"migration-pause-budget":       "5s",
+	"host-conflict-threshold":      "5",
+	"host-conflict-window":         "10m",
+	"host-uuid-collision":          "regenerate",
+	"schedule-timeout":             "create-replica=5m",
+	"schedule-quarantine-period":   "5m",
+	"host-recovery-cooldown":       "10m",
+	orch.WaitDeviceTimeoutParam:    "45s",
+	orch.WaitAPITimeoutParam:       "1m",
+	orch.ContainerStopTimeoutParam: "90s",
+}
+
+var durationFlags = []string{
+	orch.WaitDeviceTimeoutParam,
+	orch.WaitAPITimeoutParam,
+	orch.ContainerStopTimeoutParam,
+}
+
+func runWithArgs(args ...string) (*orch.Timeouts, error) {
+	var timeouts *orch.Timeouts
+
+	app := newApp()
+	app.Action = func(c *cli.Context) error {
+		var err error
+		timeouts, err = orch.ParseTimeouts(c)
+		return err
+	}
+	err := app.Run(append([]string{"longhorn-manager"}, args...))
+	return timeouts, err
+}
+
+func TestFlags(t *testing.T) {
+	assert := require.New(t)
+
+	// cli exits the process on errors from the action
+	osExiter, errWriter := cli.OsExiter, cli.ErrWriter
+	defer func() {
+		cli.OsExiter, cli.ErrWriter = osExiter, errWriter
+	}()
+	cli.OsExiter = func(int) {}
+	cli.ErrWriter = ioutil.Discard
+
+	for _, flag := range newApp().Flags {
+		name := strings.Split(flag.GetName(), ",")[0]
+		value, ok := flagExamples[name]
+		assert.True(ok, "missing example for flag --%v", name)
+
+		args := []string{"--" + name}
+		if value != "" {
+			args = append(args, value)
+		}
+		_, err := runWithArgs(args...)
+		assert.Nil(err, "flag --%v", name)
+	}
+
+	timeouts, err := runWithArgs()
+	assert.Nil(err)
+	assert.Equal(orch.DefaultTimeouts, *timeouts)
+
+	timeouts, err = runWithArgs(
+		"--"+orch.WaitDeviceTimeoutParam, flagExamples[orch.WaitDeviceTimeoutParam],
+		"--"+orch.WaitAPITimeoutParam, flagExamples[orch.WaitAPITimeoutParam],
+		"--"+orch.ContainerStopTimeoutParam, flagExamples[orch.ContainerStopTimeoutParam])
+	assert.Nil(err)
+	assert.Equal(45*time.Second, timeouts.WaitDevice)
+	assert.Equal(time.Minute, timeouts.WaitAPI)
+	assert.Equal(90*time.Second, timeouts.ContainerStop)
+
+	for _, name := range durationFlags {
+		// deprecated bare integer in seconds
+		timeouts, err = runWithArgs("--"+name, "120")
+		assert.Nil(err)
+		assert.Contains([]time.Duration{timeouts.WaitDevice, timeouts.WaitAPI, timeouts.ContainerStop}, 2*time.Minute)
+
+		_, err = runWithArgs("--"+name, "2 minutes")
+		assert.NotNil(err)
+		assert.Contains(err.Error(), "--"+name)
+	}
+}
+
+func TestRequiredTLSFlags(t *testing.T) {
+	assert := require.New(t)
+
+	osExiter, errWriter := cli.OsExiter, cli.ErrWriter
+	defer func() {
+		cli.OsExiter, cli.ErrWriter = osExiter, errWriter
+	}()
+	cli.OsExiter = func(int) {}
+	cli.ErrWriter = ioutil.Discard
+
+	err := newApp().Run([]string{"longhorn-manager", "--" + orch.EngineImageParam, flagExamples[orch.EngineImageParam], "--api-auth"})
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "--api-auth requires --internal-tls")
+
+	err = newApp().Run([]string{"longhorn-manager", "--" + orch.EngineImageParam, flagExamples[orch.EngineImageParam], "--internal-tls"})
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "--internal-tls requires --cluster-ca-key-file")
+}
@@ -0,0 +1,239 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// listHostInstances asks the manager at the address for the containers
+	// on its host
+	listHostInstances = func(address string) ([]*types.LocalInstance, error) {
+		client := http.Client{Timeout: 2 * FenceProbeTimeout, Transport: util.ManagerTransport}
+		resp, err := client.Get(util.ManagerURL(address, "/v1/localinstances"))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("unexpected status %v", resp.Status)
+		}
+		var output struct {
+			Instances []*types.LocalInstance `json:"instances"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+			return nil, err
+		}
+		return output.Instances, nil
+	}
+
+	// listHostAbandoned asks the manager at the address for the schedules
+	// abandoned by its scheduler
+	listHostAbandoned = func(address string) ([]*types.AbandonedSchedule, error) {
+		client := http.Client{Timeout: 2 * FenceProbeTimeout, Transport: util.ManagerTransport}
+		resp, err := client.Get(util.ManagerURL(address, "/v1/schedulerstatus"))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("unexpected status %v", resp.Status)
+		}
+		var output struct {
+			Abandoned []*types.AbandonedSchedule `json:"abandoned"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+			return nil, err
+		}
+		return output.Abandoned, nil
+	}
+)
+
+func (man *volumeManager) ListLocalInstances() ([]*types.LocalInstance, error) {
+	return man.orc.ListLocalInstances()
+}
+
+// localAbandoned returns the schedules abandoned on the current host
+func (man *volumeManager) localAbandoned() ([]*types.AbandonedSchedule, error) {
+	scheduler := man.orc.Scheduler()
+	if scheduler == nil {
+		return nil, nil
+	}
+	return scheduler.Status().Abandoned, nil
+}
+
+// AuditConsistency compares the controllers and replicas in the volume
+// metadata with the containers on every host. Nothing is changed.
+func (man *volumeManager) AuditConsistency() (*types.ConsistencyReport, error) {
+	volumes, err := man.orc.ListVolumes()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list volumes")
+	}
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list hosts")
+	}
+	report := &types.ConsistencyReport{
+		Volumes:        len(volumes),
+		CheckedHosts:   []string{},
+		UncheckedHosts: map[string]string{},
+		Issues:         []*types.ConsistencyIssue{},
+	}
+
+	// the containers by host, then by ID
+	live := map[string]map[string]*types.LocalInstance{}
+	abandoned := []*types.AbandonedSchedule{}
+	for id, host := range hosts {
+		var instances []*types.LocalInstance
+		var items []*types.AbandonedSchedule
+		if id == man.orc.GetCurrentHostID() {
+			instances, err = man.orc.ListLocalInstances()
+			if err == nil {
+				items, err = man.localAbandoned()
+			}
+		} else {
+			instances, err = listHostInstances(host.Address)
+			if err == nil {
+				items, err = listHostAbandoned(host.Address)
+			}
+		}
+		if err != nil {
+			report.UncheckedHosts[id] = err.Error()
+			continue
+		}
+		abandoned = append(abandoned, items...)
+		report.CheckedHosts = append(report.CheckedHosts, id)
+		live[id] = map[string]*types.LocalInstance{}
+		for _, instance := range instances {
+			live[id][instance.ID] = instance
+		}
+	}
+	sort.Strings(report.CheckedHosts)
+
+	known := map[string]bool{}
+	for _, volume := range volumes {
+		instances := []*types.InstanceInfo{}
+		if volume.Controller != nil {
+			instances = append(instances, &volume.Controller.InstanceInfo)
+		}
+		for _, replica := range volume.Replicas {
+			instances = append(instances, &replica.InstanceInfo)
+		}
+		for _, instance := range instances {
+			known[instance.ID] = true
+			issue := &types.ConsistencyIssue{
+				VolumeName:   volume.Name,
+				InstanceID:   instance.ID,
+				InstanceName: instance.Name,
+				InstanceType: instance.Type,
+				HostID:       instance.HostID,
+				Writer:       instance.Writer,
+			}
+			if hosts[instance.HostID] == nil {
+				issue.Type = types.ConsistencyIssueUnknownHost
+				issue.Detail = fmt.Sprintf("host %v is not registered", instance.HostID)
+				report.Issues = append(report.Issues, issue)
+				continue
+			}
+			containers, checked := live[instance.HostID]
+			if !checked {
+				continue
+			}
+			container := containers[instance.ID]
+			if container == nil {
+				issue.Type = types.ConsistencyIssueMissingContainer
+				issue.Detail = fmt.Sprintf("container %v not found on host %v", instance.ID, instance.HostID)
+				report.Issues = append(report.Issues, issue)
+				continue
+			}
+			if container.Running != instance.Running {
+				issue.Type = types.ConsistencyIssueStateMismatch
+				issue.Detail = fmt.Sprintf("running is %v in the metadata, %v for the container", instance.Running, container.Running)
+				report.Issues = append(report.Issues, issue)
+			} else if container.VolumeName != volume.Name || (container.Type != types.InstanceTypeNone && container.Type != instance.Type) {
+				issue.Type = types.ConsistencyIssueStateMismatch
+				issue.Detail = fmt.Sprintf("the container is %v %v of volume %v", container.Type, container.Name, container.VolumeName)
+				report.Issues = append(report.Issues, issue)
+			}
+		}
+	}
+
+	for hostID, containers := range live {
+		for id, container := range containers {
+			if known[id] {
+				continue
+			}
+			report.Issues = append(report.Issues, &types.ConsistencyIssue{
+				Type:         types.ConsistencyIssueOrphanContainer,
+				VolumeName:   container.VolumeName,
+				InstanceID:   id,
+				InstanceName: container.Name,
+				InstanceType: container.Type,
+				HostID:       hostID,
+				Detail:       fmt.Sprintf("no metadata for the container, running %v", container.Running),
+			})
+		}
+	}
+	for _, item := range abandoned {
+		report.Issues = append(report.Issues, &types.ConsistencyIssue{
+			Type:         types.ConsistencyIssueAbandonedSchedule,
+			VolumeName:   item.VolumeName,
+			InstanceID:   item.InstanceID,
+			InstanceName: item.InstanceName,
+			InstanceType: item.InstanceType,
+			HostID:       item.HostID,
+			Detail:       fmt.Sprintf("%v abandoned at %v, the outcome is unknown", item.Action, item.Abandoned),
+		})
+	}
+	sort.Slice(report.Issues, func(i, j int) bool {
+		a, b := report.Issues[i], report.Issues[j]
+		if a.VolumeName != b.VolumeName {
+			return a.VolumeName < b.VolumeName
+		}
+		return a.InstanceName < b.InstanceName
+	})
+	return report, nil
+}
+
+// WriterReport counts the volumes, their instances, the hosts and the
+// settings by the version of the manager which last wrote them
+func (man *volumeManager) WriterReport() (*types.WriterReport, error) {
+	report := &types.WriterReport{
+		Versions: map[string]int{},
+		Kinds:    map[string]map[string]int{},
+	}
+	if err := man.orc.ForEachVolume("", func(volume *types.VolumeInfo) error {
+		report.Add("volume", volume.Writer)
+		if volume.Controller != nil {
+			report.Add("controller", volume.Controller.Writer)
+		}
+		for _, replica := range volume.Replicas {
+			report.Add("replica", replica.Writer)
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "unable to list volumes")
+	}
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list hosts")
+	}
+	for _, host := range hosts {
+		report.Add("host", host.Writer)
+	}
+	history, err := man.orc.GetSettingsHistory()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get settings history")
+	}
+	if len(history) > 0 {
+		report.Add("settings", history[len(history)-1].Writer)
+	}
+	return report, nil
+}
@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestAuditConsistency(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 3})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	_, err = man.Create(&types.VolumeInfo{Name: "vol2", Size: 4096, NumberOfReplicas: 1})
+	assert.Nil(err)
+	orc.volumes["vol2"].Replicas["lost"] = &types.ReplicaInfo{
+		InstanceInfo: types.InstanceInfo{ID: "lost", Name: "lost", Type: types.InstanceTypeReplica, HostID: "host-9"},
+	}
+
+	// the containers as the metadata has them
+	live := map[string][]*types.LocalInstance{}
+	vol := orc.volumes["vol"]
+	for _, instance := range append([]*types.InstanceInfo{&vol.Controller.InstanceInfo}, replicaInstances(vol)...) {
+		live[instance.HostID] = append(live[instance.HostID], &types.LocalInstance{
+			ID:         instance.ID,
+			Name:       instance.Name,
+			Type:       instance.Type,
+			VolumeName: "vol",
+			Running:    instance.Running,
+		})
+	}
+	// the replica of vol2 on host-1 is missing, and a container of a deleted
+	// volume is left
+	orc.localInstances = append(live["host-1"], &types.LocalInstance{
+		ID: "orphan-id", Name: "deleted-replica", Type: types.InstanceTypeReplica, VolumeName: "deleted", Running: true,
+	})
+	// the replica on host-2 is stopped
+	live["host-2"][0].Running = false
+	defer func(list func(string) ([]*types.LocalInstance, error)) { listHostInstances = list }(listHostInstances)
+	listHostInstances = func(address string) ([]*types.LocalInstance, error) {
+		switch address {
+		case "host-2:9500":
+			return live["host-2"], nil
+		case "host-3:9500":
+			return nil, errors.Errorf("connection refused")
+		}
+		return nil, errors.Errorf("unexpected address %v", address)
+	}
+	// a stop on host-2 was abandoned
+	defer func(list func(string) ([]*types.AbandonedSchedule, error)) { listHostAbandoned = list }(listHostAbandoned)
+	listHostAbandoned = func(address string) ([]*types.AbandonedSchedule, error) {
+		if address != "host-2:9500" {
+			return nil, errors.Errorf("unexpected address %v", address)
+		}
+		return []*types.AbandonedSchedule{{
+			Action:       types.ScheduleActionStopInstance,
+			InstanceID:   live["host-2"][0].ID,
+			InstanceName: live["host-2"][0].Name,
+			InstanceType: types.InstanceTypeReplica,
+			VolumeName:   "vol",
+			HostID:       "host-2",
+			Abandoned:    "2017-01-01T00:00:00Z",
+		}}, nil
+	}
+
+	before := map[string]*types.VolumeInfo{}
+	for name := range orc.volumes {
+		before[name] = copyVolume(orc.volumes[name])
+	}
+	report, err := man.AuditConsistency()
+	assert.Nil(err)
+	for name := range orc.volumes {
+		assert.Equal(before[name], copyVolume(orc.volumes[name]))
+	}
+
+	assert.Equal(2, report.Volumes)
+	assert.Equal([]string{"host-1", "host-2"}, report.CheckedHosts)
+	assert.Len(report.UncheckedHosts, 1)
+	assert.Contains(report.UncheckedHosts["host-3"], "connection refused")
+
+	issues := map[types.ConsistencyIssueType][]*types.ConsistencyIssue{}
+	for _, issue := range report.Issues {
+		issues[issue.Type] = append(issues[issue.Type], issue)
+	}
+	assert.Len(report.Issues, 5)
+	assert.Len(issues[types.ConsistencyIssueMissingContainer], 1)
+	assert.Equal("vol2", issues[types.ConsistencyIssueMissingContainer][0].VolumeName)
+	assert.Equal("host-1", issues[types.ConsistencyIssueMissingContainer][0].HostID)
+	assert.Len(issues[types.ConsistencyIssueOrphanContainer], 1)
+	assert.Equal("orphan-id", issues[types.ConsistencyIssueOrphanContainer][0].InstanceID)
+	assert.Equal("deleted", issues[types.ConsistencyIssueOrphanContainer][0].VolumeName)
+	assert.Len(issues[types.ConsistencyIssueStateMismatch], 1)
+	assert.Equal("host-2", issues[types.ConsistencyIssueStateMismatch][0].HostID)
+	assert.Equal(types.InstanceTypeReplica, issues[types.ConsistencyIssueStateMismatch][0].InstanceType)
+	assert.Len(issues[types.ConsistencyIssueUnknownHost], 1)
+	assert.Equal("lost", issues[types.ConsistencyIssueUnknownHost][0].InstanceName)
+	assert.Len(issues[types.ConsistencyIssueAbandonedSchedule], 1)
+	assert.Equal("host-2", issues[types.ConsistencyIssueAbandonedSchedule][0].HostID)
+	assert.Contains(issues[types.ConsistencyIssueAbandonedSchedule][0].Detail, "outcome is unknown")
+}
+
+func replicaInstances(volume *types.VolumeInfo) []*types.InstanceInfo {
+	instances := []*types.InstanceInfo{}
+	for _, r := range volume.Replicas {
+		instances = append(instances, &r.InstanceInfo)
+	}
+	return instances
+}
+
+func TestWriterReport(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(orc.UpdateSettings("admin", func(s *types.SettingsInfo) error {
+		s.BackupTarget = "vfs:///var/lib/longhorn/backups"
+		return nil
+	}))
+
+	// the volume and a replica written by the new version, the rest before
+	// the stamps
+	newer := &types.WriterInfo{Version: "0.2.0", HostID: "host-1"}
+	orc.volumes["vol"].Writer = newer
+	for _, replica := range orc.volumes["vol"].Replicas {
+		replica.Writer = newer
+		break
+	}
+	orc.hosts["host-2"].Writer = newer
+
+	report, err := man.WriterReport()
+	assert.Nil(err)
+	assert.Equal(6, report.Records)
+	assert.Equal(map[string]int{"0.2.0": 3, types.WriterVersionUnknown: 3}, report.Versions)
+	assert.Equal(map[string]int{"0.2.0": 1, types.WriterVersionUnknown: 1}, report.Kinds["replica"])
+	assert.Equal(map[string]int{types.WriterVersionUnknown: 1}, report.Kinds["settings"])
+}
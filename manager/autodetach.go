@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+var (
+	AutoDetachCheckPeriod = time.Minute
+
+	// DefaultAutoDetachTimeout is used if the autoDetachTimeout setting is
+	// empty
+	DefaultAutoDetachTimeout = time.Hour
+)
+
+// volumeActivity is the last I/O counters seen of an attached volume, and
+// since when they haven't changed
+type volumeActivity struct {
+	readOps  int64
+	writeOps int64
+	since    time.Time
+}
+
+// autoDetachTimeout returns 0 if auto detach is disabled
+func (man *volumeManager) autoDetachTimeout() (time.Duration, error) {
+	settings, err := man.settings.GetSettings()
+	if err != nil || settings == nil {
+		return 0, errors.Wrap(err, "unable to read settings")
+	}
+	if !settings.AutoDetach {
+		return 0, nil
+	}
+	if settings.AutoDetachTimeout == "" {
+		return DefaultAutoDetachTimeout, nil
+	}
+	timeout, err := time.ParseDuration(settings.AutoDetachTimeout)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid autoDetachTimeout setting")
+	}
+	return timeout, nil
+}
+
+// detachIdleVolumes detaches the volumes attached on the current host which
+// are not mounted and had no I/O for the auto detach timeout
+func (man *volumeManager) detachIdleVolumes(now time.Time) error {
+	timeout, err := man.autoDetachTimeout()
+	if err != nil {
+		return err
+	}
+	if timeout == 0 {
+		man.setActivities(map[string]*volumeActivity{})
+		return nil
+	}
+	previous := man.getActivities()
+	activities := map[string]*volumeActivity{}
+	if err := man.orc.ForEachVolume("", func(volume *types.VolumeInfo) error {
+		volume = man.completeVolumeState(volume)
+		if volume.Controller == nil || !volume.Controller.Running || volume.Controller.HostID != man.orc.GetCurrentHostID() {
+			return nil
+		}
+		ctrl := man.getController(volume)
+		if ctrl == nil {
+			return nil
+		}
+		stats, err := ctrl.Stats()
+		if err != nil {
+			logrus.Warnf("%v", errors.Wrapf(err, "auto detach: unable to get stats of volume '%s'", volume.Name))
+			return nil
+		}
+		a := previous[volume.Name]
+		if a == nil || stats.Mounted || a.readOps != stats.ReadOps || a.writeOps != stats.WriteOps {
+			activities[volume.Name] = &volumeActivity{readOps: stats.ReadOps, writeOps: stats.WriteOps, since: now}
+			return nil
+		}
+		if now.Sub(a.since) < timeout {
+			activities[volume.Name] = a
+			return nil
+		}
+		logrus.Warnf("auto detach: volume '%s' not mounted and idle since %v, detaching", volume.Name, a.since)
+		if err := man.doDetach(volume); err != nil {
+			logrus.Errorf("%+v", errors.Wrapf(err, "auto detach: fail to detach volume '%s'", volume.Name))
+			activities[volume.Name] = a
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "unable to list volumes")
+	}
+	man.setActivities(activities)
+	return nil
+}
+
+func (man *volumeManager) getActivities() map[string]*volumeActivity {
+	man.Lock()
+	defer man.Unlock()
+	return man.activities
+}
+
+func (man *volumeManager) setActivities(activities map[string]*volumeActivity) {
+	man.Lock()
+	defer man.Unlock()
+	man.activities = activities
+}
+
+func (man *volumeManager) autoDetach(ctx context.Context) error {
+	return runner.Tick(ctx, AutoDetachCheckPeriod, func() {
+		if man.IsReconcilePaused() {
+			return
+		}
+		if err := man.detachIdleVolumes(time.Now()); err != nil {
+			logrus.Warnf("%v", errors.Wrap(err, "error checking idle volumes"))
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestAutoDetachIdleVolume(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, fc := newTestManager(orc)
+
+	for _, name := range []string{"idle", "mounted"} {
+		volume, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2})
+		assert.Nil(err)
+		assert.Nil(man.Attach(volume.Name))
+		_, err = man.Controller(volume.Name)
+		assert.Nil(err)
+	}
+	fc.controllers["mounted"].stats.Mounted = true
+
+	attached := func(name string) bool {
+		volume, err := man.Get(name)
+		assert.Nil(err)
+		return volume.Controller != nil
+	}
+
+	start := time.Date(2017, 5, 1, 0, 0, 0, 0, time.UTC)
+	// disabled by default
+	assert.Nil(man.detachIdleVolumes(start))
+	assert.Nil(man.detachIdleVolumes(start.Add(48 * time.Hour)))
+	assert.True(attached("idle"))
+
+	orc.settings.AutoDetach = true
+	orc.settings.AutoDetachTimeout = "10m"
+	assert.Nil(man.detachIdleVolumes(start))
+	assert.Nil(man.detachIdleVolumes(start.Add(5 * time.Minute)))
+	assert.True(attached("idle"))
+
+	// I/O restarts the timeout
+	fc.controllers["idle"].stats.WriteOps++
+	assert.Nil(man.detachIdleVolumes(start.Add(6 * time.Minute)))
+	assert.Nil(man.detachIdleVolumes(start.Add(15 * time.Minute)))
+	assert.True(attached("idle"))
+
+	assert.Nil(man.detachIdleVolumes(start.Add(17 * time.Minute)))
+	assert.False(attached("idle"))
+	assert.True(attached("mounted"))
+}
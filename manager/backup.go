@@ -0,0 +1,364 @@
+package manager
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/backups"
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// LocalReplicaBackupVersion is the oldest engine version which can read
+	// the data of a backup from a replica instead of through the controller
+	LocalReplicaBackupVersion = util.Version{Major: 0, Minor: 4, Patch: 0}
+
+	// DefaultBackupCompression is used if neither the volume nor the
+	// backupCompression setting sets one
+	DefaultBackupCompression = types.BackupCompressionLZ4
+
+	// BackupTurnRetryInterval is how often a backup waiting for its turn
+	// tries to take a slot
+	BackupTurnRetryInterval = 10 * time.Second
+)
+
+func ValidateBackupCompression(compression types.BackupCompression) error {
+	if compression == types.BackupCompressionDefault || compression.Valid() {
+		return nil
+	}
+	return errors.Errorf("invalid backup compression '%s', expecting one of %v", compression, types.BackupCompressions)
+}
+
+// backupCompression is the compression of the backups of the volume, the one
+// set on the volume, or the setting
+func (man *volumeManager) backupCompression(volume *types.VolumeInfo) (types.BackupCompression, error) {
+	if volume.BackupCompression != types.BackupCompressionDefault {
+		return volume.BackupCompression, nil
+	}
+	settings, err := man.settings.GetSettings()
+	if err != nil || settings == nil {
+		return DefaultBackupCompression, errors.Wrap(err, "unable to read settings")
+	}
+	if settings.BackupCompression == types.BackupCompressionDefault {
+		return DefaultBackupCompression, nil
+	}
+	return settings.BackupCompression, nil
+}
+
+// UpdateBackupCompression sets the compression of the next backups of the
+// volume, empty to follow the setting. The backups taken keep theirs.
+func (man *volumeManager) UpdateBackupCompression(name string, compression types.BackupCompression) error {
+	if err := ValidateBackupCompression(compression); err != nil {
+		return err
+	}
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	volume.BackupCompression = compression
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", name)
+	}
+	return nil
+}
+
+// backupKey identifies the backup URL by target, volume and backup name, the
+// query may come in any order
+func backupKey(backupURL string) string {
+	u, err := url.Parse(backupURL)
+	if err != nil {
+		return backupURL
+	}
+	q := u.Query()
+	return u.Scheme + "://" + u.Host + u.Path + "?backup=" + q.Get("backup") + "&volume=" + q.Get("volume")
+}
+
+// trackedBackups maps the keys of the backups tracked by standby volumes to
+// the volume names
+func trackedBackups(man types.VolumeManager) (map[string]string, error) {
+	volumes, err := man.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list volumes")
+	}
+	tracked := map[string]string{}
+	for _, v := range volumes {
+		if v.Standby {
+			tracked[backupKey(v.FromBackup)] = v.Name
+		}
+	}
+	return tracked, nil
+}
+
+// VolumeBackupTarget is the backup target with its variables resolved from
+// the labels of the volume. A volume gone can only use a target without
+// variables.
+func (man *volumeManager) VolumeBackupTarget(volumeName string) (string, error) {
+	settings, err := man.settings.GetSettings()
+	if err != nil || settings == nil {
+		return "", errors.New("cannot backup: unable to read settings")
+	}
+	if settings.BackupTarget == "" {
+		return "", errors.New("cannot backup: backupTarget not set")
+	}
+	if !backups.IsTargetTemplate(settings.BackupTarget) {
+		return settings.BackupTarget, nil
+	}
+	volume, err := man.Get(volumeName)
+	if err != nil {
+		return "", err
+	}
+	if volume == nil {
+		return "", errors.Errorf("cannot resolve backup target '%s': volume '%s' not found", settings.BackupTarget, volumeName)
+	}
+	return backups.ResolveTarget(settings.BackupTarget, volume)
+}
+
+// DeleteBackup removes the backup from the backup target. A backup already
+// gone is not an error. With removeVolume, deleting the last backup of the
+// volume removes the volume directory on the target as well.
+func (man *volumeManager) DeleteBackup(backupTarget, volumeName, backupName string, removeVolume bool) error {
+	backupURL := backups.URL(backupTarget, backupName, volumeName)
+	tracked, err := trackedBackups(man)
+	if err != nil {
+		return errors.Wrapf(err, "fail to delete backup '%s'", backupURL)
+	}
+	if standby, ok := tracked[backupKey(backupURL)]; ok {
+		return errors.Errorf("cannot delete backup '%s': standby volume '%s' is tracking it, remove the volume first",
+			backupURL, standby)
+	}
+
+	ops := man.getBackups(backupTarget)
+	if err := ops.Delete(backupURL); err != nil {
+		return errors.Wrapf(err, "fail to delete backup '%s'", backupURL)
+	}
+	logrus.Infof("deleted backup '%s'", backupURL)
+	if !removeVolume {
+		return nil
+	}
+
+	bs, err := ops.List(volumeName)
+	if err != nil {
+		return errors.Wrapf(err, "fail to list backups of volume '%s'", volumeName)
+	}
+	for _, b := range bs {
+		// the target may still list the backup just deleted
+		if b.Name != backupName {
+			logrus.Infof("keeping backup volume '%s', backup '%s' left", volumeName, b.Name)
+			return nil
+		}
+	}
+	if err := ops.DeleteVolume(volumeName); err != nil {
+		return errors.Wrapf(err, "fail to delete backup volume '%s'", volumeName)
+	}
+	logrus.Infof("deleted backup volume '%s' with its last backup", volumeName)
+	return nil
+}
+
+// GarbageCollectBackupTarget removes the orphaned blocks of the target, left
+// by interrupted backups or deletions. The managers run one at a time, the
+// backups in progress are protected by backups.BlockGCGracePeriod.
+func (man *volumeManager) GarbageCollectBackupTarget(target string) (*types.GCResult, error) {
+	if target == "" {
+		settings, err := man.settings.GetSettings()
+		if err != nil || settings == nil {
+			return nil, errors.New("cannot garbage collect backups: unable to read settings")
+		}
+		if settings.BackupTarget == "" {
+			return nil, errors.New("cannot garbage collect backups: backupTarget not set")
+		}
+		target = settings.BackupTarget
+	}
+	lock, err := man.acquireLock("backup-gc", "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to garbage collect backup target '%s'", target)
+	}
+	defer lock.release()
+
+	result, err := backups.GarbageCollect(target, time.Now())
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to garbage collect backup target '%s'", target)
+	}
+	logrus.Infof("garbage collected backup target '%s': removed %v of %v blocks, %v bytes, kept %v recent orphans",
+		target, len(result.RemovedBlocks), result.Blocks, result.BytesReclaimed, result.RecentBlocks)
+	for _, e := range result.Errors {
+		logrus.Warnf("garbage collecting backup target '%s': %v", target, e)
+	}
+	return result, nil
+}
+
+// StartBackup queues the backup of the snapshot on the controller. A backup
+// runs on the host of the controller, it reads from a good replica on the
+// same host if the engine can, so the data doesn't cross hosts. Otherwise it
+// reads through the controller, from all the replicas in turn. The blocks
+// are compressed with the compression of the volume unless the task sets one.
+func (man *volumeManager) StartBackup(volumeName string, task *types.BackupBgTask) error {
+	volume, err := man.Get(volumeName)
+	if err != nil {
+		return err
+	}
+	if volume == nil || volume.Controller == nil {
+		return errors.Errorf("cannot backup volume '%s': it's not attached", volumeName)
+	}
+	ctrl := man.getController(volume)
+	snap, err := ctrl.SnapshotOps().Get(task.Snapshot)
+	if err != nil {
+		return errors.Wrapf(err, "error getting snapshot '%s', volume '%s'", task.Snapshot, volumeName)
+	}
+	if snap == nil {
+		return errors.Errorf("could not find snapshot '%s' to backup, volume '%s'", task.Snapshot, volumeName)
+	}
+	if task.Compression == types.BackupCompressionDefault {
+		if task.Compression, err = man.backupCompression(volume); err != nil {
+			return errors.Wrapf(err, "unable to backup volume '%s'", volumeName)
+		}
+	}
+	if err := ValidateBackupCompression(task.Compression); err != nil {
+		return errors.Wrapf(err, "unable to backup volume '%s'", volumeName)
+	}
+	states, err := ctrl.GetReplicaStates()
+	if err != nil {
+		return errors.Wrapf(err, "unable to get replicas of volume '%s'", volumeName)
+	}
+
+	local, good := localBackupReplica(volume, states)
+	task.ReadStrategy, task.ReplicaAddress = types.BackupReadController, ""
+	if local != nil {
+		task.ReadStrategy, task.ReplicaAddress = types.BackupReadLocalReplica, local.Address
+	}
+	target := task.BackupTarget
+	task.WaitTurn = func() (func(), error) {
+		return man.waitBackupTurn(volumeName, target)
+	}
+	ctrl.BgTaskQueue().Put(&types.BgTask{Task: task})
+
+	man.Lock()
+	defer man.Unlock()
+	if local == nil {
+		man.backupReads.Controller++
+		return nil
+	}
+	man.backupReads.LocalReplica++
+	// through the controller, the reads would have been spread over the
+	// good replicas, the ones on other hosts pulling the data over the network
+	if size, err := strconv.ParseInt(snap.Size, 10, 64); err == nil && good > 0 {
+		man.backupReads.CrossHostBytesSaved += size * int64(good-1) / int64(good)
+	}
+	return nil
+}
+
+// localBackupReplica returns the good replica on the host of the controller
+// a backup can read from, nil if there is none or the engine cannot, and the
+// number of good replicas
+func localBackupReplica(volume *types.VolumeInfo, states []*types.ReplicaInfo) (*types.ReplicaInfo, int) {
+	rw := map[string]bool{}
+	for _, r := range states {
+		if r.Mode == types.ReplicaModeRW {
+			rw[r.Address] = true
+		}
+	}
+	version, err := util.ImageVersion(volume.EngineImage)
+	supported := err == nil && version.Compare(LocalReplicaBackupVersion) >= 0
+	var local *types.ReplicaInfo
+	for _, r := range volume.Replicas {
+		if !supported || !rw[r.Address] || r.BadTimestamp != "" || r.HostID != volume.Controller.HostID {
+			continue
+		}
+		if local == nil || r.Name < local.Name {
+			local = r
+		}
+	}
+	return local, len(rw)
+}
+
+func (man *volumeManager) BackupReadStats() *types.BackupReadStats {
+	man.Lock()
+	defer man.Unlock()
+	stats := man.backupReads
+	return &stats
+}
+
+// waitBackupTurn blocks until the backup of the volume to the target holds a
+// slot of the backupConcurrencyLimit backups of the cluster and one of the
+// backupTargetConcurrencyLimit backups to the target, and returns the
+// function releasing them. The slots are locks, so they are shared by the
+// managers and freed if the manager running a backup is gone.
+func (man *volumeManager) waitBackupTurn(volumeName, target string) (func(), error) {
+	waiting := false
+	for {
+		settings, err := man.settings.GetSettings()
+		if err != nil || settings == nil {
+			return nil, errors.Wrap(err, "unable to read settings")
+		}
+		release, err := man.takeBackupSlots(volumeName, target, settings)
+		if err != nil {
+			return nil, err
+		}
+		if release != nil {
+			if waiting {
+				logrus.Infof("backup of volume '%s' to '%s' got its turn", volumeName, target)
+			}
+			return release, nil
+		}
+		if !waiting {
+			logrus.Infof("backup of volume '%s' to '%s' waiting for its turn, %v backups running in the cluster, %v to the target at most",
+				volumeName, target, settings.BackupConcurrencyLimit, settings.BackupTargetConcurrencyLimit)
+			waiting = true
+		}
+		time.Sleep(BackupTurnRetryInterval)
+	}
+}
+
+// takeBackupSlots returns nil if a slot isn't free
+func (man *volumeManager) takeBackupSlots(volumeName, target string, settings *types.SettingsInfo) (func(), error) {
+	sum := sha1.Sum([]byte(target))
+	slots := []struct {
+		prefix string
+		limit  int
+	}{
+		{"backup-slot", settings.BackupConcurrencyLimit},
+		{"backup-target-" + hex.EncodeToString(sum[:])[:12] + "-slot", settings.BackupTargetConcurrencyLimit},
+	}
+	held := []*operationLock{}
+	release := func() {
+		for _, l := range held {
+			l.release()
+		}
+	}
+	for _, slot := range slots {
+		if slot.limit <= 0 {
+			continue
+		}
+		lock, err := man.takeSlot(slot.prefix, slot.limit, volumeName)
+		if err != nil || lock == nil {
+			// not holding a slot while waiting for the other
+			release()
+			return nil, err
+		}
+		held = append(held, lock)
+	}
+	return release, nil
+}
+
+func (man *volumeManager) takeSlot(prefix string, limit int, volumeName string) (*operationLock, error) {
+	for i := 0; i < limit; i++ {
+		lock, err := man.acquireLock(fmt.Sprintf("%v-%v", prefix, i), volumeName)
+		if err == nil {
+			return lock, nil
+		}
+		if _, ok := err.(*types.ErrLockHeld); !ok {
+			return nil, errors.Wrapf(err, "fail to take a slot of %v", prefix)
+		}
+	}
+	return nil, nil
+}
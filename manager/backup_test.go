@@ -0,0 +1,315 @@
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestDeleteBackup(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1")
+	man, _ := newTestManager(orc)
+	ops := &fakeBackupOps{}
+	man.getBackups = func(backupTarget string) types.ManagerBackupOps {
+		return ops
+	}
+
+	// the target still lists the backup just deleted
+	ops.backups = []*types.BackupInfo{{Name: "backup-1"}, {Name: "backup-2"}}
+	assert.Nil(man.DeleteBackup("s3://backups@us-east-1/", "vol", "backup-1", true))
+	assert.Equal([]string{"s3://backups@us-east-1/?backup=backup-1&volume=vol"}, ops.deleted)
+	assert.Len(ops.deletedVolumes, 0)
+
+	ops.backups = []*types.BackupInfo{{Name: "backup-2"}}
+	assert.Nil(man.DeleteBackup("s3://backups@us-east-1/", "vol", "backup-2", false))
+	assert.Len(ops.deletedVolumes, 0)
+	assert.Nil(man.DeleteBackup("s3://backups@us-east-1/", "vol", "backup-2", true))
+	assert.Equal([]string{"vol"}, ops.deletedVolumes)
+
+	// the backup tracked by a standby volume is kept
+	_, err := orc.CreateVolume(&types.VolumeInfo{
+		Name:       "standby",
+		FromBackup: "s3://backups@us-east-1/?volume=vol&backup=backup-3",
+		Standby:    true,
+	})
+	assert.Nil(err)
+	ops.deleted = nil
+	err = man.DeleteBackup("s3://backups@us-east-1/", "vol", "backup-3", false)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "standby volume 'standby'")
+	assert.Len(ops.deleted, 0)
+}
+
+func TestVolumeBackupTarget(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	_, err := man.VolumeBackupTarget("vol")
+	assert.NotNil(err)
+
+	assert.Nil(orc.SetSettings(&types.SettingsInfo{
+		EngineImage:  "test-engine",
+		BackupTarget: "s3://backups@us-east-1/{team}/{volume}",
+	}))
+	_, err = man.Create(&types.VolumeInfo{
+		Name:             "vol",
+		Size:             4096,
+		NumberOfReplicas: 2,
+		Labels:           map[string]string{"team": "billing"},
+	})
+	assert.Nil(err)
+	target, err := man.VolumeBackupTarget("vol")
+	assert.Nil(err)
+	assert.Equal("s3://backups@us-east-1/billing/vol", target)
+
+	_, err = man.Create(&types.VolumeInfo{Name: "unlabeled", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	_, err = man.VolumeBackupTarget("unlabeled")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "[team]")
+
+	_, err = man.VolumeBackupTarget("gone")
+	assert.NotNil(err)
+
+	_, err = man.Create(&types.VolumeInfo{
+		Name:             "bad",
+		Size:             4096,
+		NumberOfReplicas: 2,
+		Labels:           map[string]string{"a/b": "c"},
+	})
+	assert.NotNil(err)
+}
+
+func TestBackupReadStrategy(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 3})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	volume, err := orc.GetVolume("vol")
+	assert.Nil(err)
+	volume.EngineImage = "rancher/longhorn-engine:v0.4.0"
+	assert.Nil(orc.UpdateVolume(volume))
+	assert.Equal("host-1", volume.Controller.HostID)
+
+	ctrl := fc.get(volume).(*fakeController)
+	snapshots := newFakeSnapshotOps(time.Now())
+	snapshots.snapshots["snap-1"] = &types.SnapshotInfo{Name: "snap-1", Size: "3000"}
+	ctrl.snapshots = snapshots
+	assert.NotNil(man.StartBackup("vol", &types.BackupBgTask{Snapshot: "missing", BackupTarget: "s3://backups@us-east-1/"}))
+
+	// reads from the replica on the host of the controller, which saves the
+	// two thirds the controller would have read from the other hosts
+	var local *types.ReplicaInfo
+	for _, r := range volume.Replicas {
+		if r.HostID == "host-1" {
+			local = r
+		}
+	}
+	assert.NotNil(local)
+	assert.Nil(man.StartBackup("vol", &types.BackupBgTask{Snapshot: "snap-1", BackupTarget: "s3://backups@us-east-1/"}))
+	task := ctrl.queue.Take().Task.(*types.BackupBgTask)
+	assert.Equal(types.BackupReadLocalReplica, task.ReadStrategy)
+	assert.Equal(local.Address, task.ReplicaAddress)
+	assert.Equal(&types.BackupReadStats{LocalReplica: 1, CrossHostBytesSaved: 2000}, man.BackupReadStats())
+
+	// not if the local replica is rebuilding
+	ctrl.Lock()
+	ctrl.replicas[local.Address].Mode = types.ReplicaModeWO
+	ctrl.Unlock()
+	assert.Nil(man.StartBackup("vol", &types.BackupBgTask{Snapshot: "snap-1", BackupTarget: "s3://backups@us-east-1/"}))
+	task = ctrl.queue.Take().Task.(*types.BackupBgTask)
+	assert.Equal(types.BackupReadController, task.ReadStrategy)
+	assert.Equal("", task.ReplicaAddress)
+	ctrl.Lock()
+	ctrl.replicas[local.Address].Mode = types.ReplicaModeRW
+	ctrl.Unlock()
+
+	// nor if the engine is too old to read from a replica
+	volume.EngineImage = "rancher/longhorn-engine:v0.3.1"
+	assert.Nil(orc.UpdateVolume(volume))
+	assert.Nil(man.StartBackup("vol", &types.BackupBgTask{Snapshot: "snap-1", BackupTarget: "s3://backups@us-east-1/"}))
+	task = ctrl.queue.Take().Task.(*types.BackupBgTask)
+	assert.Equal(types.BackupReadController, task.ReadStrategy)
+	assert.Equal(&types.BackupReadStats{LocalReplica: 1, Controller: 2, CrossHostBytesSaved: 2000}, man.BackupReadStats())
+}
+
+func TestBackupCompression(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, fc := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "bad", Size: 4096, NumberOfReplicas: 2, BackupCompression: "zstd"})
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "invalid backup compression 'zstd'")
+
+	_, err = man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	volume, err := orc.GetVolume("vol")
+	assert.Nil(err)
+	ctrl := fc.get(volume).(*fakeController)
+	snapshots := newFakeSnapshotOps(time.Now())
+	snapshots.snapshots["snap-1"] = &types.SnapshotInfo{Name: "snap-1", Size: "3000"}
+	ctrl.snapshots = snapshots
+
+	backup := func() types.BackupCompression {
+		assert.Nil(man.StartBackup("vol", &types.BackupBgTask{Snapshot: "snap-1", BackupTarget: "s3://backups@us-east-1/"}))
+		return ctrl.queue.Take().Task.(*types.BackupBgTask).Compression
+	}
+
+	// the default, then the setting, then the volume override
+	assert.Equal(DefaultBackupCompression, backup())
+	assert.Nil(orc.SetSettings(&types.SettingsInfo{BackupCompression: types.BackupCompressionNone}))
+	assert.Equal(types.BackupCompressionNone, backup())
+	assert.Nil(man.UpdateBackupCompression("vol", types.BackupCompressionGzip))
+	assert.Equal(types.BackupCompressionGzip, backup())
+
+	assert.NotNil(man.UpdateBackupCompression("vol", "zstd"))
+	assert.NotNil(man.UpdateBackupCompression("gone", types.BackupCompressionGzip))
+	assert.Nil(man.UpdateBackupCompression("vol", types.BackupCompressionDefault))
+	assert.Equal(types.BackupCompressionNone, backup())
+
+	assert.NotNil(man.StartBackup("vol", &types.BackupBgTask{Snapshot: "snap-1", BackupTarget: "s3://backups@us-east-1/", Compression: "zstd"}))
+}
+
+func TestBackupConcurrencyLimit(t *testing.T) {
+	assert := require.New(t)
+	defer func(interval time.Duration) { BackupTurnRetryInterval = interval }(BackupTurnRetryInterval)
+	BackupTurnRetryInterval = 10 * time.Millisecond
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+	setLimits := func(limit, targetLimit int) {
+		assert.Nil(orc.UpdateSettings("admin", func(si *types.SettingsInfo) error {
+			si.BackupConcurrencyLimit, si.BackupTargetConcurrencyLimit = limit, targetLimit
+			return nil
+		}))
+	}
+	setLimits(1, 0)
+
+	tasks := []*types.BackupBgTask{}
+	for _, name := range []string{"vol-1", "vol-2"} {
+		_, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2})
+		assert.Nil(err)
+		assert.Nil(man.Attach(name))
+		volume, err := orc.GetVolume(name)
+		assert.Nil(err)
+		ctrl := fc.get(volume).(*fakeController)
+		snapshots := newFakeSnapshotOps(time.Now())
+		snapshots.snapshots["snap-1"] = &types.SnapshotInfo{Name: "snap-1", Size: "3000"}
+		ctrl.snapshots = snapshots
+		assert.Nil(man.StartBackup(name, &types.BackupBgTask{Snapshot: "snap-1", BackupTarget: "s3://backups@us-east-1/"}))
+		tasks = append(tasks, ctrl.queue.Take().Task.(*types.BackupBgTask))
+	}
+
+	done1, err := tasks[0].WaitTurn()
+	assert.Nil(err)
+	type waitResult struct {
+		done func()
+		err  error
+	}
+	turn := make(chan waitResult)
+	go func() {
+		done2, err := tasks[1].WaitTurn()
+		turn <- waitResult{done2, err}
+	}()
+	select {
+	case <-turn:
+		t.Fatal("second backup didn't wait for the first one")
+	case <-time.After(100 * time.Millisecond):
+	}
+	done1()
+	select {
+	case result := <-turn:
+		assert.Nil(result.err)
+		result.done()
+	case <-time.After(time.Second):
+		t.Fatal("second backup didn't run after the first one")
+	}
+
+	// the limit by target doesn't hold back the backups to the other targets
+	setLimits(0, 1)
+	done1, err = man.waitBackupTurn("vol-1", "s3://backups@us-east-1/")
+	assert.Nil(err)
+	done2, err := man.waitBackupTurn("vol-2", "s3://backups@eu-west-1/")
+	assert.Nil(err)
+	go func() {
+		done3, err := man.waitBackupTurn("vol-2", "s3://backups@us-east-1/")
+		turn <- waitResult{done3, err}
+	}()
+	select {
+	case <-turn:
+		t.Fatal("backup didn't wait for the other one to the same target")
+	case <-time.After(50 * time.Millisecond):
+	}
+	done1()
+	select {
+	case result := <-turn:
+		assert.Nil(result.err)
+		result.done()
+	case <-time.After(time.Second):
+		t.Fatal("backup didn't run after the other one to the same target")
+	}
+	done2()
+
+	// unlimited
+	setLimits(0, 0)
+	for i := 0; i < 3; i++ {
+		_, err := man.waitBackupTurn("vol-1", "s3://backups@us-east-1/")
+		assert.Nil(err)
+	}
+}
+
+func TestGarbageCollectBackupTarget(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "backup-gc")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	vol := filepath.Join(dir, "backupstore", "volumes", "a1", "b2", "vol")
+	orphan := filepath.Join(vol, "blocks", "aa", "aa", "aaaa01.blk")
+	assert.Nil(os.MkdirAll(filepath.Dir(orphan), 0700))
+	assert.Nil(ioutil.WriteFile(filepath.Join(vol, "volume.cfg"), []byte(`{"Name": "vol"}`), 0600))
+	assert.Nil(ioutil.WriteFile(orphan, []byte("orphaned"), 0600))
+	old := time.Now().Add(-24 * time.Hour)
+	assert.Nil(os.Chtimes(orphan, old, old))
+
+	orc := newFakeOrc("host-1")
+	man, _ := newTestManager(orc)
+	_, err = man.GarbageCollectBackupTarget("")
+	assert.NotNil(err)
+
+	assert.Nil(orc.UpdateSettings("admin", func(si *types.SettingsInfo) error {
+		si.BackupTarget = "vfs://" + dir
+		return nil
+	}))
+	// another manager collecting
+	lock, err := man.acquireLock("backup-gc", "")
+	assert.Nil(err)
+	_, err = man.GarbageCollectBackupTarget("")
+	assert.NotNil(err)
+	lock.release()
+
+	result, err := man.GarbageCollectBackupTarget("")
+	assert.Nil(err)
+	assert.Equal("vfs://"+dir, result.BackupTarget)
+	assert.Len(result.RemovedBlocks, 1)
+	assert.Equal(int64(len("orphaned")), result.BytesReclaimed)
+	_, err = os.Stat(orphan)
+	assert.True(os.IsNotExist(err))
+}
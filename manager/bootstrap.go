@@ -0,0 +1,132 @@
+package manager
+
+import (
+	"reflect"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+const bootstrapLock = "bootstrap"
+
+var (
+	// BootstrapFile declares the settings and the volumes the manager
+	// converges toward on start
+	BootstrapFile = ""
+)
+
+// Bootstrap sets the settings of the bootstrap file and creates its volumes
+// missing. The volumes existing are skipped even if they differ, nothing is
+// deleted or shrunk, so applying the same file again changes nothing. The
+// manager taking the lock applies it, the others skip it.
+func (man *volumeManager) Bootstrap(b *types.Bootstrap) (*types.BootstrapReport, error) {
+	lock, err := man.acquireLock(bootstrapLock, "")
+	if err != nil {
+		if _, ok := err.(*types.ErrLockHeld); ok {
+			logrus.Infof("skipped bootstrap file %v: another manager is applying it", b.File)
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "unable to apply bootstrap file %v", b.File)
+	}
+	defer lock.release()
+
+	report := &types.BootstrapReport{
+		File:    b.File,
+		Digest:  b.Digest,
+		HostID:  man.orc.GetCurrentHostID(),
+		Started: util.Now(),
+		Items:   []*types.BootstrapItem{},
+	}
+	report.Items = append(report.Items, man.bootstrapSettings(b)...)
+	for _, v := range b.Volumes {
+		report.Items = append(report.Items, man.bootstrapVolume(v))
+	}
+	report.Finished = util.Now()
+	if err := man.orc.SetBootstrapReport(report); err != nil {
+		return nil, errors.Wrapf(err, "unable to record the report of bootstrap file %v", b.File)
+	}
+	logrus.Infof("applied bootstrap file %v: %v created, %v updated, %v skipped, %v failed", b.File,
+		report.Count(types.BootstrapResultCreated), report.Count(types.BootstrapResultUpdated),
+		report.Count(types.BootstrapResultSkipped), report.Count(types.BootstrapResultFailed))
+	return report, nil
+}
+
+// bootstrapSettings sets the settings differing from the file at once
+func (man *volumeManager) bootstrapSettings(b *types.Bootstrap) []*types.BootstrapItem {
+	items := []*types.BootstrapItem{}
+	if len(b.Settings) == 0 {
+		return items
+	}
+	fail := func(err error) []*types.BootstrapItem {
+		items = items[:0]
+		for _, s := range b.Settings {
+			items = append(items, &types.BootstrapItem{Kind: "setting", Name: s.Name, Line: s.Line,
+				Result: types.BootstrapResultFailed, Reason: err.Error()})
+		}
+		return items
+	}
+
+	si, err := man.settings.GetSettings()
+	if err != nil || si == nil {
+		return fail(errors.Wrap(err, "unable to read settings"))
+	}
+	changed := false
+	for _, s := range b.Settings {
+		before := *si
+		if err := b.ApplySetting(si, s.Name, s.Value); err != nil {
+			return fail(errors.Wrapf(err, "invalid setting %v", s.Name))
+		}
+		item := &types.BootstrapItem{Kind: "setting", Name: s.Name, Line: s.Line, Result: types.BootstrapResultSkipped}
+		if !reflect.DeepEqual(before, *si) {
+			item.Result = types.BootstrapResultUpdated
+			changed = true
+		}
+		items = append(items, item)
+	}
+	if !changed {
+		return items
+	}
+	if err := man.settings.UpdateSettings("bootstrap "+b.File, func(si *types.SettingsInfo) error {
+		for _, s := range b.Settings {
+			if err := b.ApplySetting(si, s.Name, s.Value); err != nil {
+				return errors.Wrapf(err, "invalid setting %v", s.Name)
+			}
+		}
+		return nil
+	}); err != nil {
+		return fail(errors.Wrap(err, "unable to set settings"))
+	}
+	return items
+}
+
+func (man *volumeManager) bootstrapVolume(v *types.BootstrapVolume) *types.BootstrapItem {
+	item := &types.BootstrapItem{Kind: "volume", Name: v.Volume.Name, Line: v.Line}
+	existing, err := man.Get(v.Volume.Name)
+	if err != nil {
+		item.Result, item.Reason = types.BootstrapResultFailed, err.Error()
+		return item
+	}
+	if existing != nil {
+		item.Result, item.Reason = types.BootstrapResultSkipped, "exists"
+		return item
+	}
+	volume := *v.Volume
+	if _, err := man.Create(&volume); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "unable to create volume %v of bootstrap file", v.Volume.Name))
+		item.Result, item.Reason = types.BootstrapResultFailed, err.Error()
+		return item
+	}
+	item.Result = types.BootstrapResultCreated
+	return item
+}
+
+func (man *volumeManager) BootstrapReport() (*types.BootstrapReport, error) {
+	report, err := man.orc.GetBootstrapReport()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get bootstrap report")
+	}
+	return report, nil
+}
@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func testApplySetting(si *types.SettingsInfo, name, value string) error {
+	switch name {
+	case "backupTarget":
+		si.BackupTarget = value
+	case "snapshotMaxCount":
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		si.SnapshotMaxCount = count
+	default:
+		return errors.Errorf("invalid setting name %v", name)
+	}
+	return nil
+}
+
+func TestBootstrap(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "existing", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	report, err := man.BootstrapReport()
+	assert.Nil(err)
+	assert.Nil(report)
+
+	b := &types.Bootstrap{
+		File:   "/etc/longhorn/bootstrap.yaml",
+		Digest: "digest",
+		Settings: []*types.BootstrapSetting{
+			{Line: 2, Name: "backupTarget", Value: "s3://backups@us-east-1/"},
+			{Line: 3, Name: "snapshotMaxCount", Value: "0"},
+		},
+		Volumes: []*types.BootstrapVolume{
+			{Line: 5, Volume: &types.VolumeInfo{Name: "db", Size: 4096, NumberOfReplicas: 3,
+				RecurringJobs: []*types.RecurringJob{{Name: "nightly", Cron: "0 2 * * *", Task: "backup", Retain: 7}}}},
+			// larger than the volume existing, which is left as it is
+			{Line: 9, Volume: &types.VolumeInfo{Name: "existing", Size: 8192, NumberOfReplicas: 3}},
+			{Line: 12, Volume: &types.VolumeInfo{Name: "bad-jobs", Size: 4096, NumberOfReplicas: 2,
+				RecurringJobs: []*types.RecurringJob{{Name: "nightly", Cron: "whenever", Task: "backup"}}}},
+		},
+		ApplySetting: testApplySetting,
+	}
+	report, err = man.Bootstrap(b)
+	assert.Nil(err)
+	assert.Equal("host-1", report.HostID)
+	assert.Equal([]*types.BootstrapItem{
+		{Kind: "setting", Name: "backupTarget", Line: 2, Result: types.BootstrapResultUpdated},
+		{Kind: "setting", Name: "snapshotMaxCount", Line: 3, Result: types.BootstrapResultSkipped},
+		{Kind: "volume", Name: "db", Line: 5, Result: types.BootstrapResultCreated},
+		{Kind: "volume", Name: "existing", Line: 9, Result: types.BootstrapResultSkipped, Reason: "exists"},
+	}, report.Items[:4])
+	assert.Equal(types.BootstrapResultFailed, report.Items[4].Result)
+	assert.Contains(report.Items[4].Reason, "cron")
+
+	settings, err := orc.GetSettings()
+	assert.Nil(err)
+	assert.Equal("s3://backups@us-east-1/", settings.BackupTarget)
+	history, err := orc.GetSettingsHistory()
+	assert.Nil(err)
+	assert.Equal("bootstrap /etc/longhorn/bootstrap.yaml", history[len(history)-1].Author)
+	db, err := orc.GetVolume("db")
+	assert.Nil(err)
+	assert.Equal(3, db.NumberOfReplicas)
+	assert.Len(db.RecurringJobs, 1)
+	existing, err := orc.GetVolume("existing")
+	assert.Nil(err)
+	assert.Equal(int64(4096), existing.Size)
+	stored, err := man.BootstrapReport()
+	assert.Nil(err)
+	assert.Equal(report, stored)
+
+	// applying it again changes nothing
+	revisions := len(history)
+	b.Volumes = b.Volumes[:2]
+	report, err = man.Bootstrap(b)
+	assert.Nil(err)
+	for _, item := range report.Items {
+		assert.Equal(types.BootstrapResultSkipped, item.Result, item.Name)
+	}
+	history, err = orc.GetSettingsHistory()
+	assert.Nil(err)
+	assert.Len(history, revisions)
+
+	// nor while another manager applies it
+	assert.Nil(orc.AcquireLock(&types.LockInfo{Name: bootstrapLock, HolderID: "host-2", OperationID: "other"}, time.Minute))
+	report, err = man.Bootstrap(b)
+	assert.Nil(err)
+	assert.Nil(report)
+}
@@ -0,0 +1,455 @@
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+var (
+	// InternalTLSEnabled serves and reaches the other managers over TLS,
+	// with certificates issued by the cluster CA. It takes effect on Start().
+	InternalTLSEnabled = false
+	// ClusterCAKey seals the key of the cluster CA in the store, it must be
+	// the same on all the managers
+	ClusterCAKey []byte
+
+	CAValidity = 10 * 365 * 24 * time.Hour
+	// CARenewBefore is how long before its expiry the CA is rotated
+	CARenewBefore = 365 * 24 * time.Hour
+	// CATransitionWindow is how long the previous CA is still trusted after
+	// a rotation, the managers renew their certificates meanwhile
+	CATransitionWindow = 48 * time.Hour
+
+	CertValidity = 90 * 24 * time.Hour
+	// CertRenewBefore is how long before its expiry the certificate of a
+	// manager is renewed
+	CertRenewBefore = 30 * 24 * time.Hour
+	// CertCheckPeriod is how often the CA and the certificate are checked
+	CertCheckPeriod = time.Hour
+	// CAReloadInterval is the least time between the reloads of the CA on
+	// the handshakes with the peers of an unknown CA, so such peers cannot
+	// make every handshake read the store
+	CAReloadInterval = 10 * time.Second
+
+	// a manager waits for the one generating the CA at most for
+	// clusterCAWaitRetries * clusterCAWaitInterval
+	clusterCAWaitRetries  = 30
+	clusterCAWaitInterval = time.Second
+)
+
+const (
+	clusterCALock       = "cluster-ca"
+	clusterCACommonName = "longhorn-manager-ca"
+
+	MetricCAExpiry   = "longhorn_tls_ca_expiry_timestamp_seconds"
+	MetricCertExpiry = "longhorn_tls_cert_expiry_timestamp_seconds"
+)
+
+// managerCerts is the certificate of the manager and the CAs it trusts
+type managerCerts struct {
+	sync.Mutex
+
+	cert    *tls.Certificate
+	renewed time.Time
+
+	caCert         *x509.Certificate
+	previousCACert *x509.Certificate
+	previousUntil  time.Time
+	pool           *x509.CertPool
+	// the last reload on a handshake with a peer of an unknown CA
+	peerReloaded time.Time
+}
+
+// ensureClusterCA returns the cluster CA, generating it if there is none
+// yet, or sealing its key left in cleartext. The manager taking the lock
+// does it, the others wait for it.
+func (man *volumeManager) ensureClusterCA() (*types.ClusterCA, error) {
+	for i := 0; ; i++ {
+		ca, err := man.orc.GetClusterCA()
+		if err != nil {
+			return nil, err
+		}
+		if ca != nil && ca.Key == "" {
+			return ca, nil
+		}
+		lock, err := man.acquireLock(clusterCALock, "")
+		if err != nil {
+			if _, ok := err.(*types.ErrLockHeld); ok && i < clusterCAWaitRetries {
+				time.Sleep(clusterCAWaitInterval)
+				continue
+			}
+			return nil, errors.Wrap(err, "unable to generate cluster CA")
+		}
+		ca, err = man.generateClusterCA()
+		lock.release()
+		return ca, err
+	}
+}
+
+// generateClusterCA creates the CA if it still doesn't exist, or seals its
+// key left in cleartext, the caller holds the lock
+func (man *volumeManager) generateClusterCA() (*types.ClusterCA, error) {
+	ca, err := man.orc.GetClusterCA()
+	if err != nil || (ca != nil && ca.Key == "") {
+		return ca, err
+	}
+	if ca != nil {
+		if ca.SealedKey, err = util.SealKey(ClusterCAKey, ca.Key); err != nil {
+			return nil, errors.Wrap(err, "unable to seal cluster CA key")
+		}
+		ca.Key = ""
+		if err := man.orc.SetClusterCA(ca); err != nil {
+			return nil, err
+		}
+		logrus.Infof("sealed the key of the cluster CA left in cleartext")
+		return ca, nil
+	}
+	certPEM, keyPEM, err := util.GenerateCA(clusterCACommonName, CAValidity)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := util.SealKey(ClusterCAKey, keyPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to seal cluster CA key")
+	}
+	ca = &types.ClusterCA{
+		Cert:      certPEM,
+		SealedKey: sealed,
+		Created:   util.Now(),
+	}
+	if err := man.orc.SetClusterCA(ca); err != nil {
+		return nil, err
+	}
+	logrus.Infof("generated cluster CA, expires in %v", CAValidity)
+	return ca, nil
+}
+
+// RotateClusterCA replaces the cluster CA right away, and renews the
+// certificate of the manager with the new one
+func (man *volumeManager) RotateClusterCA() error {
+	if !InternalTLSEnabled {
+		return errors.Errorf("internal TLS is not enabled")
+	}
+	if err := man.rotateClusterCA(true); err != nil {
+		return err
+	}
+	return man.refreshCert()
+}
+
+// rotateClusterCA replaces the CA if force is set or it expires within
+// CARenewBefore. The previous CA is trusted for CATransitionWindow.
+func (man *volumeManager) rotateClusterCA(force bool) error {
+	lock, err := man.acquireLock(clusterCALock, "")
+	if err != nil {
+		return errors.Wrap(err, "unable to rotate cluster CA")
+	}
+	defer lock.release()
+
+	ca, err := man.orc.GetClusterCA()
+	if err != nil {
+		return err
+	}
+	if ca == nil {
+		return errors.Errorf("unable to rotate cluster CA: it doesn't exist")
+	}
+	caCert, err := util.ParseCertPEM(ca.Cert)
+	if err != nil {
+		return errors.Wrap(err, "invalid cluster CA")
+	}
+	if !force && time.Until(caCert.NotAfter) > CARenewBefore {
+		return nil
+	}
+	certPEM, keyPEM, err := util.GenerateCA(clusterCACommonName, CAValidity)
+	if err != nil {
+		return err
+	}
+	sealed, err := util.SealKey(ClusterCAKey, keyPEM)
+	if err != nil {
+		return errors.Wrap(err, "unable to seal cluster CA key")
+	}
+	until := time.Now().Add(CATransitionWindow)
+	rotated := &types.ClusterCA{
+		Cert:          certPEM,
+		SealedKey:     sealed,
+		Created:       util.Now(),
+		PreviousCert:  ca.Cert,
+		PreviousUntil: util.FormatTimeZ(until),
+	}
+	if err := lock.Err(); err != nil {
+		return err
+	}
+	if err := man.orc.SetClusterCA(rotated); err != nil {
+		return err
+	}
+	logrus.Infof("rotated cluster CA, the previous one expiring at %v is trusted until %v",
+		util.FormatTimeZ(caCert.NotAfter), util.FormatTimeZ(until))
+	return nil
+}
+
+// reloadClusterCA trusts the cluster CA as stored, along with the previous
+// CA until the end of the transition
+func (man *volumeManager) reloadClusterCA(ca *types.ClusterCA) error {
+	if ca == nil {
+		var err error
+		if ca, err = man.orc.GetClusterCA(); err != nil {
+			return err
+		}
+		if ca == nil {
+			return errors.Errorf("cluster CA doesn't exist")
+		}
+	}
+	caCert, err := util.ParseCertPEM(ca.Cert)
+	if err != nil {
+		return errors.Wrap(err, "invalid cluster CA")
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	var previous *x509.Certificate
+	until, err := util.ParseTimeZ(ca.PreviousUntil)
+	if ca.PreviousCert != "" && err == nil && time.Now().Before(until) {
+		if previous, err = util.ParseCertPEM(ca.PreviousCert); err != nil {
+			return errors.Wrap(err, "invalid previous cluster CA")
+		}
+		pool.AddCert(previous)
+	}
+
+	man.certs.Lock()
+	defer man.certs.Unlock()
+	man.certs.caCert = caCert
+	man.certs.previousCACert = previous
+	man.certs.previousUntil = until
+	man.certs.pool = pool
+	return nil
+}
+
+// refreshCert makes sure the manager has a certificate issued by the
+// current cluster CA, valid for more than CertRenewBefore
+func (man *volumeManager) refreshCert() error {
+	ca, err := man.ensureClusterCA()
+	if err != nil {
+		return err
+	}
+	if err := man.reloadClusterCA(ca); err != nil {
+		return err
+	}
+	man.certs.Lock()
+	cert, caCert := man.certs.cert, man.certs.caCert
+	man.certs.Unlock()
+	if cert != nil && time.Until(cert.Leaf.NotAfter) > CertRenewBefore &&
+		cert.Leaf.CheckSignatureFrom(caCert) == nil {
+		return nil
+	}
+
+	hostID := man.orc.GetCurrentHostID()
+	address, err := man.orc.GetAddress(hostID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to issue certificate of host %v", hostID)
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrapf(err, "invalid address %v of host %v", address, hostID)
+	}
+	caKey, err := util.OpenKey(ClusterCAKey, ca.SealedKey)
+	if err != nil {
+		return errors.Wrap(err, "unable to open cluster CA key, is the cluster CA key the same on all the managers?")
+	}
+	certPEM, keyPEM, err := util.IssueCert(ca.Cert, caKey, hostID, []string{host}, CertValidity)
+	if err != nil {
+		return errors.Wrapf(err, "unable to issue certificate of host %v", hostID)
+	}
+	pair, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return errors.Wrapf(err, "invalid certificate of host %v", hostID)
+	}
+	if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		return errors.Wrapf(err, "invalid certificate of host %v", hostID)
+	}
+
+	man.certs.Lock()
+	man.certs.cert = &pair
+	man.certs.renewed = time.Now()
+	man.certs.Unlock()
+	logrus.Infof("issued certificate of host %v for %v, expires at %v", hostID, host, util.FormatTimeZ(pair.Leaf.NotAfter))
+	return nil
+}
+
+func (man *volumeManager) currentCert() (*tls.Certificate, error) {
+	man.certs.Lock()
+	defer man.certs.Unlock()
+	if man.certs.cert == nil {
+		return nil, errors.Errorf("no certificate issued yet")
+	}
+	return man.certs.cert, nil
+}
+
+// verifyPeer checks the certificate of a peer against the trusted CAs. A
+// peer with a certificate from an unknown CA may have renewed it after a
+// rotation this manager hasn't seen yet, the CA is reloaded then, at most
+// once per CAReloadInterval.
+func (man *volumeManager) verifyPeer(certs []*x509.Certificate, name string, usage x509.ExtKeyUsage) error {
+	if len(certs) == 0 {
+		return errors.Errorf("peer has no certificate")
+	}
+	verify := func() error {
+		man.certs.Lock()
+		pool := man.certs.pool
+		man.certs.Unlock()
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:     pool,
+			DNSName:   name,
+			KeyUsages: []x509.ExtKeyUsage{usage},
+		})
+		return err
+	}
+	err := verify()
+	if _, ok := err.(x509.UnknownAuthorityError); !ok {
+		return err
+	}
+	man.certs.Lock()
+	recent := time.Since(man.certs.peerReloaded) < CAReloadInterval
+	if !recent {
+		man.certs.peerReloaded = time.Now()
+	}
+	man.certs.Unlock()
+	if recent {
+		return err
+	}
+	if err := man.reloadClusterCA(nil); err != nil {
+		return errors.Wrap(err, "unable to reload cluster CA")
+	}
+	return verify()
+}
+
+func (man *volumeManager) ServerTLSConfig() *tls.Config {
+	if !InternalTLSEnabled {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return man.currentCert()
+		},
+		// the clients other than the managers have no certificate
+		ClientAuth: tls.RequestClientCert,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return nil
+			}
+			return man.verifyPeer(cs.PeerCertificates, "", x509.ExtKeyUsageClientAuth)
+		},
+	}
+}
+
+func (man *volumeManager) ClientTLSConfig() *tls.Config {
+	if !InternalTLSEnabled {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return man.currentCert()
+		},
+		// the server is verified in VerifyConnection instead, against the
+		// cluster CAs as they change
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return man.verifyPeer(cs.PeerCertificates, cs.ServerName, x509.ExtKeyUsageServerAuth)
+		},
+	}
+}
+
+func (man *volumeManager) TLSStatus() *types.TLSStatus {
+	status := &types.TLSStatus{Enabled: InternalTLSEnabled}
+	man.certs.Lock()
+	defer man.certs.Unlock()
+	if c := man.certs.caCert; c != nil {
+		status.CAExpiry = util.FormatTimeZ(c.NotAfter)
+	}
+	if c := man.certs.previousCACert; c != nil {
+		status.PreviousCAExpiry = util.FormatTimeZ(c.NotAfter)
+		status.PreviousCAUntil = util.FormatTimeZ(man.certs.previousUntil)
+	}
+	if c := man.certs.cert; c != nil {
+		status.CertExpiry = util.FormatTimeZ(c.Leaf.NotAfter)
+		status.CertRenewed = util.FormatTimeZ(man.certs.renewed)
+	}
+	return status
+}
+
+// tlsMetrics are the expiry times of the CAs and of the certificate of the
+// manager from TLSStatus, none without internal TLS
+func (man *volumeManager) tlsMetrics() []*types.MetricFamily {
+	status := man.TLSStatus()
+	if !status.Enabled {
+		return nil
+	}
+	sample := func(expiry string, labels map[string]string) []*types.MetricSample {
+		t, err := util.ParseTimeZ(expiry)
+		if expiry == "" || err != nil {
+			return nil
+		}
+		return []*types.MetricSample{{Labels: labels, Value: float64(t.Unix())}}
+	}
+	families := []*types.MetricFamily{}
+	ca := &types.MetricFamily{
+		Name: MetricCAExpiry,
+		Help: "The expiry of the cluster CA, and of the previous one while still trusted, in seconds since the epoch",
+		Type: types.MetricTypeGauge,
+		Samples: append(sample(status.CAExpiry, map[string]string{"ca": "current"}),
+			sample(status.PreviousCAExpiry, map[string]string{"ca": "previous"})...),
+	}
+	if len(ca.Samples) != 0 {
+		families = append(families, ca)
+	}
+	// the same certificate is used to serve and to reach the other managers
+	if samples := sample(status.CertExpiry, nil); len(samples) != 0 {
+		families = append(families, &types.MetricFamily{
+			Name:    MetricCertExpiry,
+			Help:    "The expiry of the server and client certificate of the manager, in seconds since the epoch",
+			Type:    types.MetricTypeGauge,
+			Samples: samples,
+		})
+	}
+	return families
+}
+
+func (man *volumeManager) certCheck(ctx context.Context) error {
+	return runner.Tick(ctx, CertCheckPeriod, func() {
+		if err := man.checkCerts(); err != nil {
+			logrus.Errorf("%v", err)
+		}
+	})
+}
+
+// checkCerts renews the certificate of the manager, and rotates the cluster
+// CA once it expires within CARenewBefore
+func (man *volumeManager) checkCerts() error {
+	if err := man.refreshCert(); err != nil {
+		return err
+	}
+	man.certs.Lock()
+	expiring := time.Until(man.certs.caCert.NotAfter) <= CARenewBefore
+	man.certs.Unlock()
+	if !expiring {
+		return nil
+	}
+	if err := man.rotateClusterCA(false); err != nil {
+		if _, ok := errors.Cause(err).(*types.ErrLockHeld); ok {
+			// another manager is rotating it
+			return nil
+		}
+		return err
+	}
+	return man.refreshCert()
+}
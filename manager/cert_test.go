@@ -0,0 +1,215 @@
+package manager
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+// serveTLS serves the manager's TLS at a local address
+func serveTLS(assert *require.Assertions, man *volumeManager) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	go http.Serve(tls.NewListener(l, man.ServerTLSConfig()), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("ok"))
+	}))
+	return l.Addr().String(), func() { l.Close() }
+}
+
+// getTLS makes a request from the manager to the address, on a new
+// connection
+func getTLS(man *volumeManager, address string) error {
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   man.ClientTLSConfig(),
+		DisableKeepAlives: true,
+	}}
+	resp, err := client.Get("https://" + address + "/")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = ioutil.ReadAll(resp.Body)
+	return err
+}
+
+func TestInternalTLS(t *testing.T) {
+	assert := require.New(t)
+
+	enabled, renewBefore, caKey, reloadInterval := InternalTLSEnabled, CARenewBefore, ClusterCAKey, CAReloadInterval
+	defer func() {
+		InternalTLSEnabled, CARenewBefore, ClusterCAKey, CAReloadInterval = enabled, renewBefore, caKey, reloadInterval
+	}()
+	InternalTLSEnabled = false
+	ClusterCAKey = []byte("0123456789abcdef0123456789abcdef")
+	CAReloadInterval = 0
+
+	orc1 := newFakeOrc("host-1", "host-2")
+	man1, _ := newTestManager(orc1)
+	assert.NotNil(man1.RotateClusterCA())
+	assert.False(man1.TLSStatus().Enabled)
+	InternalTLSEnabled = true
+
+	// the managers share the store, the second one uses the CA generated
+	// by the first one
+	orc2 := newFakeOrc("host-2", "host-1")
+	orc2.clusterCA = orc1.clusterCA
+	man2, _ := newTestManager(orc2)
+	for _, orc := range []*fakeOrc{orc1, orc2} {
+		orc.hosts["host-1"].Address = "127.0.0.1:9500"
+		orc.hosts["host-2"].Address = "127.0.0.1:9501"
+	}
+	assert.Nil(man1.refreshCert())
+	ca, err := orc1.GetClusterCA()
+	assert.Nil(err)
+	assert.Nil(man2.refreshCert())
+	ca2, err := orc1.GetClusterCA()
+	assert.Nil(err)
+	assert.Equal(ca, ca2)
+	assert.Equal("", ca.Key)
+	assert.NotEqual("", ca.SealedKey)
+
+	status := man1.TLSStatus()
+	assert.True(status.Enabled)
+	assert.NotEqual("", status.CAExpiry)
+	assert.NotEqual("", status.CertExpiry)
+	assert.Equal("", status.PreviousCAUntil)
+	assert.Equal(status.CAExpiry, man2.TLSStatus().CAExpiry)
+	families := map[string]*types.MetricFamily{}
+	for _, f := range man1.Metrics() {
+		families[f.Name] = f
+	}
+	caExpiry, err := util.ParseTimeZ(status.CAExpiry)
+	assert.Nil(err)
+	assert.Equal([]*types.MetricSample{
+		{Labels: map[string]string{"ca": "current"}, Value: float64(caExpiry.Unix())},
+	}, families[MetricCAExpiry].Samples)
+	certExpiry, err := util.ParseTimeZ(status.CertExpiry)
+	assert.Nil(err)
+	assert.Equal(float64(certExpiry.Unix()), families[MetricCertExpiry].Samples[0].Value)
+
+	addr1, close1 := serveTLS(assert, man1)
+	defer close1()
+	addr2, close2 := serveTLS(assert, man2)
+	defer close2()
+	assert.Nil(getTLS(man2, addr1))
+	assert.Nil(getTLS(man1, addr2))
+
+	// a manager of another cluster is not trusted
+	orc3 := newFakeOrc("host-1")
+	orc3.hosts["host-1"].Address = "127.0.0.1:9500"
+	man3, _ := newTestManager(orc3)
+	assert.Nil(man3.refreshCert())
+	assert.NotNil(getTLS(man3, addr1))
+
+	// after a rotation, host-2 learns the new CA of host-1 on the handshake,
+	// and host-1 still trusts the certificate of host-2 from the previous CA
+	assert.Nil(man1.RotateClusterCA())
+	status = man1.TLSStatus()
+	assert.NotEqual("", status.PreviousCAUntil)
+	for _, f := range man1.Metrics() {
+		families[f.Name] = f
+	}
+	assert.Len(families[MetricCAExpiry].Samples, 2)
+	assert.Nil(getTLS(man2, addr1))
+	assert.Nil(getTLS(man1, addr2))
+	assert.Equal(status.CAExpiry, man2.TLSStatus().CAExpiry)
+
+	// past the transition, host-2 must have renewed its certificate
+	ca, err = orc1.GetClusterCA()
+	assert.Nil(err)
+	ca.PreviousUntil = util.FormatTimeZ(time.Now().Add(-time.Minute))
+	assert.Nil(orc1.SetClusterCA(ca))
+	assert.Nil(man1.reloadClusterCA(nil))
+	assert.NotNil(getTLS(man1, addr2))
+	cert, err := man2.currentCert()
+	assert.Nil(err)
+	assert.Nil(man2.checkCerts())
+	renewed, err := man2.currentCert()
+	assert.Nil(err)
+	assert.NotEqual(cert.Leaf.SerialNumber, renewed.Leaf.SerialNumber)
+	assert.Nil(getTLS(man1, addr2))
+	assert.Equal("", man1.TLSStatus().PreviousCAUntil)
+
+	// the CA is rotated before it expires
+	CARenewBefore = CAValidity + time.Hour
+	assert.Nil(man1.checkCerts())
+	rotated, err := orc1.GetClusterCA()
+	assert.Nil(err)
+	assert.NotEqual(ca.Cert, rotated.Cert)
+	assert.Equal(ca.Cert, rotated.PreviousCert)
+	assert.Nil(getTLS(man2, addr1))
+}
+
+func TestClusterCAKeySealed(t *testing.T) {
+	assert := require.New(t)
+
+	enabled, caKey, reloadInterval := InternalTLSEnabled, ClusterCAKey, CAReloadInterval
+	defer func() {
+		InternalTLSEnabled, ClusterCAKey, CAReloadInterval = enabled, caKey, reloadInterval
+	}()
+	InternalTLSEnabled = true
+	ClusterCAKey = []byte("0123456789abcdef0123456789abcdef")
+	CAReloadInterval = time.Hour
+
+	// the key left in cleartext before the keys were sealed
+	certPEM, keyPEM, err := util.GenerateCA(clusterCACommonName, CAValidity)
+	assert.Nil(err)
+	orc1 := newFakeOrc("host-1", "host-2")
+	assert.Nil(orc1.SetClusterCA(&types.ClusterCA{Cert: certPEM, Key: keyPEM, Created: util.Now()}))
+	man1, _ := newTestManager(orc1)
+	orc2 := newFakeOrc("host-2", "host-1")
+	orc2.clusterCA = orc1.clusterCA
+	man2, _ := newTestManager(orc2)
+	for _, orc := range []*fakeOrc{orc1, orc2} {
+		orc.hosts["host-1"].Address = "127.0.0.1:9500"
+		orc.hosts["host-2"].Address = "127.0.0.1:9501"
+	}
+	assert.Nil(man1.refreshCert())
+	ca, err := orc1.GetClusterCA()
+	assert.Nil(err)
+	assert.Equal(certPEM, ca.Cert)
+	assert.Equal("", ca.Key)
+	opened, err := util.OpenKey(ClusterCAKey, ca.SealedKey)
+	assert.Nil(err)
+	assert.Equal(keyPEM, opened)
+
+	// a manager with another cluster CA key cannot issue its certificate
+	ClusterCAKey = []byte("fedcba9876543210fedcba9876543210")
+	assert.NotNil(man2.refreshCert())
+	ClusterCAKey = []byte("0123456789abcdef0123456789abcdef")
+	assert.Nil(man2.refreshCert())
+
+	addr1, close1 := serveTLS(assert, man1)
+	defer close1()
+
+	// host-2 learns the first rotation on the handshake, but doesn't read
+	// the store again on every handshake after that
+	sameCA := func() bool {
+		man1.certs.Lock()
+		defer man1.certs.Unlock()
+		man2.certs.Lock()
+		defer man2.certs.Unlock()
+		return man1.certs.caCert.Equal(man2.certs.caCert)
+	}
+	assert.Nil(man1.RotateClusterCA())
+	assert.Nil(getTLS(man2, addr1))
+	assert.True(sameCA())
+	assert.Nil(man1.RotateClusterCA())
+	assert.NotNil(getTLS(man2, addr1))
+	assert.False(sameCA())
+
+	// once the interval is over
+	man2.certs.Lock()
+	man2.certs.peerReloaded = time.Now().Add(-CAReloadInterval)
+	man2.certs.Unlock()
+	getTLS(man2, addr1)
+	assert.True(sameCA())
+}
@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const (
+	EventReasonSnapshotsCompacted = "SnapshotsCompacted"
+)
+
+// CompactSnapshots shortens the snapshot chain of a detached volume. The
+// volume is attached on the current host for the time being, its system
+// snapshots are deleted so the purge of the engine coalesces them into
+// their children, then it's detached again. The user snapshots, the
+// protected ones and the snapshots backups are based on are kept.
+func (man *volumeManager) CompactSnapshots(name string) (err error) {
+	lock, err := man.acquireLock("compact-"+name, name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to compact snapshots of volume '%s'", name)
+	}
+	defer lock.release()
+
+	volume, err := man.Get(name)
+	if err != nil {
+		return err
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	if volume.Controller != nil {
+		return errors.Errorf("volume '%s' must be detached to compact its snapshots", name)
+	}
+
+	if err := man.doAttach(volume); err != nil {
+		return errors.Wrapf(err, "fail to attach volume '%s' to compact its snapshots", name)
+	}
+	defer func() {
+		if derr := man.doDetach(volume); derr != nil {
+			derr = errors.Wrapf(derr, "fail to detach volume '%s' after compacting its snapshots", name)
+			if err == nil {
+				err = derr
+			} else {
+				logrus.Errorf("%+v", derr)
+			}
+		}
+	}()
+	ctrl := man.getController(volume)
+	if ctrl == nil {
+		return errors.Errorf("cannot find controller of volume '%s'", name)
+	}
+	if err := lock.Err(); err != nil {
+		return err
+	}
+
+	ops := ctrl.SnapshotOps()
+	pruner := newSnapshotPruner(volume, ops, man.settings, man.getBackups)
+	ss, err := ops.List()
+	if err != nil {
+		return errors.Wrapf(err, "error listing snapshots, volume '%s'", name)
+	}
+	backedUp, err := pruner.backedUpSnapshots()
+	if err != nil {
+		return err
+	}
+	before := snapshotChainLength(ss)
+	for _, s := range ss {
+		if s.Name == volumeHeadName || s.Removed || s.UserCreated ||
+			s.Labels[SnapshotProtectedLabel] == "true" || backedUp[s.Name] {
+			continue
+		}
+		if err := ops.Delete(s.Name); err != nil {
+			return errors.Wrapf(err, "error deleting snapshot '%s', volume '%s'", s.Name, name)
+		}
+	}
+	if err := ops.Purge(); err != nil {
+		return errors.Wrapf(err, "fail to purge snapshots of volume '%s'", name)
+	}
+	if ss, err = ops.List(); err != nil {
+		return errors.Wrapf(err, "error listing snapshots, volume '%s'", name)
+	}
+	after := snapshotChainLength(ss)
+
+	man.events.record(name, types.EventSeverityInfo, EventReasonSnapshotsCompacted,
+		"snapshot chain compacted from %v to %v snapshots", before, after)
+	logrus.Infof("snapshot chain of volume '%s' compacted from %v to %v snapshots", name, before, after)
+	return nil
+}
+
+// snapshotChainLength counts the snapshots below the volume head, including
+// the removed ones not purged yet
+func snapshotChainLength(ss []*types.SnapshotInfo) int {
+	length := 0
+	for _, s := range ss {
+		if s.Name != volumeHeadName {
+			length++
+		}
+	}
+	return length
+}
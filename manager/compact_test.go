@@ -0,0 +1,64 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestCompactSnapshots(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1")
+	orc.settings = &types.SettingsInfo{BackupTarget: "s3://backups"}
+	man, fc := newTestManager(orc)
+	man.getBackups = func(backupTarget string) types.ManagerBackupOps {
+		return &fakeBackupOps{backups: []*types.BackupInfo{{SnapshotName: "system-2"}}}
+	}
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 1, EngineImage: "rancher/longhorn:v0.2.1"})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	ops := newFakeSnapshotOps(time.Now())
+	fc.get(volume).(*fakeController).snapshots = ops
+	for _, name := range []string{"system-0", "user-0", "system-1", "system-2", "system-3", "protected", "user-1"} {
+		labels := map[string]string{}
+		if name == "protected" {
+			labels[SnapshotProtectedLabel] = "true"
+		}
+		_, err := ops.Create(name, labels)
+		assert.Nil(err)
+	}
+	ops.snapshots["user-0"].UserCreated = true
+	ops.snapshots["user-1"].UserCreated = true
+
+	// only detached volumes are compacted
+	assert.NotNil(man.CompactSnapshots("vol"))
+
+	assert.Nil(man.Detach("vol"))
+	assert.Nil(man.CompactSnapshots("vol"))
+
+	// the user snapshots, the protected one and the backup base survive
+	assert.Equal([]string{"protected", "system-2", "user-0", "user-1"}, ops.names())
+	assert.Equal(1, ops.purged)
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Nil(volume.Controller)
+
+	man.events.flush()
+	events, err := orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	found := false
+	for _, e := range events {
+		if e.Reason == EventReasonSnapshotsCompacted {
+			assert.Equal("snapshot chain compacted from 7 to 4 snapshots", e.Message)
+			found = true
+		}
+	}
+	assert.True(found)
+}
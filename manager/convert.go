@@ -0,0 +1,227 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// DefaultConvertedReplicas is the number of replicas of a local volume
+	// converted to replicated, unless given
+	DefaultConvertedReplicas = 2
+)
+
+const (
+	EventReasonConverting = "Converting"
+	EventReasonConverted  = "Converted"
+)
+
+// ConvertVolume starts converting the volume to targetMode. A local volume
+// gets replicas added on other hosts, rebuilt by the controller, and becomes
+// replicated once they are all good. A replicated volume keeps its best
+// replica only, and becomes local on the host of that replica. replicas is
+// the number of replicas of the volume converted to replicated, 0 for
+// DefaultConvertedReplicas. Converting to the target in progress again is a
+// no-op.
+func (man *volumeManager) ConvertVolume(name string, targetMode types.VolumeMode, replicas int) error {
+	if err := ValidateVolumeMode(targetMode); err != nil {
+		return err
+	}
+	volume, err := man.Get(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	if volume.Conversion != nil {
+		if volume.Converting(targetMode) {
+			return nil
+		}
+		return errors.Errorf("volume '%s' is being converted to %v", name, volume.Conversion.TargetMode)
+	}
+	switch {
+	case volume.Mode == targetMode:
+		return errors.Errorf("volume '%s' is %v already", name, targetMode)
+	case volume.State == types.VolumeStateFaulted:
+		return errors.Errorf("cannot convert faulted volume '%s'", name)
+	case man.isRebuilding(name):
+		return errors.Errorf("cannot convert volume '%s' while it's rebuilding", name)
+	case volume.ReplicaPlan.Pending():
+		return errors.Errorf("cannot convert volume '%s' before its replica plan is applied", name)
+	case volume.SalvageRequired:
+		return errors.Errorf("cannot convert volume '%s' requiring salvage", name)
+	}
+
+	conversion := &types.VolumeConversion{
+		TargetMode: targetMode,
+		Started:    util.Now(),
+		Progress:   "started",
+	}
+	if targetMode == types.VolumeModeLocal {
+		if replicas > 1 {
+			return errors.Errorf("local volume must have exactly one replica, not %v", replicas)
+		}
+		if volume.MaxReplicasPerZone != 0 || volume.MinZones != 0 {
+			return errors.Errorf("zone distribution doesn't apply to %v volumes, clear it from volume '%s' first", targetMode, name)
+		}
+		conversion.TargetReplicas = 1
+	} else {
+		if replicas == 0 {
+			replicas = DefaultConvertedReplicas
+		}
+		if replicas < 1 {
+			return errors.Errorf("invalid number of replicas %v", replicas)
+		}
+		conversion.TargetReplicas = replicas
+	}
+
+	v, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	v.Conversion = conversion
+	if err := man.orc.UpdateVolume(v); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", name)
+	}
+	man.events.record(name, types.EventSeverityInfo, EventReasonConverting,
+		"converting from %v to %v with %v replicas", volume.Mode, targetMode, conversion.TargetReplicas)
+	logrus.Infof("converting volume '%s' from %v to %v with %v replicas", name, volume.Mode, targetMode, conversion.TargetReplicas)
+
+	// nothing to rebuild, a detached volume is converted to local right away
+	if volume.Controller == nil && targetMode == types.VolumeModeLocal {
+		return man.convertToLocal(name, nil, nil)
+	}
+	return nil
+}
+
+// convert moves the conversion of the volume on, from the replicas of its
+// controller. It's called by CheckController, once the replicas needed by
+// a conversion to replicated are being added.
+func (man *volumeManager) convert(name string, ctrl types.Controller, good []*types.ReplicaInfo, rebuilding int) error {
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil || volume.Conversion == nil {
+		return nil
+	}
+	conversion := volume.Conversion
+	if rebuilding > 0 {
+		return man.convertProgress(volume, fmt.Sprintf("%v of %v replicas good, %v rebuilding", len(good), conversion.TargetReplicas, rebuilding))
+	}
+	if conversion.TargetMode == types.VolumeModeLocal {
+		return man.convertToLocal(name, ctrl, good)
+	}
+	if len(good) < conversion.TargetReplicas {
+		return man.convertProgress(volume, fmt.Sprintf("%v of %v replicas good", len(good), conversion.TargetReplicas))
+	}
+
+	volume.Mode = types.VolumeModeReplicated
+	volume.NumberOfReplicas = conversion.TargetReplicas
+	volume.PinReplicas = false
+	volume.PreferredHostPinned = false
+	volume.Conversion = nil
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", name)
+	}
+	man.events.record(name, types.EventSeverityInfo, EventReasonConverted, "converted to %v with %v replicas", volume.Mode, volume.NumberOfReplicas)
+	logrus.Infof("volume '%s' converted to %v with %v replicas", name, volume.Mode, volume.NumberOfReplicas)
+	return nil
+}
+
+func (man *volumeManager) convertProgress(volume *types.VolumeInfo, progress string) error {
+	if volume.Conversion.Progress == progress {
+		return nil
+	}
+	conversion := *volume.Conversion
+	conversion.Progress = progress
+	volume.Conversion = &conversion
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", volume.Name)
+	}
+	return nil
+}
+
+// convertToLocal keeps the best replica of the volume and removes the
+// others, from the controller first if there is one. The best replica is a
+// good one on the host of the controller, or else the preferred host.
+func (man *volumeManager) convertToLocal(name string, ctrl types.Controller, good []*types.ReplicaInfo) error {
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	candidates := []*types.ReplicaInfo{}
+	if ctrl == nil {
+		for _, r := range volume.Replicas {
+			if r.BadTimestamp == "" {
+				candidates = append(candidates, r)
+			}
+		}
+	} else {
+		byAddress := map[string]*types.ReplicaInfo{}
+		for _, r := range volume.Replicas {
+			byAddress[r.Address] = r
+		}
+		for _, r := range good {
+			if replica := byAddress[r.Address]; replica != nil && replica.BadTimestamp == "" {
+				candidates = append(candidates, replica)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return errors.Errorf("volume '%s' has no good replica to keep", name)
+	}
+	hostID := volume.PreferredHostID
+	if volume.Controller != nil {
+		hostID = volume.Controller.HostID
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ci, cj := candidates[i].HostID == hostID, candidates[j].HostID == hostID
+		if ci != cj {
+			return ci
+		}
+		pi, pj := candidates[i].HostID == volume.PreferredHostID, candidates[j].HostID == volume.PreferredHostID
+		if pi != pj {
+			return pi
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	keep := candidates[0]
+
+	for _, r := range volume.Replicas {
+		if r.Name == keep.Name {
+			continue
+		}
+		if ctrl != nil && r.BadTimestamp == "" {
+			if err := ctrl.RemoveReplica(r); err != nil {
+				return errors.Wrapf(err, "fail to remove replica '%s' from controller of volume '%s'", r.Name, name)
+			}
+		}
+		if err := man.ReplicaRemove(name, r.Name); err != nil {
+			return err
+		}
+	}
+
+	if volume, err = man.orc.GetVolume(name); err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	volume.Mode = types.VolumeModeLocal
+	volume.NumberOfReplicas = 1
+	volume.PreferredHostID = keep.HostID
+	volume.PreferredHostPinned = true
+	volume.PinReplicas = true
+	volume.Conversion = nil
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", name)
+	}
+	man.events.record(name, types.EventSeverityInfo, EventReasonConverted, "converted to %v, keeping replica %v on host %v", volume.Mode, keep.Name, keep.HostID)
+	logrus.Infof("volume '%s' converted to %v, keeping replica '%s' on host %v", name, volume.Mode, keep.Name, keep.HostID)
+	return nil
+}
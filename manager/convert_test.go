@@ -0,0 +1,139 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// checkAdded runs the check of the controller, which adds a replica, and
+// waits for the replica to be added
+func checkAdded(assert *require.Assertions, man *volumeManager, ctrl *fakeController, name string) *types.ReplicaInfo {
+	volume, err := man.Get(name)
+	assert.Nil(err)
+	assert.Nil(man.CheckController(ctrl, volume))
+	var added *types.ReplicaInfo
+	select {
+	case added = <-ctrl.added:
+	case <-time.After(5 * time.Second):
+		assert.Fail("no replica added")
+	}
+	for i := 0; i < 100 && man.addingReplicasCount(name, 0) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return added
+}
+
+func TestConvertLocalToReplicated(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, Mode: types.VolumeModeLocal})
+	assert.Nil(err)
+	assert.NotNil(man.ConvertVolume("vol", types.VolumeModeLocal, 0))
+	assert.Nil(man.Attach("vol"))
+	assert.Nil(man.ConvertVolume("vol", types.VolumeModeReplicated, 0))
+	assert.Nil(man.ConvertVolume("vol", types.VolumeModeReplicated, 0))
+	assert.NotNil(man.ConvertVolume("vol", types.VolumeModeLocal, 0))
+
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(types.VolumeModeLocal, volume.Mode)
+	assert.Equal(2, volume.Conversion.TargetReplicas)
+	assert.Equal(types.VolumeStateDegraded, volume.State)
+
+	// the replicas are added on other hosts, one at a time
+	ctrl := fc.controllers["vol"]
+	added := checkAdded(assert, man, ctrl, "vol")
+	assert.NotEqual("host-1", added.HostID)
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Nil(man.CheckController(ctrl, volume))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal("1 of 2 replicas good, 1 rebuilding", volume.Conversion.Progress)
+
+	// the conversion resumes after detach and attach
+	assert.Nil(man.Detach("vol"))
+	assert.Nil(man.Attach("vol"))
+	ctrl.Lock()
+	ctrl.replicas[added.Address].Mode = types.ReplicaModeRW
+	ctrl.Unlock()
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Nil(man.CheckController(ctrl, volume))
+
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(types.VolumeModeReplicated, volume.Mode)
+	assert.Equal(2, volume.NumberOfReplicas)
+	assert.Nil(volume.Conversion)
+	assert.False(volume.PinReplicas)
+	assert.Equal(types.VolumeStateHealthy, volume.State)
+
+	man.events.flush()
+	reasons := []string{}
+	events, err := orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	for _, e := range events {
+		if e.Reason == EventReasonConverting || e.Reason == EventReasonConverted {
+			reasons = append(reasons, e.Reason)
+		}
+	}
+	assert.Equal([]string{EventReasonConverting, EventReasonConverted}, reasons)
+}
+
+func TestConvertReplicatedToLocal(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+
+	// a detached volume is converted right away
+	_, err := man.Create(&types.VolumeInfo{Name: "detached", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.NotNil(man.ConvertVolume("detached", types.VolumeModeLocal, 2))
+	assert.Nil(man.ConvertVolume("detached", types.VolumeModeLocal, 0))
+	volume, err := man.Get("detached")
+	assert.Nil(err)
+	assert.Equal(types.VolumeModeLocal, volume.Mode)
+	assert.Nil(volume.Conversion)
+	assert.Len(volume.Replicas, 1)
+	for _, r := range volume.Replicas {
+		assert.Equal(r.HostID, volume.PreferredHostID)
+	}
+
+	// an attached volume keeps the replica on the host of the controller
+	_, err = man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	assert.Nil(man.ConvertVolume("vol", types.VolumeModeLocal, 0))
+	ctrl := fc.controllers["vol"]
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Nil(man.CheckController(ctrl, volume))
+
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(types.VolumeModeLocal, volume.Mode)
+	assert.Equal(1, volume.NumberOfReplicas)
+	assert.Equal("host-1", volume.PreferredHostID)
+	assert.True(volume.PinReplicas)
+	assert.Len(volume.Replicas, 1)
+	assert.Len(ctrl.removed, 1)
+	for _, r := range volume.Replicas {
+		assert.Equal("host-1", r.HostID)
+	}
+	assert.Equal(types.VolumeStateHealthy, volume.State)
+
+	// no conversion of a faulted volume
+	for _, r := range orc.volumes["vol"].Replicas {
+		r.BadTimestamp = "2017-01-01T00:00:00Z"
+	}
+	assert.NotNil(man.ConvertVolume("vol", types.VolumeModeReplicated, 0))
+}
@@ -30,11 +30,12 @@ var tasks = map[string]taskCons{
 type jobRunner struct {
 	volume   *types.VolumeInfo
 	ctrl     types.Controller
+	man      types.VolumeManager
 	settings types.Settings
 }
 
-func newJobRunner(volume *types.VolumeInfo, ctrl types.Controller, settings types.Settings) *jobRunner {
-	return &jobRunner{volume: volume, ctrl: ctrl, settings: settings}
+func newJobRunner(volume *types.VolumeInfo, ctrl types.Controller, man types.VolumeManager) *jobRunner {
+	return &jobRunner{volume: volume, ctrl: ctrl, man: man, settings: man.Settings()}
 }
 
 type cronUpdate []*types.RecurringJob
@@ -43,8 +44,8 @@ func CronUpdate(jobs []*types.RecurringJob) types.Event {
 	return cronUpdate(jobs)
 }
 
-func RunJobs(volume *types.VolumeInfo, ctrl types.Controller, settings types.Settings, ch chan types.Event) {
-	runner := newJobRunner(volume, ctrl, settings)
+func RunJobs(volume *types.VolumeInfo, ctrl types.Controller, man types.VolumeManager, ch chan types.Event) {
+	runner := newJobRunner(volume, ctrl, man)
 
 	c := runner.setJobs(volume.RecurringJobs)
 	if c == nil {
@@ -100,9 +101,21 @@ func (runner *jobRunner) setJobs(jobs []*types.RecurringJob) *cron.Cron {
 			logrus.Infof("scheduled recurring job %+v, volume '%s'", job, runner.volume.Name)
 		}
 	}
+	c.AddFunc(SnapshotAgePruneSchedule, runner.pruneSnapshots)
 	return c
 }
 
+func (runner *jobRunner) snapshotOps() types.SnapshotOps {
+	return newPrunedSnapshotOps(runner.volume, runner.ctrl.SnapshotOps(), runner.settings, backups.New)
+}
+
+func (runner *jobRunner) pruneSnapshots() {
+	pruner := newSnapshotPruner(runner.volume, runner.ctrl.SnapshotOps(), runner.settings, backups.New)
+	if err := pruner.PruneAge(); err != nil {
+		logrus.Errorf("%+v", errors.Wrapf(err, "error pruning snapshots by age, volume '%s'", runner.volume.Name))
+	}
+}
+
 func snapName(name string) string {
 	return name + "-" + util.FormatTimeZ(time.Now()) + "-" + util.RandomID()
 }
@@ -137,7 +150,7 @@ func SnapshotTask(runner *jobRunner, job *types.RecurringJob, _ *types.SettingsI
 func (st *snapshotTask) Run() error {
 	name := snapName(st.job.Name)
 	logrus.Infof("recurring job: snapshot '%s', volume '%s'", name, st.runner.volume.Name)
-	if _, err := st.runner.ctrl.SnapshotOps().Create(name, map[string]string{JobName: st.job.Name}); err != nil {
+	if _, err := st.runner.snapshotOps().Create(name, map[string]string{JobName: st.job.Name}); err != nil {
 		return errors.Wrapf(err, "error running recurring job: snapshot '%s', volume '%s'", name, st.runner.volume.Name)
 	}
 	return st.cleanup()
@@ -198,13 +211,15 @@ func (st *snapshotTask) cleanup() error {
 }
 
 func BackupTask(runner *jobRunner, job *types.RecurringJob, si *types.SettingsInfo) Task {
-	return &backupTask{runner: runner, job: job, backupTarget: si.BackupTarget}
+	backupTarget, err := backups.ResolveTarget(si.BackupTarget, runner.volume)
+	return &backupTask{runner: runner, job: job, backupTarget: backupTarget, targetErr: err}
 }
 
 type backupTask struct {
 	sync.Mutex
 
 	backupTarget string
+	targetErr    error
 
 	runner *jobRunner
 	job    *types.RecurringJob
@@ -217,16 +232,18 @@ type backupTask struct {
 }
 
 func (bt *backupTask) Run() error {
+	if bt.targetErr != nil {
+		return errors.Wrapf(bt.targetErr, "error resolving backup target for recurring backup, volume '%s'", bt.runner.volume.Name)
+	}
 	name := snapName(bt.job.Name)
-	if _, err := bt.runner.ctrl.SnapshotOps().Create(name, map[string]string{JobName: bt.job.Name, BackupJob: bt.job.Name}); err != nil {
+	if _, err := bt.runner.snapshotOps().Create(name, map[string]string{JobName: bt.job.Name, BackupJob: bt.job.Name}); err != nil {
 		return errors.Wrapf(err, "error creating snapshot for recurring backup '%s', volume '%s'", name, bt.runner.volume.Name)
 	}
-	bt.runner.ctrl.BgTaskQueue().Put(&types.BgTask{Task: types.BackupBgTask{
+	return bt.runner.man.StartBackup(bt.runner.volume.Name, &types.BackupBgTask{
 		Snapshot:     name,
 		BackupTarget: bt.backupTarget,
 		CleanupHook:  bt.cleanup,
-	}})
-	return nil
+	})
 }
 
 func (bt *backupTask) filterSnapshots(l []*types.SnapshotInfo) []*types.SnapshotInfo {
@@ -249,6 +266,24 @@ func (bt *backupTask) filterBackups(l []*types.BackupInfo) []*types.BackupInfo {
 	return r
 }
 
+// untrackedBackups leaves out the backups tracked by standby volumes, they
+// are neither counted nor removed by the retention
+func (bt *backupTask) untrackedBackups(l []*types.BackupInfo) ([]*types.BackupInfo, error) {
+	tracked, err := trackedBackups(bt.runner.man)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error checking tracked backups, volume '%s'", bt.runner.volume.Name)
+	}
+	r := []*types.BackupInfo{}
+	for _, b := range l {
+		if standby, ok := tracked[backupKey(b.URL)]; ok {
+			logrus.Infof("recurring job cleanup: keeping backup '%s' tracked by standby volume '%s'", b.URL, standby)
+			continue
+		}
+		r = append(r, b)
+	}
+	return r, nil
+}
+
 func (bt *backupTask) listSnapshots() ([]*types.SnapshotInfo, error) {
 	ss, err := bt.runner.ctrl.SnapshotOps().List()
 	if err != nil {
@@ -266,6 +301,10 @@ func (bt *backupTask) listBackups() ([]*types.BackupInfo, error) {
 		return nil, errors.Wrapf(err, "error listing backups, volume '%s'", bt.runner.volume.Name)
 	}
 	bs = bt.filterBackups(bs)
+	bs, err = bt.untrackedBackups(bs)
+	if err != nil {
+		return nil, err
+	}
 	sort.Slice(bs, func(i, j int) bool { return bs[i].Created < bs[j].Created })
 	return bs, nil
 }
@@ -0,0 +1,515 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+// DrainStopDeadline is how long a drain is waited for on shutdown, to finish
+// the migration in progress
+var DrainStopDeadline = time.Minute
+
+func copyDrainProgress(p *types.DrainProgress) *types.DrainProgress {
+	if p == nil {
+		return nil
+	}
+	progress := *p
+	progress.Pending = append([]string{}, p.Pending...)
+	progress.ManualMigration = append([]string{}, p.ManualMigration...)
+	progress.Pinned = append([]string{}, p.Pinned...)
+	return &progress
+}
+
+// setDrainProgress records the progress in the store, a failure is only
+// logged and the drain goes on
+func (man *volumeManager) setDrainProgress(progress *types.DrainProgress) {
+	if err := man.orc.SetDrainProgress(copyDrainProgress(progress)); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "fail to record drain progress of host %v", progress.HostID))
+	}
+}
+
+// GetDrainProgress returns the progress of the last drain of the host, from
+// any manager. A drain running without holding its lock any more lost its
+// manager, it's reported interrupted.
+func (man *volumeManager) GetDrainProgress(id string) (*types.DrainProgress, error) {
+	progress, err := man.orc.GetDrainProgress(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get drain progress of host %v", id)
+	}
+	if progress == nil || !progress.Running {
+		return progress, nil
+	}
+	locks, err := man.orc.ListLocks()
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get drain progress of host %v", id)
+	}
+	for _, lock := range locks {
+		if lock.Name == drainLockName(id) && lock.OperationID == progress.JobID {
+			return progress, nil
+		}
+	}
+	progress.Running = false
+	progress.Interrupted = true
+	return progress, nil
+}
+
+func drainLockName(id string) string {
+	return "drain-" + id
+}
+
+func (man *volumeManager) UpdateHostSchedulable(id string, schedulable bool) error {
+	if err := man.orc.SetHostSchedulable(id, schedulable); err != nil {
+		return errors.Wrapf(err, "unable to update host %v", id)
+	}
+	logrus.Infof("host %v schedulable: %v", id, schedulable)
+	return nil
+}
+
+func (man *volumeManager) UpdateHostFailureDomain(id, domain string) error {
+	if err := man.orc.SetHostFailureDomain(id, domain); err != nil {
+		return errors.Wrapf(err, "unable to update host %v", id)
+	}
+	logrus.Infof("host %v failure domain: %q", id, domain)
+	return nil
+}
+
+func (man *volumeManager) UpdateHostSchedulingWeight(id string, weight int) error {
+	if err := ValidateSchedulingWeight(weight); err != nil {
+		return errors.Wrapf(err, "unable to update host %v", id)
+	}
+	if err := man.orc.SetHostSchedulingWeight(id, weight); err != nil {
+		return errors.Wrapf(err, "unable to update host %v", id)
+	}
+	logrus.Infof("host %v scheduling weight: %v", id, weight)
+	return nil
+}
+
+func (man *volumeManager) UpdateHostRole(id string, role types.HostRole) error {
+	if !role.Valid() {
+		return errors.Errorf("unable to update host %v: invalid role %q, expecting one of %v", id, role, types.HostRoles)
+	}
+	if err := man.orc.SetHostRole(id, role); err != nil {
+		return errors.Wrapf(err, "unable to update host %v", id)
+	}
+	logrus.Infof("host %v role: %q", id, role)
+	return nil
+}
+
+// ResolveHostConflict asks the machine with the nonce, among those
+// heartbeating the same host UUID, to register again with a new UUID
+func (man *volumeManager) ResolveHostConflict(id, nonce string) error {
+	host, err := man.orc.GetHost(id)
+	if err != nil {
+		return errors.Wrapf(err, "fail to get host %v", id)
+	}
+	if host == nil {
+		return errors.Errorf("cannot find host %v", id)
+	}
+	if !host.Conflicted {
+		return errors.Errorf("host %v is not conflicted", id)
+	}
+	found := false
+	for _, n := range host.ConflictNonces {
+		found = found || n == nonce
+	}
+	if !found {
+		return errors.Errorf("nonce %v is not one of the conflicting nonces %v of host %v", nonce, host.ConflictNonces, id)
+	}
+	if err := man.orc.SetHostRegenerateNonce(id, nonce); err != nil {
+		return errors.Wrapf(err, "unable to update host %v", id)
+	}
+	logrus.Warnf("host %v: the machine with nonce %v will register again", id, nonce)
+	return nil
+}
+
+// hostDrain is a drain started, with the replicas to migrate in order
+type hostDrain struct {
+	progress *types.DrainProgress
+	replicas []*types.ReplicaInfo
+	end      time.Time
+	lock     *operationLock
+}
+
+// DrainHost marks the host unschedulable and starts migrating the good
+// replicas on it to other hosts in the background, one at a time. The
+// progress is kept in the store, so any manager can report it. No new
+// migration is started after the deadline, the replicas left are reported as
+// pending. The host stays unschedulable afterwards, even if the drain is
+// partial. The local volumes on the host are left alone and reported for
+// manual migration, and so are the volumes with their replicas pinned. The
+// drain holds the lock drain-<id>, and stops like at the deadline if the lock
+// is broken or the manager shuts down.
+func (man *volumeManager) DrainHost(id string, deadline time.Duration) (*types.DrainProgress, error) {
+	drain, err := man.startDrain(id, deadline)
+	if err != nil {
+		return nil, err
+	}
+	if err := man.goDrain(drain, nil); err != nil {
+		return nil, err
+	}
+	return copyDrainProgress(drain.progress), nil
+}
+
+// goDrain runs the drain as a runner of the manager, so the shutdown stops
+// it before the next migration and waits for it. The final progress is sent
+// to done, unless nil. If the manager is shutting down, the drain ends at
+// once with all its replicas pending.
+func (man *volumeManager) goDrain(drain *hostDrain, done chan<- *types.DrainProgress) error {
+	ran := false
+	started := man.runners.Go(runner.Runner{
+		Name: "drain-" + drain.progress.HostID,
+		Run: func(ctx context.Context) error {
+			// a drain which crashed isn't run again, it released its
+			// lock
+			if ran {
+				return nil
+			}
+			ran = true
+			progress := man.runDrain(ctx, drain)
+			if done != nil {
+				done <- progress
+			}
+			return nil
+		},
+		StopDeadline: DrainStopDeadline,
+	})
+	if !started {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		man.runDrain(ctx, drain)
+		return errors.Errorf("cannot drain host %v, the manager is shutting down", drain.progress.HostID)
+	}
+	return nil
+}
+
+// startDrain takes the lock, marks the host unschedulable and lists the
+// replicas to migrate. The lock is held until runDrain is done.
+func (man *volumeManager) startDrain(id string, deadline time.Duration) (*hostDrain, error) {
+	host, err := man.orc.GetHost(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get host %v", id)
+	}
+	if host == nil {
+		return nil, errors.Errorf("cannot find host %v", id)
+	}
+	lock, err := man.acquireLock(drainLockName(id), "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to lock host %v for draining", id)
+	}
+	drain, err := man.listDrain(id)
+	if err != nil {
+		lock.release()
+		return nil, err
+	}
+	drain.lock = lock
+	drain.progress.JobID = lock.operationID
+	if deadline > 0 {
+		drain.end = time.Now().Add(deadline)
+		drain.progress.Deadline = util.FormatTimeZ(drain.end)
+	}
+	man.setDrainProgress(drain.progress)
+	logrus.Infof("draining host %v: %v replicas, deadline %v", id, drain.progress.Total, deadline)
+	if len(drain.progress.ManualMigration) != 0 {
+		logrus.Warnf("draining host %v: local volumes %v require manual migration", id, drain.progress.ManualMigration)
+	}
+	if len(drain.progress.Pinned) != 0 {
+		logrus.Warnf("draining host %v: volumes %v have their replicas pinned, skipped", id, drain.progress.Pinned)
+	}
+	return drain, nil
+}
+
+func (man *volumeManager) listDrain(id string) (*hostDrain, error) {
+	if err := man.UpdateHostSchedulable(id, false); err != nil {
+		return nil, err
+	}
+	volumes, err := man.List()
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to list volumes for draining host %v", id)
+	}
+	replicas := []*types.ReplicaInfo{}
+	manual := []string{}
+	pinned := []string{}
+	for _, volume := range volumes {
+		if volume.Mode == types.VolumeModeLocal {
+			if volumeOnHost(volume, id) {
+				manual = append(manual, volume.Name)
+			}
+			continue
+		}
+		if volume.PinReplicas {
+			if volumeOnHost(volume, id) {
+				pinned = append(pinned, volume.Name)
+			}
+			continue
+		}
+		for _, replica := range volume.Replicas {
+			if replica.HostID == id && replica.BadTimestamp == "" {
+				replicas = append(replicas, replica)
+			}
+		}
+	}
+	return &hostDrain{
+		progress: &types.DrainProgress{
+			HostID:    id,
+			DrainerID: man.orc.GetCurrentHostID(),
+			Total:     len(replicas),
+			Pending:   progressNames(replicas),
+			Running:   true,
+
+			ManualMigration: manual,
+			Pinned:          pinned,
+		},
+		replicas: replicas,
+	}, nil
+}
+
+// runDrain migrates the replicas of the drain until ctx is done and releases
+// its lock, it returns the final progress
+func (man *volumeManager) runDrain(ctx context.Context, drain *hostDrain) *types.DrainProgress {
+	defer drain.lock.release()
+
+	id := drain.progress.HostID
+	progress := copyDrainProgress(drain.progress)
+	replicas := drain.replicas
+	pending := []string{}
+	for i, replica := range replicas {
+		if err := ctx.Err(); err != nil {
+			pending = append(pending, progressNames(replicas[i:])...)
+			logrus.Warnf("draining host %v stopped, %v replicas pending", id, len(replicas)-i)
+			break
+		}
+		if !drain.end.IsZero() && time.Now().After(drain.end) {
+			pending = append(pending, progressNames(replicas[i:])...)
+			logrus.Warnf("draining host %v: deadline passed, %v replicas pending", id, len(replicas)-i)
+			break
+		}
+		if err := drain.lock.Err(); err != nil {
+			pending = append(pending, progressNames(replicas[i:])...)
+			logrus.Warnf("draining host %v: %v, %v replicas pending", id, err, len(replicas)-i)
+			break
+		}
+		if err := man.migrateReplica(replica); err != nil {
+			logrus.Errorf("%+v", errors.Wrapf(err, "draining host %v: fail to migrate replica '%s'", id, replica.Name))
+			pending = append(pending, replica.Name)
+		} else {
+			progress.Migrated++
+		}
+		progress.Pending = append(append([]string{}, pending...), progressNames(replicas[i+1:])...)
+		man.setDrainProgress(progress)
+	}
+	progress.Pending = pending
+	progress.Running = false
+	man.setDrainProgress(progress)
+	logrus.Infof("drained host %v: %v of %v replicas migrated", id, progress.Migrated, progress.Total)
+	return progress
+}
+
+func progressNames(replicas []*types.ReplicaInfo) []string {
+	names := []string{}
+	for _, r := range replicas {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+// migrateReplica replaces the replica with a new one scheduled elsewhere. For
+// an attached volume the new replica is rebuilt before the old one is
+// removed, which has to be done on the host of the controller. A detached
+// volume only loses the replica if it has other good ones, the missing
+// replica will be rebuilt on the next attach.
+func (man *volumeManager) migrateReplica(replica *types.ReplicaInfo) error {
+	volume, err := man.Get(replica.VolumeName)
+	if err != nil {
+		return err
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", replica.VolumeName)
+	}
+	if err := checkReplicasPinned(volume, replica); err != nil {
+		return err
+	}
+
+	if volume.Controller == nil {
+		for _, r := range volume.Replicas {
+			if r.Name != replica.Name && r.HostID != replica.HostID && r.BadTimestamp == "" {
+				return man.ReplicaRemove(volume.Name, replica.Name)
+			}
+		}
+		return errors.Errorf("replica '%s' is the only good replica of detached volume '%s'", replica.Name, volume.Name)
+	}
+
+	if volume.Controller.HostID == replica.HostID {
+		return errors.Errorf("controller of volume '%s' runs on the host, detach it first", volume.Name)
+	}
+	if volume.Controller.HostID != man.orc.GetCurrentHostID() {
+		host, err := man.orc.GetHost(volume.Controller.HostID)
+		if err != nil {
+			return errors.Wrapf(err, "fail to get host %v of the controller of volume '%s'", volume.Controller.HostID, volume.Name)
+		}
+		if host == nil {
+			return errors.Errorf("cannot find host %v of the controller of volume '%s'", volume.Controller.HostID, volume.Name)
+		}
+		if err := volumeAction(host.Address, volume.Name, "replicaMigrate", map[string]string{"name": replica.Name}, nil); err != nil {
+			return errors.Wrapf(err, "fail to migrate replica '%s' on host %v of the controller of volume '%s'",
+				replica.Name, volume.Controller.HostID, volume.Name)
+		}
+		return nil
+	}
+	return man.replaceReplica(volume, replica)
+}
+
+// MigrateReplica migrates the replica of the volume attached here, for the
+// drains run elsewhere
+func (man *volumeManager) MigrateReplica(volumeName, replicaName string) error {
+	volume, err := man.Get(volumeName)
+	if err != nil {
+		return err
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", volumeName)
+	}
+	replica := volume.Replicas[replicaName]
+	if replica == nil {
+		return errors.Errorf("cannot find replica '%s' of volume '%s'", replicaName, volumeName)
+	}
+	if volume.Controller == nil || volume.Controller.HostID != man.orc.GetCurrentHostID() {
+		return errors.Errorf("controller of volume '%s' doesn't run on the current host", volumeName)
+	}
+	if volume.Controller.HostID == replica.HostID {
+		return errors.Errorf("controller of volume '%s' runs on the host, detach it first", volume.Name)
+	}
+	return man.replaceReplica(volume, replica)
+}
+
+// checkReplicasPinned refuses the migration of the replica of a volume with
+// its replicas pinned, with a warning. The failed replicas of the volume are
+// still rebuilt, which isn't a migration.
+func checkReplicasPinned(volume *types.VolumeInfo, replica *types.ReplicaInfo) error {
+	if !volume.PinReplicas {
+		return nil
+	}
+	logrus.Warnf("replica '%s' of volume '%s' not migrated, the replicas are pinned", replica.Name, volume.Name)
+	return &types.ErrReplicasPinned{VolumeName: volume.Name}
+}
+
+// replaceReplica adds a new replica scheduled elsewhere to the running
+// controller of the volume, on the current host, and removes the replica
+// once the new one is rebuilt
+func (man *volumeManager) replaceReplica(volume *types.VolumeInfo, replica *types.ReplicaInfo) error {
+	if err := checkReplicasPinned(volume, replica); err != nil {
+		return err
+	}
+	ctrl := man.getController(volume)
+	if ctrl == nil {
+		return errors.Errorf("cannot find controller of volume '%s'", volume.Name)
+	}
+
+	created, err := man.orc.CreateReplica(volume.Name, man.GetReplicaName(volume.Name))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create a replica for volume '%s'", volume.Name)
+	}
+	instance, err := man.orc.StartInstance(&created.InstanceInfo)
+	if err != nil {
+		if _, err := man.orc.RemoveInstance(&created.InstanceInfo); err != nil {
+			logrus.Errorf("%+v", errors.Wrapf(err, "failed to remove stale replica '%s' of volume '%s'", created.Name, volume.Name))
+		}
+		return errors.Wrapf(err, "failed to start replica %v for volume '%s'", created.Name, volume.Name)
+	}
+	created.InstanceInfo = *instance
+
+	man.addingReplicasCount(volume.Name, 1)
+	err = ctrl.AddReplica(created)
+	man.addingReplicasCount(volume.Name, -1)
+	if err != nil {
+		if _, err := man.orc.StopInstance(&created.InstanceInfo); err != nil {
+			logrus.Errorf("%+v", errors.Wrapf(err, "failed to stop stale replica '%s' of volume '%s'", created.Name, volume.Name))
+		}
+		if _, err := man.orc.RemoveInstance(&created.InstanceInfo); err != nil {
+			logrus.Errorf("%+v", errors.Wrapf(err, "failed to remove stale replica '%s' of volume '%s'", created.Name, volume.Name))
+		}
+		return errors.Wrapf(err, "failed to add replica '%s' to volume '%s'", created.Name, volume.Name)
+	}
+
+	if err := ctrl.RemoveReplica(replica); err != nil {
+		return errors.Wrapf(err, "fail to remove replica '%s' from controller of volume '%s'", replica.Name, volume.Name)
+	}
+	logrus.Infof("migrated replica '%s' of volume '%s' to '%s' on host %v", replica.Name, volume.Name, created.Name, created.HostID)
+	return man.ReplicaRemove(volume.Name, replica.Name)
+}
+
+// EvacuateCurrentHost prepares the current host for going away for good. It
+// marks the host unschedulable, rebuilds the replicas here of the volumes
+// attached here on other hosts and detaches these volumes, then drains the
+// host. Only once nothing is left here the host is deregistered, otherwise
+// the error tells what is left. No new migration is started after the
+// deadline of ctx.
+func (man *volumeManager) EvacuateCurrentHost(ctx context.Context) error {
+	id := man.orc.GetCurrentHostID()
+	if err := man.UpdateHostSchedulable(id, false); err != nil {
+		return err
+	}
+	volumes, err := man.List()
+	if err != nil {
+		return errors.Wrapf(err, "fail to list volumes for evacuating host %v", id)
+	}
+	for _, volume := range volumes {
+		if volume.Controller == nil || volume.Controller.HostID != id {
+			continue
+		}
+		if volume.Mode != types.VolumeModeLocal {
+			for _, replica := range volume.Replicas {
+				if replica.HostID != id || replica.BadTimestamp != "" {
+					continue
+				}
+				if err := ctx.Err(); err != nil {
+					return errors.Wrapf(err, "evacuating host %v stopped", id)
+				}
+				if err := man.replaceReplica(volume, replica); err != nil {
+					return errors.Wrapf(err, "evacuating host %v: fail to migrate replica '%s'", id, replica.Name)
+				}
+			}
+		}
+		if err := man.Detach(volume.Name); err != nil {
+			return errors.Wrapf(err, "evacuating host %v: fail to detach volume '%s'", id, volume.Name)
+		}
+		logrus.Infof("evacuating host %v: detached volume '%s'", id, volume.Name)
+	}
+
+	var deadline time.Duration
+	if end, ok := ctx.Deadline(); ok {
+		if deadline = end.Sub(time.Now()); deadline <= 0 {
+			return errors.Wrapf(context.DeadlineExceeded, "evacuating host %v stopped", id)
+		}
+	}
+	drain, err := man.startDrain(id, deadline)
+	if err != nil {
+		return errors.Wrapf(err, "fail to evacuate host %v", id)
+	}
+	done := make(chan *types.DrainProgress, 1)
+	if err := man.goDrain(drain, done); err != nil {
+		return errors.Wrapf(err, "fail to evacuate host %v", id)
+	}
+	var progress *types.DrainProgress
+	select {
+	case progress = <-done:
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "evacuating host %v stopped, the drain goes on", id)
+	}
+	if len(progress.Pending) != 0 || len(progress.ManualMigration) != 0 || len(progress.Pinned) != 0 {
+		return errors.Errorf("host %v not evacuated: replicas %v pending, local volumes %v require manual migration, volumes %v have their replicas pinned",
+			id, progress.Pending, progress.ManualMigration, progress.Pinned)
+	}
+
+	if err := man.deregister(); err != nil {
+		return errors.Wrapf(err, "fail to deregister evacuated host %v", id)
+	}
+	logrus.Infof("evacuated host %v: %v replicas migrated, deregistered", id, progress.Migrated)
+	return nil
+}
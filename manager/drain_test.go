@@ -0,0 +1,319 @@
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// waitDrain waits for the drain of the host to finish and returns its
+// progress
+func waitDrain(t *testing.T, man *volumeManager, id string) *types.DrainProgress {
+	assert := require.New(t)
+	for i := 0; i < 500; i++ {
+		progress, err := man.GetDrainProgress(id)
+		assert.Nil(err)
+		if progress != nil && !progress.Running {
+			return progress
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.FailNow("drain not finished", "host %v", id)
+	return nil
+}
+
+func TestDrainHostDeadline(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+
+	// fake scheduling puts the replicas on host-1 and host-2
+	for _, name := range []string{"vol-1", "vol-2"} {
+		volume, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2})
+		assert.Nil(err)
+		assert.Nil(man.Attach(volume.Name))
+		_, err = man.Controller(volume.Name)
+		assert.Nil(err)
+		fc.controllers[volume.Name].addDelay = 200 * time.Millisecond
+	}
+
+	// the drain goes on in the background, the first migration outlasts
+	// the deadline and the second is never started
+	progress, err := man.DrainHost("host-2", 100*time.Millisecond)
+	assert.Nil(err)
+	assert.True(progress.Running)
+	assert.Equal(2, progress.Total)
+	assert.Len(progress.Pending, 2)
+	assert.Equal("host-1", progress.DrainerID)
+	assert.NotEqual("", progress.JobID)
+	jobID := progress.JobID
+	progress = waitDrain(t, man, "host-2")
+	assert.Equal(jobID, progress.JobID)
+	assert.Equal(1, progress.Migrated)
+	assert.Len(progress.Pending, 1)
+	assert.False(progress.Interrupted)
+
+	host, err := man.GetHost("host-2")
+	assert.Nil(err)
+	assert.True(host.Unschedulable)
+
+	onHost := 0
+	volumes, err := man.List()
+	assert.Nil(err)
+	for _, volume := range volumes {
+		assert.Len(volume.Replicas, 2)
+		if volumeOnHost(volume, "host-2") {
+			onHost++
+			assert.Contains(volume.Replicas, progress.Pending[0])
+		} else {
+			assert.True(volumeOnHost(volume, "host-3"))
+		}
+	}
+	assert.Equal(1, onHost)
+
+	// without a deadline the rest is migrated, never onto the drained host
+	_, err = man.DrainHost("host-2", 0)
+	assert.Nil(err)
+	progress = waitDrain(t, man, "host-2")
+	assert.NotEqual(jobID, progress.JobID)
+	assert.Equal(1, progress.Total)
+	assert.Equal(1, progress.Migrated)
+	assert.Len(progress.Pending, 0)
+	volumes, err = man.List()
+	assert.Nil(err)
+	for _, volume := range volumes {
+		assert.False(volumeOnHost(volume, "host-2"))
+	}
+
+	assert.Nil(man.UpdateHostSchedulable("host-2", true))
+	host, err = man.GetHost("host-2")
+	assert.Nil(err)
+	assert.False(host.Unschedulable)
+}
+
+func TestDrainHostShutdown(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+	for _, name := range []string{"vol-1", "vol-2"} {
+		volume, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2})
+		assert.Nil(err)
+		assert.Nil(man.Attach(volume.Name))
+		_, err = man.Controller(volume.Name)
+		assert.Nil(err)
+		fc.controllers[volume.Name].addDelay = 200 * time.Millisecond
+	}
+
+	// the shutdown stops the drain after the migration in progress, and
+	// waits for it
+	_, err := man.DrainHost("host-2", 0)
+	assert.Nil(err)
+	time.Sleep(50 * time.Millisecond)
+	man.Shutdown()
+	progress, err := man.GetDrainProgress("host-2")
+	assert.Nil(err)
+	assert.False(progress.Running)
+	assert.Equal(1, progress.Migrated)
+	assert.Len(progress.Pending, 1)
+	for _, status := range man.RunnerStatus() {
+		assert.False(status.Running, status.Name)
+	}
+	locks, err := orc.ListLocks()
+	assert.Nil(err)
+	assert.Len(locks, 0)
+
+	// no drain once shut down
+	_, err = man.DrainHost("host-2", 0)
+	assert.NotNil(err)
+	progress, err = man.GetDrainProgress("host-2")
+	assert.Nil(err)
+	assert.False(progress.Running)
+	assert.Len(progress.Pending, 1)
+	locks, err = orc.ListLocks()
+	assert.Nil(err)
+	assert.Len(locks, 0)
+}
+
+func TestEvacuateCurrentHost(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, _ := newTestManager(orc)
+
+	// fake scheduling puts the replicas on host-1 and host-2
+	_, err := man.Create(&types.VolumeInfo{Name: "vol-1", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol-1"))
+	_, err = man.Create(&types.VolumeInfo{Name: "vol-2", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+
+	// no time left, nothing is moved
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	assert.NotNil(man.EvacuateCurrentHost(ctx))
+	host, err := man.GetHost("host-1")
+	assert.Nil(err)
+	assert.True(host.Unschedulable)
+	volume, err := man.Get("vol-1")
+	assert.Nil(err)
+	assert.True(volumeOnHost(volume, "host-1"))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	assert.Nil(man.EvacuateCurrentHost(ctx))
+
+	// the attached volume got its replica rebuilt elsewhere before detaching
+	volume, err = man.Get("vol-1")
+	assert.Nil(err)
+	assert.Nil(volume.Controller)
+	assert.Len(volume.Replicas, 2)
+	assert.False(volumeOnHost(volume, "host-1"))
+	assert.True(volumeOnHost(volume, "host-3"))
+
+	volume, err = man.Get("vol-2")
+	assert.Nil(err)
+	assert.False(volumeOnHost(volume, "host-1"))
+
+	host, err = man.GetHost("host-1")
+	assert.Nil(err)
+	assert.Nil(host)
+	assert.Nil(man.beat())
+	host, err = man.GetHost("host-1")
+	assert.Nil(err)
+	assert.Nil(host)
+}
+
+func TestDrainSkipsPinnedVolumes(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+
+	// fake scheduling puts the replicas on host-1 and host-2
+	for _, name := range []string{"vol-1", "vol-2"} {
+		_, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2})
+		assert.Nil(err)
+		assert.Nil(man.Attach(name))
+	}
+	assert.Nil(man.UpdatePinReplicas("vol-2", true))
+
+	_, err := man.DrainHost("host-2", 0)
+	assert.Nil(err)
+	progress := waitDrain(t, man, "host-2")
+	assert.Equal(1, progress.Total)
+	assert.Equal(1, progress.Migrated)
+	assert.Equal([]string{"vol-2"}, progress.Pinned)
+	volume, err := man.Get("vol-1")
+	assert.Nil(err)
+	assert.False(volumeOnHost(volume, "host-2"))
+	volume, err = man.Get("vol-2")
+	assert.Nil(err)
+	assert.True(volumeOnHost(volume, "host-2"))
+
+	// nor migrated any other way
+	for _, replica := range volume.Replicas {
+		err := man.migrateReplica(replica)
+		_, ok := errors.Cause(err).(*types.ErrReplicasPinned)
+		assert.True(ok, "%v", err)
+		err = man.replaceReplica(volume, replica)
+		_, ok = errors.Cause(err).(*types.ErrReplicasPinned)
+		assert.True(ok, "%v", err)
+	}
+
+	// but still rebuilt on failure
+	ctrl := fc.controllers["vol-2"]
+	ctrl.Lock()
+	for _, replica := range ctrl.replicas {
+		if replica.HostID == "host-2" {
+			replica.Mode = types.ReplicaModeERR
+		}
+	}
+	ctrl.Unlock()
+	assert.Nil(man.CheckController(ctrl, volume))
+	select {
+	case <-ctrl.added:
+	case <-time.After(5 * time.Second):
+		assert.Fail("no rebuild scheduled for pinned volume")
+	}
+}
+
+func TestDrainForwardsMigration(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(action func(address, volumeName, action string, input, output interface{}) error) {
+		volumeAction = action
+	}(volumeAction)
+	type call struct {
+		address, volumeName, action string
+		input                       interface{}
+	}
+	calls := []call{}
+	volumeAction = func(address, volumeName, action string, input, output interface{}) error {
+		calls = append(calls, call{address, volumeName, action, input})
+		return nil
+	}
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, _ := newTestManager(orc)
+
+	// fake scheduling puts the replicas on host-1 and host-2, the
+	// controller runs on host-3
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	orc.currentHostID = "host-3"
+	assert.Nil(man.Attach("vol"))
+	orc.currentHostID = "host-1"
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	var replica *types.ReplicaInfo
+	for _, r := range volume.Replicas {
+		if r.HostID == "host-2" {
+			replica = r
+		}
+	}
+	assert.NotNil(replica)
+
+	// the manager of the controller migrates the replica
+	_, err = man.DrainHost("host-2", 0)
+	assert.Nil(err)
+	progress := waitDrain(t, man, "host-2")
+	assert.Equal(1, progress.Migrated)
+	assert.Equal([]call{{"host-3:9500", "vol", "replicaMigrate", map[string]string{"name": replica.Name}}}, calls)
+
+	// not here
+	err = man.MigrateReplica("vol", replica.Name)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "doesn't run on the current host")
+}
+
+func TestDrainInterrupted(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	// the manager running the drain is gone with its lock
+	assert.Nil(orc.SetDrainProgress(&types.DrainProgress{
+		JobID:     "gone",
+		HostID:    "host-2",
+		DrainerID: "host-3",
+		Total:     1,
+		Pending:   []string{"r"},
+		Running:   true,
+	}))
+	progress, err := man.GetDrainProgress("host-2")
+	assert.Nil(err)
+	assert.False(progress.Running)
+	assert.True(progress.Interrupted)
+
+	progress, err = man.GetDrainProgress("host-1")
+	assert.Nil(err)
+	assert.Nil(progress)
+}
@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/controller"
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// the engine status of an instance is served from the cache for
+	// EngineStatusCacheTTL, and can be asked for once per
+	// EngineStatusMinInterval
+	EngineStatusCacheTTL    = 3 * time.Second
+	EngineStatusMinInterval = time.Second
+
+	engineStatus = controller.GetEngineStatus
+)
+
+type engineStatusEntry struct {
+	status  *types.EngineStatus
+	checked time.Time
+	asked   time.Time
+}
+
+func findInstance(volume *types.VolumeInfo, name string) *types.InstanceInfo {
+	if volume.Controller != nil && volume.Controller.Name == name {
+		return &volume.Controller.InstanceInfo
+	}
+	if replica := volume.Replicas[name]; replica != nil {
+		return &replica.InstanceInfo
+	}
+	return nil
+}
+
+// EngineStatus asks the engine process of the instance, which has to run on
+// the current host, for its state
+func (man *volumeManager) EngineStatus(volumeName, instanceName string) (*types.EngineStatus, error) {
+	volume, err := man.Get(volumeName)
+	if err != nil {
+		return nil, err
+	}
+	if volume == nil {
+		return nil, errors.Errorf("cannot find volume '%s'", volumeName)
+	}
+	instance := findInstance(volume, instanceName)
+	if instance == nil {
+		return nil, errors.Errorf("cannot find instance %v of volume '%s'", instanceName, volumeName)
+	}
+	if !instance.Running {
+		return nil, errors.Errorf("instance %v of volume '%s' is not running", instanceName, volumeName)
+	}
+	if instance.HostID != man.orc.GetCurrentHostID() {
+		return nil, errors.Errorf("instance %v of volume '%s' runs on host %v", instanceName, volumeName, instance.HostID)
+	}
+
+	now := time.Now()
+	man.Lock()
+	for name, e := range man.engineStatuses {
+		if now.Sub(e.asked) > EngineStatusCacheTTL && now.Sub(e.asked) > EngineStatusMinInterval {
+			delete(man.engineStatuses, name)
+		}
+	}
+	entry := man.engineStatuses[instance.ID]
+	if entry != nil && now.Sub(entry.asked) < EngineStatusMinInterval {
+		man.Unlock()
+		return nil, &types.ErrEngineStatusRateLimited{
+			InstanceName: instanceName,
+			RetryAfter:   EngineStatusMinInterval - now.Sub(entry.asked),
+		}
+	}
+	if entry == nil {
+		entry = &engineStatusEntry{}
+		man.engineStatuses[instance.ID] = entry
+	}
+	entry.asked = now
+	if entry.status != nil && now.Sub(entry.checked) < EngineStatusCacheTTL {
+		status := entry.status
+		man.Unlock()
+		return status, nil
+	}
+	man.Unlock()
+
+	status, err := engineStatus(instance)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get engine status of instance %v of volume '%s'", instanceName, volumeName)
+	}
+	status.EngineImage = volume.EngineImage
+	if version, err := util.ImageVersion(volume.EngineImage); err == nil {
+		v := version.String()
+		status.Version = &v
+	}
+	status.Checked = util.FormatTimeZ(now)
+
+	man.Lock()
+	entry.status = status
+	entry.checked = now
+	man.Unlock()
+	return status, nil
+}
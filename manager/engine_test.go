@@ -0,0 +1,80 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestEngineStatus(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(f func(*types.InstanceInfo) (*types.EngineStatus, error), ttl, interval time.Duration) {
+		engineStatus, EngineStatusCacheTTL, EngineStatusMinInterval = f, ttl, interval
+	}(engineStatus, EngineStatusCacheTTL, EngineStatusMinInterval)
+	EngineStatusCacheTTL = 200 * time.Millisecond
+	EngineStatusMinInterval = 50 * time.Millisecond
+	calls := 0
+	engineStatus = func(instance *types.InstanceInfo) (*types.EngineStatus, error) {
+		calls++
+		return &types.EngineStatus{InstanceName: instance.Name, InstanceType: instance.Type}, nil
+	}
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2, EngineImage: "rancher/longhorn:v0.2.1"})
+	assert.Nil(err)
+
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	var remote *types.ReplicaInfo
+	for _, replica := range volume.Replicas {
+		if replica.HostID == "host-2" {
+			remote = replica
+		}
+	}
+	_, err = man.EngineStatus("vol", remote.Name)
+	assert.NotNil(err)
+	_, err = man.EngineStatus("vol", "vol-controller")
+	assert.NotNil(err)
+
+	assert.Nil(man.Attach("vol"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	status, err := man.EngineStatus("vol", volume.Controller.Name)
+	assert.Nil(err)
+	assert.Equal(types.InstanceTypeController, status.InstanceType)
+	assert.Equal("rancher/longhorn:v0.2.1", status.EngineImage)
+	assert.Equal("0.2.1", *status.Version)
+	assert.Equal(1, calls)
+
+	// asked again right away
+	_, err = man.EngineStatus("vol", volume.Controller.Name)
+	limited, ok := errors.Cause(err).(*types.ErrEngineStatusRateLimited)
+	assert.True(ok)
+	assert.True(limited.RetryAfter > 0 && limited.RetryAfter <= EngineStatusMinInterval)
+
+	// served from the cache, then queried again
+	time.Sleep(EngineStatusMinInterval)
+	_, err = man.EngineStatus("vol", volume.Controller.Name)
+	assert.Nil(err)
+	assert.Equal(1, calls)
+	time.Sleep(EngineStatusCacheTTL)
+	_, err = man.EngineStatus("vol", volume.Controller.Name)
+	assert.Nil(err)
+	assert.Equal(2, calls)
+
+	// the limit is per instance
+	for _, replica := range volume.Replicas {
+		if replica.HostID == "host-1" {
+			status, err = man.EngineStatus("vol", replica.Name)
+			assert.Nil(err)
+			assert.Equal(types.InstanceTypeReplica, status.InstanceType)
+		}
+	}
+	assert.Equal(3, calls)
+}
@@ -0,0 +1,1300 @@
+package manager
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+type fakeOrc struct {
+	sync.Mutex
+
+	currentHostID string
+	hosts         map[string]*types.HostInfo
+	volumes       map[string]*types.VolumeInfo
+	settings      *types.SettingsInfo
+
+	settingsHistory  []*types.SettingsRevision
+	settingsRevision int64
+
+	// if set, CreateVolume reports on createStarted then waits for createGate
+	createStarted chan string
+	createGate    chan struct{}
+	// CreateReplica fails for a volume with as many replicas as its limit
+	replicaLimits map[string]int
+
+	replicaDataPaths map[string]string
+
+	// CreateController fails with controllerErr, after controllerDelay
+	controllerErr   error
+	controllerDelay time.Duration
+
+	// instances on unreachable hosts cannot be started or stopped
+	unreachable map[string]bool
+	// the instances started and stopped, as "start name" or "stop name"
+	instanceOps      []string
+	localControllers []*types.LocalController
+	localInstances   []*types.LocalInstance
+	imageIDs         map[string]string // of the images on the current host, by reference
+
+	// batches of events by volume, appending fails while eventsErr is set.
+	// The IDs of the batches are in eventBatchIDs, the events removed by
+	// volume in eventsRemoved.
+	eventBatches  map[string][][]*types.VolumeEvent
+	eventBatchIDs map[string][]string
+	eventKeeps    map[string]bool // by batch ID
+	eventsRemoved map[string]int64
+	eventsErr     error
+
+	// state transitions by volume
+	history        map[string][]types.StateTransition
+	historyRemoved map[string]int64
+
+	locks map[string]*types.LockInfo
+
+	// raw records by volume, by key
+	rawRecords map[string]map[string]*types.RawRecord
+
+	// snapshots by volume, by name
+	snapshots map[string]map[string]*types.SnapshotInfo
+
+	// shared by the orchestrators of the managers of a test cluster
+	clusterCA *fakeCertStore
+
+	groups map[string]*types.VolumeGroup
+
+	apiTokens map[string]*types.APIToken
+
+	bootstrapReport *types.BootstrapReport
+
+	drains map[string]*types.DrainProgress
+
+	// the stopped controllers of the volumes which can be started again,
+	// the others are gone
+	restartable map[string]bool
+	restarted   []string
+}
+
+type fakeCertStore struct {
+	sync.Mutex
+	ca *types.ClusterCA
+}
+
+func newFakeOrc(currentHostID string, hostIDs ...string) *fakeOrc {
+	orc := &fakeOrc{
+		currentHostID: currentHostID,
+		hosts:         map[string]*types.HostInfo{},
+		volumes:       map[string]*types.VolumeInfo{},
+		settings:      &types.SettingsInfo{EngineImage: "test-engine"},
+
+		replicaDataPaths: map[string]string{},
+		unreachable:      map[string]bool{},
+
+		eventBatches:   map[string][][]*types.VolumeEvent{},
+		eventBatchIDs:  map[string][]string{},
+		eventKeeps:     map[string]bool{},
+		eventsRemoved:  map[string]int64{},
+		history:        map[string][]types.StateTransition{},
+		historyRemoved: map[string]int64{},
+		locks:          map[string]*types.LockInfo{},
+		rawRecords:     map[string]map[string]*types.RawRecord{},
+		clusterCA:      &fakeCertStore{},
+		groups:         map[string]*types.VolumeGroup{},
+		apiTokens:      map[string]*types.APIToken{},
+		drains:         map[string]*types.DrainProgress{},
+		restartable:    map[string]bool{},
+	}
+
+	for _, id := range append(hostIDs, currentHostID) {
+		orc.hosts[id] = &types.HostInfo{UUID: id, Name: id, Address: id + ":9500"}
+	}
+	return orc
+}
+
+func copyVolume(v *types.VolumeInfo) *types.VolumeInfo {
+	volume := util.CopyVolumeProperties(v)
+	volume.State = v.State
+	if v.Controller != nil {
+		controller := *v.Controller
+		volume.Controller = &controller
+	}
+	if v.Replicas != nil {
+		volume.Replicas = map[string]*types.ReplicaInfo{}
+		for k, r := range v.Replicas {
+			replica := *r
+			volume.Replicas[k] = &replica
+		}
+	}
+	return volume
+}
+
+func (o *fakeOrc) CreateVolume(volume *types.VolumeInfo) (*types.VolumeInfo, error) {
+	if o.createGate != nil {
+		o.createStarted <- volume.Name
+		<-o.createGate
+	}
+	o.Lock()
+	defer o.Unlock()
+	if o.volumes[volume.Name] != nil {
+		return nil, errors.Errorf("volume %v already exists", volume.Name)
+	}
+	o.volumes[volume.Name] = copyVolume(volume)
+	return copyVolume(volume), nil
+}
+
+func (o *fakeOrc) DeleteVolume(volumeName string) error {
+	o.Lock()
+	defer o.Unlock()
+	delete(o.volumes, volumeName)
+	return nil
+}
+
+func (o *fakeOrc) GetVolume(volumeName string) (*types.VolumeInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	v := o.volumes[volumeName]
+	if v == nil {
+		return nil, nil
+	}
+	return copyVolume(v), nil
+}
+
+func (o *fakeOrc) ListVolumes() ([]*types.VolumeInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	volumes := []*types.VolumeInfo{}
+	for _, v := range o.volumes {
+		volumes = append(volumes, copyVolume(v))
+	}
+	return volumes, nil
+}
+
+func (o *fakeOrc) ForEachVolume(after string, fn func(volume *types.VolumeInfo) error) error {
+	volumes, err := o.ListVolumes()
+	if err != nil {
+		return err
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	for _, v := range volumes {
+		if v.Name <= after {
+			continue
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *fakeOrc) MarkBadReplica(volumeName string, replica *types.ReplicaInfo) error {
+	o.Lock()
+	defer o.Unlock()
+	v := o.volumes[volumeName]
+	if v == nil {
+		return errors.Errorf("cannot find volume %v", volumeName)
+	}
+	for _, r := range v.Replicas {
+		if r.Address == replica.Address {
+			r.BadTimestamp = util.Now()
+			r.BadHostID = o.currentHostID
+		}
+	}
+	return nil
+}
+
+func (o *fakeOrc) UpdateVolume(volume *types.VolumeInfo) error {
+	o.Lock()
+	defer o.Unlock()
+	v := o.volumes[volume.Name]
+	if v == nil {
+		return errors.Errorf("cannot find volume %v", volume.Name)
+	}
+	updated := copyVolume(volume)
+	updated.Controller = v.Controller
+	updated.Replicas = v.Replicas
+	o.volumes[volume.Name] = updated
+	return nil
+}
+
+func (o *fakeOrc) UpdateVolumeBase(volumeName string, update func(volume *types.VolumeInfo) error) (*types.VolumeInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	v := o.volumes[volumeName]
+	if v == nil {
+		return nil, errors.Errorf("cannot find volume %v", volumeName)
+	}
+	updated := copyVolume(v)
+	updated.Controller, updated.Replicas = nil, nil
+	if err := update(updated); err != nil {
+		return nil, err
+	}
+	base := copyVolume(updated)
+	updated.Controller, updated.Replicas = v.Controller, v.Replicas
+	o.volumes[volumeName] = updated
+	return base, nil
+}
+
+func (o *fakeOrc) CreateController(volumeName, controllerName string, replicas map[string]*types.ReplicaInfo) (*types.ControllerInfo, error) {
+	o.Lock()
+	delay := o.controllerDelay
+	o.Unlock()
+	time.Sleep(delay)
+	o.Lock()
+	defer o.Unlock()
+	if o.controllerErr != nil {
+		return nil, o.controllerErr
+	}
+	v := o.volumes[volumeName]
+	if v == nil {
+		return nil, errors.Errorf("cannot find volume %v", volumeName)
+	}
+	v.Controller = &types.ControllerInfo{
+		InstanceInfo: types.InstanceInfo{
+			ID:         controllerName,
+			Type:       types.InstanceTypeController,
+			Name:       controllerName,
+			HostID:     o.currentHostID,
+			Address:    controllerName,
+			Running:    true,
+			VolumeName: volumeName,
+		},
+	}
+	controller := *v.Controller
+	return &controller, nil
+}
+
+// scheduleHost picks the first host, in ID order, without a good replica of
+// the volume.
+func (o *fakeOrc) scheduleHost(v *types.VolumeInfo, replicaName string) string {
+	if v.ReplicaPlan.Pending() {
+		for _, r := range v.ReplicaPlan.Replicas {
+			if r.Name == replicaName {
+				return r.HostID
+			}
+		}
+	}
+	if v.Mode == types.VolumeModeLocal && !v.Converting(types.VolumeModeReplicated) {
+		return v.PreferredHostID
+	}
+	used := map[string]bool{}
+	for _, r := range v.Replicas {
+		if r.BadTimestamp == "" {
+			used[r.HostID] = true
+		}
+	}
+	ids := []string{}
+	for id, host := range o.hosts {
+		if !host.Unschedulable {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if !used[id] {
+			return id
+		}
+	}
+	return o.currentHostID
+}
+
+func (o *fakeOrc) CreateReplica(volumeName, replicaName string) (*types.ReplicaInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	v := o.volumes[volumeName]
+	if v == nil {
+		return nil, errors.Errorf("cannot find volume %v", volumeName)
+	}
+	if limit, ok := o.replicaLimits[volumeName]; ok && len(v.Replicas) >= limit {
+		return nil, errors.Errorf("fail to create replica %v of volume %v", replicaName, volumeName)
+	}
+	replica := &types.ReplicaInfo{
+		InstanceInfo: types.InstanceInfo{
+			ID:         replicaName,
+			Type:       types.InstanceTypeReplica,
+			Name:       replicaName,
+			HostID:     o.scheduleHost(v, replicaName),
+			Address:    replicaName,
+			VolumeName: volumeName,
+		},
+	}
+	if v.Replicas == nil {
+		v.Replicas = map[string]*types.ReplicaInfo{}
+	}
+	v.Replicas[replicaName] = replica
+	r := *replica
+	return &r, nil
+}
+
+func (o *fakeOrc) instance(instance *types.InstanceInfo) *types.InstanceInfo {
+	v := o.volumes[instance.VolumeName]
+	if v == nil {
+		return nil
+	}
+	if instance.Type == types.InstanceTypeController {
+		if v.Controller == nil {
+			return nil
+		}
+		return &v.Controller.InstanceInfo
+	}
+	if r := v.Replicas[instance.Name]; r != nil {
+		return &r.InstanceInfo
+	}
+	return nil
+}
+
+func (o *fakeOrc) setRunning(instance *types.InstanceInfo, running bool) (*types.InstanceInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	i := o.instance(instance)
+	if i == nil && running && instance.Type == types.InstanceTypeController {
+		// a forgotten controller started again is recorded again
+		if v := o.volumes[instance.VolumeName]; v != nil {
+			v.Controller = &types.ControllerInfo{InstanceInfo: *instance}
+			i = &v.Controller.InstanceInfo
+		}
+	}
+	if i == nil {
+		return nil, errors.Errorf("cannot find instance %v", instance.Name)
+	}
+	if o.hosts[i.HostID] == nil || o.unreachable[i.HostID] {
+		return nil, errors.Errorf("cannot reach host %v", i.HostID)
+	}
+	i.Running = running
+	op := "stop "
+	if running {
+		op = "start "
+	}
+	o.instanceOps = append(o.instanceOps, op+i.Name)
+	ret := *i
+	return &ret, nil
+}
+
+func (o *fakeOrc) StartInstance(instance *types.InstanceInfo) (*types.InstanceInfo, error) {
+	return o.setRunning(instance, true)
+}
+
+func (o *fakeOrc) StopInstance(instance *types.InstanceInfo) (*types.InstanceInfo, error) {
+	return o.setRunning(instance, false)
+}
+
+func (o *fakeOrc) RemoveInstance(instance *types.InstanceInfo) (*types.InstanceInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	if o.hosts[instance.HostID] == nil {
+		return nil, errors.Errorf("cannot reach host %v", instance.HostID)
+	}
+	return instance, o.forget(instance)
+}
+
+func (o *fakeOrc) ListLocalControllers() ([]*types.LocalController, error) {
+	o.Lock()
+	defer o.Unlock()
+	return append([]*types.LocalController{}, o.localControllers...), nil
+}
+
+func (o *fakeOrc) ListLocalInstances() ([]*types.LocalInstance, error) {
+	o.Lock()
+	defer o.Unlock()
+	return append([]*types.LocalInstance{}, o.localInstances...), nil
+}
+
+func (o *fakeOrc) GetImageID(image string) (string, error) {
+	o.Lock()
+	defer o.Unlock()
+	return o.imageIDs[image], nil
+}
+
+func (o *fakeOrc) RemoveLocalController(id string) error {
+	o.Lock()
+	defer o.Unlock()
+	for i, c := range o.localControllers {
+		if c.ID == id {
+			o.localControllers = append(o.localControllers[:i], o.localControllers[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Errorf("cannot find controller %v", id)
+}
+
+func (o *fakeOrc) ForgetInstance(instance *types.InstanceInfo) error {
+	o.Lock()
+	defer o.Unlock()
+	return o.forget(instance)
+}
+
+func (o *fakeOrc) forget(instance *types.InstanceInfo) error {
+	v := o.volumes[instance.VolumeName]
+	if v == nil {
+		return errors.Errorf("cannot find volume %v", instance.VolumeName)
+	}
+	if instance.Type == types.InstanceTypeController {
+		v.Controller = nil
+	} else {
+		delete(v.Replicas, instance.Name)
+	}
+	return nil
+}
+
+func (o *fakeOrc) ListHosts() (map[string]*types.HostInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	hosts := map[string]*types.HostInfo{}
+	for id, h := range o.hosts {
+		host := *h
+		hosts[id] = &host
+	}
+	return hosts, nil
+}
+
+func (o *fakeOrc) GetHosts(ids []string) (map[string]*types.HostInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	hosts := map[string]*types.HostInfo{}
+	for _, id := range ids {
+		hosts[id] = nil
+		if h := o.hosts[id]; h != nil {
+			host := *h
+			hosts[id] = &host
+		}
+	}
+	return hosts, nil
+}
+
+func (o *fakeOrc) GetHost(id string) (*types.HostInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	h := o.hosts[id]
+	if h == nil {
+		return nil, nil
+	}
+	host := *h
+	return &host, nil
+}
+
+func (o *fakeOrc) DeleteHost(id string) error {
+	o.Lock()
+	defer o.Unlock()
+	delete(o.hosts, id)
+	return nil
+}
+
+func (o *fakeOrc) Deregister() error {
+	return o.DeleteHost(o.currentHostID)
+}
+
+func (o *fakeOrc) Heartbeat() error {
+	o.Lock()
+	defer o.Unlock()
+	if h := o.hosts[o.currentHostID]; h != nil {
+		h.Heartbeat = util.Now()
+	}
+	return nil
+}
+
+func (o *fakeOrc) SetHostSchedulable(id string, schedulable bool) error {
+	o.Lock()
+	defer o.Unlock()
+	h := o.hosts[id]
+	if h == nil {
+		return errors.Errorf("cannot find host %v", id)
+	}
+	h.Unschedulable = !schedulable
+	return nil
+}
+
+func (o *fakeOrc) SetHostFailureDomain(id, domain string) error {
+	o.Lock()
+	defer o.Unlock()
+	h := o.hosts[id]
+	if h == nil {
+		return errors.Errorf("cannot find host %v", id)
+	}
+	h.FailureDomain = domain
+	return nil
+}
+
+func (o *fakeOrc) SetHostSchedulingWeight(id string, weight int) error {
+	o.Lock()
+	defer o.Unlock()
+	h := o.hosts[id]
+	if h == nil {
+		return errors.Errorf("cannot find host %v", id)
+	}
+	h.SchedulingWeight = weight
+	return nil
+}
+
+func (o *fakeOrc) SetHostRole(id string, role types.HostRole) error {
+	o.Lock()
+	defer o.Unlock()
+	h := o.hosts[id]
+	if h == nil {
+		return errors.Errorf("cannot find host %v", id)
+	}
+	h.Role = role
+	return nil
+}
+
+func (o *fakeOrc) SetHostMaintenance(id string, window *types.MaintenanceWindow) error {
+	o.Lock()
+	defer o.Unlock()
+	h := o.hosts[id]
+	if h == nil {
+		return errors.Errorf("cannot find host %v", id)
+	}
+	if window != nil {
+		w := *window
+		window = &w
+	}
+	h.Maintenance = window
+	return nil
+}
+
+func (o *fakeOrc) SetHostRegenerateNonce(id, nonce string) error {
+	o.Lock()
+	defer o.Unlock()
+	h := o.hosts[id]
+	if h == nil {
+		return errors.Errorf("cannot find host %v", id)
+	}
+	h.RegenerateNonce = nonce
+	return nil
+}
+
+func (o *fakeOrc) Scheduler() types.Scheduler {
+	return nil
+}
+
+func (o *fakeOrc) GetCurrentHostID() string {
+	return o.currentHostID
+}
+
+func (o *fakeOrc) GetAddress(hostID string) (string, error) {
+	host, err := o.GetHost(hostID)
+	if err != nil || host == nil {
+		return "", errors.Errorf("cannot find host %v", hostID)
+	}
+	return host.Address, nil
+}
+
+func (o *fakeOrc) GetSettings() (*types.SettingsInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	settings := *o.settings
+	return &settings, nil
+}
+
+func (o *fakeOrc) SetSettings(settings *types.SettingsInfo) error {
+	return o.UpdateSettings("", func(si *types.SettingsInfo) error {
+		*si = *settings
+		return nil
+	})
+}
+
+func (o *fakeOrc) UpdateSettings(author string, update func(*types.SettingsInfo) error) error {
+	o.Lock()
+	defer o.Unlock()
+	s := *o.settings
+	if err := update(&s); err != nil {
+		return err
+	}
+	o.settingsRevision++
+	o.settingsHistory = append(o.settingsHistory, &types.SettingsRevision{
+		Revision: o.settingsRevision,
+		Author:   author,
+		Time:     util.Now(),
+		Previous: *o.settings,
+		Settings: s,
+	})
+	o.settings = &s
+	return nil
+}
+
+func (o *fakeOrc) GetSettingsHistory() ([]*types.SettingsRevision, error) {
+	o.Lock()
+	defer o.Unlock()
+	return append([]*types.SettingsRevision{}, o.settingsHistory...), nil
+}
+
+func (o *fakeOrc) PruneSettingsHistory(expired func(revision *types.SettingsRevision) bool) ([]*types.SettingsRevision, error) {
+	o.Lock()
+	defer o.Unlock()
+	kept, removed := []*types.SettingsRevision{}, []*types.SettingsRevision{}
+	for i, r := range o.settingsHistory {
+		if i != len(o.settingsHistory)-1 && !r.Keep && expired(r) {
+			removed = append(removed, r)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	o.settingsHistory = kept
+	return removed, nil
+}
+
+func (o *fakeOrc) KeepSettingsRevision(revision int64, keep bool) error {
+	o.Lock()
+	defer o.Unlock()
+	for _, r := range o.settingsHistory {
+		if r.Revision == revision {
+			r.Keep = keep
+			return nil
+		}
+	}
+	return errors.Errorf("cannot find settings revision %v in the history", revision)
+}
+
+func (o *fakeOrc) SettingsHistoryCounts() (*types.RecordCounts, error) {
+	o.Lock()
+	defer o.Unlock()
+	return &types.RecordCounts{Total: o.settingsRevision, Retained: int64(len(o.settingsHistory))}, nil
+}
+
+func (o *fakeOrc) ReplicaDataPath(replica *types.ReplicaInfo) (string, error) {
+	o.Lock()
+	defer o.Unlock()
+	path, ok := o.replicaDataPaths[replica.Name]
+	if !ok {
+		return "", errors.Errorf("no data path for replica %v", replica.Name)
+	}
+	return path, nil
+}
+
+func (o *fakeOrc) StateRevision(key string) (string, error) {
+	return "", errors.Errorf("revisions are not supported by the fake orchestrator")
+}
+
+func (o *fakeOrc) StateStaleSince() time.Time {
+	return time.Time{}
+}
+
+func (o *fakeOrc) AppendVolumeEvents(volumeName string, events []*types.VolumeEvent) error {
+	o.Lock()
+	defer o.Unlock()
+	if o.eventsErr != nil {
+		return o.eventsErr
+	}
+	o.eventBatches[volumeName] = append(o.eventBatches[volumeName], events)
+	o.eventBatchIDs[volumeName] = append(o.eventBatchIDs[volumeName], util.RandomID())
+	return nil
+}
+
+func (o *fakeOrc) ListVolumeEvents(volumeName string) ([]*types.VolumeEvent, error) {
+	o.Lock()
+	defer o.Unlock()
+	events := []*types.VolumeEvent{}
+	for i, batch := range o.eventBatches[volumeName] {
+		id := o.eventBatchIDs[volumeName][i]
+		for _, e := range batch {
+			listed := *e
+			listed.Batch = id
+			listed.Keep = o.eventKeeps[id]
+			events = append(events, &listed)
+		}
+	}
+	return events, nil
+}
+
+func (o *fakeOrc) DeleteVolumeEvents(volumeName string) error {
+	o.Lock()
+	defer o.Unlock()
+	delete(o.eventBatches, volumeName)
+	delete(o.eventBatchIDs, volumeName)
+	delete(o.eventsRemoved, volumeName)
+	return nil
+}
+
+func (o *fakeOrc) PruneVolumeEvents(volumeName string, expired func(events []*types.VolumeEvent) bool) ([][]*types.VolumeEvent, error) {
+	o.Lock()
+	defer o.Unlock()
+	batches, ids := [][]*types.VolumeEvent{}, []string{}
+	removed := [][]*types.VolumeEvent{}
+	for i, batch := range o.eventBatches[volumeName] {
+		id := o.eventBatchIDs[volumeName][i]
+		if !o.eventKeeps[id] && expired(batch) {
+			removed = append(removed, batch)
+			o.eventsRemoved[volumeName] += int64(len(batch))
+			continue
+		}
+		batches, ids = append(batches, batch), append(ids, id)
+	}
+	o.eventBatches[volumeName], o.eventBatchIDs[volumeName] = batches, ids
+	return removed, nil
+}
+
+func (o *fakeOrc) KeepVolumeEvents(volumeName, batch string, keep bool) error {
+	o.Lock()
+	defer o.Unlock()
+	for _, id := range o.eventBatchIDs[volumeName] {
+		if id == batch {
+			o.eventKeeps[id] = keep
+			return nil
+		}
+	}
+	return errors.Errorf("cannot find event batch %v of volume %v", batch, volumeName)
+}
+
+func (o *fakeOrc) VolumeEventCounts(volumeName string) (*types.RecordCounts, error) {
+	o.Lock()
+	defer o.Unlock()
+	counts := &types.RecordCounts{}
+	for _, batch := range o.eventBatches[volumeName] {
+		counts.Retained += int64(len(batch))
+	}
+	counts.Total = counts.Retained + o.eventsRemoved[volumeName]
+	return counts, nil
+}
+
+func (o *fakeOrc) RecordVolumeState(volumeName string, state types.VolumeState, hostID, at string) (bool, error) {
+	o.Lock()
+	defer o.Unlock()
+	transitions := o.history[volumeName]
+	from := types.VolumeStateNone
+	if n := len(transitions); n != 0 {
+		from = transitions[n-1].To
+	}
+	if from == state {
+		return false, nil
+	}
+	o.history[volumeName] = append(transitions, types.StateTransition{
+		Time:   at,
+		From:   from,
+		To:     state,
+		HostID: hostID,
+		Seq:    o.historyRemoved[volumeName] + int64(len(transitions)) + 1,
+	})
+	return true, nil
+}
+
+func (o *fakeOrc) GetVolumeHistory(volumeName string, limit int) ([]types.StateTransition, error) {
+	o.Lock()
+	defer o.Unlock()
+	transitions := append([]types.StateTransition{}, o.history[volumeName]...)
+	if limit > 0 && len(transitions) > limit {
+		transitions = transitions[len(transitions)-limit:]
+	}
+	return transitions, nil
+}
+
+func (o *fakeOrc) DeleteVolumeHistory(volumeName string) error {
+	o.Lock()
+	defer o.Unlock()
+	delete(o.history, volumeName)
+	delete(o.historyRemoved, volumeName)
+	return nil
+}
+
+func (o *fakeOrc) PruneVolumeHistory(volumeName string, expired func(transition *types.StateTransition) bool) ([]types.StateTransition, error) {
+	o.Lock()
+	defer o.Unlock()
+	transitions := o.history[volumeName]
+	kept, removed := []types.StateTransition{}, []types.StateTransition{}
+	for i := range transitions {
+		t := transitions[i]
+		if i != len(transitions)-1 && !t.Keep && expired(&t) {
+			removed = append(removed, t)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	o.history[volumeName] = kept
+	o.historyRemoved[volumeName] += int64(len(removed))
+	return removed, nil
+}
+
+func (o *fakeOrc) KeepVolumeState(volumeName string, seq int64, keep bool) error {
+	o.Lock()
+	defer o.Unlock()
+	for i, t := range o.history[volumeName] {
+		if t.Seq == seq {
+			o.history[volumeName][i].Keep = keep
+			return nil
+		}
+	}
+	return errors.Errorf("cannot find state transition %v of volume %v", seq, volumeName)
+}
+
+func (o *fakeOrc) VolumeHistoryCounts(volumeName string) (*types.RecordCounts, error) {
+	o.Lock()
+	defer o.Unlock()
+	retained := int64(len(o.history[volumeName]))
+	return &types.RecordCounts{Total: retained + o.historyRemoved[volumeName], Retained: retained}, nil
+}
+
+func (o *fakeOrc) RenderController(volumeName, controllerName string, replicas map[string]*types.ReplicaInfo) (*types.InstanceRender, error) {
+	names := []string{}
+	for name := range replicas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &types.InstanceRender{
+		Orchestrator: "fake",
+		HostID:       o.currentHostID,
+		VolumeName:   volumeName,
+		InstanceName: controllerName,
+		InstanceType: types.InstanceTypeController,
+		Payload:      names,
+	}, nil
+}
+
+func (o *fakeOrc) RenderReplica(volumeName, replicaName string) (*types.InstanceRender, error) {
+	return &types.InstanceRender{
+		Orchestrator: "fake",
+		HostID:       o.currentHostID,
+		VolumeName:   volumeName,
+		InstanceName: replicaName,
+		InstanceType: types.InstanceTypeReplica,
+	}, nil
+}
+
+func (o *fakeOrc) RestartController(volume *types.VolumeInfo) (*types.ControllerInfo, string, error) {
+	o.Lock()
+	restartable := o.restartable[volume.Name]
+	o.Unlock()
+	if !restartable {
+		return nil, "its container is gone", nil
+	}
+	instance, err := o.StartInstance(&volume.Controller.InstanceInfo)
+	if err != nil {
+		return nil, "", err
+	}
+	o.Lock()
+	o.restarted = append(o.restarted, volume.Name)
+	o.Unlock()
+	return &types.ControllerInfo{InstanceInfo: *instance}, "", nil
+}
+
+func (o *fakeOrc) CreateSnapshot(volumeName, snapshotName string) (*types.SnapshotInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	volume := o.volumes[volumeName]
+	if volume == nil {
+		return nil, errors.Errorf("volume %v doesn't exist", volumeName)
+	}
+	if volume.Controller == nil || !volume.Controller.Running {
+		return nil, errors.Errorf("no controller running for volume %v", volumeName)
+	}
+	if o.snapshots == nil {
+		o.snapshots = map[string]map[string]*types.SnapshotInfo{}
+	}
+	if o.snapshots[volumeName] == nil {
+		o.snapshots[volumeName] = map[string]*types.SnapshotInfo{}
+	}
+	if o.snapshots[volumeName][snapshotName] != nil {
+		return nil, &types.ErrSnapshotExists{VolumeName: volumeName, SnapshotName: snapshotName}
+	}
+	snapshot := &types.SnapshotInfo{
+		Name:        snapshotName,
+		UserCreated: true,
+		Created:     util.Now(),
+	}
+	o.snapshots[volumeName][snapshotName] = snapshot
+	return snapshot, nil
+}
+
+func (o *fakeOrc) ListSnapshots(volumeName string) ([]*types.SnapshotInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	snapshots := []*types.SnapshotInfo{}
+	for _, snapshot := range o.snapshots[volumeName] {
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Created < snapshots[j].Created })
+	return snapshots, nil
+}
+
+func (o *fakeOrc) DeleteSnapshot(volumeName, snapshotName string) error {
+	o.Lock()
+	defer o.Unlock()
+	if o.snapshots[volumeName][snapshotName] == nil {
+		return errors.Errorf("volume %v has no snapshot %v", volumeName, snapshotName)
+	}
+	delete(o.snapshots[volumeName], snapshotName)
+	return nil
+}
+
+func (o *fakeOrc) GetEffectiveConfig() (*types.RuntimeConfig, error) {
+	return &types.RuntimeConfig{Orchestrator: "fake", HostID: o.currentHostID}, nil
+}
+
+func (o *fakeOrc) AcquireLock(lock *types.LockInfo, ttl time.Duration) error {
+	o.Lock()
+	defer o.Unlock()
+	now := time.Now()
+	if current := o.locks[lock.Name]; current != nil && current.Held(now) {
+		held := *current
+		return &types.ErrLockHeld{Lock: &held}
+	}
+	l := *lock
+	l.Acquired = util.FormatTimeZ(now)
+	l.Expires = util.FormatTimeZ(now.Add(ttl))
+	l.RevokedBy = ""
+	o.locks[lock.Name] = &l
+	return nil
+}
+
+func (o *fakeOrc) RefreshLock(name, operationID string, ttl time.Duration) error {
+	o.Lock()
+	defer o.Unlock()
+	lock := o.locks[name]
+	if lock == nil || lock.OperationID != operationID {
+		return &types.ErrLockLost{Name: name, OperationID: operationID}
+	}
+	if lock.RevokedBy != "" {
+		return &types.ErrLockLost{Name: name, OperationID: operationID, RevokedBy: lock.RevokedBy}
+	}
+	lock.Expires = util.FormatTimeZ(time.Now().Add(ttl))
+	return nil
+}
+
+func (o *fakeOrc) ReleaseLock(name, operationID string) error {
+	o.Lock()
+	defer o.Unlock()
+	if lock := o.locks[name]; lock != nil && lock.OperationID == operationID {
+		delete(o.locks, name)
+	}
+	return nil
+}
+
+func (o *fakeOrc) ListLocks() ([]*types.LockInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	locks := []*types.LockInfo{}
+	for name, lock := range o.locks {
+		expires, err := util.ParseTimeZ(lock.Expires)
+		if err == nil && !time.Now().Before(expires) {
+			delete(o.locks, name)
+			continue
+		}
+		l := *lock
+		locks = append(locks, &l)
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Name < locks[j].Name })
+	return locks, nil
+}
+
+func (o *fakeOrc) RevokeLock(name, operationID, revokedBy string) (*types.LockInfo, error) {
+	o.Lock()
+	defer o.Unlock()
+	lock := o.locks[name]
+	if lock == nil || lock.OperationID != operationID {
+		return nil, errors.Errorf("operation %v doesn't hold lock %v", operationID, name)
+	}
+	lock.RevokedBy = revokedBy
+	l := *lock
+	return &l, nil
+}
+
+func (o *fakeOrc) GetClusterCA() (*types.ClusterCA, error) {
+	o.clusterCA.Lock()
+	defer o.clusterCA.Unlock()
+	if o.clusterCA.ca == nil {
+		return nil, nil
+	}
+	ca := *o.clusterCA.ca
+	return &ca, nil
+}
+
+func (o *fakeOrc) SetClusterCA(ca *types.ClusterCA) error {
+	o.clusterCA.Lock()
+	defer o.clusterCA.Unlock()
+	c := *ca
+	o.clusterCA.ca = &c
+	return nil
+}
+
+func (o *fakeOrc) GetVolumeGroup(name string) (*types.VolumeGroup, error) {
+	o.Lock()
+	defer o.Unlock()
+	if o.groups[name] == nil {
+		return nil, nil
+	}
+	group := *o.groups[name]
+	return &group, nil
+}
+
+func (o *fakeOrc) ListVolumeGroups() (map[string]*types.VolumeGroup, error) {
+	o.Lock()
+	defer o.Unlock()
+	groups := map[string]*types.VolumeGroup{}
+	for name, g := range o.groups {
+		group := *g
+		groups[name] = &group
+	}
+	return groups, nil
+}
+
+func (o *fakeOrc) SetVolumeGroup(group *types.VolumeGroup) error {
+	o.Lock()
+	defer o.Unlock()
+	g := *group
+	o.groups[group.Name] = &g
+	return nil
+}
+
+func (o *fakeOrc) DeleteVolumeGroup(name string) error {
+	o.Lock()
+	defer o.Unlock()
+	delete(o.groups, name)
+	return nil
+}
+
+func (o *fakeOrc) ListAPITokens() (map[string]*types.APIToken, error) {
+	o.Lock()
+	defer o.Unlock()
+	tokens := map[string]*types.APIToken{}
+	for id, t := range o.apiTokens {
+		token := *t
+		tokens[id] = &token
+	}
+	return tokens, nil
+}
+
+func (o *fakeOrc) SetAPIToken(token *types.APIToken) error {
+	o.Lock()
+	defer o.Unlock()
+	t := *token
+	o.apiTokens[token.ID] = &t
+	return nil
+}
+
+func (o *fakeOrc) DeleteAPIToken(id string) error {
+	o.Lock()
+	defer o.Unlock()
+	delete(o.apiTokens, id)
+	return nil
+}
+
+func (o *fakeOrc) GetBootstrapReport() (*types.BootstrapReport, error) {
+	o.Lock()
+	defer o.Unlock()
+	if o.bootstrapReport == nil {
+		return nil, nil
+	}
+	report := *o.bootstrapReport
+	return &report, nil
+}
+
+func (o *fakeOrc) SetBootstrapReport(report *types.BootstrapReport) error {
+	o.Lock()
+	defer o.Unlock()
+	r := *report
+	o.bootstrapReport = &r
+	return nil
+}
+
+func (o *fakeOrc) GetDrainProgress(hostID string) (*types.DrainProgress, error) {
+	o.Lock()
+	defer o.Unlock()
+	if o.drains[hostID] == nil {
+		return nil, nil
+	}
+	progress := *o.drains[hostID]
+	return &progress, nil
+}
+
+func (o *fakeOrc) SetDrainProgress(progress *types.DrainProgress) error {
+	o.Lock()
+	defer o.Unlock()
+	p := *progress
+	o.drains[progress.HostID] = &p
+	return nil
+}
+
+func (o *fakeOrc) ListVolumeRawRecords(volumeName string) ([]*types.RawRecord, error) {
+	o.Lock()
+	defer o.Unlock()
+	records := []*types.RawRecord{}
+	for _, r := range o.rawRecords[volumeName] {
+		record := *r
+		records = append(records, &record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records, nil
+}
+
+func (o *fakeOrc) SetVolumeRawRecord(volumeName string, record *types.RawRecord) error {
+	o.Lock()
+	defer o.Unlock()
+	current := o.rawRecords[volumeName][record.Key]
+	if current == nil {
+		return errors.Errorf("key %v doesn't belong to volume %v", record.Key, volumeName)
+	}
+	if current.ModifiedIndex != record.ModifiedIndex {
+		return errors.Errorf("key %v was modified after index %v", record.Key, record.ModifiedIndex)
+	}
+	current.Value = record.Value
+	current.ModifiedIndex++
+	return nil
+}
+
+type fakeController struct {
+	sync.Mutex
+
+	name     string
+	replicas map[string]*types.ReplicaInfo // key is address
+	added    chan *types.ReplicaInfo
+	removed  []string
+
+	addDelay time.Duration // how long rebuilding a replica takes
+	stats    types.VolumeStats
+
+	unresponsive bool // the replica states cannot be read
+
+	snapshots types.SnapshotOps
+	queue     fakeTaskQueue
+}
+
+type fakeTaskQueue struct {
+	sync.Mutex
+
+	tasks []*types.BgTask
+}
+
+func (q *fakeTaskQueue) List() []*types.BgTask {
+	q.Lock()
+	defer q.Unlock()
+	return append([]*types.BgTask{}, q.tasks...)
+}
+
+func (q *fakeTaskQueue) Put(t *types.BgTask) {
+	q.Lock()
+	defer q.Unlock()
+	q.tasks = append(q.tasks, t)
+}
+
+func (q *fakeTaskQueue) Take() *types.BgTask {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.tasks) == 0 {
+		return nil
+	}
+	t := q.tasks[0]
+	q.tasks = q.tasks[1:]
+	return t
+}
+
+func (q *fakeTaskQueue) Close() error {
+	return nil
+}
+
+func (c *fakeController) Name() string {
+	return c.name
+}
+
+func (c *fakeController) Endpoint() string {
+	return "/dev/longhorn/" + c.name
+}
+
+func (c *fakeController) GetReplicaStates() ([]*types.ReplicaInfo, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.unresponsive {
+		return nil, errors.Errorf("controller %v unresponsive", c.name)
+	}
+	replicas := []*types.ReplicaInfo{}
+	for _, r := range c.replicas {
+		replica := *r
+		replicas = append(replicas, &replica)
+	}
+	return replicas, nil
+}
+
+func (c *fakeController) AddReplica(replica *types.ReplicaInfo) error {
+	c.Lock()
+	delay := c.addDelay
+	c.Unlock()
+	time.Sleep(delay)
+	c.Lock()
+	r := *replica
+	r.Mode = types.ReplicaModeWO
+	c.replicas[r.Address] = &r
+	c.Unlock()
+	c.added <- &r
+	return nil
+}
+
+func (c *fakeController) RemoveReplica(replica *types.ReplicaInfo) error {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.replicas, replica.Address)
+	c.removed = append(c.removed, replica.Address)
+	return nil
+}
+
+func (c *fakeController) BgTaskQueue() types.TaskQueue {
+	return &c.queue
+}
+
+func (c *fakeController) LatestBgTasks() []*types.BgTask {
+	return nil
+}
+
+func (c *fakeController) SnapshotOps() types.SnapshotOps {
+	return c.snapshots
+}
+
+func (c *fakeController) Stats() (*types.VolumeStats, error) {
+	c.Lock()
+	defer c.Unlock()
+	stats := c.stats
+	return &stats, nil
+}
+
+func (c *fakeController) BackupOps() types.VolumeBackupOps {
+	return nil
+}
+
+type fakeControllers struct {
+	sync.Mutex
+
+	controllers map[string]*fakeController
+}
+
+func (fc *fakeControllers) get(volume *types.VolumeInfo) types.Controller {
+	if volume == nil || volume.Controller == nil || !volume.Controller.Running {
+		return nil
+	}
+	fc.Lock()
+	defer fc.Unlock()
+	c := fc.controllers[volume.Name]
+	if c == nil {
+		c = &fakeController{
+			name:     volume.Name,
+			replicas: map[string]*types.ReplicaInfo{},
+			added:    make(chan *types.ReplicaInfo, 16),
+		}
+		for _, r := range volume.Replicas {
+			if r.BadTimestamp == "" {
+				replica := *r
+				replica.Mode = types.ReplicaModeRW
+				c.replicas[r.Address] = &replica
+			}
+		}
+		fc.controllers[volume.Name] = c
+	}
+	return c
+}
+
+type fakeMonitor struct{}
+
+func (m *fakeMonitor) Close() error {
+	return nil
+}
+
+func (m *fakeMonitor) CronCh() chan<- types.Event {
+	return nil
+}
+
+func newTestManager(orc *fakeOrc) (*volumeManager, *fakeControllers) {
+	fc := &fakeControllers{controllers: map[string]*fakeController{}}
+	monitor := func(volume *types.VolumeInfo, man types.VolumeManager) types.Monitor {
+		return &fakeMonitor{}
+	}
+	man := New(orc, monitor, fc.get, nil).(*volumeManager)
+	return man, fc
+}
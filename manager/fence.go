@@ -0,0 +1,229 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+const (
+	EventReasonFencing         = "Fencing"
+	EventReasonFenced          = "Fenced"
+	EventReasonFailoverRefused = "FailoverRefused"
+)
+
+var (
+	FenceProbeTimeout = time.Second * 5
+	FenceCheckPeriod  = time.Minute
+
+	// probeHost checks if the manager at the address answers
+	probeHost = func(address string) bool {
+		client := http.Client{Timeout: FenceProbeTimeout, Transport: util.ManagerTransport}
+		resp, err := client.Get(util.ManagerURL(address, "/v1"))
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	}
+
+	// probeHostVia asks the manager at witnessAddress if it can reach the
+	// host
+	probeHostVia = func(witnessAddress, hostID string) (bool, error) {
+		client := http.Client{Timeout: 2 * FenceProbeTimeout, Transport: util.ManagerTransport}
+		resp, err := client.Get(util.ManagerURL(witnessAddress, fmt.Sprintf("/v1/hosts/%s/reachable", hostID)))
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return false, errors.Errorf("unexpected status %v", resp.Status)
+		}
+		var output struct {
+			Reachable bool `json:"reachable"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+			return false, err
+		}
+		return output.Reachable, nil
+	}
+)
+
+// ProbeHost reports if the manager of the host answers from the current host
+func (man *volumeManager) ProbeHost(id string) (bool, error) {
+	host, err := man.orc.GetHost(id)
+	if err != nil {
+		return false, errors.Wrapf(err, "fail to get host %v", id)
+	}
+	if host == nil {
+		return false, errors.Errorf("cannot find host %v", id)
+	}
+	return probeHost(host.Address), nil
+}
+
+// hostReachable probes the host directly, then through the other hosts as
+// witnesses. The host is only considered unreachable if at least one witness
+// confirms it, so a partition isolating the current host won't fence others.
+// The probes are recorded as events of the volume.
+func (man *volumeManager) hostReachable(volumeName, hostID string) (bool, error) {
+	host, err := man.orc.GetHost(hostID)
+	if err != nil {
+		return false, errors.Wrapf(err, "fail to get host %v", hostID)
+	}
+	if host == nil {
+		return false, errors.Errorf("cannot find host %v", hostID)
+	}
+	if probeHost(host.Address) {
+		man.events.record(volumeName, types.EventSeverityInfo, EventReasonFencing, "host %v answers directly", hostID)
+		return true, nil
+	}
+	man.events.record(volumeName, types.EventSeverityWarning, EventReasonFencing, "host %v doesn't answer directly", hostID)
+
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return false, errors.Wrap(err, "unable to list hosts")
+	}
+	ids := []string{}
+	for id := range hosts {
+		if id != hostID && id != man.orc.GetCurrentHostID() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	witnessed := false
+	for _, id := range ids {
+		reachable, err := probeHostVia(hosts[id].Address, hostID)
+		if err != nil {
+			man.events.record(volumeName, types.EventSeverityWarning, EventReasonFencing, "witness %v unavailable: %v", id, err)
+			continue
+		}
+		man.events.record(volumeName, types.EventSeverityInfo, EventReasonFencing,
+			"witness %v reports host %v reachable: %v", id, hostID, reachable)
+		if reachable {
+			return true, nil
+		}
+		witnessed = true
+	}
+	if !witnessed {
+		return false, errors.Errorf("no witness confirms host %v is unreachable", hostID)
+	}
+	return false, nil
+}
+
+// fence revokes the ownership of the controller of the volume on an
+// unreachable host. The engine doesn't check the generation yet, so the
+// replicas on the other hosts are stopped first: the old controller drops
+// the replicas it loses and never reconnects to them. The failover is
+// refused unless every one of them is stopped. The bumped generation is then
+// persisted before the instances on the host are forgotten, so the new
+// controller is started with it and the host drops the stale controller
+// once it's back.
+func (man *volumeManager) fence(volume *types.VolumeInfo) (err error) {
+	if volume.Controller == nil {
+		return nil
+	}
+	hostID := volume.Controller.HostID
+	defer func() {
+		if err != nil {
+			man.events.record(volume.Name, types.EventSeverityError, EventReasonFailoverRefused, "%v", err)
+		}
+	}()
+	man.events.record(volume.Name, types.EventSeverityWarning, EventReasonFencing,
+		"controller on host %v cannot be stopped", hostID)
+	reachable, err := man.hostReachable(volume.Name, hostID)
+	if err != nil {
+		return errors.Wrapf(err, "fencing: refused failover of volume '%s'", volume.Name)
+	}
+	if reachable {
+		return errors.Errorf("fencing: refused failover of volume '%s', host %v is reachable", volume.Name, hostID)
+	}
+	if err := man.revokeReplicas(volume, hostID); err != nil {
+		return errors.Wrapf(err, "fencing: refused failover of volume '%s', cannot revoke the access of the controller on host %v",
+			volume.Name, hostID)
+	}
+
+	updated, err := man.orc.UpdateVolumeBase(volume.Name, func(v *types.VolumeInfo) error {
+		v.Generation++
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "fencing: fail to bump generation of volume '%s'", volume.Name)
+	}
+	volume.Generation = updated.Generation
+	if _, err := man.forgetHostInstances(volume, hostID); err != nil {
+		return errors.Wrapf(err, "fencing: fail to forget instances of volume '%s'", volume.Name)
+	}
+	man.events.record(volume.Name, types.EventSeverityWarning, EventReasonFenced,
+		"generation bumped to %v, controller on host %v revoked", volume.Generation, hostID)
+	return nil
+}
+
+// revokeReplicas stops the replicas of the volume on the hosts other than
+// the fenced one, whether they're recorded running or not
+func (man *volumeManager) revokeReplicas(volume *types.VolumeInfo, hostID string) error {
+	names := []string{}
+	errs := Errs{}
+	for _, replica := range volume.Replicas {
+		if replica.HostID == hostID {
+			continue
+		}
+		if _, err := man.orc.StopInstance(&replica.InstanceInfo); err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to stop replica '%s'", replica.Name))
+			continue
+		}
+		names = append(names, replica.Name)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	sort.Strings(names)
+	man.events.record(volume.Name, types.EventSeverityWarning, EventReasonFencing,
+		"replicas %v stopped to revoke the access of the controller on host %v", names, hostID)
+	return nil
+}
+
+// removeStaleControllers removes the controllers on the current host with a
+// generation older than the volume's, left behind by a failover while the
+// host was partitioned
+func (man *volumeManager) removeStaleControllers() error {
+	controllers, err := man.orc.ListLocalControllers()
+	if err != nil {
+		return errors.Wrap(err, "unable to list local controllers")
+	}
+	for _, c := range controllers {
+		volume, err := man.orc.GetVolume(c.VolumeName)
+		if err != nil {
+			logrus.Warnf("%v", errors.Wrapf(err, "unable to get volume '%s' for controller %v", c.VolumeName, c.ID))
+			continue
+		}
+		if volume == nil || c.Generation >= volume.Generation {
+			continue
+		}
+		if err := man.orc.RemoveLocalController(c.ID); err != nil {
+			logrus.Errorf("%+v", errors.Wrapf(err, "fencing: fail to remove stale controller %v", c.ID))
+			continue
+		}
+		man.events.record(c.VolumeName, types.EventSeverityWarning, EventReasonFenced,
+			"stale controller %v of generation %v removed from host %v, current generation %v",
+			c.ID, c.Generation, man.orc.GetCurrentHostID(), volume.Generation)
+	}
+	return nil
+}
+
+func (man *volumeManager) fenceCheck(ctx context.Context) error {
+	return runner.Tick(ctx, FenceCheckPeriod, func() {
+		if err := man.removeStaleControllers(); err != nil {
+			logrus.Warnf("%v", err)
+		}
+	})
+}
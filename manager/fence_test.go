@@ -0,0 +1,137 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func stubProbes(direct bool, witnesses map[string]interface{}) func() {
+	origProbe, origProbeVia := probeHost, probeHostVia
+	probeHost = func(address string) bool {
+		return direct
+	}
+	probeHostVia = func(witnessAddress, hostID string) (bool, error) {
+		switch r := witnesses[witnessAddress].(type) {
+		case bool:
+			return r, nil
+		case error:
+			return false, r
+		}
+		return false, errors.Errorf("unknown witness %v", witnessAddress)
+	}
+	return func() {
+		probeHost, probeHostVia = origProbe, origProbeVia
+	}
+}
+
+func TestFenceBeforeFailover(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, _ := newTestManager(orc)
+
+	// fake scheduling puts the replicas on host-1 and host-2
+	volume, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach(volume.Name))
+
+	// the controller runs on host-2, which gets partitioned
+	orc.Lock()
+	orc.volumes[volume.Name].Controller.HostID = "host-2"
+	orc.unreachable["host-2"] = true
+	orc.Unlock()
+	man.stopMonitoring(volume)
+
+	restore := stubProbes(false, map[string]interface{}{"host-3:9500": errors.New("timeout")})
+	err = man.Attach(volume.Name)
+	restore()
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "no witness")
+
+	restore = stubProbes(false, map[string]interface{}{"host-3:9500": true})
+	err = man.Attach(volume.Name)
+	restore()
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "reachable")
+	volume, err = man.Get(volume.Name)
+	assert.Nil(err)
+	assert.Equal(int64(0), volume.Generation)
+	assert.Equal("host-2", volume.Controller.HostID)
+
+	// the replica on host-1 cannot be stopped, so the old controller may
+	// still reach it
+	orc.Lock()
+	orc.unreachable["host-1"] = true
+	orc.Unlock()
+	restore = stubProbes(false, map[string]interface{}{"host-3:9500": false})
+	err = man.Attach(volume.Name)
+	restore()
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "cannot revoke")
+	volume, err = man.Get(volume.Name)
+	assert.Nil(err)
+	assert.Equal(int64(0), volume.Generation)
+	assert.Equal("host-2", volume.Controller.HostID)
+
+	orc.Lock()
+	orc.unreachable["host-1"] = false
+	orc.instanceOps = nil
+	orc.Unlock()
+	restore = stubProbes(false, map[string]interface{}{"host-3:9500": false})
+	err = man.Attach(volume.Name)
+	restore()
+	assert.Nil(err)
+	volume, err = man.Get(volume.Name)
+	assert.Nil(err)
+	assert.Equal(int64(1), volume.Generation)
+	assert.Equal("host-1", volume.Controller.HostID)
+	assert.False(volumeOnHost(volume, "host-2"))
+
+	// the replica left is stopped before the new controller starts
+	replicas := replicaInstances(volume)
+	assert.Len(replicas, 1)
+	orc.Lock()
+	ops := orc.instanceOps
+	orc.Unlock()
+	assert.Equal([]string{"stop " + replicas[0].Name, "start " + replicas[0].Name}, ops)
+
+	man.events.flush()
+	events, err := orc.ListVolumeEvents(volume.Name)
+	assert.Nil(err)
+	reasons := map[string]int{}
+	for _, e := range events {
+		reasons[e.Reason]++
+	}
+	assert.Equal(3, reasons[EventReasonFailoverRefused])
+	assert.Equal(1, reasons[EventReasonFenced])
+	assert.NotZero(reasons[EventReasonFencing])
+}
+
+func TestRemoveStaleControllers(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	volume, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	volume.Generation = 2
+	assert.Nil(orc.UpdateVolume(volume))
+
+	orc.localControllers = []*types.LocalController{
+		{ID: "stale", VolumeName: "vol", Generation: 1},
+		{ID: "current", VolumeName: "vol", Generation: 2},
+		{ID: "orphan", VolumeName: "gone", Generation: 0},
+	}
+	assert.Nil(man.removeStaleControllers())
+	controllers, err := orc.ListLocalControllers()
+	assert.Nil(err)
+	assert.Equal([]*types.LocalController{
+		{ID: "current", VolumeName: "vol", Generation: 2},
+		{ID: "orphan", VolumeName: "gone", Generation: 0},
+	}, controllers)
+}
@@ -0,0 +1,66 @@
+package manager
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func ValidateVolumeFilter(filter types.VolumeFilter) error {
+	switch filter.State {
+	case types.VolumeAttachStateAny, types.VolumeAttachStateAttached, types.VolumeAttachStateDetached:
+	default:
+		return errors.Errorf("invalid volume state filter '%s', expecting attached or detached", filter.State)
+	}
+	switch filter.Health {
+	case types.VolumeStateNone, types.VolumeStateHealthy, types.VolumeStateDegraded, types.VolumeStateFaulted:
+	default:
+		return errors.Errorf("invalid volume health filter '%s', expecting healthy, degraded or faulted", filter.Health)
+	}
+	for k := range filter.Selector {
+		if k == "" {
+			return errors.New("invalid volume selector: empty label name")
+		}
+	}
+	return nil
+}
+
+// volumeMatches checks the volume, its state completed, against the filter
+func volumeMatches(volume *types.VolumeInfo, filter types.VolumeFilter) bool {
+	switch filter.State {
+	case types.VolumeAttachStateAttached:
+		if volume.Controller == nil {
+			return false
+		}
+	case types.VolumeAttachStateDetached:
+		if volume.Controller != nil {
+			return false
+		}
+	}
+	if filter.Health != types.VolumeStateNone && volume.State != filter.Health {
+		return false
+	}
+	if filter.HostID != "" && !volumeOnHost(volume, filter.HostID) {
+		return false
+	}
+	return labelsMatch(volume.Labels, filter.Selector)
+}
+
+// ListVolumesFiltered lists the volumes matching the filter, with their
+// state computed as List does
+func (man *volumeManager) ListVolumesFiltered(filter types.VolumeFilter) ([]*types.VolumeInfo, error) {
+	if err := ValidateVolumeFilter(filter); err != nil {
+		return nil, err
+	}
+	volumes, err := man.List()
+	if err != nil {
+		return nil, err
+	}
+	matching := []*types.VolumeInfo{}
+	for _, v := range volumes {
+		if volumeMatches(v, filter) {
+			matching = append(matching, v)
+		}
+	}
+	return matching, nil
+}
@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func filterTestVolume(name string, controllerHost string, labels map[string]string, replicaHosts ...string) *types.VolumeInfo {
+	v := &types.VolumeInfo{
+		Name:             name,
+		Size:             4096,
+		NumberOfReplicas: 2,
+		Labels:           labels,
+		Replicas:         map[string]*types.ReplicaInfo{},
+	}
+	if controllerHost != "" {
+		v.Controller = &types.ControllerInfo{InstanceInfo: types.InstanceInfo{Name: name + "-controller", HostID: controllerHost, Running: true}}
+	}
+	for _, host := range replicaHosts {
+		r := &types.ReplicaInfo{InstanceInfo: types.InstanceInfo{Name: name + "-replica-" + host, HostID: host}, Mode: types.ReplicaModeRW}
+		v.Replicas[r.Name] = r
+	}
+	return v
+}
+
+func TestListVolumesFiltered(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, _ := newTestManager(orc)
+
+	billing := map[string]string{"team": "billing"}
+	billingDB := map[string]string{"team": "billing", "tier": "db"}
+	faulted := filterTestVolume("faulted", "", billing, "host-3")
+	faulted.Replicas["faulted-replica-host-3"].BadTimestamp = "2017-01-01T00:00:00Z"
+	for _, v := range []*types.VolumeInfo{
+		filterTestVolume("healthy", "host-1", billingDB, "host-1", "host-2"),
+		// a replica short
+		filterTestVolume("degraded", "host-2", billingDB, "host-2"),
+		filterTestVolume("detached", "", map[string]string{"team": "ops"}, "host-2", "host-3"),
+		faulted,
+	} {
+		_, err := orc.CreateVolume(v)
+		assert.Nil(err)
+	}
+
+	list := func(filter types.VolumeFilter) []string {
+		volumes, err := man.ListVolumesFiltered(filter)
+		assert.Nil(err)
+		names := []string{}
+		for _, v := range volumes {
+			names = append(names, v.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	assert.Equal([]string{"degraded", "detached", "faulted", "healthy"}, list(types.VolumeFilter{}))
+
+	// state
+	assert.Equal([]string{"degraded", "healthy"}, list(types.VolumeFilter{State: types.VolumeAttachStateAttached}))
+	assert.Equal([]string{"detached", "faulted"}, list(types.VolumeFilter{State: types.VolumeAttachStateDetached}))
+
+	// health, as computed for the volumes listed
+	assert.Equal([]string{"healthy"}, list(types.VolumeFilter{Health: types.VolumeStateHealthy}))
+	assert.Equal([]string{"degraded"}, list(types.VolumeFilter{Health: types.VolumeStateDegraded}))
+	assert.Equal([]string{"faulted"}, list(types.VolumeFilter{Health: types.VolumeStateFaulted}))
+
+	// host of the controller or of a replica
+	assert.Equal([]string{"healthy"}, list(types.VolumeFilter{HostID: "host-1"}))
+	assert.Equal([]string{"degraded", "detached", "healthy"}, list(types.VolumeFilter{HostID: "host-2"}))
+	assert.Equal([]string{"detached", "faulted"}, list(types.VolumeFilter{HostID: "host-3"}))
+	assert.Equal([]string{}, list(types.VolumeFilter{HostID: "host-4"}))
+
+	// labels
+	assert.Equal([]string{"degraded", "faulted", "healthy"}, list(types.VolumeFilter{Selector: billing}))
+	assert.Equal([]string{"degraded", "healthy"}, list(types.VolumeFilter{Selector: billingDB}))
+	assert.Equal([]string{}, list(types.VolumeFilter{Selector: map[string]string{"team": "payroll"}}))
+
+	// combined
+	assert.Equal([]string{"faulted"}, list(types.VolumeFilter{State: types.VolumeAttachStateDetached, Selector: billing}))
+	assert.Equal([]string{"degraded"}, list(types.VolumeFilter{State: types.VolumeAttachStateAttached, HostID: "host-2", Health: types.VolumeStateDegraded}))
+	assert.Equal([]string{}, list(types.VolumeFilter{State: types.VolumeAttachStateAttached, Health: types.VolumeStateFaulted}))
+	assert.Equal([]string{"detached"}, list(types.VolumeFilter{State: types.VolumeAttachStateDetached, HostID: "host-2", Selector: map[string]string{"team": "ops"}}))
+
+	for _, filter := range []types.VolumeFilter{
+		{State: "running"},
+		{Health: types.VolumeStateDetached},
+		{Selector: map[string]string{"": "x"}},
+	} {
+		_, err := man.ListVolumesFiltered(filter)
+		assert.NotNil(err)
+	}
+}
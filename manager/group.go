@@ -0,0 +1,446 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// GroupOperationConcurrency is how many members a group operation
+	// works on at once
+	GroupOperationConcurrency = 4
+	// GroupOperationTimeout bounds the operation on a member on another
+	// host
+	GroupOperationTimeout = 5 * time.Minute
+
+	// volumeAction runs the action of the volume API on the manager at
+	// address, the response is decoded into output unless nil
+	volumeAction = func(address, volumeName, action string, input, output interface{}) error {
+		body, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		client := http.Client{Timeout: GroupOperationTimeout, Transport: util.ManagerTransport}
+		path := fmt.Sprintf("/v1/volumes/%s?action=%s", url.PathEscape(volumeName), action)
+		resp, err := client.Post(util.ManagerURL(address, path), "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			msg, _ := ioutil.ReadAll(resp.Body)
+			return errors.Errorf("%v: %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+		if output == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(output)
+	}
+)
+
+const (
+	// VolumeGroupSnapshotLabel is set on the snapshots of a group snapshot,
+	// to the name of the group
+	VolumeGroupSnapshotLabel = "volumegroup"
+)
+
+// volumeStateRank orders the states of the members, the group has the
+// worst one
+var volumeStateRank = map[types.VolumeState]int{
+	types.VolumeStateHealthy:  1,
+	types.VolumeStateCreated:  2,
+	types.VolumeStateCreating: 3,
+	types.VolumeStateDetached: 4,
+	types.VolumeStateDegraded: 5,
+	types.VolumeStateFaulted:  6,
+}
+
+func ValidateVolumeGroupName(name string) error {
+	if !volumeLabelKey.MatchString(name) {
+		return errors.Errorf("invalid volume group name '%s', expecting letters, digits, '_', '.' or '-'", name)
+	}
+	return nil
+}
+
+func (man *volumeManager) CreateVolumeGroup(group *types.VolumeGroup) (*types.VolumeGroup, error) {
+	if err := ValidateVolumeGroupName(group.Name); err != nil {
+		return nil, err
+	}
+	if err := ValidateVolumeLabels(group.Selector); err != nil {
+		return nil, errors.Wrapf(err, "invalid selector of volume group '%s'", group.Name)
+	}
+	existing, err := man.orc.GetVolumeGroup(group.Name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errors.Errorf("volume group '%s' already exists", group.Name)
+	}
+	g := &types.VolumeGroup{
+		Name:     group.Name,
+		Selector: group.Selector,
+		Created:  util.Now(),
+	}
+	if err := man.addGroupVolumes(g, group.Volumes); err != nil {
+		return nil, err
+	}
+	if err := man.orc.SetVolumeGroup(g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (man *volumeManager) GetVolumeGroup(name string) (*types.VolumeGroup, error) {
+	return man.orc.GetVolumeGroup(name)
+}
+
+func (man *volumeManager) ListVolumeGroups() (map[string]*types.VolumeGroup, error) {
+	return man.orc.ListVolumeGroups()
+}
+
+// UpdateVolumeGroupVolumes adds the volumes to the list of the group, and
+// removes the others. The members by the selector leave the group only
+// once their labels change.
+func (man *volumeManager) UpdateVolumeGroupVolumes(name string, add, remove []string) error {
+	group, err := man.getVolumeGroup(name)
+	if err != nil {
+		return err
+	}
+	removed := map[string]bool{}
+	for _, v := range remove {
+		removed[v] = true
+	}
+	volumes := []string{}
+	for _, v := range group.Volumes {
+		if !removed[v] {
+			volumes = append(volumes, v)
+		}
+	}
+	group.Volumes = volumes
+	if err := man.addGroupVolumes(group, add); err != nil {
+		return err
+	}
+	return man.orc.SetVolumeGroup(group)
+}
+
+func (man *volumeManager) UpdateVolumeGroupSelector(name string, selector map[string]string) error {
+	if err := ValidateVolumeLabels(selector); err != nil {
+		return errors.Wrapf(err, "invalid selector of volume group '%s'", name)
+	}
+	group, err := man.getVolumeGroup(name)
+	if err != nil {
+		return err
+	}
+	group.Selector = selector
+	return man.orc.SetVolumeGroup(group)
+}
+
+func (man *volumeManager) addGroupVolumes(group *types.VolumeGroup, volumes []string) error {
+	listed := map[string]bool{}
+	for _, v := range group.Volumes {
+		listed[v] = true
+	}
+	for _, v := range volumes {
+		if listed[v] {
+			continue
+		}
+		volume, err := man.orc.GetVolume(v)
+		if err != nil {
+			return errors.Wrapf(err, "unable to get volume '%s'", v)
+		}
+		if volume == nil {
+			return errors.Errorf("cannot find volume '%s' to add to volume group '%s'", v, group.Name)
+		}
+		group.Volumes = append(group.Volumes, v)
+		listed[v] = true
+	}
+	return nil
+}
+
+func (man *volumeManager) getVolumeGroup(name string) (*types.VolumeGroup, error) {
+	group, err := man.orc.GetVolumeGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, errors.Errorf("cannot find volume group '%s'", name)
+	}
+	return group, nil
+}
+
+// groupMembers returns the members of the group sorted by name, and the
+// volumes listed which don't exist
+func (man *volumeManager) groupMembers(group *types.VolumeGroup) ([]*types.VolumeInfo, []string, error) {
+	volumes, err := man.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	byName := map[string]*types.VolumeInfo{}
+	for _, v := range volumes {
+		byName[v.Name] = v
+	}
+	isMember := map[string]bool{}
+	missing := []string{}
+	for _, name := range group.Volumes {
+		if byName[name] == nil {
+			missing = append(missing, name)
+			continue
+		}
+		isMember[name] = true
+	}
+	if len(group.Selector) != 0 {
+		for _, v := range volumes {
+			if labelsMatch(v.Labels, group.Selector) {
+				isMember[v.Name] = true
+			}
+		}
+	}
+	members := []*types.VolumeInfo{}
+	for name := range isMember {
+		members = append(members, byName[name])
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members, missing, nil
+}
+
+func labelsMatch(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// VolumeGroupStatus aggregates the states and sizes of the members. The
+// actual size is asked from the hosts of the replicas.
+func (man *volumeManager) VolumeGroupStatus(name string) (*types.VolumeGroupStatus, error) {
+	group, err := man.getVolumeGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	members, missing, err := man.groupMembers(group)
+	if err != nil {
+		return nil, err
+	}
+	status := &types.VolumeGroupStatus{
+		Members: []string{},
+		Missing: missing,
+	}
+	for _, v := range members {
+		status.Members = append(status.Members, v.Name)
+		status.Size += v.Size
+		if volumeStateRank[v.State] > volumeStateRank[status.State] {
+			status.State = v.State
+		}
+		allocated, err := man.actualSize(v)
+		if err != nil {
+			logrus.Warnf("%v", errors.Wrapf(err, "unable to get actual size of volume '%s' of group '%s'", v.Name, name))
+			status.ActualSizeUnknown = append(status.ActualSizeUnknown, v.Name)
+			continue
+		}
+		status.ActualSize += allocated
+	}
+	return status, nil
+}
+
+// actualSize is the space allocated by a good replica of the volume
+func (man *volumeManager) actualSize(volume *types.VolumeInfo) (int64, error) {
+	var replica *types.ReplicaInfo
+	for _, r := range volume.Replicas {
+		if r.BadTimestamp == "" && volume.StandbyReplicas[r.Name] == nil {
+			replica = r
+			break
+		}
+	}
+	if replica == nil {
+		return 0, errors.Errorf("no good replica")
+	}
+	usage := &types.DiskUsage{}
+	err := man.onHost(replica.HostID, volume.Name, "replicaDiskUsage", map[string]string{"name": replica.Name}, usage, func() error {
+		var err error
+		usage, err = man.GetReplicaDiskUsage(volume.Name, replica.Name)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return usage.AllocatedBytes, nil
+}
+
+// AttachVolumeGroup attaches the members detached, to hosts[member] if set,
+// otherwise to hostID if set, otherwise to their preferred host or the
+// current one
+func (man *volumeManager) AttachVolumeGroup(name, hostID string, hosts map[string]string) ([]*types.VolumeGroupResult, error) {
+	return man.groupOperation(name, func(volume *types.VolumeInfo) error {
+		if volume.Controller != nil {
+			return nil
+		}
+		target := hosts[volume.Name]
+		if target == "" {
+			target = hostID
+		}
+		if target == "" {
+			target = volume.PreferredHostID
+		}
+		return man.onHost(target, volume.Name, "attach", map[string]string{"hostId": target}, nil, func() error {
+			return man.Attach(volume.Name)
+		})
+	})
+}
+
+func (man *volumeManager) DetachVolumeGroup(name string) ([]*types.VolumeGroupResult, error) {
+	return man.groupOperation(name, func(volume *types.VolumeInfo) error {
+		if volume.Controller == nil {
+			return nil
+		}
+		return man.onHost(volume.Controller.HostID, volume.Name, "detach", struct{}{}, nil, func() error {
+			return man.Detach(volume.Name)
+		})
+	})
+}
+
+// SnapshotVolumeGroup takes a snapshot of the attached members, with the
+// same name and labelled with the group. The snapshots are taken at about
+// the same time, not atomically.
+func (man *volumeManager) SnapshotVolumeGroup(name, snapshotName string) (string, []*types.VolumeGroupResult, error) {
+	if snapshotName == "" {
+		snapshotName = util.UUID()
+	}
+	results, err := man.groupOperation(name, func(volume *types.VolumeInfo) error {
+		return man.snapshotMember(name, volume, snapshotName)
+	})
+	return snapshotName, results, err
+}
+
+// BackupVolumeGroup takes a group snapshot and backs it up for each member
+func (man *volumeManager) BackupVolumeGroup(name, snapshotName string) (string, []*types.VolumeGroupResult, error) {
+	if snapshotName == "" {
+		snapshotName = util.UUID()
+	}
+	results, err := man.groupOperation(name, func(volume *types.VolumeInfo) error {
+		if err := man.snapshotMember(name, volume, snapshotName); err != nil {
+			return err
+		}
+		return man.onHost(volume.Controller.HostID, volume.Name, "snapshotBackup", map[string]string{"name": snapshotName}, nil, func() error {
+			target, err := man.VolumeBackupTarget(volume.Name)
+			if err != nil {
+				return err
+			}
+			return man.StartBackup(volume.Name, &types.BackupBgTask{Snapshot: snapshotName, BackupTarget: target})
+		})
+	})
+	return snapshotName, results, err
+}
+
+func (man *volumeManager) snapshotMember(groupName string, volume *types.VolumeInfo, snapshotName string) error {
+	if volume.Controller == nil {
+		return errors.Errorf("volume '%s' is not attached", volume.Name)
+	}
+	labels := map[string]string{VolumeGroupSnapshotLabel: groupName}
+	input := map[string]interface{}{"name": snapshotName, "labels": labels}
+	return man.onHost(volume.Controller.HostID, volume.Name, "snapshotCreate", input, nil, func() error {
+		ops, err := man.SnapshotOps(volume.Name)
+		if err != nil {
+			return err
+		}
+		_, err = ops.Create(snapshotName, labels)
+		return err
+	})
+}
+
+// DeleteVolumeGroup deletes the group. Its members are only deleted with
+// deleteVolumes, confirmed by the name of the group, and the group is kept
+// if any of them is not.
+func (man *volumeManager) DeleteVolumeGroup(name string, deleteVolumes bool, confirm string) ([]*types.VolumeGroupResult, error) {
+	if !deleteVolumes {
+		if _, err := man.getVolumeGroup(name); err != nil {
+			return nil, err
+		}
+		return nil, man.orc.DeleteVolumeGroup(name)
+	}
+	if confirm != name {
+		return nil, errors.Errorf("deleting the volumes of volume group '%s' must be confirmed with the name of the group", name)
+	}
+	results, err := man.groupOperation(name, func(volume *types.VolumeInfo) error {
+		return man.Delete(volume.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return results, nil
+		}
+	}
+	return results, man.orc.DeleteVolumeGroup(name)
+}
+
+// groupOperation runs op on the members, GroupOperationConcurrency at a
+// time. The group operations don't overlap, while the operation on a member
+// goes through the same path as the one requested for the volume alone.
+func (man *volumeManager) groupOperation(name string, op func(volume *types.VolumeInfo) error) ([]*types.VolumeGroupResult, error) {
+	lock, err := man.acquireLock("volumegroup-"+name, "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to operate volume group '%s'", name)
+	}
+	defer lock.release()
+
+	group, err := man.getVolumeGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	members, _, err := man.groupMembers(group)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*types.VolumeGroupResult, len(members))
+	sem := make(chan struct{}, GroupOperationConcurrency)
+	wg := &sync.WaitGroup{}
+	for i, volume := range members {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, volume *types.VolumeInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = &types.VolumeGroupResult{Volume: volume.Name}
+			if err := lock.Err(); err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			if err := op(volume); err != nil {
+				logrus.Warnf("%v", errors.Wrapf(err, "operation of volume group '%s' failed on volume '%s'", name, volume.Name))
+				results[i].Error = err.Error()
+			}
+		}(i, volume)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// onHost runs the action on the volume with local if hostID is the current
+// host or empty, otherwise it asks the manager of the host
+func (man *volumeManager) onHost(hostID, volumeName, action string, input, output interface{}, local func() error) error {
+	if hostID == "" || hostID == man.orc.GetCurrentHostID() {
+		return local()
+	}
+	address, err := man.orc.GetAddress(hostID)
+	if err != nil {
+		return errors.Wrapf(err, "cannot find host %v", hostID)
+	}
+	return volumeAction(address, volumeName, action, input, output)
+}
@@ -0,0 +1,157 @@
+package manager
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+type fakeVolumeActions struct {
+	sync.Mutex
+
+	calls []string
+	// the actions on the volumes fail
+	failing map[string]bool
+}
+
+func (f *fakeVolumeActions) do(address, volumeName, action string, input, output interface{}) error {
+	f.Lock()
+	defer f.Unlock()
+	f.calls = append(f.calls, address+" "+volumeName+" "+action)
+	if f.failing[volumeName] {
+		return errors.Errorf("%v failed", action)
+	}
+	if usage, ok := output.(*types.DiskUsage); ok {
+		usage.AllocatedBytes = 1000
+	}
+	return nil
+}
+
+func groupErrors(results []*types.VolumeGroupResult) map[string]string {
+	errs := map[string]string{}
+	for _, r := range results {
+		errs[r.Volume] = r.Error
+	}
+	return errs
+}
+
+func TestVolumeGroup(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(action func(string, string, string, interface{}, interface{}) error) {
+		volumeAction = action
+	}(volumeAction)
+	actions := &fakeVolumeActions{failing: map[string]bool{}}
+	volumeAction = actions.do
+
+	orc := newFakeOrc("host-1", "host-2")
+	orc.settings.BackupTarget = "s3://backups@us-east-1/"
+	man, fc := newTestManager(orc)
+	for _, v := range []*types.VolumeInfo{
+		{Name: "vol-1", Size: 4096, NumberOfReplicas: 1},
+		{Name: "vol-2", Size: 8192, NumberOfReplicas: 1, Labels: map[string]string{"app": "db"}},
+		{Name: "vol-3", Size: 4096, NumberOfReplicas: 1, Labels: map[string]string{"app": "db"}},
+		{Name: "vol-4", Size: 4096, NumberOfReplicas: 1, Labels: map[string]string{"app": "web"}},
+	} {
+		_, err := man.Create(v)
+		assert.Nil(err)
+	}
+
+	_, err := man.CreateVolumeGroup(&types.VolumeGroup{Name: "app/1"})
+	assert.NotNil(err)
+	_, err = man.CreateVolumeGroup(&types.VolumeGroup{Name: "app", Volumes: []string{"missing"}})
+	assert.NotNil(err)
+	_, err = man.CreateVolumeGroup(&types.VolumeGroup{Name: "app", Volumes: []string{"vol-1"}, Selector: map[string]string{"app": "db"}})
+	assert.Nil(err)
+	_, err = man.CreateVolumeGroup(&types.VolumeGroup{Name: "app"})
+	assert.NotNil(err)
+
+	status, err := man.VolumeGroupStatus("app")
+	assert.Nil(err)
+	assert.Equal([]string{"vol-1", "vol-2", "vol-3"}, status.Members)
+	assert.Equal(int64(16384), status.Size)
+	assert.Equal(types.VolumeStateDetached, status.State)
+
+	// the members join and leave by the list or the labels
+	assert.Nil(man.UpdateVolumeGroupVolumes("app", []string{"vol-4"}, []string{"vol-1"}))
+	assert.NotNil(man.UpdateVolumeGroupVolumes("app", []string{"missing"}, nil))
+	assert.Nil(man.UpdateVolumeGroupSelector("app", nil))
+	status, err = man.VolumeGroupStatus("app")
+	assert.Nil(err)
+	assert.Equal([]string{"vol-4"}, status.Members)
+	assert.Nil(man.UpdateVolumeGroupVolumes("app", []string{"vol-1", "vol-2", "vol-3"}, []string{"vol-4"}))
+
+	// vol-3 is attached on host-2, which fails it
+	actions.failing["vol-3"] = true
+	results, err := man.AttachVolumeGroup("app", "", map[string]string{"vol-3": "host-2"})
+	assert.Nil(err)
+	assert.Equal(map[string]string{"vol-1": "", "vol-2": "", "vol-3": "attach failed"}, groupErrors(results))
+	assert.Equal([]string{"host-2:9500 vol-3 attach"}, actions.calls)
+	for _, name := range []string{"vol-1", "vol-2"} {
+		volume, err := man.Get(name)
+		assert.Nil(err)
+		assert.Equal(types.VolumeStateHealthy, volume.State)
+		fc.get(volume).(*fakeController).snapshots = newFakeSnapshotOps(time.Now())
+	}
+	status, err = man.VolumeGroupStatus("app")
+	assert.Nil(err)
+	assert.Equal(types.VolumeStateDetached, status.State)
+
+	// the snapshot has the same name on the members
+	snapshot, results, err := man.SnapshotVolumeGroup("app", "")
+	assert.Nil(err)
+	assert.NotEqual("", snapshot)
+	assert.Equal("", groupErrors(results)["vol-1"])
+	assert.Equal("", groupErrors(results)["vol-2"])
+	assert.NotEqual("", groupErrors(results)["vol-3"])
+	for _, name := range []string{"vol-1", "vol-2"} {
+		volume, err := man.Get(name)
+		assert.Nil(err)
+		snap, err := fc.get(volume).SnapshotOps().Get(snapshot)
+		assert.Nil(err)
+		assert.Equal("app", snap.Labels[VolumeGroupSnapshotLabel])
+	}
+	_, results, err = man.BackupVolumeGroup("app", "backup-1")
+	assert.Nil(err)
+	assert.Equal("", groupErrors(results)["vol-1"])
+	volume, err := man.Get("vol-1")
+	assert.Nil(err)
+	task := fc.get(volume).(*fakeController).queue.Take().Task.(*types.BackupBgTask)
+	assert.Equal("backup-1", task.Snapshot)
+
+	results, err = man.DetachVolumeGroup("app")
+	assert.Nil(err)
+	assert.Equal(map[string]string{"vol-1": "", "vol-2": "", "vol-3": ""}, groupErrors(results))
+	status, err = man.VolumeGroupStatus("app")
+	assert.Nil(err)
+	assert.Equal(types.VolumeStateDetached, status.State)
+
+	// the members are only deleted on request, confirmed
+	_, err = man.DeleteVolumeGroup("app", true, "")
+	assert.NotNil(err)
+	assert.Nil(man.UpdateVolumeGroupVolumes("app", nil, []string{"vol-2", "vol-3"}))
+	results, err = man.DeleteVolumeGroup("app", true, "app")
+	assert.Nil(err)
+	assert.Equal(map[string]string{"vol-1": ""}, groupErrors(results))
+	group, err := man.GetVolumeGroup("app")
+	assert.Nil(err)
+	assert.Nil(group)
+	volume, err = man.Get("vol-1")
+	assert.Nil(err)
+	assert.Nil(volume)
+
+	_, err = man.CreateVolumeGroup(&types.VolumeGroup{Name: "db", Selector: map[string]string{"app": "db"}})
+	assert.Nil(err)
+	_, err = man.DeleteVolumeGroup("db", false, "")
+	assert.Nil(err)
+	for _, name := range []string{"vol-2", "vol-3"} {
+		volume, err := man.Get(name)
+		assert.Nil(err)
+		assert.NotNil(volume)
+	}
+}
@@ -0,0 +1,95 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+var (
+	HealthCheckPeriod = 30 * time.Second
+)
+
+const (
+	EventReasonReplicaUnhealthy    = "ReplicaUnhealthy"
+	EventReasonControllerUnhealthy = "ControllerUnhealthy"
+)
+
+// checkLocalHealth handles the containers on the current host their
+// healthcheck found unhealthy like crashed ones: the replicas are removed
+// from their controller and marked bad, the controllers are failed over
+// the way the monitor does it.
+func (man *volumeManager) checkLocalHealth() error {
+	instances, err := man.orc.ListLocalInstances()
+	if err != nil {
+		return errors.Wrap(err, "unable to list local instances")
+	}
+	errs := Errs{}
+	for _, instance := range instances {
+		if !instance.Running || instance.Health != types.InstanceHealthUnhealthy {
+			continue
+		}
+		if err := man.handleUnhealthy(instance); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (man *volumeManager) handleUnhealthy(instance *types.LocalInstance) error {
+	volume, err := man.orc.GetVolume(instance.VolumeName)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s' of unhealthy instance %v", instance.VolumeName, instance.Name)
+	}
+	if volume == nil {
+		return nil
+	}
+	switch instance.Type {
+	case types.InstanceTypeReplica:
+		for _, replica := range volume.Replicas {
+			if replica.ID == instance.ID && replica.BadTimestamp == "" {
+				return man.failUnhealthyReplica(volume, replica)
+			}
+		}
+	case types.InstanceTypeController:
+		if volume.Controller == nil || volume.Controller.ID != instance.ID || !volume.Controller.Running {
+			return nil
+		}
+		logrus.Warnf("controller %v of volume '%s' is unhealthy, handling it as failed", instance.Name, volume.Name)
+		man.events.record(volume.Name, types.EventSeverityWarning, EventReasonControllerUnhealthy, "controller %v unhealthy", instance.Name)
+		if err := man.ControllerFailed(volume.Name); err != nil {
+			return errors.Wrapf(err, "error handling unhealthy controller of volume '%s'", volume.Name)
+		}
+	}
+	return nil
+}
+
+func (man *volumeManager) failUnhealthyReplica(volume *types.VolumeInfo, replica *types.ReplicaInfo) error {
+	logrus.Warnf("Marking bad unhealthy replica '%s'", replica.Address)
+	man.events.record(volume.Name, types.EventSeverityWarning, EventReasonReplicaUnhealthy, "replica %v unhealthy", replica.Address)
+	if volume.Controller != nil && volume.Controller.Running {
+		if err := man.getController(volume).RemoveReplica(replica); err != nil {
+			return errors.Wrapf(err, "failed to remove unhealthy replica '%s' from volume '%s'", replica.Address, volume.Name)
+		}
+	}
+	if err := man.orc.MarkBadReplica(volume.Name, replica); err != nil {
+		return errors.Wrapf(err, "failed to mark replica '%s' bad for volume '%s'", replica.Address, volume.Name)
+	}
+	return nil
+}
+
+func (man *volumeManager) healthCheck(ctx context.Context) error {
+	return runner.Tick(ctx, HealthCheckPeriod, func() {
+		if err := man.checkLocalHealth(); err != nil {
+			logrus.Warnf("%v", err)
+		}
+	})
+}
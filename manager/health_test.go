@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestCheckLocalHealth(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, fc := newTestManager(orc)
+
+	for _, name := range []string{"vol-a", "vol-b"} {
+		_, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2})
+		assert.Nil(err)
+		assert.Nil(man.Attach(name))
+	}
+	a, err := man.Get("vol-a")
+	assert.Nil(err)
+	b, err := man.Get("vol-b")
+	assert.Nil(err)
+	unhealthy, healthy := []*types.ReplicaInfo{}, []*types.ReplicaInfo{}
+	for _, r := range a.Replicas {
+		if len(unhealthy) == 0 {
+			unhealthy = append(unhealthy, r)
+		} else {
+			healthy = append(healthy, r)
+		}
+	}
+	stopped := &types.LocalInstance{ID: "stopped-id", Type: types.InstanceTypeReplica, VolumeName: "vol-b",
+		Health: types.InstanceHealthUnhealthy}
+	for _, r := range b.Replicas {
+		stopped.ID = r.ID
+		break
+	}
+	orc.localInstances = []*types.LocalInstance{
+		{ID: unhealthy[0].ID, Name: unhealthy[0].Name, Type: types.InstanceTypeReplica, VolumeName: "vol-a",
+			Running: true, Health: types.InstanceHealthUnhealthy},
+		{ID: healthy[0].ID, Name: healthy[0].Name, Type: types.InstanceTypeReplica, VolumeName: "vol-a",
+			Running: true, Health: types.InstanceHealthHealthy},
+		{ID: a.Controller.ID, Name: a.Controller.Name, Type: types.InstanceTypeController, VolumeName: "vol-a",
+			Running: true, Health: types.InstanceHealthStarting},
+		{ID: b.Controller.ID, Name: b.Controller.Name, Type: types.InstanceTypeController, VolumeName: "vol-b",
+			Running: true, Health: types.InstanceHealthUnhealthy},
+		// the container isn't running, it's handled like any stopped one
+		stopped,
+		// a volume deleted since
+		{ID: "gone-id", Type: types.InstanceTypeReplica, VolumeName: "gone", Running: true, Health: types.InstanceHealthUnhealthy},
+	}
+
+	assert.Nil(man.checkLocalHealth())
+
+	a, err = man.Get("vol-a")
+	assert.Nil(err)
+	assert.NotEqual("", a.Replicas[unhealthy[0].Name].BadTimestamp)
+	assert.Equal("", a.Replicas[healthy[0].Name].BadTimestamp)
+	assert.NotNil(a.Controller)
+	assert.Equal([]string{unhealthy[0].Address}, fc.get(a).(*fakeController).removed)
+
+	// the unhealthy controller failed, the volume isn't reattached by default
+	b, err = man.Get("vol-b")
+	assert.Nil(err)
+	assert.Nil(b.Controller)
+	for _, r := range b.Replicas {
+		assert.Equal("", r.BadTimestamp)
+	}
+
+	// the replica marked bad isn't handled again
+	assert.Nil(man.checkLocalHealth())
+	assert.Len(fc.get(a).(*fakeController).removed, 1)
+}
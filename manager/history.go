@@ -0,0 +1,133 @@
+package manager
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+// stateRecorder records the state transitions of the volumes at the writes
+// of the manager changing them, the reads of the volumes record nothing
+type stateRecorder struct {
+	orc    types.Orchestrator
+	hostID string
+}
+
+func newStateRecorder(orc types.Orchestrator, hostID string) *stateRecorder {
+	return &stateRecorder{
+		orc:    orc,
+		hostID: hostID,
+	}
+}
+
+// record reads the state of the volume after a write, and records it at the
+// time of the write if it changed
+func (r *stateRecorder) record(volumeName, at string) {
+	if volumeName == "" {
+		return
+	}
+	volume, err := r.orc.GetVolume(volumeName)
+	if err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "failed to read state of volume '%s' to record it", volumeName))
+		return
+	}
+	if volume == nil {
+		return
+	}
+	state := volumeState(volume)
+	if _, err := r.orc.RecordVolumeState(volumeName, state, r.hostID, at); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "failed to record state %v of volume '%s'", state, volumeName))
+	}
+}
+
+// discard removes the history of a volume gone
+func (r *stateRecorder) discard(volumeName string) error {
+	return r.orc.DeleteVolumeHistory(volumeName)
+}
+
+// historyOrc is the orchestrator of the manager, recording the state of the
+// volume after each of its writes which may change it: the replicas created,
+// marked bad or removed, the controller created on attach or removed on
+// detach or failure, and the updates of the volume
+type historyOrc struct {
+	types.Orchestrator
+	states *stateRecorder
+}
+
+// wrote records the state of the volume, deferred by the writes so the
+// time is taken once the write returns
+func (o *historyOrc) wrote(volumeName string) {
+	o.states.record(volumeName, util.Now())
+}
+
+func (o *historyOrc) CreateVolume(volume *types.VolumeInfo) (*types.VolumeInfo, error) {
+	defer o.wrote(volume.Name)
+	return o.Orchestrator.CreateVolume(volume)
+}
+
+func (o *historyOrc) MarkBadReplica(volumeName string, replica *types.ReplicaInfo) error {
+	defer o.wrote(volumeName)
+	return o.Orchestrator.MarkBadReplica(volumeName, replica)
+}
+
+func (o *historyOrc) UpdateVolume(volume *types.VolumeInfo) error {
+	defer o.wrote(volume.Name)
+	return o.Orchestrator.UpdateVolume(volume)
+}
+
+func (o *historyOrc) UpdateVolumeBase(volumeName string, update func(volume *types.VolumeInfo) error) (*types.VolumeInfo, error) {
+	defer o.wrote(volumeName)
+	return o.Orchestrator.UpdateVolumeBase(volumeName, update)
+}
+
+func (o *historyOrc) CreateController(volumeName, controllerName string, replicas map[string]*types.ReplicaInfo) (*types.ControllerInfo, error) {
+	defer o.wrote(volumeName)
+	return o.Orchestrator.CreateController(volumeName, controllerName, replicas)
+}
+
+func (o *historyOrc) CreateReplica(volumeName, replicaName string) (*types.ReplicaInfo, error) {
+	defer o.wrote(volumeName)
+	return o.Orchestrator.CreateReplica(volumeName, replicaName)
+}
+
+func (o *historyOrc) RestartController(volume *types.VolumeInfo) (*types.ControllerInfo, string, error) {
+	defer o.wrote(volume.Name)
+	return o.Orchestrator.RestartController(volume)
+}
+
+func (o *historyOrc) RemoveInstance(instance *types.InstanceInfo) (*types.InstanceInfo, error) {
+	defer o.wrote(instance.VolumeName)
+	return o.Orchestrator.RemoveInstance(instance)
+}
+
+func (o *historyOrc) ForgetInstance(instance *types.InstanceInfo) error {
+	defer o.wrote(instance.VolumeName)
+	return o.Orchestrator.ForgetInstance(instance)
+}
+
+func (o *historyOrc) SetVolumeRawRecord(volumeName string, record *types.RawRecord) error {
+	defer o.wrote(volumeName)
+	return o.Orchestrator.SetVolumeRawRecord(volumeName, record)
+}
+
+// GetVolumeHistory returns the latest limit state transitions of the volume,
+// all of those kept if limit is 0, oldest first
+func (man *volumeManager) GetVolumeHistory(volumeName string, limit int) ([]types.StateTransition, error) {
+	if limit < 0 {
+		return nil, errors.Errorf("invalid limit %v, expecting 0 or more", limit)
+	}
+	volume, err := man.orc.GetVolume(volumeName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get history of volume '%s'", volumeName)
+	}
+	if volume == nil {
+		return nil, errors.Errorf("cannot find volume '%s'", volumeName)
+	}
+	transitions, err := man.orc.GetVolumeHistory(volumeName, limit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get history of volume '%s'", volumeName)
+	}
+	return transitions, nil
+}
@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+func TestVolumeHistory(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(types.VolumeStateDetached, volume.State)
+	assert.Nil(man.Attach("vol"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(types.VolumeStateHealthy, volume.State)
+
+	// the reads record nothing
+	recorded, err := orc.GetVolumeHistory("vol", 0)
+	assert.Nil(err)
+	orc.Lock()
+	for _, replica := range orc.volumes["vol"].Replicas {
+		replica.BadTimestamp = util.Now()
+	}
+	orc.Unlock()
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(types.VolumeStateFaulted, volume.State)
+	_, err = man.List()
+	assert.Nil(err)
+	history, err := orc.GetVolumeHistory("vol", 0)
+	assert.Nil(err)
+	assert.Equal(recorded, history)
+	orc.Lock()
+	for _, replica := range orc.volumes["vol"].Replicas {
+		replica.BadTimestamp = ""
+	}
+	orc.Unlock()
+
+	// the replica marked bad is recorded at the time of the write
+	for _, replica := range volume.Replicas {
+		before := util.Now()
+		assert.Nil(man.orc.MarkBadReplica("vol", replica))
+		latest, err := orc.GetVolumeHistory("vol", 1)
+		assert.Nil(err)
+		assert.Equal(types.VolumeStateDegraded, latest[0].To)
+		assert.True(latest[0].Time >= before)
+		break
+	}
+	assert.Nil(man.Detach("vol"))
+
+	history, err = man.GetVolumeHistory("vol", 0)
+	assert.Nil(err)
+	states := []types.VolumeState{}
+	for i, transition := range history {
+		if i > 0 {
+			assert.Equal(history[i-1].To, transition.From)
+			assert.True(history[i-1].Time <= transition.Time)
+		}
+		assert.Equal("host-1", transition.HostID)
+		states = append(states, transition.To)
+	}
+	assert.Equal(types.VolumeStateNone, history[0].From)
+	assert.Equal([]types.VolumeState{
+		types.VolumeStateDetached,
+		types.VolumeStateHealthy,
+		types.VolumeStateDegraded,
+		types.VolumeStateDetached,
+	}, states[len(states)-4:])
+
+	latest, err := man.GetVolumeHistory("vol", 2)
+	assert.Nil(err)
+	assert.Equal(history[len(history)-2:], latest)
+	_, err = man.GetVolumeHistory("vol", -1)
+	assert.NotNil(err)
+	_, err = man.GetVolumeHistory("missing", 0)
+	assert.NotNil(err)
+
+	// a volume created again with the same name starts over
+	assert.Nil(man.Delete("vol"))
+	history, err = orc.GetVolumeHistory("vol", 0)
+	assert.Nil(err)
+	assert.Len(history, 0)
+	_, err = man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	history, err = man.GetVolumeHistory("vol", 0)
+	assert.Nil(err)
+	assert.Equal(types.VolumeStateNone, history[0].From)
+	assert.Equal(types.VolumeStateDetached, history[len(history)-1].To)
+}
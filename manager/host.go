@@ -0,0 +1,246 @@
+package manager
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/scheduler"
+	"github.com/rancher/longhorn-manager/types"
+)
+
+var (
+	// HostLowDiskPercentage of the storage available marks the host low
+	// on disk
+	HostLowDiskPercentage int64 = 10
+)
+
+// HostDetails fills in the detail of the hosts from the volume metadata and
+// the heartbeats observed, without asking the hosts
+func (man *volumeManager) HostDetails(hosts map[string]*types.HostInfo) error {
+	details := map[string]*types.HostDetail{}
+	for id := range hosts {
+		details[id] = &types.HostDetail{
+			Conditions:           []types.HostCondition{},
+			UnschedulableReasons: []types.HostCondition{},
+		}
+	}
+	now := time.Now()
+	if err := man.orc.ForEachVolume("", func(volume *types.VolumeInfo) error {
+		if reserving(volume.ReplicaPlan, now) {
+			for _, r := range volume.ReplicaPlan.Replicas {
+				if d := details[r.HostID]; d != nil {
+					d.ReservedBytes += volume.Size
+				}
+			}
+		}
+		if volume.Controller != nil {
+			if d := details[volume.Controller.HostID]; d != nil {
+				d.Controllers++
+			}
+		}
+		for _, replica := range volume.Replicas {
+			if d := details[replica.HostID]; d != nil {
+				d.Replicas++
+				if replica.BadTimestamp == "" {
+					d.ReservedBytes += volume.Size
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "unable to list volumes")
+	}
+
+	for id, host := range hosts {
+		man.clocks.annotate(host)
+		d := details[id]
+		d.Online = id == man.orc.GetCurrentHostID() || man.clocks.online(id, HostOfflineTimeout)
+		d.AvailableBytes = host.StorageAvailable
+		if !d.Online {
+			d.Conditions = append(d.Conditions, types.HostConditionOffline)
+			d.UnschedulableReasons = append(d.UnschedulableReasons, types.HostConditionOffline)
+		}
+		if host.Unschedulable {
+			d.Conditions = append(d.Conditions, types.HostConditionCordoned)
+			d.UnschedulableReasons = append(d.UnschedulableReasons, types.HostConditionCordoned)
+		}
+		if host.Conflicted {
+			d.Conditions = append(d.Conditions, types.HostConditionConflicted)
+			d.UnschedulableReasons = append(d.UnschedulableReasons, types.HostConditionConflicted)
+		}
+		if !host.Role.RunsReplicas() {
+			d.Conditions = append(d.Conditions, types.HostConditionComputeOnly)
+			d.UnschedulableReasons = append(d.UnschedulableReasons, types.HostConditionComputeOnly)
+		}
+		if host.RecoveryCooldown > 0 {
+			d.Conditions = append(d.Conditions, types.HostConditionRecovering)
+			d.UnschedulableReasons = append(d.UnschedulableReasons, types.HostConditionRecovering)
+		}
+		if host.SkewDetected {
+			d.Conditions = append(d.Conditions, types.HostConditionClockSkewed)
+		}
+		if host.StorageTotal > 0 && host.StorageAvailable*100 < host.StorageTotal*HostLowDiskPercentage {
+			d.Conditions = append(d.Conditions, types.HostConditionLowDisk)
+		}
+		d.Schedulable = len(d.UnschedulableReasons) == 0
+		host.Detail = d
+	}
+	return nil
+}
+
+// ValidateSchedulingWeight allows 0 for the default weight of 1
+func ValidateSchedulingWeight(weight int) error {
+	if weight < 0 {
+		return errors.Errorf("invalid scheduling weight %v, expecting 0 or more", weight)
+	}
+	return nil
+}
+
+func ValidateCapacityCheck(check *types.CapacityCheck) error {
+	if check.Count <= 0 {
+		return errors.Errorf("invalid count %v, expecting a positive number", check.Count)
+	}
+	if check.Size <= 0 {
+		return errors.Errorf("invalid size %v, expecting a positive size", check.Size)
+	}
+	if check.Replicas <= 0 {
+		return errors.Errorf("invalid replicas %v, expecting a positive number", check.Replicas)
+	}
+	if check.OverProvisioningPercentage < 0 || check.MaxReplicasPerHost < 0 {
+		return errors.Errorf("invalid overProvisioningPercentage %v or maxReplicasPerHost %v, expecting 0 or more",
+			check.OverProvisioningPercentage, check.MaxReplicasPerHost)
+	}
+	return nil
+}
+
+// CapacityCheck simulates placing the volumes of the check on the current
+// hosts, without reserving anything. The real scheduler doesn't check the
+// capacity of the hosts.
+func (man *volumeManager) CapacityCheck(check *types.CapacityCheck) (*types.CapacityCheckResult, error) {
+	if err := ValidateCapacityCheck(check); err != nil {
+		return nil, err
+	}
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list hosts")
+	}
+	if err := man.HostDetails(hosts); err != nil {
+		return nil, err
+	}
+	return scheduler.SimulateCapacity(hosts, check)
+}
+
+// hostInstances lists the containers of the host which are instances of the
+// volumes, controllers first. The containers left by deleted volumes or
+// instances are left alone, they're reported by AuditConsistency.
+func (man *volumeManager) hostInstances(hostID string) ([]*types.InstanceInfo, error) {
+	host, err := man.orc.GetHost(hostID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get host %v", hostID)
+	}
+	if host == nil {
+		return nil, errors.Errorf("cannot find host %v", hostID)
+	}
+	var local []*types.LocalInstance
+	if hostID == man.orc.GetCurrentHostID() {
+		local, err = man.orc.ListLocalInstances()
+	} else {
+		local, err = listHostInstances(host.Address)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list the containers on host %v", hostID)
+	}
+
+	volumes := map[string]*types.VolumeInfo{}
+	instances := []*types.InstanceInfo{}
+	for _, l := range local {
+		volume, ok := volumes[l.VolumeName]
+		if !ok {
+			if volume, err = man.orc.GetVolume(l.VolumeName); err != nil {
+				return nil, errors.Wrapf(err, "unable to get volume %v", l.VolumeName)
+			}
+			volumes[l.VolumeName] = volume
+		}
+		if volume == nil {
+			continue
+		}
+		var instance *types.InstanceInfo
+		if volume.Controller != nil && volume.Controller.ID == l.ID {
+			instance = &volume.Controller.InstanceInfo
+		}
+		for _, replica := range volume.Replicas {
+			if replica.ID == l.ID {
+				instance = &replica.InstanceInfo
+			}
+		}
+		if instance == nil || instance.HostID != hostID {
+			continue
+		}
+		copied := *instance
+		copied.Running = l.Running
+		instances = append(instances, &copied)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Type != instances[j].Type {
+			return instances[i].Type == types.InstanceTypeController
+		}
+		return instances[i].Name < instances[j].Name
+	})
+	return instances, nil
+}
+
+// StopAllInstancesOnHost stops the running instances of the host for a
+// maintenance, the controllers before the replicas they write to. It goes
+// on past the instances failing to stop, and returns their errors. The
+// volumes of the controllers stopped fail over as for any failed
+// controller, unless the host is in a maintenance window.
+func (man *volumeManager) StopAllInstancesOnHost(hostID string) error {
+	instances, err := man.hostInstances(hostID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to stop the instances on host %v", hostID)
+	}
+	errs := Errs{}
+	for _, instance := range instances {
+		if !instance.Running {
+			continue
+		}
+		if _, err := man.orc.StopInstance(instance); err != nil {
+			errs = append(errs, errors.Wrapf(err, "fail to stop %v %v of volume %v", instance.Type, instance.Name, instance.VolumeName))
+			continue
+		}
+		logrus.Infof("Stopped %v %v of volume %v on host %v", instance.Type, instance.Name, instance.VolumeName, hostID)
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// StartAllInstancesOnHost starts the stopped instances of the host after a
+// maintenance, the replicas before the controllers connecting to them. It
+// goes on past the instances failing to start, and returns their errors.
+func (man *volumeManager) StartAllInstancesOnHost(hostID string) error {
+	instances, err := man.hostInstances(hostID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to start the instances on host %v", hostID)
+	}
+	errs := Errs{}
+	for i := len(instances) - 1; i >= 0; i-- {
+		instance := instances[i]
+		if instance.Running {
+			continue
+		}
+		if _, err := man.orc.StartInstance(instance); err != nil {
+			errs = append(errs, errors.Wrapf(err, "fail to start %v %v of volume %v", instance.Type, instance.Name, instance.VolumeName))
+			continue
+		}
+		logrus.Infof("Started %v %v of volume %v on host %v", instance.Type, instance.Name, instance.VolumeName, hostID)
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
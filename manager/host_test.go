@@ -0,0 +1,219 @@
+package manager
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+func TestHostDetails(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, clock := newSkewTestManager(orc)
+
+	// fake scheduling puts the replicas on host-1 and host-2
+	volume, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach(volume.Name))
+
+	heartbeats(orc, clock, nil)
+	assert.Nil(man.checkClockSkew())
+
+	// host-3 stops sending heartbeats
+	for i := 0; i < 4; i++ {
+		clock.now = clock.now.Add(HostHeartbeatPeriod)
+		orc.Lock()
+		orc.hosts["host-2"].Heartbeat = util.FormatTimeZ(clock.now)
+		orc.Unlock()
+		assert.Nil(man.checkClockSkew())
+	}
+	assert.Nil(man.UpdateHostSchedulable("host-2", false))
+	orc.Lock()
+	orc.hosts["host-2"].StorageTotal = 1000
+	orc.hosts["host-2"].StorageAvailable = 50
+	orc.Unlock()
+
+	hosts, err := man.ListHosts()
+	assert.Nil(err)
+	assert.Nil(man.HostDetails(hosts))
+
+	d := hosts["host-1"].Detail
+	assert.True(d.Online)
+	assert.True(d.Schedulable)
+	assert.Len(d.Conditions, 0)
+	assert.Equal(1, d.Controllers)
+	assert.Equal(1, d.Replicas)
+	assert.Equal(int64(4096), d.ReservedBytes)
+
+	d = hosts["host-2"].Detail
+	assert.True(d.Online)
+	assert.False(d.Schedulable)
+	assert.Equal([]types.HostCondition{types.HostConditionCordoned, types.HostConditionLowDisk}, d.Conditions)
+	assert.Equal([]types.HostCondition{types.HostConditionCordoned}, d.UnschedulableReasons)
+	assert.Equal(0, d.Controllers)
+	assert.Equal(1, d.Replicas)
+	assert.Equal(int64(50), d.AvailableBytes)
+
+	d = hosts["host-3"].Detail
+	assert.False(d.Online)
+	assert.False(d.Schedulable)
+	assert.Equal([]types.HostCondition{types.HostConditionOffline}, d.UnschedulableReasons)
+	assert.Equal(0, d.Replicas)
+}
+
+func TestResolveHostConflict(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	assert.NotNil(man.ResolveHostConflict("host-2", "nonce-a"))
+	assert.NotNil(man.ResolveHostConflict("host-3", "nonce-a"))
+
+	orc.hosts["host-2"].Conflicted = true
+	orc.hosts["host-2"].ConflictNonces = []string{"nonce-a", "nonce-b"}
+	hosts, err := man.ListHosts()
+	assert.Nil(err)
+	assert.Nil(man.HostDetails(hosts))
+	d := hosts["host-2"].Detail
+	assert.False(d.Schedulable)
+	assert.Contains(d.Conditions, types.HostConditionConflicted)
+	assert.Contains(d.UnschedulableReasons, types.HostConditionConflicted)
+
+	assert.NotNil(man.ResolveHostConflict("host-2", "nonce-c"))
+	assert.Equal("", orc.hosts["host-2"].RegenerateNonce)
+	assert.Nil(man.ResolveHostConflict("host-2", "nonce-b"))
+	assert.Equal("nonce-b", orc.hosts["host-2"].RegenerateNonce)
+}
+
+func TestCapacityCheck(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, clock := newSkewTestManager(orc)
+
+	// fake scheduling puts the replicas on host-1 and host-2
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	orc.Lock()
+	for _, host := range orc.hosts {
+		host.StorageTotal = 4096 * 4
+	}
+	orc.Unlock()
+	heartbeats(orc, clock, nil)
+	assert.Nil(man.checkClockSkew())
+	assert.Nil(man.UpdateHostSchedulable("host-3", false))
+
+	result, err := man.CapacityCheck(&types.CapacityCheck{Count: 10, Size: 4096, Replicas: 2})
+	assert.Nil(err)
+	assert.Equal(3, result.Fits)
+	assert.Equal(types.CapacityLimitSpace, result.Limit)
+	assert.Equal(map[string]string{"host-3": string(types.HostConditionCordoned)}, result.ExcludedHosts)
+
+	// the check doesn't create anything
+	volumes, err := man.List()
+	assert.Nil(err)
+	assert.Len(volumes, 1)
+
+	_, err = man.CapacityCheck(&types.CapacityCheck{Count: 1, Size: 4096})
+	assert.NotNil(err)
+}
+
+// hostContainers lists the instances of the volumes on the host as their
+// containers
+func hostContainers(orc *fakeOrc, hostID string) []*types.LocalInstance {
+	containers := []*types.LocalInstance{}
+	for _, volume := range orc.volumes {
+		instances := replicaInstances(volume)
+		if volume.Controller != nil {
+			instances = append(instances, &volume.Controller.InstanceInfo)
+		}
+		for _, instance := range instances {
+			if instance.HostID != hostID {
+				continue
+			}
+			containers = append(containers, &types.LocalInstance{
+				ID:         instance.ID,
+				Name:       instance.Name,
+				Type:       instance.Type,
+				VolumeName: volume.Name,
+				Running:    instance.Running,
+			})
+		}
+	}
+	return containers
+}
+
+func TestStopStartAllInstancesOnHost(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+	for _, name := range []string{"vol-a", "vol-b"} {
+		_, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2})
+		assert.Nil(err)
+		assert.Nil(man.Attach(name))
+	}
+	// a container left by a deleted volume
+	orc.localInstances = append(hostContainers(orc, "host-1"), &types.LocalInstance{
+		ID: "gone-replica-id", Name: "gone-replica", Type: types.InstanceTypeReplica, VolumeName: "gone",
+	})
+	expected := []string{}
+	for _, instance := range orc.localInstances {
+		if instance.VolumeName != "gone" {
+			expected = append(expected, instance.Name)
+		}
+	}
+	assert.Len(expected, 4)
+
+	// the controllers first
+	orc.instanceOps = nil
+	assert.Nil(man.StopAllInstancesOnHost("host-1"))
+	assert.Len(orc.instanceOps, len(expected))
+	stopped := []string{}
+	for i, op := range orc.instanceOps {
+		assert.True(strings.HasPrefix(op, "stop "), op)
+		name := strings.TrimPrefix(op, "stop ")
+		stopped = append(stopped, name)
+		assert.Equal(i < 2, strings.HasSuffix(name, "-controller"), op)
+	}
+	sort.Strings(stopped)
+	sort.Strings(expected)
+	assert.Equal(expected, stopped)
+	for _, instance := range hostContainers(orc, "host-1") {
+		assert.False(instance.Running, instance.Name)
+	}
+	for _, instance := range hostContainers(orc, "host-2") {
+		assert.True(instance.Running, instance.Name)
+	}
+
+	// the controllers last
+	orc.localInstances = hostContainers(orc, "host-1")
+	orc.instanceOps = nil
+	assert.Nil(man.StartAllInstancesOnHost("host-1"))
+	assert.Len(orc.instanceOps, len(expected))
+	for i, op := range orc.instanceOps {
+		assert.True(strings.HasPrefix(op, "start "), op)
+		assert.Equal(i >= 2, strings.HasSuffix(op, "-controller"), op)
+	}
+	for _, instance := range hostContainers(orc, "host-1") {
+		assert.True(instance.Running, instance.Name)
+	}
+
+	// every instance is tried, the errors returned together
+	orc.localInstances = hostContainers(orc, "host-1")
+	orc.unreachable = map[string]bool{"host-1": true}
+	err := man.StopAllInstancesOnHost("host-1")
+	assert.NotNil(err)
+	errs, ok := err.(Errs)
+	assert.True(ok)
+	assert.Len(errs, len(expected))
+
+	assert.NotNil(man.StopAllInstancesOnHost("host-3"))
+}
@@ -0,0 +1,70 @@
+package manager
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// ListVolumesUsingImage lists the volumes with a controller or a replica
+// container created from the engine image, whatever the engine image of the
+// volume in the metadata: a volume upgraded runs the old image until its
+// containers are created again. The stopped containers count, they start
+// again with their image, so every host must list its containers. The
+// containers left by deleted volumes are reported by AuditConsistency.
+// Docker reports the image ID of the containers created from the ID or a
+// digest instead of the name, so the image matches by ID too, as found on
+// the current host or on the containers created from its name.
+func (man *volumeManager) ListVolumesUsingImage(image string) ([]*types.VolumeInfo, error) {
+	if image == "" {
+		return nil, errors.New("unable to list volumes using image: no image given")
+	}
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list hosts")
+	}
+	imageID, err := man.orc.GetImageID(image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to resolve the ID of image %v", image)
+	}
+	imageIDs := map[string]bool{}
+	if imageID != "" {
+		imageIDs[imageID] = true
+	}
+	all := []*types.LocalInstance{}
+	for id, host := range hosts {
+		var instances []*types.LocalInstance
+		if id == man.orc.GetCurrentHostID() {
+			instances, err = man.orc.ListLocalInstances()
+		} else {
+			instances, err = listHostInstances(host.Address)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list the containers on host %v for image %v", id, image)
+		}
+		for _, instance := range instances {
+			if instance.Image == image && instance.ImageID != "" {
+				imageIDs[instance.ImageID] = true
+			}
+		}
+		all = append(all, instances...)
+	}
+	using := map[string]bool{}
+	for _, instance := range all {
+		if instance.Image == image || imageIDs[instance.ImageID] {
+			using[instance.VolumeName] = true
+		}
+	}
+
+	volumes, err := man.List()
+	if err != nil {
+		return nil, err
+	}
+	matching := []*types.VolumeInfo{}
+	for _, v := range volumes {
+		if using[v.Name] {
+			matching = append(matching, v)
+		}
+	}
+	return matching, nil
+}
@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestListVolumesUsingImage(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	for _, name := range []string{"old", "upgraded", "upgrading", "by-id"} {
+		_, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2, EngineImage: "engine:v1"})
+		assert.Nil(err)
+	}
+	assert.Nil(man.Attach("old"))
+	// the metadata of both is upgraded, a replica of one still runs the
+	// old image
+	orc.volumes["upgraded"].EngineImage = "engine:v2"
+	orc.volumes["upgrading"].EngineImage = "engine:v2"
+	imageIDs := map[string]string{"engine:v1": "sha256:v1", "engine:v2": "sha256:v2"}
+
+	live := map[string][]*types.LocalInstance{}
+	for _, volume := range orc.volumes {
+		instances := replicaInstances(volume)
+		if volume.Controller != nil {
+			instances = append(instances, &volume.Controller.InstanceInfo)
+		}
+		for i, instance := range instances {
+			image := volume.EngineImage
+			if volume.Name == "upgrading" && i == 0 {
+				image = "engine:v1"
+			}
+			imageID := imageIDs[image]
+			// created from the ID, docker reports it as the image
+			if volume.Name == "by-id" {
+				image = imageID
+			}
+			live[instance.HostID] = append(live[instance.HostID], &types.LocalInstance{
+				ID:         instance.ID,
+				Name:       instance.Name,
+				Type:       instance.Type,
+				VolumeName: volume.Name,
+				Running:    instance.Running,
+				Image:      image,
+				ImageID:    imageID,
+			})
+		}
+	}
+	orc.localInstances = live["host-1"]
+	defer func(list func(string) ([]*types.LocalInstance, error)) { listHostInstances = list }(listHostInstances)
+	listHostInstances = func(address string) ([]*types.LocalInstance, error) {
+		if address != "host-2:9500" {
+			return nil, errors.Errorf("unexpected address %v", address)
+		}
+		return live["host-2"], nil
+	}
+
+	volumes, err := man.ListVolumesUsingImage("engine:v1")
+	assert.Nil(err)
+	names := []string{}
+	for _, v := range volumes {
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+	assert.Equal([]string{"by-id", "old", "upgrading"}, names)
+	volumes, err = man.ListVolumesUsingImage("sha256:v1")
+	assert.Nil(err)
+	assert.Len(volumes, 3)
+
+	volumes, err = man.ListVolumesUsingImage("engine:v2")
+	assert.Nil(err)
+	assert.Len(volumes, 2)
+	// no container created from the name, resolved on the current host
+	volumes, err = man.ListVolumesUsingImage("engine:latest")
+	assert.Nil(err)
+	assert.Len(volumes, 0)
+	orc.imageIDs = map[string]string{"engine:latest": "sha256:v2"}
+	volumes, err = man.ListVolumesUsingImage("engine:latest")
+	assert.Nil(err)
+	assert.Len(volumes, 2)
+	volumes, err = man.ListVolumesUsingImage("engine:v0")
+	assert.Nil(err)
+	assert.Len(volumes, 0)
+
+	// a host not answering may run the image
+	listHostInstances = func(address string) ([]*types.LocalInstance, error) {
+		return nil, errors.Errorf("connection refused")
+	}
+	_, err = man.ListVolumesUsingImage("engine:v0")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "host host-2")
+}
@@ -0,0 +1,55 @@
+package manager
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// checkLeaks fails the test if it leaves goroutines behind, deferred at its
+// start with defer checkLeaks(t)(). The goroutines ending on their own are
+// given a second.
+func checkLeaks(t *testing.T) func() {
+	before := runtime.NumGoroutine()
+	return func() {
+		after := 0
+		for i := 0; i < 100; i++ {
+			if after = runtime.NumGoroutine(); after <= before {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		stacks := make([]byte, 1<<20)
+		stacks = stacks[:runtime.Stack(stacks, true)]
+		t.Errorf("%v goroutines left behind, %v before the test:\n%s", after-before, before, stacks)
+	}
+}
+
+func TestStartShutdown(t *testing.T) {
+	defer checkLeaks(t)()
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+
+	assert.Nil(man.Start())
+	runners := man.RunnerStatus()
+	assert.Len(runners, 11)
+	// stopped the last
+	assert.Equal("events", runners[0].Name)
+
+	assert.Nil(man.Attach("vol"))
+	man.Shutdown()
+	for _, r := range man.RunnerStatus() {
+		assert.False(r.Running, r.Name)
+	}
+	events, err := man.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Equal([]string{EventReasonCreated, EventReasonAttached}, eventReasons(events))
+}
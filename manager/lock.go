@@ -0,0 +1,126 @@
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// LockTTL is how long a lock outlives the last refresh of its holder
+	LockTTL = 30 * time.Second
+	// LockRefreshInterval is how often the holder refreshes its locks
+	LockRefreshInterval = 10 * time.Second
+)
+
+const (
+	EventReasonLockBroken = "LockBroken"
+)
+
+// operationLock is a lock held by an operation of this manager. It's
+// refreshed in the background until released, the operation checks Err
+// between its steps and aborts once the lock is lost.
+type operationLock struct {
+	sync.Mutex
+
+	orc         types.Orchestrator
+	name        string
+	operationID string
+	err         error
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// acquireLock takes the lock for a new operation. volumeName is the volume
+// the operation works on, if any, which gets an event if the lock is broken.
+func (man *volumeManager) acquireLock(name, volumeName string) (*operationLock, error) {
+	lock := &types.LockInfo{
+		Name:        name,
+		HolderID:    man.orc.GetCurrentHostID(),
+		OperationID: util.UUID(),
+		VolumeName:  volumeName,
+	}
+	if err := man.orc.AcquireLock(lock, LockTTL); err != nil {
+		return nil, err
+	}
+	l := &operationLock{
+		orc:         man.orc,
+		name:        name,
+		operationID: lock.OperationID,
+		stopCh:      make(chan struct{}),
+	}
+	go l.refresh()
+	return l, nil
+}
+
+func (l *operationLock) refresh() {
+	ticker := time.NewTicker(LockRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.stopCh:
+			return
+		}
+		err := l.orc.RefreshLock(l.name, l.operationID, LockTTL)
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(*types.ErrLockLost); ok {
+			logrus.Warnf("%v, aborting the operation", err)
+			l.Lock()
+			l.err = err
+			l.Unlock()
+			return
+		}
+		logrus.Errorf("%+v", errors.Wrapf(err, "fail to refresh lock %v", l.name))
+	}
+}
+
+// Err is not nil once the lock is lost, e.g. broken by an admin
+func (l *operationLock) Err() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.err
+}
+
+func (l *operationLock) release() {
+	l.stopOnce.Do(func() {
+		close(l.stopCh)
+	})
+	if err := l.orc.ReleaseLock(l.name, l.operationID); err != nil {
+		logrus.Errorf("%+v", errors.Wrapf(err, "fail to release lock %v", l.name))
+	}
+}
+
+func (man *volumeManager) ListLocks() ([]*types.LockInfo, error) {
+	locks, err := man.orc.ListLocks()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list locks")
+	}
+	return locks, nil
+}
+
+// BreakLock revokes the lock, confirm must be the operation ID of the holder
+// so only the lock listed is broken. The holder aborts on its next refresh.
+func (man *volumeManager) BreakLock(name, confirm, author string) (*types.LockInfo, error) {
+	if confirm == "" {
+		return nil, errors.Errorf("breaking lock %v requires the operation ID of the holder as confirmation", name)
+	}
+	lock, err := man.orc.RevokeLock(name, confirm, author)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to break lock %v", name)
+	}
+	if lock.VolumeName != "" {
+		man.events.record(lock.VolumeName, types.EventSeverityWarning, EventReasonLockBroken,
+			"lock %v of operation %v on host %v broken by %v", name, lock.OperationID, lock.HolderID, author)
+	}
+	logrus.Warnf("lock %v of operation %v on host %v broken by %v", name, lock.OperationID, lock.HolderID, author)
+	return lock, nil
+}
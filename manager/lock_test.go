@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestBreakLock(t *testing.T) {
+	assert := require.New(t)
+
+	interval := LockRefreshInterval
+	defer func() { LockRefreshInterval = interval }()
+	LockRefreshInterval = 10 * time.Millisecond
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	// a host drained elsewhere cannot be drained here
+	assert.Nil(orc.AcquireLock(&types.LockInfo{Name: "drain-host-2", HolderID: "host-2", OperationID: "other"}, time.Minute))
+	_, err := man.DrainHost("host-2", 0)
+	assert.NotNil(err)
+	assert.Nil(orc.ReleaseLock("drain-host-2", "other"))
+
+	lock, err := man.acquireLock("replace-vol", "vol")
+	assert.Nil(err)
+	locks, err := man.ListLocks()
+	assert.Nil(err)
+	assert.Len(locks, 1)
+	assert.Equal("host-1", locks[0].HolderID)
+	assert.Equal(lock.operationID, locks[0].OperationID)
+
+	// only the operation listed is broken
+	_, err = man.BreakLock("replace-vol", "", "admin")
+	assert.NotNil(err)
+	_, err = man.BreakLock("replace-vol", "other", "admin")
+	assert.NotNil(err)
+	assert.Nil(lock.Err())
+	_, err = man.BreakLock("replace-vol", lock.operationID, "admin")
+	assert.Nil(err)
+
+	// the holder learns it on the next refresh
+	for i := 0; i < 100 && lock.Err() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	lost, ok := lock.Err().(*types.ErrLockLost)
+	assert.True(ok)
+	assert.Equal("admin", lost.RevokedBy)
+	lock.release()
+
+	man.events.flush()
+	events, err := orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Len(events, 1)
+	assert.Equal(EventReasonLockBroken, events[0].Reason)
+}
@@ -0,0 +1,292 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+const (
+	AttachReasonMaintenance = "restore after host maintenance"
+
+	EventReasonMaintenanceScheduled = "MaintenanceScheduled"
+	EventReasonMaintenanceCanceled  = "MaintenanceCanceled"
+	EventReasonMaintenanceDrained   = "MaintenanceDrained"
+	EventReasonMaintenanceRestored  = "MaintenanceRestored"
+)
+
+var (
+	MaintenanceCheckPeriod = time.Minute
+)
+
+func validateMaintenanceWindow(w *types.MaintenanceWindow) error {
+	switch w.Policy {
+	case types.MaintenancePolicyControllers, types.MaintenancePolicyFull:
+	default:
+		return errors.Errorf("invalid maintenance policy %q, expecting %v or %v",
+			w.Policy, types.MaintenancePolicyControllers, types.MaintenancePolicyFull)
+	}
+	if _, err := time.Parse(time.RFC3339, w.Start); err != nil {
+		return errors.Wrapf(err, "invalid maintenance start %q", w.Start)
+	}
+	if w.Duration <= 0 {
+		return errors.Errorf("invalid maintenance duration %v", w.Duration)
+	}
+	return nil
+}
+
+// volumesByHost maps the hosts to the volumes with instances on them
+func (man *volumeManager) volumesByHost() (map[string]map[string]bool, error) {
+	hosts := map[string]map[string]bool{}
+	add := func(hostID, name string) {
+		if hosts[hostID] == nil {
+			hosts[hostID] = map[string]bool{}
+		}
+		hosts[hostID][name] = true
+	}
+	if err := man.orc.ForEachVolume("", func(volume *types.VolumeInfo) error {
+		if volume.Controller != nil {
+			add(volume.Controller.HostID, volume.Name)
+		}
+		for _, replica := range volume.Replicas {
+			add(replica.HostID, volume.Name)
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "unable to list volumes")
+	}
+	return hosts, nil
+}
+
+// ScheduleMaintenance plans the maintenance of the host. It's refused while
+// the host has a window not done, or if the window overlaps the window of
+// another host with instances of the same volumes, as both could be out at
+// the same time.
+func (man *volumeManager) ScheduleMaintenance(id string, window *types.MaintenanceWindow) (*types.MaintenanceWindow, error) {
+	if err := validateMaintenanceWindow(window); err != nil {
+		return nil, errors.Wrapf(err, "unable to schedule maintenance of host %v", id)
+	}
+	start, end, _ := window.Window()
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to list hosts")
+	}
+	host := hosts[id]
+	if host == nil {
+		return nil, errors.Errorf("cannot find host %v", id)
+	}
+	if host.Maintenance.Open() {
+		return nil, errors.Errorf("host %v already has a maintenance window at %v", id, host.Maintenance.Start)
+	}
+	volumes, err := man.volumesByHost()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to schedule maintenance of host %v", id)
+	}
+	for otherID, other := range hosts {
+		if otherID == id || !other.Maintenance.Open() {
+			continue
+		}
+		otherStart, otherEnd, err := other.Maintenance.Window()
+		if err != nil || !start.Before(otherEnd) || !otherStart.Before(end) {
+			continue
+		}
+		for name := range volumes[id] {
+			if volumes[otherID][name] {
+				return nil, errors.Errorf("maintenance of host %v overlaps the one of host %v at %v, both hold volume '%s'",
+					id, otherID, other.Maintenance.Start, name)
+			}
+		}
+	}
+
+	w := &types.MaintenanceWindow{
+		Start:    window.Start,
+		Duration: window.Duration,
+		Policy:   window.Policy,
+		Restore:  window.Restore,
+		State:    types.MaintenanceStateScheduled,
+	}
+	if err := man.orc.SetHostMaintenance(id, w); err != nil {
+		return nil, errors.Wrapf(err, "unable to schedule maintenance of host %v", id)
+	}
+	for name := range volumes[id] {
+		man.events.record(name, types.EventSeverityInfo, EventReasonMaintenanceScheduled,
+			"maintenance of host %v scheduled at %v for %v, policy %v", id, w.Start, w.Duration, w.Policy)
+	}
+	logrus.Infof("host %v maintenance scheduled at %v for %v, policy %v", id, w.Start, w.Duration, w.Policy)
+	return w, nil
+}
+
+// CancelMaintenance drops the maintenance window of the host before it starts
+func (man *volumeManager) CancelMaintenance(id string) error {
+	host, err := man.orc.GetHost(id)
+	if err != nil {
+		return errors.Wrapf(err, "fail to get host %v", id)
+	}
+	if host == nil {
+		return errors.Errorf("cannot find host %v", id)
+	}
+	if host.Maintenance == nil || host.Maintenance.State != types.MaintenanceStateScheduled {
+		return errors.Errorf("host %v has no maintenance window to cancel", id)
+	}
+	if err := man.orc.SetHostMaintenance(id, nil); err != nil {
+		return errors.Wrapf(err, "unable to cancel maintenance of host %v", id)
+	}
+	volumes, err := man.volumesByHost()
+	if err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "unable to record the canceled maintenance of host %v", id))
+	}
+	for name := range volumes[id] {
+		man.events.record(name, types.EventSeverityInfo, EventReasonMaintenanceCanceled,
+			"maintenance of host %v at %v canceled", id, host.Maintenance.Start)
+	}
+	logrus.Infof("host %v maintenance at %v canceled", id, host.Maintenance.Start)
+	return nil
+}
+
+// maintenanceCheck runs the maintenance window of the current host. The host
+// is back once its manager runs, so it's the manager here draining the host
+// at the start of the window and restoring it after the end.
+func (man *volumeManager) maintenanceCheck(ctx context.Context) error {
+	return runner.Tick(ctx, MaintenanceCheckPeriod, func() {
+		if man.IsReconcilePaused() {
+			return
+		}
+		if err := man.checkMaintenance(time.Now()); err != nil {
+			logrus.Warnf("%v", errors.Wrap(err, "error checking host maintenance"))
+		}
+	})
+}
+
+func (man *volumeManager) checkMaintenance(now time.Time) error {
+	id := man.orc.GetCurrentHostID()
+	host, err := man.orc.GetHost(id)
+	if err != nil {
+		return errors.Wrapf(err, "fail to get host %v", id)
+	}
+	if host == nil || !host.Maintenance.Open() {
+		return nil
+	}
+	w := host.Maintenance
+	start, end, err := w.Window()
+	if err != nil {
+		return errors.Wrapf(err, "invalid maintenance window of host %v", id)
+	}
+	if now.Before(start) {
+		return nil
+	}
+
+	lock, err := man.acquireLock("maintenance-"+id, "")
+	if err != nil {
+		return errors.Wrapf(err, "fail to lock host %v for maintenance", id)
+	}
+	defer lock.release()
+
+	switch {
+	case w.State == types.MaintenanceStateScheduled && !now.Before(end):
+		// the host was out for the whole window, nothing left to drain
+		logrus.Warnf("host %v maintenance at %v missed, the window ended", id, w.Start)
+		w.State = types.MaintenanceStateDone
+	case w.State == types.MaintenanceStateScheduled:
+		w.WasUnschedulable = host.Unschedulable
+		if err := man.drainForMaintenance(id, w); err != nil {
+			return err
+		}
+		w.State = types.MaintenanceStateActive
+		w.Drained = util.Now()
+	case !now.Before(end):
+		man.restoreFromMaintenance(id, w)
+		w.State = types.MaintenanceStateDone
+		w.Restored = util.Now()
+	default:
+		return nil
+	}
+	if err := man.orc.SetHostMaintenance(id, w); err != nil {
+		return errors.Wrapf(err, "unable to update maintenance of host %v", id)
+	}
+	return nil
+}
+
+// drainForMaintenance cordons the host and detaches the volumes attached
+// here. With the full policy the replicas here are removed as well, from the
+// volumes with good replicas elsewhere. The replicas of the volumes attached
+// on other hosts can only be migrated from there, and are left pending.
+func (man *volumeManager) drainForMaintenance(id string, w *types.MaintenanceWindow) error {
+	if err := man.UpdateHostSchedulable(id, false); err != nil {
+		return err
+	}
+	volumes := []*types.VolumeInfo{}
+	if err := man.orc.ForEachVolume("", func(volume *types.VolumeInfo) error {
+		if volumeOnHost(volume, id) {
+			volumes = append(volumes, volume)
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "fail to list volumes for maintenance of host %v", id)
+	}
+	for _, volume := range volumes {
+		if volume.Controller != nil && volume.Controller.HostID == id {
+			if err := man.Detach(volume.Name); err != nil {
+				logrus.Warnf("%v", errors.Wrapf(err, "maintenance of host %v: fail to detach volume '%s'", id, volume.Name))
+				man.events.record(volume.Name, types.EventSeverityWarning, EventReasonMaintenanceDrained,
+					"unable to detach for maintenance of host %v: %v", id, err)
+				continue
+			}
+			w.DetachedVolumes = append(w.DetachedVolumes, volume.Name)
+			man.events.record(volume.Name, types.EventSeverityInfo, EventReasonMaintenanceDrained,
+				"detached for maintenance of host %v", id)
+		}
+		if w.Policy != types.MaintenancePolicyFull || volume.Mode == types.VolumeModeLocal {
+			continue
+		}
+		for _, replica := range volume.Replicas {
+			if replica.HostID != id || replica.BadTimestamp != "" {
+				continue
+			}
+			if err := man.migrateReplica(replica); err != nil {
+				logrus.Warnf("%v", errors.Wrapf(err, "maintenance of host %v: replica '%s' left on the host", id, replica.Name))
+				w.Pending = append(w.Pending, replica.Name)
+				man.events.record(volume.Name, types.EventSeverityWarning, EventReasonMaintenanceDrained,
+					"replica '%s' left on host %v in maintenance: %v", replica.Name, id, err)
+				continue
+			}
+			w.MovedReplicas = append(w.MovedReplicas, &types.MovedReplica{VolumeName: volume.Name, ReplicaName: replica.Name})
+			man.events.record(volume.Name, types.EventSeverityInfo, EventReasonMaintenanceDrained,
+				"replica '%s' removed for maintenance of host %v", replica.Name, id)
+		}
+	}
+	logrus.Infof("host %v drained for maintenance: %v volumes detached, %v replicas moved, %v pending",
+		id, len(w.DetachedVolumes), len(w.MovedReplicas), len(w.Pending))
+	return nil
+}
+
+// restoreFromMaintenance puts the host back in use, unless it was cordoned
+// before the window, and with Restore attaches the volumes detached by the
+// drain here again, if they are still detached. Failures are only logged, the
+// window ends anyway.
+func (man *volumeManager) restoreFromMaintenance(id string, w *types.MaintenanceWindow) {
+	if !w.WasUnschedulable {
+		if err := man.UpdateHostSchedulable(id, true); err != nil {
+			logrus.Warnf("%v", errors.Wrapf(err, "maintenance of host %v: fail to uncordon", id))
+		}
+	}
+	if !w.Restore {
+		return
+	}
+	for _, name := range w.DetachedVolumes {
+		volume, err := man.orc.GetVolume(name)
+		if err != nil || volume == nil || volume.Controller != nil {
+			continue
+		}
+		if err := man.attach(name, AttachReasonMaintenance); err != nil {
+			logrus.Warnf("%v", errors.Wrapf(err, "maintenance of host %v: fail to attach volume '%s' again", id, name))
+			man.events.record(name, types.EventSeverityWarning, EventReasonMaintenanceRestored,
+				"unable to attach to host %v again after maintenance: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, _ := newTestManager(orc)
+
+	// fake scheduling puts the replicas on host-1 and host-2
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	start := now.Add(time.Hour)
+	window := func(start time.Time) *types.MaintenanceWindow {
+		return &types.MaintenanceWindow{
+			Start:    start.Format(time.RFC3339),
+			Duration: time.Hour,
+			Policy:   types.MaintenancePolicyFull,
+			Restore:  true,
+		}
+	}
+
+	_, err = man.ScheduleMaintenance("host-1", &types.MaintenanceWindow{Start: start.Format(time.RFC3339), Duration: time.Hour, Policy: "some"})
+	assert.NotNil(err)
+	w, err := man.ScheduleMaintenance("host-1", window(start))
+	assert.Nil(err)
+	assert.Equal(types.MaintenanceStateScheduled, w.State)
+	_, err = man.ScheduleMaintenance("host-1", window(start.Add(3*time.Hour)))
+	assert.NotNil(err)
+
+	// host-2 holds a replica of the same volume, host-3 nothing
+	_, err = man.ScheduleMaintenance("host-2", window(start.Add(30*time.Minute)))
+	assert.NotNil(err)
+	_, err = man.ScheduleMaintenance("host-3", window(start.Add(30*time.Minute)))
+	assert.Nil(err)
+	_, err = man.ScheduleMaintenance("host-2", window(start.Add(2*time.Hour)))
+	assert.Nil(err)
+	assert.Nil(man.CancelMaintenance("host-2"))
+	assert.NotNil(man.CancelMaintenance("host-2"))
+	host, err := man.GetHost("host-2")
+	assert.Nil(err)
+	assert.Nil(host.Maintenance)
+
+	// nothing happens before the start
+	assert.Nil(man.checkMaintenance(now))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	assert.NotNil(volume.Controller)
+
+	// the drain detaches the volume and removes its replica here
+	assert.Nil(man.checkMaintenance(start))
+	host, err = man.GetHost("host-1")
+	assert.Nil(err)
+	assert.True(host.Unschedulable)
+	assert.Equal(types.MaintenanceStateActive, host.Maintenance.State)
+	assert.Equal([]string{"vol"}, host.Maintenance.DetachedVolumes)
+	assert.Len(host.Maintenance.MovedReplicas, 1)
+	assert.Len(host.Maintenance.Pending, 0)
+	assert.NotNil(man.CancelMaintenance("host-1"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Nil(volume.Controller)
+	assert.False(volumeOnHost(volume, "host-1"))
+
+	// once the window ends the host is back in use, and the volume attached
+	// here again
+	assert.Nil(man.checkMaintenance(start.Add(30 * time.Minute)))
+	host, err = man.GetHost("host-1")
+	assert.Nil(err)
+	assert.Equal(types.MaintenanceStateActive, host.Maintenance.State)
+	assert.Nil(man.checkMaintenance(start.Add(time.Hour)))
+	host, err = man.GetHost("host-1")
+	assert.Nil(err)
+	assert.False(host.Unschedulable)
+	assert.Equal(types.MaintenanceStateDone, host.Maintenance.State)
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.NotNil(volume.Controller)
+	assert.Equal("host-1", volume.Controller.HostID)
+	assert.Equal(AttachReasonMaintenance, volume.AttachHistory[len(volume.AttachHistory)-1].Reason)
+
+	// a window done can be replaced
+	_, err = man.ScheduleMaintenance("host-1", window(start.Add(24*time.Hour)))
+	assert.Nil(err)
+
+	man.events.flush()
+	events, err := orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	reasons := map[string]int{}
+	for _, e := range events {
+		reasons[e.Reason]++
+	}
+	assert.Equal(3, reasons[EventReasonMaintenanceScheduled])
+	assert.Equal(1, reasons[EventReasonMaintenanceCanceled])
+	assert.Equal(2, reasons[EventReasonMaintenanceDrained])
+}
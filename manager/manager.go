@@ -1,19 +1,30 @@
 package manager
 
 import (
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
 
+	"github.com/rancher/longhorn-manager/controller"
+	"github.com/rancher/longhorn-manager/scheduler"
 	"github.com/rancher/longhorn-manager/types"
 	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/runner"
+	"github.com/rancher/longhorn-manager/util/trace"
 )
 
 var (
 	KeepBadReplicasPeriod = time.Hour * 2
+
+	// MaxConcurrentProvisioning limits volumes being created at the same
+	// time, 0 for unlimited. It takes effect on New().
+	MaxConcurrentProvisioning = 0
 )
 
 type volumeManager struct {
@@ -21,6 +32,10 @@ type volumeManager struct {
 
 	monitors       map[string]types.Monitor
 	addingReplicas map[string]int
+	rebuilding     map[string]bool
+	rebuilds       map[string]*replicaRebuild
+	standbySyncs   map[string]bool                 // key is volume name
+	recreates      map[string]*controllerRecreates // key is volume name
 
 	orc     types.Orchestrator
 	monitor types.BeginMonitoring
@@ -29,6 +44,44 @@ type volumeManager struct {
 	getBackups    types.GetManagerBackupOps
 
 	settings types.Settings
+
+	provisioning chan struct{}
+
+	clocks *clockSkewDetector
+
+	activities map[string]*volumeActivity // key is volume name
+
+	engineStatuses map[string]*engineStatusEntry // key is instance ID
+
+	reconcilePaused bool
+
+	events *eventRecorder
+
+	states *stateRecorder
+
+	slowOps *slowOperations
+	opStats *operationStats
+
+	runners *runner.Group
+
+	// held while placing replicas, so the plans see the reservations of
+	// each other
+	planning sync.Mutex
+
+	heartbeatLock sync.Mutex
+	deregistered  bool
+
+	backupReads types.BackupReadStats
+
+	volumeMetrics []*types.MetricFamily
+	// of the last batched read of the hosts, 0 before the first one
+	hostsReadLatency time.Duration
+	// the records removed by the record GC since the start
+	recordsRemoved map[recordGCKey]int64
+
+	certs managerCerts
+
+	tokens apiTokens
 }
 
 func (man *volumeManager) GetControllerName(volumeName string) string {
@@ -40,22 +93,210 @@ func (man *volumeManager) GetReplicaName(volumeName string) string {
 }
 
 func New(orc types.Orchestrator, monitor types.BeginMonitoring, getController types.GetController, getBackups types.GetManagerBackupOps) types.VolumeManager {
+	states := newStateRecorder(orc, orc.GetCurrentHostID())
 	return &volumeManager{
 		monitors:       map[string]types.Monitor{},
 		addingReplicas: map[string]int{},
+		rebuilding:     map[string]bool{},
+		rebuilds:       map[string]*replicaRebuild{},
+		standbySyncs:   map[string]bool{},
+		recreates:      map[string]*controllerRecreates{},
+
+		engineStatuses: map[string]*engineStatusEntry{},
 
-		orc:     orc,
+		orc:     &historyOrc{Orchestrator: orc, states: states},
 		monitor: monitor,
 
 		getController: getController,
 		getBackups:    getBackups,
 
 		settings: orc,
+
+		provisioning: provisioningLimit(MaxConcurrentProvisioning),
+
+		clocks: newClockSkewDetector(time.Now),
+
+		events: newEventRecorder(orc, orc.GetCurrentHostID()),
+		states: states,
+
+		slowOps: &slowOperations{},
+		opStats: &operationStats{},
+
+		runners: runner.NewGroup(),
+	}
+}
+
+func provisioningLimit(max int) chan struct{} {
+	if max <= 0 {
+		return nil
+	}
+	return make(chan struct{}, max)
+}
+
+// acquireProvisioning blocks until the volume is allowed to be provisioned,
+// and returns the function to release the slot.
+func (man *volumeManager) acquireProvisioning(name string) func() {
+	if man.provisioning == nil {
+		return func() {}
+	}
+	select {
+	case man.provisioning <- struct{}{}:
+	default:
+		logrus.Infof("volume '%s' waiting for provisioning, %v in progress", name, cap(man.provisioning))
+		man.provisioning <- struct{}{}
+	}
+	return func() { <-man.provisioning }
+}
+
+func ValidateDataIntegrity(mode types.DataIntegrity) error {
+	switch mode {
+	case types.DataIntegrityDisabled, types.DataIntegrityFastCheck, types.DataIntegrityFull:
+		return nil
+	}
+	return errors.Errorf("invalid data integrity mode '%s'", mode)
+}
+
+func ValidateReplicaBacking(backing types.ReplicaBacking) error {
+	switch backing {
+	case types.ReplicaBackingFile, types.ReplicaBackingBlock:
+		return nil
+	}
+	return errors.Errorf("invalid replica backing '%s'", backing)
+}
+
+func ValidateCacheMode(mode types.CacheMode) error {
+	switch mode {
+	case types.CacheModeWriteThrough, types.CacheModeWriteBack:
+		return nil
+	}
+	return errors.Errorf("invalid cache mode '%s'", mode)
+}
+
+func ValidateFsType(fsType types.FsType) error {
+	switch fsType {
+	case types.FsTypeRaw, types.FsTypeExt4, types.FsTypeXFS:
+		return nil
+	}
+	return errors.Errorf("invalid file system type '%s'", fsType)
+}
+
+func ValidateVolumeMode(mode types.VolumeMode) error {
+	switch mode {
+	case types.VolumeModeReplicated, types.VolumeModeLocal:
+		return nil
+	}
+	return errors.Errorf("invalid volume mode '%s'", mode)
+}
+
+// prepareLocalVolume pins the single replica and the controller of a local
+// volume to its host, the current host by default
+func (man *volumeManager) prepareLocalVolume(volume *types.VolumeInfo) error {
+	if volume.NumberOfReplicas == 0 {
+		volume.NumberOfReplicas = 1
 	}
+	if volume.NumberOfReplicas != 1 {
+		return errors.Errorf("local volume must have exactly one replica, not %v", volume.NumberOfReplicas)
+	}
+	if volume.PreferredHostID == "" {
+		volume.PreferredHostID = man.orc.GetCurrentHostID()
+	}
+	host, err := man.orc.GetHost(volume.PreferredHostID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get host %v", volume.PreferredHostID)
+	}
+	if host == nil {
+		return errors.Errorf("cannot find host %v", volume.PreferredHostID)
+	}
+	volume.PreferredHostPinned = true
+	volume.PinReplicas = true
+	return nil
+}
+
+func ValidateEngineVersionConstraint(constraint string) error {
+	if constraint == "" {
+		return nil
+	}
+	_, err := util.ParseVersionConstraint(constraint)
+	return err
+}
+
+var volumeLabelKey = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func ValidateVolumeLabels(labels map[string]string) error {
+	for k := range labels {
+		if !volumeLabelKey.MatchString(k) {
+			return errors.Errorf("invalid volume label '%s', expecting letters, digits, '_', '.' or '-'", k)
+		}
+	}
+	return nil
 }
 
-func (man *volumeManager) doCreate(volume *types.VolumeInfo) (*types.VolumeInfo, error) {
+// checkEngineVersion refuses the engine image of the volume if its version
+// violates the engine version constraint of the volume
+func checkEngineVersion(volume *types.VolumeInfo) error {
+	if volume.EngineVersionConstraint == "" {
+		return nil
+	}
+	constraint, err := util.ParseVersionConstraint(volume.EngineVersionConstraint)
+	if err != nil {
+		return err
+	}
+	version, err := util.ImageVersion(volume.EngineImage)
+	if err != nil {
+		return errors.Wrapf(err, "unable to check engine version constraint '%s'", volume.EngineVersionConstraint)
+	}
+	if !constraint.Check(version) {
+		return errors.Errorf("engine image '%s' version %v violates engine version constraint '%s'",
+			volume.EngineImage, version, volume.EngineVersionConstraint)
+	}
+	return nil
+}
+
+func ValidateZoneDistribution(volume *types.VolumeInfo) error {
+	if volume.MaxReplicasPerZone < 0 || volume.MinZones < 0 {
+		return errors.Errorf("invalid maxReplicasPerZone %v or minZones %v, expecting 0 or more",
+			volume.MaxReplicasPerZone, volume.MinZones)
+	}
+	if volume.MaxReplicasPerZone == 0 && volume.MinZones == 0 {
+		return nil
+	}
+	if volume.Mode == types.VolumeModeLocal {
+		return errors.Errorf("zone distribution doesn't apply to %v volumes", volume.Mode)
+	}
+	if volume.MinZones > volume.NumberOfReplicas {
+		return errors.Errorf("unable to place %v replicas in %v zones", volume.NumberOfReplicas, volume.MinZones)
+	}
+	return nil
+}
+
+// checkZoneDistribution refuses the zone distribution of the volume if the
+// current hosts cannot satisfy it
+func (man *volumeManager) checkZoneDistribution(volume *types.VolumeInfo) error {
+	if volume.MaxReplicasPerZone == 0 && volume.MinZones == 0 {
+		return nil
+	}
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return errors.Wrap(err, "unable to list hosts")
+	}
+	return scheduler.CheckZoneDistribution(hosts, volume.NumberOfReplicas, volume.MaxReplicasPerZone, volume.MinZones)
+}
+
+func (man *volumeManager) doCreate(volume *types.VolumeInfo) (_ *types.VolumeInfo, err error) {
+	op := man.beginOperation("create", volume.Name)
+	defer func() {
+		man.endOperation(op, err)
+	}()
+	step := op.span.Child("wait provisioning slot")
+	release := man.acquireProvisioning(volume.Name)
+	defer release()
+	step.End(nil)
+
 	volume.Created = util.Now()
+	if volume.ScheduleOnCreate {
+		return man.createPlanned(volume)
+	}
+	volume.Creating = true
 	vol, err := man.orc.CreateVolume(volume)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create volume '%s'", volume.Name)
@@ -63,10 +304,25 @@ func (man *volumeManager) doCreate(volume *types.VolumeInfo) (*types.VolumeInfo,
 
 	for i := 0; i < vol.NumberOfReplicas; i++ {
 		replicaName := man.GetReplicaName(vol.Name)
-		if _, err := man.orc.CreateReplica(vol.Name, replicaName); err != nil {
+		step := op.span.Childf("schedule replica %v", i+1)
+		_, err := man.orc.CreateReplica(vol.Name, replicaName)
+		if step.End(err); err != nil {
+			man.updateScheduledCondition(vol.Name, err)
 			return nil, errors.Wrapf(err, "error creating replica '%s', volume '%s'", replicaName, vol.Name)
 		}
 	}
+	created, err := man.orc.GetVolume(vol.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get volume '%s'", vol.Name)
+	}
+	if created == nil {
+		return nil, errors.Errorf("creation of volume '%s' aborted", vol.Name)
+	}
+	created.Creating = false
+	if err := man.orc.UpdateVolume(created); err != nil {
+		return nil, errors.Wrapf(err, "failed to update volume '%s'", vol.Name)
+	}
+	man.events.record(vol.Name, types.EventSeverityInfo, EventReasonCreated, "created with %v replicas", vol.NumberOfReplicas)
 	return man.Get(volume.Name)
 }
 
@@ -92,7 +348,7 @@ func (man *volumeManager) createFromBackup(volume *types.VolumeInfo, backup *typ
 		defer man.cleanupFailedCreate(vol)
 		return nil, errors.Wrapf(err, "failed to attach to restore the backup, volume '%s', backup '%+v'", vol.Name, backup)
 	}
-	if err := man.getController(vol).BackupOps().Restore(backup.URL); err != nil {
+	if err := man.getController(vol).BackupOps().Restore(backup.URL, types.BackupCompression(backup.CompressionMethod)); err != nil {
 		defer man.cleanupFailedCreate(vol)
 		return nil, errors.Wrapf(err, "failed to restore the backup, volume '%s', backup '%+v'", vol.Name, backup)
 	}
@@ -104,6 +360,13 @@ func (man *volumeManager) createFromBackup(volume *types.VolumeInfo, backup *typ
 }
 
 func (man *volumeManager) Create(volume *types.VolumeInfo) (*types.VolumeInfo, error) {
+	if strings.HasPrefix(volume.Name, SmokeTestVolumePrefix) {
+		return nil, errors.Errorf("create volume fail: prefix '%s' is reserved for the smoke tests", SmokeTestVolumePrefix)
+	}
+	return man.create(volume)
+}
+
+func (man *volumeManager) create(volume *types.VolumeInfo) (*types.VolumeInfo, error) {
 	vol, err := man.Get(volume.Name)
 	if err != nil {
 		return nil, err
@@ -111,6 +374,75 @@ func (man *volumeManager) Create(volume *types.VolumeInfo) (*types.VolumeInfo, e
 	if vol != nil {
 		return nil, errors.Errorf("volume %v already exists", volume.Name)
 	}
+	if err := ValidateRehomePolicy(volume.RehomePolicy); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if err := ValidateAutoReattachPolicy(volume.AutoReattach); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if err := ValidateBackupCompression(volume.BackupCompression); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if volume.SnapshotMaxCount < 0 || volume.SnapshotMaxAge < 0 {
+		return nil, errors.New("create volume fail: snapshot limits cannot be negative")
+	}
+	if volume.Standby && volume.FromBackup == "" {
+		return nil, errors.New("create volume fail: standby volume needs a backup to track")
+	}
+	if volume.DataIntegrity == types.DataIntegrityDefault {
+		volume.DataIntegrity = types.DataIntegrityFastCheck
+	}
+	if err := ValidateDataIntegrity(volume.DataIntegrity); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if volume.ReplicaBacking == types.ReplicaBackingDefault {
+		volume.ReplicaBacking = types.ReplicaBackingFile
+	}
+	if err := ValidateReplicaBacking(volume.ReplicaBacking); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if volume.CacheMode == types.CacheModeDefault {
+		volume.CacheMode = types.CacheModeWriteThrough
+	}
+	if err := ValidateCacheMode(volume.CacheMode); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if volume.CacheMode == types.CacheModeWriteBack {
+		logrus.Warnf("volume '%s' uses %v cache mode: %v", volume.Name, volume.CacheMode, types.CacheModeWriteBackWarning)
+	}
+	if err := ValidateEngineVersionConstraint(volume.EngineVersionConstraint); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if err := ValidateFsType(volume.FsType); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	volume.FsInitialized = false
+	if err := ValidateVolumeLabels(volume.Labels); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if err := ValidateJobs(volume.RecurringJobs); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if err := util.ValidateEnv(volume.InstanceEnv); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if volume.Mode == types.VolumeModeDefault {
+		volume.Mode = types.VolumeModeReplicated
+	}
+	if err := ValidateVolumeMode(volume.Mode); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if volume.Mode == types.VolumeModeLocal {
+		if err := man.prepareLocalVolume(volume); err != nil {
+			return nil, errors.Wrap(err, "create volume fail")
+		}
+	}
+	if err := ValidateZoneDistribution(volume); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	if err := man.checkZoneDistribution(volume); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
 	settings, err := man.settings.GetSettings()
 	if err != nil || settings == nil {
 		return nil, errors.New("create volume fail: fail to load settings")
@@ -121,6 +453,9 @@ func (man *volumeManager) Create(volume *types.VolumeInfo) (*types.VolumeInfo, e
 			return nil, errors.New("create volume fail: No EngineImage specified")
 		}
 	}
+	if err := checkEngineVersion(volume); err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
 	if volume.FromBackup != "" {
 		backupTarget := settings.BackupTarget
 		if backupTarget == "" {
@@ -156,22 +491,81 @@ func (man *volumeManager) Delete(name string) error {
 		}
 	}
 
-	return errors.Wrapf(man.orc.DeleteVolume(name), "failed to delete volume '%s'", name)
+	if err := man.orc.DeleteVolume(name); err != nil {
+		return errors.Wrapf(err, "failed to delete volume '%s'", name)
+	}
+	if err := man.events.discard(name); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "failed to remove events of deleted volume '%s'", name))
+	}
+	if err := man.states.discard(name); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "failed to remove history of deleted volume '%s'", name))
+	}
+	return nil
+}
+
+// AbortVolumeCreation removes the replicas created and the metadata of a
+// volume whose creation failed or is stuck. A volume done creating must be
+// deleted instead.
+func (man *volumeManager) AbortVolumeCreation(name string) error {
+	volume, err := man.Get(name)
+	if err != nil {
+		return err
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	if !volume.Creating {
+		return errors.Errorf("cannot abort creation of volume '%s': volume is %v, not %v",
+			name, volume.State, types.VolumeStateCreating)
+	}
+
+	instances := []*types.InstanceInfo{}
+	if volume.Controller != nil {
+		instances = append(instances, &volume.Controller.InstanceInfo)
+	}
+	for _, replica := range volume.Replicas {
+		instances = append(instances, &replica.InstanceInfo)
+	}
+	for _, instance := range instances {
+		if _, err := man.orc.RemoveInstance(instance); err != nil {
+			return errors.Wrapf(err, "error removing %v container %s(%s), volume '%s'", instance.Type, instance.Name, instance.ID, name)
+		}
+	}
+
+	if err := man.orc.DeleteVolume(name); err != nil {
+		return errors.Wrapf(err, "failed to delete volume '%s'", name)
+	}
+	if err := man.events.discard(name); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "failed to remove events of volume '%s'", name))
+	}
+	if err := man.states.discard(name); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "failed to remove history of volume '%s'", name))
+	}
+	logrus.Warnf("aborted creation of volume '%s', removed %v instances", name, len(instances))
+	return nil
 }
 
 func volumeState(volume *types.VolumeInfo) types.VolumeState {
 	goodReplicaCount := 0
-	for _, replica := range volume.Replicas {
-		if replica.BadTimestamp == "" {
+	for name, replica := range volume.Replicas {
+		if replica.BadTimestamp == "" && volume.StandbyReplicas[name] == nil {
 			goodReplicaCount++
 		}
 	}
+	wanted := volume.NumberOfReplicas
+	if volume.Converting(types.VolumeModeReplicated) {
+		wanted = volume.Conversion.TargetReplicas
+	}
 	switch {
+	case volume.Creating:
+		return types.VolumeStateCreating
+	case volume.ReplicaPlan.Pending():
+		return types.VolumeStateDetached
 	case goodReplicaCount == 0:
 		return types.VolumeStateFaulted
 	case volume.Controller == nil:
 		return types.VolumeStateDetached
-	case goodReplicaCount == volume.NumberOfReplicas:
+	case goodReplicaCount == wanted:
 		return types.VolumeStateHealthy
 	}
 	return types.VolumeStateDegraded
@@ -209,7 +603,33 @@ func (man *volumeManager) List() ([]*types.VolumeInfo, error) {
 	return volumes, nil
 }
 
+// errPageFull stops the scan of the volumes once a page is listed
+var errPageFull = errors.New("page full")
+
+func (man *volumeManager) ListPage(after string, limit int) ([]*types.VolumeInfo, string, error) {
+	if limit <= 0 {
+		return nil, "", errors.Errorf("invalid page limit %v", limit)
+	}
+	volumes := []*types.VolumeInfo{}
+	next := ""
+	err := man.orc.ForEachVolume(after, func(volume *types.VolumeInfo) error {
+		if len(volumes) == limit {
+			next = volumes[len(volumes)-1].Name
+			return errPageFull
+		}
+		volumes = append(volumes, man.completeVolumeState(volume))
+		return nil
+	})
+	if err != nil && err != errPageFull {
+		return nil, "", err
+	}
+	return volumes, next, nil
+}
+
 func (man *volumeManager) Start() error {
+	if err := man.loadAPITokensFile(); err != nil {
+		return err
+	}
 	vs, err := man.List()
 	if err != nil {
 		return err
@@ -219,9 +639,43 @@ func (man *volumeManager) Start() error {
 			man.startMonitoring(v)
 		}
 	}
+	if InternalTLSEnabled {
+		if err := man.refreshCert(); err != nil {
+			return errors.Wrap(err, "unable to set up internal TLS")
+		}
+	}
+	// stopped in the reverse order, the events of the others are written
+	// once they're stopped
+	man.runners.Go(runner.Runner{Name: "events", Run: man.events.run})
+	if InternalTLSEnabled {
+		man.runners.Go(runner.Runner{Name: "cert-check", Run: man.certCheck})
+	}
+	man.runners.Go(runner.Runner{Name: "rehome", Run: man.rehome})
+	man.runners.Go(runner.Runner{Name: "maintenance-check", Run: man.maintenanceCheck})
+	man.runners.Go(runner.Runner{Name: "heartbeat", Run: man.heartbeat})
+	man.runners.Go(runner.Runner{Name: "fence-check", Run: man.fenceCheck})
+	man.runners.Go(runner.Runner{Name: "health-check", Run: man.healthCheck})
+	man.runners.Go(runner.Runner{Name: "auto-detach", Run: man.autoDetach})
+	man.runners.Go(runner.Runner{Name: "replica-quota", Run: man.replicaQuota})
+	man.runners.Go(runner.Runner{Name: "volume-metrics", Run: man.refreshVolumeMetrics})
+	man.runners.Go(runner.Runner{Name: "metadata-export", Run: man.metadataExport})
+	man.runners.Go(runner.Runner{Name: "record-gc", Run: man.recordGC})
 	return nil
 }
 
+// Shutdown stops the background loops of the manager, the last started
+// first, and writes the events still queued
+func (man *volumeManager) Shutdown() {
+	if err := man.runners.Stop(); err != nil {
+		logrus.Errorf("%v", err)
+	}
+	man.events.flush()
+}
+
+func (man *volumeManager) RunnerStatus() []*runner.Status {
+	return man.runners.Status()
+}
+
 func (man *volumeManager) startMonitoring(volume *types.VolumeInfo) {
 	man.Lock()
 	defer man.Unlock()
@@ -253,26 +707,111 @@ func (man *volumeManager) stopMonitoring(volume *types.VolumeInfo) {
 }
 
 func (man *volumeManager) Attach(name string) error {
+	return man.AttachWithEnv(name, nil)
+}
+
+// AttachWithEnv attaches the volume, the instances created while it's
+// attached get env on top of the environment of the volume. The env cannot
+// change while the controller is running.
+func (man *volumeManager) AttachWithEnv(name string, env map[string]string) error {
+	return man.attachWithEnv(name, env, AttachReasonRequested)
+}
+
+func (man *volumeManager) attachWithEnv(name string, env map[string]string, reason string) error {
+	if err := util.ValidateEnv(env); err != nil {
+		return errors.Wrapf(err, "unable to attach volume '%s'", name)
+	}
+	if len(env) == 0 {
+		env = nil
+	}
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	if len(env) != 0 || len(volume.AttachEnv) != 0 {
+		if volume.Controller != nil && volume.Controller.Running {
+			if env != nil && !reflect.DeepEqual(env, volume.AttachEnv) {
+				return errors.Errorf("volume '%s' is attached, detach it first to change its attach environment", name)
+			}
+		} else {
+			volume.AttachEnv = env
+			if err := man.orc.UpdateVolume(volume); err != nil {
+				return errors.Wrapf(err, "unable to update volume '%s'", name)
+			}
+		}
+	}
+	return man.attach(name, reason)
+}
+
+func (man *volumeManager) attach(name, reason string) (err error) {
+	op := man.beginOperation("attach", name)
+	defer func() {
+		man.endOperation(op, err)
+	}()
 	volume, err := man.Get(name)
 	if err != nil {
 		return err
 	}
-	return man.doAttach(volume)
+	if err := man.doAttach(volume); err != nil {
+		return err
+	}
+	man.events.record(name, types.EventSeverityInfo, EventReasonAttached, "attached on host %v, %v", man.orc.GetCurrentHostID(), reason)
+	if err := man.recordAttach(name, reason); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "failed to record attach target for volume '%s'", name))
+	}
+	return nil
 }
 
 func (man *volumeManager) doAttach(volume *types.VolumeInfo) error {
+	if volume.Creating {
+		return errors.Errorf("volume '%s' is still being created, its creation may be aborted", volume.Name)
+	}
+	if volume.SalvageRequired {
+		return errors.Errorf("volume '%s' requires salvage before attaching: %s", volume.Name, volume.SalvageReason)
+	}
+	if err := checkEngineVersion(volume); err != nil {
+		return errors.Wrapf(err, "refused to attach volume '%s'", volume.Name)
+	}
+	if volume.Mode == types.VolumeModeLocal && volume.PreferredHostID != man.orc.GetCurrentHostID() {
+		return errors.Errorf("local volume '%s' can only be attached on host %v", volume.Name, volume.PreferredHostID)
+	}
+	span := trace.Current(volume.Name)
+	if volume.ReplicaPlan.Pending() {
+		step := span.Child("apply replica plan")
+		v, err := man.applyPlan(volume)
+		if step.End(err); err != nil {
+			return err
+		}
+		volume = v
+	}
 	if volume.Controller != nil {
 		if volume.Controller.Running && volume.Controller.HostID == man.orc.GetCurrentHostID() {
 			man.startMonitoring(volume)
 			return nil
 		}
-		if err := man.Detach(volume.Name); err != nil {
-			return errors.Wrapf(err, "failed to detach before reattaching volume '%s'", volume.Name)
+		step := span.Child("detach previous controller")
+		err := man.Detach(volume.Name)
+		step.End(err)
+		if err != nil {
+			if volume.Controller.HostID == man.orc.GetCurrentHostID() {
+				return errors.Wrapf(err, "failed to detach before reattaching volume '%s'", volume.Name)
+			}
+			logrus.Warnf("%v", errors.Wrapf(err, "failed to detach before reattaching volume '%s'", volume.Name))
+			if err := man.fence(volume); err != nil {
+				return err
+			}
+			if volume, err = man.Get(volume.Name); err != nil {
+				return err
+			}
 		}
 	}
 	replicas := map[string]*types.ReplicaInfo{}
 	var recentBadReplica *types.ReplicaInfo
 	var recentBadK string
+	step := span.Child("stop replicas")
 	wg := &sync.WaitGroup{}
 	errCh := make(chan error)
 	for k, replica := range volume.Replicas {
@@ -285,6 +824,9 @@ func (man *volumeManager) doAttach(volume *types.VolumeInfo) error {
 				}
 			}(replica)
 		}
+		if volume.StandbyReplicas[k] != nil {
+			continue
+		}
 		if replica.BadTimestamp == "" {
 			replicas[k] = replica
 		} else {
@@ -293,12 +835,17 @@ func (man *volumeManager) doAttach(volume *types.VolumeInfo) error {
 				logrus.Errorf("%+v", err)
 				continue
 			}
+			if recentBadReplica == nil {
+				recentBadReplica = replica
+				recentBadK = k
+				continue
+			}
 			recentBadTime, err := util.ParseTime(recentBadReplica.BadTimestamp)
 			if err != nil {
 				logrus.Errorf("%+v", err)
 				continue
 			}
-			if recentBadReplica == nil || replicaBadTime.After(recentBadTime) {
+			if replicaBadTime.After(recentBadTime) {
 				recentBadReplica = replica
 				recentBadK = k
 			}
@@ -314,14 +861,17 @@ func (man *volumeManager) doAttach(volume *types.VolumeInfo) error {
 		logrus.Errorf("%+v", err)
 	}
 	if len(errs) > 0 {
+		step.End(errs)
 		return errs
 	}
+	step.End(nil)
 	if len(replicas) == 0 && recentBadReplica != nil {
 		replicas[recentBadK] = recentBadReplica
 	}
 	if len(replicas) == 0 {
 		return errors.Errorf("no replicas to start the controller for volume '%s'", volume.Name)
 	}
+	step = span.Child("start replicas")
 	wg = &sync.WaitGroup{}
 	errCh = make(chan error)
 	for _, replica := range replicas {
@@ -343,19 +893,43 @@ func (man *volumeManager) doAttach(volume *types.VolumeInfo) error {
 		logrus.Errorf("%+v", err)
 	}
 	if len(errs) > 0 {
+		step.End(errs)
 		return errs
 	}
+	step.End(nil)
 
+	step = span.Child("create controller")
 	controller, err := man.orc.CreateController(volume.Name, man.GetControllerName(volume.Name), replicas)
-	if err != nil {
+	if step.End(err); err != nil {
 		return errors.Wrapf(err, "failed to start the controller for volume '%s'", volume.Name)
 	}
 
 	volume.Controller = controller
+	if volume.FsType != types.FsTypeRaw && !volume.FsInitialized {
+		man.markFsInitialized(volume.Name)
+	}
+	if volume.RebuildFailures != 0 || volume.RebuildCondition != "" {
+		man.resetRebuildFailures(volume.Name)
+	}
 	man.startMonitoring(volume)
 	return nil
 }
 
+// markFsInitialized keeps the following attaches from probing the device of
+// the volume for a file system. Failing it is harmless, the device is never
+// formatted over an existing file system.
+func (man *volumeManager) markFsInitialized(name string) {
+	v, err := man.orc.GetVolume(name)
+	if err != nil || v == nil {
+		logrus.Warnf("fail to get volume '%s' to mark its file system initialized: %v", name, err)
+		return
+	}
+	v.FsInitialized = true
+	if err := man.orc.UpdateVolume(v); err != nil {
+		logrus.Warnf("fail to mark file system of volume '%s' initialized: %v", name, err)
+	}
+}
+
 func (man *volumeManager) Detach(name string) error {
 	volume, err := man.Get(name)
 	if err != nil {
@@ -365,7 +939,11 @@ func (man *volumeManager) Detach(name string) error {
 		logrus.Warnf("volume %v no longer exist for detach", name)
 		return nil
 	}
-	return man.doDetach(volume)
+	if err := man.doDetach(volume); err != nil {
+		return err
+	}
+	man.events.record(name, types.EventSeverityInfo, EventReasonDetached, "detached")
+	return nil
 }
 
 func (man *volumeManager) doDetach(volume *types.VolumeInfo) error {
@@ -409,6 +987,7 @@ func (man *volumeManager) doDetach(volume *types.VolumeInfo) error {
 
 func (man *volumeManager) createAndAddReplicaToController(volumeName string, ctrl types.Controller) error {
 	replica, err := man.orc.CreateReplica(volumeName, man.GetReplicaName(volumeName))
+	man.updateScheduledCondition(volumeName, err)
 	if err != nil {
 		return errors.Wrapf(err, "failed to create a replica for volume '%s'", volumeName)
 	}
@@ -418,19 +997,92 @@ func (man *volumeManager) createAndAddReplicaToController(volumeName string, ctr
 	}
 	// Update replica.InstanceInfo to provide address for ctrl.AddReplica() call
 	replica.InstanceInfo = *instance
+	man.rebuildReplica(volumeName, ctrl, replica)
+	return nil
+}
+
+// rebuildReplica adds the running replica to the controller in the
+// background
+func (man *volumeManager) rebuildReplica(volumeName string, ctrl types.Controller, replica *types.ReplicaInfo) {
+	r := man.startRebuild(volumeName, replica)
 	go func() {
-		man.addingReplicasCount(volumeName, 1)
-		defer man.addingReplicasCount(volumeName, -1)
-		if err := ctrl.AddReplica(replica); err != nil {
+		err := ctrl.AddReplica(replica)
+		// a rebuild torn down as stuck or cancelled is cleaned up already
+		if !man.finishRebuild(r) {
+			return
+		}
+		if err != nil {
 			logrus.Errorf("%+v", errors.Wrapf(err, "failed to add replica '%s' to volume '%s'", replica.Name, volumeName))
-			if _, err := man.orc.StopInstance(&replica.InstanceInfo); err != nil {
-				logrus.Errorf("%+v", errors.Wrapf(err, "failed to stop stale replica '%s' of volume '%s'", replica.Name, volumeName))
-			}
-			if _, err := man.orc.RemoveInstance(&replica.InstanceInfo); err != nil {
-				logrus.Errorf("%+v", errors.Wrapf(err, "failed to remove stale replica '%s' of volume '%s'", replica.Name, volumeName))
-			}
+			man.removeStaleReplica(volumeName, replica)
+			return
 		}
+		man.resetRebuildFailures(volumeName)
 	}()
+}
+
+// UpdateControllerReplicas rewires the running controller of the volume to
+// the desired replicas without restarting it. The replicas not yet known to
+// the controller are added and rebuilt in the background, the ones not
+// desired are removed from it. At least one of the good replicas of the
+// controller must be kept as the source of rebuilding.
+func (man *volumeManager) UpdateControllerReplicas(volumeName string, desired []*types.ReplicaInfo) error {
+	volume, err := man.Get(volumeName)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", volumeName)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", volumeName)
+	}
+	ctrl := man.getController(volume)
+	if ctrl == nil {
+		return errors.Errorf("volume '%s' has no running controller", volumeName)
+	}
+	current, err := ctrl.GetReplicaStates()
+	if err != nil {
+		return NewControllerError(err)
+	}
+
+	desiredAddrs := map[string]bool{}
+	for _, replica := range desired {
+		if replica.Address == "" {
+			return errors.Errorf("replica '%s' of volume '%s' has no address, is it running?", replica.Name, volumeName)
+		}
+		desiredAddrs[replica.Address] = true
+	}
+	currentAddrs := map[string]bool{}
+	kept := false
+	for _, replica := range current {
+		currentAddrs[replica.Address] = true
+		if desiredAddrs[replica.Address] && replica.Mode == types.ReplicaModeRW {
+			kept = true
+		}
+	}
+	if !kept {
+		return errors.Errorf("desired replicas of volume '%s' keep none of the good replicas of the controller", volumeName)
+	}
+
+	for _, replica := range desired {
+		if currentAddrs[replica.Address] {
+			continue
+		}
+		logrus.Infof("adding replica '%s' to the controller of volume '%s'", replica.Address, volumeName)
+		man.addingReplicasCount(volumeName, 1)
+		go func(replica *types.ReplicaInfo) {
+			defer man.addingReplicasCount(volumeName, -1)
+			if err := ctrl.AddReplica(replica); err != nil {
+				logrus.Errorf("%+v", errors.Wrapf(err, "failed to add replica '%s' to volume '%s'", replica.Address, volumeName))
+			}
+		}(replica)
+	}
+	for _, replica := range current {
+		if desiredAddrs[replica.Address] {
+			continue
+		}
+		logrus.Infof("removing replica '%s' from the controller of volume '%s'", replica.Address, volumeName)
+		if err := ctrl.RemoveReplica(replica); err != nil {
+			return errors.Wrapf(err, "failed to remove replica '%s' from volume '%s'", replica.Address, volumeName)
+		}
+	}
 	return nil
 }
 
@@ -461,6 +1113,91 @@ func (man *volumeManager) UpdateRecurring(name string, jobs []*types.RecurringJo
 	return nil
 }
 
+func (man *volumeManager) UpdatePinReplicas(name string, pinned bool) error {
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	volume.PinReplicas = pinned
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", name)
+	}
+	logrus.Infof("volume '%s' replicas pinned: %v", name, pinned)
+	return nil
+}
+
+func (man *volumeManager) UpdateEngineVersionConstraint(name, constraint string) error {
+	if err := ValidateEngineVersionConstraint(constraint); err != nil {
+		return err
+	}
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	volume.EngineVersionConstraint = constraint
+	if err := checkEngineVersion(volume); err != nil {
+		return err
+	}
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", name)
+	}
+	logrus.Infof("volume '%s' engine version constraint: '%s'", name, constraint)
+	return nil
+}
+
+func (man *volumeManager) RenderInstance(instanceType types.InstanceType, volumeName string) (*types.InstanceRender, error) {
+	volume, err := man.Get(volumeName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get volume '%s'", volumeName)
+	}
+	if volume == nil {
+		return nil, errors.Errorf("cannot find volume '%s'", volumeName)
+	}
+	switch instanceType {
+	case types.InstanceTypeController:
+		// the replicas attaching the volume would start the controller with
+		replicas := map[string]*types.ReplicaInfo{}
+		for name, replica := range volume.Replicas {
+			if replica.BadTimestamp == "" {
+				replicas[name] = replica
+			}
+		}
+		if len(replicas) == 0 {
+			return nil, errors.Errorf("no replicas to start the controller for volume '%s'", volumeName)
+		}
+		return man.orc.RenderController(volumeName, man.GetControllerName(volumeName), replicas)
+	case types.InstanceTypeReplica:
+		return man.orc.RenderReplica(volumeName, man.GetReplicaName(volumeName))
+	}
+	return nil, errors.Errorf("invalid instance type %v", instanceType)
+}
+
+func (man *volumeManager) GetEffectiveConfig() (*types.RuntimeConfig, error) {
+	config, err := man.orc.GetEffectiveConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get the config of the orchestrator")
+	}
+	config.MaxConcurrentProvisioning = MaxConcurrentProvisioning
+	config.EventFlushInterval = EventFlushInterval.String()
+	config.EventBatchSize = EventBatchSize
+	config.EventQueueSize = EventQueueSize
+	config.ReplicaPlanReservation = ReplicaPlanReservation.String()
+	config.RawEditingEnabled = RawEditingEnabled
+	config.ReplicaRebuildTimeout = controller.AddReplicaTimeout.String()
+	config.RebuildStallTimeout = RebuildStallTimeout.String()
+	config.RebuildMaxRetries = RebuildMaxRetries
+	config.InternalTLS = InternalTLSEnabled
+	config.APITokensFile = APITokensFile
+	config.BootstrapFile = BootstrapFile
+	return config, nil
+}
+
 func (man *volumeManager) CheckController(ctrl types.Controller, volume *types.VolumeInfo) error {
 	replicas, err := ctrl.GetReplicaStates()
 	if err != nil {
@@ -469,9 +1206,16 @@ func (man *volumeManager) CheckController(ctrl types.Controller, volume *types.V
 	logrus.Debugf("checking '%s', NumberOfReplicas=%v: controller knows %v replicas", volume.Name, volume.NumberOfReplicas, len(volume.Replicas))
 	goodReplicas := []*types.ReplicaInfo{}
 	woReplicas := []*types.ReplicaInfo{}
+	// the standby replicas only join the controller while they're synced
+	standbyAddrs := volume.StandbyAddresses()
+	standbys := []*types.ReplicaInfo{}
 	errCh := make(chan error)
 	wg := &sync.WaitGroup{}
 	for _, replica := range replicas {
+		if standbyAddrs[replica.Address] && replica.Mode != types.ReplicaModeERR {
+			standbys = append(standbys, replica)
+			continue
+		}
 		switch replica.Mode {
 		case types.ReplicaModeRW:
 			goodReplicas = append(goodReplicas, replica)
@@ -482,6 +1226,7 @@ func (man *volumeManager) CheckController(ctrl types.Controller, volume *types.V
 			go func(replica *types.ReplicaInfo) {
 				defer wg.Done()
 				logrus.Warnf("Marking bad replica '%s'", replica.Address)
+				man.events.record(volume.Name, types.EventSeverityWarning, EventReasonReplicaFailed, "replica %v failed", replica.Address)
 				wg.Add(2)
 				go func() {
 					defer wg.Done()
@@ -513,15 +1258,45 @@ func (man *volumeManager) CheckController(ctrl types.Controller, volume *types.V
 	}
 	if len(goodReplicas) == 0 {
 		logrus.Errorf("volume '%s' has no more good replicas, shutting it down", volume.Name)
+		man.events.record(volume.Name, types.EventSeverityError, EventReasonNoGoodReplicas, "no more good replicas, shutting down")
 		return man.Detach(volume.Name)
 	}
 
+	if err := man.checkRebuild(volume.Name, ctrl); err != nil {
+		return err
+	}
 	addingReplicas := man.addingReplicasCount(volume.Name, 0)
+	man.setRebuilding(volume.Name, len(woReplicas)+addingReplicas > 0)
 	logrus.Debugf("'%s' replicas by state: RW=%v, WO=%v, adding=%v", volume.Name, len(goodReplicas), len(woReplicas), addingReplicas)
-	if len(goodReplicas) < volume.NumberOfReplicas && len(woReplicas) == 0 && addingReplicas == 0 {
-		if err := man.createAndAddReplicaToController(volume.Name, ctrl); err != nil {
+	// local volumes are never rebuilt, they fault with the replica. The
+	// replicas of a local volume converted to replicated are added as
+	// rebuilt ones.
+	wanted := volume.NumberOfReplicas
+	rebuild := volume.Mode != types.VolumeModeLocal && !volume.Converting(types.VolumeModeLocal)
+	if volume.Converting(types.VolumeModeReplicated) {
+		wanted, rebuild = volume.Conversion.TargetReplicas, true
+	}
+	if volume.RebuildCondition != "" {
+		rebuild = false
+	}
+	if rebuild && len(goodReplicas) < wanted && len(woReplicas) == 0 && addingReplicas == 0 {
+		promoted, err := man.promoteSyncedStandby(volume, ctrl)
+		if err != nil {
 			return err
 		}
+		if !promoted {
+			if err := man.createAndAddReplicaToController(volume.Name, ctrl); err != nil {
+				return err
+			}
+		}
+		man.events.record(volume.Name, types.EventSeverityInfo, EventReasonRebuilding, "rebuilding a replica, %v of %v good", len(goodReplicas), wanted)
+	} else if len(woReplicas)+addingReplicas == 0 {
+		if err := man.checkStandbys(volume, ctrl, standbys); err != nil {
+			return err
+		}
+	}
+	if volume.Conversion != nil {
+		return man.convert(volume.Name, ctrl, goodReplicas, len(woReplicas)+addingReplicas)
 	}
 	if len(goodReplicas)+len(woReplicas) > volume.NumberOfReplicas {
 		logrus.Warnf("volume '%s' has more replicas than needed: has %v, needs %v", volume.Name, len(goodReplicas), volume.NumberOfReplicas)
@@ -541,6 +1316,7 @@ func (man *volumeManager) Cleanup(v *types.VolumeInfo) error {
 	}
 	logrus.Infof("running cleanup, volume '%s'", volume.Name)
 	now := time.Now().UTC()
+	currentHostSkewed := man.clocks.skewed(man.orc.GetCurrentHostID())
 	errCh := make(chan error)
 	wg := &sync.WaitGroup{}
 	for _, replica := range volume.Replicas {
@@ -558,6 +1334,17 @@ func (man *volumeManager) Cleanup(v *types.VolumeInfo) error {
 					errCh <- errors.Wrapf(err, "error stopping bad replica '%s', volume '%s'", replica.Name, volume.Name)
 				}()
 			}
+			if currentHostSkewed {
+				logrus.Warnf("clock of current host is skewed, skip aging bad replica '%s', volume '%s'", replica.Name, volume.Name)
+				return
+			}
+			if man.clocks.skewed(replica.BadHostID) {
+				// restamp with the clock of the current host
+				logrus.Warnf("bad timestamp of replica '%s' was stamped by skewed host %v, restamping, volume '%s'",
+					replica.Name, replica.BadHostID, volume.Name)
+				errCh <- errors.Wrapf(man.orc.MarkBadReplica(volume.Name, replica), "error restamping bad replica '%s', volume '%s'", replica.Name, volume.Name)
+				return
+			}
 			badTime, err := util.ParseTime(replica.BadTimestamp)
 			if err != nil {
 				errCh <- errors.Wrapf(err, "fail to parse bad timestamp %v", replica.BadTimestamp)
@@ -600,19 +1387,171 @@ func (man *volumeManager) Controller(name string) (types.Controller, error) {
 }
 
 func (man *volumeManager) SnapshotOps(name string) (types.SnapshotOps, error) {
-	controller, err := man.Controller(name)
+	volume, err := man.Get(name)
 	if err != nil {
 		return nil, err
 	}
-	return controller.SnapshotOps(), nil
+	controller := man.getController(volume)
+	if controller == nil {
+		return nil, errors.Errorf("cannot find controller of volume '%s'", name)
+	}
+	return newPrunedSnapshotOps(volume, controller.SnapshotOps(), man.settings, man.getBackups), nil
 }
 
 func (man *volumeManager) ListHosts() (map[string]*types.HostInfo, error) {
-	return man.orc.ListHosts()
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range hosts {
+		man.clocks.annotate(host)
+	}
+	return hosts, nil
 }
 
 func (man *volumeManager) GetHost(id string) (*types.HostInfo, error) {
-	return man.orc.GetHost(id)
+	host, err := man.orc.GetHost(id)
+	if err != nil {
+		return nil, err
+	}
+	man.clocks.annotate(host)
+	return host, nil
+}
+
+// GetHosts reads the hosts in one batch, the latency of the last batch is
+// exported in the metrics
+func (man *volumeManager) GetHosts(ids []string) (map[string]*types.HostInfo, error) {
+	start := time.Now()
+	hosts, err := man.orc.GetHosts(ids)
+	if err != nil {
+		return nil, err
+	}
+	man.Lock()
+	man.hostsReadLatency = time.Since(start)
+	man.Unlock()
+	for _, host := range hosts {
+		man.clocks.annotate(host)
+	}
+	return hosts, nil
+}
+
+func (man *volumeManager) DeleteHost(id string, force bool) error {
+	if id == man.orc.GetCurrentHostID() {
+		return errors.Errorf("cannot delete current host %v", id)
+	}
+	host, err := man.orc.GetHost(id)
+	if err != nil {
+		return errors.Wrapf(err, "fail to get host %v", id)
+	}
+	if host == nil {
+		return errors.Errorf("cannot find host %v", id)
+	}
+	volumes, err := man.List()
+	if err != nil {
+		return errors.Wrapf(err, "fail to list volumes for deleting host %v", id)
+	}
+	affected := []*types.VolumeInfo{}
+	for _, volume := range volumes {
+		if volumeOnHost(volume, id) {
+			affected = append(affected, volume)
+		}
+	}
+	if len(affected) != 0 && !force {
+		names := []string{}
+		local := []string{}
+		for _, volume := range affected {
+			if volume.Mode == types.VolumeModeLocal {
+				local = append(local, volume.Name)
+			} else {
+				names = append(names, volume.Name)
+			}
+		}
+		if len(local) != 0 {
+			return errors.Errorf("host %v still has instances of volumes %v, and local volumes %v requiring manual migration",
+				id, names, local)
+		}
+		return errors.Errorf("host %v still has instances of volumes %v", id, names)
+	}
+
+	errs := Errs{}
+	rebuilds := map[string]types.Controller{}
+	for _, volume := range affected {
+		ctrl, err := man.forgetHostInstances(volume, id)
+		if err != nil {
+			errs = append(errs, err)
+			logrus.Errorf("%+v", err)
+			continue
+		}
+		if ctrl != nil {
+			rebuilds[volume.Name] = ctrl
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if err := man.orc.DeleteHost(id); err != nil {
+		return errors.Wrapf(err, "fail to delete host %v", id)
+	}
+
+	// rebuild only after the host is gone, so it won't get the new replicas
+	for name, ctrl := range rebuilds {
+		volume, err := man.Get(name)
+		if err != nil || volume == nil {
+			logrus.Warnf("unable to get volume '%s' for rebuild: %v", name, err)
+			continue
+		}
+		if err := man.CheckController(ctrl, volume); err != nil {
+			logrus.Errorf("%+v", errors.Wrapf(err, "fail to rebuild volume '%s' after deleting host %v", name, id))
+		}
+	}
+	return nil
+}
+
+func volumeOnHost(volume *types.VolumeInfo, hostID string) bool {
+	if volume.Controller != nil && volume.Controller.HostID == hostID {
+		return true
+	}
+	for _, replica := range volume.Replicas {
+		if replica.HostID == hostID {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetHostInstances drops the instances of the volume on a lost host from
+// the metadata. If the volume's controller runs on the current host, the lost
+// replicas are removed from it as well, and the controller is returned for
+// rebuilding redundancy on the remaining hosts.
+func (man *volumeManager) forgetHostInstances(volume *types.VolumeInfo, hostID string) (types.Controller, error) {
+	if volume.Controller != nil && volume.Controller.HostID == hostID {
+		logrus.Warnf("forgetting controller of volume '%s' on lost host %v", volume.Name, hostID)
+		man.stopMonitoring(volume)
+		if err := man.orc.ForgetInstance(&volume.Controller.InstanceInfo); err != nil {
+			return nil, errors.Wrapf(err, "fail to forget controller of volume '%s'", volume.Name)
+		}
+		volume.Controller = nil
+	}
+
+	var ctrl types.Controller
+	if volume.Controller != nil && volume.Controller.Running && volume.Controller.HostID == man.orc.GetCurrentHostID() {
+		ctrl = man.getController(volume)
+	}
+	for _, replica := range volume.Replicas {
+		if replica.HostID != hostID {
+			continue
+		}
+		logrus.Warnf("forgetting replica '%s' of volume '%s' on lost host %v", replica.Name, volume.Name, hostID)
+		if ctrl != nil && replica.Address != "" {
+			if err := ctrl.RemoveReplica(replica); err != nil {
+				logrus.Warnf("%v", errors.Wrapf(err, "fail to remove replica '%s' from controller, push on", replica.Name))
+			}
+		}
+		if err := man.orc.ForgetInstance(&replica.InstanceInfo); err != nil {
+			return nil, errors.Wrapf(err, "fail to forget replica '%s' of volume '%s'", replica.Name, volume.Name)
+		}
+	}
+	return ctrl, nil
 }
 
 func (man *volumeManager) VolumeBackupOps(name string) (types.VolumeBackupOps, error) {
@@ -639,6 +1578,48 @@ func (man *volumeManager) ProcessSchedule(spec *types.ScheduleSpec, item *types.
 	return scheduler.Process(spec, item)
 }
 
+func (man *volumeManager) SchedulerStatus() (*types.SchedulerStatus, error) {
+	scheduler := man.orc.Scheduler()
+	if scheduler == nil {
+		return nil, errors.Errorf("No scheduler found for the orchestrator")
+	}
+	unschedulable, err := man.countUnschedulable()
+	if err != nil {
+		return nil, err
+	}
+	status := scheduler.Status()
+	status.Unschedulable = unschedulable
+	return status, nil
+}
+
+// GetReplicaDiskUsage has to run on the host of the replica
+func (man *volumeManager) GetReplicaDiskUsage(volumeName, replicaName string) (*types.DiskUsage, error) {
+	volume, err := man.orc.GetVolume(volumeName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get volume '%s'", volumeName)
+	}
+	if volume == nil {
+		return nil, errors.Errorf("cannot find volume '%s'", volumeName)
+	}
+	replica := volume.Replicas[replicaName]
+	if replica == nil {
+		return nil, errors.Errorf("cannot find replica %v of volume %v", replicaName, volumeName)
+	}
+	path, err := man.orc.ReplicaDataPath(replica)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get data path of replica %v of volume %v", replicaName, volumeName)
+	}
+	apparent, allocated, err := util.DiskUsage(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get disk usage of replica %v of volume %v", replicaName, volumeName)
+	}
+	return &types.DiskUsage{
+		Path:           path,
+		AllocatedBytes: allocated,
+		ApparentBytes:  apparent,
+	}, nil
+}
+
 func (man *volumeManager) ReplicaRemove(volumeName, replicaName string) error {
 	volume, err := man.Get(volumeName)
 	if err != nil {
@@ -0,0 +1,570 @@
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestDeleteHostForced(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+
+	attached, err := man.Create(&types.VolumeInfo{Name: "attached", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach(attached.Name))
+	detached, err := man.Create(&types.VolumeInfo{Name: "detached", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+
+	// fake scheduling puts the replicas on host-1 and host-2
+	for _, name := range []string{attached.Name, detached.Name} {
+		volume, err := man.Get(name)
+		assert.Nil(err)
+		assert.True(volumeOnHost(volume, "host-2"))
+	}
+
+	err = man.DeleteHost("host-2", false)
+	assert.NotNil(err)
+	host, err := man.GetHost("host-2")
+	assert.Nil(err)
+	assert.NotNil(host)
+
+	assert.Nil(man.DeleteHost("host-2", true))
+
+	host, err = man.GetHost("host-2")
+	assert.Nil(err)
+	assert.Nil(host)
+
+	volumes, err := man.List()
+	assert.Nil(err)
+	assert.Len(volumes, 2)
+	for _, volume := range volumes {
+		assert.False(volumeOnHost(volume, "host-2"), "volume '%s' references deleted host", volume.Name)
+	}
+
+	ctrl := fc.controllers[attached.Name]
+	assert.Len(ctrl.removed, 1)
+	select {
+	case replica := <-ctrl.added:
+		assert.Equal("host-3", replica.HostID)
+	case <-time.After(5 * time.Second):
+		assert.Fail("no rebuild scheduled after deleting host")
+	}
+
+	volume, err := man.Get(attached.Name)
+	assert.Nil(err)
+	assert.Len(volume.Replicas, 2)
+}
+
+func TestProvisioningLimit(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(max int) {
+		MaxConcurrentProvisioning = max
+	}(MaxConcurrentProvisioning)
+	MaxConcurrentProvisioning = 2
+
+	orc := newFakeOrc("host-1", "host-2")
+	orc.createStarted = make(chan string, 3)
+	orc.createGate = make(chan struct{})
+	man, _ := newTestManager(orc)
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func(name string) {
+			_, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 1})
+			errs <- err
+		}(fmt.Sprintf("vol-%v", i))
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-orc.createStarted:
+		case <-time.After(5 * time.Second):
+			assert.Fail("provisioning didn't start")
+		}
+	}
+	select {
+	case name := <-orc.createStarted:
+		assert.Fail("third provisioning didn't wait", "volume '%s'", name)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// reads are not blocked by provisioning
+	_, err := man.List()
+	assert.Nil(err)
+
+	close(orc.createGate)
+	select {
+	case <-orc.createStarted:
+	case <-time.After(5 * time.Second):
+		assert.Fail("third provisioning didn't start after the others finished")
+	}
+	for i := 0; i < 3; i++ {
+		assert.Nil(<-errs)
+	}
+
+	volumes, err := man.List()
+	assert.Nil(err)
+	assert.Len(volumes, 3)
+}
+
+func TestControllerFailedReattach(t *testing.T) {
+	defer checkLeaks(t)()
+	assert := require.New(t)
+
+	defer func(f func(string) (int64, error)) {
+		replicaRevisionCounter = f
+	}(replicaRevisionCounter)
+	revisions := map[string]int64{}
+	replicaRevisionCounter = func(address string) (int64, error) {
+		return revisions[address], nil
+	}
+
+	orc := newFakeOrc("host-1", "host-2")
+	orc.settings.AutoReattach = types.AutoReattachPolicyIfClean
+	man, _ := newTestManager(orc)
+
+	for _, name := range []string{"clean", "diverged"} {
+		_, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2})
+		assert.Nil(err)
+		assert.Nil(man.Attach(name))
+	}
+
+	volume, err := man.Get("diverged")
+	assert.Nil(err)
+	replicaNames := []string{}
+	for _, replica := range volume.Replicas {
+		revisions[replica.Address] = int64(len(replicaNames))
+		replicaNames = append(replicaNames, replica.Name)
+	}
+
+	assert.Nil(man.ControllerFailed("clean"))
+	volume, err = man.Get("clean")
+	assert.Nil(err)
+	assert.NotNil(volume.Controller)
+	assert.False(volume.SalvageRequired)
+	assert.Len(volume.AttachHistory, 2)
+	assert.Equal(AttachReasonAutoReattach, volume.AttachHistory[1].Reason)
+
+	assert.Nil(man.ControllerFailed("diverged"))
+	volume, err = man.Get("diverged")
+	assert.Nil(err)
+	assert.Nil(volume.Controller)
+	assert.True(volume.SalvageRequired)
+	assert.Contains(volume.SalvageReason, "revision counters disagree")
+	assert.NotNil(man.Attach("diverged"))
+	man.events.flush()
+	events, err := orc.ListVolumeEvents("diverged")
+	assert.Nil(err)
+	found := false
+	for _, e := range events {
+		if e.Reason == EventReasonSalvageRequired {
+			assert.Equal(types.EventSeverityError, e.Severity)
+			assert.Contains(e.Message, volume.SalvageReason)
+			found = true
+		}
+	}
+	assert.True(found)
+
+	assert.Nil(man.Salvage("diverged", replicaNames[:1]))
+	volume, err = man.Get("diverged")
+	assert.Nil(err)
+	assert.False(volume.SalvageRequired)
+	assert.Equal("", volume.Replicas[replicaNames[0]].BadTimestamp)
+	assert.NotEqual("", volume.Replicas[replicaNames[1]].BadTimestamp)
+	assert.Nil(man.Attach("diverged"))
+}
+
+func TestControllerFailedDisabled(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 1})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+
+	assert.Nil(man.ControllerFailed("vol"))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	assert.Nil(volume.Controller)
+	assert.False(volume.SalvageRequired)
+}
+
+func TestPinnedReplicasRebuilt(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	assert.Nil(man.UpdatePinReplicas("vol", true))
+
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	assert.True(volume.PinReplicas)
+
+	ctrl := fc.controllers["vol"]
+	ctrl.Lock()
+	for _, replica := range ctrl.replicas {
+		replica.Mode = types.ReplicaModeERR
+		break
+	}
+	ctrl.Unlock()
+
+	// pinning doesn't stop replacing failed replicas
+	assert.Nil(man.CheckController(ctrl, volume))
+	select {
+	case <-ctrl.added:
+	case <-time.After(5 * time.Second):
+		assert.Fail("no rebuild scheduled for pinned volume")
+	}
+}
+
+func TestGetReplicaDiskUsage(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1")
+	man, _ := newTestManager(orc)
+
+	volume, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 1})
+	assert.Nil(err)
+	assert.Len(volume.Replicas, 1)
+
+	dir, err := ioutil.TempDir("", "replica")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	// a sparse volume file with a single block written
+	f, err := os.Create(filepath.Join(dir, "volume-head-000.img"))
+	assert.Nil(err)
+	_, err = f.WriteAt(make([]byte, 4096), 1<<20)
+	assert.Nil(err)
+	assert.Nil(f.Close())
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "volume.meta"), []byte("{}"), 0600))
+
+	for name := range volume.Replicas {
+		orc.replicaDataPaths[name] = dir
+
+		usage, err := man.GetReplicaDiskUsage("vol", name)
+		assert.Nil(err)
+		assert.Equal(dir, usage.Path)
+		assert.Equal(int64(1<<20+4096+2), usage.ApparentBytes)
+		assert.True(usage.AllocatedBytes >= 4096)
+		assert.True(usage.AllocatedBytes < usage.ApparentBytes)
+	}
+
+	_, err = man.GetReplicaDiskUsage("vol", "nonexistent")
+	assert.NotNil(err)
+}
+
+func TestUpdateControllerReplicas(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+
+	replica, err := orc.CreateReplica("vol", man.GetReplicaName("vol"))
+	assert.Nil(err)
+	instance, err := orc.StartInstance(&replica.InstanceInfo)
+	assert.Nil(err)
+	replica.InstanceInfo = *instance
+
+	kept := []*types.ReplicaInfo{}
+	dropped := []*types.ReplicaInfo{}
+	for _, r := range volume.Replicas {
+		if r.HostID == "host-1" {
+			kept = append(kept, r)
+		} else {
+			dropped = append(dropped, r)
+		}
+	}
+	assert.Len(kept, 1)
+	assert.Len(dropped, 1)
+
+	ctrl := fc.controllers["vol"]
+	// rewiring to new replicas only would leave nothing to rebuild from
+	assert.NotNil(man.UpdateControllerReplicas("vol", []*types.ReplicaInfo{replica}))
+	assert.Len(ctrl.removed, 0)
+
+	assert.Nil(man.UpdateControllerReplicas("vol", append(kept, replica)))
+	select {
+	case added := <-ctrl.added:
+		assert.Equal(replica.Address, added.Address)
+	case <-time.After(5 * time.Second):
+		assert.Fail("new replica not added to controller")
+	}
+	assert.Equal([]string{dropped[0].Address}, ctrl.removed)
+
+	// nothing to do once the controller has the desired replicas
+	assert.Nil(man.UpdateControllerReplicas("vol", append(kept, replica)))
+	assert.Len(ctrl.removed, 1)
+	assert.Len(ctrl.added, 0)
+}
+
+func TestEngineVersionConstraint(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "bad", Size: 4096, NumberOfReplicas: 2,
+		EngineVersionConstraint: ">=0.3 <"})
+	assert.NotNil(err)
+
+	// out of range image is rejected
+	_, err = man.Create(&types.VolumeInfo{Name: "old", Size: 4096, NumberOfReplicas: 2,
+		EngineImage: "rancher/longhorn-engine:v0.2.1", EngineVersionConstraint: "^0.3"})
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "violates")
+	volume, err := man.Get("old")
+	assert.Nil(err)
+	assert.Nil(volume)
+
+	// the version of an untagged image cannot be told
+	_, err = man.Create(&types.VolumeInfo{Name: "untagged", Size: 4096, NumberOfReplicas: 2,
+		EngineVersionConstraint: "^0.3"})
+	assert.NotNil(err)
+
+	_, err = man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2,
+		EngineImage: "rancher/longhorn-engine:v0.3.1", EngineVersionConstraint: "^0.3"})
+	assert.Nil(err)
+	assert.NotNil(man.UpdateEngineVersionConstraint("vol", ">=0.4.0"))
+	assert.Nil(man.UpdateEngineVersionConstraint("vol", ">=0.3.0 <0.5.0"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(">=0.3.0 <0.5.0", volume.EngineVersionConstraint)
+
+	// the volume isn't launched on an image out of range
+	volume.EngineImage = "rancher/longhorn-engine:v0.5.0"
+	assert.Nil(orc.UpdateVolume(volume))
+	err = man.Attach("vol")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "violates")
+}
+
+func TestLocalVolume(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, fc := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "bad", Size: 4096, NumberOfReplicas: 2, Mode: types.VolumeModeLocal})
+	assert.NotNil(err)
+
+	volume, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, Mode: types.VolumeModeLocal, PreferredHostID: "host-2"})
+	assert.Nil(err)
+	assert.Equal(1, volume.NumberOfReplicas)
+	assert.Len(volume.Replicas, 1)
+	for _, replica := range volume.Replicas {
+		assert.Equal("host-2", replica.HostID)
+	}
+	assert.NotNil(man.UpdatePreferredHost("vol", "host-1", types.RehomePolicyNone))
+
+	// the controller stays with the replica
+	err = man.Attach("vol")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "host-2")
+
+	orc.currentHostID = "host-2"
+	assert.Nil(man.Attach("vol"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal("host-2", volume.Controller.HostID)
+
+	// drain leaves the volume to be migrated by hand
+	_, err = man.DrainHost("host-2", 0)
+	assert.Nil(err)
+	progress := waitDrain(t, man, "host-2")
+	assert.Equal(0, progress.Total)
+	assert.Equal([]string{"vol"}, progress.ManualMigration)
+
+	// the failed replica is not rebuilt, the volume goes down
+	ctrl := fc.controllers["vol"]
+	ctrl.Lock()
+	for _, replica := range ctrl.replicas {
+		replica.Mode = types.ReplicaModeERR
+	}
+	ctrl.Unlock()
+	assert.Nil(man.CheckController(ctrl, volume))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Len(volume.Replicas, 1)
+	assert.Nil(volume.Controller)
+	assert.Equal(types.VolumeStateFaulted, volume.State)
+}
+
+func TestZoneDistribution(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	orc.hosts["host-1"].FailureDomain = "zone-a"
+	orc.hosts["host-2"].FailureDomain = "zone-a"
+	orc.hosts["host-3"].FailureDomain = "zone-b"
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "negative", Size: 4096, NumberOfReplicas: 2, MinZones: -1})
+	assert.NotNil(err)
+	_, err = man.Create(&types.VolumeInfo{Name: "toomany", Size: 4096, NumberOfReplicas: 2, MinZones: 3})
+	assert.NotNil(err)
+
+	// only two zones
+	_, err = man.Create(&types.VolumeInfo{Name: "zones", Size: 4096, NumberOfReplicas: 3, MinZones: 3})
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "only 2 zones")
+	_, err = man.Create(&types.VolumeInfo{Name: "perzone", Size: 4096, NumberOfReplicas: 3, MaxReplicasPerZone: 1})
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "at most 1 per zone")
+	volume, err := man.Get("perzone")
+	assert.Nil(err)
+	assert.Nil(volume)
+
+	volume, err = man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2,
+		MaxReplicasPerZone: 1, MinZones: 2})
+	assert.Nil(err)
+	assert.Equal(1, volume.MaxReplicasPerZone)
+	assert.Equal(2, volume.MinZones)
+}
+
+func TestFsType(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "bad", Size: 4096, NumberOfReplicas: 1, FsType: "btrfs"})
+	assert.NotNil(err)
+
+	volume, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 1, FsType: types.FsTypeExt4, FsInitialized: true})
+	assert.Nil(err)
+	assert.False(volume.FsInitialized)
+
+	// the first attach formats the device
+	assert.Nil(man.Attach("vol"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.True(volume.FsInitialized)
+	assert.Equal(types.FsTypeExt4, volume.FsType)
+}
+
+func TestAttachWithEnv(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 1})
+	assert.Nil(err)
+
+	assert.NotNil(man.AttachWithEnv("vol", map[string]string{"LD_PRELOAD": "/tmp/x.so"}))
+
+	env := map[string]string{"LOG_LEVEL": "debug"}
+	assert.Nil(man.AttachWithEnv("vol", env))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(env, volume.AttachEnv)
+
+	// the env of the running controller stays
+	assert.NotNil(man.AttachWithEnv("vol", map[string]string{"LOG_LEVEL": "info"}))
+	assert.Nil(man.AttachWithEnv("vol", env))
+	assert.Nil(man.Attach("vol"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(env, volume.AttachEnv)
+
+	// a new attach request without env clears it
+	assert.Nil(man.Detach("vol"))
+	assert.Nil(man.Attach("vol"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Nil(volume.AttachEnv)
+}
+
+func TestListPage(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, _ := newTestManager(orc)
+
+	for _, name := range []string{"vol-3", "vol-1", "vol-5", "vol-2", "vol-4"} {
+		_, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2})
+		assert.Nil(err)
+	}
+
+	names := []string{}
+	after := ""
+	for pages := 0; ; pages++ {
+		assert.True(pages < 3)
+		volumes, next, err := man.ListPage(after, 2)
+		assert.Nil(err)
+		for _, v := range volumes {
+			names = append(names, v.Name)
+		}
+		if next == "" {
+			break
+		}
+		after = next
+	}
+	assert.Equal([]string{"vol-1", "vol-2", "vol-3", "vol-4", "vol-5"}, names)
+
+	// a full last page has no next one
+	volumes, next, err := man.ListPage("vol-3", 2)
+	assert.Nil(err)
+	assert.Len(volumes, 2)
+	assert.Equal("", next)
+
+	_, _, err = man.ListPage("", 0)
+	assert.NotNil(err)
+}
+
+func TestAbortVolumeCreation(t *testing.T) {
+	defer checkLeaks(t)()
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	orc.replicaLimits = map[string]int{"vol": 1}
+	man, _ := newTestManager(orc)
+
+	// the second replica fails, the volume is left half created
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.NotNil(err)
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(types.VolumeStateCreating, volume.State)
+	assert.Len(volume.Replicas, 1)
+	assert.NotNil(man.Attach("vol"))
+
+	assert.Nil(man.AbortVolumeCreation("vol"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Nil(volume)
+	assert.NotNil(man.AbortVolumeCreation("vol"))
+
+	// the creation can be retried
+	delete(orc.replicaLimits, "vol")
+	volume, err = man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Equal(types.VolumeStateDetached, volume.State)
+	assert.Len(volume.Replicas, 2)
+	assert.False(volume.Creating)
+	assert.NotNil(man.AbortVolumeCreation("vol"))
+}
@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/backups"
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+const metadataExportLock = "metadata-export"
+
+var (
+	// MetadataExportInterval is how often the metadata snapshot is exported
+	// to the backup target, 0 to never
+	MetadataExportInterval = time.Hour
+	// MetadataKey encrypts the metadata snapshots, they're only compressed
+	// if empty
+	MetadataKey []byte
+
+	getMetadataStore types.GetMetadataStore = backups.NewMetadataStore
+)
+
+// exportMetadata writes a snapshot of the volumes, hosts and settings to the
+// backup target, unless another manager did it within the interval. It
+// returns the name of the snapshot, "" if skipped.
+func (man *volumeManager) exportMetadata() (string, error) {
+	settings, err := man.settings.GetSettings()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to export metadata")
+	}
+	if settings == nil || settings.BackupTarget == "" {
+		return "", nil
+	}
+	store, err := getMetadataStore(settings.BackupTarget)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to export metadata")
+	}
+
+	lock, err := man.acquireLock(metadataExportLock, "")
+	if err != nil {
+		if _, ok := err.(*types.ErrLockHeld); ok {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "unable to export metadata")
+	}
+	defer lock.release()
+
+	latest, _, err := store.Latest()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to export metadata")
+	}
+	now := time.Now()
+	if latest != "" {
+		if created, err := backups.MetadataTime(latest); err == nil && now.Sub(created) < MetadataExportInterval {
+			return "", nil
+		}
+	}
+
+	snapshot, err := man.metadataSnapshot(settings)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to export metadata")
+	}
+	data, err := backups.EncodeMetadata(snapshot, MetadataKey)
+	if err != nil {
+		return "", err
+	}
+	name := backups.MetadataName(now)
+	if err := store.Put(name, data); err != nil {
+		return "", errors.Wrap(err, "unable to export metadata")
+	}
+	logrus.Infof("Exported metadata snapshot %v of %v volumes and %v hosts, %v bytes",
+		name, len(snapshot.Volumes), len(snapshot.Hosts), len(data))
+	return name, nil
+}
+
+// metadataSnapshot keeps what's needed to find the data of the volumes
+// again, none of the running state. The env of the instances is redacted as
+// in the API, since the backup target is no place for secrets.
+func (man *volumeManager) metadataSnapshot(settings *types.SettingsInfo) (*types.MetadataSnapshot, error) {
+	volumes, err := man.orc.ListVolumes()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list volumes")
+	}
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list hosts")
+	}
+	snapshot := &types.MetadataSnapshot{
+		Version:  types.MetadataSnapshotVersion,
+		Created:  util.Now(),
+		HostID:   man.orc.GetCurrentHostID(),
+		Settings: redactSettings(settings),
+		Hosts:    []*types.HostInfo{},
+		Volumes:  []*types.VolumeInfo{},
+	}
+	for _, volume := range volumes {
+		v := *volume
+		v.Controller = nil
+		v.Endpoint = ""
+		v.AttachHistory = nil
+		v.Migration = nil
+		v.InstanceEnv = util.RedactOpts(v.InstanceEnv)
+		v.AttachEnv = nil
+		v.Replicas = map[string]*types.ReplicaInfo{}
+		for name, replica := range volume.Replicas {
+			r := *replica
+			r.Env = util.RedactEnv(r.Env)
+			v.Replicas[name] = &r
+		}
+		snapshot.Volumes = append(snapshot.Volumes, &v)
+	}
+	for _, host := range hosts {
+		snapshot.Hosts = append(snapshot.Hosts, &types.HostInfo{
+			UUID:             host.UUID,
+			Name:             host.Name,
+			Address:          host.Address,
+			Unschedulable:    host.Unschedulable,
+			FailureDomain:    host.FailureDomain,
+			SchedulingWeight: host.SchedulingWeight,
+			Role:             host.Role,
+		})
+	}
+	return snapshot, nil
+}
+
+func redactSettings(settings *types.SettingsInfo) *types.SettingsInfo {
+	s := *settings
+	s.InstanceEnv = util.RedactOpts(s.InstanceEnv)
+	return &s
+}
+
+func (man *volumeManager) metadataExport(ctx context.Context) error {
+	if MetadataExportInterval <= 0 {
+		return nil
+	}
+	// another manager exporting within the interval is noticed on the
+	// backup target, checking more often spreads the exports. The same
+	// error, e.g. of a backup target not supported, is only logged once.
+	lastErr := ""
+	return runner.Tick(ctx, MetadataExportInterval/4, func() {
+		_, err := man.exportMetadata()
+		if err != nil && err.Error() != lastErr {
+			logrus.Warnf("%v", err)
+		}
+		lastErr = ""
+		if err != nil {
+			lastErr = err.Error()
+		}
+	})
+}
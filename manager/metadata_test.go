@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/backups"
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+type fakeMetadataStore struct {
+	snapshots map[string][]byte
+	latest    string
+}
+
+func (s *fakeMetadataStore) Put(name string, data []byte) error {
+	s.snapshots[name] = data
+	if name > s.latest {
+		s.latest = name
+	}
+	return nil
+}
+
+func (s *fakeMetadataStore) Latest() (string, []byte, error) {
+	return s.latest, s.snapshots[s.latest], nil
+}
+
+func TestExportMetadata(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	store := &fakeMetadataStore{snapshots: map[string][]byte{}}
+	defer func(get types.GetMetadataStore, key []byte) {
+		getMetadataStore, MetadataKey = get, key
+	}(getMetadataStore, MetadataKey)
+	getMetadataStore = func(backupTarget string) (types.MetadataStore, error) {
+		return store, nil
+	}
+	MetadataKey = []byte("0123456789abcdef0123456789abcdef")
+
+	volume := filterTestVolume("attached", "host-1", nil, "host-1", "host-2")
+	volume.InstanceEnv = map[string]string{"S3_SECRET_KEY": "secret", "TZ": "UTC"}
+	volume.AttachEnv = map[string]string{"S3_SECRET_KEY": "other"}
+	volume.Replicas["attached-replica-host-2"].Env = []string{"S3_SECRET_KEY=secret", "TZ=UTC"}
+	_, err := orc.CreateVolume(volume)
+	assert.Nil(err)
+
+	// no backup target
+	name, err := man.exportMetadata()
+	assert.Nil(err)
+	assert.Equal("", name)
+
+	assert.Nil(orc.UpdateSettings("admin", func(si *types.SettingsInfo) error {
+		si.BackupTarget = "vfs:///var/lib/longhorn/backups"
+		si.InstanceEnv = map[string]string{"S3_SECRET_KEY": "secret"}
+		return nil
+	}))
+	name, err = man.exportMetadata()
+	assert.Nil(err)
+	assert.NotEqual("", name)
+
+	snapshot, err := backups.DecodeMetadata(store.snapshots[name], MetadataKey)
+	assert.Nil(err)
+	assert.Equal(types.MetadataSnapshotVersion, snapshot.Version)
+	assert.Equal("host-1", snapshot.HostID)
+	assert.Equal("vfs:///var/lib/longhorn/backups", snapshot.Settings.BackupTarget)
+	assert.Len(snapshot.Hosts, 2)
+	assert.Len(snapshot.Volumes, 1)
+	assert.Nil(snapshot.Volumes[0].Controller)
+	assert.Len(snapshot.Volumes[0].Replicas, 2)
+	assert.Equal("host-2", snapshot.Volumes[0].Replicas["attached-replica-host-2"].HostID)
+	assert.Equal(map[string]string{"S3_SECRET_KEY": util.Redacted}, snapshot.Settings.InstanceEnv)
+	assert.Equal(map[string]string{"S3_SECRET_KEY": util.Redacted, "TZ": "UTC"}, snapshot.Volumes[0].InstanceEnv)
+	assert.Nil(snapshot.Volumes[0].AttachEnv)
+	assert.Equal([]string{"S3_SECRET_KEY=" + util.Redacted, "TZ=UTC"}, snapshot.Volumes[0].Replicas["attached-replica-host-2"].Env)
+	stored, err := orc.GetVolume("attached")
+	assert.Nil(err)
+	assert.Equal("secret", stored.InstanceEnv["S3_SECRET_KEY"])
+	_, err = backups.DecodeMetadata(store.snapshots[name], nil)
+	assert.NotNil(err)
+
+	// exported within the interval
+	name, err = man.exportMetadata()
+	assert.Nil(err)
+	assert.Equal("", name)
+
+	// nor while another manager exports it
+	store.latest = backups.MetadataName(time.Now().Add(-2 * MetadataExportInterval))
+	assert.Nil(orc.AcquireLock(&types.LockInfo{Name: metadataExportLock, HolderID: "host-2", OperationID: "other"}, time.Minute))
+	name, err = man.exportMetadata()
+	assert.Nil(err)
+	assert.Equal("", name)
+	assert.Nil(orc.ReleaseLock(metadataExportLock, "other"))
+	name, err = man.exportMetadata()
+	assert.Nil(err)
+	assert.NotEqual("", name)
+}
@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+var (
+	VolumeMetricsRefreshPeriod = time.Minute
+
+	// MaxMetricsVolumes bounds the volumes with their own samples, by name
+	// order. The others are only counted.
+	MaxMetricsVolumes = 1000
+)
+
+const (
+	MetricVolumeCapacity          = "longhorn_volume_capacity_bytes"
+	MetricVolumeActualSize        = "longhorn_volume_actual_size_bytes"
+	MetricVolumeActualSizeUnknown = "longhorn_volume_actual_size_unknown_volumes"
+	MetricVolumeMetricsDropped    = "longhorn_volume_metrics_dropped_volumes"
+	MetricHostsReadLatency        = "longhorn_hosts_batch_read_seconds"
+	MetricRecordsRemoved          = "longhorn_record_gc_removed_records_total"
+)
+
+// collectVolumeMetrics builds the volume metrics, with the actual sizes from
+// sizeOf. The volumes whose actual size cannot be fetched have no sample of
+// it, and are counted instead.
+func (man *volumeManager) collectVolumeMetrics(sizeOf func(volume *types.VolumeInfo) (int64, error)) ([]*types.MetricFamily, error) {
+	capacity := &types.MetricFamily{
+		Name:    MetricVolumeCapacity,
+		Help:    "The size of the volume",
+		Type:    types.MetricTypeGauge,
+		Samples: []*types.MetricSample{},
+	}
+	actualSize := &types.MetricFamily{
+		Name:    MetricVolumeActualSize,
+		Help:    "The space allocated by a good replica of the volume",
+		Type:    types.MetricTypeGauge,
+		Samples: []*types.MetricSample{},
+	}
+	unknown, dropped := 0, 0
+	if err := man.orc.ForEachVolume("", func(volume *types.VolumeInfo) error {
+		if len(capacity.Samples) >= MaxMetricsVolumes {
+			dropped++
+			return nil
+		}
+		labels := map[string]string{"volume": volume.Name}
+		capacity.Samples = append(capacity.Samples, &types.MetricSample{Labels: labels, Value: float64(volume.Size)})
+		size, err := sizeOf(volume)
+		if err != nil {
+			logrus.Debugf("unable to get actual size of volume '%s' for the metrics: %v", volume.Name, err)
+			unknown++
+			return nil
+		}
+		actualSize.Samples = append(actualSize.Samples, &types.MetricSample{Labels: labels, Value: float64(size)})
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "unable to list volumes")
+	}
+	return []*types.MetricFamily{
+		capacity,
+		actualSize,
+		{
+			Name:    MetricVolumeActualSizeUnknown,
+			Help:    "The volumes whose actual size cannot be fetched",
+			Type:    types.MetricTypeGauge,
+			Samples: []*types.MetricSample{{Value: float64(unknown)}},
+		},
+		{
+			Name:    MetricVolumeMetricsDropped,
+			Help:    "The volumes without samples, beyond the max number of volumes exported",
+			Type:    types.MetricTypeGauge,
+			Samples: []*types.MetricSample{{Value: float64(dropped)}},
+		},
+	}, nil
+}
+
+// updateVolumeMetrics replaces the volume metrics, the previous ones are
+// kept if the volumes cannot be listed
+func (man *volumeManager) updateVolumeMetrics(sizeOf func(volume *types.VolumeInfo) (int64, error)) error {
+	families, err := man.collectVolumeMetrics(sizeOf)
+	if err != nil {
+		return err
+	}
+	man.Lock()
+	defer man.Unlock()
+	man.volumeMetrics = families
+	return nil
+}
+
+func (man *volumeManager) refreshVolumeMetrics(ctx context.Context) error {
+	refresh := func() {
+		if err := man.updateVolumeMetrics(man.actualSize); err != nil {
+			logrus.Warnf("%v", errors.Wrap(err, "error refreshing volume metrics"))
+		}
+	}
+	refresh()
+	return runner.Tick(ctx, VolumeMetricsRefreshPeriod, refresh)
+}
+
+// Metrics returns the volume metrics of the last refresh, none before the
+// first one, the latency of the last batched read of the hosts, if any, the
+// records removed by the record GC, the skewed hosts, the latency of the
+// operations and the expiry of the certificates
+func (man *volumeManager) Metrics() []*types.MetricFamily {
+	man.Lock()
+	defer man.Unlock()
+	families := append([]*types.MetricFamily{}, man.volumeMetrics...)
+	if man.hostsReadLatency > 0 {
+		families = append(families, &types.MetricFamily{
+			Name:    MetricHostsReadLatency,
+			Help:    "The latency of the last batched read of the hosts",
+			Type:    types.MetricTypeGauge,
+			Samples: []*types.MetricSample{{Value: man.hostsReadLatency.Seconds()}},
+		})
+	}
+	if len(man.recordsRemoved) != 0 {
+		removed := &types.MetricFamily{
+			Name:    MetricRecordsRemoved,
+			Help:    "The records removed by the record GC of the manager since it started",
+			Type:    types.MetricTypeCounter,
+			Samples: []*types.MetricSample{},
+		}
+		for key, count := range man.recordsRemoved {
+			removed.Samples = append(removed.Samples, &types.MetricSample{
+				Labels: map[string]string{"record": key.record, "outcome": string(key.outcome)},
+				Value:  float64(count),
+			})
+		}
+		sort.Slice(removed.Samples, func(i, j int) bool {
+			a, b := removed.Samples[i].Labels, removed.Samples[j].Labels
+			return a["record"]+a["outcome"] < b["record"]+b["outcome"]
+		})
+		families = append(families, removed)
+	}
+	families = append(families, man.clocks.families()...)
+	families = append(families, man.opStats.families()...)
+	return append(families, man.tlsMetrics()...)
+}
@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestVolumeMetrics(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(max int) { MaxMetricsVolumes = max }(MaxMetricsVolumes)
+	MaxMetricsVolumes = 2
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+	assert.Len(man.Metrics(), 0)
+	for _, name := range []string{"vol-c", "vol-a", "vol-b"} {
+		_, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2})
+		assert.Nil(err)
+	}
+
+	sizes := map[string]int64{"vol-a": 1024}
+	assert.Nil(man.updateVolumeMetrics(func(volume *types.VolumeInfo) (int64, error) {
+		size, ok := sizes[volume.Name]
+		if !ok {
+			return 0, errors.Errorf("no good replica")
+		}
+		return size, nil
+	}))
+	families := map[string]*types.MetricFamily{}
+	for _, f := range man.Metrics() {
+		families[f.Name] = f
+	}
+	for _, name := range []string{MetricVolumeCapacity, MetricVolumeActualSize, MetricVolumeActualSizeUnknown, MetricVolumeMetricsDropped} {
+		assert.Equal(types.MetricTypeGauge, families[name].Type)
+	}
+
+	// the first volumes by name, vol-b has no actual size
+	assert.Equal([]*types.MetricSample{
+		{Labels: map[string]string{"volume": "vol-a"}, Value: 4096},
+		{Labels: map[string]string{"volume": "vol-b"}, Value: 4096},
+	}, families[MetricVolumeCapacity].Samples)
+	assert.Equal([]*types.MetricSample{
+		{Labels: map[string]string{"volume": "vol-a"}, Value: 1024},
+	}, families[MetricVolumeActualSize].Samples)
+	assert.Equal(float64(1), families[MetricVolumeActualSizeUnknown].Samples[0].Value)
+	assert.Equal(float64(1), families[MetricVolumeMetricsDropped].Samples[0].Value)
+}
+
+func TestHostsReadMetric(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+	assert.Len(man.Metrics(), 0)
+
+	hosts, err := man.GetHosts([]string{"host-2", "host-3"})
+	assert.Nil(err)
+	assert.Len(hosts, 2)
+	assert.Equal("host-2", hosts["host-2"].Name)
+	assert.Nil(hosts["host-3"])
+
+	families := man.Metrics()
+	assert.Len(families, 1)
+	assert.Equal(MetricHostsReadLatency, families[0].Name)
+	assert.True(families[0].Samples[0].Value > 0)
+}
@@ -0,0 +1,265 @@
+package manager
+
+import (
+	"net"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/trace"
+)
+
+var (
+	// MigrationPauseBudget is how long the IO of a volume may be paused
+	// while its controller is migrated, it's rolled back beyond it
+	MigrationPauseBudget = 10 * time.Second
+	ReplicaProbeTimeout  = 5 * time.Second
+
+	// probeReplica checks if the replica at the address accepts the
+	// connections of a controller on the current host
+	probeReplica = func(address string) error {
+		conn, err := net.DialTimeout("tcp", address+":9502", ReplicaProbeTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+)
+
+const (
+	EventReasonControllerMigrated          = "ControllerMigrated"
+	EventReasonControllerMigrationRollback = "ControllerMigrationRollback"
+
+	AttachReasonMigrated = "controller migrated"
+)
+
+// MigrateController moves the controller of the attached volume to the
+// current host. The engine has no standby mode, so it's a switchover: the
+// old controller is stopped, which flushes and pauses the IO, and a new one
+// is created on the current host with the device exposed there. Like a
+// failover from a fenced host, the generation of the volume is bumped so the
+// source host removes the old controller once the new one runs. If the new
+// controller fails or takes longer than MigrationPauseBudget, it's removed
+// and the old one started again.
+func (man *volumeManager) MigrateController(name string) (_ *types.ControllerMigration, err error) {
+	lock, err := man.acquireLock("migrate-"+name, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to migrate controller of volume '%s'", name)
+	}
+	defer lock.release()
+
+	volume, err := man.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if volume == nil {
+		return nil, errors.Errorf("cannot find volume '%s'", name)
+	}
+	if err := man.checkMigration(volume); err != nil {
+		return nil, errors.Wrapf(err, "unable to migrate controller of volume '%s'", name)
+	}
+	replicas := map[string]*types.ReplicaInfo{}
+	for k, replica := range volume.Replicas {
+		if replica.Running && replica.BadTimestamp == "" && volume.StandbyReplicas[k] == nil {
+			replicas[k] = replica
+		}
+	}
+	if len(replicas) == 0 {
+		return nil, errors.Errorf("no running replicas to migrate the controller of volume '%s' with", name)
+	}
+	for _, replica := range replicas {
+		if err := probeReplica(replica.Address); err != nil {
+			return nil, errors.Wrapf(err, "host %v cannot reach replica '%s' of volume '%s'",
+				man.orc.GetCurrentHostID(), replica.Name, name)
+		}
+	}
+
+	op := man.beginOperation("migrate controller", name)
+	defer func() {
+		man.endOperation(op, err)
+	}()
+	old := volume.Controller.InstanceInfo
+	migration := &types.ControllerMigration{
+		SourceHostID: old.HostID,
+		TargetHostID: man.orc.GetCurrentHostID(),
+		State:        types.MigrationStateRunning,
+		Started:      util.Now(),
+		PauseBudget:  MigrationPauseBudget.String(),
+	}
+	if err := man.updateMigration(name, migration, 0); err != nil {
+		return nil, err
+	}
+
+	paused := time.Now()
+	controller, err := man.switchController(volume, &old, replicas, paused)
+	migration.Pause = time.Since(paused).String()
+	migration.Finished = util.Now()
+	if err != nil {
+		return migration, man.rollbackMigration(volume, &old, migration, paused, err)
+	}
+	migration.State = types.MigrationStateSucceeded
+	if err := man.updateMigration(name, migration, 0); err != nil {
+		logrus.Warnf("%v", err)
+	}
+	logrus.Infof("migrated controller of volume '%s' from host %v to %v, IO paused for %v",
+		name, migration.SourceHostID, migration.TargetHostID, migration.Pause)
+	man.events.record(name, types.EventSeverityInfo, EventReasonControllerMigrated,
+		"controller migrated from host %v to %v, IO paused for %v", migration.SourceHostID, migration.TargetHostID, migration.Pause)
+	if err := man.recordAttach(name, AttachReasonMigrated+" from host "+migration.SourceHostID); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "failed to record attach target for volume '%s'", name))
+	}
+	volume.Controller = controller
+	man.startMonitoring(volume)
+	return migration, nil
+}
+
+// checkMigration is why the controller of the volume cannot be migrated to
+// the current host, nil if it can
+func (man *volumeManager) checkMigration(volume *types.VolumeInfo) error {
+	currentHostID := man.orc.GetCurrentHostID()
+	switch {
+	case volume.Controller == nil || !volume.Controller.Running:
+		return errors.Errorf("volume is not attached")
+	case volume.Controller.HostID == currentHostID:
+		return errors.Errorf("the controller runs on host %v already", currentHostID)
+	case volume.Mode == types.VolumeModeLocal:
+		return errors.Errorf("the controller of a %v volume stays on its host", volume.Mode)
+	case volume.State == types.VolumeStateFaulted:
+		return errors.Errorf("volume is %v", volume.State)
+	case volume.Conversion != nil:
+		return errors.Errorf("volume is being converted to %v", volume.Conversion.TargetMode)
+	case man.isRebuilding(volume.Name):
+		return errors.Errorf("volume is rebuilding a replica")
+	}
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return errors.Wrap(err, "unable to list hosts")
+	}
+	if err := man.HostDetails(hosts); err != nil {
+		return err
+	}
+	if reason := attachHostUnavailable(hosts[currentHostID]); reason != "" {
+		return errors.Errorf("host %v cannot run the controller: %v", currentHostID, reason)
+	}
+	return nil
+}
+
+// switchController stops the old controller and creates the new one on the
+// current host, within the pause budget from paused. A new controller too
+// late is removed.
+func (man *volumeManager) switchController(volume *types.VolumeInfo, old *types.InstanceInfo,
+	replicas map[string]*types.ReplicaInfo, paused time.Time) (*types.ControllerInfo, error) {
+	span := trace.Current(volume.Name)
+	step := span.Child("stop old controller")
+	_, err := man.orc.StopInstance(old)
+	if step.End(err); err != nil {
+		return nil, errors.Wrapf(err, "failed to stop controller of volume '%s' on host %v", volume.Name, old.HostID)
+	}
+	// the container stays on the source host until the new controller runs
+	if err := man.orc.ForgetInstance(old); err != nil {
+		return nil, errors.Wrapf(err, "failed to forget controller of volume '%s' on host %v", volume.Name, old.HostID)
+	}
+	if err := man.updateMigration(volume.Name, nil, 1); err != nil {
+		return nil, err
+	}
+
+	type created struct {
+		controller *types.ControllerInfo
+		err        error
+	}
+	step = span.Child("create controller")
+	createdCh := make(chan created, 1)
+	go func() {
+		controller, err := man.orc.CreateController(volume.Name, man.GetControllerName(volume.Name), replicas)
+		createdCh <- created{controller, err}
+	}()
+	var c created
+	select {
+	case c = <-createdCh:
+	case <-time.After(MigrationPauseBudget - time.Since(paused)):
+		// the creation cannot be interrupted, the replicas must not have
+		// both controllers at once
+		c = <-createdCh
+		if c.err == nil {
+			if _, err := man.orc.RemoveInstance(&c.controller.InstanceInfo); err != nil {
+				logrus.Errorf("%+v", errors.Wrapf(err, "failed to remove late controller of volume '%s'", volume.Name))
+			}
+		}
+		c.err = errors.Errorf("the new controller wasn't up within the pause budget %v", MigrationPauseBudget)
+	}
+	if step.End(c.err); c.err != nil {
+		return nil, errors.Wrapf(c.err, "failed to create controller of volume '%s' on host %v", volume.Name, man.orc.GetCurrentHostID())
+	}
+	return c.controller, nil
+}
+
+// rollbackMigration starts the old controller again, with the generation
+// of the volume it was created with, and records why the migration failed
+func (man *volumeManager) rollbackMigration(volume *types.VolumeInfo, old *types.InstanceInfo,
+	migration *types.ControllerMigration, paused time.Time, cause error) error {
+	logrus.Errorf("%+v", cause)
+	migration.Error = cause.Error()
+	step := trace.Current(volume.Name).Child("restart old controller")
+	err := man.restoreController(volume, old)
+	step.End(err)
+	if err != nil {
+		migration.State = types.MigrationStateFailed
+		migration.Error += ", rollback failed: " + err.Error()
+		if err := man.updateMigration(volume.Name, migration, 0); err != nil {
+			logrus.Warnf("%v", err)
+		}
+		man.events.record(volume.Name, types.EventSeverityError, EventReasonControllerMigrationRollback,
+			"controller migration to host %v failed and couldn't be rolled back, volume to be attached again: %v", migration.TargetHostID, migration.Error)
+		return errors.Wrapf(err, "%v, and failed to restart the old controller on host %v, volume '%s' is to be attached again",
+			cause, old.HostID, volume.Name)
+	}
+
+	// the pause lasts until the old controller is started over
+	migration.Pause = time.Since(paused).String()
+	migration.State = types.MigrationStateRolledBack
+	if err := man.updateMigration(volume.Name, migration, 0); err != nil {
+		logrus.Warnf("%v", err)
+	}
+	man.events.record(volume.Name, types.EventSeverityWarning, EventReasonControllerMigrationRollback,
+		"controller migration to host %v rolled back to host %v: %v", migration.TargetHostID, old.HostID, cause)
+	return errors.Wrapf(cause, "rolled back controller migration of volume '%s' to host %v", volume.Name, old.HostID)
+}
+
+func (man *volumeManager) restoreController(volume *types.VolumeInfo, old *types.InstanceInfo) error {
+	current, err := man.orc.GetVolume(volume.Name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", volume.Name)
+	}
+	if current == nil {
+		return errors.Errorf("cannot find volume '%s'", volume.Name)
+	}
+	if current.Generation != volume.Generation {
+		if err := man.updateMigration(volume.Name, nil, volume.Generation-current.Generation); err != nil {
+			return err
+		}
+	}
+	// starting the container records it as the controller again
+	if _, err := man.orc.StartInstance(old); err != nil {
+		return errors.Wrapf(err, "failed to restart controller of volume '%s' on host %v", volume.Name, old.HostID)
+	}
+	return nil
+}
+
+// updateMigration records the state of the migration on the volume, and
+// adds the delta to its generation
+func (man *volumeManager) updateMigration(name string, migration *types.ControllerMigration, generationDelta int64) error {
+	if _, err := man.orc.UpdateVolumeBase(name, func(volume *types.VolumeInfo) error {
+		if migration != nil {
+			m := *migration
+			volume.Migration = &m
+		}
+		volume.Generation += generationDelta
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", name)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// newMigrationTestManager attaches the volume on host-2 and returns the
+// manager of host-1 to migrate it to
+func newMigrationTestManager(assert *require.Assertions) (*volumeManager, *fakeOrc) {
+	orc := newFakeOrc("host-2", "host-1", "host-3")
+	source, _ := newTestManager(orc)
+	_, err := source.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(source.Attach("vol"))
+
+	orc.currentHostID = "host-1"
+	man, clock := newSkewTestManager(orc)
+	heartbeats(orc, clock, nil)
+	assert.Nil(man.checkClockSkew())
+	return man, orc
+}
+
+func TestMigrateController(t *testing.T) {
+	defer checkLeaks(t)()
+	assert := require.New(t)
+
+	defer func(probe func(string) error) { probeReplica = probe }(probeReplica)
+	probed := []string{}
+	probeReplica = func(address string) error {
+		probed = append(probed, address)
+		return nil
+	}
+
+	man, orc := newMigrationTestManager(assert)
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	generation := volume.Generation
+
+	migration, err := man.MigrateController("vol")
+	assert.Nil(err)
+	assert.Equal(types.MigrationStateSucceeded, migration.State)
+	assert.Equal("host-2", migration.SourceHostID)
+	assert.Equal("host-1", migration.TargetHostID)
+	assert.NotEqual("", migration.Pause)
+	assert.Equal(MigrationPauseBudget.String(), migration.PauseBudget)
+	assert.Len(probed, 2)
+
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(types.VolumeStateHealthy, volume.State)
+	assert.Equal("host-1", volume.Controller.HostID)
+	assert.True(volume.Controller.Running)
+	// the old controller is left to the source host to remove
+	assert.Equal(generation+1, volume.Generation)
+	assert.Equal(migration, volume.Migration)
+	last := volume.AttachHistory[len(volume.AttachHistory)-1]
+	assert.Equal("host-1", last.HostID)
+	assert.Equal(AttachReasonMigrated+" from host host-2", last.Reason)
+
+	man.events.flush()
+	events, err := orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Equal(EventReasonControllerMigrated, events[len(events)-1].Reason)
+
+	_, err = man.MigrateController("vol")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "runs on host host-1 already")
+}
+
+func TestMigrateControllerChecks(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(probe func(string) error) { probeReplica = probe }(probeReplica)
+	probeReplica = func(address string) error {
+		return errors.Errorf("connection refused")
+	}
+
+	man, _ := newMigrationTestManager(assert)
+	_, err := man.MigrateController("vol")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "host host-1 cannot reach replica")
+
+	probeReplica = func(address string) error {
+		return nil
+	}
+	assert.Nil(man.UpdateHostSchedulable("host-1", false))
+	_, err = man.MigrateController("vol")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "host host-1 cannot run the controller")
+	assert.Nil(man.UpdateHostSchedulable("host-1", true))
+
+	_, err = man.MigrateController("missing")
+	assert.NotNil(err)
+
+	// nothing was touched
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	assert.Equal("host-2", volume.Controller.HostID)
+	assert.True(volume.Controller.Running)
+	assert.Nil(volume.Migration)
+}
+
+func TestMigrateControllerRollback(t *testing.T) {
+	defer checkLeaks(t)()
+	assert := require.New(t)
+
+	defer func(probe func(string) error, budget time.Duration) {
+		probeReplica = probe
+		MigrationPauseBudget = budget
+	}(probeReplica, MigrationPauseBudget)
+	probeReplica = func(address string) error {
+		return nil
+	}
+
+	man, orc := newMigrationTestManager(assert)
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	generation := volume.Generation
+	controllerID := volume.Controller.ID
+
+	// the new controller fails
+	orc.controllerErr = errors.Errorf("device not found")
+	migration, err := man.MigrateController("vol")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "device not found")
+	assert.Equal(types.MigrationStateRolledBack, migration.State)
+	assert.Contains(migration.Error, "device not found")
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(types.VolumeStateHealthy, volume.State)
+	assert.Equal("host-2", volume.Controller.HostID)
+	assert.Equal(controllerID, volume.Controller.ID)
+	assert.True(volume.Controller.Running)
+	assert.Equal(generation, volume.Generation)
+	assert.Equal(types.MigrationStateRolledBack, volume.Migration.State)
+
+	// the new controller is up too late
+	orc.controllerErr = nil
+	orc.controllerDelay = 50 * time.Millisecond
+	MigrationPauseBudget = 10 * time.Millisecond
+	migration, err = man.MigrateController("vol")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "pause budget")
+	assert.Equal(types.MigrationStateRolledBack, migration.State)
+	pause, err := time.ParseDuration(migration.Pause)
+	assert.Nil(err)
+	assert.True(pause >= 50*time.Millisecond)
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal("host-2", volume.Controller.HostID)
+	assert.True(volume.Controller.Running)
+	assert.Equal(generation, volume.Generation)
+
+	// the old controller cannot be stopped nor started again
+	orc.controllerDelay = 0
+	orc.unreachable["host-2"] = true
+	migration, err = man.MigrateController("vol")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "is to be attached again")
+	assert.Equal(types.MigrationStateFailed, migration.State)
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal("host-2", volume.Controller.HostID)
+	assert.Equal(types.MigrationStateFailed, volume.Migration.State)
+	assert.Equal(generation, volume.Generation)
+}
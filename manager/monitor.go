@@ -43,7 +43,7 @@ func Monitor(getController types.GetController) types.BeginMonitoring {
 		cleanupCh := make(chan types.Event)
 		go cleanup(volume, man, cleanupCh)
 		cronCh := make(chan types.Event)
-		go RunJobs(volume, getController(volume), man.Settings(), cronCh)
+		go RunJobs(volume, getController(volume), man, cronCh)
 		return &monitorChan{volume: volume, cronCh: cronCh, monitorCh: monitorCh, cleanupCh: cleanupCh}
 	}
 }
@@ -53,25 +53,46 @@ func monitor(ctrl types.Controller, volume *types.VolumeInfo, man types.VolumeMa
 	defer ticker.Start().Stop()
 	<-ch
 	failedAttempts := 0
+	var unresponsiveSince time.Time
 	for range ch {
+		ctrlFailed := false
 		if err := func() error {
 			defer ticker.Stop().Start()
+			if man.IsReconcilePaused() {
+				return nil
+			}
 			if err := man.CheckController(ctrl, volume); err != nil {
 				if err, ok := err.(ControllerError); ok {
+					// the controller may come back within the threshold
+					if threshold := man.ControllerRecreateThreshold(); threshold > 0 {
+						if unresponsiveSince.IsZero() {
+							unresponsiveSince = time.Now()
+						}
+						if time.Since(unresponsiveSince) < threshold {
+							logrus.Warnf("%v", errors.Wrapf(err.Cause(), "controller of volume '%s' unresponsive since %v", volume.Name, unresponsiveSince))
+							return nil
+						}
+					}
+					ctrlFailed = true
 					return errors.Wrapf(err.Cause(), "controller failed, volume '%s'", volume.Name)
 				}
+				unresponsiveSince = time.Time{}
 				if failedAttempts++; failedAttempts > MonitoringMaxRetries {
 					return errors.Wrapf(err, "repeated errors checking volume '%s', giving up", volume.Name)
 				}
 				logrus.Warnf("%v", errors.Wrapf(err, "error checking volume '%s', going to retry", volume.Name))
 				return nil
 			}
-			failedAttempts = 0
+			failedAttempts, unresponsiveSince = 0, time.Time{}
 			return nil
 		}(); err != nil {
 			close(ch)
 			logrus.Error(errors.Wrapf(err, "detaching volume"))
-			if err := man.Detach(volume.Name); err != nil {
+			if ctrlFailed {
+				if err := man.ControllerFailed(volume.Name); err != nil {
+					logrus.Errorf("%+v", errors.Wrapf(err, "error handling controller failure of volume '%s'", volume.Name))
+				}
+			} else if err := man.Detach(volume.Name); err != nil {
 				logrus.Errorf("%+v", errors.Wrapf(err, "error detaching failed volume '%s'", volume.Name))
 			}
 		}
@@ -85,6 +106,9 @@ func cleanup(volume *types.VolumeInfo, man types.VolumeManager, ch chan types.Ev
 	for range ch {
 		func() {
 			defer ticker.Stop().Start()
+			if man.IsReconcilePaused() {
+				return
+			}
 			if err := man.Cleanup(volume); err != nil {
 				logrus.Warnf("%v", errors.Wrapf(err, "error cleaning up volume '%s'", volume.Name))
 			}
@@ -0,0 +1,195 @@
+package manager
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/trace"
+)
+
+var (
+	// SlowOperationThreshold is the duration beyond which an operation is
+	// recorded as slow with its trace, 0 to never
+	SlowOperationThreshold = 30 * time.Second
+	// SlowOperationsKept is the number of the latest slow operations kept
+	SlowOperationsKept = 100
+)
+
+const (
+	EventReasonSlowOperation = "SlowOperation"
+
+	MetricOperations            = "longhorn_operations_total"
+	MetricOperationDuration     = "longhorn_operation_duration_seconds_total"
+	MetricOperationSLOBreaches  = "longhorn_operation_slo_breaches_total"
+	MetricOperationLastDuration = "longhorn_operation_last_duration_seconds"
+)
+
+// operation is an operation of the manager on a volume being traced
+type operation struct {
+	id         string
+	name       string
+	volumeName string
+	span       *trace.Span
+}
+
+// slowOperations are the latest slow operations, the oldest first
+type slowOperations struct {
+	sync.Mutex
+	ops []*types.SlowOperation
+}
+
+func (s *slowOperations) add(op *types.SlowOperation) {
+	s.Lock()
+	defer s.Unlock()
+	s.ops = append(s.ops, op)
+	if len(s.ops) > SlowOperationsKept {
+		s.ops = s.ops[len(s.ops)-SlowOperationsKept:]
+	}
+}
+
+func (s *slowOperations) list() []*types.SlowOperation {
+	s.Lock()
+	defer s.Unlock()
+	return append([]*types.SlowOperation{}, s.ops...)
+}
+
+// operationCounts are the operations of a name ended since the manager
+// started
+type operationCounts struct {
+	succeeded   int64
+	failed      int64
+	seconds     float64
+	slow        int64
+	lastSeconds float64
+}
+
+// operationStats counts the operations ended by name, for their latency and
+// the breaches of their SLO, SlowOperationThreshold
+type operationStats struct {
+	sync.Mutex
+	counts map[string]*operationCounts
+}
+
+func (s *operationStats) add(name string, duration time.Duration, failed, slow bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.counts == nil {
+		s.counts = map[string]*operationCounts{}
+	}
+	c := s.counts[name]
+	if c == nil {
+		c = &operationCounts{}
+		s.counts[name] = c
+	}
+	if failed {
+		c.failed++
+	} else {
+		c.succeeded++
+	}
+	c.seconds += duration.Seconds()
+	c.lastSeconds = duration.Seconds()
+	if slow {
+		c.slow++
+	}
+}
+
+// families are the metrics of the operations by name, none before the
+// first operation ends
+func (s *operationStats) families() []*types.MetricFamily {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.counts) == 0 {
+		return nil
+	}
+	names := []string{}
+	for name := range s.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ops := &types.MetricFamily{
+		Name: MetricOperations,
+		Help: "The operations of the manager ended since it started, by result",
+		Type: types.MetricTypeCounter,
+	}
+	duration := &types.MetricFamily{
+		Name: MetricOperationDuration,
+		Help: "The time taken by the operations of the manager since it started, over their count for the mean latency",
+		Type: types.MetricTypeCounter,
+	}
+	breaches := &types.MetricFamily{
+		Name: MetricOperationSLOBreaches,
+		Help: "The operations of the manager slower than the slow operation threshold since it started",
+		Type: types.MetricTypeCounter,
+	}
+	last := &types.MetricFamily{
+		Name: MetricOperationLastDuration,
+		Help: "The time taken by the last operation of the manager",
+		Type: types.MetricTypeGauge,
+	}
+	for _, name := range names {
+		c := s.counts[name]
+		ops.Samples = append(ops.Samples,
+			&types.MetricSample{Labels: map[string]string{"operation": name, "result": "succeeded"}, Value: float64(c.succeeded)},
+			&types.MetricSample{Labels: map[string]string{"operation": name, "result": "failed"}, Value: float64(c.failed)})
+		labels := map[string]string{"operation": name}
+		duration.Samples = append(duration.Samples, &types.MetricSample{Labels: labels, Value: c.seconds})
+		breaches.Samples = append(breaches.Samples, &types.MetricSample{Labels: labels, Value: float64(c.slow)})
+		last.Samples = append(last.Samples, &types.MetricSample{Labels: labels, Value: c.lastSeconds})
+	}
+	return []*types.MetricFamily{ops, duration, breaches, last}
+}
+
+// beginOperation starts tracing the operation on the volume, the steps
+// deeper down find the operation by the volume name
+func (man *volumeManager) beginOperation(name, volumeName string) *operation {
+	return &operation{
+		id:         util.UUID(),
+		name:       name,
+		volumeName: volumeName,
+		span:       trace.Begin(volumeName, name),
+	}
+}
+
+// endOperation finishes the operation, counts it in the metrics, and records
+// it if it was slow: it's kept for SlowOperations, logged and added to the
+// events of the volume
+func (man *volumeManager) endOperation(op *operation, err error) {
+	duration := op.span.End(err)
+	breached := SlowOperationThreshold > 0 && duration >= SlowOperationThreshold
+	man.opStats.add(op.name, duration, err != nil, breached)
+	if !breached {
+		return
+	}
+	record := op.span.Record()
+	slow := &types.SlowOperation{
+		ID:         op.id,
+		Operation:  op.name,
+		VolumeName: op.volumeName,
+		HostID:     man.orc.GetCurrentHostID(),
+		Started:    record.Start,
+		Duration:   duration,
+		Error:      record.Error,
+		Trace:      record,
+	}
+	man.slowOps.add(slow)
+	logrus.Warnf("slow operation %v: %v of volume '%s' took %v: %v", op.id, op.name, op.volumeName, duration, record)
+	slowest := record.Slowest()
+	man.events.record(op.volumeName, types.EventSeverityWarning, EventReasonSlowOperation,
+		"%v took %v, the slowest step %v took %v, operation %v", op.name, duration, slowest.Name, slowest.Duration, op.id)
+}
+
+// SlowOperations are the latest operations of the manager slower than
+// SlowOperationThreshold, the latest first
+func (man *volumeManager) SlowOperations() []*types.SlowOperation {
+	ops := man.slowOps.list()
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
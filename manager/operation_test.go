@@ -0,0 +1,99 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestSlowOperations(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(threshold time.Duration, kept int) {
+		SlowOperationThreshold = threshold
+		SlowOperationsKept = kept
+	}(SlowOperationThreshold, SlowOperationsKept)
+	SlowOperationThreshold = time.Hour
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	assert.Len(man.SlowOperations(), 0)
+	assert.Nil(man.Detach("vol"))
+
+	// every operation is slow
+	SlowOperationThreshold = time.Nanosecond
+	SlowOperationsKept = 2
+	assert.Nil(man.Attach("vol"))
+	ops := man.SlowOperations()
+	assert.Len(ops, 1)
+	op := ops[0]
+	assert.Equal("attach", op.Operation)
+	assert.Equal("vol", op.VolumeName)
+	assert.Equal("host-1", op.HostID)
+	assert.Equal("attach", op.Trace.Name)
+	steps := []string{}
+	for _, step := range op.Trace.Steps {
+		assert.False(step.Running)
+		steps = append(steps, step.Name)
+	}
+	assert.Equal([]string{"stop replicas", "start replicas", "create controller"}, steps)
+
+	man.events.flush()
+	events, err := orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	found := false
+	for _, e := range events {
+		if e.Reason == EventReasonSlowOperation {
+			assert.Contains(e.Message, op.ID)
+			found = true
+		}
+	}
+	assert.True(found)
+
+	// the latest first, only the latest kept
+	_, err = man.Create(&types.VolumeInfo{Name: "vol-2", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol-2"))
+	ops = man.SlowOperations()
+	assert.Len(ops, 2)
+	assert.Equal("attach", ops[0].Operation)
+	assert.Equal("vol-2", ops[0].VolumeName)
+	assert.Equal("create", ops[1].Operation)
+	assert.Equal("schedule replica 2", ops[1].Trace.Steps[2].Name)
+
+	// the attaches are counted, the last ones breached the SLO
+	assert.Nil(man.Detach("vol"))
+	orc.Lock()
+	orc.controllerErr = errors.Errorf("cannot create controller")
+	orc.Unlock()
+	assert.NotNil(man.Attach("vol"))
+	families := map[string]*types.MetricFamily{}
+	for _, f := range man.Metrics() {
+		families[f.Name] = f
+	}
+	samples := map[string]float64{}
+	for _, name := range []string{MetricOperations, MetricOperationSLOBreaches} {
+		assert.Equal(types.MetricTypeCounter, families[name].Type)
+		for _, sample := range families[name].Samples {
+			if sample.Labels["operation"] == "attach" {
+				samples[name+sample.Labels["result"]] = sample.Value
+			}
+		}
+	}
+	assert.Equal(map[string]float64{
+		MetricOperations + "succeeded": 3,
+		MetricOperations + "failed":    1,
+		MetricOperationSLOBreaches:     3,
+	}, samples)
+	for _, sample := range families[MetricOperationDuration].Samples {
+		assert.True(sample.Value > 0, sample.Labels["operation"])
+	}
+}
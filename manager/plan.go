@@ -0,0 +1,243 @@
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/scheduler"
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// ReplicaPlanReservation is how long the hosts planned for the replicas
+	// of a volume created with ScheduleOnCreate keep its size reserved, if
+	// the volume is not attached in the meantime
+	ReplicaPlanReservation = 24 * time.Hour
+)
+
+const (
+	EventReasonPlanned     = "Planned"
+	EventReasonPlanApplied = "PlanApplied"
+	EventReasonPlanDeviate = "PlanDeviated"
+)
+
+// reserving is true if the plan still holds the volume size on the planned
+// hosts
+func reserving(plan *types.ReplicaPlan, now time.Time) bool {
+	if !plan.Pending() {
+		return false
+	}
+	expires, err := util.ParseTimeZ(plan.Expires)
+	return err == nil && now.Before(expires)
+}
+
+func replicaPolicy(volume *types.VolumeInfo) *types.SchedulePolicy {
+	if volume.Mode == types.VolumeModeLocal {
+		return &types.SchedulePolicy{
+			Binding:   types.SchedulePolicyBindingHost,
+			HostIDMap: map[string]struct{}{volume.PreferredHostID: {}},
+		}
+	}
+	return &types.SchedulePolicy{
+		Binding:   types.SchedulePolicyBindingSoftAntiAffinity,
+		HostIDMap: map[string]struct{}{},
+
+		Replicas:           volume.NumberOfReplicas,
+		MaxReplicasPerZone: volume.MaxReplicasPerZone,
+		MinZones:           volume.MinZones,
+	}
+}
+
+// eligibleHosts splits the hosts with their detail into the schedulable
+// ones and the others, and returns the bytes the schedulable hosts have
+// left, less the replicas and the plans reserving, other than the plan of
+// the volume itself
+func (man *volumeManager) eligibleHosts(volume *types.VolumeInfo) (map[string]*types.HostInfo, map[string]*types.HostInfo, map[string]int64, error) {
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "unable to list hosts")
+	}
+	if err := man.HostDetails(hosts); err != nil {
+		return nil, nil, nil, err
+	}
+	own := map[string]int64{}
+	if reserving(volume.ReplicaPlan, time.Now()) {
+		for _, r := range volume.ReplicaPlan.Replicas {
+			own[r.HostID] += volume.Size
+		}
+	}
+	eligible := map[string]*types.HostInfo{}
+	ineligible := map[string]*types.HostInfo{}
+	available := map[string]int64{}
+	for id, host := range hosts {
+		if !host.Detail.Schedulable {
+			ineligible[id] = host
+			continue
+		}
+		eligible[id] = host
+		if host.StorageTotal > 0 {
+			available[id] = host.StorageTotal - host.Detail.ReservedBytes + own[id]
+		}
+	}
+	return eligible, ineligible, available, nil
+}
+
+// planReplicas places all the replicas of the volume, with a fresh
+// reservation
+func (man *volumeManager) planReplicas(volume *types.VolumeInfo) (*types.ReplicaPlan, error) {
+	hosts, ineligible, available, err := man.eligibleHosts(volume)
+	if err != nil {
+		return nil, err
+	}
+	hostIDs, err := scheduler.PlanReplicas(hosts, replicaPolicy(volume), volume.NumberOfReplicas, volume.Size, available)
+	if err != nil {
+		addIneligibleHosts(err, ineligible)
+		return nil, errors.Wrapf(err, "unable to plan the replicas of volume '%s'", volume.Name)
+	}
+	now := time.Now()
+	plan := &types.ReplicaPlan{
+		Replicas: []*types.PlannedReplica{},
+		Planned:  util.FormatTimeZ(now),
+		Expires:  util.FormatTimeZ(now.Add(ReplicaPlanReservation)),
+	}
+	for i, id := range hostIDs {
+		name := man.GetReplicaName(volume.Name)
+		if volume.ReplicaPlan != nil && i < len(volume.ReplicaPlan.Replicas) {
+			name = volume.ReplicaPlan.Replicas[i].Name
+		}
+		plan.Replicas = append(plan.Replicas, &types.PlannedReplica{Name: name, HostID: id})
+	}
+	return plan, nil
+}
+
+func planHosts(plan *types.ReplicaPlan) []string {
+	hostIDs := []string{}
+	for _, r := range plan.Replicas {
+		hostIDs = append(hostIDs, r.HostID)
+	}
+	return hostIDs
+}
+
+// createPlanned creates the volume with its replicas planned, and no
+// replica created
+func (man *volumeManager) createPlanned(volume *types.VolumeInfo) (*types.VolumeInfo, error) {
+	man.planning.Lock()
+	defer man.planning.Unlock()
+	plan, err := man.planReplicas(volume)
+	if err != nil {
+		return nil, errors.Wrap(err, "create volume fail")
+	}
+	volume.ReplicaPlan = plan
+	if _, err := man.orc.CreateVolume(volume); err != nil {
+		return nil, errors.Wrapf(err, "failed to create volume '%s'", volume.Name)
+	}
+	man.events.record(volume.Name, types.EventSeverityInfo, EventReasonPlanned, "planned %v replicas on hosts %v", len(plan.Replicas), planHosts(plan))
+	return man.Get(volume.Name)
+}
+
+// ReplanReplicas places the replicas of a volume not attached since it was
+// created with ScheduleOnCreate again, for the hosts as they are now
+func (man *volumeManager) ReplanReplicas(name string) error {
+	man.planning.Lock()
+	defer man.planning.Unlock()
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	if !volume.ReplicaPlan.Pending() {
+		return errors.Errorf("volume '%s' has no replica plan pending", name)
+	}
+	plan, err := man.planReplicas(volume)
+	if man.setScheduledCondition(volume, err) && err != nil {
+		if err := man.orc.UpdateVolume(volume); err != nil {
+			logrus.Warnf("%v", errors.Wrapf(err, "unable to update scheduled condition of volume '%s'", name))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	before := planHosts(volume.ReplicaPlan)
+	volume.ReplicaPlan = plan
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", name)
+	}
+	man.events.record(name, types.EventSeverityInfo, EventReasonPlanned, "replanned replicas on hosts %v, were %v", planHosts(plan), before)
+	logrus.Infof("volume '%s' replicas replanned on hosts %v", name, planHosts(plan))
+	return nil
+}
+
+// applyPlan creates the replicas of the volume where planned. The replicas
+// planned on hosts no longer eligible go elsewhere, and the deviations are
+// recorded in the plan.
+func (man *volumeManager) applyPlan(volume *types.VolumeInfo) (*types.VolumeInfo, error) {
+	man.planning.Lock()
+	defer man.planning.Unlock()
+	plan := volume.ReplicaPlan
+	hosts, ineligible, available, err := man.eligibleHosts(volume)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to apply the replica plan of volume '%s'", volume.Name)
+	}
+
+	policy := replicaPolicy(volume)
+	moved := []*types.PlannedReplica{}
+	for _, r := range plan.Replicas {
+		if _, ok := hosts[r.HostID]; ok {
+			policy.HostIDMap[r.HostID] = struct{}{}
+		} else {
+			moved = append(moved, r)
+		}
+	}
+	if len(moved) > 0 {
+		hostIDs, err := scheduler.PlanReplicas(hosts, policy, len(moved), volume.Size, available)
+		if err != nil {
+			addIneligibleHosts(err, ineligible)
+			man.updateScheduledCondition(volume.Name, err)
+			return nil, errors.Wrapf(err, "unable to replan the replicas of volume '%s'", volume.Name)
+		}
+		now := util.FormatTimeZ(time.Now())
+		for i, r := range moved {
+			reason := "host not found"
+			if host := ineligible[r.HostID]; host != nil {
+				reason = fmt.Sprintf("host %v", host.Detail.UnschedulableReasons[0])
+			}
+			plan.Deviations = append(plan.Deviations, &types.PlanDeviation{
+				Replica:       r.Name,
+				PlannedHostID: r.HostID,
+				HostID:        hostIDs[i],
+				Reason:        reason,
+				Time:          now,
+			})
+			man.events.record(volume.Name, types.EventSeverityWarning, EventReasonPlanDeviate,
+				"replica %v planned on host %v goes to host %v: %v", r.Name, r.HostID, hostIDs[i], reason)
+			r.HostID = hostIDs[i]
+		}
+		if err := man.orc.UpdateVolume(volume); err != nil {
+			return nil, errors.Wrapf(err, "unable to update volume '%s'", volume.Name)
+		}
+	}
+
+	for _, r := range plan.Replicas {
+		if volume.Replicas[r.Name] != nil {
+			continue
+		}
+		if _, err := man.orc.CreateReplica(volume.Name, r.Name); err != nil {
+			man.updateScheduledCondition(volume.Name, err)
+			return nil, errors.Wrapf(err, "error creating planned replica '%s' on host %v, volume '%s'", r.Name, r.HostID, volume.Name)
+		}
+	}
+	man.setScheduledCondition(volume, nil)
+	plan.Applied = util.Now()
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return nil, errors.Wrapf(err, "unable to update volume '%s'", volume.Name)
+	}
+	man.events.record(volume.Name, types.EventSeverityInfo, EventReasonPlanApplied,
+		"created replicas on hosts %v, %v deviated from the plan", planHosts(plan), len(moved))
+	return man.Get(volume.Name)
+}
@@ -0,0 +1,170 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/scheduler"
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+func replicaHosts(volume *types.VolumeInfo) []string {
+	hostIDs := []string{}
+	for _, r := range volume.Replicas {
+		hostIDs = append(hostIDs, r.HostID)
+	}
+	sort.Strings(hostIDs)
+	return hostIDs
+}
+
+func TestScheduleOnCreate(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	for id, total := range map[string]int64{"host-1": 10000, "host-2": 20000, "host-3": 30000} {
+		orc.hosts[id].StorageTotal = total
+	}
+	man, clock := newSkewTestManager(orc)
+	heartbeats(orc, clock, nil)
+	assert.Nil(man.checkClockSkew())
+
+	// the hosts with the most space left, and nothing created
+	volume, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 8000, NumberOfReplicas: 2, ScheduleOnCreate: true})
+	assert.Nil(err)
+	assert.Len(volume.Replicas, 0)
+	assert.Equal(types.VolumeStateDetached, volume.State)
+	assert.True(volume.ReplicaPlan.Pending())
+	assert.Equal([]string{"host-3", "host-2"}, planHosts(volume.ReplicaPlan))
+
+	// the plan reserves the space, which the next plans see
+	hosts, err := man.ListHosts()
+	assert.Nil(err)
+	assert.Nil(man.HostDetails(hosts))
+	assert.Equal(int64(8000), hosts["host-3"].Detail.ReservedBytes)
+	assert.Equal(int64(0), hosts["host-1"].Detail.ReservedBytes)
+	other, err := man.Create(&types.VolumeInfo{Name: "other", Size: 15000, NumberOfReplicas: 1, ScheduleOnCreate: true})
+	assert.Nil(err)
+	assert.Equal([]string{"host-3"}, planHosts(other.ReplicaPlan))
+
+	// failing to place the replicas fails the create
+	_, err = man.Create(&types.VolumeInfo{Name: "big", Size: 12000, NumberOfReplicas: 2, ScheduleOnCreate: true})
+	assert.NotNil(err)
+	big, err := man.Get("big")
+	assert.Nil(err)
+	assert.Nil(big)
+
+	// replanning keeps the replica names, not the hosts no longer eligible
+	assert.Nil(man.Delete("other"))
+	assert.Nil(man.UpdateHostSchedulable("host-3", false))
+	assert.Nil(man.ReplanReplicas("vol"))
+	replanned, err := man.Get("vol")
+	assert.Nil(err)
+	assert.Equal([]string{"host-2", "host-1"}, planHosts(replanned.ReplicaPlan))
+	assert.Equal(volume.ReplicaPlan.Replicas[0].Name, replanned.ReplicaPlan.Replicas[0].Name)
+
+	// attach follows the plan, but for the host cordoned since
+	assert.Nil(man.UpdateHostSchedulable("host-3", true))
+	assert.Nil(man.UpdateHostSchedulable("host-1", false))
+	assert.Nil(man.Attach("vol"))
+	attached, err := man.Get("vol")
+	assert.Nil(err)
+	assert.Equal([]string{"host-2", "host-3"}, replicaHosts(attached))
+	assert.NotNil(attached.Controller)
+	assert.False(attached.ReplicaPlan.Pending())
+	assert.Len(attached.ReplicaPlan.Deviations, 1)
+	deviation := attached.ReplicaPlan.Deviations[0]
+	assert.Equal("host-1", deviation.PlannedHostID)
+	assert.Equal("host-3", deviation.HostID)
+	assert.Equal("host cordoned", deviation.Reason)
+	assert.NotNil(man.ReplanReplicas("vol"))
+
+	// the plan applied no longer reserves over the replicas
+	hosts, err = man.ListHosts()
+	assert.Nil(err)
+	assert.Nil(man.HostDetails(hosts))
+	assert.Equal(int64(8000), hosts["host-2"].Detail.ReservedBytes)
+	assert.Equal(int64(8000), hosts["host-3"].Detail.ReservedBytes)
+
+	// the reservation expires
+	reservation := ReplicaPlanReservation
+	defer func() { ReplicaPlanReservation = reservation }()
+	ReplicaPlanReservation = 0
+	_, err = man.Create(&types.VolumeInfo{Name: "expired", Size: 1000, NumberOfReplicas: 1, ScheduleOnCreate: true})
+	assert.Nil(err)
+	hosts, err = man.ListHosts()
+	assert.Nil(err)
+	assert.Nil(man.HostDetails(hosts))
+	assert.Equal(int64(8000), hosts["host-3"].Detail.ReservedBytes)
+	assert.Equal(int64(8000), hosts["host-2"].Detail.ReservedBytes)
+	assert.Equal(int64(0), hosts["host-1"].Detail.ReservedBytes)
+}
+
+func TestPlanSkipsComputeHosts(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	for id, total := range map[string]int64{"host-1": 10000, "host-2": 20000, "host-3": 30000} {
+		orc.hosts[id].StorageTotal = total
+	}
+	man, clock := newSkewTestManager(orc)
+	heartbeats(orc, clock, nil)
+	assert.Nil(man.checkClockSkew())
+
+	// the host with the most space is reserved for compute
+	assert.Nil(man.UpdateHostRole("host-3", types.HostRoleCompute))
+	assert.NotNil(man.UpdateHostRole("host-1", types.HostRole("backup")))
+	volume, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 8000, NumberOfReplicas: 2, ScheduleOnCreate: true})
+	assert.Nil(err)
+	assert.Equal([]string{"host-2", "host-1"}, planHosts(volume.ReplicaPlan))
+
+	hosts, err := man.ListHosts()
+	assert.Nil(err)
+	assert.Nil(man.HostDetails(hosts))
+	assert.False(hosts["host-3"].Detail.Schedulable)
+	assert.Equal([]types.HostCondition{types.HostConditionComputeOnly}, hosts["host-3"].Detail.UnschedulableReasons)
+
+	// no room for a third replica without the compute host
+	_, err = man.Create(&types.VolumeInfo{Name: "other", Size: 8000, NumberOfReplicas: 3, ScheduleOnCreate: true})
+	assert.NotNil(err)
+}
+
+func TestPlanSkipsRecoveringHosts(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, clock := newSkewTestManager(orc)
+	heartbeats(orc, clock, nil)
+	assert.Nil(man.checkClockSkew())
+
+	// host-3 came back a minute ago
+	orc.hosts["host-3"].Recovered = util.FormatTimeZ(clock.now.Add(-time.Minute))
+	host, err := man.GetHost("host-3")
+	assert.Nil(err)
+	assert.Equal(scheduler.HostRecoveryCooldown-time.Minute, host.RecoveryCooldown)
+	hosts, err := man.ListHosts()
+	assert.Nil(err)
+	assert.Nil(man.HostDetails(hosts))
+	assert.Equal([]types.HostCondition{types.HostConditionRecovering}, hosts["host-3"].Detail.UnschedulableReasons)
+
+	for i := 0; i < 3; i++ {
+		volume, err := man.Create(&types.VolumeInfo{Name: fmt.Sprintf("vol-%v", i), Size: 4096, NumberOfReplicas: 3, ScheduleOnCreate: true})
+		assert.Nil(err)
+		assert.NotContains(planHosts(volume.ReplicaPlan), "host-3")
+	}
+
+	// placed there again once the cooldown elapsed
+	clock.now = clock.now.Add(scheduler.HostRecoveryCooldown)
+	heartbeats(orc, clock, nil)
+	assert.Nil(man.checkClockSkew())
+	host, err = man.GetHost("host-3")
+	assert.Nil(err)
+	assert.Equal(time.Duration(0), host.RecoveryCooldown)
+	volume, err := man.Create(&types.VolumeInfo{Name: "wide", Size: 4096, NumberOfReplicas: 3, ScheduleOnCreate: true})
+	assert.Nil(err)
+	assert.Contains(planHosts(volume.ReplicaPlan), "host-3")
+}
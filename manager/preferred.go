@@ -0,0 +1,74 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// attachHostUnavailable is why the host cannot take the controller of a
+// volume, "" if it can
+func attachHostUnavailable(host *types.HostInfo) string {
+	switch {
+	case host == nil:
+		return "not found"
+	case !host.Role.RunsControllers():
+		return "storage only"
+	case !host.Detail.Online:
+		return string(types.HostConditionOffline)
+	case host.Unschedulable:
+		return string(types.HostConditionCordoned)
+	case host.Conflicted:
+		return string(types.HostConditionConflicted)
+	}
+	return ""
+}
+
+// PickAttachHost returns the first of the preferred hosts the controller of
+// the volume can run on, "" if none can and the volume is to be attached
+// where it would be without preferences
+func (man *volumeManager) PickAttachHost(name string, preferredHostIDs []string) (string, error) {
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to list hosts")
+	}
+	if err := man.HostDetails(hosts); err != nil {
+		return "", err
+	}
+	skipped := []string{}
+	for _, id := range preferredHostIDs {
+		reason := attachHostUnavailable(hosts[id])
+		if reason == "" {
+			if len(skipped) != 0 {
+				logrus.Infof("attaching volume '%s' to preferred host %v, skipped %v", name, id, strings.Join(skipped, ", "))
+			}
+			return id, nil
+		}
+		skipped = append(skipped, fmt.Sprintf("%v (%v)", id, reason))
+	}
+	logrus.Warnf("none of the preferred hosts of volume '%s' can attach it: %v", name, strings.Join(skipped, ", "))
+	return "", nil
+}
+
+// AttachPreferred attaches the volume on the current host, picked among the
+// preferred hosts by PickAttachHost. The attach history records which of
+// them it is.
+func (man *volumeManager) AttachPreferred(name string, env map[string]string, preferredHostIDs []string) error {
+	if len(preferredHostIDs) == 0 {
+		return man.AttachWithEnv(name, env)
+	}
+	return man.attachWithEnv(name, env, preferredAttachReason(preferredHostIDs, man.orc.GetCurrentHostID()))
+}
+
+func preferredAttachReason(preferredHostIDs []string, hostID string) string {
+	for i, id := range preferredHostIDs {
+		if id == hostID {
+			return fmt.Sprintf("%v on preferred host %v of %v", AttachReasonRequested, i+1, len(preferredHostIDs))
+		}
+	}
+	return AttachReasonRequested + ", none of the preferred hosts available"
+}
@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+func TestPickAttachHost(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, clock := newSkewTestManager(orc)
+
+	heartbeats(orc, clock, nil)
+	assert.Nil(man.checkClockSkew())
+	// host-3 stops sending heartbeats
+	for i := 0; i < 4; i++ {
+		clock.now = clock.now.Add(HostHeartbeatPeriod)
+		orc.Lock()
+		orc.hosts["host-2"].Heartbeat = util.FormatTimeZ(clock.now)
+		orc.Unlock()
+		assert.Nil(man.checkClockSkew())
+	}
+
+	hostID, err := man.PickAttachHost("vol", []string{"host-1", "host-2"})
+	assert.Nil(err)
+	assert.Equal("host-1", hostID)
+
+	// the second is used when the first is unschedulable
+	assert.Nil(man.UpdateHostSchedulable("host-1", false))
+	hostID, err = man.PickAttachHost("vol", []string{"host-1", "host-2"})
+	assert.Nil(err)
+	assert.Equal("host-2", hostID)
+
+	// offline, storage only or gone hosts are skipped
+	assert.Nil(man.UpdateHostRole("host-2", types.HostRoleStorage))
+	hostID, err = man.PickAttachHost("vol", []string{"gone", "host-2", "host-3"})
+	assert.Nil(err)
+	assert.Equal("", hostID)
+
+	assert.Nil(man.UpdateHostSchedulable("host-1", true))
+	hostID, err = man.PickAttachHost("vol", []string{"host-3", "host-1"})
+	assert.Nil(err)
+	assert.Equal("host-1", hostID)
+}
+
+func TestAttachPreferred(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.AttachPreferred("vol", nil, []string{"host-2", "host-1"}))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	assert.Equal("host-1", volume.Controller.HostID)
+	assert.Len(volume.AttachHistory, 1)
+	assert.Equal("requested on preferred host 2 of 2", volume.AttachHistory[0].Reason)
+
+	assert.Nil(man.Detach("vol"))
+	assert.Nil(man.AttachPreferred("vol", nil, []string{"host-2"}))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal("requested, none of the preferred hosts available", volume.AttachHistory[1].Reason)
+}
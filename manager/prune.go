@@ -0,0 +1,228 @@
+package manager
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/backups"
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+const (
+	// snapshots labeled protected=true are never pruned
+	SnapshotProtectedLabel = "protected"
+
+	volumeHeadName = "volume-head"
+)
+
+var (
+	SnapshotAgePruneSchedule = "@hourly"
+)
+
+type snapshotLimits struct {
+	maxCount int
+	maxAge   time.Duration
+	strategy types.SnapshotPruneStrategy
+}
+
+// snapshotPruner deletes the snapshots exceeding the count and age limits of
+// the volume, or the cluster defaults if the volume has none
+type snapshotPruner struct {
+	volume     *types.VolumeInfo
+	ops        types.SnapshotOps
+	settings   types.Settings
+	getBackups types.GetManagerBackupOps
+}
+
+func newSnapshotPruner(volume *types.VolumeInfo, ops types.SnapshotOps, settings types.Settings, getBackups types.GetManagerBackupOps) *snapshotPruner {
+	return &snapshotPruner{
+		volume:     volume,
+		ops:        ops,
+		settings:   settings,
+		getBackups: getBackups,
+	}
+}
+
+func (p *snapshotPruner) limits() (*snapshotLimits, error) {
+	si, err := p.settings.GetSettings()
+	if err != nil || si == nil {
+		return nil, errors.Wrap(err, "unable to get settings")
+	}
+	limits := &snapshotLimits{
+		maxCount: p.volume.SnapshotMaxCount,
+		maxAge:   p.volume.SnapshotMaxAge,
+		strategy: si.SnapshotPruneStrategy,
+	}
+	if limits.maxCount == 0 {
+		limits.maxCount = si.SnapshotMaxCount
+	}
+	if limits.maxAge == 0 && si.SnapshotMaxAge != "" {
+		if limits.maxAge, err = time.ParseDuration(si.SnapshotMaxAge); err != nil {
+			return nil, errors.Wrapf(err, "invalid snapshotMaxAge setting")
+		}
+	}
+	if limits.strategy == types.SnapshotPruneDefault {
+		limits.strategy = types.SnapshotPruneAfterCreate
+	}
+	return limits, nil
+}
+
+// candidates returns the snapshots counted against the limits, oldest first,
+// and which of them can be pruned
+func (p *snapshotPruner) candidates() ([]*types.SnapshotInfo, map[string]bool, error) {
+	ss, err := p.ops.List()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error listing snapshots, volume '%s'", p.volume.Name)
+	}
+	backedUp, err := p.backedUpSnapshots()
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshots := []*types.SnapshotInfo{}
+	prunable := map[string]bool{}
+	for _, s := range ss {
+		if s.Removed || s.Name == volumeHeadName {
+			continue
+		}
+		snapshots = append(snapshots, s)
+		if s.Labels[SnapshotProtectedLabel] != "true" && !backedUp[s.Name] {
+			prunable[s.Name] = true
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Created < snapshots[j].Created })
+	return snapshots, prunable, nil
+}
+
+func (p *snapshotPruner) backedUpSnapshots() (map[string]bool, error) {
+	backedUp := map[string]bool{}
+	si, err := p.settings.GetSettings()
+	if err != nil || si == nil {
+		return nil, errors.Wrap(err, "unable to get settings")
+	}
+	if si.BackupTarget == "" {
+		return backedUp, nil
+	}
+	backupTarget, err := backups.ResolveTarget(si.BackupTarget, p.volume)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := p.getBackups(backupTarget).List(p.volume.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing backups, volume '%s'", p.volume.Name)
+	}
+	for _, b := range bs {
+		backedUp[b.SnapshotName] = true
+	}
+	return backedUp, nil
+}
+
+func (p *snapshotPruner) delete(toRm []*types.SnapshotInfo, policy string) error {
+	if len(toRm) == 0 {
+		return nil
+	}
+	for _, s := range toRm {
+		if err := p.ops.Delete(s.Name); err != nil {
+			return errors.Wrapf(err, "error pruning snapshot '%s', volume '%s'", s.Name, p.volume.Name)
+		}
+		logrus.Warnf("snapshot pruning: deleted snapshot '%s' created %v, volume '%s', policy %s",
+			s.Name, s.Created, p.volume.Name, policy)
+	}
+	if err := p.ops.Purge(); err != nil {
+		return errors.Wrapf(err, "fail to purge snapshots after pruning volume '%s'", p.volume.Name)
+	}
+	return nil
+}
+
+// pruneCount deletes the oldest prunable snapshots until at most max are left
+func (p *snapshotPruner) pruneCount(max, maxCount int) error {
+	snapshots, prunable, err := p.candidates()
+	if err != nil {
+		return err
+	}
+	toRm := []*types.SnapshotInfo{}
+	excess := len(snapshots) - max
+	for _, s := range snapshots {
+		if excess <= 0 {
+			break
+		}
+		if prunable[s.Name] {
+			toRm = append(toRm, s)
+			excess--
+		}
+	}
+	if excess > 0 {
+		logrus.Warnf("snapshot pruning: volume '%s' exceeds snapshotMaxCount %v, remaining snapshots are protected or backed up",
+			p.volume.Name, maxCount)
+	}
+	return p.delete(toRm, "snapshotMaxCount="+strconv.Itoa(maxCount))
+}
+
+// PruneAge deletes the prunable snapshots older than the age limit
+func (p *snapshotPruner) PruneAge() error {
+	limits, err := p.limits()
+	if err != nil {
+		return err
+	}
+	if limits.maxAge == 0 {
+		return nil
+	}
+	snapshots, prunable, err := p.candidates()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(-limits.maxAge)
+	toRm := []*types.SnapshotInfo{}
+	for _, s := range snapshots {
+		created, err := util.ParseTime(s.Created)
+		if err != nil {
+			logrus.Warnf("%v", errors.Wrapf(err, "snapshot pruning: skipping snapshot '%s', volume '%s'", s.Name, p.volume.Name))
+			continue
+		}
+		if prunable[s.Name] && created.Before(deadline) {
+			toRm = append(toRm, s)
+		}
+	}
+	return p.delete(toRm, "snapshotMaxAge="+limits.maxAge.String())
+}
+
+// prunedSnapshotOps keeps the snapshot count of the volume within the limit
+// when creating snapshots
+type prunedSnapshotOps struct {
+	types.SnapshotOps
+
+	pruner *snapshotPruner
+}
+
+func (ops *prunedSnapshotOps) Create(name string, labels map[string]string) (string, error) {
+	limits, err := ops.pruner.limits()
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to get snapshot limits, volume '%s'", ops.pruner.volume.Name)
+	}
+	if limits.maxCount > 0 && limits.strategy == types.SnapshotPruneBeforeCreate {
+		if err := ops.pruner.pruneCount(limits.maxCount-1, limits.maxCount); err != nil {
+			return "", err
+		}
+	}
+	snapName, err := ops.SnapshotOps.Create(name, labels)
+	if err != nil {
+		return "", err
+	}
+	if limits.maxCount > 0 && limits.strategy == types.SnapshotPruneAfterCreate {
+		if err := ops.pruner.pruneCount(limits.maxCount, limits.maxCount); err != nil {
+			logrus.Errorf("%+v", err)
+		}
+	}
+	return snapName, nil
+}
+
+func newPrunedSnapshotOps(volume *types.VolumeInfo, ops types.SnapshotOps, settings types.Settings, getBackups types.GetManagerBackupOps) types.SnapshotOps {
+	return &prunedSnapshotOps{
+		SnapshotOps: ops,
+		pruner:      newSnapshotPruner(volume, ops, settings, getBackups),
+	}
+}
@@ -0,0 +1,188 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+type fakeSnapshotOps struct {
+	snapshots map[string]*types.SnapshotInfo
+	next      time.Time
+	purged    int
+}
+
+func newFakeSnapshotOps(start time.Time) *fakeSnapshotOps {
+	return &fakeSnapshotOps{
+		snapshots: map[string]*types.SnapshotInfo{
+			volumeHeadName: {Name: volumeHeadName, Created: util.FormatTimeZ(start)},
+		},
+		next: start,
+	}
+}
+
+func (f *fakeSnapshotOps) Create(name string, labels map[string]string) (string, error) {
+	f.next = f.next.Add(time.Hour)
+	f.snapshots[name] = &types.SnapshotInfo{Name: name, Created: util.FormatTimeZ(f.next), Labels: labels}
+	return name, nil
+}
+
+func (f *fakeSnapshotOps) List() ([]*types.SnapshotInfo, error) {
+	ss := []*types.SnapshotInfo{}
+	for _, s := range f.snapshots {
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+func (f *fakeSnapshotOps) Get(name string) (*types.SnapshotInfo, error) {
+	return f.snapshots[name], nil
+}
+
+func (f *fakeSnapshotOps) Delete(name string) error {
+	s := f.snapshots[name]
+	if s == nil {
+		return errors.Errorf("cannot find snapshot %v", name)
+	}
+	s.Removed = true
+	return nil
+}
+
+func (f *fakeSnapshotOps) Revert(name string) error {
+	return nil
+}
+
+func (f *fakeSnapshotOps) Purge() error {
+	f.purged++
+	for name, s := range f.snapshots {
+		if s.Removed {
+			delete(f.snapshots, name)
+		}
+	}
+	return nil
+}
+
+func (f *fakeSnapshotOps) names() []string {
+	names := []string{}
+	for name := range f.snapshots {
+		if name != volumeHeadName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type fakeBackupOps struct {
+	backups        []*types.BackupInfo
+	deleted        []string
+	deletedVolumes []string
+}
+
+func (f *fakeBackupOps) List(volumeName string) ([]*types.BackupInfo, error) {
+	return f.backups, nil
+}
+
+func (f *fakeBackupOps) Get(url string) (*types.BackupInfo, error) {
+	return nil, nil
+}
+
+func (f *fakeBackupOps) Delete(url string) error {
+	f.deleted = append(f.deleted, url)
+	return nil
+}
+
+func (f *fakeBackupOps) ListVolumes() ([]*types.BackupVolumeInfo, error) {
+	return nil, nil
+}
+
+func (f *fakeBackupOps) GetVolume(volumeName string) (*types.BackupVolumeInfo, error) {
+	return nil, nil
+}
+
+func (f *fakeBackupOps) DeleteVolume(volumeName string) error {
+	f.deletedVolumes = append(f.deletedVolumes, volumeName)
+	return nil
+}
+
+func newTestPrunedOps(volume *types.VolumeInfo, settings *types.SettingsInfo, backups []*types.BackupInfo, start time.Time) (types.SnapshotOps, *fakeSnapshotOps) {
+	orc := newFakeOrc("host-1")
+	orc.settings = settings
+	ops := newFakeSnapshotOps(start)
+	getBackups := func(backupTarget string) types.ManagerBackupOps {
+		return &fakeBackupOps{backups: backups}
+	}
+	return newPrunedSnapshotOps(volume, ops, orc, getBackups), ops
+}
+
+func TestSnapshotPruneCount(t *testing.T) {
+	assert := require.New(t)
+
+	volume := &types.VolumeInfo{Name: "vol", SnapshotMaxCount: 3}
+	settings := &types.SettingsInfo{
+		BackupTarget:     "s3://backups",
+		SnapshotMaxCount: 10,
+	}
+	backups := []*types.BackupInfo{{SnapshotName: "snap-1"}}
+	prunedOps, ops := newTestPrunedOps(volume, settings, backups, time.Now())
+
+	_, err := prunedOps.Create("snap-0", map[string]string{SnapshotProtectedLabel: "true"})
+	assert.Nil(err)
+	for i := 1; i < 5; i++ {
+		_, err := prunedOps.Create(fmt.Sprintf("snap-%v", i), nil)
+		assert.Nil(err)
+	}
+
+	// the protected and the backed up snapshots are kept
+	assert.Equal([]string{"snap-0", "snap-1", "snap-4"}, ops.names())
+	assert.Equal(2, ops.purged)
+}
+
+func TestSnapshotPruneBeforeCreate(t *testing.T) {
+	assert := require.New(t)
+
+	volume := &types.VolumeInfo{Name: "vol"}
+	settings := &types.SettingsInfo{
+		SnapshotMaxCount:      2,
+		SnapshotPruneStrategy: types.SnapshotPruneBeforeCreate,
+	}
+	prunedOps, ops := newTestPrunedOps(volume, settings, nil, time.Now())
+
+	for i := 0; i < 3; i++ {
+		_, err := prunedOps.Create(fmt.Sprintf("snap-%v", i), nil)
+		assert.Nil(err)
+		assert.True(len(ops.names()) <= 2)
+	}
+	assert.Equal([]string{"snap-1", "snap-2"}, ops.names())
+}
+
+func TestSnapshotPruneAge(t *testing.T) {
+	assert := require.New(t)
+
+	volume := &types.VolumeInfo{Name: "vol", SnapshotMaxAge: 150 * time.Minute}
+	settings := &types.SettingsInfo{
+		BackupTarget:   "s3://backups",
+		SnapshotMaxAge: "1h",
+	}
+	backups := []*types.BackupInfo{{SnapshotName: "snap-1"}}
+	// snap-i is created i+1 hours after start, the last one an hour ago
+	prunedOps, ops := newTestPrunedOps(volume, settings, backups, time.Now().Add(-6*time.Hour))
+	for i := 0; i < 5; i++ {
+		labels := map[string]string{}
+		if i == 2 {
+			labels[SnapshotProtectedLabel] = "true"
+		}
+		_, err := prunedOps.Create(fmt.Sprintf("snap-%v", i), labels)
+		assert.Nil(err)
+	}
+
+	assert.Nil(prunedOps.(*prunedSnapshotOps).pruner.PruneAge())
+	assert.Equal([]string{"snap-1", "snap-2", "snap-3", "snap-4"}, ops.names())
+}
@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+var (
+	ReplicaQuotaCheckPeriod = time.Minute * 5
+
+	// DefaultReplicaQuotaOverhead is used if the replicaQuotaOverhead
+	// setting is 0
+	DefaultReplicaQuotaOverhead = 100
+)
+
+// replicaQuotaOverhead returns the percentage of the volume size a replica
+// may allocate on top of it, or -1 if the quota is disabled
+func (man *volumeManager) replicaQuotaOverhead() (int, error) {
+	settings, err := man.settings.GetSettings()
+	if err != nil || settings == nil {
+		return 0, errors.Wrap(err, "unable to read settings")
+	}
+	if !settings.ReplicaQuota {
+		return -1, nil
+	}
+	if settings.ReplicaQuotaOverhead == 0 {
+		return DefaultReplicaQuotaOverhead, nil
+	}
+	return settings.ReplicaQuotaOverhead, nil
+}
+
+// checkReplicaQuotas marks bad the replicas on the current host allocating
+// more than the volume size plus the overhead, e.g. with too many snapshots.
+// Cleanup stops them and the controller rebuilds them elsewhere. The last
+// good replica of a volume is only reported.
+func (man *volumeManager) checkReplicaQuotas() error {
+	overhead, err := man.replicaQuotaOverhead()
+	if err != nil {
+		return err
+	}
+	if overhead < 0 {
+		return nil
+	}
+	return man.orc.ForEachVolume("", func(volume *types.VolumeInfo) error {
+		quota := volume.Size + volume.Size*int64(overhead)/100
+		good := 0
+		for _, replica := range volume.Replicas {
+			if replica.BadTimestamp == "" {
+				good++
+			}
+		}
+		for _, replica := range volume.Replicas {
+			// a block device cannot allocate more than its size
+			if replica.HostID != man.orc.GetCurrentHostID() || replica.BadTimestamp != "" || replica.BlockDevice != "" {
+				continue
+			}
+			path, err := man.orc.ReplicaDataPath(replica)
+			if err != nil {
+				logrus.Warnf("%v", errors.Wrapf(err, "replica quota: fail to get data path of replica '%s' of volume '%s'", replica.Name, volume.Name))
+				continue
+			}
+			_, allocated, err := util.DiskUsage(path)
+			if err != nil {
+				logrus.Warnf("%v", errors.Wrapf(err, "replica quota: fail to get disk usage of replica '%s' of volume '%s'", replica.Name, volume.Name))
+				continue
+			}
+			if allocated <= quota {
+				continue
+			}
+			if good <= 1 {
+				logrus.Errorf("replica quota: replica '%s' of volume '%s' allocated %v bytes over its quota of %v bytes, keeping the last good replica",
+					replica.Name, volume.Name, allocated, quota)
+				continue
+			}
+			logrus.Errorf("replica quota: replica '%s' of volume '%s' allocated %v bytes over its quota of %v bytes, marking it bad",
+				replica.Name, volume.Name, allocated, quota)
+			if err := man.orc.MarkBadReplica(volume.Name, replica); err != nil {
+				logrus.Errorf("%+v", errors.Wrapf(err, "replica quota: fail to mark replica '%s' of volume '%s' bad", replica.Name, volume.Name))
+				continue
+			}
+			good--
+		}
+		return nil
+	})
+}
+
+func (man *volumeManager) replicaQuota(ctx context.Context) error {
+	return runner.Tick(ctx, ReplicaQuotaCheckPeriod, func() {
+		if man.IsReconcilePaused() {
+			return
+		}
+		if err := man.checkReplicaQuotas(); err != nil {
+			logrus.Warnf("%v", errors.Wrap(err, "error checking replica quotas"))
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestReplicaQuota(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1")
+	man, _ := newTestManager(orc)
+
+	volume, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	names := []string{}
+	for name := range volume.Replicas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dir, err := ioutil.TempDir("", "replica")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		assert.Nil(os.Mkdir(path, 0700))
+		orc.replicaDataPaths[name] = path
+		// the first replica is far over 4096 plus 100%
+		size := 64 << 10
+		if i > 0 {
+			size = 100
+		}
+		assert.Nil(ioutil.WriteFile(filepath.Join(path, "volume-snap-000.img"), make([]byte, size), 0600))
+	}
+
+	// disabled by default
+	assert.Nil(man.checkReplicaQuotas())
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	for _, replica := range volume.Replicas {
+		assert.Equal("", replica.BadTimestamp)
+	}
+
+	// within 4096 plus 2000%
+	orc.settings.ReplicaQuota = true
+	orc.settings.ReplicaQuotaOverhead = 2000
+	assert.Nil(man.checkReplicaQuotas())
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	for _, replica := range volume.Replicas {
+		assert.Equal("", replica.BadTimestamp)
+	}
+
+	orc.settings.ReplicaQuotaOverhead = 0
+	assert.Nil(man.checkReplicaQuotas())
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.NotEqual("", volume.Replicas[names[0]].BadTimestamp)
+	assert.Equal("", volume.Replicas[names[1]].BadTimestamp)
+
+	// the last good replica is kept
+	assert.Nil(ioutil.WriteFile(filepath.Join(orc.replicaDataPaths[names[1]], "volume-snap-000.img"), make([]byte, 64<<10), 0600))
+	assert.Nil(man.checkReplicaQuotas())
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal("", volume.Replicas[names[1]].BadTimestamp)
+}
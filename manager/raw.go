@@ -0,0 +1,64 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// RawEditingEnabled allows writing the raw records of the volumes, for
+	// break-glass repairs
+	RawEditingEnabled = false
+)
+
+const (
+	EventReasonRawRecordEdited = "RawRecordEdited"
+)
+
+func (man *volumeManager) ListVolumeRawRecords(name string) ([]*types.RawRecord, error) {
+	records, err := man.orc.ListVolumeRawRecords(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get records of volume '%s'", name)
+	}
+	for _, r := range records {
+		value, err := util.RedactJSON([]byte(r.Value))
+		if err != nil {
+			// shown as it is, a broken value is what support looks for
+			logrus.Warnf("record %v of volume '%s': %v", r.Key, name, err)
+			continue
+		}
+		r.Value = string(value)
+	}
+	return records, nil
+}
+
+// SetVolumeRawRecord writes a single record of the volume as listed, if it
+// wasn't modified since. The value must have the secrets filled in again.
+func (man *volumeManager) SetVolumeRawRecord(name string, record *types.RawRecord, author string) error {
+	if !RawEditingEnabled {
+		return errors.Errorf("raw editing is disabled, restart the manager with --enable-raw-editing")
+	}
+	if strings.Contains(record.Value, util.Redacted) {
+		return errors.Errorf("value of %v has redacted fields, fill in their values", record.Key)
+	}
+	value := &bytes.Buffer{}
+	if err := json.Compact(value, []byte(record.Value)); err != nil {
+		return errors.Wrapf(err, "invalid value of %v", record.Key)
+	}
+	r := *record
+	r.Value = value.String()
+	if err := man.orc.SetVolumeRawRecord(name, &r); err != nil {
+		return errors.Wrapf(err, "unable to set record of volume '%s'", name)
+	}
+	man.events.record(name, types.EventSeverityError, EventReasonRawRecordEdited,
+		"record %v at index %v edited by %v", record.Key, record.ModifiedIndex, author)
+	logrus.Warnf("record %v of volume '%s' at index %v edited by %v", record.Key, name, record.ModifiedIndex, author)
+	return nil
+}
@@ -0,0 +1,58 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestVolumeRawRecords(t *testing.T) {
+	assert := require.New(t)
+
+	enabled := RawEditingEnabled
+	defer func() { RawEditingEnabled = enabled }()
+
+	orc := newFakeOrc("host-1")
+	man, _ := newTestManager(orc)
+	orc.rawRecords["vol"] = map[string]*types.RawRecord{
+		"/longhorn/volumes/vol/base": {
+			Key:           "/longhorn/volumes/vol/base",
+			Value:         `{"name":"vol","backupCredential":"xyz"}`,
+			ModifiedIndex: 7,
+		},
+		"/longhorn/volumes/vol/instances/controller": {
+			Key:           "/longhorn/volumes/vol/instances/controller",
+			Value:         `{"name":`,
+			ModifiedIndex: 8,
+		},
+	}
+
+	records, err := man.ListVolumeRawRecords("vol")
+	assert.Nil(err)
+	assert.Len(records, 2)
+	assert.Equal("{\n  \"backupCredential\": \"REDACTED\",\n  \"name\": \"vol\"\n}", records[0].Value)
+	assert.Equal(uint64(7), records[0].ModifiedIndex)
+	// a broken value is listed as it is
+	assert.Equal(`{"name":`, records[1].Value)
+
+	record := &types.RawRecord{Key: records[0].Key, Value: "{\n  \"name\": \"vol\"\n}", ModifiedIndex: 7}
+	RawEditingEnabled = false
+	assert.NotNil(man.SetVolumeRawRecord("vol", record, "admin"))
+
+	RawEditingEnabled = true
+	assert.NotNil(man.SetVolumeRawRecord("vol", records[0], "admin"))
+	assert.NotNil(man.SetVolumeRawRecord("vol", &types.RawRecord{Key: record.Key, Value: "{", ModifiedIndex: 7}, "admin"))
+	assert.Nil(man.SetVolumeRawRecord("vol", record, "admin"))
+	assert.Equal(`{"name":"vol"}`, orc.rawRecords["vol"][record.Key].Value)
+	// the index moved on
+	assert.NotNil(man.SetVolumeRawRecord("vol", record, "admin"))
+
+	man.events.flush()
+	events, err := orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Len(events, 1)
+	assert.Equal(EventReasonRawRecordEdited, events[0].Reason)
+	assert.Equal(types.EventSeverityError, events[0].Severity)
+}
@@ -0,0 +1,211 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/controller"
+	"github.com/rancher/longhorn-manager/types"
+)
+
+var replicaRevisionCounter = controller.GetReplicaRevisionCounter
+
+const EventReasonSalvageRequired = "SalvageRequired"
+
+func ValidateAutoReattachPolicy(policy types.AutoReattachPolicy) error {
+	switch policy {
+	case types.AutoReattachPolicyDefault, types.AutoReattachPolicyDisabled,
+		types.AutoReattachPolicyAlways, types.AutoReattachPolicyIfClean:
+		return nil
+	}
+	return errors.Errorf("invalid auto reattach policy '%s'", policy)
+}
+
+func (man *volumeManager) setRebuilding(name string, rebuilding bool) {
+	man.Lock()
+	defer man.Unlock()
+	if rebuilding {
+		man.rebuilding[name] = true
+	} else {
+		delete(man.rebuilding, name)
+	}
+}
+
+func (man *volumeManager) isRebuilding(name string) bool {
+	man.Lock()
+	defer man.Unlock()
+	return man.rebuilding[name]
+}
+
+func (man *volumeManager) autoReattachPolicy(volume *types.VolumeInfo) (types.AutoReattachPolicy, error) {
+	if volume.AutoReattach != types.AutoReattachPolicyDefault {
+		return volume.AutoReattach, nil
+	}
+	settings, err := man.settings.GetSettings()
+	if err != nil || settings == nil {
+		return types.AutoReattachPolicyDisabled, errors.Wrap(err, "unable to read settings")
+	}
+	if settings.AutoReattach == types.AutoReattachPolicyDefault {
+		return types.AutoReattachPolicyDisabled, nil
+	}
+	return settings.AutoReattach, nil
+}
+
+// uncleanReason checks if the replicas are safe for a new controller. It
+// returns why not, or "" if they are.
+func (man *volumeManager) uncleanReason(volume *types.VolumeInfo) string {
+	if man.isRebuilding(volume.Name) {
+		return "a replica rebuild was in progress"
+	}
+	revisions := map[int64][]string{}
+	for _, replica := range volume.Replicas {
+		if replica.BadTimestamp != "" {
+			continue
+		}
+		if !replica.Running || replica.Address == "" {
+			return fmt.Sprintf("replica '%s' is not running", replica.Name)
+		}
+		revision, err := replicaRevisionCounter(replica.Address)
+		if err != nil {
+			return fmt.Sprintf("unable to get revision counter of replica '%s': %v", replica.Name, err)
+		}
+		revisions[revision] = append(revisions[revision], replica.Name)
+	}
+	if len(revisions) > 1 {
+		return fmt.Sprintf("replica revision counters disagree: %v", revisions)
+	}
+	return ""
+}
+
+// ControllerFailed detaches the volume after its controller crashed, and
+// reattaches it if allowed by the auto reattach policy. If the policy asks
+// for clean replicas but they aren't, the volume requires salvage instead.
+// An unresponsive controller is recreated first, if enabled.
+func (man *volumeManager) ControllerFailed(name string) error {
+	volume, err := man.Get(name)
+	if err != nil {
+		return err
+	}
+	if volume == nil {
+		logrus.Warnf("volume %v no longer exists for controller failure", name)
+		return nil
+	}
+	if recreated, err := man.recreateController(volume, time.Now()); recreated || err != nil {
+		return err
+	}
+	policy, err := man.autoReattachPolicy(volume)
+	if err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "fail to get auto reattach policy of volume '%s', disabled", name))
+	}
+	reason := ""
+	if policy != types.AutoReattachPolicyDisabled {
+		reason = man.uncleanReason(volume)
+		if reason == "" && man.restartController(volume) {
+			return nil
+		}
+		if policy != types.AutoReattachPolicyIfClean {
+			reason = ""
+		}
+	}
+	if err := man.doDetach(volume); err != nil {
+		return errors.Wrapf(err, "error detaching volume '%s' with failed controller", name)
+	}
+
+	switch {
+	case policy == types.AutoReattachPolicyDisabled:
+		return nil
+	case reason != "":
+		return man.requireSalvage(name, reason)
+	}
+	logrus.Warnf("automatically reattaching volume '%s' after controller failure, policy %v", name, policy)
+	return man.attach(name, AttachReasonAutoReattach)
+}
+
+func (man *volumeManager) requireSalvage(name, reason string) error {
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	logrus.Errorf("volume '%s' requires salvage by the operator: %s", name, reason)
+	volume.SalvageRequired = true
+	volume.SalvageReason = reason
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return err
+	}
+	man.events.record(name, types.EventSeverityError, EventReasonSalvageRequired, "salvage by the operator required: %s", reason)
+	return nil
+}
+
+// Salvage keeps the chosen replicas of a volume requiring salvage, marks the
+// others bad, and allows the volume to be attached again.
+func (man *volumeManager) Salvage(name string, replicaNames []string) error {
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	if volume.Controller != nil {
+		return errors.Errorf("volume '%s' must be detached for salvage", name)
+	}
+	if len(replicaNames) == 0 {
+		return errors.Errorf("at least one replica of volume '%s' must be kept for salvage", name)
+	}
+	keep := map[string]bool{}
+	for _, replicaName := range replicaNames {
+		if volume.Replicas[replicaName] == nil {
+			return errors.Errorf("cannot find replica '%s' of volume '%s'", replicaName, name)
+		}
+		keep[replicaName] = true
+	}
+
+	replicaNames = []string{}
+	for replicaName := range volume.Replicas {
+		replicaNames = append(replicaNames, replicaName)
+	}
+	sort.Strings(replicaNames)
+	for _, replicaName := range replicaNames {
+		replica := volume.Replicas[replicaName]
+		if keep[replicaName] || replica.BadTimestamp != "" {
+			continue
+		}
+		logrus.Warnf("salvage: marking replica '%s' of volume '%s' bad", replicaName, name)
+		if err := man.orc.MarkBadReplica(name, replica); err != nil {
+			return errors.Wrapf(err, "fail to mark replica '%s' of volume '%s' bad", replicaName, name)
+		}
+	}
+
+	volume.SalvageRequired = false
+	volume.SalvageReason = ""
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", name)
+	}
+	logrus.Infof("salvaged volume '%s' with replicas %v", name, keep)
+	return nil
+}
+
+func (man *volumeManager) UpdateAutoReattach(name string, policy types.AutoReattachPolicy) error {
+	if err := ValidateAutoReattachPolicy(policy); err != nil {
+		return err
+	}
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	volume.AutoReattach = policy
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", name)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/controller"
+	"github.com/rancher/longhorn-manager/types"
+)
+
+var (
+	// RebuildStallTimeout is how long a rebuild may go without progress
+	// before it's torn down as stuck, 0 to never
+	RebuildStallTimeout = 30 * time.Minute
+	// RebuildMaxRetries is how many stuck or cancelled rebuilds a volume
+	// retries on other hosts before it's left degraded
+	RebuildMaxRetries = 2
+
+	replicaRebuildProgress = controller.GetReplicaRebuildProgress
+)
+
+const (
+	EventReasonRebuildStuck     = "RebuildStuck"
+	EventReasonRebuildCancelled = "RebuildCancelled"
+)
+
+// replicaRebuild is a replica being added to the controller of a volume. It
+// holds one of the adding replicas of the volume until it's finished or
+// torn down, whichever comes first.
+type replicaRebuild struct {
+	volumeName string
+	replica    *types.ReplicaInfo
+
+	// progress is what the replica reported last, changed at advanced
+	progress string
+	advanced time.Time
+	finished bool
+}
+
+func (man *volumeManager) startRebuild(volumeName string, replica *types.ReplicaInfo) *replicaRebuild {
+	r := &replicaRebuild{
+		volumeName: volumeName,
+		replica:    replica,
+		advanced:   time.Now(),
+	}
+	man.Lock()
+	man.rebuilds[volumeName] = r
+	man.Unlock()
+	man.addingReplicasCount(volumeName, 1)
+	return r
+}
+
+// finishRebuild releases the adding replica held by the rebuild, it's false
+// if the rebuild was finished already
+func (man *volumeManager) finishRebuild(r *replicaRebuild) bool {
+	man.Lock()
+	if r.finished {
+		man.Unlock()
+		return false
+	}
+	r.finished = true
+	if man.rebuilds[r.volumeName] == r {
+		delete(man.rebuilds, r.volumeName)
+	}
+	man.Unlock()
+	man.addingReplicasCount(r.volumeName, -1)
+	return true
+}
+
+// checkRebuild tears down the rebuild of the volume if the replica reported
+// the same progress for RebuildStallTimeout
+func (man *volumeManager) checkRebuild(volumeName string, ctrl types.Controller) error {
+	if RebuildStallTimeout == 0 {
+		return nil
+	}
+	man.Lock()
+	r := man.rebuilds[volumeName]
+	man.Unlock()
+	if r == nil {
+		return nil
+	}
+	progress, err := replicaRebuildProgress(r.replica.Address)
+	if err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "unable to get rebuild progress of replica '%s' of volume '%s'", r.replica.Name, volumeName))
+		return nil
+	}
+	now := time.Now()
+	man.Lock()
+	if progress != r.progress {
+		r.progress, r.advanced = progress, now
+	}
+	stalled := now.Sub(r.advanced)
+	man.Unlock()
+	if stalled < RebuildStallTimeout {
+		return nil
+	}
+	return man.abortRebuild(r, ctrl, EventReasonRebuildStuck, fmt.Sprintf("no progress for %v", stalled.Truncate(time.Second)))
+}
+
+// CancelRebuild tears down the replica being rebuilt for the volume, the
+// same way as a stuck rebuild
+func (man *volumeManager) CancelRebuild(volumeName string) error {
+	volume, err := man.Get(volumeName)
+	if err != nil {
+		return err
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", volumeName)
+	}
+	ctrl := man.getController(volume)
+	if ctrl == nil {
+		return errors.Errorf("volume '%s' has no running controller", volumeName)
+	}
+	man.Lock()
+	r := man.rebuilds[volumeName]
+	man.Unlock()
+	if r == nil {
+		return errors.Errorf("volume '%s' has no rebuild in progress on this host", volumeName)
+	}
+	return man.abortRebuild(r, ctrl, EventReasonRebuildCancelled, "cancelled")
+}
+
+// abortRebuild removes the replica from the controller and deletes it, its
+// data is incomplete. The next rebuild avoids the host of the replica,
+// unless the volume failed too many rebuilds.
+func (man *volumeManager) abortRebuild(r *replicaRebuild, ctrl types.Controller, reason, detail string) error {
+	if !man.finishRebuild(r) {
+		return errors.Errorf("rebuild of replica '%s' of volume '%s' is finished already", r.replica.Name, r.volumeName)
+	}
+	logrus.Warnf("rebuild of replica '%s' of volume '%s' on host %v aborted: %v", r.replica.Name, r.volumeName, r.replica.HostID, detail)
+	if err := ctrl.RemoveReplica(r.replica); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "failed to remove rebuilding replica '%s' from controller of volume '%s'", r.replica.Name, r.volumeName))
+	}
+	man.removeStaleReplica(r.volumeName, r.replica)
+
+	volume, err := man.orc.GetVolume(r.volumeName)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", r.volumeName)
+	}
+	if volume == nil {
+		return nil
+	}
+	volume.RebuildFailures++
+	excluded := false
+	for _, hostID := range volume.RebuildExcludedHosts {
+		excluded = excluded || hostID == r.replica.HostID
+	}
+	if !excluded {
+		volume.RebuildExcludedHosts = append(volume.RebuildExcludedHosts, r.replica.HostID)
+	}
+	if volume.RebuildFailures > RebuildMaxRetries {
+		volume.RebuildCondition = fmt.Sprintf("%v rebuilds failed, the last one on host %v %v: %v. No replica is rebuilt until the volume is reattached",
+			volume.RebuildFailures, r.replica.HostID, reason, detail)
+		man.events.record(r.volumeName, types.EventSeverityError, reason,
+			"rebuild of replica %v on host %v aborted, %v; giving up after %v failed rebuilds", r.replica.Name, r.replica.HostID, detail, volume.RebuildFailures)
+	} else {
+		man.events.record(r.volumeName, types.EventSeverityWarning, reason,
+			"rebuild of replica %v on host %v aborted, %v; retrying on another host", r.replica.Name, r.replica.HostID, detail)
+	}
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", r.volumeName)
+	}
+	return nil
+}
+
+// resetRebuildFailures clears the failed rebuilds of the volume, after a
+// good rebuild or an attach
+func (man *volumeManager) resetRebuildFailures(volumeName string) {
+	volume, err := man.orc.GetVolume(volumeName)
+	if err != nil || volume == nil {
+		logrus.Warnf("unable to get volume '%s' to reset its failed rebuilds: %v", volumeName, err)
+		return
+	}
+	if volume.RebuildFailures == 0 && volume.RebuildCondition == "" {
+		return
+	}
+	volume.RebuildFailures = 0
+	volume.RebuildExcludedHosts = nil
+	volume.RebuildCondition = ""
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "unable to reset failed rebuilds of volume '%s'", volumeName))
+	}
+}
+
+// removeStaleReplica deletes a replica which failed to be added to the
+// controller
+func (man *volumeManager) removeStaleReplica(volumeName string, replica *types.ReplicaInfo) {
+	if _, err := man.orc.StopInstance(&replica.InstanceInfo); err != nil {
+		logrus.Errorf("%+v", errors.Wrapf(err, "failed to stop stale replica '%s' of volume '%s'", replica.Name, volumeName))
+	}
+	if _, err := man.orc.RemoveInstance(&replica.InstanceInfo); err != nil {
+		logrus.Errorf("%+v", errors.Wrapf(err, "failed to remove stale replica '%s' of volume '%s'", replica.Name, volumeName))
+	}
+}
@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestStuckRebuild(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(progress func(string) (string, error), timeout time.Duration, retries int) {
+		replicaRebuildProgress, RebuildStallTimeout, RebuildMaxRetries = progress, timeout, retries
+	}(replicaRebuildProgress, RebuildStallTimeout, RebuildMaxRetries)
+	replicaRebuildProgress = func(address string) (string, error) {
+		return "chain=[];disks={};", nil
+	}
+	RebuildStallTimeout = 50 * time.Millisecond
+	RebuildMaxRetries = 1
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	ctrl := fc.get(volume).(*fakeController)
+	ctrl.Lock()
+	// the rebuilds never finish
+	ctrl.addDelay = time.Hour
+	for _, replica := range ctrl.replicas {
+		replica.Mode = types.ReplicaModeERR
+		break
+	}
+	ctrl.Unlock()
+
+	check := func() *types.VolumeInfo {
+		volume, err := man.Get("vol")
+		assert.Nil(err)
+		assert.Nil(man.CheckController(ctrl, volume))
+		volume, err = man.Get("vol")
+		assert.Nil(err)
+		return volume
+	}
+	rebuilding := func() *replicaRebuild {
+		man.Lock()
+		defer man.Unlock()
+		return man.rebuilds["vol"]
+	}
+
+	check()
+	first := rebuilding()
+	assert.NotNil(first)
+	assert.Equal(1, man.addingReplicasCount("vol", 0))
+
+	// no progress for the stall timeout, the replica is torn down and the
+	// rebuild retried
+	check()
+	time.Sleep(2 * RebuildStallTimeout)
+	volume = check()
+	assert.Equal(1, volume.RebuildFailures)
+	assert.Equal([]string{first.replica.HostID}, volume.RebuildExcludedHosts)
+	assert.Equal("", volume.RebuildCondition)
+	ctrl.Lock()
+	assert.Contains(ctrl.removed, first.replica.Address)
+	ctrl.Unlock()
+	second := rebuilding()
+	assert.NotNil(second)
+	assert.NotEqual(first.replica.Name, second.replica.Name)
+	assert.Equal(1, man.addingReplicasCount("vol", 0))
+
+	// cancelling follows the same path, and gives up after the retries
+	assert.Nil(man.CancelRebuild("vol"))
+	assert.NotNil(man.CancelRebuild("vol"))
+	volume = check()
+	assert.Equal(2, volume.RebuildFailures)
+	assert.NotEqual("", volume.RebuildCondition)
+	assert.Nil(rebuilding())
+	assert.Equal(0, man.addingReplicasCount("vol", 0))
+
+	man.events.flush()
+	events, err := orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	reasons := map[string]types.EventSeverity{}
+	for _, e := range events {
+		reasons[e.Reason] = e.Severity
+	}
+	assert.Equal(types.EventSeverityWarning, reasons[EventReasonRebuildStuck])
+	assert.Equal(types.EventSeverityError, reasons[EventReasonRebuildCancelled])
+
+	// reattaching clears the failures
+	assert.Nil(man.Detach("vol"))
+	assert.Nil(man.Attach("vol"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(0, volume.RebuildFailures)
+	assert.Equal("", volume.RebuildCondition)
+}
@@ -0,0 +1,34 @@
+package manager
+
+import (
+	"github.com/Sirupsen/logrus"
+)
+
+// PauseReconcile stops the periodic checks of the manager from acting: the
+// monitoring and cleanup of the attached volumes, rehoming, auto detach and
+// the replica quotas. Nothing is repaired until ResumeReconcile, the
+// operations asked for through the API still work. The pause is not kept
+// over a restart.
+func (man *volumeManager) PauseReconcile() {
+	man.Lock()
+	defer man.Unlock()
+	if !man.reconcilePaused {
+		logrus.Warnf("reconcile paused, degraded volumes won't be repaired until resumed")
+	}
+	man.reconcilePaused = true
+}
+
+func (man *volumeManager) ResumeReconcile() {
+	man.Lock()
+	defer man.Unlock()
+	if man.reconcilePaused {
+		logrus.Infof("reconcile resumed")
+	}
+	man.reconcilePaused = false
+}
+
+func (man *volumeManager) IsReconcilePaused() bool {
+	man.Lock()
+	defer man.Unlock()
+	return man.reconcilePaused
+}
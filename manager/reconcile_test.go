@@ -0,0 +1,66 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestPauseReconcile(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+
+	ctrl := fc.controllers["vol"]
+	ctrl.Lock()
+	for _, replica := range ctrl.replicas {
+		replica.Mode = types.ReplicaModeERR
+		break
+	}
+	ctrl.Unlock()
+
+	assert.False(man.IsReconcilePaused())
+	man.PauseReconcile()
+	assert.True(man.IsReconcilePaused())
+
+	// each send returns once the previous check is done
+	ch := make(chan types.Event)
+	go monitor(ctrl, volume, man, ch)
+	defer close(ch)
+	for i := 0; i < 3; i++ {
+		assert.True(Send(ch, TimeEvent()))
+	}
+	select {
+	case <-ctrl.added:
+		assert.Fail("degraded volume rebuilt while reconcile paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+	ctrl.Lock()
+	assert.Len(ctrl.removed, 0)
+	ctrl.Unlock()
+
+	// manual operations still work
+	assert.Nil(man.UpdatePinReplicas("vol", true))
+
+	man.ResumeReconcile()
+	assert.False(man.IsReconcilePaused())
+	assert.True(Send(ch, TimeEvent()))
+	select {
+	case <-ctrl.added:
+	case <-time.After(5 * time.Second):
+		assert.Fail("no rebuild after reconcile resumed")
+	}
+	ctrl.Lock()
+	assert.Len(ctrl.removed, 1)
+	ctrl.Unlock()
+}
@@ -0,0 +1,222 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// the volume events are queued in memory and written in batches, every
+	// EventFlushInterval or once EventBatchSize events are queued. The
+	// lowest severity events are dropped first beyond EventQueueSize. They
+	// take effect on New().
+	EventFlushInterval = 2 * time.Second
+	EventBatchSize     = 100
+	EventQueueSize     = 1000
+)
+
+const (
+	EventReasonCreated        = "Created"
+	EventReasonAttached       = "Attached"
+	EventReasonDetached       = "Detached"
+	EventReasonReplicaFailed  = "ReplicaFailed"
+	EventReasonRebuilding     = "Rebuilding"
+	EventReasonNoGoodReplicas = "NoGoodReplicas"
+)
+
+// eventRecorder keeps recording the events from blocking on the store. The
+// events of a volume are written in the order they were recorded.
+type eventRecorder struct {
+	sync.Mutex
+
+	store     types.EventStore
+	hostID    string
+	interval  time.Duration
+	batchSize int
+	queueSize int
+
+	queue   []*types.VolumeEvent
+	dropped map[types.EventSeverity]int64
+
+	// held while writing, so the batches are written one after another
+	flushing sync.Mutex
+	flushCh  chan struct{}
+}
+
+func newEventRecorder(store types.EventStore, hostID string) *eventRecorder {
+	return &eventRecorder{
+		store:     store,
+		hostID:    hostID,
+		interval:  EventFlushInterval,
+		batchSize: EventBatchSize,
+		queueSize: EventQueueSize,
+
+		dropped: map[types.EventSeverity]int64{},
+
+		flushCh: make(chan struct{}, 1),
+	}
+}
+
+func (r *eventRecorder) record(volumeName string, severity types.EventSeverity, reason, format string, args ...interface{}) {
+	event := &types.VolumeEvent{
+		Volume:   volumeName,
+		Time:     util.Now(),
+		HostID:   r.hostID,
+		Severity: severity,
+		Reason:   reason,
+		Message:  fmt.Sprintf(format, args...),
+	}
+
+	r.Lock()
+	r.queue = append(r.queue, event)
+	r.trim()
+	full := len(r.queue) >= r.batchSize
+	r.Unlock()
+
+	if full {
+		select {
+		case r.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trim drops the oldest of the lowest severity events until the queue fits,
+// the order of the remaining events is kept. Must be called with the lock
+// held.
+func (r *eventRecorder) trim() {
+	for len(r.queue) > r.queueSize {
+		drop := 0
+		for i, e := range r.queue {
+			if e.Severity.Rank() < r.queue[drop].Severity.Rank() {
+				drop = i
+			}
+		}
+		e := r.queue[drop]
+		r.dropped[e.Severity]++
+		logrus.Debugf("event queue full, dropped %v event '%s' of volume '%s'", e.Severity, e.Reason, e.Volume)
+		r.queue = append(r.queue[:drop], r.queue[drop+1:]...)
+	}
+}
+
+func (r *eventRecorder) take() []*types.VolumeEvent {
+	r.Lock()
+	defer r.Unlock()
+	n := r.batchSize
+	if n > len(r.queue) {
+		n = len(r.queue)
+	}
+	batch := r.queue[:n:n]
+	r.queue = r.queue[n:]
+	return batch
+}
+
+// requeue puts back the events failed to be written ahead of the ones
+// recorded since
+func (r *eventRecorder) requeue(events []*types.VolumeEvent) {
+	r.Lock()
+	defer r.Unlock()
+	r.queue = append(events, r.queue...)
+	r.trim()
+}
+
+// flush writes the queued events in batches, with one write per volume in
+// each batch. The events failed to be written are kept for the next flush.
+func (r *eventRecorder) flush() {
+	r.flushing.Lock()
+	defer r.flushing.Unlock()
+	for {
+		batch := r.take()
+		if len(batch) == 0 {
+			return
+		}
+		names := []string{}
+		byVolume := map[string][]*types.VolumeEvent{}
+		for _, e := range batch {
+			if byVolume[e.Volume] == nil {
+				names = append(names, e.Volume)
+			}
+			byVolume[e.Volume] = append(byVolume[e.Volume], e)
+		}
+		failed := []*types.VolumeEvent{}
+		for _, name := range names {
+			if err := r.store.AppendVolumeEvents(name, byVolume[name]); err != nil {
+				logrus.Errorf("%+v", errors.Wrapf(err, "failed to write %v events of volume '%s'", len(byVolume[name]), name))
+				failed = append(failed, byVolume[name]...)
+			}
+		}
+		if len(failed) > 0 {
+			r.requeue(failed)
+			return
+		}
+	}
+}
+
+// discard drops the queued events of the volume and removes the ones written
+func (r *eventRecorder) discard(volumeName string) error {
+	r.flushing.Lock()
+	defer r.flushing.Unlock()
+	r.Lock()
+	queue := []*types.VolumeEvent{}
+	for _, e := range r.queue {
+		if e.Volume != volumeName {
+			queue = append(queue, e)
+		}
+	}
+	r.queue = queue
+	r.Unlock()
+	return r.store.DeleteVolumeEvents(volumeName)
+}
+
+// run flushes the events periodically, or once a batch is queued, and the
+// events queued when ctx is done before returning
+func (r *eventRecorder) run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.flushCh:
+		case <-ctx.Done():
+			r.flush()
+			return nil
+		}
+		r.flush()
+	}
+}
+
+func (r *eventRecorder) status() *types.EventRecorderStatus {
+	r.Lock()
+	defer r.Unlock()
+	dropped := map[types.EventSeverity]int64{}
+	for severity, count := range r.dropped {
+		dropped[severity] = count
+	}
+	return &types.EventRecorderStatus{
+		Queued:        len(r.queue),
+		QueueSize:     r.queueSize,
+		BatchSize:     r.batchSize,
+		FlushInterval: r.interval.String(),
+		Dropped:       dropped,
+	}
+}
+
+func (man *volumeManager) ListVolumeEvents(name string) ([]*types.VolumeEvent, error) {
+	events, err := man.orc.ListVolumeEvents(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list events of volume '%s'", name)
+	}
+	return events, nil
+}
+
+func (man *volumeManager) EventRecorderStatus() *types.EventRecorderStatus {
+	return man.events.status()
+}
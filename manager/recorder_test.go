@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func eventReasons(events []*types.VolumeEvent) []string {
+	reasons := []string{}
+	for _, e := range events {
+		reasons = append(reasons, e.Reason)
+	}
+	return reasons
+}
+
+func TestEventRecorderBatches(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1")
+	r := newEventRecorder(orc, "host-1")
+	r.batchSize = 3
+
+	r.record("vol-a", types.EventSeverityInfo, "a1", "")
+	r.record("vol-b", types.EventSeverityInfo, "b1", "")
+	r.record("vol-a", types.EventSeverityInfo, "a2", "")
+	r.record("vol-b", types.EventSeverityInfo, "b2", "")
+	r.record("vol-a", types.EventSeverityInfo, "a3", "")
+	assert.Equal(5, r.status().Queued)
+	assert.Len(orc.eventBatches, 0)
+
+	r.flush()
+	assert.Equal(0, r.status().Queued)
+	// one write per volume in each batch, in the order recorded
+	assert.Len(orc.eventBatches["vol-a"], 2)
+	assert.Equal([]string{"a1", "a2"}, eventReasons(orc.eventBatches["vol-a"][0]))
+	assert.Equal([]string{"a3"}, eventReasons(orc.eventBatches["vol-a"][1]))
+	assert.Len(orc.eventBatches["vol-b"], 2)
+	events, err := orc.ListVolumeEvents("vol-b")
+	assert.Nil(err)
+	assert.Equal([]string{"b1", "b2"}, eventReasons(events))
+	assert.Equal("host-1", events[0].HostID)
+}
+
+func TestEventRecorderOverflow(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1")
+	r := newEventRecorder(orc, "host-1")
+	r.queueSize = 3
+
+	r.record("vol", types.EventSeverityInfo, "i1", "")
+	r.record("vol", types.EventSeverityError, "e1", "")
+	r.record("vol", types.EventSeverityInfo, "i2", "")
+	r.record("vol", types.EventSeverityWarning, "w1", "")
+	r.record("vol", types.EventSeverityInfo, "i3", "")
+	r.record("vol", types.EventSeverityError, "e2", "")
+
+	status := r.status()
+	assert.Equal(3, status.Queued)
+	assert.Equal(map[types.EventSeverity]int64{types.EventSeverityInfo: 3}, status.Dropped)
+
+	r.flush()
+	events, err := orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Equal([]string{"e1", "w1", "e2"}, eventReasons(events))
+
+	// the warning goes once there is no info event left
+	r.record("vol", types.EventSeverityError, "e3", "")
+	r.record("vol", types.EventSeverityWarning, "w2", "")
+	r.record("vol", types.EventSeverityError, "e4", "")
+	r.record("vol", types.EventSeverityError, "e5", "")
+	assert.Equal(int64(1), r.status().Dropped[types.EventSeverityWarning])
+	r.flush()
+	events, err = orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Equal([]string{"e1", "w1", "e2", "e3", "e4", "e5"}, eventReasons(events))
+}
+
+func TestEventRecorderStoreFailure(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1")
+	r := newEventRecorder(orc, "host-1")
+	r.batchSize = 2
+
+	orc.eventsErr = errors.New("etcd unavailable")
+	r.record("vol", types.EventSeverityInfo, "1", "")
+	r.record("vol", types.EventSeverityInfo, "2", "")
+	r.flush()
+	assert.Equal(2, r.status().Queued)
+
+	r.record("vol", types.EventSeverityInfo, "3", "")
+	orc.eventsErr = nil
+	// the queued events are written once stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Nil(r.run(ctx))
+	assert.Equal(0, r.status().Queued)
+	events, err := orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Equal([]string{"1", "2", "3"}, eventReasons(events))
+}
+
+func TestVolumeEvents(t *testing.T) {
+	defer checkLeaks(t)()
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	assert.Nil(man.Detach("vol"))
+
+	// not listed before written
+	events, err := man.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Len(events, 0)
+	assert.Equal(3, man.EventRecorderStatus().Queued)
+
+	man.Shutdown()
+	events, err = man.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Equal([]string{EventReasonCreated, EventReasonAttached, EventReasonDetached}, eventReasons(events))
+
+	assert.Nil(man.Delete("vol"))
+	events, err = man.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Len(events, 0)
+}
@@ -0,0 +1,148 @@
+package manager
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+var (
+	// DefaultControllerUnresponsiveThreshold is used if the
+	// controllerUnresponsiveThreshold setting is empty
+	DefaultControllerUnresponsiveThreshold = 30 * time.Second
+
+	// ControllerRecreateBackoff is the wait after the first recreate of the
+	// controller of a volume, doubled by each of the following ones up to
+	// ControllerRecreateMaxBackoff
+	ControllerRecreateBackoff    = time.Minute
+	ControllerRecreateMaxBackoff = 30 * time.Minute
+	// the recreates of a volume are forgotten once it had none for
+	// ControllerRecreateResetPeriod
+	ControllerRecreateResetPeriod = time.Hour
+)
+
+const (
+	AttachReasonControllerRecreate = "recreate of unresponsive controller"
+
+	EventReasonControllerRecreated = "ControllerRecreated"
+	EventReasonControllerRestarted = "ControllerRestarted"
+)
+
+// controllerRecreates are the recent recreates of the controller of a
+// volume
+type controllerRecreates struct {
+	count int
+	last  time.Time
+}
+
+// ControllerRecreateThreshold returns how long a controller may stay
+// unresponsive before it's recreated, 0 if the recreates are disabled
+func (man *volumeManager) ControllerRecreateThreshold() time.Duration {
+	settings, err := man.settings.GetSettings()
+	if err != nil || settings == nil {
+		logrus.Warnf("%v", errors.Wrap(err, "unable to read settings, controller recreate disabled"))
+		return 0
+	}
+	if !settings.ControllerRecreate {
+		return 0
+	}
+	if settings.ControllerUnresponsiveThreshold == "" {
+		return DefaultControllerUnresponsiveThreshold
+	}
+	threshold, err := time.ParseDuration(settings.ControllerUnresponsiveThreshold)
+	if err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "invalid controllerUnresponsiveThreshold setting, using %v", DefaultControllerUnresponsiveThreshold))
+		return DefaultControllerUnresponsiveThreshold
+	}
+	return threshold
+}
+
+// controllerRecreateBackoff returns how long the volume must wait before
+// the next recreate, after count of them
+func controllerRecreateBackoff(count int) time.Duration {
+	if count == 0 {
+		return 0
+	}
+	backoff := ControllerRecreateBackoff
+	for i := 1; i < count && backoff < ControllerRecreateMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > ControllerRecreateMaxBackoff {
+		backoff = ControllerRecreateMaxBackoff
+	}
+	return backoff
+}
+
+// recreateController detaches the volume with the unresponsive controller
+// and attaches it again on the current host, which runs the controller,
+// unless the crashed controller can be restarted in place. It's false if the controller is not recreated: the recreates are disabled,
+// backing off, or the replicas aren't healthy.
+func (man *volumeManager) recreateController(volume *types.VolumeInfo, now time.Time) (bool, error) {
+	if man.ControllerRecreateThreshold() == 0 {
+		return false, nil
+	}
+	if volume.Controller == nil || volume.Controller.HostID != man.orc.GetCurrentHostID() {
+		return false, nil
+	}
+	man.Lock()
+	r := man.recreates[volume.Name]
+	if r != nil && now.Sub(r.last) >= ControllerRecreateResetPeriod {
+		r = nil
+	}
+	if r == nil {
+		r = &controllerRecreates{}
+	}
+	wait := controllerRecreateBackoff(r.count) - now.Sub(r.last)
+	man.Unlock()
+	if wait > 0 {
+		logrus.Warnf("not recreating the controller of volume '%s', recreated %v times, backing off for %v", volume.Name, r.count, wait)
+		return false, nil
+	}
+	if reason := man.uncleanReason(volume); reason != "" {
+		logrus.Warnf("not recreating the controller of volume '%s': %v", volume.Name, reason)
+		return false, nil
+	}
+
+	man.Lock()
+	man.recreates[volume.Name] = &controllerRecreates{count: r.count + 1, last: now}
+	man.Unlock()
+	if man.restartController(volume) {
+		return true, nil
+	}
+	logrus.Warnf("recreating the unresponsive controller of volume '%s' on host %v", volume.Name, volume.Controller.HostID)
+	if err := man.doDetach(volume); err != nil {
+		return true, errors.Wrapf(err, "error detaching volume '%s' to recreate its controller", volume.Name)
+	}
+	man.events.record(volume.Name, types.EventSeverityWarning, EventReasonControllerRecreated, "recreating unresponsive controller, attempt %v", r.count+1)
+	return true, man.attach(volume.Name, AttachReasonControllerRecreate)
+}
+
+// restartController starts the crashed controller of the volume again in
+// place, which is quicker than recreating it: the replicas are left running
+// and the container is reused. They must be clean. It's false if the
+// controller is to be recreated, e.g. its container is gone or its config
+// changed.
+func (man *volumeManager) restartController(volume *types.VolumeInfo) bool {
+	if volume.Controller == nil || volume.Controller.HostID != man.orc.GetCurrentHostID() {
+		return false
+	}
+	controller, reason, err := man.orc.RestartController(volume)
+	if err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "fail to restart the controller of volume '%s', recreating it", volume.Name))
+		return false
+	}
+	if controller == nil {
+		logrus.Infof("not restarting the controller of volume '%s' in place: %v", volume.Name, reason)
+		return false
+	}
+	logrus.Warnf("restarted the failed controller of volume '%s' in place on host %v", volume.Name, controller.HostID)
+	man.stopMonitoring(volume)
+	v := *volume
+	v.Controller = controller
+	man.startMonitoring(&v)
+	man.events.record(volume.Name, types.EventSeverityWarning, EventReasonControllerRestarted, "restarted failed controller %v in place", controller.Name)
+	return true
+}
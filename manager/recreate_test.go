@@ -0,0 +1,137 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestControllerRecreate(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(f func(string) (int64, error), period time.Duration) {
+		replicaRevisionCounter, MonitoringPeriod = f, period
+	}(replicaRevisionCounter, MonitoringPeriod)
+	replicaRevisionCounter = func(address string) (int64, error) {
+		return 1, nil
+	}
+	MonitoringPeriod = 10 * time.Millisecond
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, fc := newTestManager(orc)
+	assert.Equal(time.Duration(0), man.ControllerRecreateThreshold())
+	orc.settings.ControllerRecreate = true
+	orc.settings.ControllerUnresponsiveThreshold = "100ms"
+	assert.Equal(100*time.Millisecond, man.ControllerRecreateThreshold())
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	ctrl := fc.get(volume).(*fakeController)
+	ctrl.Lock()
+	ctrl.unresponsive = true
+	ctrl.Unlock()
+
+	// the monitor waits out the threshold, then the controller is
+	// recreated on the same host
+	ch := make(chan types.Event)
+	started := time.Now()
+	done := make(chan struct{})
+	go func() {
+		monitor(ctrl, volume, man, ch)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.FailNow("the unresponsive controller is never failed")
+	}
+	assert.True(time.Since(started) >= 100*time.Millisecond)
+	ctrl.Lock()
+	ctrl.unresponsive = false
+	ctrl.Unlock()
+
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.NotNil(volume.Controller)
+	assert.Equal("host-1", volume.Controller.HostID)
+	assert.Len(volume.AttachHistory, 2)
+	assert.Equal(AttachReasonControllerRecreate, volume.AttachHistory[1].Reason)
+
+	// failing again right away backs off, the volume is detached as the
+	// auto reattach policy says
+	assert.Nil(man.ControllerFailed("vol"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Nil(volume.Controller)
+	assert.Len(volume.AttachHistory, 2)
+
+	assert.Equal(time.Duration(0), controllerRecreateBackoff(0))
+	assert.Equal(ControllerRecreateBackoff, controllerRecreateBackoff(1))
+	assert.Equal(4*ControllerRecreateBackoff, controllerRecreateBackoff(3))
+	assert.Equal(ControllerRecreateMaxBackoff, controllerRecreateBackoff(20))
+}
+
+func TestControllerRestartInPlace(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(f func(string) (int64, error)) {
+		replicaRevisionCounter = f
+	}(replicaRevisionCounter)
+	replicaRevisionCounter = func(address string) (int64, error) {
+		return 1, nil
+	}
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+	orc.settings.ControllerRecreate = true
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	assert.Nil(man.Attach("vol"))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	controllerID := volume.Controller.ID
+
+	// the stopped controller is started again, the replicas are untouched
+	orc.restartable["vol"] = true
+	_, err = orc.StopInstance(&volume.Controller.InstanceInfo)
+	assert.Nil(err)
+	assert.Nil(man.ControllerFailed("vol"))
+	assert.Equal([]string{"vol"}, orc.restarted)
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(controllerID, volume.Controller.ID)
+	assert.True(volume.Controller.Running)
+	assert.Len(volume.AttachHistory, 1)
+	for _, replica := range volume.Replicas {
+		assert.True(replica.Running)
+	}
+
+	// gone, it's recreated
+	orc.restartable["vol"] = false
+	man.recreates = map[string]*controllerRecreates{}
+	assert.Nil(man.ControllerFailed("vol"))
+	assert.Equal([]string{"vol"}, orc.restarted)
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.NotNil(volume.Controller)
+	assert.Len(volume.AttachHistory, 2)
+	assert.Equal(AttachReasonControllerRecreate, volume.AttachHistory[1].Reason)
+
+	// restarted as auto reattach too
+	orc.settings.ControllerRecreate = false
+	orc.settings.AutoReattach = types.AutoReattachPolicyAlways
+	orc.restartable["vol"] = true
+	assert.Nil(man.ControllerFailed("vol"))
+	assert.Equal([]string{"vol", "vol"}, orc.restarted)
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.NotNil(volume.Controller)
+	assert.Len(volume.AttachHistory, 2)
+}
@@ -0,0 +1,180 @@
+package manager
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+const (
+	AttachReasonRequested    = "requested"
+	AttachReasonRehome       = "rehome to preferred host"
+	AttachReasonAutoReattach = "auto reattach after controller failure"
+
+	attachHistoryLimit = 20
+)
+
+var (
+	RehomePeriod = time.Minute
+)
+
+func ValidateRehomePolicy(policy types.RehomePolicy) error {
+	switch policy {
+	case types.RehomePolicyNone, types.RehomePolicyReattach, types.RehomePolicyWindow:
+		return nil
+	}
+	return errors.Errorf("invalid rehome policy '%s'", policy)
+}
+
+// mostFrequentHost returns the host with the most attaches, ties broken by
+// host ID so the result is stable.
+func mostFrequentHost(counts map[string]int) string {
+	hostIDs := []string{}
+	for id := range counts {
+		hostIDs = append(hostIDs, id)
+	}
+	sort.Strings(hostIDs)
+	best := ""
+	for _, id := range hostIDs {
+		if best == "" || counts[id] > counts[best] {
+			best = id
+		}
+	}
+	return best
+}
+
+func (man *volumeManager) recordAttach(name, reason string) error {
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil || volume.Controller == nil {
+		return nil
+	}
+	hostID := volume.Controller.HostID
+	if volume.AttachCounts == nil {
+		volume.AttachCounts = map[string]int{}
+	}
+	volume.AttachCounts[hostID]++
+	volume.AttachHistory = append(volume.AttachHistory, &types.AttachRecord{
+		HostID: hostID,
+		Time:   util.Now(),
+		Reason: reason,
+	})
+	if len(volume.AttachHistory) > attachHistoryLimit {
+		volume.AttachHistory = volume.AttachHistory[len(volume.AttachHistory)-attachHistoryLimit:]
+	}
+	if !volume.PreferredHostPinned {
+		volume.PreferredHostID = mostFrequentHost(volume.AttachCounts)
+	}
+	volume.RehomePending = volume.RehomePolicy == types.RehomePolicyReattach &&
+		volume.PreferredHostID != "" && volume.PreferredHostID != hostID
+	if volume.RehomePending {
+		logrus.Infof("volume '%s' attached to host %v instead of preferred host %v, will rehome at next attach",
+			name, hostID, volume.PreferredHostID)
+	}
+	return man.orc.UpdateVolume(volume)
+}
+
+func (man *volumeManager) UpdatePreferredHost(name, hostID string, policy types.RehomePolicy) error {
+	if err := ValidateRehomePolicy(policy); err != nil {
+		return err
+	}
+	if hostID != "" {
+		host, err := man.orc.GetHost(hostID)
+		if err != nil {
+			return errors.Wrapf(err, "unable to get host %v", hostID)
+		}
+		if host == nil {
+			return errors.Errorf("cannot find host %v", hostID)
+		}
+	}
+	volume, err := man.orc.GetVolume(name)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", name)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", name)
+	}
+	if volume.Mode == types.VolumeModeLocal {
+		return errors.Errorf("cannot change the host of local volume '%s'", name)
+	}
+	volume.PreferredHostPinned = hostID != ""
+	volume.PreferredHostID = hostID
+	if !volume.PreferredHostPinned {
+		volume.PreferredHostID = mostFrequentHost(volume.AttachCounts)
+	}
+	volume.RehomePolicy = policy
+	if policy != types.RehomePolicyReattach {
+		volume.RehomePending = false
+	}
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update volume '%s'", name)
+	}
+	return nil
+}
+
+// rehome moves controllers of volumes preferring the current host back here
+// during the maintenance window. An attached volume may be serving IO, so it
+// is only moved if the window allows live migration.
+func (man *volumeManager) rehome(ctx context.Context) error {
+	return runner.Tick(ctx, RehomePeriod, func() {
+		if man.IsReconcilePaused() {
+			return
+		}
+		if err := man.rehomeVolumes(); err != nil {
+			logrus.Warnf("%v", errors.Wrap(err, "error rehoming volumes"))
+		}
+	})
+}
+
+func (man *volumeManager) rehomeVolumes() error {
+	settings, err := man.settings.GetSettings()
+	if err != nil || settings == nil {
+		return errors.Wrap(err, "unable to read settings")
+	}
+	if settings.RehomeWindow == "" || !settings.RehomeWindowLive {
+		return nil
+	}
+	window, err := util.ParseTimeWindow(settings.RehomeWindow)
+	if err != nil {
+		return err
+	}
+	if !util.InTimeWindow(window, time.Now()) {
+		return nil
+	}
+
+	currentHostID := man.orc.GetCurrentHostID()
+	rehomed := map[string]string{}
+	if err := man.orc.ForEachVolume("", func(volume *types.VolumeInfo) error {
+		if volume.RehomePolicy != types.RehomePolicyWindow ||
+			volume.PreferredHostID != currentHostID ||
+			volume.Controller == nil || !volume.Controller.Running ||
+			volume.Controller.HostID == currentHostID {
+			return nil
+		}
+		rehomed[volume.Name] = volume.Controller.HostID
+		return nil
+	}); err != nil {
+		return err
+	}
+	errs := Errs{}
+	for name, hostID := range rehomed {
+		logrus.Infof("rehoming volume '%s' from host %v to preferred host %v",
+			name, hostID, currentHostID)
+		if err := man.attach(name, AttachReasonRehome); err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to rehome volume '%s'", name))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
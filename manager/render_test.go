@@ -0,0 +1,36 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestRenderInstance(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+
+	volume := filterTestVolume("vol", "", nil, "host-1", "host-2")
+	volume.Replicas["vol-replica-host-2"].BadTimestamp = "2017-01-01T00:00:00Z"
+	_, err := orc.CreateVolume(volume)
+	assert.Nil(err)
+
+	// with the replicas attaching would start the controller with
+	render, err := man.RenderInstance(types.InstanceTypeController, "vol")
+	assert.Nil(err)
+	assert.Equal("vol-controller", render.InstanceName)
+	assert.Equal([]string{"vol-replica-host-1"}, render.Payload)
+
+	render, err = man.RenderInstance(types.InstanceTypeReplica, "vol")
+	assert.Nil(err)
+	assert.Contains(render.InstanceName, "vol-replica-")
+
+	_, err = man.RenderInstance(types.InstanceTypeController, "missing")
+	assert.NotNil(err)
+	_, err = man.RenderInstance("snapshot", "vol")
+	assert.NotNil(err)
+}
@@ -0,0 +1,252 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+const (
+	recordGCLock = "record-gc"
+
+	recordVolumeEvents    = "volumeEvents"
+	recordVolumeHistory   = "volumeHistory"
+	recordSettingsHistory = "settingsHistory"
+)
+
+var (
+	// RecordGCInterval is how often a manager runs the record GC, unless
+	// another one is at it, 0 to never
+	RecordGCInterval = 10 * time.Minute
+
+	// DefaultRecordRetentionSucceeded and DefaultRecordRetentionFailed are
+	// used if the recordRetentionSucceeded and recordRetentionFailed settings
+	// are empty
+	DefaultRecordRetentionSucceeded = 7 * 24 * time.Hour
+	DefaultRecordRetentionFailed    = 30 * 24 * time.Hour
+)
+
+// recordGCKey counts the records removed by kind and outcome
+type recordGCKey struct {
+	record  string
+	outcome types.RecordOutcome
+}
+
+// recordRetention is how long the records are kept by outcome, 0 for no
+// limit
+type recordRetention map[types.RecordOutcome]time.Duration
+
+func (man *volumeManager) recordRetention() (recordRetention, error) {
+	settings, err := man.settings.GetSettings()
+	if err != nil || settings == nil {
+		return nil, errors.Wrap(err, "unable to read settings")
+	}
+	retention := recordRetention{
+		types.RecordOutcomeSucceeded: DefaultRecordRetentionSucceeded,
+		types.RecordOutcomeFailed:    DefaultRecordRetentionFailed,
+	}
+	for outcome, value := range map[types.RecordOutcome]string{
+		types.RecordOutcomeSucceeded: settings.RecordRetentionSucceeded,
+		types.RecordOutcomeFailed:    settings.RecordRetentionFailed,
+	} {
+		if value == "" {
+			continue
+		}
+		age, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid record retention setting of the %v records", outcome)
+		}
+		retention[outcome] = age
+	}
+	return retention, nil
+}
+
+// expired is true if the record of the outcome written at t is older than
+// its retention. The records of an unknown time are never expired.
+func (r recordRetention) expired(outcome types.RecordOutcome, t string, now time.Time) bool {
+	age := r[outcome]
+	if age <= 0 {
+		return false
+	}
+	written, err := util.ParseTime(t)
+	if err != nil {
+		return false
+	}
+	return now.Sub(written) > age
+}
+
+// eventsOutcome is failed for a batch with a warning or an error, and its
+// time is of its latest event
+func eventsOutcome(events []*types.VolumeEvent) (types.RecordOutcome, string) {
+	outcome, latest := types.RecordOutcomeSucceeded, ""
+	for _, e := range events {
+		if e.Severity.Rank() > types.EventSeverityInfo.Rank() {
+			outcome = types.RecordOutcomeFailed
+		}
+		if e.Time > latest {
+			latest = e.Time
+		}
+	}
+	return outcome, latest
+}
+
+func transitionOutcome(t *types.StateTransition) types.RecordOutcome {
+	if t.To == types.VolumeStateFaulted {
+		return types.RecordOutcomeFailed
+	}
+	return types.RecordOutcomeSucceeded
+}
+
+// resumableWorkflow returns the workflow of the volume which may still be
+// resumed, "" if none. Its records are left to tell how it went.
+func resumableWorkflow(volume *types.VolumeInfo) string {
+	switch {
+	case volume.Creating:
+		return "creation"
+	case volume.Conversion != nil:
+		return "conversion"
+	case volume.Migration != nil && volume.Migration.State == types.MigrationStateRunning:
+		return "controller migration"
+	case volume.SalvageRequired:
+		return "salvage"
+	}
+	return ""
+}
+
+// gcRecords removes the volume events, the state transitions and the
+// settings revisions older than their retention, except those kept and the
+// records of the volumes with a resumable workflow. It does nothing while
+// another manager is at it, and returns the records removed.
+func (man *volumeManager) gcRecords(now time.Time) (map[recordGCKey]int64, error) {
+	retention, err := man.recordRetention()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to garbage collect records")
+	}
+	lock, err := man.acquireLock(recordGCLock, "")
+	if err != nil {
+		if _, ok := err.(*types.ErrLockHeld); ok {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "unable to garbage collect records")
+	}
+	defer lock.release()
+
+	removed := map[recordGCKey]int64{}
+	defer func() {
+		man.Lock()
+		defer man.Unlock()
+		if man.recordsRemoved == nil {
+			man.recordsRemoved = map[recordGCKey]int64{}
+		}
+		for key, count := range removed {
+			man.recordsRemoved[key] += count
+		}
+	}()
+
+	volumes, err := man.orc.ListVolumes()
+	if err != nil {
+		return removed, errors.Wrap(err, "unable to garbage collect records")
+	}
+	failed := []string{}
+	for _, volume := range volumes {
+		if workflow := resumableWorkflow(volume); workflow != "" {
+			logrus.Debugf("Keeping the records of volume %v, its %v may be resumed", volume.Name, workflow)
+			continue
+		}
+		batches, err := man.orc.PruneVolumeEvents(volume.Name, func(events []*types.VolumeEvent) bool {
+			outcome, t := eventsOutcome(events)
+			return retention.expired(outcome, t, now)
+		})
+		for _, events := range batches {
+			outcome, _ := eventsOutcome(events)
+			removed[recordGCKey{recordVolumeEvents, outcome}] += int64(len(events))
+		}
+		if err != nil {
+			logrus.Warnf("%v", err)
+			failed = append(failed, volume.Name)
+			continue
+		}
+		transitions, err := man.orc.PruneVolumeHistory(volume.Name, func(t *types.StateTransition) bool {
+			return retention.expired(transitionOutcome(t), t.Time, now)
+		})
+		if err != nil {
+			logrus.Warnf("%v", err)
+			failed = append(failed, volume.Name)
+			continue
+		}
+		for i := range transitions {
+			removed[recordGCKey{recordVolumeHistory, transitionOutcome(&transitions[i])}]++
+		}
+	}
+
+	revisions, err := man.settings.PruneSettingsHistory(func(r *types.SettingsRevision) bool {
+		return retention.expired(types.RecordOutcomeSucceeded, r.Time, now)
+	})
+	if err != nil {
+		return removed, errors.Wrap(err, "unable to garbage collect records")
+	}
+	removed[recordGCKey{recordSettingsHistory, types.RecordOutcomeSucceeded}] += int64(len(revisions))
+
+	total := int64(0)
+	for _, count := range removed {
+		total += count
+	}
+	if total != 0 {
+		logrus.Infof("Garbage collected %v records", total)
+	}
+	if len(failed) != 0 {
+		return removed, errors.Errorf("unable to garbage collect the records of volumes %v", failed)
+	}
+	return removed, nil
+}
+
+func (man *volumeManager) recordGC(ctx context.Context) error {
+	if RecordGCInterval <= 0 {
+		return nil
+	}
+	return runner.Tick(ctx, RecordGCInterval, func() {
+		if _, err := man.gcRecords(time.Now()); err != nil {
+			logrus.Warnf("%v", err)
+		}
+	})
+}
+
+// KeepVolumeEvents sets if the batch of events of the volume is exempt from
+// the limit of the events and the record GC
+func (man *volumeManager) KeepVolumeEvents(name, batch string, keep bool) error {
+	if err := man.orc.KeepVolumeEvents(name, batch, keep); err != nil {
+		return errors.Wrapf(err, "failed to keep events of volume '%s'", name)
+	}
+	return nil
+}
+
+// KeepVolumeState sets if the state transition of the volume is exempt from
+// the limit of the history and the record GC
+func (man *volumeManager) KeepVolumeState(name string, seq int64, keep bool) error {
+	if err := man.orc.KeepVolumeState(name, seq, keep); err != nil {
+		return errors.Wrapf(err, "failed to keep state transition of volume '%s'", name)
+	}
+	return nil
+}
+
+func (man *volumeManager) VolumeEventCounts(name string) (*types.RecordCounts, error) {
+	counts, err := man.orc.VolumeEventCounts(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to count events of volume '%s'", name)
+	}
+	return counts, nil
+}
+
+func (man *volumeManager) VolumeHistoryCounts(name string) (*types.RecordCounts, error) {
+	counts, err := man.orc.VolumeHistoryCounts(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to count state transitions of volume '%s'", name)
+	}
+	return counts, nil
+}
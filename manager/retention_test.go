@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+func TestGCRecords(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2")
+	man, _ := newTestManager(orc)
+	for _, name := range []string{"vol", "vol-converting"} {
+		_, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 2})
+		assert.Nil(err)
+	}
+	orc.Lock()
+	orc.volumes["vol-converting"].Conversion = &types.VolumeConversion{TargetMode: types.VolumeModeLocal, TargetReplicas: 1}
+	orc.Unlock()
+
+	now := time.Now()
+	daysAgo := func(days int) string {
+		return util.FormatTimeZ(now.Add(-time.Duration(days) * 24 * time.Hour))
+	}
+	newEvent := func(severity types.EventSeverity, days int) *types.VolumeEvent {
+		return &types.VolumeEvent{Severity: severity, Reason: "Test", Time: daysAgo(days)}
+	}
+	for _, name := range []string{"vol", "vol-converting"} {
+		assert.Nil(orc.DeleteVolumeEvents(name))
+		// succeeded and expired, failed but not expired yet, failed and
+		// expired, then kept
+		assert.Nil(orc.AppendVolumeEvents(name, []*types.VolumeEvent{newEvent(types.EventSeverityInfo, 10)}))
+		assert.Nil(orc.AppendVolumeEvents(name, []*types.VolumeEvent{
+			newEvent(types.EventSeverityInfo, 12), newEvent(types.EventSeverityWarning, 10),
+		}))
+		assert.Nil(orc.AppendVolumeEvents(name, []*types.VolumeEvent{newEvent(types.EventSeverityError, 40)}))
+		assert.Nil(orc.AppendVolumeEvents(name, []*types.VolumeEvent{newEvent(types.EventSeverityInfo, 40)}))
+
+		orc.Lock()
+		orc.history[name] = []types.StateTransition{
+			{Seq: 1, Time: daysAgo(40), To: types.VolumeStateCreating, Keep: true},
+			{Seq: 2, Time: daysAgo(10), From: types.VolumeStateCreating, To: types.VolumeStateHealthy},
+			{Seq: 3, Time: daysAgo(10), From: types.VolumeStateHealthy, To: types.VolumeStateFaulted},
+			{Seq: 4, Time: daysAgo(40), From: types.VolumeStateFaulted, To: types.VolumeStateDetached},
+		}
+		orc.Unlock()
+	}
+	events, err := man.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Len(events, 5)
+	assert.Nil(man.KeepVolumeEvents("vol", events[4].Batch, true))
+	assert.NotNil(man.KeepVolumeEvents("vol", "none", true))
+
+	assert.Nil(orc.UpdateSettings("admin", func(si *types.SettingsInfo) error {
+		si.BackupTarget = "nfs://1.2.3.4:/test"
+		return nil
+	}))
+	assert.Nil(orc.UpdateSettings("admin", func(si *types.SettingsInfo) error {
+		si.BackupTarget = ""
+		return nil
+	}))
+	orc.Lock()
+	for _, r := range orc.settingsHistory {
+		r.Time = daysAgo(10)
+	}
+	orc.Unlock()
+
+	removed, err := man.gcRecords(now)
+	assert.Nil(err)
+	assert.Equal(map[recordGCKey]int64{
+		{recordVolumeEvents, types.RecordOutcomeSucceeded}:    1,
+		{recordVolumeEvents, types.RecordOutcomeFailed}:       1,
+		{recordVolumeHistory, types.RecordOutcomeSucceeded}:   1,
+		{recordSettingsHistory, types.RecordOutcomeSucceeded}: 1,
+	}, removed)
+
+	events, err = man.ListVolumeEvents("vol")
+	assert.Nil(err)
+	assert.Len(events, 3)
+	assert.Equal(types.EventSeverityWarning, events[1].Severity)
+	assert.True(events[2].Keep)
+	counts, err := man.VolumeEventCounts("vol")
+	assert.Nil(err)
+	assert.Equal(&types.RecordCounts{Total: 5, Retained: 3}, counts)
+
+	// the kept, the failed not expired yet, and the latest one
+	history, err := man.GetVolumeHistory("vol", 0)
+	assert.Nil(err)
+	assert.Len(history, 3)
+	assert.Equal(int64(1), history[0].Seq)
+	assert.Equal(int64(3), history[1].Seq)
+	assert.Equal(int64(4), history[2].Seq)
+	counts, err = man.VolumeHistoryCounts("vol")
+	assert.Nil(err)
+	assert.Equal(&types.RecordCounts{Total: 4, Retained: 3}, counts)
+
+	// the records of a resumable workflow are left
+	events, err = man.ListVolumeEvents("vol-converting")
+	assert.Nil(err)
+	assert.Len(events, 5)
+	history, err = man.GetVolumeHistory("vol-converting", 0)
+	assert.Nil(err)
+	assert.Len(history, 4)
+
+	families := map[string]*types.MetricFamily{}
+	for _, f := range man.Metrics() {
+		families[f.Name] = f
+	}
+	assert.NotNil(families[MetricRecordsRemoved])
+	assert.Equal(types.MetricTypeCounter, families[MetricRecordsRemoved].Type)
+	assert.Equal(&types.MetricSample{
+		Labels: map[string]string{"record": recordSettingsHistory, "outcome": string(types.RecordOutcomeSucceeded)},
+		Value:  1,
+	}, families[MetricRecordsRemoved].Samples[0])
+
+	// no limit for the failed records
+	settings, err := orc.GetSettings()
+	assert.Nil(err)
+	settings.RecordRetentionFailed = "0"
+	assert.Nil(orc.SetSettings(settings))
+	orc.Lock()
+	orc.volumes["vol-converting"].Conversion = nil
+	orc.Unlock()
+	removed, err = man.gcRecords(now)
+	assert.Nil(err)
+	assert.Equal(int64(2), removed[recordGCKey{recordVolumeEvents, types.RecordOutcomeSucceeded}])
+	assert.Equal(int64(0), removed[recordGCKey{recordVolumeEvents, types.RecordOutcomeFailed}])
+	events, err = man.ListVolumeEvents("vol-converting")
+	assert.Nil(err)
+	assert.Len(events, 3)
+}
@@ -0,0 +1,203 @@
+package manager
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/rancher/longhorn-manager/orch"
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var restartPolicies = []string{
+	string(types.RestartPolicyNo),
+	string(types.RestartPolicyUnlessStopped),
+	string(types.RestartPolicyAlways),
+}
+
+var settingDefinitions = []types.SettingDefinition{
+	{
+		Name:        "backupTarget",
+		Type:        types.SettingTypeString,
+		Description: "The backup target URL, e.g. s3://bucket@region/path or nfs://server:/path. Backups are disabled if empty",
+	},
+	{
+		Name:        "engineImage",
+		Type:        types.SettingTypeString,
+		Description: "The engine image of the new volumes, set from --engine-image at startup",
+	},
+	{
+		Name:        "rehomeWindow",
+		Type:        types.SettingTypeTimeWindow,
+		Description: "The maintenance window for moving the volumes to their preferred hosts, e.g. 02:00-04:00",
+	},
+	{
+		Name:        "rehomeWindowLive",
+		Type:        types.SettingTypeBool,
+		Default:     "false",
+		Description: "Move the attached volumes to their preferred hosts in the rehome window",
+	},
+	{
+		Name:        "autoReattach",
+		Type:        types.SettingTypeEnum,
+		Default:     string(types.AutoReattachPolicyDisabled),
+		Description: "Reattach the volumes after their controller failed, unless set on the volume",
+		Options: []string{
+			string(types.AutoReattachPolicyDisabled),
+			string(types.AutoReattachPolicyAlways),
+			string(types.AutoReattachPolicyIfClean),
+		},
+	},
+	{
+		Name:        "snapshotMaxCount",
+		Type:        types.SettingTypeInt,
+		Default:     "0",
+		Description: "The maximum number of snapshots of a volume, unless set on the volume. 0 for unlimited",
+		Validation:  ">= 0",
+	},
+	{
+		Name:        "snapshotMaxAge",
+		Type:        types.SettingTypeDuration,
+		Description: "The snapshots older than this are pruned, unless set on the volume. Unlimited if empty",
+	},
+	{
+		Name:        "snapshotPruneStrategy",
+		Type:        types.SettingTypeEnum,
+		Default:     string(types.SnapshotPruneAfterCreate),
+		Description: "Prune the snapshots over snapshotMaxCount before or after creating a new one",
+		Options: []string{
+			string(types.SnapshotPruneBeforeCreate),
+			string(types.SnapshotPruneAfterCreate),
+		},
+	},
+	{
+		Name:        "clockSkewThreshold",
+		Type:        types.SettingTypeDuration,
+		Default:     DefaultClockSkewThreshold.String(),
+		Description: "The clock difference between hosts reported as skew",
+		Validation:  "> 0",
+	},
+	{
+		Name:        "autoDetach",
+		Type:        types.SettingTypeBool,
+		Default:     "false",
+		Description: "Detach the volumes without I/O and not mounted for autoDetachTimeout",
+	},
+	{
+		Name:        "autoDetachTimeout",
+		Type:        types.SettingTypeDuration,
+		Default:     DefaultAutoDetachTimeout.String(),
+		Description: "How long a volume stays idle before it's detached",
+		Validation:  "> 0",
+	},
+	{
+		Name:        "replicaRestartPolicy",
+		Type:        types.SettingTypeEnum,
+		Default:     string(types.RestartPolicyUnlessStopped),
+		Description: "The restart policy of the replica containers when the docker daemon restarts",
+		Options:     restartPolicies,
+	},
+	{
+		Name:        "controllerRestartPolicy",
+		Type:        types.SettingTypeEnum,
+		Default:     string(types.RestartPolicyNo),
+		Description: "The restart policy of the controller containers when the docker daemon restarts",
+		Options:     restartPolicies,
+	},
+	{
+		Name:        "replicaQuota",
+		Type:        types.SettingTypeBool,
+		Default:     "false",
+		Description: "Mark bad the replicas allocating more than the volume size plus replicaQuotaOverhead, they are rebuilt elsewhere",
+	},
+	{
+		Name:        "replicaQuotaOverhead",
+		Type:        types.SettingTypeInt,
+		Default:     "0",
+		Description: "The percentage of the volume size a replica may allocate on top of it, e.g. for snapshots. 0 for 100",
+		Validation:  ">= 0",
+	},
+	{
+		Name:        "instanceEnv",
+		Type:        types.SettingTypeMap,
+		Description: "The environment variables of the new controller and replica containers, e.g. {\"HTTPS_PROXY\": \"http://proxy:3128\"}. A volume and an attach request can override them. The running containers keep their environment",
+		Validation:  "environment variable names matching " + strings.Join(util.EnvNamePatterns, " "),
+	},
+	{
+		Name:        "controllerRecreate",
+		Type:        types.SettingTypeBool,
+		Default:     "false",
+		Description: "Recreate a controller unresponsive for controllerUnresponsiveThreshold on the same host if its replicas are healthy, backing off between the attempts",
+	},
+	{
+		Name:        "controllerUnresponsiveThreshold",
+		Type:        types.SettingTypeDuration,
+		Default:     DefaultControllerUnresponsiveThreshold.String(),
+		Description: "How long a controller stays unresponsive before it's recreated",
+		Validation:  "> 0",
+	},
+	{
+		Name:        "backupCompression",
+		Type:        types.SettingTypeEnum,
+		Default:     string(DefaultBackupCompression),
+		Description: "The compression of the backups, unless set on the volume. The backups are restored with the compression they were taken with",
+		Options: []string{
+			string(types.BackupCompressionNone),
+			string(types.BackupCompressionLZ4),
+			string(types.BackupCompressionGzip),
+		},
+	},
+	{
+		Name:        "healthcheckInterval",
+		Type:        types.SettingTypeDuration,
+		Default:     orch.DefaultHealthcheckInterval.String(),
+		Description: "How often the new controller and replica containers ping their engine. The engines older than " + orch.HealthcheckVersion.String() + " get no healthcheck",
+		Validation:  "> 0",
+	},
+	{
+		Name:        "healthcheckRetries",
+		Type:        types.SettingTypeInt,
+		Default:     strconv.Itoa(orch.DefaultHealthcheckRetries),
+		Description: "The failed pings in a row after which a container is unhealthy, its replica marked bad or its controller handled as failed. 0 for the default",
+		Validation:  ">= 0",
+	},
+	{
+		Name:        "backupConcurrencyLimit",
+		Type:        types.SettingTypeInt,
+		Default:     "0",
+		Description: "The backups running at the same time in the cluster, the others wait for their turn. 0 for unlimited",
+		Validation:  ">= 0",
+	},
+	{
+		Name:        "backupTargetConcurrencyLimit",
+		Type:        types.SettingTypeInt,
+		Default:     "0",
+		Description: "The backups running at the same time to each backup target, the others wait for their turn. 0 for unlimited",
+		Validation:  ">= 0",
+	},
+	{
+		Name:        "recordRetentionSucceeded",
+		Type:        types.SettingTypeDuration,
+		Default:     DefaultRecordRetentionSucceeded.String(),
+		Description: "The volume events, state transitions and settings revisions older than this are removed, unless kept. 0 to remove them only beyond the limit of their list",
+		Validation:  ">= 0",
+	},
+	{
+		Name:        "recordRetentionFailed",
+		Type:        types.SettingTypeDuration,
+		Default:     DefaultRecordRetentionFailed.String(),
+		Description: "The same for the warning and error events and the transitions to faulted, kept longer for debugging",
+		Validation:  ">= 0",
+	},
+}
+
+// ListSettingsDefinitions describes all the settings, in the order of
+// SettingsInfo
+func (man *volumeManager) ListSettingsDefinitions() ([]types.SettingDefinition, error) {
+	definitions := []types.SettingDefinition{}
+	for _, d := range settingDefinitions {
+		d.Options = append([]string{}, d.Options...)
+		definitions = append(definitions, d)
+	}
+	return definitions, nil
+}
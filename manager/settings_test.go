@@ -0,0 +1,48 @@
+package manager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestSettingsDefinitions(t *testing.T) {
+	assert := require.New(t)
+
+	man, _ := newTestManager(newFakeOrc("host-1"))
+	definitions, err := man.ListSettingsDefinitions()
+	assert.Nil(err)
+
+	byName := map[string]types.SettingDefinition{}
+	for _, d := range definitions {
+		_, dup := byName[d.Name]
+		assert.False(dup, d.Name)
+		assert.NotEmpty(d.Description, d.Name)
+		if d.Type == types.SettingTypeEnum {
+			assert.NotEmpty(d.Options, d.Name)
+			assert.Contains(d.Options, d.Default, d.Name)
+		} else {
+			assert.Empty(d.Options, d.Name)
+		}
+		byName[d.Name] = d
+	}
+
+	// every field of SettingsInfo is defined
+	st := reflect.TypeOf(types.SettingsInfo{})
+	for i := 0; i < st.NumField(); i++ {
+		name := strings.Split(st.Field(i).Tag.Get("json"), ",")[0]
+		assert.Contains(byName, name)
+	}
+	assert.Len(definitions, st.NumField())
+
+	assert.Equal(DefaultAutoDetachTimeout.String(), byName["autoDetachTimeout"].Default)
+	assert.Equal(DefaultClockSkewThreshold.String(), byName["clockSkewThreshold"].Default)
+	assert.Equal(string(types.RestartPolicyUnlessStopped), byName["replicaRestartPolicy"].Default)
+	assert.Equal(string(types.RestartPolicyNo), byName["controllerRestartPolicy"].Default)
+	assert.Equal(string(types.AutoReattachPolicyDisabled), byName["autoReattach"].Default)
+	assert.Equal("", byName["backupTarget"].Default)
+}
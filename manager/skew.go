@@ -0,0 +1,293 @@
+package manager
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/scheduler"
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/runner"
+)
+
+var (
+	HostHeartbeatPeriod = time.Second * 10
+	// HostOfflineTimeout without a new heartbeat marks the host offline
+	HostOfflineTimeout = HostHeartbeatPeriod * 3
+
+	// DefaultClockSkewThreshold is used if the clockSkewThreshold setting
+	// is empty
+	DefaultClockSkewThreshold = time.Second * 30
+)
+
+const (
+	EventReasonClockSkewed      = "ClockSkewed"
+	EventReasonClockSkewCleared = "ClockSkewCleared"
+
+	MetricHostsClockSkewed = "longhorn_hosts_clock_skewed"
+)
+
+type hostClock struct {
+	heartbeat string
+	skew      time.Duration
+	skewed    bool
+	seen      time.Time // by the current host clock, when heartbeat changed
+}
+
+// skewChange is a host entering or leaving the skewed state
+type skewChange struct {
+	hostID string
+	skew   time.Duration
+	skewed bool
+}
+
+// clockSkewDetector estimates the clock skew of every host against the clock
+// of the current host, from the time a new heartbeat of the host is observed.
+// The error of the estimation is bounded by HostHeartbeatPeriod.
+type clockSkewDetector struct {
+	sync.Mutex
+
+	now   func() time.Time
+	hosts map[string]*hostClock
+}
+
+func newClockSkewDetector(now func() time.Time) *clockSkewDetector {
+	return &clockSkewDetector{
+		now:   now,
+		hosts: map[string]*hostClock{},
+	}
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// observe updates the skew of the hosts with new heartbeats. The first
+// heartbeat seen of a host only records the baseline, since it may have been
+// written long ago. If most of the other hosts look skewed, it's the clock of
+// the current host which is off. It returns the hosts entering or leaving
+// the skewed state.
+func (d *clockSkewDetector) observe(hosts map[string]*types.HostInfo, currentHostID string, threshold time.Duration) []skewChange {
+	d.Lock()
+	defer d.Unlock()
+
+	now := d.now()
+	for id := range d.hosts {
+		if hosts[id] == nil {
+			delete(d.hosts, id)
+		}
+	}
+	skews := []time.Duration{}
+	for id, host := range hosts {
+		if id == currentHostID || host.Heartbeat == "" {
+			continue
+		}
+		c := d.hosts[id]
+		if c == nil {
+			d.hosts[id] = &hostClock{heartbeat: host.Heartbeat, seen: now}
+			continue
+		}
+		if c.heartbeat != host.Heartbeat {
+			c.seen = now
+			t, err := util.ParseTime(host.Heartbeat)
+			if err != nil {
+				logrus.Warnf("invalid heartbeat %v of host %v: %v", host.Heartbeat, id, err)
+				continue
+			}
+			c.heartbeat = host.Heartbeat
+			c.skew = t.Sub(now)
+		}
+		skews = append(skews, c.skew)
+	}
+
+	selfSkewed := false
+	current := d.hosts[currentHostID]
+	if current == nil {
+		current = &hostClock{}
+		d.hosts[currentHostID] = current
+	}
+	current.skew = 0
+	current.seen = now
+	if len(skews) >= 2 {
+		skewed := 0
+		for _, skew := range skews {
+			if absDuration(skew) > threshold {
+				skewed++
+			}
+		}
+		if skewed*2 > len(skews) {
+			selfSkewed = true
+			sort.Slice(skews, func(i, j int) bool { return skews[i] < skews[j] })
+			current.skew = -skews[len(skews)/2]
+		}
+	}
+
+	changes := []skewChange{}
+	for id, c := range d.hosts {
+		skewed := absDuration(c.skew) > threshold && !selfSkewed
+		if id == currentHostID {
+			skewed = selfSkewed
+		}
+		if skewed && !c.skewed {
+			logrus.Warnf("host %v clock skew detected: %v, threshold %v", id, c.skew, threshold)
+		} else if !skewed && c.skewed {
+			logrus.Infof("host %v clock skew cleared: %v, threshold %v", id, c.skew, threshold)
+		}
+		if skewed != c.skewed {
+			changes = append(changes, skewChange{hostID: id, skew: c.skew, skewed: skewed})
+		}
+		c.skewed = skewed
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].hostID < changes[j].hostID })
+	return changes
+}
+
+func (d *clockSkewDetector) skewed(hostID string) bool {
+	d.Lock()
+	defer d.Unlock()
+	c := d.hosts[hostID]
+	return c != nil && c.skewed
+}
+
+// families returns the count of the skewed hosts, none before the first
+// observation
+func (d *clockSkewDetector) families() []*types.MetricFamily {
+	d.Lock()
+	defer d.Unlock()
+	if len(d.hosts) == 0 {
+		return nil
+	}
+	skewed := 0
+	for _, c := range d.hosts {
+		if c.skewed {
+			skewed++
+		}
+	}
+	return []*types.MetricFamily{{
+		Name:    MetricHostsClockSkewed,
+		Help:    "The hosts whose clock is skewed beyond the clockSkewThreshold setting",
+		Type:    types.MetricTypeGauge,
+		Samples: []*types.MetricSample{{Value: float64(skewed)}},
+	}}
+}
+
+// online reports if a new heartbeat of the host was seen within timeout
+func (d *clockSkewDetector) online(hostID string, timeout time.Duration) bool {
+	d.Lock()
+	defer d.Unlock()
+	c := d.hosts[hostID]
+	return c != nil && d.now().Sub(c.seen) <= timeout
+}
+
+// annotate sets the clock skew of the host, and what's left of its
+// recovery cooldown by its clock
+func (d *clockSkewDetector) annotate(host *types.HostInfo) {
+	if host == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	if c := d.hosts[host.UUID]; c != nil {
+		host.ClockSkew = c.skew
+		host.SkewDetected = c.skewed
+	}
+	host.RecoveryCooldown = scheduler.RecoveryCooldown(host, d.now().Add(host.ClockSkew))
+}
+
+func (man *volumeManager) clockSkewThreshold() (time.Duration, error) {
+	settings, err := man.settings.GetSettings()
+	if err != nil || settings == nil {
+		return 0, errors.Wrap(err, "unable to read settings")
+	}
+	if settings.ClockSkewThreshold == "" {
+		return DefaultClockSkewThreshold, nil
+	}
+	threshold, err := time.ParseDuration(settings.ClockSkewThreshold)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid clockSkewThreshold setting")
+	}
+	return threshold, nil
+}
+
+func (man *volumeManager) checkClockSkew() error {
+	threshold, err := man.clockSkewThreshold()
+	if err != nil {
+		return err
+	}
+	hosts, err := man.orc.ListHosts()
+	if err != nil {
+		return errors.Wrap(err, "unable to list hosts")
+	}
+	changes := man.clocks.observe(hosts, man.orc.GetCurrentHostID(), threshold)
+	if len(changes) == 0 {
+		return nil
+	}
+	return man.recordSkewChanges(changes, threshold)
+}
+
+// recordSkewChanges records the hosts entering or leaving the skewed state
+// as events of the volumes with an instance on them. Every manager observes
+// the clocks, so only the volumes with their controller on the current host
+// are recorded here, each by one manager.
+func (man *volumeManager) recordSkewChanges(changes []skewChange, threshold time.Duration) error {
+	currentHostID := man.orc.GetCurrentHostID()
+	return man.orc.ForEachVolume("", func(volume *types.VolumeInfo) error {
+		if volume.Controller == nil || volume.Controller.HostID != currentHostID {
+			return nil
+		}
+		for _, change := range changes {
+			if !volumeOnHost(volume, change.hostID) {
+				continue
+			}
+			if change.skewed {
+				man.events.record(volume.Name, types.EventSeverityWarning, EventReasonClockSkewed,
+					"host %v clock skewed by %v, beyond threshold %v, its timestamps are no longer trusted",
+					change.hostID, change.skew, threshold)
+			} else {
+				man.events.record(volume.Name, types.EventSeverityInfo, EventReasonClockSkewCleared,
+					"host %v clock skew cleared, skewed by %v within threshold %v", change.hostID, change.skew, threshold)
+			}
+		}
+		return nil
+	})
+}
+
+// beat records the heartbeat of the current host, unless it's deregistered
+func (man *volumeManager) beat() error {
+	man.heartbeatLock.Lock()
+	defer man.heartbeatLock.Unlock()
+	if man.deregistered {
+		return nil
+	}
+	return man.orc.Heartbeat()
+}
+
+// deregister removes the current host, no heartbeat adds it back afterwards
+func (man *volumeManager) deregister() error {
+	man.heartbeatLock.Lock()
+	defer man.heartbeatLock.Unlock()
+	if err := man.orc.Deregister(); err != nil {
+		return err
+	}
+	man.deregistered = true
+	return nil
+}
+
+func (man *volumeManager) heartbeat(ctx context.Context) error {
+	return runner.Tick(ctx, HostHeartbeatPeriod, func() {
+		if err := man.beat(); err != nil {
+			logrus.Warnf("%v", err)
+		}
+		if err := man.checkClockSkew(); err != nil {
+			logrus.Warnf("%v", errors.Wrap(err, "error checking clock skew"))
+		}
+	})
+}
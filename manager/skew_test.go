@@ -0,0 +1,182 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newSkewTestManager(orc *fakeOrc) (*volumeManager, *fakeClock) {
+	man, _ := newTestManager(orc)
+	clock := &fakeClock{now: time.Date(2017, 5, 1, 0, 0, 0, 0, time.UTC)}
+	man.clocks = newClockSkewDetector(clock.Now)
+	return man, clock
+}
+
+// heartbeats advances the clock and lets the hosts send heartbeats with the
+// given skews
+func heartbeats(orc *fakeOrc, clock *fakeClock, skews map[string]time.Duration) {
+	clock.now = clock.now.Add(HostHeartbeatPeriod)
+	orc.Lock()
+	defer orc.Unlock()
+	for id, host := range orc.hosts {
+		host.Heartbeat = util.FormatTimeZ(clock.now.Add(skews[id]))
+	}
+}
+
+func TestClockSkewDetection(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, clock := newSkewTestManager(orc)
+	skewedHosts := func() float64 {
+		for _, f := range man.Metrics() {
+			if f.Name == MetricHostsClockSkewed {
+				return f.Samples[0].Value
+			}
+		}
+		return -1
+	}
+	assert.Equal(float64(-1), skewedHosts())
+
+	skews := map[string]time.Duration{"host-3": 2 * time.Minute}
+	// the first heartbeat is only the baseline
+	heartbeats(orc, clock, skews)
+	assert.Nil(man.checkClockSkew())
+	hosts, err := man.ListHosts()
+	assert.Nil(err)
+	assert.False(hosts["host-3"].SkewDetected)
+	assert.Equal(float64(0), skewedHosts())
+
+	heartbeats(orc, clock, skews)
+	assert.Nil(man.checkClockSkew())
+	hosts, err = man.ListHosts()
+	assert.Nil(err)
+	assert.False(hosts["host-1"].SkewDetected)
+	assert.False(hosts["host-2"].SkewDetected)
+	assert.True(hosts["host-3"].SkewDetected)
+	assert.Equal(2*time.Minute, hosts["host-3"].ClockSkew)
+	assert.Equal(float64(1), skewedHosts())
+
+	heartbeats(orc, clock, nil)
+	assert.Nil(man.checkClockSkew())
+	host, err := man.GetHost("host-3")
+	assert.Nil(err)
+	assert.False(host.SkewDetected)
+	assert.Equal(float64(0), skewedHosts())
+
+	// most of the other hosts disagree, the current host is skewed
+	skews = map[string]time.Duration{"host-2": -time.Minute, "host-3": -time.Minute}
+	heartbeats(orc, clock, skews)
+	assert.Nil(man.checkClockSkew())
+	hosts, err = man.ListHosts()
+	assert.Nil(err)
+	assert.True(hosts["host-1"].SkewDetected)
+	assert.Equal(time.Minute, hosts["host-1"].ClockSkew)
+	assert.False(hosts["host-2"].SkewDetected)
+	assert.False(hosts["host-3"].SkewDetected)
+
+	orc.settings.ClockSkewThreshold = "2m"
+	assert.Nil(man.checkClockSkew())
+	host, err = man.GetHost("host-1")
+	assert.Nil(err)
+	assert.False(host.SkewDetected)
+}
+
+func TestClockSkewEvents(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, clock := newSkewTestManager(orc)
+	for _, name := range []string{"vol-1", "vol-2"} {
+		_, err := man.Create(&types.VolumeInfo{Name: name, Size: 4096, NumberOfReplicas: 1})
+		assert.Nil(err)
+		assert.Nil(man.Attach(name))
+	}
+	orc.Lock()
+	for _, r := range orc.volumes["vol-1"].Replicas {
+		r.HostID = "host-3"
+	}
+	for _, r := range orc.volumes["vol-2"].Replicas {
+		r.HostID = "host-2"
+	}
+	orc.Unlock()
+
+	skews := map[string]time.Duration{"host-3": 2 * time.Minute}
+	for i := 0; i < 3; i++ {
+		heartbeats(orc, clock, skews)
+		assert.Nil(man.checkClockSkew())
+	}
+	heartbeats(orc, clock, nil)
+	assert.Nil(man.checkClockSkew())
+
+	man.events.flush()
+	reasons := func(name string) []string {
+		events, err := orc.ListVolumeEvents(name)
+		assert.Nil(err)
+		reasons := []string{}
+		for _, e := range events {
+			if e.Reason == EventReasonClockSkewed || e.Reason == EventReasonClockSkewCleared {
+				reasons = append(reasons, e.Reason)
+			}
+		}
+		return reasons
+	}
+	// only the volume with an instance on the host
+	assert.Equal([]string{EventReasonClockSkewed, EventReasonClockSkewCleared}, reasons("vol-1"))
+	assert.Empty(reasons("vol-2"))
+}
+
+func TestCleanupSkewedBadTimestamp(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, clock := newSkewTestManager(orc)
+
+	skews := map[string]time.Duration{"host-2": time.Hour}
+	for i := 0; i < 2; i++ {
+		heartbeats(orc, clock, skews)
+		assert.Nil(man.checkClockSkew())
+	}
+	assert.True(man.clocks.skewed("host-2"))
+
+	volume, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	volume, err = man.Get(volume.Name)
+	assert.Nil(err)
+	assert.Len(volume.Replicas, 2)
+
+	// both look old enough to be removed, but only one timestamp is trusted
+	stamp := util.FormatTimeZ(time.Now().Add(-KeepBadReplicasPeriod - time.Hour))
+	badHosts := []string{"host-2", "host-3"}
+	names := []string{}
+	orc.Lock()
+	for _, r := range orc.volumes[volume.Name].Replicas {
+		r.BadTimestamp = stamp
+		r.BadHostID = badHosts[len(names)]
+		names = append(names, r.Name)
+	}
+	orc.Unlock()
+
+	assert.Nil(man.Cleanup(volume))
+
+	volume, err = man.Get(volume.Name)
+	assert.Nil(err)
+	assert.Len(volume.Replicas, 1)
+	restamped := volume.Replicas[names[0]]
+	assert.NotNil(restamped)
+	assert.Equal("host-1", restamped.BadHostID)
+	assert.NotEqual(stamp, restamped.BadTimestamp)
+}
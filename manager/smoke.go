@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// SmokeTestVolumePrefix names the volumes of the smoke tests, other
+	// volumes cannot use it
+	SmokeTestVolumePrefix = "longhorn-smoke-"
+	SmokeTestVolumeSize   = int64(16 * 1024 * 1024)
+	SmokeTestPatternSize  = 4096
+
+	// writeReadDevice writes data at the start of the device, and reads it
+	// back
+	writeReadDevice = func(device string, data []byte) ([]byte, error) {
+		f, err := os.OpenFile(device, os.O_RDWR|os.O_SYNC, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if _, err := f.WriteAt(data, 0); err != nil {
+			return nil, err
+		}
+		read := make([]byte, len(data))
+		if _, err := f.ReadAt(read, 0); err != nil {
+			return nil, err
+		}
+		return read, nil
+	}
+)
+
+// SmokeTest provisions a tiny volume on the current host, writes a random
+// pattern to its device and reads it back, then removes the volume. The
+// volume is removed even if a step fails. No step is started once ctx is
+// done.
+func (man *volumeManager) SmokeTest(ctx context.Context) (err error) {
+	name := SmokeTestVolumePrefix + util.RandomID()
+	defer func() {
+		if err != nil {
+			err = errors.Wrapf(err, "smoke test with volume '%s' failed", name)
+		}
+	}()
+
+	if _, err := man.create(&types.VolumeInfo{
+		Name:             name,
+		Size:             SmokeTestVolumeSize,
+		NumberOfReplicas: 1,
+	}); err != nil {
+		return err
+	}
+	defer func() {
+		if derr := man.Delete(name); derr != nil {
+			logrus.Errorf("%+v", errors.Wrapf(derr, "fail to remove smoke test volume '%s'", name))
+			if err == nil {
+				err = derr
+			}
+		}
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := man.Attach(name); err != nil {
+		return err
+	}
+	volume, err := man.Get(name)
+	if err != nil {
+		return err
+	}
+	ctrl := man.getController(volume)
+	if ctrl == nil {
+		return errors.Errorf("cannot find controller of volume '%s'", name)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	pattern := make([]byte, SmokeTestPatternSize)
+	if _, err := rand.Read(pattern); err != nil {
+		return errors.Wrap(err, "fail to generate pattern")
+	}
+	read, err := writeReadDevice(ctrl.Endpoint(), pattern)
+	if err != nil {
+		return errors.Wrapf(err, "fail to write and read device %v", ctrl.Endpoint())
+	}
+	if !bytes.Equal(pattern, read) {
+		return errors.Errorf("data read from device %v doesn't match the data written", ctrl.Endpoint())
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := man.Detach(name); err != nil {
+		return err
+	}
+	logrus.Debugf("smoke test with volume '%s' passed", name)
+	return nil
+}
@@ -0,0 +1,58 @@
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestSmokeTest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("provisions a volume")
+	}
+	assert := require.New(t)
+
+	writeRead := writeReadDevice
+	defer func() { writeReadDevice = writeRead }()
+	devices := []string{}
+	writeReadDevice = func(device string, data []byte) ([]byte, error) {
+		devices = append(devices, device)
+		return append([]byte{}, data...), nil
+	}
+
+	orc := newFakeOrc("host-1")
+	man, _ := newTestManager(orc)
+
+	_, err := man.Create(&types.VolumeInfo{Name: SmokeTestVolumePrefix + "vol", Size: 4096})
+	assert.NotNil(err)
+
+	assert.Nil(man.SmokeTest(context.Background()))
+	assert.Len(devices, 1)
+	assert.True(strings.HasPrefix(devices[0], "/dev/longhorn/"+SmokeTestVolumePrefix))
+	volumes, err := man.List()
+	assert.Nil(err)
+	assert.Len(volumes, 0)
+
+	// the volume is removed when the data doesn't match
+	writeReadDevice = func(device string, data []byte) ([]byte, error) {
+		return make([]byte, len(data)), nil
+	}
+	err = man.SmokeTest(context.Background())
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "doesn't match")
+	volumes, err = man.List()
+	assert.Nil(err)
+	assert.Len(volumes, 0)
+
+	// and when no step can be started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NotNil(man.SmokeTest(ctx))
+	volumes, err = man.List()
+	assert.Nil(err)
+	assert.Len(volumes, 0)
+}
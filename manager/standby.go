@@ -0,0 +1,258 @@
+package manager
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// StandbySyncPeriod is how often the warm-standby replicas of an
+	// attached volume are synced
+	StandbySyncPeriod = 6 * time.Hour
+)
+
+const (
+	EventReasonStandbyCreated  = "StandbyCreated"
+	EventReasonStandbySynced   = "StandbySynced"
+	EventReasonStandbyPromoted = "StandbyPromoted"
+)
+
+// CreateStandbyReplica creates a warm-standby replica of the attached
+// volume. It's synced from a snapshot of the volume right away and every
+// StandbySyncPeriod, but it's kept out of the controller until promoted.
+func (man *volumeManager) CreateStandbyReplica(volumeName string) (*types.ReplicaInfo, error) {
+	volume, ctrl, err := man.standbyController(volumeName)
+	if err != nil {
+		return nil, err
+	}
+	if volume.Mode == types.VolumeModeLocal || volume.Conversion != nil {
+		return nil, errors.Errorf("volume '%s' in mode %v cannot have standby replicas", volumeName, volume.Mode)
+	}
+	replica, err := man.orc.CreateReplica(volumeName, man.GetReplicaName(volumeName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create a standby replica for volume '%s'", volumeName)
+	}
+	// recorded before it's started, the checks of the controller never take
+	// it for an active replica
+	if err := man.updateStandby(volumeName, replica.Name, &types.StandbyReplica{}); err != nil {
+		man.removeStaleReplica(volumeName, replica)
+		return nil, err
+	}
+	man.events.record(volumeName, types.EventSeverityInfo, EventReasonStandbyCreated, "created standby replica %v", replica.Name)
+	if man.startStandbySync(volumeName) {
+		go man.syncStandby(volumeName, ctrl, replica)
+	}
+	return replica, nil
+}
+
+// PromoteStandbyReplica adds the standby replica to the controller as an
+// active one. Only the changes since its last sync are rebuilt.
+func (man *volumeManager) PromoteStandbyReplica(volumeName, replicaName string) error {
+	volume, ctrl, err := man.standbyController(volumeName)
+	if err != nil {
+		return err
+	}
+	replica := volume.Replicas[replicaName]
+	if volume.StandbyReplicas[replicaName] == nil || replica == nil {
+		return errors.Errorf("volume '%s' has no standby replica '%s'", volumeName, replicaName)
+	}
+	if replica.BadTimestamp != "" {
+		return errors.Errorf("standby replica '%s' of volume '%s' is bad", replicaName, volumeName)
+	}
+	man.Lock()
+	syncing := man.standbySyncs[volumeName]
+	man.Unlock()
+	if syncing {
+		return errors.Errorf("a standby replica of volume '%s' is being synced", volumeName)
+	}
+	return man.promoteStandby(volumeName, ctrl, replica)
+}
+
+// promoteSyncedStandby promotes the standby replica of the volume synced
+// last, it's false if there's none to promote
+func (man *volumeManager) promoteSyncedStandby(volume *types.VolumeInfo, ctrl types.Controller) (bool, error) {
+	man.Lock()
+	syncing := man.standbySyncs[volume.Name]
+	man.Unlock()
+	if syncing {
+		return false, nil
+	}
+	var latest *types.ReplicaInfo
+	latestSynced := ""
+	for name, standby := range volume.StandbyReplicas {
+		replica := volume.Replicas[name]
+		if replica == nil || replica.BadTimestamp != "" || standby.Synced == "" {
+			continue
+		}
+		if latest == nil || standby.Synced > latestSynced {
+			latest, latestSynced = replica, standby.Synced
+		}
+	}
+	if latest == nil {
+		return false, nil
+	}
+	return true, man.promoteStandby(volume.Name, ctrl, latest)
+}
+
+func (man *volumeManager) promoteStandby(volumeName string, ctrl types.Controller, replica *types.ReplicaInfo) error {
+	if err := man.updateStandby(volumeName, replica.Name, nil); err != nil {
+		return err
+	}
+	if !replica.Running {
+		instance, err := man.orc.StartInstance(&replica.InstanceInfo)
+		if err != nil {
+			return errors.Wrapf(err, "failed to start replica %v for volume '%s'", replica.Name, volumeName)
+		}
+		replica.InstanceInfo = *instance
+	}
+	man.rebuildReplica(volumeName, ctrl, replica)
+	man.events.record(volumeName, types.EventSeverityInfo, EventReasonStandbyPromoted, "promoted standby replica %v", replica.Name)
+	return nil
+}
+
+// checkStandbys forgets the standby replicas gone bad or missing, takes the
+// ones left in the controller out of it, and syncs the one synced longest
+// ago once it's due
+func (man *volumeManager) checkStandbys(volume *types.VolumeInfo, ctrl types.Controller, inController []*types.ReplicaInfo) error {
+	if len(volume.StandbyReplicas) == 0 {
+		return nil
+	}
+	man.Lock()
+	syncing := man.standbySyncs[volume.Name]
+	man.Unlock()
+	if syncing {
+		return nil
+	}
+	for _, replica := range inController {
+		if err := ctrl.RemoveReplica(replica); err != nil {
+			return NewControllerError(errors.Wrapf(err, "failed to remove standby replica '%s' from volume '%s'", replica.Address, volume.Name))
+		}
+	}
+
+	var due *types.ReplicaInfo
+	dueSynced := ""
+	for name, standby := range volume.StandbyReplicas {
+		replica := volume.Replicas[name]
+		if replica == nil || replica.BadTimestamp != "" {
+			logrus.Infof("forgetting standby replica '%s' of volume '%s', it's bad or gone", name, volume.Name)
+			if err := man.updateStandby(volume.Name, name, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if standby.Synced != "" {
+			synced, err := util.ParseTime(standby.Synced)
+			if err == nil && time.Since(synced) < StandbySyncPeriod {
+				continue
+			}
+		}
+		if due == nil || standby.Synced < dueSynced {
+			due, dueSynced = replica, standby.Synced
+		}
+	}
+	if due != nil && man.startStandbySync(volume.Name) {
+		replica := *due
+		go man.syncStandby(volume.Name, ctrl, &replica)
+	}
+	return nil
+}
+
+// syncStandby brings the standby replica up to date. The controller takes a
+// snapshot and rebuilds the replica from it, then the replica is taken out
+// of the controller again.
+func (man *volumeManager) syncStandby(volumeName string, ctrl types.Controller, replica *types.ReplicaInfo) {
+	defer man.finishStandbySync(volumeName)
+
+	if !replica.Running {
+		instance, err := man.orc.StartInstance(&replica.InstanceInfo)
+		if err != nil {
+			logrus.Errorf("%+v", errors.Wrapf(err, "failed to start standby replica '%s' of volume '%s'", replica.Name, volumeName))
+			return
+		}
+		replica.InstanceInfo = *instance
+	}
+	if err := ctrl.AddReplica(replica); err != nil {
+		logrus.Errorf("%+v", errors.Wrapf(err, "failed to sync standby replica '%s' of volume '%s'", replica.Name, volumeName))
+		man.events.record(volumeName, types.EventSeverityWarning, EventReasonStandbySynced, "failed to sync standby replica %v: %v", replica.Name, err)
+		return
+	}
+	if err := ctrl.RemoveReplica(replica); err != nil {
+		// taken out by the next check of the controller
+		logrus.Errorf("%+v", errors.Wrapf(err, "failed to remove synced standby replica '%s' from volume '%s'", replica.Name, volumeName))
+		return
+	}
+	if err := man.updateStandby(volumeName, replica.Name, &types.StandbyReplica{Synced: util.Now()}); err != nil {
+		logrus.Errorf("%+v", err)
+		return
+	}
+	man.events.record(volumeName, types.EventSeverityInfo, EventReasonStandbySynced, "synced standby replica %v", replica.Name)
+}
+
+// startStandbySync holds the single standby sync of the volume, it's false
+// if a sync is in progress
+func (man *volumeManager) startStandbySync(volumeName string) bool {
+	man.Lock()
+	defer man.Unlock()
+	if man.standbySyncs[volumeName] {
+		return false
+	}
+	man.standbySyncs[volumeName] = true
+	return true
+}
+
+func (man *volumeManager) finishStandbySync(volumeName string) {
+	man.Lock()
+	defer man.Unlock()
+	delete(man.standbySyncs, volumeName)
+}
+
+// updateStandby sets the standby record of the replica, or removes it if
+// standby is nil. A promoted replica is no longer a standby one, while a
+// standby removed meanwhile isn't recorded again.
+func (man *volumeManager) updateStandby(volumeName, replicaName string, standby *types.StandbyReplica) error {
+	volume, err := man.orc.GetVolume(volumeName)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get volume '%s'", volumeName)
+	}
+	if volume == nil {
+		return errors.Errorf("cannot find volume '%s'", volumeName)
+	}
+	known := volume.StandbyReplicas[replicaName] != nil
+	if (standby == nil || standby.Synced != "") && !known {
+		return nil
+	}
+	standbys := map[string]*types.StandbyReplica{}
+	for name, s := range volume.StandbyReplicas {
+		standbys[name] = s
+	}
+	if standby == nil {
+		delete(standbys, replicaName)
+	} else {
+		standbys[replicaName] = standby
+	}
+	volume.StandbyReplicas = standbys
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		return errors.Wrapf(err, "unable to update standby replica '%s' of volume '%s'", replicaName, volumeName)
+	}
+	return nil
+}
+
+func (man *volumeManager) standbyController(volumeName string) (*types.VolumeInfo, types.Controller, error) {
+	volume, err := man.Get(volumeName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if volume == nil {
+		return nil, nil, errors.Errorf("cannot find volume '%s'", volumeName)
+	}
+	ctrl := man.getController(volume)
+	if ctrl == nil {
+		return nil, nil, errors.Errorf("volume '%s' has no running controller", volumeName)
+	}
+	return volume, ctrl, nil
+}
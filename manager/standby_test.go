@@ -0,0 +1,82 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestStandbyReplica(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	man, fc := newTestManager(orc)
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 4096, NumberOfReplicas: 2})
+	assert.Nil(err)
+	_, err = man.CreateStandbyReplica("vol")
+	assert.NotNil(err)
+	assert.Nil(man.Attach("vol"))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	ctrl := fc.get(volume).(*fakeController)
+
+	// the standby is synced through the controller, then taken out of it
+	waitSynced := func(name string) *types.VolumeInfo {
+		for i := 0; i < 100; i++ {
+			volume, err := man.Get("vol")
+			assert.Nil(err)
+			if s := volume.StandbyReplicas[name]; s != nil && s.Synced != "" {
+				return volume
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.FailNow("standby replica is never synced")
+		return nil
+	}
+	standby, err := man.CreateStandbyReplica("vol")
+	assert.Nil(err)
+	added := <-ctrl.added
+	assert.Equal(standby.Address, added.Address)
+	volume = waitSynced(standby.Name)
+	ctrl.Lock()
+	assert.Contains(ctrl.removed, standby.Address)
+	assert.Nil(ctrl.replicas[standby.Address])
+	ctrl.Unlock()
+	assert.Len(volume.Replicas, 3)
+	assert.Equal(types.VolumeStateHealthy, volume.State)
+
+	// not due for a sync, the healthy volume leaves it alone
+	assert.Nil(man.CheckController(ctrl, volume))
+	assert.Len(ctrl.added, 0)
+
+	// an active replica fails, the standby replaces it
+	ctrl.Lock()
+	for _, replica := range ctrl.replicas {
+		replica.Mode = types.ReplicaModeERR
+		break
+	}
+	ctrl.Unlock()
+	assert.Nil(man.CheckController(ctrl, volume))
+	added = <-ctrl.added
+	assert.Equal(standby.Address, added.Address)
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Len(volume.StandbyReplicas, 0)
+	assert.Len(volume.Replicas, 3)
+
+	// promoted on request
+	assert.NotNil(man.PromoteStandbyReplica("vol", standby.Name))
+	standby, err = man.CreateStandbyReplica("vol")
+	assert.Nil(err)
+	<-ctrl.added
+	waitSynced(standby.Name)
+	assert.Nil(man.PromoteStandbyReplica("vol", standby.Name))
+	added = <-ctrl.added
+	assert.Equal(standby.Address, added.Address)
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Nil(volume.StandbyReplicas[standby.Name])
+}
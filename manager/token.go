@@ -0,0 +1,213 @@
+package manager
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// APITokensFile has a token of the API per line, as token,role,name,
+	// optionally followed by ,namespace, which is refused since the volumes
+	// have no namespaces. Empty lines and lines starting with # are
+	// skipped. It's read on Start().
+	APITokensFile = ""
+
+	// APITokenCacheTTL is how long the tokens of the store are cached, a
+	// revoked token may still be accepted by the other managers meanwhile
+	APITokenCacheTTL = 10 * time.Second
+)
+
+// apiTokens caches the API tokens by hash
+type apiTokens struct {
+	sync.Mutex
+
+	file      []*types.APIToken
+	byHash    map[string]*types.APIToken
+	refreshed time.Time
+}
+
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPITokenSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// LoadAPITokensFile reads the tokens of APITokensFile format
+func LoadAPITokensFile(path string) ([]*types.APIToken, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read API tokens file %v", path)
+	}
+	defer f.Close()
+
+	tokens := []*types.APIToken{}
+	names := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) == 4 && fields[3] != "" {
+			return nil, errors.Errorf("namespace %v on line %v of API tokens file %v: namespace-scoped tokens are not supported", fields[3], n, path)
+		}
+		if (len(fields) != 3 && len(fields) != 4) || fields[0] == "" || fields[2] == "" {
+			return nil, errors.Errorf("invalid line %v of API tokens file %v, expecting token,role,name", n, path)
+		}
+		role := types.APIRole(fields[1])
+		if !role.Valid() {
+			return nil, errors.Errorf("invalid role %q on line %v of API tokens file %v, expecting one of %v", role, n, path, types.APIRoles)
+		}
+		if names[fields[2]] {
+			return nil, errors.Errorf("duplicate name %v on line %v of API tokens file %v", fields[2], n, path)
+		}
+		names[fields[2]] = true
+		tokens = append(tokens, &types.APIToken{
+			ID:     "file-" + fields[2],
+			Name:   fields[2],
+			Role:   role,
+			Hash:   HashAPIToken(fields[0]),
+			Source: types.APITokenSourceFile,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "unable to read API tokens file %v", path)
+	}
+	return tokens, nil
+}
+
+func (man *volumeManager) loadAPITokensFile() error {
+	if APITokensFile == "" {
+		return nil
+	}
+	tokens, err := LoadAPITokensFile(APITokensFile)
+	if err != nil {
+		return err
+	}
+	man.tokens.Lock()
+	defer man.tokens.Unlock()
+	man.tokens.file = tokens
+	man.tokens.refreshed = time.Time{}
+	return nil
+}
+
+// CreateAPIToken stores a new token, and returns it with its secret. Only
+// its hash is kept, the secret cannot be read again.
+func (man *volumeManager) CreateAPIToken(name string, role types.APIRole, namespace string) (*types.APIToken, string, error) {
+	if name == "" {
+		return nil, "", errors.Errorf("unable to create API token without name")
+	}
+	if namespace != "" {
+		return nil, "", errors.Errorf("unable to create API token %v in namespace %v: namespace-scoped tokens are not supported", name, namespace)
+	}
+	if !role.Valid() {
+		return nil, "", errors.Errorf("unable to create API token %v: invalid role %q, expecting one of %v", name, role, types.APIRoles)
+	}
+	secret, err := newAPITokenSecret()
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "unable to create API token %v", name)
+	}
+	token := &types.APIToken{
+		ID:      util.RandomID(),
+		Name:    name,
+		Role:    role,
+		Hash:    HashAPIToken(secret),
+		Created: util.Now(),
+		Source:  types.APITokenSourceStore,
+	}
+	if err := man.orc.SetAPIToken(token); err != nil {
+		return nil, "", errors.Wrapf(err, "unable to create API token %v", name)
+	}
+	man.tokens.Lock()
+	man.tokens.refreshed = time.Time{}
+	man.tokens.Unlock()
+	return token, secret, nil
+}
+
+// ListAPITokens lists the tokens of the tokens file and of the store, by name
+func (man *volumeManager) ListAPITokens() ([]*types.APIToken, error) {
+	stored, err := man.orc.ListAPITokens()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list API tokens")
+	}
+	man.tokens.Lock()
+	tokens := append([]*types.APIToken{}, man.tokens.file...)
+	man.tokens.Unlock()
+	for _, token := range stored {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Name != tokens[j].Name {
+			return tokens[i].Name < tokens[j].Name
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// RevokeAPIToken deletes a token of the store, the tokens of the tokens file
+// are only removed from the file
+func (man *volumeManager) RevokeAPIToken(id string) error {
+	stored, err := man.orc.ListAPITokens()
+	if err != nil {
+		return errors.Wrapf(err, "unable to revoke API token %v", id)
+	}
+	if stored[id] == nil {
+		return errors.Errorf("cannot find API token %v in the store", id)
+	}
+	if err := man.orc.DeleteAPIToken(id); err != nil {
+		return errors.Wrapf(err, "unable to revoke API token %v", id)
+	}
+	man.tokens.Lock()
+	man.tokens.refreshed = time.Time{}
+	man.tokens.Unlock()
+	return nil
+}
+
+// AuthenticateAPIToken returns the token with the secret, nil if there is
+// none
+func (man *volumeManager) AuthenticateAPIToken(secret string) (*types.APIToken, error) {
+	man.tokens.Lock()
+	defer man.tokens.Unlock()
+	if time.Since(man.tokens.refreshed) > APITokenCacheTTL {
+		stored, err := man.orc.ListAPITokens()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to list API tokens")
+		}
+		byHash := map[string]*types.APIToken{}
+		for _, token := range man.tokens.file {
+			byHash[token.Hash] = token
+		}
+		for _, token := range stored {
+			byHash[token.Hash] = token
+		}
+		man.tokens.byHash = byHash
+		man.tokens.refreshed = time.Now()
+	}
+	token := man.tokens.byHash[HashAPIToken(secret)]
+	if token == nil {
+		return nil, nil
+	}
+	t := *token
+	return &t, nil
+}
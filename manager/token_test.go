@@ -0,0 +1,76 @@
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestAPITokens(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "tokens")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	defer func(file string) { APITokensFile = file }(APITokensFile)
+	APITokensFile = filepath.Join(dir, "tokens")
+	assert.Nil(ioutil.WriteFile(APITokensFile, []byte("# static tokens\n\nfile-secret,readonly,monitoring\nother-secret,readonly,alerting,\n"), 0600))
+
+	orc := newFakeOrc("host-1")
+	man, _ := newTestManager(orc)
+	assert.Nil(man.loadAPITokensFile())
+
+	_, _, err = man.CreateAPIToken("provisioner", "root", "")
+	assert.NotNil(err)
+	_, _, err = man.CreateAPIToken("provisioner", types.APIRoleOperator, "team-a")
+	assert.NotNil(err)
+	token, secret, err := man.CreateAPIToken("provisioner", types.APIRoleOperator, "")
+	assert.Nil(err)
+	assert.NotEqual("", secret)
+	assert.Equal(HashAPIToken(secret), token.Hash)
+	assert.Equal(types.APITokenSourceStore, token.Source)
+
+	// the secrets are never listed
+	tokens, err := man.ListAPITokens()
+	assert.Nil(err)
+	assert.Len(tokens, 3)
+	assert.Equal("monitoring", tokens[1].Name)
+	assert.Equal(types.APITokenSourceFile, tokens[1].Source)
+	assert.Equal(HashAPIToken("file-secret"), tokens[1].Hash)
+	assert.Equal(token, tokens[2])
+
+	got, err := man.AuthenticateAPIToken(secret)
+	assert.Nil(err)
+	assert.Equal(token, got)
+	got, err = man.AuthenticateAPIToken("file-secret")
+	assert.Nil(err)
+	assert.Equal(types.APIRoleReadonly, got.Role)
+	got, err = man.AuthenticateAPIToken("other")
+	assert.Nil(err)
+	assert.Nil(got)
+
+	// the tokens of the file are only removed from it
+	assert.NotNil(man.RevokeAPIToken(tokens[1].ID))
+	assert.Nil(man.RevokeAPIToken(token.ID))
+	got, err = man.AuthenticateAPIToken(secret)
+	assert.Nil(err)
+	assert.Nil(got)
+	tokens, err = man.ListAPITokens()
+	assert.Nil(err)
+	assert.Len(tokens, 2)
+
+	for _, content := range []string{
+		"secret,readonly\n",
+		"secret,root,monitoring\n",
+		"secret-1,readonly,monitoring\nsecret-2,admin,monitoring\n",
+		"secret,readonly,monitoring,team-a\n",
+	} {
+		assert.Nil(ioutil.WriteFile(APITokensFile, []byte(content), 0600))
+		assert.NotNil(man.loadAPITokensFile(), content)
+	}
+}
@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"reflect"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+const (
+	EventReasonUnschedulable = "Unschedulable"
+	EventReasonScheduled     = "Scheduled"
+)
+
+// addIneligibleHosts adds the hosts left out of the placement to an
+// unschedulable error, with the conditions keeping them out
+func addIneligibleHosts(err error, ineligible map[string]*types.HostInfo) {
+	e, ok := errors.Cause(err).(*types.ErrUnschedulable)
+	if !ok {
+		return
+	}
+	failed := map[string][]types.ScheduleConstraint{}
+	for _, h := range e.Hosts {
+		failed[h.HostID] = h.Constraints
+	}
+	for id, host := range ineligible {
+		for _, condition := range host.Detail.UnschedulableReasons {
+			failed[id] = append(failed[id], types.ScheduleConstraint(condition))
+		}
+	}
+	e.Hosts = types.NewErrUnschedulable(failed).Hosts
+}
+
+// setScheduledCondition records the outcome of placing a replica of the
+// volume, without updating it. An unschedulable error sets the Scheduled
+// condition, no error clears it. The other errors tell nothing about the
+// placement, and leave it alone. It's true if the volume changed.
+func (man *volumeManager) setScheduledCondition(volume *types.VolumeInfo, err error) bool {
+	current := volume.Condition(types.VolumeConditionScheduled)
+	conditions := []*types.VolumeCondition{}
+	for _, c := range volume.Conditions {
+		if c.Type != types.VolumeConditionScheduled {
+			conditions = append(conditions, c)
+		}
+	}
+	if err == nil {
+		if current == nil {
+			return false
+		}
+		volume.Conditions = conditions
+		man.events.record(volume.Name, types.EventSeverityInfo, EventReasonScheduled, "replicas placed, unschedulable since %v", current.Since)
+		return true
+	}
+	e, ok := errors.Cause(err).(*types.ErrUnschedulable)
+	if !ok {
+		return false
+	}
+	now := util.Now()
+	condition := &types.VolumeCondition{
+		Type:    types.VolumeConditionScheduled,
+		Reasons: e.Reasons(),
+		Hosts:   e.Hosts,
+		Message: e.Message(),
+		Since:   now,
+		Updated: now,
+	}
+	if current != nil {
+		condition.Since = current.Since
+	}
+	if current == nil || !reflect.DeepEqual(current.Hosts, condition.Hosts) {
+		man.events.record(volume.Name, types.EventSeverityWarning, EventReasonUnschedulable, "unable to place a replica: %v", condition.Message)
+	}
+	volume.Conditions = append(conditions, condition)
+	return true
+}
+
+// updateScheduledCondition records the outcome of placing a replica of the
+// volume, see setScheduledCondition
+func (man *volumeManager) updateScheduledCondition(name string, err error) {
+	volume, getErr := man.orc.GetVolume(name)
+	if getErr != nil || volume == nil {
+		logrus.Warnf("unable to get volume '%s' to update its scheduled condition: %v", name, getErr)
+		return
+	}
+	if !man.setScheduledCondition(volume, err) {
+		return
+	}
+	if err := man.orc.UpdateVolume(volume); err != nil {
+		logrus.Warnf("%v", errors.Wrapf(err, "unable to update scheduled condition of volume '%s'", name))
+	}
+}
+
+// countUnschedulable counts the volumes with the Scheduled condition by
+// the constraints failed
+func (man *volumeManager) countUnschedulable() (map[types.ScheduleConstraint]int, error) {
+	counts := map[types.ScheduleConstraint]int{}
+	if err := man.orc.ForEachVolume("", func(volume *types.VolumeInfo) error {
+		if c := volume.Condition(types.VolumeConditionScheduled); c != nil {
+			for _, reason := range c.Reasons {
+				counts[reason]++
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "unable to list volumes")
+	}
+	return counts, nil
+}
@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestScheduledCondition(t *testing.T) {
+	assert := require.New(t)
+
+	orc := newFakeOrc("host-1", "host-2", "host-3")
+	for id, total := range map[string]int64{"host-1": 10000, "host-2": 20000, "host-3": 30000} {
+		orc.hosts[id].StorageTotal = total
+	}
+	man, clock := newSkewTestManager(orc)
+	heartbeats(orc, clock, nil)
+	assert.Nil(man.checkClockSkew())
+
+	_, err := man.Create(&types.VolumeInfo{Name: "vol", Size: 8000, NumberOfReplicas: 2, ScheduleOnCreate: true})
+	assert.Nil(err)
+
+	// the replicas don't fit on the only host left, the condition has what
+	// each host fails
+	assert.Nil(man.UpdateHostSchedulable("host-2", false))
+	assert.Nil(man.UpdateHostSchedulable("host-3", false))
+	assert.NotNil(man.ReplanReplicas("vol"))
+	volume, err := man.Get("vol")
+	assert.Nil(err)
+	condition := volume.Condition(types.VolumeConditionScheduled)
+	assert.NotNil(condition)
+	assert.Equal([]*types.HostConstraints{
+		{HostID: "host-1", Constraints: []types.ScheduleConstraint{types.ScheduleConstraintInsufficientSpace}},
+		{HostID: "host-2", Constraints: []types.ScheduleConstraint{types.ScheduleConstraintCordoned}},
+		{HostID: "host-3", Constraints: []types.ScheduleConstraint{types.ScheduleConstraintCordoned}},
+	}, condition.Hosts)
+	assert.Equal([]types.ScheduleConstraint{types.ScheduleConstraintCordoned, types.ScheduleConstraintInsufficientSpace}, condition.Reasons)
+	assert.Equal("host host-1 without enough space; host host-2 cordoned; host host-3 cordoned", condition.Message)
+	counts, err := man.countUnschedulable()
+	assert.Nil(err)
+	assert.Equal(map[types.ScheduleConstraint]int{
+		types.ScheduleConstraintCordoned:          1,
+		types.ScheduleConstraintInsufficientSpace: 1,
+	}, counts)
+
+	// failing the same way again keeps when it started
+	assert.NotNil(man.ReplanReplicas("vol"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Equal(condition.Since, volume.Condition(types.VolumeConditionScheduled).Since)
+
+	// placing the replicas clears it
+	assert.Nil(man.UpdateHostSchedulable("host-3", true))
+	assert.Nil(man.ReplanReplicas("vol"))
+	volume, err = man.Get("vol")
+	assert.Nil(err)
+	assert.Nil(volume.Condition(types.VolumeConditionScheduled))
+	assert.Len(volume.Conditions, 0)
+
+	man.events.flush()
+	events, err := orc.ListVolumeEvents("vol")
+	assert.Nil(err)
+	reasons := map[string]int{}
+	for _, e := range events {
+		reasons[e.Reason]++
+	}
+	assert.Equal(1, reasons[EventReasonUnschedulable])
+	assert.Equal(1, reasons[EventReasonScheduled])
+}
@@ -1,5 +1,21 @@
 package orch
 
+import (
+	"time"
+)
+
 const (
 	EngineImageParam = "engine-image"
+
+	WaitDeviceTimeoutParam    = "wait-device-timeout"
+	WaitAPITimeoutParam       = "wait-api-timeout"
+	ContainerStopTimeoutParam = "container-stop-timeout"
+)
+
+var (
+	DefaultTimeouts = Timeouts{
+		WaitDevice:    30 * time.Second,
+		WaitAPI:       30 * time.Second,
+		ContainerStop: 1 * time.Minute,
+	}
 )
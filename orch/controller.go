@@ -0,0 +1,32 @@
+package orch
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const (
+	ControllerCacheModeFlag = "--cache-mode"
+)
+
+var (
+	controllerCacheModes = map[types.CacheMode]string{
+		types.CacheModeWriteThrough: "writethrough",
+		types.CacheModeWriteBack:    "writeback",
+	}
+)
+
+// ControllerCacheModeArgs returns the controller launch arguments controlling
+// the write cache. Volumes created before the cache mode was introduced get
+// the default write-through.
+func ControllerCacheModeArgs(mode types.CacheMode) ([]string, error) {
+	if mode == types.CacheModeDefault {
+		mode = types.CacheModeWriteThrough
+	}
+	cache, ok := controllerCacheModes[mode]
+	if !ok {
+		return nil, errors.Errorf("invalid cache mode '%s'", mode)
+	}
+	return []string{ControllerCacheModeFlag, cache}, nil
+}
@@ -0,0 +1,27 @@
+package orch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestControllerCacheModeArgs(t *testing.T) {
+	assert := require.New(t)
+
+	expected := map[types.CacheMode][]string{
+		types.CacheModeDefault:      {"--cache-mode", "writethrough"},
+		types.CacheModeWriteThrough: {"--cache-mode", "writethrough"},
+		types.CacheModeWriteBack:    {"--cache-mode", "writeback"},
+	}
+	for mode, args := range expected {
+		result, err := ControllerCacheModeArgs(mode)
+		assert.Nil(err)
+		assert.Equal(args, result, "mode '%s'", mode)
+	}
+
+	_, err := ControllerCacheModeArgs(types.CacheMode("none"))
+	assert.NotNil(err)
+}
@@ -2,9 +2,16 @@ package docker
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
@@ -12,6 +19,8 @@ import (
 	"golang.org/x/net/context"
 
 	dTypes "github.com/docker/docker/api/types"
+	dContainer "github.com/docker/docker/api/types/container"
+	dNetwork "github.com/docker/docker/api/types/network"
 	dCli "github.com/docker/docker/client"
 
 	"github.com/rancher/longhorn-manager/api"
@@ -24,7 +33,38 @@ import (
 
 const (
 	cfgDirectory = "/var/lib/rancher/longhorn/"
+
+	// refuse to start
+	HostUUIDCollisionRefuse = "refuse"
+	// register as a new host with a new UUID
+	HostUUIDCollisionRegenerate = "regenerate"
+	// start anyway, leaving it to the conflict detection of the heartbeats
+	HostUUIDCollisionIgnore = "ignore"
+
+	// ETCDPasswordEnv is the environment variable of --etcd-password
+	ETCDPasswordEnv = "LONGHORN_ETCD_PASSWORD"
+)
+
+var HostUUIDCollisions = []string{HostUUIDCollisionRefuse, HostUUIDCollisionRegenerate, HostUUIDCollisionIgnore}
+
+var (
 	hostUUIDFile = cfgDirectory + ".physical_host_uuid"
+
+	// HostConflictThreshold of nonces of other machines seen in the host
+	// record within HostConflictWindow marks the host conflicted
+	HostConflictThreshold = 3
+	HostConflictWindow    = 5 * time.Minute
+
+	// HostDownThreshold since the last heartbeat of the host, as the
+	// managers mark it offline, means it was down when it beats again
+	HostDownThreshold = 30 * time.Second
+
+	// HostUUIDCollision is what a machine does at start when the host
+	// record of its UUID belongs to another machine, see HostUUIDCollisions
+	HostUUIDCollision = HostUUIDCollisionRefuse
+
+	// built in drivers, or plugins such as "vendor/driver:tag"
+	logDriverRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*(/[a-z0-9][a-z0-9._-]*)?(:[a-zA-Z0-9._-]+)?$`)
 )
 
 type dockerOrc struct {
@@ -32,19 +72,77 @@ type dockerOrc struct {
 	Network     string
 	IP          string
 
+	// ReplicaDNSAlias addresses the replicas by a network alias instead of
+	// their IP, if the network has embedded DNS
+	ReplicaDNSAlias bool
+
+	// ReplicaBlockDevices of the host can back the data of the replicas,
+	// one each. blockDeviceLock keeps two replicas from taking the same.
+	ReplicaBlockDevices []string
+	blockDeviceLock     sync.Mutex
+
+	// ReplicaMountpoints must be mounted, writable and have the space of
+	// the volume free before a replica backed by files is created, so the
+	// data doesn't land on the root disk
+	ReplicaMountpoints []string
+
 	currentHost *types.HostInfo
+	timeouts    orch.Timeouts
+	logConfig   dContainer.LogConfig
+
+	// nonce of this start, and the other nonces seen in the host record
+	nonce         string
+	foreignNonces []foreignNonce
 
 	kv  *kvstore.KVStore
-	cli *dCli.Client
+	cli dockerClient
 
 	scheduler types.Scheduler
+
+	// config is what the orchestrator was created with, for reporting
+	config dockerOrcConfig
+}
+
+// dockerClient is the part of the Docker API used by the orchestrator
+type dockerClient interface {
+	ContainerCreate(ctx context.Context, config *dContainer.Config, hostConfig *dContainer.HostConfig, networkingConfig *dNetwork.NetworkingConfig, containerName string) (dContainer.ContainerCreateCreatedBody, error)
+	ContainerInspect(ctx context.Context, containerID string) (dTypes.ContainerJSON, error)
+	ContainerStart(ctx context.Context, containerID string, options dTypes.ContainerStartOptions) error
+	ContainerStop(ctx context.Context, containerID string, timeout *time.Duration) error
+	ContainerRemove(ctx context.Context, containerID string, options dTypes.ContainerRemoveOptions) error
+	ContainerLogs(ctx context.Context, container string, options dTypes.ContainerLogsOptions) (io.ReadCloser, error)
+	ContainerList(ctx context.Context, options dTypes.ContainerListOptions) ([]dTypes.Container, error)
+	ContainerWait(ctx context.Context, containerID string) (int64, error)
+	ImageInspectWithRaw(ctx context.Context, imageID string) (dTypes.ImageInspect, []byte, error)
 }
 
 type dockerOrcConfig struct {
 	servers []string
 	prefix  string
-	image   string
-	network string
+	etcd    kvstore.ETCDOptions
+	// serve the reads of etcd from a cache while it's unreachable
+	readCache bool
+	image     string
+	network   string
+
+	// writes to etcd a second after a burst, unlimited if 0
+	writeRate  float64
+	writeBurst int
+
+	// the address of the API in the host record, the IP detected with port
+	// if empty
+	address string
+	port    string
+
+	replicaDNSAlias     bool
+	replicaBlockDevices []string
+	replicaMountpoints  []string
+
+	// version of the manager, stamped on the records written
+	version string
+
+	timeouts  *orch.Timeouts
+	logConfig *dContainer.LogConfig
 }
 
 func New(c *cli.Context) (types.Orchestrator, error) {
@@ -55,38 +153,93 @@ func New(c *cli.Context) (types.Orchestrator, error) {
 	prefix := c.String("etcd-prefix")
 	image := c.String(orch.EngineImageParam)
 	network := c.String("docker-network")
+	timeouts, err := orch.ParseTimeouts(c)
+	if err != nil {
+		return nil, err
+	}
+	logConfig, err := parseLogConfig(c.String("instance-log-driver"), c.StringSlice("instance-log-opts"))
+	if err != nil {
+		return nil, err
+	}
+	etcdOptions, err := parseETCDOptions(c.String("etcd-cert"), c.String("etcd-key"), c.String("etcd-cacert"),
+		c.String("etcd-username"), c.String("etcd-password"))
+	if err != nil {
+		return nil, err
+	}
+	if c.Float64("etcd-write-rate") < 0 {
+		return nil, fmt.Errorf("invalid value %v for --etcd-write-rate, expecting a number such as 50", c.Float64("etcd-write-rate"))
+	}
+	if c.Int("etcd-write-burst") < 1 {
+		return nil, fmt.Errorf("invalid value %v for --etcd-write-burst, expecting a number such as 100", c.Int("etcd-write-burst"))
+	}
+	port := strconv.Itoa(api.DefaultPort)
+	if listen := c.StringSlice("listen"); len(listen) > 0 {
+		if _, port, err = net.SplitHostPort(listen[0]); err != nil {
+			return nil, errors.Wrapf(err, "invalid listen address %v", listen[0])
+		}
+	}
 	return newDocker(&dockerOrcConfig{
-		servers: servers,
-		prefix:  prefix,
-		image:   image,
-		network: network,
+		servers:   servers,
+		prefix:    prefix,
+		etcd:      etcdOptions,
+		readCache: c.Bool("etcd-read-cache"),
+		image:     image,
+		network:   network,
+		address:   c.String("advertise-address"),
+		port:      port,
+		timeouts:  timeouts,
+
+		writeRate:  c.Float64("etcd-write-rate"),
+		writeBurst: c.Int("etcd-write-burst"),
+
+		replicaDNSAlias:     c.Bool("replica-dns-alias"),
+		replicaBlockDevices: c.StringSlice("replica-block-device"),
+		replicaMountpoints:  c.StringSlice("replica-mountpoint"),
+		version:             c.App.Version,
+		logConfig:           logConfig,
 	})
 }
 
 func newDocker(cfg *dockerOrcConfig) (types.Orchestrator, error) {
-	etcdBackend, err := kvstore.NewETCDBackend(cfg.servers)
+	etcdBackend, err := connectETCD(cfg.servers, cfg.prefix, cfg.etcd)
 	if err != nil {
 		return nil, err
 	}
-	kvStore, err := kvstore.NewKVStore(cfg.prefix, etcdBackend)
+	var backend kvstore.Backend = etcdBackend
+	if cfg.readCache {
+		cache := kvstore.NewReadCacheBackend(etcdBackend)
+		watcher := kvstore.NewWatcher(etcdBackend, cfg.prefix)
+		go watcher.Run(nil)
+		cache.Watch(watcher)
+		backend = cache
+	}
+	kvStore, err := kvstore.NewKVStore(cfg.prefix, backend)
 	if err != nil {
 		return nil, err
 	}
+	kvStore.SetWriteLimit(cfg.writeRate, cfg.writeBurst)
 
-	docker := &dockerOrc{
-		EngineImage: cfg.image,
-		kv:          kvStore,
+	// volumes written with their instances in the base, before each had its
+	// own key, may exceed the size limit of etcd values as they grow
+	split, err := kvStore.SplitVolumeRecords()
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to split volume records")
+	}
+	if split > 0 {
+		logrus.Infof("Split the records of %v volumes", split)
 	}
-	docker.scheduler = scheduler.NewOrcScheduler(docker)
+
+	docker := newDockerOrc(cfg, kvStore)
 
 	//Set Docker API to compatible with 1.12
 	os.Setenv("DOCKER_API_VERSION", "1.24")
-	docker.cli, err = dCli.NewEnvClient()
+	client, err := dCli.NewEnvClient()
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot connect to docker")
 	}
+	docker.cli = client
 
-	if _, err := docker.cli.ContainerList(context.Background(), dTypes.ContainerListOptions{}); err != nil {
+	if _, err := client.ContainerList(context.Background(), dTypes.ContainerListOptions{}); err != nil {
 		return nil, errors.Wrap(err, "cannot pass test to get container list")
 	}
 
@@ -95,8 +248,14 @@ func newDocker(cfg *dockerOrcConfig) (types.Orchestrator, error) {
 	}
 
 	logrus.Infof("Detected network is %s, IP is %s", docker.Network, docker.IP)
+	if docker.ReplicaDNSAlias && !docker.embeddedDNS() {
+		logrus.Warnf("Network %s has no embedded DNS, replicas are addressed by IP", docker.Network)
+	}
 
-	address := docker.IP + ":" + strconv.Itoa(api.DefaultPort)
+	address := cfg.address
+	if address == "" {
+		address = net.JoinHostPort(docker.IP, cfg.port)
+	}
 	logrus.Info("Local address is: ", address)
 
 	if err := docker.Register(address); err != nil {
@@ -106,6 +265,130 @@ func newDocker(cfg *dockerOrcConfig) (types.Orchestrator, error) {
 	return docker, nil
 }
 
+// newDockerOrc sets up the orchestrator from the config, without connecting
+// to Docker
+func newDockerOrc(cfg *dockerOrcConfig, kv *kvstore.KVStore) *dockerOrc {
+	docker := &dockerOrc{
+		EngineImage: cfg.image,
+		kv:          kv,
+		timeouts:    orch.DefaultTimeouts,
+
+		ReplicaDNSAlias:     cfg.replicaDNSAlias,
+		ReplicaBlockDevices: cfg.replicaBlockDevices,
+		ReplicaMountpoints:  cfg.replicaMountpoints,
+
+		config: *cfg,
+	}
+	if cfg.timeouts != nil {
+		docker.timeouts = *cfg.timeouts
+	}
+	if cfg.logConfig != nil {
+		docker.logConfig = *cfg.logConfig
+	}
+	docker.scheduler = scheduler.NewOrcScheduler(docker)
+	return docker
+}
+
+// GetEffectiveConfig reports the config resolved from the flags, with the
+// credentials of the etcd servers and the secret log options redacted
+func (d *dockerOrc) GetEffectiveConfig() (*types.RuntimeConfig, error) {
+	config := &types.RuntimeConfig{
+		Orchestrator:  OrcName,
+		EtcdServers:   []string{},
+		EtcdPrefix:    d.config.prefix,
+		EtcdReadCache: d.config.readCache,
+		EtcdUsername:  d.config.etcd.Username,
+		EngineImage:   d.EngineImage,
+		Network:       d.Network,
+		IP:            d.IP,
+
+		ReplicaDNSAlias:     d.ReplicaDNSAlias,
+		ReplicaBlockDevices: d.ReplicaBlockDevices,
+		ReplicaMountpoints:  d.ReplicaMountpoints,
+		InstanceLogDriver:   d.logConfig.Type,
+		InstanceLogOpts:     util.RedactOpts(d.logConfig.Config),
+		Timeouts: map[string]string{
+			orch.WaitDeviceTimeoutParam:    d.timeouts.WaitDevice.String(),
+			orch.WaitAPITimeoutParam:       d.timeouts.WaitAPI.String(),
+			orch.ContainerStopTimeoutParam: d.timeouts.ContainerStop.String(),
+		},
+
+		HostConflictThreshold: HostConflictThreshold,
+		HostConflictWindow:    HostConflictWindow.String(),
+		HostUUIDCollision:     HostUUIDCollision,
+
+		MaxConcurrentSchedules:   scheduler.MaxConcurrentSchedules,
+		ScheduleTimeout:          scheduler.DefaultProcessTimeout.String(),
+		ScheduleActionTimeouts:   map[types.ScheduleAction]string{},
+		ScheduleQuarantinePeriod: scheduler.QuarantinePeriod.String(),
+	}
+	for _, server := range d.config.servers {
+		config.EtcdServers = append(config.EtcdServers, util.RedactURL(server))
+	}
+	for action, timeout := range scheduler.ProcessTimeouts {
+		config.ScheduleActionTimeouts[action] = timeout.String()
+	}
+	if d.currentHost != nil {
+		config.HostID = d.currentHost.UUID
+		config.Address = d.currentHost.Address
+	}
+	return config, nil
+}
+
+// parseLogConfig returns the log config of the instance containers, empty
+// for the default of Docker. opts are key=value.
+func parseLogConfig(driver string, opts []string) (*dContainer.LogConfig, error) {
+	if driver == "" {
+		if len(opts) != 0 {
+			return nil, fmt.Errorf("--instance-log-opts requires --instance-log-driver")
+		}
+		return &dContainer.LogConfig{}, nil
+	}
+	if !logDriverRegexp.MatchString(driver) {
+		return nil, fmt.Errorf("invalid value %v for --instance-log-driver, expecting a driver name such as \"syslog\"", driver)
+	}
+	config := &dContainer.LogConfig{Type: driver}
+	for _, opt := range opts {
+		parts := strings.SplitN(opt, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid value %v for --instance-log-opts, expecting key=value", opt)
+		}
+		if config.Config == nil {
+			config.Config = map[string]string{}
+		}
+		config.Config[parts[0]] = parts[1]
+	}
+	if driver == "none" {
+		logrus.Warnf("Instance log driver is none, the output of failed instances won't be available")
+	}
+	return config, nil
+}
+
+// parseETCDOptions loads the client certificate and the CA of the etcd
+// servers, given all three or none, and takes the credentials, given both or
+// none
+func parseETCDOptions(certFile, keyFile, caFile, username, password string) (kvstore.ETCDOptions, error) {
+	opts := kvstore.ETCDOptions{}
+	if (username == "") != (password == "") {
+		return opts, fmt.Errorf("--etcd-username and --etcd-password must be given together")
+	}
+	opts.Username = username
+	opts.Password = password
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return opts, nil
+	}
+	if certFile == "" || keyFile == "" || caFile == "" {
+		return opts, fmt.Errorf("--etcd-cert, --etcd-key and --etcd-cacert must be given together, got cert %q, key %q and CA %q",
+			certFile, keyFile, caFile)
+	}
+	tlsConfig, err := kvstore.NewETCDTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		return opts, err
+	}
+	opts.TLS = tlsConfig
+	return opts, nil
+}
+
 func getCurrentHost(address string) (*types.HostInfo, error) {
 	var err error
 
@@ -125,7 +408,7 @@ func getCurrentHost(address string) (*types.HostInfo, error) {
 
 	// file doesn't exists, generate new UUID for the host
 	host.UUID = util.UUID()
-	if err := os.MkdirAll(cfgDirectory, os.ModeDir|0600); err != nil {
+	if err := os.MkdirAll(filepath.Dir(hostUUIDFile), os.ModeDir|0600); err != nil {
 		return nil, fmt.Errorf("Fail to create configuration directory: %v", err)
 	}
 	if err := ioutil.WriteFile(hostUUIDFile, []byte(host.UUID), 0600); err != nil {
@@ -172,18 +455,247 @@ func (d *dockerOrc) Register(address string) error {
 	if err != nil {
 		return err
 	}
+	currentHost.Heartbeat = util.Now()
+	currentHost.Nonce = util.UUID()
+	d.kv.SetWriter(&types.WriterInfo{
+		Version: d.config.version,
+		HostID:  currentHost.UUID,
+	})
+	// keep the settings of the host across restarts
+	existing, err := d.kv.GetHost(currentHost.UUID)
+	if err != nil {
+		return err
+	}
+	if uuidCollision(existing, currentHost) {
+		switch HostUUIDCollision {
+		case HostUUIDCollisionRegenerate:
+			logrus.Errorf("Host UUID %v in %v is used by another machine %v at %v, heartbeat %v. "+
+				"The machine may be a clone, registering as a new host",
+				currentHost.UUID, hostUUIDFile, existing.Name, existing.Address, existing.Heartbeat)
+			if err := os.Remove(hostUUIDFile); err != nil {
+				return errors.Wrapf(err, "fail to remove uuid of host %v", currentHost.UUID)
+			}
+			return d.Register(address)
+		case HostUUIDCollisionIgnore:
+			logrus.Warnf("Host UUID %v is used by another machine %v at %v, starting anyway",
+				currentHost.UUID, existing.Name, existing.Address)
+		default:
+			return errors.Errorf("host UUID %v in %v is used by another machine %v at %v, heartbeat %v. "+
+				"The machine may be a clone: remove the file to register as a new host, or start with --host-uuid-collision=%v",
+				currentHost.UUID, hostUUIDFile, existing.Name, existing.Address, existing.Heartbeat, HostUUIDCollisionRegenerate)
+		}
+	}
+	if existing != nil {
+		currentHost.Unschedulable = existing.Unschedulable
+		currentHost.FailureDomain = existing.FailureDomain
+		currentHost.SchedulingWeight = existing.SchedulingWeight
+		currentHost.Role = existing.Role
+		currentHost.Maintenance = existing.Maintenance
+		currentHost.Conflicted = existing.Conflicted
+		currentHost.ConflictNonces = existing.ConflictNonces
+		currentHost.RegenerateNonce = existing.RegenerateNonce
+	}
 
 	if err := d.kv.SetHost(currentHost); err != nil {
 		return err
 	}
 	d.currentHost = currentHost
+	d.nonce = currentHost.Nonce
+	d.foreignNonces = nil
+	return nil
+}
+
+// uuidCollision is true if the host record of the UUID is heartbeated by
+// another machine, with another name or address. A record without a
+// heartbeat within HostConflictWindow is taken as left by the same machine
+// before its address changed.
+func uuidCollision(existing, current *types.HostInfo) bool {
+	if existing == nil || (existing.Name == current.Name && existing.Address == current.Address) {
+		return false
+	}
+	heartbeat, err := util.ParseTime(existing.Heartbeat)
+	if err != nil {
+		return false
+	}
+	return time.Since(heartbeat) <= HostConflictWindow
+}
+
+func (d *dockerOrc) Heartbeat() error {
+	host, err := d.kv.GetHost(d.currentHost.UUID)
+	if err != nil {
+		return errors.Wrapf(err, "fail to update heartbeat of host %v", d.currentHost.UUID)
+	}
+	if host == nil {
+		h := *d.currentHost
+		host = &h
+	}
+	if host.RegenerateNonce == d.nonce {
+		return d.regenerateUUID()
+	}
+	d.checkConflict(host)
+	total, available, capacityErr := util.FilesystemCapacity(cfgDirectory)
+	if capacityErr != nil {
+		logrus.Warnf("%v", capacityErr)
+	}
+	// only the fields of the heartbeat are written, over the latest record
+	if err := d.kv.UpdateHost(host.UUID, func(latest *types.HostInfo) (*types.HostInfo, error) {
+		if latest == nil {
+			h := *d.currentHost
+			latest = &h
+		}
+		latest.Nonce = d.nonce
+		latest.Conflicted, latest.ConflictNonces = host.Conflicted, host.ConflictNonces
+		now := time.Now()
+		if last, err := util.ParseTime(latest.Heartbeat); err == nil && now.Sub(last) > HostDownThreshold {
+			logrus.Infof("host %v back %v after its last heartbeat", latest.UUID, now.Sub(last))
+			latest.Recovered = util.FormatTimeZ(now)
+		}
+		latest.Heartbeat = util.FormatTimeZ(now)
+		if capacityErr == nil {
+			latest.StorageTotal, latest.StorageAvailable = total, available
+		}
+		return latest, nil
+	}); err != nil {
+		return errors.Wrapf(err, "fail to update heartbeat of host %v", host.UUID)
+	}
+	return nil
+}
+
+// Deregister removes the record of the current host. The host registers
+// again on the next start.
+func (d *dockerOrc) Deregister() error {
+	if err := d.kv.DeleteHost(d.currentHost.UUID); err != nil {
+		return errors.Wrapf(err, "fail to deregister host %v", d.currentHost.UUID)
+	}
+	return nil
+}
+
+type foreignNonce struct {
+	nonce string
+	seen  time.Time
+}
+
+// checkConflict records the nonce of another machine found in the host
+// record, which means both machines heartbeat with the same UUID, e.g. the
+// VM image was cloned with the UUID file. The nonce of the machine asked to
+// register again is ignored, the conflict clears once no other nonce is seen
+// within HostConflictWindow.
+func (d *dockerOrc) checkConflict(host *types.HostInfo) {
+	now := time.Now()
+	if host.Nonce != "" && host.Nonce != d.nonce && host.Nonce != host.RegenerateNonce {
+		d.foreignNonces = append(d.foreignNonces, foreignNonce{nonce: host.Nonce, seen: now})
+	}
+	recent := []foreignNonce{}
+	for _, f := range d.foreignNonces {
+		if f.nonce != host.RegenerateNonce && now.Sub(f.seen) <= HostConflictWindow {
+			recent = append(recent, f)
+		}
+	}
+	d.foreignNonces = recent
+
+	if len(recent) >= HostConflictThreshold {
+		nonces := []string{d.nonce}
+		seen := map[string]bool{d.nonce: true}
+		for _, f := range recent {
+			if !seen[f.nonce] {
+				seen[f.nonce] = true
+				nonces = append(nonces, f.nonce)
+			}
+		}
+		if !host.Conflicted {
+			logrus.Errorf("CONFLICT: host %v is registered by multiple machines, with nonces %v. "+
+				"Stopped scheduling to it. Choose the nonce of the machine to register again with the resolveConflict action",
+				host.UUID, nonces)
+		}
+		host.Conflicted = true
+		host.ConflictNonces = nonces
+	} else if len(recent) == 0 && host.Conflicted {
+		logrus.Infof("host %v conflict cleared", host.UUID)
+		host.Conflicted = false
+		host.ConflictNonces = nil
+	}
+}
+
+// regenerateUUID registers the current machine again as a new host, leaving
+// the host record to the other machine
+func (d *dockerOrc) regenerateUUID() error {
+	old := d.currentHost.UUID
+	if err := os.Remove(hostUUIDFile); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "fail to remove uuid of host %v", old)
+	}
+	if err := d.Register(d.currentHost.Address); err != nil {
+		return errors.Wrapf(err, "fail to register host %v again", old)
+	}
+	logrus.Warnf("host %v registered again as %v to resolve the conflict", old, d.currentHost.UUID)
 	return nil
 }
 
+// updateHost applies update to the record of the host, retrying if the
+// record is modified concurrently
+func (d *dockerOrc) updateHost(id string, update func(host *types.HostInfo)) error {
+	if err := d.kv.UpdateHost(id, func(host *types.HostInfo) (*types.HostInfo, error) {
+		if host == nil {
+			return nil, errors.Errorf("cannot find host %v", id)
+		}
+		update(host)
+		return host, nil
+	}); err != nil {
+		return errors.Wrapf(err, "fail to update host %v", id)
+	}
+	return nil
+}
+
+func (d *dockerOrc) SetHostRegenerateNonce(id, nonce string) error {
+	return d.updateHost(id, func(host *types.HostInfo) {
+		host.RegenerateNonce = nonce
+	})
+}
+
+func (d *dockerOrc) SetHostSchedulable(id string, schedulable bool) error {
+	return d.updateHost(id, func(host *types.HostInfo) {
+		host.Unschedulable = !schedulable
+	})
+}
+
+func (d *dockerOrc) SetHostFailureDomain(id, domain string) error {
+	return d.updateHost(id, func(host *types.HostInfo) {
+		host.FailureDomain = domain
+	})
+}
+
+func (d *dockerOrc) SetHostSchedulingWeight(id string, weight int) error {
+	return d.updateHost(id, func(host *types.HostInfo) {
+		host.SchedulingWeight = weight
+	})
+}
+
+func (d *dockerOrc) SetHostRole(id string, role types.HostRole) error {
+	return d.updateHost(id, func(host *types.HostInfo) {
+		host.Role = role
+	})
+}
+
+func (d *dockerOrc) SetHostMaintenance(id string, window *types.MaintenanceWindow) error {
+	return d.updateHost(id, func(host *types.HostInfo) {
+		host.Maintenance = window
+	})
+}
+
 func (d *dockerOrc) GetHost(id string) (*types.HostInfo, error) {
 	return d.kv.GetHost(id)
 }
 
+func (d *dockerOrc) GetHosts(ids []string) (map[string]*types.HostInfo, error) {
+	return d.kv.GetHosts(ids)
+}
+
+func (d *dockerOrc) DeleteHost(id string) error {
+	if id == d.currentHost.UUID {
+		return errors.Errorf("cannot delete current host %v", id)
+	}
+	return d.kv.DeleteHost(id)
+}
+
 func (d *dockerOrc) ListHosts() (map[string]*types.HostInfo, error) {
 	return d.kv.ListHosts()
 }
@@ -223,31 +735,34 @@ func (d *dockerOrc) GetVolume(volumeName string) (*types.VolumeInfo, error) {
 }
 
 func (d *dockerOrc) UpdateVolume(volume *types.VolumeInfo) error {
-	v, err := d.kv.GetVolumeBase(volume.Name)
-	if err != nil {
-		return errors.Errorf("cannot update volume %v because it doesn't exists %+v", volume.Name, v)
+	if _, err := d.kv.UpdateVolumeBase(volume.Name, func(v *types.VolumeInfo) error {
+		*v = *volume
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "cannot update volume %v", volume.Name)
 	}
-	return d.kv.SetVolumeBase(volume)
+	return nil
+}
+
+func (d *dockerOrc) UpdateVolumeBase(volumeName string, update func(volume *types.VolumeInfo) error) (*types.VolumeInfo, error) {
+	return d.kv.UpdateVolumeBase(volumeName, update)
 }
 
 func (d *dockerOrc) ListVolumes() ([]*types.VolumeInfo, error) {
 	return d.kv.ListVolumes()
 }
 
+func (d *dockerOrc) ForEachVolume(after string, fn func(volume *types.VolumeInfo) error) error {
+	return d.kv.ForEachVolume(after, fn)
+}
+
 func (d *dockerOrc) MarkBadReplica(volumeName string, replica *types.ReplicaInfo) error {
-	v, err := d.kv.GetVolume(volumeName)
-	if err != nil {
-		return errors.Wrap(err, "fail to mark bad replica, cannot get volume")
-	}
-	for k, r := range v.Replicas {
-		if r.Name == replica.Name {
-			r.BadTimestamp = util.Now()
-			v.Replicas[k] = r
-			break
-		}
-	}
-	if err := d.UpdateVolume(v); err != nil {
-		return errors.Wrap(err, "fail to mark bad replica, cannot update volume")
+	if err := d.kv.UpdateVolumeReplica(volumeName, replica.Name, func(r *types.ReplicaInfo) error {
+		r.BadTimestamp = util.Now()
+		r.BadHostID = d.currentHost.UUID
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "fail to mark bad replica")
 	}
 	return nil
 }
@@ -270,6 +785,178 @@ func (d *dockerOrc) SetSettings(settings *types.SettingsInfo) error {
 	return d.kv.SetSettings(settings)
 }
 
+func (d *dockerOrc) UpdateSettings(author string, update func(*types.SettingsInfo) error) error {
+	return d.kv.UpdateSettings(author, &types.SettingsInfo{EngineImage: d.EngineImage}, update)
+}
+
+func (d *dockerOrc) GetSettingsHistory() ([]*types.SettingsRevision, error) {
+	return d.kv.GetSettingsHistory()
+}
+
+func (d *dockerOrc) PruneSettingsHistory(expired func(revision *types.SettingsRevision) bool) ([]*types.SettingsRevision, error) {
+	return d.kv.PruneSettingsHistory(expired)
+}
+
+func (d *dockerOrc) KeepSettingsRevision(revision int64, keep bool) error {
+	return d.kv.KeepSettingsRevision(revision, keep)
+}
+
+func (d *dockerOrc) SettingsHistoryCounts() (*types.RecordCounts, error) {
+	return d.kv.SettingsHistoryCounts()
+}
+
+func (d *dockerOrc) StateRevision(key string) (string, error) {
+	return d.kv.Revision(key)
+}
+
+func (d *dockerOrc) StateStaleSince() time.Time {
+	return d.kv.StaleSince()
+}
+
+func (d *dockerOrc) AppendVolumeEvents(volumeName string, events []*types.VolumeEvent) error {
+	return d.kv.AppendVolumeEvents(volumeName, events)
+}
+
+func (d *dockerOrc) ListVolumeEvents(volumeName string) ([]*types.VolumeEvent, error) {
+	return d.kv.ListVolumeEvents(volumeName)
+}
+
+func (d *dockerOrc) DeleteVolumeEvents(volumeName string) error {
+	return d.kv.DeleteVolumeEvents(volumeName)
+}
+
+func (d *dockerOrc) PruneVolumeEvents(volumeName string, expired func(events []*types.VolumeEvent) bool) ([][]*types.VolumeEvent, error) {
+	return d.kv.PruneVolumeEvents(volumeName, expired)
+}
+
+func (d *dockerOrc) KeepVolumeEvents(volumeName, batch string, keep bool) error {
+	return d.kv.KeepVolumeEvents(volumeName, batch, keep)
+}
+
+func (d *dockerOrc) VolumeEventCounts(volumeName string) (*types.RecordCounts, error) {
+	return d.kv.VolumeEventCounts(volumeName)
+}
+
+func (d *dockerOrc) RecordVolumeState(volumeName string, state types.VolumeState, hostID, at string) (bool, error) {
+	return d.kv.RecordVolumeState(volumeName, state, hostID, at)
+}
+
+func (d *dockerOrc) GetVolumeHistory(volumeName string, limit int) ([]types.StateTransition, error) {
+	return d.kv.GetVolumeHistory(volumeName, limit)
+}
+
+func (d *dockerOrc) DeleteVolumeHistory(volumeName string) error {
+	return d.kv.DeleteVolumeHistory(volumeName)
+}
+
+func (d *dockerOrc) PruneVolumeHistory(volumeName string, expired func(transition *types.StateTransition) bool) ([]types.StateTransition, error) {
+	return d.kv.PruneVolumeHistory(volumeName, expired)
+}
+
+func (d *dockerOrc) KeepVolumeState(volumeName string, seq int64, keep bool) error {
+	return d.kv.KeepVolumeState(volumeName, seq, keep)
+}
+
+func (d *dockerOrc) VolumeHistoryCounts(volumeName string) (*types.RecordCounts, error) {
+	return d.kv.VolumeHistoryCounts(volumeName)
+}
+
+func (d *dockerOrc) AcquireLock(lock *types.LockInfo, ttl time.Duration) error {
+	return d.kv.AcquireLock(lock, ttl)
+}
+
+func (d *dockerOrc) RefreshLock(name, operationID string, ttl time.Duration) error {
+	return d.kv.RefreshLock(name, operationID, ttl)
+}
+
+func (d *dockerOrc) ReleaseLock(name, operationID string) error {
+	return d.kv.ReleaseLock(name, operationID)
+}
+
+func (d *dockerOrc) ListLocks() ([]*types.LockInfo, error) {
+	return d.kv.ListLocks()
+}
+
+func (d *dockerOrc) RevokeLock(name, operationID, revokedBy string) (*types.LockInfo, error) {
+	return d.kv.RevokeLock(name, operationID, revokedBy)
+}
+
+func (d *dockerOrc) ListVolumeRawRecords(volumeName string) ([]*types.RawRecord, error) {
+	return d.kv.ListVolumeRawRecords(volumeName)
+}
+
+func (d *dockerOrc) SetVolumeRawRecord(volumeName string, record *types.RawRecord) error {
+	return d.kv.SetVolumeRawRecord(volumeName, record)
+}
+
+func (d *dockerOrc) GetClusterCA() (*types.ClusterCA, error) {
+	return d.kv.GetClusterCA()
+}
+
+func (d *dockerOrc) SetClusterCA(ca *types.ClusterCA) error {
+	return d.kv.SetClusterCA(ca)
+}
+
+func (d *dockerOrc) ListAPITokens() (map[string]*types.APIToken, error) {
+	return d.kv.ListAPITokens()
+}
+
+func (d *dockerOrc) SetAPIToken(token *types.APIToken) error {
+	return d.kv.SetAPIToken(token)
+}
+
+func (d *dockerOrc) DeleteAPIToken(id string) error {
+	return d.kv.DeleteAPIToken(id)
+}
+
+func (d *dockerOrc) GetBootstrapReport() (*types.BootstrapReport, error) {
+	return d.kv.GetBootstrapReport()
+}
+
+func (d *dockerOrc) SetBootstrapReport(report *types.BootstrapReport) error {
+	return d.kv.SetBootstrapReport(report)
+}
+
+func (d *dockerOrc) GetDrainProgress(hostID string) (*types.DrainProgress, error) {
+	return d.kv.GetDrainProgress(hostID)
+}
+
+func (d *dockerOrc) SetDrainProgress(progress *types.DrainProgress) error {
+	return d.kv.SetDrainProgress(progress)
+}
+
+func (d *dockerOrc) GetVolumeGroup(name string) (*types.VolumeGroup, error) {
+	return d.kv.GetVolumeGroup(name)
+}
+
+func (d *dockerOrc) ListVolumeGroups() (map[string]*types.VolumeGroup, error) {
+	return d.kv.ListVolumeGroups()
+}
+
+func (d *dockerOrc) SetVolumeGroup(group *types.VolumeGroup) error {
+	return d.kv.SetVolumeGroup(group)
+}
+
+func (d *dockerOrc) DeleteVolumeGroup(name string) error {
+	return d.kv.DeleteVolumeGroup(name)
+}
+
 func (d *dockerOrc) Scheduler() types.Scheduler {
 	return d.scheduler
 }
+
+// connectETCD checks the servers are reachable and take the credentials
+// before anything is read
+func connectETCD(servers []string, prefix string, opts kvstore.ETCDOptions) (*kvstore.ETCDBackend, error) {
+	backend, err := kvstore.NewETCDBackend(servers, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := backend.Check(prefix); err != nil {
+		if _, ok := err.(*kvstore.ErrETCDUnauthorized); ok {
+			return nil, errors.Wrapf(err, "check --etcd-username, and --etcd-password or %v", ETCDPasswordEnv)
+		}
+		return nil, err
+	}
+	return backend, nil
+}
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	dTypes "github.com/docker/docker/api/types"
+	dContainer "github.com/docker/docker/api/types/container"
+)
+
+var (
+	// FormatTimeout bounds each of the containers probing and formatting
+	// the device of a new volume
+	FormatTimeout = 5 * time.Minute
+)
+
+const (
+	// blkid -p exits with 2 if it finds no signature on the device
+	blkidNoSignature = 2
+)
+
+// formatDevice formats the device of the volume with fsType, unless blkid
+// finds a signature of any file system or partition table on it already.
+// Both run in short-lived privileged containers of the engine image, which
+// see the devices of the host under /host/dev.
+func (d *dockerOrc) formatDevice(data *dockerScheduleData) error {
+	device := filepath.Join("/host", d.getDeviceName(data.VolumeName))
+	exitCode, output, err := d.runDeviceTool(data, "blkid", []string{"blkid", "-p", device})
+	if err != nil {
+		return errors.Wrapf(err, "fail to probe device of volume %v", data.VolumeName)
+	}
+	switch exitCode {
+	case 0:
+		logrus.Infof("device of volume %v has a signature already, skip formatting it with %v: %v",
+			data.VolumeName, data.FsType, output)
+		return nil
+	case blkidNoSignature:
+	default:
+		return errors.Errorf("fail to probe device of volume %v, blkid exit code %v: %v",
+			data.VolumeName, exitCode, output)
+	}
+
+	mkfs := "mkfs." + string(data.FsType)
+	exitCode, output, err = d.runDeviceTool(data, "mkfs", []string{mkfs, device})
+	if err != nil {
+		return errors.Wrapf(err, "fail to format device of volume %v with %v", data.VolumeName, data.FsType)
+	}
+	if exitCode != 0 {
+		return errors.Errorf("fail to format device of volume %v, %v exit code %v: %v",
+			data.VolumeName, mkfs, exitCode, output)
+	}
+	logrus.Infof("formatted device of volume %v with %v", data.VolumeName, data.FsType)
+	return nil
+}
+
+// runDeviceTool runs cmd to completion in a privileged container, and
+// returns its exit code and logs. The container is removed afterwards.
+func (d *dockerOrc) runDeviceTool(data *dockerScheduleData, tool string, cmd []string) (int64, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), FormatTimeout)
+	defer cancel()
+
+	createBody, err := d.cli.ContainerCreate(ctx,
+		&dContainer.Config{
+			Image: data.EngineImage,
+			Cmd:   cmd,
+		},
+		&dContainer.HostConfig{
+			Binds:       []string{"/dev:/host/dev"},
+			Privileged:  true,
+			NetworkMode: "none",
+			// the logs are read back, whatever the log driver of the instances
+			LogConfig: dContainer.LogConfig{Type: "json-file"},
+		}, nil, data.InstanceName+"-"+tool)
+	if err != nil {
+		return 0, "", errors.Wrapf(err, "fail to create %v container", tool)
+	}
+	defer func() {
+		if err := d.cli.ContainerRemove(context.Background(), createBody.ID, dTypes.ContainerRemoveOptions{
+			RemoveVolumes: true,
+			Force:         true,
+		}); err != nil {
+			logrus.Warnf("fail to remove %v container %v: %v", tool, createBody.ID, err)
+		}
+	}()
+
+	if err := d.cli.ContainerStart(ctx, createBody.ID, dTypes.ContainerStartOptions{}); err != nil {
+		return 0, "", errors.Wrapf(err, "fail to start %v container", tool)
+	}
+	exitCode, err := d.cli.ContainerWait(ctx, createBody.ID)
+	if err != nil {
+		return 0, "", errors.Wrapf(err, "fail to wait for %v container", tool)
+	}
+	output, err := d.containerLogs(createBody.ID)
+	if err != nil {
+		output = "logs unavailable: " + err.Error()
+	}
+	return exitCode, output, nil
+}
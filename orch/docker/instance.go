@@ -1,12 +1,14 @@
 package docker
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
@@ -14,19 +16,41 @@ import (
 
 	dTypes "github.com/docker/docker/api/types"
 	dContainer "github.com/docker/docker/api/types/container"
+	dNetwork "github.com/docker/docker/api/types/network"
+	dCli "github.com/docker/docker/client"
 
+	"github.com/rancher/longhorn-manager/controller"
+	"github.com/rancher/longhorn-manager/orch"
 	"github.com/rancher/longhorn-manager/types"
 	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/trace"
 )
 
 const (
 	OrcName = "docker"
+
+	containerLogTail = 50
+
+	replicaDataDir = "/volume"
+	// the block device backing a replica, in its container
+	replicaBlockDevice = "/dev/longhorn-replica"
+
+	labelVolume       = "io.rancher.longhorn.volume"
+	labelGeneration   = "io.rancher.longhorn.generation"
+	labelInstanceType = "io.rancher.longhorn.type"
+	labelDNSAlias     = "io.rancher.longhorn.alias"
+	labelBlockDevice  = "io.rancher.longhorn.block-device"
 )
 
 var (
-	ContainerStopTimeout = 1 * time.Minute
-	WaitDeviceTimeout    = 30 //seconds
-	WaitAPITimeout       = 30 //seconds
+	waitForAPI               = util.WaitForAPI
+	waitForDevice            = util.WaitForDevice
+	getControllerReplicas    = controller.GetReplicaStates
+	createControllerSnapshot = controller.CreateSnapshot
+	listControllerSnapshots  = controller.ListSnapshots
+	deleteControllerSnapshot = controller.DeleteSnapshot
+	checkBlockDevice         = util.CheckBlockDevice
+	checkMountpoint          = util.CheckMountpoint
 )
 
 type dockerScheduleData struct {
@@ -35,65 +59,18 @@ type dockerScheduleData struct {
 	VolumeSize   string
 	EngineImage  string
 	ReplicaURLs  []string
-}
+	Generation   int64
+	CacheMode    types.CacheMode
 
-func (d *dockerOrc) ProcessSchedule(item *types.ScheduleItem) (*types.InstanceInfo, error) {
-	var (
-		data     dockerScheduleData
-		instance *types.InstanceInfo
-		err      error
-	)
+	RestartPolicy types.RestartPolicy
+	Env           []string
+	Healthcheck   *orch.Healthcheck
 
-	if item.Data.Orchestrator != OrcName {
-		return nil, errors.Errorf("received request for the wrong orchestrator %v", item.Data.Orchestrator)
-	}
-	if len(item.Data.Data) != 0 {
-		if err := json.Unmarshal(item.Data.Data, &data); err != nil {
-			return nil, errors.Wrap(err, "fail to parse schedule data")
-		}
-	}
-	if item.Instance.ID == "" {
-		return nil, errors.Errorf("empty instance ID")
-	}
-	input := &types.InstanceInfo{
-		ID:         item.Instance.ID,
-		HostID:     item.Instance.HostID,
-		Type:       item.Instance.Type,
-		VolumeName: item.Instance.VolumeName,
-		Name:       item.Instance.Name,
-	}
-	switch item.Action {
-	case types.ScheduleActionCreateController:
-		instance, err = d.createController(&data)
-	case types.ScheduleActionCreateReplica:
-		instance, err = d.createReplica(&data)
-	case types.ScheduleActionStartInstance:
-		instance, err = d.startInstance(input)
-	case types.ScheduleActionStopInstance:
-		instance, err = d.stopInstance(input)
-	case types.ScheduleActionDeleteInstance:
-		instance, err = d.removeInstance(input)
-	default:
-		return nil, errors.Errorf("cannot find specified action %v", item.Action)
-	}
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to process schedule")
-	}
-	if item.Action == types.ScheduleActionDeleteInstance {
-		err = d.removeInstanceMetadata(instance)
-	} else {
-		err = d.updateInstanceMetadata(instance)
-	}
-	if err != nil {
-		if item.Action == types.ScheduleActionCreateController ||
-			item.Action == types.ScheduleActionCreateReplica {
-			logrus.Warnf("failed to update instance metadata for %+v, cleaning up", instance)
-			d.removeInstance(instance)
-		}
+	DataIntegrity  types.DataIntegrity
+	ReplicaBacking types.ReplicaBacking
 
-		return nil, errors.Wrapf(err, "failed to update instance metadata for %+v", instance)
-	}
-	return instance, nil
+	// FsType is formatted on the device of the controller, if set
+	FsType types.FsType
 }
 
 func (d *dockerOrc) CreateController(volumeName, controllerName string, replicas map[string]*types.ReplicaInfo) (*types.ControllerInfo, error) {
@@ -138,6 +115,21 @@ func (d *dockerOrc) prepareCreateController(volumeName, controllerName string, r
 		VolumeName:   volumeName,
 		EngineImage:  volume.EngineImage,
 		ReplicaURLs:  []string{},
+		Generation:   volume.Generation,
+		CacheMode:    volume.CacheMode,
+	}
+	// a restored volume isn't fresh, and neither is one attached before
+	if !volume.FsInitialized && volume.FromBackup == "" {
+		data.FsType = volume.FsType
+	}
+	if data.RestartPolicy, err = d.restartPolicy(types.InstanceTypeController); err != nil {
+		return nil, errors.Wrap(err, "unable to create controller")
+	}
+	if data.Env, err = d.instanceEnv(volume); err != nil {
+		return nil, errors.Wrap(err, "unable to create controller")
+	}
+	if data.Healthcheck, err = d.healthcheck(types.InstanceTypeController, volume.EngineImage); err != nil {
+		return nil, errors.Wrap(err, "unable to create controller")
 	}
 	for _, name := range replicaNames {
 		replica := volume.Replicas[name]
@@ -160,67 +152,179 @@ func (d *dockerOrc) prepareCreateController(volumeName, controllerName string, r
 	}, nil
 }
 
-func (d *dockerOrc) createController(data *dockerScheduleData) (instance *types.InstanceInfo, err error) {
+// containerSpec is the request of the docker API creating a container
+type containerSpec struct {
+	Name             string                     `json:"name"`
+	Config           *dContainer.Config         `json:"config"`
+	HostConfig       *dContainer.HostConfig     `json:"hostConfig"`
+	NetworkingConfig *dNetwork.NetworkingConfig `json:"networkingConfig"`
+}
+
+// hostSpec is the config of the current host the containers are created
+// with
+type hostSpec struct {
+	Network   string
+	LogConfig dContainer.LogConfig
+	// address the new replicas by a network alias
+	ReplicaDNSAlias bool
+}
+
+func (d *dockerOrc) hostSpec() *hostSpec {
+	return &hostSpec{
+		Network:         d.Network,
+		LogConfig:       d.logConfig,
+		ReplicaDNSAlias: d.ReplicaDNSAlias && d.embeddedDNS(),
+	}
+}
+
+func (s *containerSpec) create(cli dockerClient) (dContainer.ContainerCreateCreatedBody, error) {
+	return cli.ContainerCreate(context.Background(), s.Config, s.HostConfig, s.NetworkingConfig, s.Name)
+}
+
+// controllerSpec builds the container of a controller, without side effects
+func controllerSpec(data *dockerScheduleData, host *hostSpec) (*containerSpec, error) {
+	cacheArgs, err := orch.ControllerCacheModeArgs(data.CacheMode)
+	if err != nil {
+		return nil, err
+	}
 	cmd := []string{
 		"launch", "controller",
 		"--listen", "0.0.0.0:9501",
 		"--frontend", "tgt",
 	}
+	cmd = append(cmd, cacheArgs...)
 	for _, url := range data.ReplicaURLs {
 		cmd = append(cmd, "--replica", url)
 	}
 	cmd = append(cmd, data.VolumeName)
 
-	createBody, err := d.cli.ContainerCreate(context.Background(),
-		&dContainer.Config{
-			Image: data.EngineImage,
-			Cmd:   cmd,
+	return &containerSpec{
+		Name: data.InstanceName,
+		Config: &dContainer.Config{
+			Image:       data.EngineImage,
+			Cmd:         cmd,
+			Env:         data.Env,
+			Healthcheck: healthConfig(data.Healthcheck),
+			Labels: map[string]string{
+				labelVolume:       data.VolumeName,
+				labelGeneration:   strconv.FormatInt(data.Generation, 10),
+				labelInstanceType: string(types.InstanceTypeController),
+			},
 		},
-		&dContainer.HostConfig{
+		HostConfig: &dContainer.HostConfig{
 			Binds: []string{
 				"/dev:/host/dev",
 				"/proc:/host/proc",
 			},
-			Privileged:  true,
-			NetworkMode: dContainer.NetworkMode(d.Network),
-		}, nil, data.InstanceName)
+			Privileged:    true,
+			NetworkMode:   dContainer.NetworkMode(host.Network),
+			RestartPolicy: dContainer.RestartPolicy{Name: string(data.RestartPolicy)},
+			LogConfig:     host.LogConfig,
+		},
+	}, nil
+}
+
+func (d *dockerOrc) createController(data *dockerScheduleData) (instance *types.InstanceInfo, err error) {
+	spec, err := controllerSpec(data, d.hostSpec())
 	if err != nil {
+		return nil, errors.Wrapf(err, "fail to create controller for %v", data.VolumeName)
+	}
+
+	// the steps are part of the operation of the manager on the volume
+	// when it runs on this host
+	span := trace.Current(data.VolumeName)
+
+	logrus.Debugf("creating controller %v of %v: %v", data.InstanceName, data.VolumeName, strings.Join(spec.Config.Cmd, " "))
+	step := span.Child("container create")
+	createBody, err := spec.create(d.cli)
+	if step.End(err); err != nil {
 		return nil, errors.Wrap(err, "fail to create controller container")
 	}
 
+	created := &types.InstanceInfo{
+		ID:         createBody.ID,
+		HostID:     d.GetCurrentHostID(),
+		Name:       data.InstanceName,
+		Type:       types.InstanceTypeController,
+		VolumeName: data.VolumeName,
+		Env:        data.Env,
+	}
 	defer func() {
-		if err != nil && instance != nil {
+		if err != nil {
 			logrus.Errorf("fail to start controller %v of %v, cleaning up: %v",
 				data.InstanceName, data.VolumeName, err)
-			d.removeInstance(instance)
+			d.removeInstance(created)
 			instance = nil
 		}
 	}()
 
-	instance = &types.InstanceInfo{
-		ID:         createBody.ID,
-		HostID:     d.GetCurrentHostID(),
-		Name:       data.InstanceName,
-		Type:       types.InstanceTypeController,
-		VolumeName: data.VolumeName,
+	step = span.Child("container start")
+	instance, err = d.startInstance(created)
+	if step.End(err); err != nil {
+		return nil, errors.Wrap(err, "fail to start controller container")
 	}
-	instance, err = d.startInstance(instance)
-	if err != nil {
-		return instance, errors.Wrap(err, "fail to start controller container")
+
+	url := "http://" + instance.Address + ":9501"
+	step = span.Child("wait api")
+	if err := waitForAPI(url, "/v1", d.timeouts.WaitAPI); err != nil {
+		step.End(err)
+		return nil, d.withContainerOutput(created.ID, errors.Wrapf(err, "fail to wait for api endpoint at %v", url))
 	}
+	step.End(nil)
 
-	url := "http://" + instance.Address + ":9501/v1"
-	if err := util.WaitForAPI(url, WaitAPITimeout); err != nil {
-		return instance, errors.Wrapf(err, "fail to wait for api endpoint at %v", url)
+	step = span.Child("wait device")
+	if err := waitForDevice(d.getDeviceName(data.VolumeName), d.timeouts.WaitDevice); err != nil {
+		step.End(err)
+		return nil, d.withContainerOutput(created.ID, errors.Wrapf(err, "fail to create controller for %v", instance.VolumeName))
 	}
+	step.End(nil)
 
-	if err := util.WaitForDevice(d.getDeviceName(data.VolumeName), WaitDeviceTimeout); err != nil {
-		return instance, errors.Wrapf(err, "fail to create controller for %v", instance.VolumeName)
+	step = span.Child("verify replicas")
+	if err := verifyControllerReplicas(instance, data.ReplicaURLs); err != nil {
+		step.End(err)
+		return nil, d.withContainerOutput(created.ID, err)
+	}
+	step.End(nil)
+
+	if data.FsType != types.FsTypeRaw {
+		step = span.Child("format device")
+		if err := d.formatDevice(data); err != nil {
+			step.End(err)
+			return nil, err
+		}
+		step.End(nil)
 	}
 
 	return instance, nil
 }
 
+// verifyControllerReplicas makes sure the controller is serving the device
+// with at least one of the replicas. Missing replicas will be rebuilt later.
+func verifyControllerReplicas(instance *types.InstanceInfo, replicaURLs []string) error {
+	replicas, err := getControllerReplicas(instance.Address)
+	if err != nil {
+		return errors.Wrapf(err, "fail to get replicas of controller for %v", instance.VolumeName)
+	}
+	connected := map[string]bool{}
+	for _, replica := range replicas {
+		if replica.Mode == types.ReplicaModeRW || replica.Mode == types.ReplicaModeWO {
+			connected["tcp://"+replica.Address+":9502"] = true
+		}
+	}
+	attached := 0
+	for _, url := range replicaURLs {
+		if connected[url] {
+			attached++
+		} else {
+			logrus.Warnf("replica %v is not attached to controller for %v", url, instance.VolumeName)
+		}
+	}
+	if attached == 0 {
+		return errors.Errorf("none of replicas %v is attached to controller for %v", replicaURLs, instance.VolumeName)
+	}
+	return nil
+}
+
 func (d *dockerOrc) getDeviceName(volumeName string) string {
 	return filepath.Join("/dev/longhorn/", volumeName)
 }
@@ -249,27 +353,92 @@ func (d *dockerOrc) CreateReplica(volumeName, replicaName string) (*types.Replic
 		Data: *data,
 	}
 
-	policy := d.prepareCreateReplicaPolicy(volume)
+	policy := d.prepareCreateReplicaPolicy(volume, replicaName)
 
 	instance, err := d.scheduler.Schedule(schedule, policy)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Fail to create replica for %v", volumeName)
 	}
-	return &types.ReplicaInfo{
-		InstanceInfo: *instance,
-	}, nil
+	replica, err := d.kv.GetVolumeReplica(volumeName, replicaName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get replica %v of %v", replicaName, volumeName)
+	}
+	if replica == nil {
+		replica = &types.ReplicaInfo{InstanceInfo: *instance}
+	}
+	replica.PreferredHostID = policy.PreferredHostID
+	if replica.PreferredHostID == "" {
+		replica.PreferredHostID = instance.HostID
+	}
+	if err := d.kv.SetVolumeReplica(replica); err != nil {
+		return nil, errors.Wrapf(err, "unable to set preferred host of replica %v of %v", replicaName, volumeName)
+	}
+	if replica.HostID != replica.PreferredHostID {
+		logrus.Infof("replica %v of %v placed on host %v, preferred host %v", replicaName, volumeName, replica.HostID, replica.PreferredHostID)
+	}
+	return replica, nil
 }
 
-func (d *dockerOrc) prepareCreateReplicaPolicy(volume *types.VolumeInfo) *types.SchedulePolicy {
+// rebuiltPreferredHost returns the preferred host of the most recent bad
+// replica not rebuilt yet, i.e. no good replica has the same preferred host
+func rebuiltPreferredHost(volume *types.VolumeInfo) string {
+	kept := map[string]bool{}
+	for _, r := range volume.Replicas {
+		if r.BadTimestamp == "" {
+			kept[r.PreferredHostID] = true
+		}
+	}
+	preferred, latest := "", ""
+	for _, r := range volume.Replicas {
+		if r.BadTimestamp == "" || r.PreferredHostID == "" || kept[r.PreferredHostID] {
+			continue
+		}
+		if latest == "" || r.BadTimestamp > latest {
+			preferred, latest = r.PreferredHostID, r.BadTimestamp
+		}
+	}
+	return preferred
+}
+
+func (d *dockerOrc) prepareCreateReplicaPolicy(volume *types.VolumeInfo, replicaName string) *types.SchedulePolicy {
+	if volume.ReplicaPlan.Pending() {
+		for _, r := range volume.ReplicaPlan.Replicas {
+			if r.Name == replicaName {
+				return &types.SchedulePolicy{
+					Binding:   types.SchedulePolicyBindingHost,
+					HostIDMap: map[string]struct{}{r.HostID: {}},
+				}
+			}
+		}
+	}
+	if volume.Mode == types.VolumeModeLocal && !volume.Converting(types.VolumeModeReplicated) {
+		return &types.SchedulePolicy{
+			Binding:   types.SchedulePolicyBindingHost,
+			HostIDMap: map[string]struct{}{volume.PreferredHostID: {}},
+		}
+	}
 	policy := &types.SchedulePolicy{
 		Binding:   types.SchedulePolicyBindingSoftAntiAffinity,
 		HostIDMap: map[string]struct{}{},
+
+		Replicas:           volume.NumberOfReplicas,
+		MaxReplicasPerZone: volume.MaxReplicasPerZone,
+		MinZones:           volume.MinZones,
+
+		PreferredHostID: rebuiltPreferredHost(volume),
 	}
 	for _, replica := range volume.Replicas {
 		if replica.BadTimestamp == "" {
 			policy.HostIDMap[replica.HostID] = struct{}{}
 		}
 	}
+	// the hosts of the stuck or cancelled rebuilds are avoided too
+	for _, hostID := range volume.RebuildExcludedHosts {
+		policy.HostIDMap[hostID] = struct{}{}
+		if policy.PreferredHostID == hostID {
+			policy.PreferredHostID = ""
+		}
+	}
 	return policy
 }
 
@@ -282,6 +451,20 @@ func (d *dockerOrc) prepareCreateReplica(volume *types.VolumeInfo, replicaName s
 		VolumeSize:   strconv.FormatInt(volume.Size, 10),
 		InstanceName: replicaName,
 		EngineImage:  volume.EngineImage,
+
+		DataIntegrity:  volume.DataIntegrity,
+		ReplicaBacking: volume.ReplicaBacking,
+	}
+	restartPolicy, err := d.restartPolicy(types.InstanceTypeReplica)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create replica")
+	}
+	data.RestartPolicy = restartPolicy
+	if data.Env, err = d.instanceEnv(volume); err != nil {
+		return nil, errors.Wrap(err, "unable to create replica")
+	}
+	if data.Healthcheck, err = d.healthcheck(types.InstanceTypeReplica, volume.EngineImage); err != nil {
+		return nil, errors.Wrap(err, "unable to create replica")
 	}
 	bData, err := json.Marshal(data)
 	if err != nil {
@@ -293,38 +476,116 @@ func (d *dockerOrc) prepareCreateReplica(volume *types.VolumeInfo, replicaName s
 	}, nil
 }
 
-func (d *dockerOrc) createReplica(data *dockerScheduleData) (*types.InstanceInfo, error) {
+// replicaSpec builds the container of a replica, backed by blockDevice of
+// the host with the block replica backing, without side effects
+func replicaSpec(data *dockerScheduleData, host *hostSpec, blockDevice string) (*containerSpec, error) {
+	integrityArgs, err := orch.ReplicaDataIntegrityArgs(data.DataIntegrity)
+	if err != nil {
+		return nil, err
+	}
 	cmd := []string{
 		"launch", "replica",
 		"--listen", "0.0.0.0:9502",
 		"--size", data.VolumeSize,
-		"/volume",
 	}
-	createBody, err := d.cli.ContainerCreate(context.Background(),
-		&dContainer.Config{
-			Image: data.EngineImage,
-			Volumes: map[string]struct{}{
-				"/volume": {},
+	cmd = append(cmd, integrityArgs...)
+
+	labels := map[string]string{
+		labelVolume:       data.VolumeName,
+		labelInstanceType: string(types.InstanceTypeReplica),
+	}
+	volumes := map[string]struct{}{
+		replicaDataDir: {},
+	}
+	backingPath := replicaDataDir
+	var devices []dContainer.DeviceMapping
+	if data.ReplicaBacking == types.ReplicaBackingBlock {
+		if blockDevice == "" {
+			return nil, errors.Errorf("no block device for replica %v", data.InstanceName)
+		}
+		labels[labelBlockDevice] = blockDevice
+		devices = []dContainer.DeviceMapping{{
+			PathOnHost:        blockDevice,
+			PathInContainer:   replicaBlockDevice,
+			CgroupPermissions: "rwm",
+		}}
+		backingPath, volumes = replicaBlockDevice, nil
+	}
+	backingArgs, err := orch.ReplicaBackingArgs(data.ReplicaBacking, backingPath)
+	if err != nil {
+		return nil, err
+	}
+	cmd = append(cmd, backingArgs...)
+	var networking *dNetwork.NetworkingConfig
+	if alias := replicaAlias(host, data.InstanceName); alias != "" {
+		labels[labelDNSAlias] = alias
+		networking = &dNetwork.NetworkingConfig{
+			EndpointsConfig: map[string]*dNetwork.EndpointSettings{
+				host.Network: {Aliases: []string{alias}},
 			},
-			Cmd: cmd,
+		}
+	}
+
+	return &containerSpec{
+		Name: data.InstanceName,
+		Config: &dContainer.Config{
+			Image:       data.EngineImage,
+			Volumes:     volumes,
+			Cmd:         cmd,
+			Env:         data.Env,
+			Healthcheck: healthConfig(data.Healthcheck),
+			Labels:      labels,
+		},
+		HostConfig: &dContainer.HostConfig{
+			Privileged:    true,
+			NetworkMode:   dContainer.NetworkMode(host.Network),
+			RestartPolicy: dContainer.RestartPolicy{Name: string(data.RestartPolicy)},
+			LogConfig:     host.LogConfig,
+			Resources:     dContainer.Resources{Devices: devices},
 		},
-		&dContainer.HostConfig{
-			Privileged:  true,
-			NetworkMode: dContainer.NetworkMode(d.Network),
-		}, nil, data.InstanceName)
+		NetworkingConfig: networking,
+	}, nil
+}
+
+func (d *dockerOrc) createReplica(data *dockerScheduleData) (*types.InstanceInfo, error) {
+	blockDevice := ""
+	if data.ReplicaBacking == types.ReplicaBackingBlock {
+		// until the container is labeled with the device
+		d.blockDeviceLock.Lock()
+		defer d.blockDeviceLock.Unlock()
+		var err error
+		if blockDevice, err = d.replicaBlockDevice(data); err != nil {
+			return nil, errors.Wrapf(err, "fail to create replica for %v", data.VolumeName)
+		}
+	} else if err := d.checkReplicaMountpoints(data); err != nil {
+		return nil, errors.Wrapf(err, "fail to create replica for %v", data.VolumeName)
+	}
+	spec, err := replicaSpec(data, d.hostSpec(), blockDevice)
 	if err != nil {
 		return nil, errors.Wrapf(err, "fail to create replica for %v", data.VolumeName)
 	}
 
+	span := trace.Current(data.VolumeName)
+
+	logrus.Debugf("creating replica %v of %v: %v", data.InstanceName, data.VolumeName, strings.Join(spec.Config.Cmd, " "))
+	step := span.Child("container create")
+	createBody, err := spec.create(d.cli)
+	if step.End(err); err != nil {
+		return nil, errors.Wrapf(err, "fail to create replica for %v", data.VolumeName)
+	}
+
 	input := &types.InstanceInfo{
-		ID:         createBody.ID,
-		HostID:     d.GetCurrentHostID(),
-		Name:       data.InstanceName,
-		Type:       types.InstanceTypeReplica,
-		VolumeName: data.VolumeName,
+		ID:          createBody.ID,
+		HostID:      d.GetCurrentHostID(),
+		Name:        data.InstanceName,
+		Type:        types.InstanceTypeReplica,
+		VolumeName:  data.VolumeName,
+		Env:         data.Env,
+		BlockDevice: blockDevice,
 	}
 	instance, err := d.refreshInstanceInfo(input)
 	if err != nil {
+		err = d.withContainerOutput(input.ID, err)
 		logrus.Errorf("fail to create replica %v of %v, cleaning up: %v", data.InstanceName, data.VolumeName, err)
 		d.removeInstance(input)
 		return nil, errors.Wrapf(err, "fail to create replica for %v", input.VolumeName)
@@ -333,6 +594,88 @@ func (d *dockerOrc) createReplica(data *dockerScheduleData) (*types.InstanceInfo
 	return instance, nil
 }
 
+// checkReplicaMountpoints makes sure the mountpoints configured on the host
+// are mounted and can take the data of the replica
+func (d *dockerOrc) checkReplicaMountpoints(data *dockerScheduleData) error {
+	if len(d.ReplicaMountpoints) == 0 {
+		return nil
+	}
+	size, err := strconv.ParseInt(data.VolumeSize, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "invalid size of volume %v", data.VolumeName)
+	}
+	for _, path := range d.ReplicaMountpoints {
+		if err := checkMountpoint(path, size); err != nil {
+			return errors.Wrapf(err, "host %v", d.GetCurrentHostID())
+		}
+	}
+	return nil
+}
+
+func (d *dockerOrc) replicaBlockDevice(data *dockerScheduleData) (string, error) {
+	size, err := strconv.ParseInt(data.VolumeSize, 10, 64)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid size of volume %v", data.VolumeName)
+	}
+	return d.freeBlockDevice(size)
+}
+
+// freeBlockDevice returns the first block device of the host configured for
+// the replicas no container is labeled with, which nothing else uses and is
+// at least size bytes
+func (d *dockerOrc) freeBlockDevice(size int64) (string, error) {
+	if len(d.ReplicaBlockDevices) == 0 {
+		return "", errors.Errorf("no replica block device configured on host %v", d.GetCurrentHostID())
+	}
+	containers, err := d.cli.ContainerList(context.Background(), dTypes.ContainerListOptions{All: true})
+	if err != nil {
+		return "", errors.Wrap(err, "fail to list containers")
+	}
+	used := map[string]string{}
+	for _, c := range containers {
+		if device := c.Labels[labelBlockDevice]; device != "" {
+			used[device] = c.ID
+		}
+	}
+	reasons := []string{}
+	for _, device := range d.ReplicaBlockDevices {
+		if id, ok := used[device]; ok {
+			reasons = append(reasons, fmt.Sprintf("block device %v is used by container %v", device, id))
+			continue
+		}
+		if err := checkBlockDevice(device, size); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		return device, nil
+	}
+	return "", errors.Errorf("no free replica block device on host %v: %v", d.GetCurrentHostID(), strings.Join(reasons, "; "))
+}
+
+// embeddedDNS tells if the containers on the network can resolve each other
+// by name, which Docker only does on user-defined networks
+func (d *dockerOrc) embeddedDNS() bool {
+	switch d.Network {
+	case "", "default", "bridge", "host", "none":
+		return false
+	}
+	return true
+}
+
+// replicaAlias returns the network alias of a new replica, or "" to address
+// it by IP
+func replicaAlias(host *hostSpec, name string) string {
+	if !host.ReplicaDNSAlias {
+		return ""
+	}
+	alias := util.DNSLabel(name)
+	if err := util.ValidateDNSLabel(alias); err != nil {
+		logrus.Warnf("replica %v is addressed by IP: %v", name, err)
+		return ""
+	}
+	return alias
+}
+
 func (d *dockerOrc) refreshInstanceInfo(instance *types.InstanceInfo) (*types.InstanceInfo, error) {
 	inspectJSON, err := d.cli.ContainerInspect(context.Background(), instance.ID)
 	if err != nil {
@@ -347,6 +690,13 @@ func (d *dockerOrc) refreshInstanceInfo(instance *types.InstanceInfo) (*types.In
 		HostID:     d.GetCurrentHostID(),
 		Running:    inspectJSON.State.Running,
 		VolumeName: instance.VolumeName,
+		Env:        instance.Env,
+	}
+	if inspectJSON.Config != nil {
+		info.BlockDevice = inspectJSON.Config.Labels[labelBlockDevice]
+	}
+	if inspectJSON.State.Health != nil {
+		info.Health = types.InstanceHealth(inspectJSON.State.Health.Status)
 	}
 	if d.Network == "" {
 		info.Address = inspectJSON.NetworkSettings.IPAddress
@@ -355,8 +705,12 @@ func (d *dockerOrc) refreshInstanceInfo(instance *types.InstanceInfo) (*types.In
 	}
 	if info.Running && info.Address == "" {
 		msg := fmt.Sprintf("BUG: Cannot find IP address of %v", instance.ID)
-		logrus.Errorf(msg)
-		return nil, errors.Errorf(msg)
+		logrus.Error(msg)
+		return nil, errors.New(msg)
+	}
+	// the alias stays the same when the container gets another IP
+	if inspectJSON.Config != nil && inspectJSON.Config.Labels[labelDNSAlias] != "" && info.Running {
+		info.Address = inspectJSON.Config.Labels[labelDNSAlias]
 	}
 	return info, nil
 }
@@ -397,11 +751,138 @@ func (d *dockerOrc) StartInstance(instance *types.InstanceInfo) (*types.Instance
 	return ret, nil
 }
 
+// restartPolicy returns the restart policy of the containers of the type.
+// Controllers are not restarted by default, their failover is up to the
+// manager.
+func (d *dockerOrc) restartPolicy(instanceType types.InstanceType) (types.RestartPolicy, error) {
+	settings, err := d.GetSettings()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get settings")
+	}
+	if instanceType == types.InstanceTypeController {
+		if settings.ControllerRestartPolicy == types.RestartPolicyDefault {
+			return types.RestartPolicyNo, nil
+		}
+		return settings.ControllerRestartPolicy, nil
+	}
+	if settings.ReplicaRestartPolicy == types.RestartPolicyDefault {
+		return types.RestartPolicyUnlessStopped, nil
+	}
+	return settings.ReplicaRestartPolicy, nil
+}
+
+// healthcheck is the healthcheck of the new instances of the type, nil if
+// the engine cannot be pinged
+func (d *dockerOrc) healthcheck(instanceType types.InstanceType, engineImage string) (*orch.Healthcheck, error) {
+	settings, err := d.GetSettings()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get settings")
+	}
+	return orch.InstanceHealthcheck(settings, instanceType, engineImage), nil
+}
+
+func healthConfig(h *orch.Healthcheck) *dContainer.HealthConfig {
+	if h == nil {
+		return nil
+	}
+	return &dContainer.HealthConfig{
+		Test:     append([]string{"CMD"}, h.Cmd...),
+		Interval: h.Interval,
+		Timeout:  h.Interval,
+		Retries:  h.Retries,
+	}
+}
+
+// containerHealth reads the health from the status of a listed container,
+// e.g. "Up 2 minutes (unhealthy)"
+func containerHealth(status string) types.InstanceHealth {
+	switch {
+	case strings.HasSuffix(status, "(health: starting)"):
+		return types.InstanceHealthStarting
+	case strings.HasSuffix(status, "(unhealthy)"):
+		return types.InstanceHealthUnhealthy
+	case strings.HasSuffix(status, "(healthy)"):
+		return types.InstanceHealthHealthy
+	}
+	return types.InstanceHealthNone
+}
+
+// instanceEnv is the environment of the new instances of the volume
+func (d *dockerOrc) instanceEnv(volume *types.VolumeInfo) ([]string, error) {
+	settings, err := d.GetSettings()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get settings")
+	}
+	return orch.InstanceEnv(settings, volume), nil
+}
+
 func (d *dockerOrc) startInstance(instance *types.InstanceInfo) (*types.InstanceInfo, error) {
+	// the container may have been restarted by docker already, adopt it
+	if info, err := d.refreshInstanceInfo(instance); err == nil && info.Running {
+		logrus.Infof("instance '%v' type %v is already running, adopting it", instance.ID, instance.Type)
+		return info, nil
+	}
 	if err := d.startContainer(instance.ID); err != nil {
-		return nil, errors.Wrapf(err, "fail to start instance '%v' type %v", instance.ID, instance.Type)
+		return nil, d.withContainerOutput(instance.ID,
+			errors.Wrapf(err, "fail to start instance '%v' type %v", instance.ID, instance.Type))
+	}
+	info, err := d.refreshInstanceInfo(instance)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Running {
+		return nil, d.withContainerOutput(instance.ID,
+			errors.Errorf("instance '%v' type %v exited right after start", instance.ID, instance.Type))
+	}
+	return info, nil
+}
+
+// withContainerOutput adds the exit code and the last lines of the logs of
+// the container to the error, to tell why the container failed
+func (d *dockerOrc) withContainerOutput(id string, err error) error {
+	exitCode := "unknown"
+	inspectJSON, inspectErr := d.cli.ContainerInspect(context.Background(), id)
+	if inspectErr == nil && inspectJSON.ContainerJSONBase != nil && inspectJSON.State != nil {
+		exitCode = strconv.Itoa(inspectJSON.State.ExitCode)
+	}
+	logs, logsErr := d.containerLogs(id)
+	if logsErr != nil {
+		logs = fmt.Sprintf("unavailable: %v", logsErr)
+	}
+	return errors.Errorf("%v\ncontainer %v exit code %v, last %v lines of logs:\n%v",
+		err, id, exitCode, containerLogTail, logs)
+}
+
+func (d *dockerOrc) containerLogs(id string) (string, error) {
+	r, err := d.cli.ContainerLogs(context.Background(), id, dTypes.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       strconv.Itoa(containerLogTail),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return demuxContainerLogs(r)
+}
+
+// demuxContainerLogs strips the headers Docker adds in front of each frame
+// of stdout and stderr, for containers without TTY
+func demuxContainerLogs(r io.Reader) (string, error) {
+	output := &bytes.Buffer{}
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return output.String(), nil
+			}
+			return output.String(), errors.Wrap(err, "fail to read logs header")
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(output, r, size); err != nil {
+			return output.String(), errors.Wrap(err, "fail to read logs")
+		}
 	}
-	return d.refreshInstanceInfo(instance)
 }
 
 func (d *dockerOrc) startContainer(id string) error {
@@ -428,15 +909,15 @@ func (d *dockerOrc) StopInstance(instance *types.InstanceInfo) (*types.InstanceI
 }
 
 func (d *dockerOrc) stopInstance(instance *types.InstanceInfo) (*types.InstanceInfo, error) {
-	if err := d.cli.ContainerStop(context.Background(),
-		instance.ID, &ContainerStopTimeout); err != nil {
+	if err := d.stopContainer(instance.ID); err != nil {
 		return nil, errors.Wrapf(err, "fail to start instance '%v'", instance.ID)
 	}
 	return d.refreshInstanceInfo(instance)
 }
 
 func (d *dockerOrc) stopContainer(id string) error {
-	return d.cli.ContainerStop(context.Background(), id, &ContainerStopTimeout)
+	timeout := d.timeouts.ContainerStop
+	return d.cli.ContainerStop(context.Background(), id, &timeout)
 }
 
 func (d *dockerOrc) RemoveInstance(instance *types.InstanceInfo) (*types.InstanceInfo, error) {
@@ -458,6 +939,110 @@ func (d *dockerOrc) RemoveInstance(instance *types.InstanceInfo) (*types.Instanc
 	return ret, nil
 }
 
+func (d *dockerOrc) ForgetInstance(instance *types.InstanceInfo) error {
+	return d.removeInstanceMetadata(instance)
+}
+
+func (d *dockerOrc) ReplicaDataPath(replica *types.ReplicaInfo) (string, error) {
+	if replica.HostID != d.GetCurrentHostID() {
+		return "", errors.Errorf("replica %v is on host %v, not the current host", replica.Name, replica.HostID)
+	}
+	inspectJSON, err := d.cli.ContainerInspect(context.Background(), replica.ID)
+	if err != nil {
+		return "", errors.Wrapf(err, "fail to inspect replica %v", replica.Name)
+	}
+	for _, mount := range inspectJSON.Mounts {
+		if mount.Destination == replicaDataDir {
+			return mount.Source, nil
+		}
+	}
+	return "", errors.Errorf("cannot find data directory of replica %v", replica.Name)
+}
+
+func (d *dockerOrc) ListLocalControllers() ([]*types.LocalController, error) {
+	containers, err := d.cli.ContainerList(context.Background(), dTypes.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to list containers")
+	}
+	controllers := []*types.LocalController{}
+	for _, c := range containers {
+		label, ok := c.Labels[labelGeneration]
+		if !ok {
+			continue
+		}
+		generation, err := strconv.ParseInt(label, 10, 64)
+		if err != nil {
+			logrus.Warnf("invalid generation %v of controller container %v", label, c.ID)
+			continue
+		}
+		controllers = append(controllers, &types.LocalController{
+			ID:         c.ID,
+			VolumeName: c.Labels[labelVolume],
+			Generation: generation,
+		})
+	}
+	return controllers, nil
+}
+
+// ListLocalInstances lists the containers labeled with their volume. The
+// replicas created before they were labeled aren't found.
+func (d *dockerOrc) ListLocalInstances() ([]*types.LocalInstance, error) {
+	containers, err := d.cli.ContainerList(context.Background(), dTypes.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to list containers")
+	}
+	instances := []*types.LocalInstance{}
+	for _, c := range containers {
+		volumeName, ok := c.Labels[labelVolume]
+		if !ok {
+			continue
+		}
+		instanceType := types.InstanceType(c.Labels[labelInstanceType])
+		if _, ok := c.Labels[labelGeneration]; ok && instanceType == types.InstanceTypeNone {
+			instanceType = types.InstanceTypeController
+		}
+		name := ""
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		instances = append(instances, &types.LocalInstance{
+			ID:         c.ID,
+			Name:       name,
+			Type:       instanceType,
+			VolumeName: volumeName,
+			Running:    c.State == "running",
+			Health:     containerHealth(c.Status),
+			Image:      c.Image,
+			ImageID:    c.ImageID,
+		})
+	}
+	return instances, nil
+}
+
+func (d *dockerOrc) GetImageID(image string) (string, error) {
+	inspect, _, err := d.cli.ImageInspectWithRaw(context.Background(), image)
+	if err != nil {
+		if dCli.IsErrImageNotFound(err) {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "fail to inspect image %v", image)
+	}
+	return inspect.ID, nil
+}
+
+func (d *dockerOrc) RemoveLocalController(id string) error {
+	if err := d.stopContainer(id); err != nil {
+		logrus.Warnf("fail to stop controller container %v, removing anyway: %v", id, err)
+	}
+	if err := d.cli.ContainerRemove(context.Background(), id, dTypes.ContainerRemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	}); err != nil {
+		return errors.Wrapf(err, "fail to remove controller container %v", id)
+	}
+	return nil
+}
+
 func (d *dockerOrc) removeInstance(instance *types.InstanceInfo) (*types.InstanceInfo, error) {
 	if err := d.removeContainer(instance.ID); err != nil {
 		return nil, errors.Wrapf(err, "Fail to remove instance %v", instance.ID)
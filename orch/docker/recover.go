@@ -0,0 +1,37 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/rancher/longhorn-manager/kvstore"
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// RecoverMetadata writes the metadata snapshot into the etcd prefix of the
+// flags, which must be empty, e.g. after etcd was lost
+func RecoverMetadata(c *cli.Context, snapshot *types.MetadataSnapshot, author string) error {
+	servers := c.StringSlice("etcd-servers")
+	if len(servers) == 0 {
+		return fmt.Errorf("Unspecified etcd servers")
+	}
+	etcdOptions, err := parseETCDOptions(c.String("etcd-cert"), c.String("etcd-key"), c.String("etcd-cacert"),
+		c.String("etcd-username"), c.String("etcd-password"))
+	if err != nil {
+		return err
+	}
+	etcdBackend, err := connectETCD(servers, c.String("etcd-prefix"), etcdOptions)
+	if err != nil {
+		return err
+	}
+	kvStore, err := kvstore.NewKVStore(c.String("etcd-prefix"), etcdBackend)
+	if err != nil {
+		return err
+	}
+	if err := kvStore.RestoreMetadata(snapshot, author); err != nil {
+		return errors.Wrap(err, "fail to recover metadata")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package docker
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// RenderController returns the request of the docker API which would create
+// the controller of the volume with the replicas on the current host,
+// without creating it
+func (d *dockerOrc) RenderController(volumeName, controllerName string, replicas map[string]*types.ReplicaInfo) (*types.InstanceRender, error) {
+	replicaNames := []string{}
+	for name := range replicas {
+		replicaNames = append(replicaNames, name)
+	}
+	scheduleData, err := d.prepareCreateController(volumeName, controllerName, replicaNames)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to render controller for %v", volumeName)
+	}
+	data := &dockerScheduleData{}
+	if err := json.Unmarshal(scheduleData.Data, data); err != nil {
+		return nil, errors.Wrapf(err, "fail to render controller for %v", volumeName)
+	}
+	spec, err := controllerSpec(data, d.hostSpec())
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to render controller for %v", volumeName)
+	}
+	return d.render(types.InstanceTypeController, volumeName, spec), nil
+}
+
+// RenderReplica returns the request of the docker API which would create
+// the replica of the volume on the current host, without creating it. The
+// block device is the one free now.
+func (d *dockerOrc) RenderReplica(volumeName, replicaName string) (*types.InstanceRender, error) {
+	volume, err := d.kv.GetVolume(volumeName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to render replica for %v", volumeName)
+	}
+	if volume == nil {
+		return nil, errors.Errorf("fail to render replica, cannot find volume %v", volumeName)
+	}
+	scheduleData, err := d.prepareCreateReplica(volume, replicaName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to render replica for %v", volumeName)
+	}
+	data := &dockerScheduleData{}
+	if err := json.Unmarshal(scheduleData.Data, data); err != nil {
+		return nil, errors.Wrapf(err, "fail to render replica for %v", volumeName)
+	}
+	blockDevice := ""
+	if data.ReplicaBacking == types.ReplicaBackingBlock {
+		if blockDevice, err = d.replicaBlockDevice(data); err != nil {
+			return nil, errors.Wrapf(err, "fail to render replica for %v", volumeName)
+		}
+	}
+	spec, err := replicaSpec(data, d.hostSpec(), blockDevice)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to render replica for %v", volumeName)
+	}
+	return d.render(types.InstanceTypeReplica, volumeName, spec), nil
+}
+
+func (d *dockerOrc) render(instanceType types.InstanceType, volumeName string, spec *containerSpec) *types.InstanceRender {
+	return &types.InstanceRender{
+		Orchestrator: OrcName,
+		HostID:       d.GetCurrentHostID(),
+		VolumeName:   volumeName,
+		InstanceName: spec.Name,
+		InstanceType: instanceType,
+		Payload:      spec,
+	}
+}
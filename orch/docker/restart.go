@@ -0,0 +1,124 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// RestartController starts the stopped container of the controller of the
+// volume again, its replicas are left as they are. It's only done if the
+// controller is on the current host and its container is still the one
+// CreateController would create now with the good replicas of the volume.
+// Otherwise it returns why not, the controller is to be recreated.
+func (d *dockerOrc) RestartController(volume *types.VolumeInfo) (*types.ControllerInfo, string, error) {
+	controller := volume.Controller
+	if controller == nil || controller.HostID != d.GetCurrentHostID() {
+		return nil, "the controller is not on the current host", nil
+	}
+	data, reason := d.controllerRestartReason(volume)
+	if reason != "" {
+		return nil, reason, nil
+	}
+
+	instance, err := d.StartInstance(&controller.InstanceInfo)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "fail to restart controller for %v", volume.Name)
+	}
+	url := "http://" + instance.Address + ":9501"
+	if err := waitForAPI(url, "/v1", d.timeouts.WaitAPI); err != nil {
+		return nil, "", d.withContainerOutput(instance.ID, errors.Wrapf(err, "fail to wait for api endpoint at %v", url))
+	}
+	if err := waitForDevice(d.getDeviceName(volume.Name), d.timeouts.WaitDevice); err != nil {
+		return nil, "", d.withContainerOutput(instance.ID, errors.Wrapf(err, "fail to restart controller for %v", volume.Name))
+	}
+	if err := verifyControllerReplicas(instance, data.ReplicaURLs); err != nil {
+		return nil, "", d.withContainerOutput(instance.ID, err)
+	}
+	return &types.ControllerInfo{InstanceInfo: *instance}, "", nil
+}
+
+// controllerRestartReason compares the container of the controller of the
+// volume with the one CreateController would create now. It returns the
+// schedule data of the controller, or why the container cannot be started
+// again as it is.
+func (d *dockerOrc) controllerRestartReason(volume *types.VolumeInfo) (*dockerScheduleData, string) {
+	controller := volume.Controller
+	inspectJSON, err := d.cli.ContainerInspect(context.Background(), controller.ID)
+	if err != nil {
+		return nil, fmt.Sprintf("its container is gone: %v", err)
+	}
+	if inspectJSON.ContainerJSONBase == nil || inspectJSON.State == nil || inspectJSON.Config == nil {
+		return nil, "its container cannot be inspected"
+	}
+	if inspectJSON.State.Running {
+		return nil, "its container is still running"
+	}
+
+	replicaNames := []string{}
+	for name, replica := range volume.Replicas {
+		if replica.BadTimestamp == "" && volume.StandbyReplicas[name] == nil {
+			replicaNames = append(replicaNames, name)
+		}
+	}
+	sort.Strings(replicaNames)
+	scheduleData, err := d.prepareCreateController(volume.Name, controller.Name, replicaNames)
+	if err != nil {
+		return nil, fmt.Sprintf("its config cannot be rendered: %v", err)
+	}
+	data := &dockerScheduleData{}
+	if err := json.Unmarshal(scheduleData.Data, data); err != nil {
+		return nil, fmt.Sprintf("its config cannot be rendered: %v", err)
+	}
+	spec, err := controllerSpec(data, d.hostSpec())
+	if err != nil {
+		return nil, fmt.Sprintf("its config cannot be rendered: %v", err)
+	}
+
+	config := inspectJSON.Config
+	if config.Image != spec.Config.Image {
+		return nil, fmt.Sprintf("its image %v differs from %v", config.Image, spec.Config.Image)
+	}
+	if !reflect.DeepEqual(controllerArgs(config.Cmd), controllerArgs(spec.Config.Cmd)) {
+		return nil, fmt.Sprintf("its command %v differs from %v", []string(config.Cmd), []string(spec.Config.Cmd))
+	}
+	for _, label := range []string{labelVolume, labelGeneration} {
+		if config.Labels[label] != spec.Config.Labels[label] {
+			return nil, fmt.Sprintf("its label %v=%v differs from %v", label, config.Labels[label], spec.Config.Labels[label])
+		}
+	}
+	// the environment of the image is added to the container's
+	env := map[string]bool{}
+	for _, e := range config.Env {
+		env[e] = true
+	}
+	for _, e := range spec.Config.Env {
+		if !env[e] {
+			return nil, fmt.Sprintf("its environment lacks %v", e)
+		}
+	}
+	return data, ""
+}
+
+// controllerArgs are the arguments of a controller command with the
+// replicas sorted, they're in no particular order
+func controllerArgs(cmd []string) []string {
+	args := []string{}
+	replicas := []string{}
+	for i := 0; i < len(cmd); i++ {
+		if cmd[i] == "--replica" && i+1 < len(cmd) {
+			replicas = append(replicas, cmd[i+1])
+			i++
+			continue
+		}
+		args = append(args, cmd[i])
+	}
+	sort.Strings(replicas)
+	return append(args, replicas...)
+}
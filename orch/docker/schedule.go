@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// scheduleClass describes what repeating an action does to the instance and
+// its metadata
+type scheduleClass int
+
+const (
+	// creates a new instance, removed if its metadata cannot be recorded
+	scheduleClassCreate scheduleClass = iota
+	// repeating has no further effect, the metadata is refreshed
+	scheduleClassIdempotent
+	// repeating has no further effect, the metadata is removed
+	scheduleClassRemove
+)
+
+type scheduleHandler struct {
+	class    scheduleClass
+	validate func(instance *types.InstanceInfo, data *dockerScheduleData) error
+	handle   func(d *dockerOrc, instance *types.InstanceInfo, data *dockerScheduleData) (*types.InstanceInfo, error)
+}
+
+// scheduleHandlers has a handler for every types.ScheduleActions
+var scheduleHandlers = map[types.ScheduleAction]*scheduleHandler{
+	types.ScheduleActionCreateController: {
+		class:    scheduleClassCreate,
+		validate: validateCreateController,
+		handle: func(d *dockerOrc, instance *types.InstanceInfo, data *dockerScheduleData) (*types.InstanceInfo, error) {
+			return d.createController(data)
+		},
+	},
+	types.ScheduleActionCreateReplica: {
+		class:    scheduleClassCreate,
+		validate: validateCreateReplica,
+		handle: func(d *dockerOrc, instance *types.InstanceInfo, data *dockerScheduleData) (*types.InstanceInfo, error) {
+			return d.createReplica(data)
+		},
+	},
+	types.ScheduleActionStartInstance: {
+		class:    scheduleClassIdempotent,
+		validate: validateInstance,
+		handle: func(d *dockerOrc, instance *types.InstanceInfo, data *dockerScheduleData) (*types.InstanceInfo, error) {
+			return d.startInstance(instance)
+		},
+	},
+	types.ScheduleActionStopInstance: {
+		class:    scheduleClassIdempotent,
+		validate: validateInstance,
+		handle: func(d *dockerOrc, instance *types.InstanceInfo, data *dockerScheduleData) (*types.InstanceInfo, error) {
+			return d.stopInstance(instance)
+		},
+	},
+	types.ScheduleActionDeleteInstance: {
+		class:    scheduleClassRemove,
+		validate: validateInstance,
+		handle: func(d *dockerOrc, instance *types.InstanceInfo, data *dockerScheduleData) (*types.InstanceInfo, error) {
+			return d.removeInstance(instance)
+		},
+	},
+}
+
+func validateInstance(instance *types.InstanceInfo, data *dockerScheduleData) error {
+	if !instance.Type.Valid() {
+		return errors.Errorf("invalid instance type %q", string(instance.Type))
+	}
+	return nil
+}
+
+func validateCreateController(instance *types.InstanceInfo, data *dockerScheduleData) error {
+	if data.InstanceName == "" || data.VolumeName == "" || data.EngineImage == "" {
+		return errors.Errorf("instance name, volume name and engine image required to create controller")
+	}
+	if len(data.ReplicaURLs) == 0 {
+		return errors.Errorf("replicas required to create controller %v", data.InstanceName)
+	}
+	return nil
+}
+
+func validateCreateReplica(instance *types.InstanceInfo, data *dockerScheduleData) error {
+	if data.InstanceName == "" || data.VolumeName == "" || data.EngineImage == "" {
+		return errors.Errorf("instance name, volume name and engine image required to create replica")
+	}
+	if data.VolumeSize == "" {
+		return errors.Errorf("volume size required to create replica %v", data.InstanceName)
+	}
+	return nil
+}
+
+func (d *dockerOrc) ProcessSchedule(ctx context.Context, item *types.ScheduleItem) (*types.InstanceInfo, error) {
+	var data dockerScheduleData
+
+	handler := scheduleHandlers[item.Action]
+	if !item.Action.Valid() || handler == nil {
+		return nil, &types.ErrUnknownAction{Action: item.Action}
+	}
+	if item.Data.Orchestrator != OrcName {
+		return nil, errors.Errorf("received request for the wrong orchestrator %v", item.Data.Orchestrator)
+	}
+	if len(item.Data.Data) != 0 {
+		if err := json.Unmarshal(item.Data.Data, &data); err != nil {
+			return nil, errors.Wrap(err, "fail to parse schedule data")
+		}
+	}
+	if item.Instance.ID == "" {
+		return nil, errors.Errorf("empty instance ID")
+	}
+	input := &types.InstanceInfo{
+		ID:         item.Instance.ID,
+		HostID:     item.Instance.HostID,
+		Type:       item.Instance.Type,
+		VolumeName: item.Instance.VolumeName,
+		Name:       item.Instance.Name,
+	}
+	if err := handler.validate(input, &data); err != nil {
+		return nil, errors.Wrapf(err, "invalid schedule request %v", item.Action)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "schedule abandoned before processing")
+	}
+	instance, err := handler.handle(d, input, &data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to process schedule")
+	}
+	// the scheduler has given up on the item, leave the metadata for the
+	// consistency audit to sort out
+	if err := ctx.Err(); err != nil {
+		logrus.Warnf("schedule %v of %+v finished after it was abandoned, metadata not updated", item.Action, instance)
+		return nil, errors.Wrap(err, "schedule abandoned")
+	}
+	if handler.class == scheduleClassRemove {
+		err = d.removeInstanceMetadata(instance)
+	} else {
+		err = d.updateInstanceMetadata(instance)
+	}
+	if err != nil {
+		if handler.class == scheduleClassCreate {
+			logrus.Warnf("failed to update instance metadata for %+v, cleaning up", instance)
+			d.removeInstance(instance)
+		}
+
+		return nil, errors.Wrapf(err, "failed to update instance metadata for %+v", instance)
+	}
+	return instance, nil
+}
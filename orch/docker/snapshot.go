@@ -0,0 +1,162 @@
+package docker
+
+import (
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/controller"
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func (d *dockerOrc) CreateSnapshot(volumeName, snapshotName string) (*types.SnapshotInfo, error) {
+	volume, err := d.kv.GetVolume(volumeName)
+	if err != nil {
+		return nil, err
+	}
+	if volume == nil {
+		return nil, errors.Errorf("unable to create snapshot %v: volume %v doesn't exist", snapshotName, volumeName)
+	}
+	if !controllerRunning(volume) {
+		return nil, errors.Errorf("unable to create snapshot %v: no controller running for volume %v", snapshotName, volumeName)
+	}
+	// checked first so the engine isn't asked for a snapshot not recorded
+	// afterwards, the record created below fails the same if another
+	// manager creates it meanwhile
+	existing, err := d.kv.GetVolumeSnapshot(volumeName, snapshotName)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, &types.ErrSnapshotExists{VolumeName: volumeName, SnapshotName: snapshotName}
+	}
+
+	snapshot, err := createControllerSnapshot(volume.Controller.Address, snapshotName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create snapshot %v of volume %v", snapshotName, volumeName)
+	}
+	if err := d.kv.CreateVolumeSnapshot(volumeName, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (d *dockerOrc) ListSnapshots(volumeName string) ([]*types.SnapshotInfo, error) {
+	volume, err := d.kv.GetVolume(volumeName)
+	if err != nil {
+		return nil, err
+	}
+	if volume == nil {
+		return nil, errors.Errorf("unable to list snapshots: volume %v doesn't exist", volumeName)
+	}
+	records, err := d.kv.ListVolumeSnapshots(volumeName)
+	if err != nil {
+		return nil, err
+	}
+	if !controllerRunning(volume) {
+		return orderSnapshots(records), nil
+	}
+
+	live, _, err := listControllerSnapshots(volume.Controller.Address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list snapshots of volume %v", volumeName)
+	}
+	snapshots := map[string]*types.SnapshotInfo{}
+	for _, snapshot := range live {
+		snapshots[snapshot.Name] = snapshot
+	}
+	for name := range records {
+		if snapshots[name] == nil {
+			logrus.Warnf("snapshot %v of volume %v is recorded but gone from its controller", name, volumeName)
+		}
+	}
+	return orderSnapshots(snapshots), nil
+}
+
+func (d *dockerOrc) DeleteSnapshot(volumeName, snapshotName string) error {
+	volume, err := d.kv.GetVolume(volumeName)
+	if err != nil {
+		return err
+	}
+	if volume == nil {
+		return errors.Errorf("unable to delete snapshot %v: volume %v doesn't exist", snapshotName, volumeName)
+	}
+	if snapshotName == controller.VolumeHeadName {
+		return errors.Errorf("unable to delete snapshot %v: it's the volume head of volume %v", snapshotName, volumeName)
+	}
+	if !controllerRunning(volume) {
+		return errors.Errorf("unable to delete snapshot %v: no controller running for volume %v", snapshotName, volumeName)
+	}
+	record, err := d.kv.GetVolumeSnapshot(volumeName, snapshotName)
+	if err != nil {
+		return err
+	}
+
+	live, head, err := listControllerSnapshots(volume.Controller.Address)
+	if err != nil {
+		return errors.Wrapf(err, "unable to list snapshots of volume %v", volumeName)
+	}
+	if snapshotName == head {
+		return errors.Errorf("unable to delete snapshot %v: the volume head of volume %v is on it", snapshotName, volumeName)
+	}
+	found := false
+	for _, snapshot := range live {
+		if snapshot.Name == snapshotName {
+			found = true
+		}
+	}
+	if found {
+		if err := deleteControllerSnapshot(volume.Controller.Address, snapshotName); err != nil {
+			return errors.Wrapf(err, "unable to delete snapshot %v of volume %v", snapshotName, volumeName)
+		}
+	} else if record == nil {
+		return errors.Errorf("unable to delete snapshot %v: volume %v has no such snapshot", snapshotName, volumeName)
+	} else {
+		logrus.Infof("snapshot %v of volume %v is gone from its controller, removing its record", snapshotName, volumeName)
+	}
+	if record != nil {
+		if err := d.kv.DeleteVolumeSnapshot(volumeName, snapshotName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func controllerRunning(volume *types.VolumeInfo) bool {
+	return volume.Controller != nil && volume.Controller.Running && volume.Controller.Address != ""
+}
+
+// orderSnapshots orders the snapshots along their parent chain, oldest
+// first. After a revert the snapshots branch off, the branches are ordered
+// by the creation of their first snapshot. A snapshot whose parent is gone
+// starts a chain of its own.
+func orderSnapshots(snapshots map[string]*types.SnapshotInfo) []*types.SnapshotInfo {
+	children := map[string][]*types.SnapshotInfo{}
+	for _, snapshot := range snapshots {
+		parent := snapshot.Parent
+		if snapshots[parent] == nil {
+			parent = ""
+		}
+		children[parent] = append(children[parent], snapshot)
+	}
+	for _, c := range children {
+		sort.Slice(c, func(i, j int) bool {
+			if c[i].Created != c[j].Created {
+				return c[i].Created < c[j].Created
+			}
+			return c[i].Name < c[j].Name
+		})
+	}
+
+	ordered := []*types.SnapshotInfo{}
+	var walk func(parent string)
+	walk = func(parent string) {
+		for _, snapshot := range children[parent] {
+			ordered = append(ordered, snapshot)
+			walk(snapshot.Name)
+		}
+	}
+	walk("")
+	return ordered
+}
@@ -0,0 +1,29 @@
+package orch
+
+import (
+	"sort"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+// InstanceEnv returns the environment of the instances of the volume in the
+// NAME=value form. The variables of the volume override the settings, and
+// the ones of the attach request override both.
+func InstanceEnv(settings *types.SettingsInfo, volume *types.VolumeInfo) []string {
+	merged := map[string]string{}
+	for name, value := range settings.InstanceEnv {
+		merged[name] = value
+	}
+	for name, value := range volume.InstanceEnv {
+		merged[name] = value
+	}
+	for name, value := range volume.AttachEnv {
+		merged[name] = value
+	}
+	env := []string{}
+	for name, value := range merged {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
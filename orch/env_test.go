@@ -0,0 +1,22 @@
+package orch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestInstanceEnv(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal([]string{}, InstanceEnv(&types.SettingsInfo{}, &types.VolumeInfo{}))
+
+	settings := &types.SettingsInfo{InstanceEnv: map[string]string{"HTTP_PROXY": "http://proxy:3128", "NO_PROXY": "10.0.0.0/8"}}
+	volume := &types.VolumeInfo{InstanceEnv: map[string]string{"NO_PROXY": "", "FEATURE_X": "on"}}
+	assert.Equal([]string{"FEATURE_X=on", "HTTP_PROXY=http://proxy:3128", "NO_PROXY="}, InstanceEnv(settings, volume))
+
+	volume.AttachEnv = map[string]string{"FEATURE_X": "off", "LOG_LEVEL": "debug"}
+	assert.Equal([]string{"FEATURE_X=off", "HTTP_PROXY=http://proxy:3128", "LOG_LEVEL=debug", "NO_PROXY="}, InstanceEnv(settings, volume))
+}
@@ -0,0 +1,61 @@
+package orch
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+var (
+	// HealthcheckVersion is the oldest engine version with the ping
+	// command, the instances of older engines get no healthcheck
+	HealthcheckVersion = util.Version{Major: 0, Minor: 4, Patch: 0}
+
+	DefaultHealthcheckInterval = 10 * time.Second
+	DefaultHealthcheckRetries  = 3
+
+	healthcheckAddresses = map[types.InstanceType]string{
+		types.InstanceTypeController: "localhost:9501",
+		types.InstanceTypeReplica:    "localhost:9502",
+	}
+)
+
+// Healthcheck is run in the container of an instance, which is unhealthy
+// after Retries failures in a row
+type Healthcheck struct {
+	Cmd      []string
+	Interval time.Duration
+	Retries  int
+}
+
+// InstanceHealthcheck returns the healthcheck of the instances of the type
+// running the engine image, nil if the engine is too old to be pinged
+func InstanceHealthcheck(settings *types.SettingsInfo, instanceType types.InstanceType, engineImage string) *Healthcheck {
+	address, ok := healthcheckAddresses[instanceType]
+	if !ok {
+		return nil
+	}
+	version, err := util.ImageVersion(engineImage)
+	if err != nil || version.Compare(HealthcheckVersion) < 0 {
+		return nil
+	}
+	h := &Healthcheck{
+		Cmd:      []string{"longhorn", "ping", "--address", address},
+		Interval: DefaultHealthcheckInterval,
+		Retries:  DefaultHealthcheckRetries,
+	}
+	if settings.HealthcheckInterval != "" {
+		if interval, err := time.ParseDuration(settings.HealthcheckInterval); err == nil && interval > 0 {
+			h.Interval = interval
+		} else {
+			logrus.Warnf("invalid healthcheckInterval setting %v, using %v", settings.HealthcheckInterval, DefaultHealthcheckInterval)
+		}
+	}
+	if settings.HealthcheckRetries > 0 {
+		h.Retries = settings.HealthcheckRetries
+	}
+	return h
+}
@@ -0,0 +1,34 @@
+package orch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestInstanceHealthcheck(t *testing.T) {
+	assert := require.New(t)
+
+	settings := &types.SettingsInfo{}
+	h := InstanceHealthcheck(settings, types.InstanceTypeReplica, "rancher/longhorn-engine:v0.4.0")
+	assert.Equal(&Healthcheck{
+		Cmd:      []string{"longhorn", "ping", "--address", "localhost:9502"},
+		Interval: DefaultHealthcheckInterval,
+		Retries:  DefaultHealthcheckRetries,
+	}, h)
+
+	settings = &types.SettingsInfo{HealthcheckInterval: "30s", HealthcheckRetries: 5}
+	h = InstanceHealthcheck(settings, types.InstanceTypeController, "rancher/longhorn-engine:v0.5.1")
+	assert.Equal([]string{"longhorn", "ping", "--address", "localhost:9501"}, h.Cmd)
+	assert.Equal(30*time.Second, h.Interval)
+	assert.Equal(5, h.Retries)
+
+	// the engines without the ping command, or of unknown version
+	for _, image := range []string{"rancher/longhorn-engine:v0.3.1", "rancher/longhorn-engine:latest", "engine"} {
+		assert.Nil(InstanceHealthcheck(settings, types.InstanceTypeReplica, image), image)
+	}
+	assert.Nil(InstanceHealthcheck(settings, types.InstanceTypeNone, "rancher/longhorn-engine:v0.4.0"))
+}
@@ -0,0 +1,46 @@
+package orch
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+const (
+	ReplicaChecksumFlag    = "--checksum"
+	ReplicaBlockDeviceFlag = "--block-device"
+)
+
+var (
+	replicaChecksumModes = map[types.DataIntegrity]string{
+		types.DataIntegrityDisabled:  "none",
+		types.DataIntegrityFastCheck: "fast",
+		types.DataIntegrityFull:      "full",
+	}
+)
+
+// ReplicaDataIntegrityArgs returns the replica launch arguments controlling
+// checksum verification. Volumes created before the data integrity mode was
+// introduced get the default fast check.
+func ReplicaDataIntegrityArgs(mode types.DataIntegrity) ([]string, error) {
+	if mode == types.DataIntegrityDefault {
+		mode = types.DataIntegrityFastCheck
+	}
+	checksum, ok := replicaChecksumModes[mode]
+	if !ok {
+		return nil, errors.Errorf("invalid data integrity mode '%s'", mode)
+	}
+	return []string{ReplicaChecksumFlag, checksum}, nil
+}
+
+// ReplicaBackingArgs returns the replica launch arguments locating its data,
+// the directory of the file backing or the device of the block backing
+func ReplicaBackingArgs(backing types.ReplicaBacking, path string) ([]string, error) {
+	switch backing {
+	case types.ReplicaBackingDefault, types.ReplicaBackingFile:
+		return []string{path}, nil
+	case types.ReplicaBackingBlock:
+		return []string{ReplicaBlockDeviceFlag, path}, nil
+	}
+	return nil, errors.Errorf("invalid replica backing '%s'", backing)
+}
@@ -0,0 +1,46 @@
+package orch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func TestReplicaDataIntegrityArgs(t *testing.T) {
+	assert := require.New(t)
+
+	expected := map[types.DataIntegrity][]string{
+		types.DataIntegrityDefault:   {"--checksum", "fast"},
+		types.DataIntegrityDisabled:  {"--checksum", "none"},
+		types.DataIntegrityFastCheck: {"--checksum", "fast"},
+		types.DataIntegrityFull:      {"--checksum", "full"},
+	}
+	for mode, args := range expected {
+		result, err := ReplicaDataIntegrityArgs(mode)
+		assert.Nil(err)
+		assert.Equal(args, result, "mode '%s'", mode)
+	}
+
+	_, err := ReplicaDataIntegrityArgs(types.DataIntegrity("paranoid"))
+	assert.NotNil(err)
+}
+
+func TestReplicaBackingArgs(t *testing.T) {
+	assert := require.New(t)
+
+	expected := map[types.ReplicaBacking][]string{
+		types.ReplicaBackingDefault: {"/volume"},
+		types.ReplicaBackingFile:    {"/volume"},
+		types.ReplicaBackingBlock:   {"--block-device", "/volume"},
+	}
+	for backing, args := range expected {
+		result, err := ReplicaBackingArgs(backing, "/volume")
+		assert.Nil(err)
+		assert.Equal(args, result, "backing '%s'", backing)
+	}
+
+	_, err := ReplicaBackingArgs(types.ReplicaBacking("nfs"), "/volume")
+	assert.NotNil(err)
+}
@@ -0,0 +1,34 @@
+package orch
+
+import (
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/rancher/longhorn-manager/util"
+)
+
+type Timeouts struct {
+	WaitDevice    time.Duration
+	WaitAPI       time.Duration
+	ContainerStop time.Duration
+}
+
+func ParseTimeouts(c *cli.Context) (*Timeouts, error) {
+	var err error
+
+	timeouts := DefaultTimeouts
+	for name, t := range map[string]*time.Duration{
+		WaitDeviceTimeoutParam:    &timeouts.WaitDevice,
+		WaitAPITimeoutParam:       &timeouts.WaitAPI,
+		ContainerStopTimeoutParam: &timeouts.ContainerStop,
+	} {
+		if !c.IsSet(name) && c.String(name) == "" {
+			continue
+		}
+		if *t, err = util.ParseDurationFlag(name, c.String(name)); err != nil {
+			return nil, err
+		}
+	}
+	return &timeouts, nil
+}
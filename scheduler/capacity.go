@@ -0,0 +1,153 @@
+package scheduler
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+var (
+	// MaxCapacityCheckPlacements bounds the replicas placed by
+	// SimulateCapacity
+	MaxCapacityCheckPlacements = 100000
+)
+
+// matchHost checks the host against the node selector of a capacity check
+func matchHost(host *types.HostInfo, selector map[string]string) (bool, error) {
+	for key, value := range selector {
+		var field string
+		switch key {
+		case "name":
+			field = host.Name
+		case "uuid":
+			field = host.UUID
+		case "failureDomain":
+			field = host.FailureDomain
+		default:
+			return false, errors.Errorf("invalid node selector key %v, expecting name, uuid or failureDomain", key)
+		}
+		if field != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// SimulateCapacity places the volumes of the check one at a time, the
+// replicas by the soft anti-affinity priority then the most space left, on a
+// copy of the capacity of the hosts. The hosts need their Detail. A host can
+// reserve its storage times the over-provisioning percentage, less the sizes
+// of the replicas already on it. Nothing is reserved for real.
+func SimulateCapacity(hosts map[string]*types.HostInfo, check *types.CapacityCheck) (*types.CapacityCheckResult, error) {
+	result := &types.CapacityCheckResult{
+		Requested:     check.Count,
+		LimitingHosts: []string{},
+		ExcludedHosts: map[string]string{},
+	}
+	overProvisioning := int64(check.OverProvisioningPercentage)
+	if overProvisioning == 0 {
+		overProvisioning = 100
+	}
+
+	eligible := map[string]*types.HostInfo{}
+	available := map[string]int64{}
+	replicas := map[string]int{}
+	for id, host := range hosts {
+		if host.Detail == nil {
+			return nil, errors.Errorf("no detail of host %v", id)
+		}
+		matched, err := matchHost(host, check.NodeSelector)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !matched:
+			result.ExcludedHosts[id] = "nodeSelector"
+		case !host.Detail.Schedulable:
+			result.ExcludedHosts[id] = string(host.Detail.UnschedulableReasons[0])
+		case host.StorageTotal == 0:
+			result.ExcludedHosts[id] = "unknownCapacity"
+		default:
+			eligible[id] = host
+			available[id] = host.StorageTotal*overProvisioning/100 - host.Detail.ReservedBytes
+			replicas[id] = host.Detail.Replicas
+		}
+	}
+
+	placements := 0
+	for result.Fits < check.Count {
+		if len(eligible) == 0 {
+			result.Limit = types.CapacityLimitNoHosts
+			break
+		}
+		if placements+check.Replicas > MaxCapacityCheckPlacements {
+			result.Limit = types.CapacityLimitIterations
+			break
+		}
+		placed, limit, limiting := placeVolume(eligible, available, replicas, check)
+		if limit != types.CapacityLimitNone {
+			result.Limit = limit
+			result.LimitingHosts = limiting
+			break
+		}
+		for _, id := range placed {
+			available[id] -= check.Size
+			replicas[id]++
+		}
+		placements += check.Replicas
+		result.Fits++
+	}
+	return result, nil
+}
+
+// placeVolume picks the hosts of the replicas of a volume without reserving
+// them, or returns why a replica cannot be placed and on which hosts
+func placeVolume(eligible map[string]*types.HostInfo, available map[string]int64, replicas map[string]int,
+	check *types.CapacityCheck) ([]string, types.CapacityLimit, []string) {
+	policy := &types.SchedulePolicy{
+		Binding:   types.SchedulePolicyBindingSoftAntiAffinity,
+		HostIDMap: map[string]struct{}{},
+	}
+	used := map[string]int{}
+	placed := []string{}
+	for i := 0; i < check.Replicas; i++ {
+		priority, err := hostPriorities(eligible, policy)
+		if err != nil {
+			return nil, types.CapacityLimitNoHosts, nil
+		}
+		best := ""
+		bestPriority := 0
+		outOfSpace := []string{}
+		capped := []string{}
+		for id := range eligible {
+			if available[id]-int64(used[id]+1)*check.Size < 0 {
+				outOfSpace = append(outOfSpace, id)
+				continue
+			}
+			if check.MaxReplicasPerHost > 0 && replicas[id]+used[id] >= check.MaxReplicasPerHost {
+				capped = append(capped, id)
+				continue
+			}
+			p := priority(id)
+			left := available[id] - int64(used[id])*check.Size
+			bestLeft := available[best] - int64(used[best])*check.Size
+			if best == "" || p < bestPriority || (p == bestPriority && (left > bestLeft || (left == bestLeft && id < best))) {
+				best, bestPriority = id, p
+			}
+		}
+		if best == "" {
+			if len(outOfSpace) > 0 {
+				sort.Strings(outOfSpace)
+				return nil, types.CapacityLimitSpace, outOfSpace
+			}
+			sort.Strings(capped)
+			return nil, types.CapacityLimitReplicaCap, capped
+		}
+		used[best]++
+		policy.HostIDMap[best] = struct{}{}
+		placed = append(placed, best)
+	}
+	return placed, types.CapacityLimitNone, nil
+}
@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+)
+
+func newCapacityHosts(storage map[string]int64) map[string]*types.HostInfo {
+	hosts := map[string]*types.HostInfo{}
+	for id, total := range storage {
+		hosts[id] = &types.HostInfo{
+			UUID:         id,
+			Name:         id,
+			StorageTotal: total,
+			Detail: &types.HostDetail{
+				Online:      true,
+				Schedulable: true,
+			},
+		}
+	}
+	return hosts
+}
+
+func TestSimulateCapacitySpace(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newCapacityHosts(map[string]int64{
+		"host-1": 100,
+		"host-2": 100,
+		"host-3": 50,
+	})
+	hosts["host-1"].Detail.ReservedBytes = 20
+	hosts["host-1"].Detail.Replicas = 2
+
+	result, err := SimulateCapacity(hosts, &types.CapacityCheck{
+		Count:    10,
+		Size:     20,
+		Replicas: 2,
+	})
+	assert.Nil(err)
+	assert.Equal(10, result.Requested)
+	// 80 + 100 + 50 bytes hold 4 + 5 + 2 replicas
+	assert.Equal(5, result.Fits)
+	assert.Equal(types.CapacityLimitSpace, result.Limit)
+	assert.Equal([]string{"host-1", "host-2", "host-3"}, result.LimitingHosts)
+
+	// nothing is reserved on the hosts
+	assert.Equal(int64(20), hosts["host-1"].Detail.ReservedBytes)
+	assert.Equal(2, hosts["host-1"].Detail.Replicas)
+
+	result, err = SimulateCapacity(hosts, &types.CapacityCheck{
+		Count:                      10,
+		Size:                       20,
+		Replicas:                   2,
+		OverProvisioningPercentage: 200,
+	})
+	assert.Nil(err)
+	assert.Equal(10, result.Fits)
+	assert.Equal(types.CapacityLimitNone, result.Limit)
+	assert.Empty(result.LimitingHosts)
+}
+
+func TestSimulateCapacityReplicaCap(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newCapacityHosts(map[string]int64{
+		"host-1": 1000,
+		"host-2": 1000,
+	})
+	hosts["host-2"].Detail.Replicas = 1
+
+	result, err := SimulateCapacity(hosts, &types.CapacityCheck{
+		Count:              5,
+		Size:               10,
+		Replicas:           2,
+		MaxReplicasPerHost: 3,
+	})
+	assert.Nil(err)
+	assert.Equal(2, result.Fits)
+	assert.Equal(types.CapacityLimitReplicaCap, result.Limit)
+	assert.Equal([]string{"host-1", "host-2"}, result.LimitingHosts)
+}
+
+func TestSimulateCapacityExcludedHosts(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newCapacityHosts(map[string]int64{
+		"host-1": 100,
+		"host-2": 100,
+		"host-3": 0,
+		"host-4": 100,
+	})
+	hosts["host-1"].FailureDomain = "zone-a"
+	hosts["host-2"].FailureDomain = "zone-b"
+	hosts["host-3"].FailureDomain = "zone-a"
+	hosts["host-4"].FailureDomain = "zone-a"
+	hosts["host-4"].Detail.Schedulable = false
+	hosts["host-4"].Detail.UnschedulableReasons = []types.HostCondition{types.HostConditionCordoned}
+
+	result, err := SimulateCapacity(hosts, &types.CapacityCheck{
+		Count:        3,
+		Size:         50,
+		Replicas:     1,
+		NodeSelector: map[string]string{"failureDomain": "zone-a"},
+	})
+	assert.Nil(err)
+	assert.Equal(2, result.Fits)
+	assert.Equal(types.CapacityLimitSpace, result.Limit)
+	assert.Equal([]string{"host-1"}, result.LimitingHosts)
+	assert.Equal(map[string]string{
+		"host-2": "nodeSelector",
+		"host-3": "unknownCapacity",
+		"host-4": string(types.HostConditionCordoned),
+	}, result.ExcludedHosts)
+
+	result, err = SimulateCapacity(hosts, &types.CapacityCheck{
+		Count:        1,
+		Size:         50,
+		Replicas:     1,
+		NodeSelector: map[string]string{"name": "host-9"},
+	})
+	assert.Nil(err)
+	assert.Equal(0, result.Fits)
+	assert.Equal(types.CapacityLimitNoHosts, result.Limit)
+
+	_, err = SimulateCapacity(hosts, &types.CapacityCheck{
+		Count:        1,
+		Size:         50,
+		Replicas:     1,
+		NodeSelector: map[string]string{"rack": "1"},
+	})
+	assert.NotNil(err)
+}
+
+func TestSimulateCapacityIterations(t *testing.T) {
+	assert := require.New(t)
+
+	oldMax := MaxCapacityCheckPlacements
+	defer func() { MaxCapacityCheckPlacements = oldMax }()
+	MaxCapacityCheckPlacements = 10
+
+	hosts := newCapacityHosts(map[string]int64{
+		"host-1": 1000,
+		"host-2": 1000,
+		"host-3": 1000,
+	})
+	result, err := SimulateCapacity(hosts, &types.CapacityCheck{
+		Count:    100,
+		Size:     1,
+		Replicas: 3,
+	})
+	assert.Nil(err)
+	assert.Equal(3, result.Fits)
+	assert.Equal(types.CapacityLimitIterations, result.Limit)
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/rancher/longhorn-manager/api"
 	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
 )
 
 type schedulerClient struct {
@@ -20,7 +21,7 @@ type schedulerClient struct {
 }
 
 func newSchedulerClient(host *types.HostInfo) *schedulerClient {
-	address := "http://" + host.Address + "/v1"
+	address := util.ManagerURL(host.Address, "/v1")
 	return &schedulerClient{
 		hostID:  host.UUID,
 		address: address,
@@ -65,7 +66,8 @@ func (c *schedulerClient) do(method, path string, req, resp interface{}) error {
 	}
 	httpReq.Header.Set("Content-Type", bodyType)
 
-	httpResp, err := http.DefaultClient.Do(httpReq)
+	client := &http.Client{Transport: util.ManagerTransport}
+	httpResp, err := client.Do(httpReq)
 	if err != nil {
 		return err
 	}
@@ -1,34 +1,443 @@
 package scheduler
 
 import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
 
 	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+	"github.com/rancher/longhorn-manager/util/trace"
+)
+
+var (
+	// MaxConcurrentSchedules limits the items being scheduled at the same
+	// time, the others are queued. 0 for unlimited.
+	MaxConcurrentSchedules = 0
+
+	// SchedulerLatencyWindow is the number of recent items of each action
+	// the latency is reported on
+	SchedulerLatencyWindow = 20
+
+	// ProcessTimeouts are the deadlines of processing an item by action,
+	// DefaultProcessTimeout for the others. The item is abandoned after it.
+	ProcessTimeouts       = map[types.ScheduleAction]time.Duration{}
+	DefaultProcessTimeout = 3 * time.Minute
+
+	// QuarantinePeriod is how long the instance of an abandoned item is
+	// refused new items, and its host new instances if it was a create
+	QuarantinePeriod = 10 * time.Minute
+
+	// HostRecoveryCooldown is how long a host back from an outage takes no
+	// new replicas, in case it's flaky
+	HostRecoveryCooldown = 5 * time.Minute
+
+	// MaxAbandonedSchedules is the number of recent abandoned items kept
+	// for the consistency audit
+	MaxAbandonedSchedules = 100
 )
 
 type OrcScheduler struct {
+	sync.Mutex
+
 	ops types.ScheduleOps
+
+	slots     chan struct{}
+	items     map[*types.ScheduleItem]*types.ScheduleItemStatus
+	latencies map[types.ScheduleAction][]time.Duration
+
+	timeouts   map[types.ScheduleAction]int
+	abandoned  []*types.AbandonedSchedule
+	leaked     int
+	quarantine map[string]time.Time
 }
 
 func NewOrcScheduler(ops types.ScheduleOps) *OrcScheduler {
-	return &OrcScheduler{
-		ops: ops,
+	s := &OrcScheduler{
+		ops:       ops,
+		items:     map[*types.ScheduleItem]*types.ScheduleItemStatus{},
+		latencies: map[types.ScheduleAction][]time.Duration{},
+
+		timeouts:   map[types.ScheduleAction]int{},
+		abandoned:  []*types.AbandonedSchedule{},
+		quarantine: map[string]time.Time{},
+	}
+	if MaxConcurrentSchedules > 0 {
+		s.slots = make(chan struct{}, MaxConcurrentSchedules)
+	}
+	return s
+}
+
+// track queues the item until a slot is available, the returned func
+// releases the slot and records the latency of the item. The waiting and the
+// processing are steps of the operation on the volume if it's traced.
+func (s *OrcScheduler) track(item *types.ScheduleItem) func() {
+	span := trace.Current(item.Instance.VolumeName).Childf("%v %v %v", item.Action, item.Instance.Type, item.Instance.ID)
+	queued := span.Child("queued")
+	s.Lock()
+	s.items[item] = &types.ScheduleItemStatus{
+		Action:     item.Action,
+		InstanceID: item.Instance.ID,
+		VolumeName: item.Instance.VolumeName,
+		Since:      util.Now(),
+	}
+	s.Unlock()
+
+	if s.slots != nil {
+		s.slots <- struct{}{}
+	}
+	queued.End(nil)
+	process := span.Child("process")
+	s.Lock()
+	s.items[item].InFlight = true
+	s.items[item].Since = util.Now()
+	s.Unlock()
+
+	return func() {
+		latency := process.End(nil)
+		span.End(nil)
+		if s.slots != nil {
+			<-s.slots
+		}
+		s.Lock()
+		defer s.Unlock()
+		delete(s.items, item)
+		latencies := append(s.latencies[item.Action], latency)
+		if len(latencies) > SchedulerLatencyWindow {
+			latencies = latencies[len(latencies)-SchedulerLatencyWindow:]
+		}
+		s.latencies[item.Action] = latencies
 	}
 }
 
-func randomHostID(m map[string]*types.HostInfo) string {
-	for k := range m {
-		return k
+func (s *OrcScheduler) Status() *types.SchedulerStatus {
+	s.Lock()
+	defer s.Unlock()
+
+	status := &types.SchedulerStatus{
+		Items:   []*types.ScheduleItemStatus{},
+		Latency: map[types.ScheduleAction]*types.ScheduleLatency{},
+
+		Timeouts:    map[types.ScheduleAction]int{},
+		Abandoned:   []*types.AbandonedSchedule{},
+		Leaked:      s.leaked,
+		Quarantined: []*types.ScheduleQuarantine{},
+	}
+	for action, count := range s.timeouts {
+		status.Timeouts[action] = count
+	}
+	for _, item := range s.abandoned {
+		a := *item
+		status.Abandoned = append(status.Abandoned, &a)
+	}
+	now := time.Now()
+	for id, until := range s.quarantine {
+		if until.After(now) {
+			status.Quarantined = append(status.Quarantined, &types.ScheduleQuarantine{
+				ID:    id,
+				Until: util.FormatTimeZ(until),
+			})
+		}
+	}
+	sort.Slice(status.Quarantined, func(i, j int) bool { return status.Quarantined[i].ID < status.Quarantined[j].ID })
+	for _, item := range s.items {
+		i := *item
+		status.Items = append(status.Items, &i)
+		if item.InFlight {
+			status.InFlight++
+		} else {
+			status.Queued++
+		}
+	}
+	sort.Slice(status.Items, func(i, j int) bool { return status.Items[i].Since < status.Items[j].Since })
+	for action, latencies := range s.latencies {
+		l := &types.ScheduleLatency{
+			Count: len(latencies),
+			Last:  latencies[len(latencies)-1],
+		}
+		var total time.Duration
+		for _, latency := range latencies {
+			total += latency
+			if latency > l.Max {
+				l.Max = latency
+			}
+		}
+		l.Average = total / time.Duration(len(latencies))
+		status.Latency[action] = l
+	}
+	return status
+}
+
+// hostWeight is the scheduling weight of the host, 1 if it has none
+func hostWeight(host *types.HostInfo) int {
+	if host.SchedulingWeight <= 0 {
+		return 1
+	}
+	return host.SchedulingWeight
+}
+
+// weightedShuffle orders the hosts at random, each one going first with a
+// probability proportional to its weight
+func weightedShuffle(hosts map[string]*types.HostInfo, list []string) {
+	keys := make(map[string]float64, len(list))
+	for _, id := range list {
+		keys[id] = rand.ExpFloat64() / float64(hostWeight(hosts[id]))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return keys[list[i]] < keys[list[j]]
+	})
+}
+
+// failureDomain returns the failure domain of the host, a host without one
+// is a domain on its own
+func failureDomain(host *types.HostInfo) string {
+	if host.FailureDomain == "" {
+		return "host:" + host.UUID
+	}
+	return host.FailureDomain
+}
+
+// hostFilter returns the constraint the host fails, "" if it passes
+type hostFilter func(id string) types.ScheduleConstraint
+
+// hostFilters is the filter chain of the policy, the schedulable hosts bound
+// by a host binding, or the schedulable hosts allowed by the zone
+// distribution for soft anti-affinity. Only replicas are placed by the
+// filters, the controllers go on the host attaching the volume.
+func hostFilters(hosts map[string]*types.HostInfo, policy *types.SchedulePolicy) []hostFilter {
+	filters := []hostFilter{
+		func(id string) types.ScheduleConstraint {
+			if hosts[id].Unschedulable {
+				return types.ScheduleConstraintCordoned
+			}
+			return ""
+		},
+		func(id string) types.ScheduleConstraint {
+			if hosts[id].Conflicted {
+				return types.ScheduleConstraintConflicted
+			}
+			return ""
+		},
+		func(id string) types.ScheduleConstraint {
+			if !hosts[id].Role.RunsReplicas() {
+				return types.ScheduleConstraintComputeOnly
+			}
+			return ""
+		},
+		func(id string) types.ScheduleConstraint {
+			if RecoveryCooldown(hosts[id], time.Now()) > 0 {
+				return types.ScheduleConstraintRecovering
+			}
+			return ""
+		},
+	}
+	if policy == nil {
+		return filters
+	}
+	if policy.Binding == types.SchedulePolicyBindingHost {
+		return append(filters, func(id string) types.ScheduleConstraint {
+			if _, ok := policy.HostIDMap[id]; !ok {
+				return types.ScheduleConstraintNotBound
+			}
+			return ""
+		})
+	}
+	allowed := zoneFilter(hosts, policy)
+	return append(filters, func(id string) types.ScheduleConstraint {
+		if !allowed(id) {
+			return types.ScheduleConstraintZoneDistribution
+		}
+		return ""
+	})
+}
+
+// RecoveryCooldown returns what's left at now, by the clock of the host, of
+// the cooldown of the host back from an outage, 0 once it's over
+func RecoveryCooldown(host *types.HostInfo, now time.Time) time.Duration {
+	if host.Recovered == "" {
+		return 0
+	}
+	recovered, err := util.ParseTimeZ(host.Recovered)
+	if err != nil {
+		return 0
+	}
+	if left := recovered.Add(HostRecoveryCooldown).Sub(now); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// filterHosts runs each host through the filters, and returns the hosts
+// passing them all, and the constraints failed by the others
+func filterHosts(hosts map[string]*types.HostInfo, filters []hostFilter) ([]string, map[string][]types.ScheduleConstraint) {
+	passed := []string{}
+	failed := map[string][]types.ScheduleConstraint{}
+	for id := range hosts {
+		for _, filter := range filters {
+			if constraint := filter(id); constraint != "" {
+				failed[id] = append(failed[id], constraint)
+			}
+		}
+		if len(failed[id]) == 0 {
+			passed = append(passed, id)
+		}
+	}
+	sort.Strings(passed)
+	return passed, failed
+}
+
+// hostPriorityList orders the hosts passing the filters of the policy. With
+// soft anti-affinity, hosts in a failure domain without any of the bound
+// hosts come first, then the other hosts not bound, then the bound hosts.
+// The preferred host of the policy goes before them all, unless it's bound.
+// The hosts of the same priority are shuffled by their scheduling weight.
+// With host binding, only the bound hosts are listed.
+func hostPriorityList(hosts map[string]*types.HostInfo, policy *types.SchedulePolicy) ([]string, error) {
+	if policy != nil && policy.Binding == types.SchedulePolicyBindingHost {
+		list, _ := filterHosts(hosts, hostFilters(hosts, policy))
+		return list, nil
+	}
+	priority, err := hostPriorities(hosts, policy)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		if err := CheckZoneDistribution(hosts, policy.Replicas, policy.MaxReplicasPerZone, policy.MinZones); err != nil {
+			return nil, err
+		}
+	}
+
+	passed, failed := filterHosts(hosts, hostFilters(hosts, policy))
+	if len(passed) == 0 {
+		for _, constraints := range failed {
+			if len(constraints) == 1 && constraints[0] == types.ScheduleConstraintZoneDistribution {
+				return nil, types.NewErrUnschedulable(failed)
+			}
+		}
+	}
+	lists := make([][]string, priorityLow+1)
+	for _, id := range passed {
+		p := priority(id)
+		lists[p] = append(lists[p], id)
+	}
+	for _, l := range lists {
+		weightedShuffle(hosts, l)
+	}
+	list := append(append(lists[priorityHigh], lists[priorityNormal]...), lists[priorityLow]...)
+	if policy != nil && policy.PreferredHostID != "" {
+		for i, id := range list[:len(lists[priorityHigh])+len(lists[priorityNormal])] {
+			if id == policy.PreferredHostID {
+				list = append(append([]string{id}, list[:i]...), list[i+1:]...)
+				break
+			}
+		}
 	}
-	return ""
+	return list, nil
+}
+
+// CheckZoneDistribution returns an error if the schedulable hosts are not in
+// enough failure domains to place the replicas with at most maxPerZone per
+// domain and in at least minZones domains. 0 means no constraint.
+func CheckZoneDistribution(hosts map[string]*types.HostInfo, replicas, maxPerZone, minZones int) error {
+	if maxPerZone == 0 && minZones == 0 {
+		return nil
+	}
+	zones := map[string]bool{}
+	for _, host := range hosts {
+		if !host.Unschedulable && !host.Conflicted && host.Role.RunsReplicas() {
+			zones[failureDomain(host)] = true
+		}
+	}
+	if minZones > len(zones) {
+		return errors.Errorf("unable to place replicas in %v zones, only %v zones have schedulable hosts",
+			minZones, len(zones))
+	}
+	if maxPerZone > 0 && maxPerZone*len(zones) < replicas {
+		return errors.Errorf("unable to place %v replicas with at most %v per zone, only %v zones have schedulable hosts",
+			replicas, maxPerZone, len(zones))
+	}
+	return nil
+}
+
+// zoneFilter returns if a replica can go on the host without breaking the
+// zone distribution of the policy, given the replicas on the bound hosts.
+// With MinZones, a replica only goes in a zone already used if the replicas
+// left can still make up the missing zones.
+func zoneFilter(hosts map[string]*types.HostInfo, policy *types.SchedulePolicy) func(id string) bool {
+	counts := map[string]int{}
+	bound := 0
+	for id := range policy.HostIDMap {
+		if host, ok := hosts[id]; ok {
+			counts[failureDomain(host)]++
+			bound++
+		}
+	}
+	left := policy.Replicas - bound - 1
+	if left < 0 {
+		left = 0
+	}
+	return func(id string) bool {
+		zone := failureDomain(hosts[id])
+		if policy.MaxReplicasPerZone > 0 && counts[zone] >= policy.MaxReplicasPerZone {
+			return false
+		}
+		zones := len(counts)
+		if counts[zone] == 0 {
+			zones++
+		}
+		return policy.MinZones == 0 || zones+left >= policy.MinZones
+	}
+}
+
+const (
+	priorityHigh = iota
+	priorityNormal
+	priorityLow
+)
+
+// hostPriorities returns the priority of a host for the soft anti-affinity
+// policy, see hostPriorityList
+func hostPriorities(hosts map[string]*types.HostInfo, policy *types.SchedulePolicy) (func(id string) int, error) {
+	usedDomains := map[string]bool{}
+	if policy != nil {
+		if policy.Binding != types.SchedulePolicyBindingSoftAntiAffinity {
+			return nil, errors.Errorf("Unsupported schedule policy binding %v", policy.Binding)
+		}
+		for id := range policy.HostIDMap {
+			if host, ok := hosts[id]; ok {
+				usedDomains[failureDomain(host)] = true
+			}
+		}
+	}
+	return func(id string) int {
+		if policy == nil {
+			return priorityNormal
+		}
+		if _, ok := policy.HostIDMap[id]; ok {
+			return priorityLow
+		}
+		if usedDomains[failureDomain(hosts[id])] {
+			return priorityNormal
+		}
+		return priorityHigh
+	}, nil
 }
 
 func (s *OrcScheduler) Schedule(item *types.ScheduleItem, policy *types.SchedulePolicy) (*types.InstanceInfo, error) {
 	if item.Instance.ID == "" || item.Instance.Type == types.InstanceTypeNone {
 		return nil, errors.Errorf("instance ID and type required for scheduling")
 	}
+	defer s.track(item)()
+
 	if item.Instance.HostID != "" {
+		if err := s.checkRole(item); err != nil {
+			return nil, err
+		}
 		return s.ScheduleProcess(&types.ScheduleSpec{
 			HostID: item.Instance.HostID,
 		}, item)
@@ -39,27 +448,14 @@ func (s *OrcScheduler) Schedule(item *types.ScheduleItem, policy *types.Schedule
 		return nil, errors.Wrap(err, "fail to schedule")
 	}
 
-	normalPriorityList := []string{}
-	lowPriorityList := []string{}
-
-	for id := range hosts {
-		if policy != nil {
-			if policy.Binding == types.SchedulePolicyBindingSoftAntiAffinity {
-				if _, ok := policy.HostIDMap[id]; ok {
-					lowPriorityList = append(lowPriorityList, id)
-				} else {
-					normalPriorityList = append(normalPriorityList, id)
-				}
-			} else {
-				return nil, errors.Errorf("Unsupported schedule policy binding %v", policy.Binding)
-			}
-		} else {
-			normalPriorityList = append(normalPriorityList, id)
+	priorityList, err := hostPriorityList(hosts, policy)
+	if err != nil {
+		if e, ok := err.(*types.ErrUnschedulable); ok {
+			e.Action, e.InstanceID = item.Action, item.Instance.ID
 		}
+		return nil, err
 	}
 
-	priorityList := append(normalPriorityList, lowPriorityList...)
-
 	for _, id := range priorityList {
 		ret, err := s.ScheduleProcess(&types.ScheduleSpec{HostID: id}, item)
 		if err == nil {
@@ -69,7 +465,13 @@ func (s *OrcScheduler) Schedule(item *types.ScheduleItem, policy *types.Schedule
 		logrus.Warnf("Fail to schedule %+v on host %v, trying on another one: %v",
 			hosts[id], item.Instance, err)
 	}
-	return nil, errors.Errorf("unable to find suitable host for scheduling")
+	_, failed := filterHosts(hosts, hostFilters(hosts, policy))
+	for _, id := range priorityList {
+		failed[id] = append(failed[id], types.ScheduleConstraintProcessFailed)
+	}
+	e := types.NewErrUnschedulable(failed)
+	e.Action, e.InstanceID = item.Action, item.Instance.ID
+	return nil, e
 }
 
 func (s *OrcScheduler) ScheduleProcess(spec *types.ScheduleSpec, item *types.ScheduleItem) (*types.InstanceInfo, error) {
@@ -94,7 +496,10 @@ func (s *OrcScheduler) Process(spec *types.ScheduleSpec, item *types.ScheduleIte
 	if s.ops.GetCurrentHostID() != spec.HostID {
 		return nil, errors.Errorf("wrong host routing, should be at %v", spec.HostID)
 	}
-	instance, err := s.ops.ProcessSchedule(item)
+	if err := s.checkQuarantine(spec.HostID, item); err != nil {
+		return nil, err
+	}
+	instance, err := s.processWithDeadline(spec.HostID, item)
 	if err != nil {
 		return nil, errors.Wrapf(err, "fail to process schedule request")
 	}
@@ -103,3 +508,189 @@ func (s *OrcScheduler) Process(spec *types.ScheduleSpec, item *types.ScheduleIte
 	}
 	return instance, nil
 }
+
+func isCreate(action types.ScheduleAction) bool {
+	return action == types.ScheduleActionCreateController || action == types.ScheduleActionCreateReplica
+}
+
+// checkRole refuses to create the instance on its host if the role of the
+// host doesn't run the instance type
+func (s *OrcScheduler) checkRole(item *types.ScheduleItem) error {
+	if !isCreate(item.Action) {
+		return nil
+	}
+	host, err := s.ops.GetHost(item.Instance.HostID)
+	if err != nil {
+		return errors.Wrapf(err, "cannot find host %v", item.Instance.HostID)
+	}
+	if host == nil || host.Role.Runs(item.Instance.Type) {
+		return nil
+	}
+	constraint := types.ScheduleConstraintComputeOnly
+	if host.Role == types.HostRoleStorage {
+		constraint = types.ScheduleConstraintStorageOnly
+	}
+	e := types.NewErrUnschedulable(map[string][]types.ScheduleConstraint{
+		item.Instance.HostID: {constraint},
+	})
+	e.Action, e.InstanceID = item.Action, item.Instance.ID
+	return e
+}
+
+// checkQuarantine refuses the item if its instance, or its host for a
+// create, had an item abandoned recently
+func (s *OrcScheduler) checkQuarantine(hostID string, item *types.ScheduleItem) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	for id, until := range s.quarantine {
+		if !until.After(now) {
+			delete(s.quarantine, id)
+		}
+	}
+	if until, ok := s.quarantine[item.Instance.ID]; ok {
+		return errors.Errorf("instance %v is quarantined until %v after an abandoned schedule",
+			item.Instance.ID, util.FormatTimeZ(until))
+	}
+	if until, ok := s.quarantine[hostID]; ok && isCreate(item.Action) {
+		return errors.Errorf("host %v is quarantined for new instances until %v after an abandoned schedule",
+			hostID, util.FormatTimeZ(until))
+	}
+	return nil
+}
+
+type processResult struct {
+	instance *types.InstanceInfo
+	err      error
+}
+
+// processWithDeadline abandons the item if it isn't processed before the
+// deadline of its action. The call keeps running in the background, it's
+// counted as leaked until it returns.
+func (s *OrcScheduler) processWithDeadline(hostID string, item *types.ScheduleItem) (*types.InstanceInfo, error) {
+	timeout, ok := ProcessTimeouts[item.Action]
+	if !ok {
+		timeout = DefaultProcessTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan processResult, 1)
+	go func() {
+		instance, err := s.ops.ProcessSchedule(ctx, item)
+		done <- processResult{instance, err}
+	}()
+	select {
+	case result := <-done:
+		return result.instance, result.err
+	case <-ctx.Done():
+	}
+
+	abandoned := &types.AbandonedSchedule{
+		Action:       item.Action,
+		InstanceID:   item.Instance.ID,
+		InstanceName: item.Instance.Name,
+		InstanceType: item.Instance.Type,
+		VolumeName:   item.Instance.VolumeName,
+		HostID:       hostID,
+		Abandoned:    util.Now(),
+	}
+	logrus.Errorf("Abandoned schedule %v of %v for volume %v after timeout %v, the outcome is unknown",
+		item.Action, item.Instance.ID, item.Instance.VolumeName, timeout)
+
+	s.Lock()
+	s.timeouts[item.Action]++
+	s.leaked++
+	s.abandoned = append(s.abandoned, abandoned)
+	if len(s.abandoned) > MaxAbandonedSchedules {
+		s.abandoned = s.abandoned[len(s.abandoned)-MaxAbandonedSchedules:]
+	}
+	until := time.Now().Add(QuarantinePeriod)
+	s.quarantine[item.Instance.ID] = until
+	if isCreate(item.Action) {
+		s.quarantine[hostID] = until
+	}
+	s.Unlock()
+
+	go func() {
+		result := <-done
+		logrus.Warnf("Abandoned schedule %v of %v returned, error %v", item.Action, item.Instance.ID, result.err)
+		s.Lock()
+		defer s.Unlock()
+		s.leaked--
+		abandoned.Returned = true
+	}()
+	return nil, &types.ErrScheduleTimeout{
+		Action:     item.Action,
+		InstanceID: item.Instance.ID,
+		Timeout:    timeout,
+	}
+}
+
+// PlanReplicas picks the hosts for count new replicas of the given size one
+// at a time, by the priority of the policy then the most space available,
+// without creating anything. The hosts missing from available have an
+// unknown capacity and are only picked after the others.
+func PlanReplicas(hosts map[string]*types.HostInfo, policy *types.SchedulePolicy, count int, size int64, available map[string]int64) ([]string, error) {
+	p := *policy
+	p.HostIDMap = map[string]struct{}{}
+	for id := range policy.HostIDMap {
+		p.HostIDMap[id] = struct{}{}
+	}
+	left := map[string]int64{}
+	for id, bytes := range available {
+		left[id] = bytes
+	}
+
+	planned := []string{}
+	for len(planned) < count {
+		list, err := hostPriorityList(hosts, &p)
+		if err != nil {
+			return nil, err
+		}
+		priority := func(id string) int { return priorityNormal }
+		if p.Binding == types.SchedulePolicyBindingSoftAntiAffinity {
+			if priority, err = hostPriorities(hosts, &p); err != nil {
+				return nil, err
+			}
+		}
+		sort.Slice(list, func(i, j int) bool {
+			pi, pj := priority(list[i]), priority(list[j])
+			if pi != pj {
+				return pi < pj
+			}
+			li, iKnown := left[list[i]]
+			lj, jKnown := left[list[j]]
+			if iKnown != jKnown {
+				return iKnown
+			}
+			if li != lj {
+				return li > lj
+			}
+			return list[i] < list[j]
+		})
+		picked := ""
+		for _, id := range list {
+			if bytes, ok := left[id]; ok && bytes < size {
+				continue
+			}
+			picked = id
+			break
+		}
+		if picked == "" {
+			_, failed := filterHosts(hosts, hostFilters(hosts, &p))
+			for _, id := range list {
+				failed[id] = append(failed[id], types.ScheduleConstraintInsufficientSpace)
+			}
+			return nil, errors.Wrapf(types.NewErrUnschedulable(failed), "unable to find a host with %v bytes available for replica %v of %v",
+				size, len(planned)+1, count)
+		}
+		planned = append(planned, picked)
+		p.HostIDMap[picked] = struct{}{}
+		if _, ok := left[picked]; ok {
+			left[picked] -= size
+		}
+	}
+	return planned, nil
+}
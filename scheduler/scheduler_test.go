@@ -0,0 +1,588 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rancher/longhorn-manager/types"
+	"github.com/rancher/longhorn-manager/util"
+)
+
+func newHosts(domains map[string]string) map[string]*types.HostInfo {
+	hosts := map[string]*types.HostInfo{}
+	for id, domain := range domains {
+		hosts[id] = &types.HostInfo{UUID: id, FailureDomain: domain}
+	}
+	return hosts
+}
+
+// placeReplicas schedules the replicas one by one on the first host of the
+// priority list, the way prepareCreateReplicaPolicy binds the existing ones
+func placeReplicas(assert *require.Assertions, hosts map[string]*types.HostInfo, count int) []string {
+	placed := []string{}
+	for i := 0; i < count; i++ {
+		policy := &types.SchedulePolicy{
+			Binding:   types.SchedulePolicyBindingSoftAntiAffinity,
+			HostIDMap: map[string]struct{}{},
+		}
+		for _, id := range placed {
+			policy.HostIDMap[id] = struct{}{}
+		}
+		list, err := hostPriorityList(hosts, policy)
+		assert.Nil(err)
+		assert.NotEmpty(list)
+		placed = append(placed, list[0])
+	}
+	return placed
+}
+
+func TestFailureDomainSpread(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newHosts(map[string]string{
+		"host-1": "zone-a",
+		"host-2": "zone-a",
+		"host-3": "zone-a",
+		"host-4": "zone-b",
+	})
+	for i := 0; i < 20; i++ {
+		placed := placeReplicas(assert, hosts, 3)
+		zones := map[string]int{}
+		hostIDs := map[string]bool{}
+		for _, id := range placed {
+			zones[hosts[id].FailureDomain]++
+			hostIDs[id] = true
+		}
+		assert.Len(zones, 2)
+		assert.Len(hostIDs, 3)
+	}
+}
+
+func TestFailureDomainFallback(t *testing.T) {
+	assert := require.New(t)
+
+	// hosts without a domain are spread as before
+	hosts := newHosts(map[string]string{"host-1": "", "host-2": "", "host-3": ""})
+	placed := placeReplicas(assert, hosts, 3)
+	sort.Strings(placed)
+	assert.Equal([]string{"host-1", "host-2", "host-3"}, placed)
+
+	// a single domain falls back to spreading across hosts
+	hosts = newHosts(map[string]string{"host-1": "zone-a", "host-2": "zone-a"})
+	placed = placeReplicas(assert, hosts, 2)
+	sort.Strings(placed)
+	assert.Equal([]string{"host-1", "host-2"}, placed)
+
+	hosts["host-2"].Unschedulable = true
+	list, err := hostPriorityList(hosts, nil)
+	assert.Nil(err)
+	assert.Equal([]string{"host-1"}, list)
+
+	hosts["host-1"].Conflicted = true
+	list, err = hostPriorityList(hosts, nil)
+	assert.Nil(err)
+	assert.Len(list, 0)
+	hosts["host-1"].Conflicted = false
+
+	_, err = hostPriorityList(hosts, &types.SchedulePolicy{Binding: "unknown"})
+	assert.NotNil(err)
+}
+
+func TestSchedulingWeight(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newHosts(map[string]string{"host-1": "", "host-2": "", "host-3": "", "host-4": ""})
+	hosts["host-2"].SchedulingWeight = 2
+	hosts["host-3"].SchedulingWeight = 3
+	// a cordoned host is never picked, whatever its weight
+	hosts["host-4"].SchedulingWeight = 100
+	hosts["host-4"].Unschedulable = true
+
+	// each placement is a new volume, so all hosts have the same priority
+	const placements = 6000
+	counts := map[string]int{}
+	for i := 0; i < placements; i++ {
+		placed := placeReplicas(assert, hosts, 1)
+		counts[placed[0]]++
+	}
+	assert.Equal(0, counts["host-4"])
+	for id, weight := range map[string]int{"host-1": 1, "host-2": 2, "host-3": 3} {
+		expected := placements * weight / 6
+		assert.InDelta(expected, counts[id], float64(expected)/10, "host %v placed %v", id, counts[id])
+	}
+
+	// the weight doesn't override the anti-affinity of the replicas
+	for i := 0; i < 20; i++ {
+		placed := placeReplicas(assert, hosts, 3)
+		sort.Strings(placed)
+		assert.Equal([]string{"host-1", "host-2", "host-3"}, placed)
+	}
+}
+
+func TestHostBinding(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newHosts(map[string]string{"host-1": "", "host-2": "", "host-3": ""})
+	policy := &types.SchedulePolicy{
+		Binding:   types.SchedulePolicyBindingHost,
+		HostIDMap: map[string]struct{}{"host-2": {}},
+	}
+	list, err := hostPriorityList(hosts, policy)
+	assert.Nil(err)
+	assert.Equal([]string{"host-2"}, list)
+
+	hosts["host-2"].Unschedulable = true
+	list, err = hostPriorityList(hosts, policy)
+	assert.Nil(err)
+	assert.Len(list, 0)
+}
+
+func TestHostRecoveryCooldown(t *testing.T) {
+	assert := require.New(t)
+
+	now := time.Now()
+	hosts := newHosts(map[string]string{"host-1": "", "host-2": "", "host-3": ""})
+	hosts["host-2"].Recovered = util.FormatTimeZ(now.Add(-time.Minute))
+	left := RecoveryCooldown(hosts["host-2"], now)
+	assert.True(left > HostRecoveryCooldown-2*time.Minute && left <= HostRecoveryCooldown-time.Minute)
+	assert.Equal(time.Duration(0), RecoveryCooldown(hosts["host-1"], now))
+
+	for i := 0; i < 10; i++ {
+		assert.NotContains(placeReplicas(assert, hosts, 2), "host-2")
+	}
+	_, failed := filterHosts(hosts, hostFilters(hosts, nil))
+	assert.Equal(map[string][]types.ScheduleConstraint{"host-2": {types.ScheduleConstraintRecovering}}, failed)
+
+	hosts["host-2"].Recovered = util.FormatTimeZ(now.Add(-HostRecoveryCooldown - time.Second))
+	assert.Equal(time.Duration(0), RecoveryCooldown(hosts["host-2"], now))
+	assert.Len(placeReplicas(assert, hosts, 3), 3)
+}
+
+func TestPreferredHost(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newHosts(map[string]string{"host-1": "zone-a", "host-2": "zone-b", "host-3": "zone-c"})
+	policy := zonePolicy(3, 0, 0, "host-2")
+	policy.PreferredHostID = "host-1"
+	list, err := hostPriorityList(hosts, policy)
+	assert.Nil(err)
+	assert.Equal("host-1", list[0])
+
+	// not when bound, unschedulable or against the zone distribution
+	policy.PreferredHostID = "host-2"
+	list, err = hostPriorityList(hosts, policy)
+	assert.Nil(err)
+	assert.Equal("host-2", list[len(list)-1])
+
+	policy.PreferredHostID = "host-1"
+	hosts["host-1"].Unschedulable = true
+	list, err = hostPriorityList(hosts, policy)
+	assert.Nil(err)
+	assert.Equal([]string{"host-3", "host-2"}, list)
+	hosts["host-1"].Unschedulable = false
+
+	hosts["host-1"].FailureDomain = "zone-b"
+	policy.Replicas, policy.MaxReplicasPerZone = 2, 1
+	list, err = hostPriorityList(hosts, policy)
+	assert.Nil(err)
+	assert.Equal([]string{"host-3"}, list)
+}
+
+func zonePolicy(replicas, maxPerZone, minZones int, bound ...string) *types.SchedulePolicy {
+	policy := &types.SchedulePolicy{
+		Binding:            types.SchedulePolicyBindingSoftAntiAffinity,
+		HostIDMap:          map[string]struct{}{},
+		Replicas:           replicas,
+		MaxReplicasPerZone: maxPerZone,
+		MinZones:           minZones,
+	}
+	for _, id := range bound {
+		policy.HostIDMap[id] = struct{}{}
+	}
+	return policy
+}
+
+func TestZoneMaxReplicasPerZone(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newHosts(map[string]string{
+		"host-1": "zone-a",
+		"host-2": "zone-a",
+		"host-3": "zone-a",
+		"host-4": "zone-b",
+		"host-5": "zone-b",
+	})
+	list, err := hostPriorityList(hosts, zonePolicy(4, 2, 0, "host-1", "host-2"))
+	assert.Nil(err)
+	sort.Strings(list)
+	assert.Equal([]string{"host-4", "host-5"}, list)
+
+	// the bound hosts still come last
+	list, err = hostPriorityList(hosts, zonePolicy(4, 2, 0, "host-1", "host-4"))
+	assert.Nil(err)
+	assert.Len(list, 5)
+	last := list[3:]
+	sort.Strings(last)
+	assert.Equal([]string{"host-1", "host-4"}, last)
+
+	// a replacement replica has nowhere to go once both zones are full
+	_, err = hostPriorityList(hosts, zonePolicy(4, 2, 0, "host-1", "host-2", "host-4", "host-5"))
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "zone distribution")
+
+	_, err = hostPriorityList(hosts, zonePolicy(5, 2, 0))
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "at most 2 per zone")
+
+	hosts["host-4"].Unschedulable = true
+	hosts["host-5"].Unschedulable = true
+	_, err = hostPriorityList(hosts, zonePolicy(2, 1, 0))
+	assert.NotNil(err)
+}
+
+func TestZoneMinZones(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newHosts(map[string]string{
+		"host-1": "zone-a",
+		"host-2": "zone-a",
+		"host-3": "zone-a",
+		"host-4": "zone-b",
+		"host-5": "zone-c",
+	})
+	list, err := hostPriorityList(hosts, zonePolicy(3, 0, 3))
+	assert.Nil(err)
+	assert.Len(list, 5)
+
+	// the last two replicas have to go to the other two zones
+	list, err = hostPriorityList(hosts, zonePolicy(3, 0, 3, "host-1"))
+	assert.Nil(err)
+	sort.Strings(list)
+	assert.Equal([]string{"host-4", "host-5"}, list)
+
+	list, err = hostPriorityList(hosts, zonePolicy(3, 0, 3, "host-1", "host-4"))
+	assert.Nil(err)
+	assert.Equal([]string{"host-5"}, list)
+
+	// with a replica to spare, zone-a can take a second one
+	list, err = hostPriorityList(hosts, zonePolicy(4, 0, 3, "host-1"))
+	assert.Nil(err)
+	assert.Len(list, 5)
+
+	_, err = hostPriorityList(hosts, zonePolicy(4, 0, 4))
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "only 3 zones")
+
+	hosts["host-5"].Unschedulable = true
+	_, err = hostPriorityList(hosts, zonePolicy(3, 0, 3))
+	assert.NotNil(err)
+}
+
+func TestUnschedulableConstraints(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newHosts(map[string]string{
+		"host-1": "zone-a",
+		"host-2": "zone-a",
+		"host-3": "zone-b",
+		"host-4": "zone-b",
+	})
+	hosts["host-3"].Unschedulable = true
+	hosts["host-4"].Conflicted = true
+
+	// each host with the constraints it fails, in order of the filters
+	_, err := hostPriorityList(hosts, zonePolicy(2, 2, 0, "host-1", "host-2"))
+	assert.NotNil(err)
+	e, ok := err.(*types.ErrUnschedulable)
+	assert.True(ok)
+	assert.Equal([]*types.HostConstraints{
+		{HostID: "host-1", Constraints: []types.ScheduleConstraint{types.ScheduleConstraintZoneDistribution}},
+		{HostID: "host-2", Constraints: []types.ScheduleConstraint{types.ScheduleConstraintZoneDistribution}},
+		{HostID: "host-3", Constraints: []types.ScheduleConstraint{types.ScheduleConstraintCordoned}},
+		{HostID: "host-4", Constraints: []types.ScheduleConstraint{types.ScheduleConstraintConflicted}},
+	}, e.Hosts)
+	assert.Equal([]types.ScheduleConstraint{
+		types.ScheduleConstraintConflicted,
+		types.ScheduleConstraintCordoned,
+		types.ScheduleConstraintZoneDistribution,
+	}, e.Reasons())
+	assert.Equal("host host-1 breaking the zone distribution; host host-2 breaking the zone distribution; host host-3 cordoned; host host-4 conflicted", e.Message())
+
+	// the hosts passing the filters are out of space
+	policy := &types.SchedulePolicy{
+		Binding:   types.SchedulePolicyBindingSoftAntiAffinity,
+		HostIDMap: map[string]struct{}{},
+	}
+	_, err = PlanReplicas(hosts, policy, 1, 100, map[string]int64{"host-1": 50, "host-2": 80})
+	assert.NotNil(err)
+	e, ok = errors.Cause(err).(*types.ErrUnschedulable)
+	assert.True(ok)
+	assert.Len(e.Hosts, 4)
+	assert.Equal([]types.ScheduleConstraint{types.ScheduleConstraintInsufficientSpace}, e.Hosts[0].Constraints)
+	assert.Equal([]types.ScheduleConstraint{types.ScheduleConstraintCordoned}, e.Hosts[2].Constraints)
+
+	assert.Equal([]types.ScheduleConstraint{types.ScheduleConstraintNoHosts}, types.NewErrUnschedulable(nil).Reasons())
+}
+
+func TestHostRoles(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newHosts(map[string]string{
+		"host-1": "zone-a",
+		"host-2": "zone-b",
+		"host-3": "zone-c",
+		"host-4": "zone-d",
+	})
+	hosts["host-2"].Role = types.HostRoleStorage
+	hosts["host-3"].Role = types.HostRoleCompute
+	hosts["host-4"].Role = types.HostRoleCompute
+
+	// replicas skip the compute hosts
+	for i := 0; i < 20; i++ {
+		placed := placeReplicas(assert, hosts, 2)
+		sort.Strings(placed)
+		assert.Equal([]string{"host-1", "host-2"}, placed)
+	}
+	_, err := hostPriorityList(hosts, zonePolicy(3, 1, 0))
+	assert.NotNil(err)
+
+	_, err = PlanReplicas(hosts, zonePolicy(1, 0, 0), 1, 100, map[string]int64{"host-1": 50, "host-2": 50})
+	e, ok := errors.Cause(err).(*types.ErrUnschedulable)
+	assert.True(ok)
+	assert.Equal([]types.ScheduleConstraint{types.ScheduleConstraintComputeOnly}, e.Hosts[2].Constraints)
+
+	// controllers go on compute hosts, not on storage hosts
+	ops := &roleOps{hosts: hosts}
+	s := NewOrcScheduler(ops)
+	for id, ok := range map[string]bool{"host-1": true, "host-2": false, "host-3": true} {
+		ops.current = id
+		_, err := s.Schedule(&types.ScheduleItem{
+			Action:   types.ScheduleActionCreateController,
+			Instance: types.ScheduleInstance{ID: "controller", Type: types.InstanceTypeController, HostID: id, VolumeName: "vol"},
+		}, nil)
+		if ok {
+			assert.Nil(err, id)
+			continue
+		}
+		e, isUnschedulable := err.(*types.ErrUnschedulable)
+		assert.True(isUnschedulable, id)
+		assert.Equal([]types.ScheduleConstraint{types.ScheduleConstraintStorageOnly}, e.Reasons())
+	}
+}
+
+// roleOps processes the items on the current host, among the hosts
+type roleOps struct {
+	hosts   map[string]*types.HostInfo
+	current string
+}
+
+func (o *roleOps) ListHosts() (map[string]*types.HostInfo, error) {
+	return o.hosts, nil
+}
+
+func (o *roleOps) GetHost(id string) (*types.HostInfo, error) {
+	return o.hosts[id], nil
+}
+
+func (o *roleOps) GetCurrentHostID() string {
+	return o.current
+}
+
+func (o *roleOps) ProcessSchedule(ctx context.Context, item *types.ScheduleItem) (*types.InstanceInfo, error) {
+	return &types.InstanceInfo{ID: item.Instance.ID, Type: item.Instance.Type, HostID: item.Instance.HostID}, nil
+}
+
+// fakeOps processes the items on the current host, each waiting on gate
+type fakeOps struct {
+	started chan string
+	gate    chan struct{}
+}
+
+func (o *fakeOps) ListHosts() (map[string]*types.HostInfo, error) {
+	return newHosts(map[string]string{"host-1": ""}), nil
+}
+
+func (o *fakeOps) GetHost(id string) (*types.HostInfo, error) {
+	return &types.HostInfo{UUID: id}, nil
+}
+
+func (o *fakeOps) GetCurrentHostID() string {
+	return "host-1"
+}
+
+func (o *fakeOps) ProcessSchedule(ctx context.Context, item *types.ScheduleItem) (*types.InstanceInfo, error) {
+	o.started <- item.Instance.ID
+	<-o.gate
+	return &types.InstanceInfo{ID: item.Instance.ID, Type: item.Instance.Type, HostID: "host-1"}, nil
+}
+
+func TestSchedulerStatus(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(max int) { MaxConcurrentSchedules = max }(MaxConcurrentSchedules)
+	MaxConcurrentSchedules = 1
+	ops := &fakeOps{started: make(chan string, 2), gate: make(chan struct{})}
+	s := NewOrcScheduler(ops)
+
+	errCh := make(chan error)
+	for _, id := range []string{"replica-1", "replica-2"} {
+		go func(id string) {
+			_, err := s.Schedule(&types.ScheduleItem{
+				Action:   types.ScheduleActionCreateReplica,
+				Instance: types.ScheduleInstance{ID: id, Type: types.InstanceTypeReplica, VolumeName: "vol"},
+			}, nil)
+			errCh <- err
+		}(id)
+	}
+
+	first := <-ops.started
+	var status *types.SchedulerStatus
+	for i := 0; i < 100; i++ {
+		if status = s.Status(); status.Queued == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(1, status.Queued)
+	assert.Equal(1, status.InFlight)
+	assert.Len(status.Items, 2)
+	for _, item := range status.Items {
+		assert.Equal(item.InstanceID == first, item.InFlight)
+		assert.Equal(types.ScheduleActionCreateReplica, item.Action)
+	}
+	assert.Len(status.Latency, 0)
+
+	ops.gate <- struct{}{}
+	assert.Nil(<-errCh)
+	<-ops.started
+	status = s.Status()
+	assert.Equal(0, status.Queued)
+	assert.Equal(1, status.InFlight)
+	assert.Equal(1, status.Latency[types.ScheduleActionCreateReplica].Count)
+
+	ops.gate <- struct{}{}
+	assert.Nil(<-errCh)
+	status = s.Status()
+	assert.Equal(0, status.Queued+status.InFlight)
+	assert.Len(status.Items, 0)
+	latency := status.Latency[types.ScheduleActionCreateReplica]
+	assert.Equal(2, latency.Count)
+	assert.True(latency.Max >= latency.Average)
+}
+
+// hangingOps processes the items on the current host, the ones for the
+// instances in hang only return once abandoned
+type hangingOps struct {
+	fakeOps
+	hang map[string]bool
+}
+
+func (o *hangingOps) ProcessSchedule(ctx context.Context, item *types.ScheduleItem) (*types.InstanceInfo, error) {
+	if o.hang[item.Instance.ID] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &types.InstanceInfo{ID: item.Instance.ID, Type: item.Instance.Type, HostID: "host-1"}, nil
+}
+
+func TestProcessDeadline(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(max int, timeouts map[types.ScheduleAction]time.Duration, quarantine time.Duration) {
+		MaxConcurrentSchedules = max
+		ProcessTimeouts = timeouts
+		QuarantinePeriod = quarantine
+	}(MaxConcurrentSchedules, ProcessTimeouts, QuarantinePeriod)
+	MaxConcurrentSchedules = 1
+	ProcessTimeouts = map[types.ScheduleAction]time.Duration{types.ScheduleActionCreateReplica: 50 * time.Millisecond}
+	QuarantinePeriod = 300 * time.Millisecond
+	s := NewOrcScheduler(&hangingOps{hang: map[string]bool{"replica-1": true}})
+
+	item := func(action types.ScheduleAction, id string) *types.ScheduleItem {
+		return &types.ScheduleItem{
+			Action:   action,
+			Instance: types.ScheduleInstance{ID: id, Type: types.InstanceTypeReplica, HostID: "host-1", VolumeName: "vol"},
+		}
+	}
+
+	_, err := s.Schedule(item(types.ScheduleActionCreateReplica, "replica-1"), nil)
+	assert.NotNil(err)
+	assert.IsType(&types.ErrScheduleTimeout{}, errors.Cause(err))
+
+	// the pool moves on, but not for the instance, nor creates on the host
+	_, err = s.Schedule(item(types.ScheduleActionStartInstance, "replica-2"), nil)
+	assert.Nil(err)
+	_, err = s.Schedule(item(types.ScheduleActionDeleteInstance, "replica-1"), nil)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "instance replica-1 is quarantined")
+	_, err = s.Schedule(item(types.ScheduleActionCreateReplica, "replica-3"), nil)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "host host-1 is quarantined")
+
+	status := s.Status()
+	assert.Equal(map[types.ScheduleAction]int{types.ScheduleActionCreateReplica: 1}, status.Timeouts)
+	assert.Len(status.Abandoned, 1)
+	assert.Equal("replica-1", status.Abandoned[0].InstanceID)
+	assert.Equal("host-1", status.Abandoned[0].HostID)
+	assert.Len(status.Quarantined, 2)
+	assert.Equal("host-1", status.Quarantined[0].ID)
+	assert.Equal("replica-1", status.Quarantined[1].ID)
+
+	// the abandoned call saw its context cancelled and returned
+	for i := 0; i < 100 && s.Status().Leaked != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status = s.Status()
+	assert.Equal(0, status.Leaked)
+	assert.True(status.Abandoned[0].Returned)
+
+	time.Sleep(QuarantinePeriod)
+	_, err = s.Schedule(item(types.ScheduleActionCreateReplica, "replica-3"), nil)
+	assert.Nil(err)
+	assert.Len(s.Status().Quarantined, 0)
+}
+
+func TestPlanReplicas(t *testing.T) {
+	assert := require.New(t)
+
+	hosts := newHosts(map[string]string{
+		"host-1": "zone-a",
+		"host-2": "zone-a",
+		"host-3": "zone-b",
+		"host-4": "zone-b",
+	})
+	policy := &types.SchedulePolicy{
+		Binding:   types.SchedulePolicyBindingSoftAntiAffinity,
+		HostIDMap: map[string]struct{}{},
+		Replicas:  3,
+	}
+	available := map[string]int64{"host-1": 100, "host-2": 300, "host-3": 200, "host-4": 50}
+
+	// a new zone first, the most space available among the same priority
+	planned, err := PlanReplicas(hosts, policy, 3, 60, available)
+	assert.Nil(err)
+	assert.Equal([]string{"host-2", "host-3", "host-1"}, planned)
+	// the policy and the capacity are left alone
+	assert.Len(policy.HostIDMap, 0)
+	assert.Equal(int64(300), available["host-2"])
+
+	// the hosts bound already come last, host-4 is too small
+	policy.HostIDMap["host-2"] = struct{}{}
+	planned, err = PlanReplicas(hosts, policy, 2, 120, available)
+	assert.Nil(err)
+	assert.Equal([]string{"host-3", "host-2"}, planned)
+
+	_, err = PlanReplicas(hosts, policy, 3, 250, available)
+	assert.NotNil(err)
+
+	// unknown capacity comes after the known one
+	delete(available, "host-1")
+	planned, err = PlanReplicas(hosts, policy, 2, 250, available)
+	assert.Nil(err)
+	assert.Equal([]string{"host-1", "host-2"}, planned)
+}
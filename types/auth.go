@@ -0,0 +1,60 @@
+package types
+
+// APIRole is what a token is allowed to do on the API, each role having the
+// rights of the roles before it
+type APIRole string
+
+const (
+	// reads
+	APIRoleReadonly = APIRole("readonly")
+	// volume, volume group and backup changes as well
+	APIRoleOperator = APIRole("operator")
+	// settings, hosts, admin endpoints and tokens as well
+	APIRoleAdmin = APIRole("admin")
+)
+
+var APIRoles = []APIRole{APIRoleReadonly, APIRoleOperator, APIRoleAdmin}
+
+func (r APIRole) rank() int {
+	for i, role := range APIRoles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r APIRole) Valid() bool {
+	return r.rank() >= 0
+}
+
+// Allows is true if the role has the rights of required
+func (r APIRole) Allows(required APIRole) bool {
+	return r.Valid() && required.Valid() && r.rank() >= required.rank()
+}
+
+const (
+	APITokenSourceStore = "store"
+	// from the tokens file of the manager, not revocable through the API
+	APITokenSourceFile = "file"
+)
+
+// APIToken is a token of the API, only its SHA-256 hash is kept
+type APIToken struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Role    APIRole `json:"role"`
+	Hash    string  `json:"hash"`
+	Created string  `json:"created,omitempty"`
+	Source  string  `json:"source"`
+	// Namespace would scope the token, the volumes have no namespaces so
+	// the tokens with one are refused
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// TokenStore keeps the API tokens created through the API
+type TokenStore interface {
+	ListAPITokens() (map[string]*APIToken, error)
+	SetAPIToken(token *APIToken) error
+	DeleteAPIToken(id string) error
+}
@@ -0,0 +1,71 @@
+package types
+
+// Bootstrap is the state declared by the bootstrap file, the manager
+// converges toward it on start. It only adds: the volumes existing are left
+// as they are, and nothing is deleted.
+type Bootstrap struct {
+	File   string
+	Digest string
+
+	Settings []*BootstrapSetting
+	Volumes  []*BootstrapVolume
+
+	// ApplySetting validates the value of the setting and sets it
+	ApplySetting func(si *SettingsInfo, name, value string) error `json:"-"`
+}
+
+type BootstrapSetting struct {
+	Line  int
+	Name  string
+	Value string
+}
+
+type BootstrapVolume struct {
+	Line   int
+	Volume *VolumeInfo
+}
+
+type BootstrapResult string
+
+const (
+	BootstrapResultCreated = BootstrapResult("created")
+	BootstrapResultUpdated = BootstrapResult("updated")
+	BootstrapResultSkipped = BootstrapResult("skipped")
+	BootstrapResultFailed  = BootstrapResult("failed")
+)
+
+// BootstrapItem is the outcome of a setting or a volume of the bootstrap file
+type BootstrapItem struct {
+	Kind   string          `json:"kind"` // setting or volume
+	Name   string          `json:"name"`
+	Line   int             `json:"line"`
+	Result BootstrapResult `json:"result"`
+	Reason string          `json:"reason,omitempty"`
+}
+
+// BootstrapReport is the outcome of the last bootstrap
+type BootstrapReport struct {
+	File     string           `json:"file"`
+	Digest   string           `json:"digest"`
+	HostID   string           `json:"hostId"`
+	Started  string           `json:"started"`
+	Finished string           `json:"finished"`
+	Items    []*BootstrapItem `json:"items"`
+}
+
+// Count is the number of items with the result
+func (r *BootstrapReport) Count(result BootstrapResult) int {
+	count := 0
+	for _, item := range r.Items {
+		if item.Result == result {
+			count++
+		}
+	}
+	return count
+}
+
+// BootstrapStore keeps the report of the last bootstrap
+type BootstrapStore interface {
+	GetBootstrapReport() (*BootstrapReport, error) // nil if there was none
+	SetBootstrapReport(report *BootstrapReport) error
+}
@@ -0,0 +1,38 @@
+package types
+
+// ClusterCA is the certificate authority of the TLS between the managers,
+// in PEM. Its key is sealed with the cluster CA key of the managers, so
+// reading the store isn't enough to issue certificates. After a rotation,
+// the previous CA is still trusted until PreviousUntil, while the managers
+// renew their certificates.
+type ClusterCA struct {
+	Cert      string `json:"cert"`
+	SealedKey string `json:"sealedKey,omitempty"`
+	// the key in cleartext, as stored before the keys were sealed, it's
+	// sealed by the next manager started
+	Key           string `json:"key,omitempty"`
+	Created       string `json:"created"`
+	PreviousCert  string `json:"previousCert,omitempty"`
+	PreviousUntil string `json:"previousUntil,omitempty"`
+}
+
+// CertStore keeps the cluster CA
+type CertStore interface {
+	GetClusterCA() (*ClusterCA, error) // nil if not generated yet
+	SetClusterCA(ca *ClusterCA) error
+}
+
+// TLSStatus reports the certificates of the TLS between the managers, as
+// seen by the manager
+type TLSStatus struct {
+	Enabled bool `json:"enabled"`
+
+	CAExpiry string `json:"caExpiry,omitempty"`
+	// the CA rotated out, trusted until PreviousCAUntil
+	PreviousCAExpiry string `json:"previousCAExpiry,omitempty"`
+	PreviousCAUntil  string `json:"previousCAUntil,omitempty"`
+
+	// the certificate of the manager
+	CertExpiry  string `json:"certExpiry,omitempty"`
+	CertRenewed string `json:"certRenewed,omitempty"`
+}
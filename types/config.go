@@ -0,0 +1,50 @@
+package types
+
+// RuntimeConfig is the configuration resolved from the flags and their
+// defaults, for debugging. The secrets are redacted.
+type RuntimeConfig struct {
+	Orchestrator  string   `json:"orchestrator"`
+	HostID        string   `json:"hostId"`
+	Address       string   `json:"address"`
+	EtcdServers   []string `json:"etcdServers"`
+	EtcdPrefix    string   `json:"etcdPrefix"`
+	EtcdReadCache bool     `json:"etcdReadCache"`
+	EtcdUsername  string   `json:"etcdUsername,omitempty"`
+	EngineImage   string   `json:"engineImage"`
+	Network       string   `json:"network"`
+	IP            string   `json:"ip"`
+
+	ReplicaDNSAlias     bool              `json:"replicaDNSAlias"`
+	ReplicaBlockDevices []string          `json:"replicaBlockDevices,omitempty"`
+	ReplicaMountpoints  []string          `json:"replicaMountpoints,omitempty"`
+	InstanceLogDriver   string            `json:"instanceLogDriver,omitempty"`
+	InstanceLogOpts     map[string]string `json:"instanceLogOpts,omitempty"`
+	// Timeouts of the instances, by flag name
+	Timeouts map[string]string `json:"timeouts"`
+
+	HostConflictThreshold int    `json:"hostConflictThreshold"`
+	HostConflictWindow    string `json:"hostConflictWindow"`
+	HostUUIDCollision     string `json:"hostUUIDCollision"`
+
+	MaxConcurrentSchedules int    `json:"maxConcurrentSchedules"`
+	ScheduleTimeout        string `json:"scheduleTimeout"`
+	// ScheduleActionTimeouts override ScheduleTimeout, by action
+	ScheduleActionTimeouts   map[ScheduleAction]string `json:"scheduleActionTimeouts,omitempty"`
+	ScheduleQuarantinePeriod string                    `json:"scheduleQuarantinePeriod"`
+
+	// the manager fills in its own
+	MaxConcurrentProvisioning int    `json:"maxConcurrentProvisioning"`
+	EventFlushInterval        string `json:"eventFlushInterval"`
+	EventBatchSize            int    `json:"eventBatchSize"`
+	EventQueueSize            int    `json:"eventQueueSize"`
+	ReplicaPlanReservation    string `json:"replicaPlanReservation"`
+	RawEditingEnabled         bool   `json:"rawEditingEnabled"`
+	ReplicaRebuildTimeout     string `json:"replicaRebuildTimeout"`
+	RebuildStallTimeout       string `json:"rebuildStallTimeout"`
+	RebuildMaxRetries         int    `json:"rebuildMaxRetries"`
+	InternalTLS               bool   `json:"internalTLS"`
+	APITokensFile             string `json:"apiTokensFile,omitempty"`
+	BootstrapFile             string `json:"bootstrapFile,omitempty"`
+	// the API server fills in its own
+	APIAuth bool `json:"apiAuth"`
+}
@@ -0,0 +1,48 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrEngineStatusRateLimited is returned if the engine status of the instance
+// was asked for too recently
+type ErrEngineStatusRateLimited struct {
+	InstanceName string
+	RetryAfter   time.Duration
+}
+
+func (e *ErrEngineStatusRateLimited) Error() string {
+	return fmt.Sprintf("engine status of %v asked for too often, retry after %v", e.InstanceName, e.RetryAfter)
+}
+
+// EngineStatus is what the engine process of an instance reports, in the
+// same shape for all the engine versions. The fields the running engine
+// doesn't report are nil.
+type EngineStatus struct {
+	InstanceName string       `json:"instanceName"`
+	InstanceType InstanceType `json:"instanceType"`
+	HostID       string       `json:"hostId"`
+	EngineImage  string       `json:"engineImage"`
+	Version      *string      `json:"version"` // from the tag of the engine image
+	Checked      string       `json:"checked"`
+
+	// reported by controllers
+	Frontend      *string                    `json:"frontend"`
+	FrontendState *string                    `json:"frontendState"`
+	Endpoint      *string                    `json:"endpoint"`
+	QueueDepth    *int64                     `json:"queueDepth"`
+	Connections   []*EngineReplicaConnection `json:"connections"`
+
+	// reported by replicas
+	ReplicaState    *string `json:"replicaState"`
+	Rebuilding      *bool   `json:"rebuilding"`
+	RevisionCounter *int64  `json:"revisionCounter"`
+}
+
+// EngineReplicaConnection is a replica the controller has a data connection
+// to
+type EngineReplicaConnection struct {
+	Address string      `json:"address"`
+	Mode    ReplicaMode `json:"mode"`
+}
@@ -0,0 +1,59 @@
+package types
+
+type EventSeverity string
+
+const (
+	EventSeverityInfo    = EventSeverity("info")
+	EventSeverityWarning = EventSeverity("warning")
+	EventSeverityError   = EventSeverity("error")
+)
+
+// Rank orders the severities, the events of the lowest rank are dropped first
+// when the manager cannot write them fast enough.
+func (s EventSeverity) Rank() int {
+	switch s {
+	case EventSeverityWarning:
+		return 1
+	case EventSeverityError:
+		return 2
+	}
+	return 0
+}
+
+type VolumeEvent struct {
+	Volume   string        `json:"volume"`
+	Time     string        `json:"time"`
+	HostID   string        `json:"hostId"`
+	Severity EventSeverity `json:"severity"`
+	Reason   string        `json:"reason"`
+	Message  string        `json:"message"`
+	// Batch is the batch the event was appended in, and Keep exempts the
+	// batch from the record GC. Both are set when listed.
+	Batch string `json:"batch,omitempty"`
+	Keep  bool   `json:"keep,omitempty"`
+}
+
+// EventStore keeps the events of the volumes. The events of a volume are
+// appended in batches, and listed in the order they were appended.
+type EventStore interface {
+	AppendVolumeEvents(volumeName string, events []*VolumeEvent) error
+	ListVolumeEvents(volumeName string) ([]*VolumeEvent, error) // oldest first
+	DeleteVolumeEvents(volumeName string) error
+
+	// PruneVolumeEvents removes the batches expired and returns their
+	// events, never the batches kept
+	PruneVolumeEvents(volumeName string, expired func(events []*VolumeEvent) bool) ([][]*VolumeEvent, error)
+	KeepVolumeEvents(volumeName, batch string, keep bool) error
+	VolumeEventCounts(volumeName string) (*RecordCounts, error)
+}
+
+// EventRecorderStatus reports the events buffered by the manager, not yet
+// written to the store
+type EventRecorderStatus struct {
+	Queued        int    `json:"queued"`
+	QueueSize     int    `json:"queueSize"`
+	BatchSize     int    `json:"batchSize"`
+	FlushInterval string `json:"flushInterval"`
+	// events dropped since the start as the queue overflowed, by severity
+	Dropped map[EventSeverity]int64 `json:"dropped"`
+}
@@ -0,0 +1,21 @@
+package types
+
+type VolumeAttachState string
+
+const (
+	VolumeAttachStateAny      = VolumeAttachState("")
+	VolumeAttachStateAttached = VolumeAttachState("attached")
+	VolumeAttachStateDetached = VolumeAttachState("detached")
+)
+
+// VolumeFilter selects volumes, the fields left empty match any volume
+type VolumeFilter struct {
+	State VolumeAttachState `json:"state,omitempty"`
+	// Health is healthy, degraded or faulted, the state of the volume. A
+	// faulted volume may be detached.
+	Health VolumeState `json:"health,omitempty"`
+	// HostID is the host of the controller or of a replica of the volume
+	HostID string `json:"hostId,omitempty"`
+	// Selector is the labels the volume has all of
+	Selector map[string]string `json:"selector,omitempty"`
+}
@@ -0,0 +1,39 @@
+package types
+
+// VolumeGroup is a set of volumes managed together. The members are the
+// volumes listed, plus the ones with all the labels of the selector.
+type VolumeGroup struct {
+	Name     string            `json:"name"`
+	Volumes  []string          `json:"volumes,omitempty"`
+	Selector map[string]string `json:"selector,omitempty"`
+	Created  string            `json:"created"`
+}
+
+// VolumeGroupStatus aggregates the members of a volume group
+type VolumeGroupStatus struct {
+	Members []string `json:"members"`
+	// Missing are the volumes listed which don't exist
+	Missing []string `json:"missing,omitempty"`
+	// State is the worst state of the members, empty for no member
+	State VolumeState `json:"state"`
+
+	Size int64 `json:"size"`
+	// ActualSize is the space used by a good replica of each member, the
+	// members unknown are skipped
+	ActualSize        int64    `json:"actualSize"`
+	ActualSizeUnknown []string `json:"actualSizeUnknown,omitempty"`
+}
+
+// VolumeGroupResult is the outcome of a group operation on a member
+type VolumeGroupResult struct {
+	Volume string `json:"volume"`
+	Error  string `json:"error,omitempty"`
+}
+
+// VolumeGroupStore keeps the volume groups
+type VolumeGroupStore interface {
+	GetVolumeGroup(name string) (*VolumeGroup, error) // nil if not found
+	ListVolumeGroups() (map[string]*VolumeGroup, error)
+	SetVolumeGroup(group *VolumeGroup) error
+	DeleteVolumeGroup(name string) error
+}
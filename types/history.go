@@ -0,0 +1,32 @@
+package types
+
+// StateTransition is a change of the state of a volume, recorded by the
+// manager of HostID which wrote the change
+type StateTransition struct {
+	Time   string      `json:"time"`
+	From   VolumeState `json:"from"`
+	To     VolumeState `json:"to"`
+	HostID string      `json:"hostId"`
+	// Seq numbers the transitions of the volume from 1, Keep exempts the
+	// transition from the record GC
+	Seq  int64 `json:"seq"`
+	Keep bool  `json:"keep,omitempty"`
+}
+
+// HistoryStore keeps the latest state transitions of the volumes
+type HistoryStore interface {
+	// RecordVolumeState appends the transition to the state at the time
+	// of the change unless it's the state recorded last already, and
+	// returns if it did
+	RecordVolumeState(volumeName string, state VolumeState, hostID, at string) (bool, error)
+	// GetVolumeHistory returns the latest limit transitions, all of them
+	// if limit is 0, oldest first
+	GetVolumeHistory(volumeName string, limit int) ([]StateTransition, error)
+	DeleteVolumeHistory(volumeName string) error
+
+	// PruneVolumeHistory removes the transitions expired and returns them,
+	// never those kept nor the latest one
+	PruneVolumeHistory(volumeName string, expired func(transition *StateTransition) bool) ([]StateTransition, error)
+	KeepVolumeState(volumeName string, seq int64, keep bool) error
+	VolumeHistoryCounts(volumeName string) (*RecordCounts, error)
+}
@@ -0,0 +1,68 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// LockInfo is a lock in the store, held by one operation of a host until it
+// expires. The store has no TTL of its own, a lock expires once Expires
+// passes without a refresh, and is cleaned up by the next listing.
+type LockInfo struct {
+	Name        string `json:"name"`
+	HolderID    string `json:"holderId"`
+	OperationID string `json:"operationId"`
+	VolumeName  string `json:"volumeName,omitempty"`
+	Acquired    string `json:"acquired"`
+	Expires     string `json:"expires"`
+	// RevokedBy is set when the lock is broken. The holder learns it on the
+	// next refresh, and other operations may take the lock right away.
+	RevokedBy string `json:"revokedBy,omitempty"`
+}
+
+// Held is true if the lock is neither expired nor revoked at now
+func (l *LockInfo) Held(now time.Time) bool {
+	if l.RevokedBy != "" {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, l.Expires)
+	return err == nil && now.Before(expires)
+}
+
+// LockStore keeps the locks shared by the managers
+type LockStore interface {
+	// AcquireLock fails with ErrLockHeld if another operation holds the
+	// lock
+	AcquireLock(lock *LockInfo, ttl time.Duration) error
+	// RefreshLock extends the lock, or fails with ErrLockLost if the
+	// operation no longer holds it
+	RefreshLock(name, operationID string, ttl time.Duration) error
+	ReleaseLock(name, operationID string) error
+	// ListLocks removes the expired locks and returns the others, sorted by
+	// name
+	ListLocks() ([]*LockInfo, error)
+	// RevokeLock breaks the lock held by the operation and returns it
+	RevokeLock(name, operationID, revokedBy string) (*LockInfo, error)
+}
+
+type ErrLockHeld struct {
+	Lock *LockInfo
+}
+
+func (e *ErrLockHeld) Error() string {
+	return fmt.Sprintf("lock %v is held by operation %v of host %v until %v",
+		e.Lock.Name, e.Lock.OperationID, e.Lock.HolderID, e.Lock.Expires)
+}
+
+type ErrLockLost struct {
+	Name        string
+	OperationID string
+	RevokedBy   string
+}
+
+func (e *ErrLockLost) Error() string {
+	if e.RevokedBy != "" {
+		return fmt.Sprintf("lock %v of operation %v was broken by %v", e.Name, e.OperationID, e.RevokedBy)
+	}
+	return fmt.Sprintf("lock %v of operation %v was lost", e.Name, e.OperationID)
+}
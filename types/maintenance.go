@@ -0,0 +1,63 @@
+package types
+
+import (
+	"time"
+)
+
+type MaintenancePolicy string
+
+const (
+	// the volumes attached on the host are detached
+	MaintenancePolicyControllers = MaintenancePolicy("controllers")
+	// the replicas on the host are migrated off it too
+	MaintenancePolicyFull = MaintenancePolicy("full")
+)
+
+type MaintenanceState string
+
+const (
+	MaintenanceStateScheduled = MaintenanceState("scheduled")
+	// the host is drained and cordoned until the end of the window
+	MaintenanceStateActive = MaintenanceState("active")
+	MaintenanceStateDone   = MaintenanceState("done")
+)
+
+// MaintenanceWindow is a planned maintenance of a host. The host is drained
+// at Start, and put back in use once it's back after Duration, with the
+// volumes moved off it moved back if Restore is set.
+type MaintenanceWindow struct {
+	Start    string            `json:"start"`
+	Duration time.Duration     `json:"duration"`
+	Policy   MaintenancePolicy `json:"policy"`
+	Restore  bool              `json:"restore"`
+	State    MaintenanceState  `json:"state"`
+
+	// the layout before the drain, to restore
+	WasUnschedulable bool            `json:"wasUnschedulable,omitempty"`
+	DetachedVolumes  []string        `json:"detachedVolumes,omitempty"`
+	MovedReplicas    []*MovedReplica `json:"movedReplicas,omitempty"`
+	Pending          []string        `json:"pending,omitempty"` // replicas left on the host
+	Drained          string          `json:"drained,omitempty"`
+	Restored         string          `json:"restored,omitempty"`
+}
+
+// MovedReplica is a replica removed from a host in maintenance. The volume
+// was detached, it rebuilds the replica elsewhere on the next attach.
+type MovedReplica struct {
+	VolumeName  string `json:"volumeName"`
+	ReplicaName string `json:"replicaName"`
+}
+
+// Window returns the start and the end of the window
+func (w *MaintenanceWindow) Window() (time.Time, time.Time, error) {
+	start, err := time.Parse(time.RFC3339, w.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return start, start.Add(w.Duration), nil
+}
+
+// Open is true until the window is done
+func (w *MaintenanceWindow) Open() bool {
+	return w != nil && w.State != MaintenanceStateDone
+}
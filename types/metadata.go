@@ -0,0 +1,28 @@
+package types
+
+// MetadataSnapshotVersion is the version of the metadata snapshots written,
+// the snapshots of later versions cannot be read
+const MetadataSnapshotVersion = 1
+
+// MetadataSnapshot is the metadata exported to the backup target, enough to
+// find the data of the volumes again if etcd is lost: the volumes with the
+// host and the name of each replica, the hosts and the settings. It has no
+// running state, the volumes are detached.
+type MetadataSnapshot struct {
+	Version int    `json:"version"`
+	Created string `json:"created"`
+	HostID  string `json:"hostID"` // the host exporting it
+
+	Settings *SettingsInfo `json:"settings,omitempty"`
+	Hosts    []*HostInfo   `json:"hosts"`
+	Volumes  []*VolumeInfo `json:"volumes"`
+}
+
+// MetadataStore keeps the metadata snapshots on the backup target
+type MetadataStore interface {
+	Put(name string, data []byte) error
+	// Latest is the name and the data of the last snapshot, "" if none
+	Latest() (string, []byte, error)
+}
+
+type GetMetadataStore func(backupTarget string) (MetadataStore, error)
@@ -0,0 +1,22 @@
+package types
+
+type MetricType string
+
+const (
+	MetricTypeGauge   = MetricType("gauge")
+	MetricTypeCounter = MetricType("counter")
+)
+
+// MetricFamily is a metric exported in the Prometheus text format, with a
+// sample for each set of labels
+type MetricFamily struct {
+	Name    string
+	Help    string
+	Type    MetricType
+	Samples []*MetricSample
+}
+
+type MetricSample struct {
+	Labels map[string]string
+	Value  float64
+}
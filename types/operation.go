@@ -0,0 +1,20 @@
+package types
+
+import (
+	"time"
+
+	"github.com/rancher/longhorn-manager/util/trace"
+)
+
+// SlowOperation is an operation of the manager which took longer than the
+// slow operation threshold, with the steps it took
+type SlowOperation struct {
+	ID         string        `json:"operationId"`
+	Operation  string        `json:"operation"`
+	VolumeName string        `json:"volumeName"`
+	HostID     string        `json:"hostId"`
+	Started    string        `json:"started"`
+	Duration   time.Duration `json:"duration"`
+	Error      string        `json:"error,omitempty"`
+	Trace      *trace.Record `json:"trace"`
+}
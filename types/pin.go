@@ -0,0 +1,15 @@
+package types
+
+import (
+	"fmt"
+)
+
+// ErrReplicasPinned is returned for migrating a replica of a volume with
+// PinReplicas set
+type ErrReplicasPinned struct {
+	VolumeName string
+}
+
+func (e *ErrReplicasPinned) Error() string {
+	return fmt.Sprintf("replicas of volume %v are pinned", e.VolumeName)
+}
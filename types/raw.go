@@ -0,0 +1,19 @@
+package types
+
+// RawRecord is a key of the store with its JSON value as it's stored, for
+// support to inspect and repair the metadata of a volume
+type RawRecord struct {
+	Key           string `json:"key"`
+	Value         string `json:"value"`
+	ModifiedIndex uint64 `json:"modifiedIndex"`
+}
+
+// RawStore gives the raw records of a volume
+type RawStore interface {
+	// ListVolumeRawRecords returns the keys of the volume, its events and
+	// the locks of its operations, sorted by key
+	ListVolumeRawRecords(volumeName string) ([]*RawRecord, error)
+	// SetVolumeRawRecord writes the value of one of the keys listed for the
+	// volume, and fails if the key was modified after record.ModifiedIndex
+	SetVolumeRawRecord(volumeName string, record *RawRecord) error
+}
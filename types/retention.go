@@ -0,0 +1,18 @@
+package types
+
+// RecordCounts are the number of records of a list written in all, and of
+// those retained. The others were removed by the limit of the list or by the
+// record GC.
+type RecordCounts struct {
+	Total    int64 `json:"total"`
+	Retained int64 `json:"retained"`
+}
+
+// RecordOutcome tells the records of a failure, which the record GC keeps
+// longer, from the others
+type RecordOutcome string
+
+const (
+	RecordOutcomeSucceeded = RecordOutcome("succeeded")
+	RecordOutcomeFailed    = RecordOutcome("failed")
+)
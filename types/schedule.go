@@ -1,33 +1,197 @@
 package types
 
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ScheduleAction string
+
 const (
-	ScheduleActionCreateController = "create-controller"
-	ScheduleActionCreateReplica    = "create-replica"
-	ScheduleActionDeleteInstance   = "delete"
-	ScheduleActionStartInstance    = "start"
-	ScheduleActionStopInstance     = "stop"
+	ScheduleActionCreateController = ScheduleAction("create-controller")
+	ScheduleActionCreateReplica    = ScheduleAction("create-replica")
+	ScheduleActionDeleteInstance   = ScheduleAction("delete")
+	ScheduleActionStartInstance    = ScheduleAction("start")
+	ScheduleActionStopInstance     = ScheduleAction("stop")
 )
 
+var ScheduleActions = []ScheduleAction{
+	ScheduleActionCreateController,
+	ScheduleActionCreateReplica,
+	ScheduleActionDeleteInstance,
+	ScheduleActionStartInstance,
+	ScheduleActionStopInstance,
+}
+
+func (a ScheduleAction) Valid() bool {
+	for _, action := range ScheduleActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrUnknownAction is returned for a schedule action without a handler
+type ErrUnknownAction struct {
+	Action ScheduleAction
+}
+
+func (e *ErrUnknownAction) Error() string {
+	return fmt.Sprintf("unknown schedule action %q", string(e.Action))
+}
+
+// ErrScheduleTimeout is returned for an item abandoned after its deadline,
+// what the item did is unknown
+type ErrScheduleTimeout struct {
+	Action     ScheduleAction
+	InstanceID string
+	Timeout    time.Duration
+}
+
+func (e *ErrScheduleTimeout) Error() string {
+	return fmt.Sprintf("schedule %v of %v abandoned after timeout %v", e.Action, e.InstanceID, e.Timeout)
+}
+
+// ScheduleConstraint is a constraint of the scheduler a candidate host
+// fails, it's stable for alerting
+type ScheduleConstraint string
+
+const (
+	ScheduleConstraintOffline    = ScheduleConstraint("offline")
+	ScheduleConstraintCordoned   = ScheduleConstraint("cordoned")
+	ScheduleConstraintConflicted = ScheduleConstraint("conflicted")
+	// the role of the host doesn't run the instance
+	ScheduleConstraintComputeOnly = ScheduleConstraint("computeOnly")
+	ScheduleConstraintStorageOnly = ScheduleConstraint("storageOnly")
+	// the host is back from an outage for less than the cooldown
+	ScheduleConstraintRecovering = ScheduleConstraint("recovering")
+	// the host is not one the policy binds to
+	ScheduleConstraintNotBound = ScheduleConstraint("notBound")
+	// a replica on the host would break the zone distribution
+	ScheduleConstraintZoneDistribution  = ScheduleConstraint("zoneDistribution")
+	ScheduleConstraintInsufficientSpace = ScheduleConstraint("insufficientSpace")
+	// the host passed the filters, but creating the instance on it failed
+	ScheduleConstraintProcessFailed = ScheduleConstraint("processFailed")
+	// there is no candidate host at all
+	ScheduleConstraintNoHosts = ScheduleConstraint("noHosts")
+)
+
+var scheduleConstraintDescriptions = map[ScheduleConstraint]string{
+	ScheduleConstraintOffline:           "offline",
+	ScheduleConstraintCordoned:          "cordoned",
+	ScheduleConstraintConflicted:        "conflicted",
+	ScheduleConstraintComputeOnly:       "reserved for compute",
+	ScheduleConstraintStorageOnly:       "reserved for storage",
+	ScheduleConstraintRecovering:        "recovering from an outage",
+	ScheduleConstraintNotBound:          "not bound by the policy",
+	ScheduleConstraintZoneDistribution:  "breaking the zone distribution",
+	ScheduleConstraintInsufficientSpace: "without enough space",
+	ScheduleConstraintProcessFailed:     "failing to create the instance",
+	ScheduleConstraintNoHosts:           "no hosts",
+}
+
+func (c ScheduleConstraint) Description() string {
+	if d, ok := scheduleConstraintDescriptions[c]; ok {
+		return d
+	}
+	return string(c)
+}
+
+// HostConstraints are the constraints a candidate host fails
+type HostConstraints struct {
+	HostID      string               `json:"hostId"`
+	Constraints []ScheduleConstraint `json:"constraints"`
+}
+
+// ErrUnschedulable is returned if no candidate host can take the instance,
+// with the constraints each one fails, by host ID
+type ErrUnschedulable struct {
+	Action     ScheduleAction
+	InstanceID string
+	Hosts      []*HostConstraints
+}
+
+// NewErrUnschedulable sorts the hosts failing the constraints by ID
+func NewErrUnschedulable(failed map[string][]ScheduleConstraint) *ErrUnschedulable {
+	e := &ErrUnschedulable{Hosts: []*HostConstraints{}}
+	for id, constraints := range failed {
+		e.Hosts = append(e.Hosts, &HostConstraints{HostID: id, Constraints: constraints})
+	}
+	sort.Slice(e.Hosts, func(i, j int) bool { return e.Hosts[i].HostID < e.Hosts[j].HostID })
+	return e
+}
+
+// Reasons returns each constraint failed once, sorted
+func (e *ErrUnschedulable) Reasons() []ScheduleConstraint {
+	if len(e.Hosts) == 0 {
+		return []ScheduleConstraint{ScheduleConstraintNoHosts}
+	}
+	seen := map[ScheduleConstraint]bool{}
+	reasons := []ScheduleConstraint{}
+	for _, h := range e.Hosts {
+		for _, c := range h.Constraints {
+			if !seen[c] {
+				seen[c] = true
+				reasons = append(reasons, c)
+			}
+		}
+	}
+	sort.Slice(reasons, func(i, j int) bool { return reasons[i] < reasons[j] })
+	return reasons
+}
+
+// Message describes the constraints failed by each host
+func (e *ErrUnschedulable) Message() string {
+	if len(e.Hosts) == 0 {
+		return "no hosts"
+	}
+	hosts := []string{}
+	for _, h := range e.Hosts {
+		descriptions := []string{}
+		for _, c := range h.Constraints {
+			descriptions = append(descriptions, c.Description())
+		}
+		hosts = append(hosts, fmt.Sprintf("host %v %v", h.HostID, strings.Join(descriptions, ", ")))
+	}
+	return strings.Join(hosts, "; ")
+}
+
+func (e *ErrUnschedulable) Error() string {
+	if e.InstanceID == "" {
+		return fmt.Sprintf("unable to find suitable host: %v", e.Message())
+	}
+	return fmt.Sprintf("unable to find suitable host for %v of %v: %v", e.Action, e.InstanceID, e.Message())
+}
+
 type SchedulePolicyBinding string
 
 const (
 	SchedulePolicyBindingSoftAntiAffinity = "soft.anti-affinity"
+	// SchedulePolicyBindingHost only schedules on the hosts in HostIDMap
+	SchedulePolicyBindingHost = "host"
 )
 
 type Scheduler interface {
 	Schedule(item *ScheduleItem, policy *SchedulePolicy) (*InstanceInfo, error)
 	Process(spec *ScheduleSpec, item *ScheduleItem) (*InstanceInfo, error)
+	Status() *SchedulerStatus
 }
 
 type ScheduleOps interface {
 	ListHosts() (map[string]*HostInfo, error)
 	GetHost(id string) (*HostInfo, error)
 	GetCurrentHostID() string
-	ProcessSchedule(item *ScheduleItem) (*InstanceInfo, error)
+	// ProcessSchedule should stop, and not record anything, once ctx is
+	// done, the scheduler has abandoned the item then
+	ProcessSchedule(ctx context.Context, item *ScheduleItem) (*InstanceInfo, error)
 }
 
 type ScheduleItem struct {
-	Action   string
+	Action   ScheduleAction
 	Instance ScheduleInstance
 	Data     ScheduleData
 }
@@ -52,4 +216,105 @@ type ScheduleData struct {
 type SchedulePolicy struct {
 	Binding   SchedulePolicyBinding
 	HostIDMap map[string]struct{}
+
+	// zone distribution of the soft anti-affinity replicas, Replicas is the
+	// number the volume should end up with
+	Replicas           int
+	MaxReplicasPerZone int
+	MinZones           int
+
+	// with soft anti-affinity, PreferredHostID is tried first if it's
+	// schedulable, not bound and allowed by the zone distribution
+	PreferredHostID string
+}
+
+type SchedulerStatus struct {
+	Queued   int                   `json:"queued"`
+	InFlight int                   `json:"inFlight"`
+	Items    []*ScheduleItemStatus `json:"items"`
+	// latency of the recent items processed, by action
+	Latency map[ScheduleAction]*ScheduleLatency `json:"latency"`
+
+	// items abandoned after their deadline, by action
+	Timeouts map[ScheduleAction]int `json:"timeouts"`
+	// the recent abandoned items, and how many are still running
+	Abandoned   []*AbandonedSchedule  `json:"abandoned"`
+	Leaked      int                   `json:"leaked"`
+	Quarantined []*ScheduleQuarantine `json:"quarantined"`
+
+	// volumes with replicas which cannot be placed, by the constraints
+	// the hosts fail
+	Unschedulable map[ScheduleConstraint]int `json:"unschedulable"`
+}
+
+// AbandonedSchedule is an item processed on the host which didn't finish
+// before its deadline. Its outcome is unknown, the metadata may not match
+// the containers.
+type AbandonedSchedule struct {
+	Action       ScheduleAction `json:"action"`
+	InstanceID   string         `json:"instanceId"`
+	InstanceName string         `json:"instanceName"`
+	InstanceType InstanceType   `json:"instanceType"`
+	VolumeName   string         `json:"volumeName"`
+	HostID       string         `json:"hostId"`
+	Abandoned    string         `json:"abandoned"`
+	// the call returned after it was abandoned
+	Returned bool `json:"returned"`
+}
+
+// ScheduleQuarantine refuses items for an instance, or creating instances on
+// a host, until the time
+type ScheduleQuarantine struct {
+	ID    string `json:"id"`
+	Until string `json:"until"`
+}
+
+type ScheduleItemStatus struct {
+	Action     ScheduleAction `json:"action"`
+	InstanceID string         `json:"instanceId"`
+	VolumeName string         `json:"volumeName"`
+	InFlight   bool           `json:"inFlight"`
+	Since      string         `json:"since"`
+}
+
+type ScheduleLatency struct {
+	Count   int           `json:"count"`
+	Average time.Duration `json:"average"`
+	Max     time.Duration `json:"max"`
+	Last    time.Duration `json:"last"`
+}
+
+// CapacityCheck asks how many of Count volumes of Size with Replicas would
+// fit on the hosts
+type CapacityCheck struct {
+	Count    int   `json:"count"`
+	Size     int64 `json:"size"`
+	Replicas int   `json:"replicas"`
+	// the hosts to place on, by name, uuid or failureDomain
+	NodeSelector map[string]string `json:"nodeSelector"`
+	// of the storage of a host which can be reserved by replicas, 100 if 0
+	OverProvisioningPercentage int `json:"overProvisioningPercentage"`
+	// 0 for unlimited
+	MaxReplicasPerHost int `json:"maxReplicasPerHost"`
+}
+
+type CapacityLimit string
+
+const (
+	CapacityLimitNone       = CapacityLimit("")
+	CapacityLimitNoHosts    = CapacityLimit("noHosts")
+	CapacityLimitSpace      = CapacityLimit("space")
+	CapacityLimitReplicaCap = CapacityLimit("replicaCap")
+	// the simulation stopped after too many placements
+	CapacityLimitIterations = CapacityLimit("iterations")
+)
+
+type CapacityCheckResult struct {
+	Requested int           `json:"requested"`
+	Fits      int           `json:"fits"`
+	Limit     CapacityLimit `json:"limit"`
+	// the hosts out of space or at the replica cap for the next volume
+	LimitingHosts []string `json:"limitingHosts"`
+	// the hosts not considered, by ID, with the reason
+	ExcludedHosts map[string]string `json:"excludedHosts"`
 }
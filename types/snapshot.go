@@ -0,0 +1,14 @@
+package types
+
+import (
+	"fmt"
+)
+
+type ErrSnapshotExists struct {
+	VolumeName   string
+	SnapshotName string
+}
+
+func (e *ErrSnapshotExists) Error() string {
+	return fmt.Sprintf("snapshot %v of volume %v already exists", e.SnapshotName, e.VolumeName)
+}
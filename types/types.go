@@ -1,8 +1,12 @@
 package types
 
 import (
+	"context"
+	"crypto/tls"
 	"io"
 	"time"
+
+	"github.com/rancher/longhorn-manager/util/runner"
 )
 
 type VolumeState string
@@ -10,6 +14,7 @@ type VolumeState string
 const (
 	VolumeStateNone     = VolumeState("")
 	VolumeStateCreated  = VolumeState("created")
+	VolumeStateCreating = VolumeState("creating")
 	VolumeStateDetached = VolumeState("detached")
 	VolumeStateFaulted  = VolumeState("faulted")
 	VolumeStateHealthy  = VolumeState("healthy")
@@ -24,6 +29,111 @@ const (
 	ReplicaModeERR = ReplicaMode("ERR")
 )
 
+type RehomePolicy string
+
+const (
+	RehomePolicyNone     = RehomePolicy("")
+	RehomePolicyReattach = RehomePolicy("reattach")
+	RehomePolicyWindow   = RehomePolicy("window")
+)
+
+type AutoReattachPolicy string
+
+const (
+	AutoReattachPolicyDefault  = AutoReattachPolicy("")
+	AutoReattachPolicyDisabled = AutoReattachPolicy("disabled")
+	AutoReattachPolicyAlways   = AutoReattachPolicy("always")
+	AutoReattachPolicyIfClean  = AutoReattachPolicy("if-clean")
+)
+
+type DataIntegrity string
+
+const (
+	DataIntegrityDefault   = DataIntegrity("")
+	DataIntegrityDisabled  = DataIntegrity("disabled")
+	DataIntegrityFastCheck = DataIntegrity("fast-check")
+	DataIntegrityFull      = DataIntegrity("full")
+)
+
+// ReplicaBacking is where the replicas of a volume keep their data: a file
+// of the container volume, or a block device of the host
+type ReplicaBacking string
+
+const (
+	ReplicaBackingDefault = ReplicaBacking("")
+	ReplicaBackingFile    = ReplicaBacking("file")
+	ReplicaBackingBlock   = ReplicaBacking("block")
+)
+
+type CacheMode string
+
+const (
+	CacheModeDefault      = CacheMode("")
+	CacheModeWriteThrough = CacheMode("writethrough")
+	CacheModeWriteBack    = CacheMode("writeback")
+
+	CacheModeWriteBackWarning = "writeback cache may lose the acknowledged writes on power failure"
+)
+
+// FsType is the file system the device of a new volume is formatted with
+type FsType string
+
+const (
+	FsTypeRaw  = FsType("")
+	FsTypeExt4 = FsType("ext4")
+	FsTypeXFS  = FsType("xfs")
+)
+
+// VolumeMode only changes by the conversion of the volume
+type VolumeMode string
+
+const (
+	VolumeModeDefault    = VolumeMode("")
+	VolumeModeReplicated = VolumeMode("replicated")
+	// VolumeModeLocal has a single replica pinned with the controller to
+	// one host. The replica is never rebuilt, the volume faults if it fails.
+	VolumeModeLocal = VolumeMode("local")
+)
+
+// RestartPolicy of the instance containers when the docker daemon restarts
+type RestartPolicy string
+
+const (
+	RestartPolicyDefault       = RestartPolicy("")
+	RestartPolicyNo            = RestartPolicy("no")
+	RestartPolicyUnlessStopped = RestartPolicy("unless-stopped")
+	RestartPolicyAlways        = RestartPolicy("always")
+)
+
+type SnapshotPruneStrategy string
+
+const (
+	SnapshotPruneDefault      = SnapshotPruneStrategy("")
+	SnapshotPruneBeforeCreate = SnapshotPruneStrategy("before-create")
+	SnapshotPruneAfterCreate  = SnapshotPruneStrategy("after-create")
+)
+
+// BackupCompression is the algorithm compressing the blocks of a backup
+type BackupCompression string
+
+const (
+	BackupCompressionDefault = BackupCompression("")
+	BackupCompressionNone    = BackupCompression("none")
+	BackupCompressionLZ4     = BackupCompression("lz4")
+	BackupCompressionGzip    = BackupCompression("gzip")
+)
+
+var BackupCompressions = []BackupCompression{BackupCompressionNone, BackupCompressionLZ4, BackupCompressionGzip}
+
+func (c BackupCompression) Valid() bool {
+	for _, compression := range BackupCompressions {
+		if c == compression {
+			return true
+		}
+	}
+	return false
+}
+
 type InstanceType string
 
 const (
@@ -32,28 +142,206 @@ const (
 	InstanceTypeReplica    = InstanceType("replica")
 )
 
+func (t InstanceType) Valid() bool {
+	return t == InstanceTypeController || t == InstanceTypeReplica
+}
+
 type VolumeManager interface {
 	Start() error
 	Create(volume *VolumeInfo) (*VolumeInfo, error)
 	Delete(name string) error
+	// AbortVolumeCreation removes what was created of a volume still being
+	// created, so the creation can be retried
+	AbortVolumeCreation(name string) error
 	Get(name string) (*VolumeInfo, error)
 	List() ([]*VolumeInfo, error)
+	// ListPage lists at most limit volumes named after the given name, in
+	// name order, with the name to list the next page after, empty after
+	// the last page
+	ListPage(after string, limit int) ([]*VolumeInfo, string, error)
+	ListVolumesFiltered(filter VolumeFilter) ([]*VolumeInfo, error)
+	// ListVolumesUsingImage lists the volumes with a container created
+	// from the engine image on any host, the image can be retired once
+	// none is listed
+	ListVolumesUsingImage(image string) ([]*VolumeInfo, error)
 	Attach(name string) error
+	AttachWithEnv(name string, env map[string]string) error
+	// PickAttachHost returns the first of the preferred hosts which can
+	// attach the volume, "" if none can
+	PickAttachHost(name string, preferredHostIDs []string) (string, error)
+	// AttachPreferred attaches the volume on the current host, recording
+	// which of the preferred hosts it is
+	AttachPreferred(name string, env map[string]string, preferredHostIDs []string) error
 	Detach(name string) error
 	UpdateRecurring(name string, jobs []*RecurringJob) error
+	UpdatePreferredHost(name, hostID string, policy RehomePolicy) error
+	UpdatePinReplicas(name string, pinned bool) error
+	UpdateEngineVersionConstraint(name, constraint string) error
+
+	ListSettingsDefinitions() ([]SettingDefinition, error)
+	UpdateAutoReattach(name string, policy AutoReattachPolicy) error
+	UpdateBackupCompression(name string, compression BackupCompression) error
+	ControllerFailed(name string) error
+	// ControllerRecreateThreshold is how long a controller may stay
+	// unresponsive before it's failed and recreated, 0 to fail it at once
+	ControllerRecreateThreshold() time.Duration
+	Salvage(name string, replicaNames []string) error
+	ReplanReplicas(name string) error
+	// ConvertVolume converts the volume to mode, replicas is the number of
+	// replicas of a replicated volume, 0 for the default
+	ConvertVolume(name string, mode VolumeMode, replicas int) error
+	// MigrateController moves the controller of the attached volume to the
+	// current host, rolling back to the old one if it fails. The migration
+	// is recorded on the volume either way.
+	MigrateController(name string) (*ControllerMigration, error)
+	// CompactSnapshots coalesces the system snapshots of a detached volume
+	CompactSnapshots(name string) error
+	// CancelRebuild tears down the replica being rebuilt, the controller
+	// must be on the current host
+	CancelRebuild(name string) error
+	// CreateStandbyReplica and PromoteStandbyReplica manage the warm-standby
+	// replicas, the controller must be on the current host
+	CreateStandbyReplica(volumeName string) (*ReplicaInfo, error)
+	PromoteStandbyReplica(volumeName, replicaName string) error
+
+	CreateVolumeGroup(group *VolumeGroup) (*VolumeGroup, error)
+	GetVolumeGroup(name string) (*VolumeGroup, error) // nil if not found
+	ListVolumeGroups() (map[string]*VolumeGroup, error)
+	UpdateVolumeGroupVolumes(name string, add, remove []string) error
+	UpdateVolumeGroupSelector(name string, selector map[string]string) error
+	VolumeGroupStatus(name string) (*VolumeGroupStatus, error)
+	// the operations of a volume group report the result of each member
+	AttachVolumeGroup(name, hostID string, hosts map[string]string) ([]*VolumeGroupResult, error)
+	DetachVolumeGroup(name string) ([]*VolumeGroupResult, error)
+	SnapshotVolumeGroup(name, snapshotName string) (string, []*VolumeGroupResult, error)
+	BackupVolumeGroup(name, snapshotName string) (string, []*VolumeGroupResult, error)
+	DeleteVolumeGroup(name string, deleteVolumes bool, confirm string) ([]*VolumeGroupResult, error)
 	ReplicaRemove(volumeName, replicaName string) error
+	UpdateControllerReplicas(volumeName string, desired []*ReplicaInfo) error
+	GetReplicaDiskUsage(volumeName, replicaName string) (*DiskUsage, error)
+	EngineStatus(volumeName, instanceName string) (*EngineStatus, error) // instance on the current host only
 
 	ListHosts() (map[string]*HostInfo, error)
 	GetHost(id string) (*HostInfo, error)
+	GetHosts(ids []string) (map[string]*HostInfo, error) // has all the IDs, nil for hosts not found
+	DeleteHost(id string, force bool) error
+	// DrainHost starts the drain of the host in the background and returns
+	// its progress so far, 0 for no deadline
+	DrainHost(id string, deadline time.Duration) (*DrainProgress, error)
+	GetDrainProgress(id string) (*DrainProgress, error) // nil if the host was never drained
+	// MigrateReplica replaces the replica with one scheduled elsewhere, the
+	// controller must be on the current host
+	MigrateReplica(volumeName, replicaName string) error
+	// EvacuateCurrentHost moves everything off the current host and
+	// deregisters it, before the manager exits for good
+	EvacuateCurrentHost(ctx context.Context) error
+	// SmokeTest provisions, attaches, writes and reads, and removes a
+	// temporary volume on the current host
+	SmokeTest(ctx context.Context) error
+	UpdateHostSchedulable(id string, schedulable bool) error
+	UpdateHostFailureDomain(id, domain string) error
+	UpdateHostSchedulingWeight(id string, weight int) error
+	UpdateHostRole(id string, role HostRole) error
+	// StopAllInstancesOnHost and StartAllInstancesOnHost stop and start
+	// the instances of the volumes on the host, the controllers first on
+	// stop and last on start
+	StopAllInstancesOnHost(id string) error
+	StartAllInstancesOnHost(id string) error
+	// ScheduleMaintenance plans a maintenance window of the host, replacing
+	// the last one done
+	ScheduleMaintenance(id string, window *MaintenanceWindow) (*MaintenanceWindow, error)
+	CancelMaintenance(id string) error            // only before the window starts
+	HostDetails(hosts map[string]*HostInfo) error // fills in Detail of the hosts
+	ResolveHostConflict(id, nonce string) error   // the machine with nonce registers again
+	ListLocalInstances() ([]*LocalInstance, error)
+	ListLocks() ([]*LockInfo, error)
+	// BreakLock revokes the lock, confirm is the operation ID of the holder
+	BreakLock(name, confirm, author string) (*LockInfo, error)
+	// ListVolumeRawRecords returns the records of the volume in the store,
+	// indented and without secrets
+	ListVolumeRawRecords(name string) ([]*RawRecord, error)
+	// SetVolumeRawRecord fails unless raw editing is enabled
+	SetVolumeRawRecord(name string, record *RawRecord, author string) error
+	AuditConsistency() (*ConsistencyReport, error) // read-only
+	WriterReport() (*WriterReport, error)
+
+	// CreateAPIToken returns the token with its secret, which cannot be read
+	// again
+	CreateAPIToken(name string, role APIRole, namespace string) (*APIToken, string, error)
+	ListAPITokens() ([]*APIToken, error)
+	RevokeAPIToken(id string) error
+	AuthenticateAPIToken(secret string) (*APIToken, error) // nil if unknown
+
+	// Bootstrap converges toward the state of the bootstrap file, unless
+	// another manager is at it. It returns nil then.
+	Bootstrap(b *Bootstrap) (*BootstrapReport, error)
+	BootstrapReport() (*BootstrapReport, error) // nil if there was none
+	CapacityCheck(check *CapacityCheck) (*CapacityCheckResult, error)
+
+	SchedulerStatus() (*SchedulerStatus, error)
+	ProbeHost(id string) (bool, error) // if the manager of the host can be reached from here
 
 	CheckController(ctrl Controller, volume *VolumeInfo) error
 	Cleanup(volume *VolumeInfo) error
+	// the periodic checks do nothing while paused
+	PauseReconcile()
+	ResumeReconcile()
+	IsReconcilePaused() bool
+
+	// the events are buffered and written in batches, recent ones may not
+	// be listed yet
+	ListVolumeEvents(name string) ([]*VolumeEvent, error)
+	EventRecorderStatus() *EventRecorderStatus
+	// GetVolumeHistory returns the latest limit state transitions of the
+	// volume, all of those kept if limit is 0, oldest first
+	GetVolumeHistory(volumeName string, limit int) ([]StateTransition, error)
+	// KeepVolumeEvents and KeepVolumeState exempt a batch of events or a
+	// state transition from the limit of its list and the record GC
+	KeepVolumeEvents(name, batch string, keep bool) error
+	KeepVolumeState(name string, seq int64, keep bool) error
+	VolumeEventCounts(name string) (*RecordCounts, error)
+	VolumeHistoryCounts(name string) (*RecordCounts, error)
+	// StartBackup queues the backup task on the controller of the volume,
+	// with its read strategy picked
+	StartBackup(volumeName string, task *BackupBgTask) error
+	BackupReadStats() *BackupReadStats
+	// Metrics are exported on /metrics, the volume metrics are refreshed
+	// in the background
+	Metrics() []*MetricFamily
+	// ServerTLSConfig and ClientTLSConfig serve and reach the other managers
+	// with the certificate of the current host, nil without internal TLS
+	ServerTLSConfig() *tls.Config
+	ClientTLSConfig() *tls.Config
+	TLSStatus() *TLSStatus
+	// RotateClusterCA replaces the cluster CA, the previous one is trusted
+	// for a transition window
+	RotateClusterCA() error
+	// GetEffectiveConfig reports the config of the orchestrator and the
+	// manager resolved from the flags, without secrets
+	GetEffectiveConfig() (*RuntimeConfig, error)
+	// RenderInstance returns what attaching the volume would create for its
+	// controller, or adding a replica for the replica, on the current host
+	RenderInstance(instanceType InstanceType, volumeName string) (*InstanceRender, error)
+	// SlowOperations lists the latest operations of the manager slower
+	// than the slow operation threshold, with their traces
+	SlowOperations() []*SlowOperation
+	// RunnerStatus reports the background loops of the manager
+	RunnerStatus() []*runner.Status
+	// Shutdown stops the background loops and writes the buffered events,
+	// to be called before exiting
+	Shutdown()
 
 	Controller(name string) (Controller, error)
 	SnapshotOps(name string) (SnapshotOps, error)
 	VolumeBackupOps(name string) (VolumeBackupOps, error)
 	Settings() Settings
 	ManagerBackupOps(backupTarget string) ManagerBackupOps
+	DeleteBackup(backupTarget, volumeName, backupName string, removeVolume bool) error
+	// VolumeBackupTarget resolves the backup target setting for the volume
+	VolumeBackupTarget(volumeName string) (string, error)
+	// GarbageCollectBackupTarget removes the blocks no backup references
+	// from the target, the backupTarget setting if empty
+	GarbageCollectBackupTarget(target string) (*GCResult, error)
 
 	ProcessSchedule(spec *ScheduleSpec, item *ScheduleItem) (*InstanceInfo, error)
 }
@@ -61,6 +349,59 @@ type VolumeManager interface {
 type Settings interface {
 	GetSettings() (*SettingsInfo, error)
 	SetSettings(*SettingsInfo) error
+	// UpdateSettings applies update to the latest settings and records the
+	// change in the history atomically
+	UpdateSettings(author string, update func(*SettingsInfo) error) error
+	GetSettingsHistory() ([]*SettingsRevision, error) // oldest first
+
+	// PruneSettingsHistory removes the revisions expired and returns them,
+	// never those kept nor the latest one
+	PruneSettingsHistory(expired func(revision *SettingsRevision) bool) ([]*SettingsRevision, error)
+	KeepSettingsRevision(revision int64, keep bool) error
+	SettingsHistoryCounts() (*RecordCounts, error)
+}
+
+type SettingsRevision struct {
+	Revision int64        `json:"revision"`
+	Author   string       `json:"author"`
+	Time     string       `json:"time"`
+	Previous SettingsInfo `json:"previous"`
+	Settings SettingsInfo `json:"settings"`
+	Writer   *WriterInfo  `json:"writer,omitempty"`
+	// Keep exempts the revision from the record GC
+	Keep bool `json:"keep,omitempty"`
+}
+
+// WriterInfo stamps a record with the manager which last wrote it
+type WriterInfo struct {
+	Version string `json:"version"`
+	HostID  string `json:"hostId"`
+}
+
+// WriterVersionUnknown counts the records without a stamp, written before
+// the stamps or by a test store
+const WriterVersionUnknown = "unknown"
+
+// WriterReport counts the records by the version of the manager which last
+// wrote them, all of them with a single version once an upgrade converged
+type WriterReport struct {
+	Records  int                       `json:"records"`
+	Versions map[string]int            `json:"versions"`
+	Kinds    map[string]map[string]int `json:"kinds"` // the versions by kind of record
+}
+
+// Add counts a record of the kind
+func (r *WriterReport) Add(kind string, writer *WriterInfo) {
+	version := WriterVersionUnknown
+	if writer != nil && writer.Version != "" {
+		version = writer.Version
+	}
+	r.Records++
+	r.Versions[version]++
+	if r.Kinds[kind] == nil {
+		r.Kinds[kind] = map[string]int{}
+	}
+	r.Kinds[kind][version]++
 }
 
 type SnapshotOps interface {
@@ -73,8 +414,7 @@ type SnapshotOps interface {
 }
 
 type VolumeBackupOps interface {
-	StartBackup(snapName, backupTarget string) error
-	Restore(backup string) error
+	Restore(backup string, compression BackupCompression) error
 	DeleteBackup(backup string) error
 }
 
@@ -83,10 +423,11 @@ type GetManagerBackupOps func(backupTarget string) ManagerBackupOps
 type ManagerBackupOps interface {
 	List(volumeName string) ([]*BackupInfo, error)
 	Get(url string) (*BackupInfo, error)
-	Delete(url string) error
+	Delete(url string) error // nil if the backup is already gone
 
 	ListVolumes() ([]*BackupVolumeInfo, error)
 	GetVolume(volumeName string) (*BackupVolumeInfo, error)
+	DeleteVolume(volumeName string) error // removes the volume directory
 }
 
 type Event interface{}
@@ -112,6 +453,15 @@ type Controller interface {
 
 	SnapshotOps() SnapshotOps
 	BackupOps() VolumeBackupOps
+
+	Stats() (*VolumeStats, error)
+}
+
+// VolumeStats are the I/O counters of the frontend device of the volume
+type VolumeStats struct {
+	ReadOps  int64
+	WriteOps int64
+	Mounted  bool
 }
 
 type Orchestrator interface {
@@ -119,23 +469,92 @@ type Orchestrator interface {
 	DeleteVolume(volumeName string) error                 // removes volume metadata
 	GetVolume(volumeName string) (*VolumeInfo, error)     // For non-existing volume, return (nil, nil)
 	ListVolumes() ([]*VolumeInfo, error)
+	// ForEachVolume calls fn with each volume named after the given name,
+	// in name order, until fn returns an error, without holding all the
+	// volumes at once
+	ForEachVolume(after string, fn func(volume *VolumeInfo) error) error
 	MarkBadReplica(volumeName string, replica *ReplicaInfo) error // find replica by Address
 	UpdateVolume(volume *VolumeInfo) error
+	// UpdateVolumeBase applies update to the volume without its instances,
+	// retrying if the volume is modified concurrently, and returns the
+	// volume written
+	UpdateVolumeBase(volumeName string, update func(volume *VolumeInfo) error) (*VolumeInfo, error)
 
 	CreateController(volumeName, controllerName string, replicas map[string]*ReplicaInfo) (*ControllerInfo, error)
 	CreateReplica(volumeName, replicaName string) (*ReplicaInfo, error)
+	// RenderController and RenderReplica return what CreateController and
+	// CreateReplica would create on the current host, without creating it
+	RenderController(volumeName, controllerName string, replicas map[string]*ReplicaInfo) (*InstanceRender, error)
+	RenderReplica(volumeName, replicaName string) (*InstanceRender, error)
+	// RestartController starts the stopped controller of the volume on the
+	// current host again as it was created, without touching its replicas.
+	// It returns why not instead if the controller is to be recreated, e.g.
+	// it's gone or differs from what CreateController would create now.
+	RestartController(volume *VolumeInfo) (*ControllerInfo, string, error)
+	// CreateSnapshot snapshots the volume through its running controller,
+	// and fails with ErrSnapshotExists if the volume has a snapshot of that
+	// name already
+	CreateSnapshot(volumeName, snapshotName string) (*SnapshotInfo, error)
+	// ListSnapshots lists the snapshots of the volume oldest first, those of
+	// its running controller, or those recorded if it has none
+	ListSnapshots(volumeName string) ([]*SnapshotInfo, error)
+	// DeleteSnapshot removes the snapshot through the running controller
+	// of the volume, except the one the volume head is on
+	DeleteSnapshot(volumeName, snapshotName string) error
 
 	StartInstance(instance *InstanceInfo) (*InstanceInfo, error)
 	StopInstance(instance *InstanceInfo) (*InstanceInfo, error)
 	RemoveInstance(instance *InstanceInfo) (*InstanceInfo, error)
+	ForgetInstance(instance *InstanceInfo) error          // removes instance metadata only, for instances on lost hosts
+	ReplicaDataPath(replica *ReplicaInfo) (string, error) // replica on the current host only
+	ListLocalControllers() ([]*LocalController, error)
+	ListLocalInstances() ([]*LocalInstance, error)
+	RemoveLocalController(id string) error // stops and removes the container only, not the metadata
+	// GetImageID resolves the image on the current host, empty if it isn't
+	// there
+	GetImageID(image string) (string, error)
 
 	ListHosts() (map[string]*HostInfo, error)
 	GetHost(id string) (*HostInfo, error)
+	GetHosts(ids []string) (map[string]*HostInfo, error) // has all the IDs, nil for hosts not found
+	DeleteHost(id string) error
+	Heartbeat() error // records the clock of the current host
+	// Deregister removes the record of the current host, the heartbeats
+	// must be stopped first
+	Deregister() error
+	SetHostSchedulable(id string, schedulable bool) error
+	SetHostFailureDomain(id, domain string) error
+	SetHostSchedulingWeight(id string, weight int) error
+	SetHostRole(id string, role HostRole) error
+	SetHostMaintenance(id string, window *MaintenanceWindow) error
+	SetHostRegenerateNonce(id, nonce string) error
 
 	Scheduler() Scheduler // return nil if not supported
+	GetEffectiveConfig() (*RuntimeConfig, error)
 
 	ServiceLocator
 	Settings
+	StateRevisioner
+	EventStore
+	HistoryStore
+	LockStore
+	RawStore
+	CertStore
+	TokenStore
+	VolumeGroupStore
+	BootstrapStore
+	DrainStore
+}
+
+// InstanceRender is what the orchestrator would create for an instance
+type InstanceRender struct {
+	Orchestrator string       `json:"orchestrator"`
+	HostID       string       `json:"hostId"`
+	VolumeName   string       `json:"volumeName"`
+	InstanceName string       `json:"instanceName"`
+	InstanceType InstanceType `json:"instanceType"`
+	// Payload is the request of the API of the orchestrator creating it
+	Payload interface{} `json:"payload"`
 }
 
 type ServiceLocator interface {
@@ -143,9 +562,89 @@ type ServiceLocator interface {
 	GetAddress(hostID string) (string, error) // Return <host>:<port>
 }
 
+// StateRevisioner reports the revision of the state stored under a key, e.g.
+// "volumes" or "volumes/<name>". The revision changes with every write.
+type StateRevisioner interface {
+	StateRevision(key string) (string, error)
+	// StateStaleSince is when the reads of the state started being served
+	// from a cache, zero if they are fresh
+	StateStaleSince() time.Time
+}
+
 type SettingsInfo struct {
-	BackupTarget string `json:"backupTarget" mapstructure:"backupTarget"`
-	EngineImage  string `json:"engineImage" mapstructure:"engineImage"`
+	BackupTarget     string `json:"backupTarget" mapstructure:"backupTarget"`
+	EngineImage      string `json:"engineImage" mapstructure:"engineImage"`
+	RehomeWindow     string `json:"rehomeWindow" mapstructure:"rehomeWindow"`
+	RehomeWindowLive bool   `json:"rehomeWindowLive" mapstructure:"rehomeWindowLive"`
+
+	AutoReattach AutoReattachPolicy `json:"autoReattach" mapstructure:"autoReattach"`
+
+	SnapshotMaxCount      int                   `json:"snapshotMaxCount" mapstructure:"snapshotMaxCount"`
+	SnapshotMaxAge        string                `json:"snapshotMaxAge" mapstructure:"snapshotMaxAge"`
+	SnapshotPruneStrategy SnapshotPruneStrategy `json:"snapshotPruneStrategy" mapstructure:"snapshotPruneStrategy"`
+
+	ClockSkewThreshold string `json:"clockSkewThreshold" mapstructure:"clockSkewThreshold"`
+
+	// detach the volumes without I/O and not mounted for AutoDetachTimeout
+	AutoDetach        bool   `json:"autoDetach" mapstructure:"autoDetach"`
+	AutoDetachTimeout string `json:"autoDetachTimeout" mapstructure:"autoDetachTimeout"`
+
+	// default unless-stopped for replicas and no for controllers
+	ReplicaRestartPolicy    RestartPolicy `json:"replicaRestartPolicy" mapstructure:"replicaRestartPolicy"`
+	ControllerRestartPolicy RestartPolicy `json:"controllerRestartPolicy" mapstructure:"controllerRestartPolicy"`
+
+	// mark bad the replicas allocating more than the volume size plus
+	// ReplicaQuotaOverhead percent of it
+	ReplicaQuota         bool `json:"replicaQuota" mapstructure:"replicaQuota"`
+	ReplicaQuotaOverhead int  `json:"replicaQuotaOverhead" mapstructure:"replicaQuotaOverhead"`
+
+	// environment variables of the new controllers and replicas
+	InstanceEnv map[string]string `json:"instanceEnv" mapstructure:"instanceEnv"`
+
+	// recreate the controller unresponsive for ControllerUnresponsiveThreshold
+	// on the same host, if its replicas are healthy
+	ControllerRecreate              bool   `json:"controllerRecreate" mapstructure:"controllerRecreate"`
+	ControllerUnresponsiveThreshold string `json:"controllerUnresponsiveThreshold" mapstructure:"controllerUnresponsiveThreshold"`
+
+	// compression of the backups, unless set on the volume. Default lz4
+	BackupCompression BackupCompression `json:"backupCompression" mapstructure:"backupCompression"`
+
+	// the container healthcheck of the instances whose engine can be pinged
+	HealthcheckInterval string `json:"healthcheckInterval" mapstructure:"healthcheckInterval"`
+	HealthcheckRetries  int    `json:"healthcheckRetries" mapstructure:"healthcheckRetries"`
+
+	// backups running at the same time in the cluster, and to each backup
+	// target, the others wait for their turn. 0 for unlimited
+	BackupConcurrencyLimit       int `json:"backupConcurrencyLimit" mapstructure:"backupConcurrencyLimit"`
+	BackupTargetConcurrencyLimit int `json:"backupTargetConcurrencyLimit" mapstructure:"backupTargetConcurrencyLimit"`
+
+	// the record GC removes the events, state transitions and settings
+	// revisions older than these, the failed ones are kept longer
+	RecordRetentionSucceeded string `json:"recordRetentionSucceeded" mapstructure:"recordRetentionSucceeded"`
+	RecordRetentionFailed    string `json:"recordRetentionFailed" mapstructure:"recordRetentionFailed"`
+}
+
+type SettingType string
+
+const (
+	SettingTypeString     = SettingType("string")
+	SettingTypeBool       = SettingType("bool")
+	SettingTypeInt        = SettingType("int")
+	SettingTypeDuration   = SettingType("duration")
+	SettingTypeEnum       = SettingType("enum")
+	SettingTypeTimeWindow = SettingType("timeWindow")
+	SettingTypeMap        = SettingType("map") // a JSON object of strings
+)
+
+// SettingDefinition describes a field of SettingsInfo, with the values in
+// the string form used by the API
+type SettingDefinition struct {
+	Name        string      `json:"name"`
+	Type        SettingType `json:"type"`
+	Default     string      `json:"default"` // effective value when the setting is empty
+	Description string      `json:"description"`
+	Options     []string    `json:"options,omitempty"`    // the values of an enum
+	Validation  string      `json:"validation,omitempty"` // the constraints beyond the type
 }
 
 type VolumeInfo struct {
@@ -159,9 +658,327 @@ type VolumeInfo struct {
 	Replicas            map[string]*ReplicaInfo //key is replicaName
 	State               VolumeState
 	EngineImage         string
+	Mode                VolumeMode
 	Endpoint            string
 	Created             string
 	RecurringJobs       []*RecurringJob
+	DataIntegrity       DataIntegrity
+	CacheMode           CacheMode
+	SnapshotMaxCount    int
+	SnapshotMaxAge      time.Duration
+
+	// ReplicaBacking of the replicas, the block devices are taken from the
+	// ones configured on the hosts of the replicas
+	ReplicaBacking ReplicaBacking
+
+	// EngineVersionConstraint is a semver range the version of EngineImage
+	// must be in, e.g. ">=0.3.0 <0.5.0" or "^0.3"
+	EngineVersionConstraint string
+
+	// PinReplicas keeps replicas from being migrated for anything other
+	// than replacing failed replicas
+	PinReplicas bool
+
+	// MaxReplicasPerZone and MinZones constrain how the replicas are
+	// distributed over the failure domains, 0 for no constraint
+	MaxReplicasPerZone int
+	MinZones           int
+
+	PreferredHostID     string
+	PreferredHostPinned bool
+	AttachCounts        map[string]int
+	RehomePolicy        RehomePolicy
+	RehomePending       bool
+	AttachHistory       []*AttachRecord
+
+	AutoReattach    AutoReattachPolicy
+	SalvageRequired bool
+	SalvageReason   string
+
+	// Generation of the controller ownership, bumped when the controller
+	// is failed over from a fenced host
+	Generation int64
+
+	// Standby volume tracks the backup FromBackup, which cannot be deleted
+	// while the volume exists
+	Standby bool
+
+	// Labels are set on create, the backup target may refer to them
+	Labels map[string]string
+
+	// BackupCompression overrides the backupCompression setting
+	BackupCompression BackupCompression
+
+	// ScheduleOnCreate places the replicas on create, the replicas are only
+	// created on the first attach following ReplicaPlan
+	ScheduleOnCreate bool
+	ReplicaPlan      *ReplicaPlan
+
+	// InstanceEnv is added to the instanceEnv setting for the instances of
+	// the volume, and takes precedence
+	InstanceEnv map[string]string
+	// AttachEnv is the override of InstanceEnv given by the last attach
+	// request, for the instances created while the volume is attached
+	AttachEnv map[string]string
+
+	// Conversion is the change of Mode in progress, nil if none
+	Conversion *VolumeConversion
+
+	// Migration is the last move of the controller to another host while
+	// attached, in progress or done
+	Migration *ControllerMigration
+
+	// FsType is formatted on the device by the first attach, unless the
+	// device has a file system already. FsInitialized is set once done.
+	FsType        FsType
+	FsInitialized bool
+
+	// RebuildFailures counts the stuck or cancelled rebuilds since the last
+	// good one, the next rebuild avoids their hosts. RebuildCondition is set
+	// once they exceed the retries, no replica is rebuilt until reattach.
+	RebuildFailures      int
+	RebuildExcludedHosts []string
+	RebuildCondition     string
+
+	// StandbyReplicas are the replicas kept out of the controller as warm
+	// standbys, by replica name. They're synced from a snapshot of the
+	// volume now and then, and promoted to replace a failed replica.
+	StandbyReplicas map[string]*StandbyReplica
+
+	// Conditions are the conditions of the volume not met, e.g. Scheduled
+	// while its replicas cannot be placed
+	Conditions []*VolumeCondition
+
+	// Creating is set until all the replicas of the volume are created, the
+	// creation can be aborted until then
+	Creating bool
+
+	// Writer is the manager which last wrote the volume, for debugging
+	Writer *WriterInfo `json:",omitempty"`
+}
+
+// StandbyAddresses returns the addresses of the standby replicas known
+func (v *VolumeInfo) StandbyAddresses() map[string]bool {
+	addrs := map[string]bool{}
+	for name := range v.StandbyReplicas {
+		if replica := v.Replicas[name]; replica != nil && replica.Address != "" {
+			addrs[replica.Address] = true
+		}
+	}
+	return addrs
+}
+
+// StandbyReplica is a warm-standby replica of a volume
+type StandbyReplica struct {
+	// Synced is when the replica was last synced, empty if never
+	Synced string `json:"synced,omitempty"`
+}
+
+// VolumeConversion changes the mode of a volume. It progresses with the
+// checks of the controller, so a conversion of an attached volume stops on
+// detach and resumes on the next attach.
+type VolumeConversion struct {
+	TargetMode VolumeMode `json:"targetMode"`
+	// TargetReplicas is the number of replicas of the volume converted,
+	// always 1 for local
+	TargetReplicas int    `json:"targetReplicas"`
+	Started        string `json:"started"`
+	Progress       string `json:"progress"`
+}
+
+type MigrationState string
+
+const (
+	MigrationStateRunning    = MigrationState("running")
+	MigrationStateSucceeded  = MigrationState("succeeded")
+	MigrationStateRolledBack = MigrationState("rolledBack")
+	// MigrationStateFailed is a migration which couldn't be rolled back
+	// either, the volume is left detached
+	MigrationStateFailed = MigrationState("failed")
+)
+
+// ControllerMigration moves the controller of an attached volume from the
+// source to the target host. The IO of the volume is paused from the stop
+// of the old controller until the new one, or the old one on rollback, has
+// its device up.
+type ControllerMigration struct {
+	SourceHostID string         `json:"sourceHostId"`
+	TargetHostID string         `json:"targetHostId"`
+	State        MigrationState `json:"state"`
+	Started      string         `json:"started"`
+	Finished     string         `json:"finished,omitempty"`
+	// PauseBudget is how long the IO may be paused, the migration is
+	// rolled back beyond it. Pause is how long it was, once finished.
+	PauseBudget string `json:"pauseBudget"`
+	Pause       string `json:"pause,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Converting is true if the volume is being converted to mode
+func (v *VolumeInfo) Converting(mode VolumeMode) bool {
+	return v.Conversion != nil && v.Conversion.TargetMode == mode
+}
+
+type VolumeConditionType string
+
+const (
+	// VolumeConditionScheduled is set while a replica of the volume cannot
+	// be placed, and cleared once one is
+	VolumeConditionScheduled = VolumeConditionType("Scheduled")
+)
+
+// VolumeCondition is a condition of the volume not met. The reasons and the
+// hosts are the constraints failed by each candidate host, the message is
+// made of them.
+type VolumeCondition struct {
+	Type    VolumeConditionType  `json:"type"`
+	Reasons []ScheduleConstraint `json:"reasons"`
+	Hosts   []*HostConstraints   `json:"hosts"`
+	Message string               `json:"message"`
+	Since   string               `json:"since"`
+	Updated string               `json:"updated"`
+}
+
+// Condition returns the condition of the type not met, nil if it's met
+func (v *VolumeInfo) Condition(t VolumeConditionType) *VolumeCondition {
+	for _, c := range v.Conditions {
+		if c.Type == t {
+			return c
+		}
+	}
+	return nil
+}
+
+// ReplicaPlan is where the replicas of a volume are to be created. The
+// volume size is reserved on the planned hosts until Expires.
+type ReplicaPlan struct {
+	Replicas   []*PlannedReplica `json:"replicas"`
+	Planned    string            `json:"planned"`
+	Expires    string            `json:"expires"`
+	Applied    string            `json:"applied,omitempty"`
+	Deviations []*PlanDeviation  `json:"deviations,omitempty"`
+}
+
+type PlannedReplica struct {
+	Name   string `json:"name"`
+	HostID string `json:"hostId"`
+}
+
+// PlanDeviation is a replica created elsewhere than planned, as the planned
+// host was no longer eligible
+type PlanDeviation struct {
+	Replica       string `json:"replica"`
+	PlannedHostID string `json:"plannedHostId"`
+	HostID        string `json:"hostId"`
+	Reason        string `json:"reason"`
+	Time          string `json:"time"`
+}
+
+// Pending is true if the replicas have not been created following the
+// plan yet
+func (p *ReplicaPlan) Pending() bool {
+	return p != nil && p.Applied == ""
+}
+
+// LocalController is a controller container found on the current host, it
+// may be no longer in the metadata
+type LocalController struct {
+	ID         string
+	VolumeName string
+	Generation int64
+}
+
+// LocalInstance is a controller or replica container found on the current
+// host
+type LocalInstance struct {
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	Type       InstanceType   `json:"type"`
+	VolumeName string         `json:"volumeName"`
+	Running    bool           `json:"running"`
+	Health     InstanceHealth `json:"health,omitempty"`
+	// Image is the engine image the container was created from, its ID if
+	// it was created from the ID or a digest
+	Image string `json:"image,omitempty"`
+	// ImageID is the ID of the image, whatever it was created from
+	ImageID string `json:"imageID,omitempty"`
+}
+
+type ConsistencyIssueType string
+
+const (
+	// the metadata references a container not found on its host
+	ConsistencyIssueMissingContainer = ConsistencyIssueType("missingContainer")
+	// a container of a volume has no metadata
+	ConsistencyIssueOrphanContainer = ConsistencyIssueType("orphanContainer")
+	// the metadata and the container disagree, e.g. on running
+	ConsistencyIssueStateMismatch = ConsistencyIssueType("stateMismatch")
+	// the metadata references a host not registered
+	ConsistencyIssueUnknownHost = ConsistencyIssueType("unknownHost")
+	// a schedule of the instance was abandoned after its deadline, what it
+	// did is unknown
+	ConsistencyIssueAbandonedSchedule = ConsistencyIssueType("abandonedSchedule")
+)
+
+type ConsistencyIssue struct {
+	Type         ConsistencyIssueType `json:"type"`
+	VolumeName   string               `json:"volumeName"`
+	InstanceID   string               `json:"instanceId"`
+	InstanceName string               `json:"instanceName"`
+	InstanceType InstanceType         `json:"instanceType"`
+	HostID       string               `json:"hostId"`
+	Detail       string               `json:"detail"`
+	// the manager which last wrote the instance metadata, if any
+	Writer *WriterInfo `json:"writer,omitempty"`
+}
+
+// ConsistencyReport compares the volume metadata with the containers on
+// the hosts. The hosts which couldn't be asked for their containers are
+// unchecked.
+type ConsistencyReport struct {
+	Volumes        int                 `json:"volumes"`
+	CheckedHosts   []string            `json:"checkedHosts"`
+	UncheckedHosts map[string]string   `json:"uncheckedHosts"` // host ID to the error
+	Issues         []*ConsistencyIssue `json:"issues"`
+}
+
+// DrainProgress is the progress of the drain of a host, kept in the store
+// under the host ID. The job ID is the operation ID of the lock the drain
+// holds.
+type DrainProgress struct {
+	JobID     string   `json:"jobId"`
+	HostID    string   `json:"hostId"`
+	DrainerID string   `json:"drainerId"` // the host running the drain
+	Total     int      `json:"total"`
+	Migrated  int      `json:"migrated"`
+	Pending   []string `json:"pending"` // replicas not migrated yet
+	Deadline  string   `json:"deadline,omitempty"`
+	Running   bool     `json:"running"`
+	// the drain stopped without finishing, its manager is gone
+	Interrupted bool `json:"interrupted,omitempty"`
+
+	// local volumes on the host, requiring manual migration
+	ManualMigration []string `json:"manualMigration,omitempty"`
+	// volumes with their replicas pinned, left on the host
+	Pinned []string `json:"pinned,omitempty"`
+}
+
+// DrainStore keeps the progress of the last drain of each host
+type DrainStore interface {
+	GetDrainProgress(hostID string) (*DrainProgress, error) // nil if the host was never drained
+	SetDrainProgress(progress *DrainProgress) error
+}
+
+type DiskUsage struct {
+	Path           string `json:"path"`
+	AllocatedBytes int64  `json:"allocatedBytes"`
+	ApparentBytes  int64  `json:"apparentBytes"`
+}
+
+type AttachRecord struct {
+	HostID string `json:"hostId"`
+	Time   string `json:"time"`
+	Reason string `json:"reason"`
 }
 
 type InstanceInfo struct {
@@ -172,8 +989,27 @@ type InstanceInfo struct {
 	Address    string
 	Running    bool
 	VolumeName string
+	// Env is the environment the instance was created with, NAME=value
+	Env []string
+	// BlockDevice is the host block device backing the data of a replica,
+	// empty for the file backing
+	BlockDevice string `json:",omitempty"`
+	// Health is the status of the container healthcheck at the last
+	// refresh, empty if the container has no healthcheck
+	Health InstanceHealth `json:",omitempty"`
+
+	Writer *WriterInfo `json:",omitempty"`
 }
 
+type InstanceHealth string
+
+const (
+	InstanceHealthNone      = InstanceHealth("")
+	InstanceHealthStarting  = InstanceHealth("starting")
+	InstanceHealthHealthy   = InstanceHealth("healthy")
+	InstanceHealthUnhealthy = InstanceHealth("unhealthy")
+)
+
 type ControllerInfo struct {
 	InstanceInfo
 }
@@ -183,6 +1019,10 @@ type ReplicaInfo struct {
 
 	Mode         ReplicaMode
 	BadTimestamp string
+	BadHostID    string `json:"badHostID,omitempty"` // host whose clock stamped BadTimestamp
+	// PreferredHostID is the host of the first placement, the replicas
+	// rebuilt in place of this one go back there if they can
+	PreferredHostID string `json:"preferredHostID,omitempty"`
 }
 
 type SnapshotInfo struct {
@@ -197,9 +1037,125 @@ type SnapshotInfo struct {
 }
 
 type HostInfo struct {
-	UUID    string `json:"uuid"`
-	Name    string `json:"name"`
-	Address string `json:"address"`
+	UUID      string `json:"uuid"`
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Heartbeat string `json:"heartbeat,omitempty"` // host clock at the last heartbeat
+
+	Unschedulable bool `json:"unschedulable,omitempty"`
+	// hosts sharing a rack or zone, replicas are spread across domains
+	FailureDomain string `json:"failureDomain,omitempty"`
+	// SchedulingWeight biases placement toward the host among the hosts of
+	// the same priority, 0 for the default of 1
+	SchedulingWeight int `json:"schedulingWeight,omitempty"`
+	// Role restricts the instances the host runs, any of them if empty
+	Role HostRole `json:"role,omitempty"`
+
+	// the filesystem of the host data directory at the last heartbeat
+	StorageTotal     int64 `json:"storageTotal,omitempty"`
+	StorageAvailable int64 `json:"storageAvailable,omitempty"`
+
+	// Nonce is regenerated on every start of the manager. Machines sharing
+	// the UUID keep overwriting each other's nonce, which marks the host
+	// conflicted with the nonces seen. The machine with RegenerateNonce
+	// generates a new UUID and registers again.
+	Nonce           string   `json:"nonce,omitempty"`
+	Conflicted      bool     `json:"conflicted,omitempty"`
+	ConflictNonces  []string `json:"conflictNonces,omitempty"`
+	RegenerateNonce string   `json:"regenerateNonce,omitempty"`
+
+	Maintenance *MaintenanceWindow `json:"maintenance,omitempty"`
+
+	// Recovered is when the host heartbeat again after it was down, by its
+	// own clock. It takes no new replicas for a cooldown after it.
+	Recovered string `json:"recovered,omitempty"`
+
+	Writer *WriterInfo `json:"writer,omitempty"`
+
+	// computed by the manager against its own clock, not stored
+	ClockSkew        time.Duration `json:"-"`
+	SkewDetected     bool          `json:"-"`
+	RecoveryCooldown time.Duration `json:"-"` // left of the cooldown
+
+	// computed by the manager on request, not stored
+	Detail *HostDetail `json:"-"`
+}
+
+// HostRole is what a host is reserved for in a mixed cluster
+type HostRole string
+
+const (
+	HostRoleAny = HostRole("")
+	// storage hosts only hold replicas, they don't attach volumes
+	HostRoleStorage = HostRole("storage")
+	// compute hosts only attach volumes, they never hold replicas
+	HostRoleCompute = HostRole("compute")
+)
+
+var HostRoles = []HostRole{
+	HostRoleAny,
+	HostRoleStorage,
+	HostRoleCompute,
+}
+
+func (r HostRole) Valid() bool {
+	for _, role := range HostRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RunsReplicas is true if replicas can be placed on the host
+func (r HostRole) RunsReplicas() bool {
+	return r != HostRoleCompute
+}
+
+// RunsControllers is true if volumes can be attached to the host
+func (r HostRole) RunsControllers() bool {
+	return r != HostRoleStorage
+}
+
+// Runs is true if instances of the type can be created on the host
+func (r HostRole) Runs(t InstanceType) bool {
+	switch t {
+	case InstanceTypeReplica:
+		return r.RunsReplicas()
+	case InstanceTypeController:
+		return r.RunsControllers()
+	}
+	return true
+}
+
+type HostCondition string
+
+const (
+	HostConditionOffline     = HostCondition("offline")
+	HostConditionCordoned    = HostCondition("cordoned")
+	HostConditionClockSkewed = HostCondition("clockSkewed")
+	HostConditionLowDisk     = HostCondition("lowDisk")
+	HostConditionConflicted  = HostCondition("conflicted")
+	HostConditionComputeOnly = HostCondition("computeOnly")
+	// the host is back from an outage for less than the cooldown
+	HostConditionRecovering = HostCondition("recovering")
+)
+
+// HostDetail summarizes the state of the host and what's placed on it
+type HostDetail struct {
+	Online      bool
+	Conditions  []HostCondition
+	Controllers int
+	Replicas    int
+	// sum of the sizes of the good replicas on the host, and of the replicas
+	// planned on it while the plan reserves
+	ReservedBytes int64
+	// the free space reported by the host, 0 if unknown
+	AvailableBytes int64
+	// if the host can take new replicas, or the conditions preventing it.
+	// Low disk is only a warning, the scheduler doesn't check the space.
+	Schedulable          bool
+	UnschedulableReasons []HostCondition
 }
 
 type BackupInfo struct {
@@ -212,6 +1168,9 @@ type BackupInfo struct {
 	VolumeName      string `json:"volumeName,omitempty"`
 	VolumeSize      string `json:"volumeSize,omitempty"`
 	VolumeCreated   string `json:"volumeCreated,omitempty"`
+	// CompressionMethod is the compression of the blocks of the backup,
+	// restored with the same
+	CompressionMethod string `json:"compressionMethod,omitempty"`
 }
 
 type TaskQueue interface {
@@ -230,11 +1189,52 @@ type BgTask struct {
 	Task      interface{} `json:"task"`
 }
 
+const (
+	BackupReadLocalReplica = "local-replica"
+	BackupReadController   = "controller"
+)
+
 type BackupBgTask struct {
 	Snapshot     string `json:"snapshot"`
 	BackupTarget string `json:"backupTarget"`
+	// ReadStrategy is where the backup reads the data from, the replica at
+	// ReplicaAddress on the host of the controller, or the controller
+	ReadStrategy   string `json:"readStrategy,omitempty"`
+	ReplicaAddress string `json:"replicaAddress,omitempty"`
+	// Compression of the blocks of the backup, the engine default if empty
+	Compression BackupCompression `json:"compression,omitempty"`
 
 	CleanupHook func() error `json:"-"`
+	// WaitTurn blocks until the backup may run, and returns the function
+	// to call once it's done
+	WaitTurn func() (func(), error) `json:"-"`
+}
+
+// BackupReadStats counts the backups started by the manager by read strategy
+type BackupReadStats struct {
+	LocalReplica int64 `json:"localReplica"`
+	Controller   int64 `json:"controller"`
+	// CrossHostBytesSaved estimates the data the backups read from a local
+	// replica would have pulled from the other hosts through the controller
+	CrossHostBytesSaved int64 `json:"crossHostBytesSaved"`
+}
+
+// GCResult is what the garbage collection of the blocks of a backup target
+// found and removed
+type GCResult struct {
+	BackupTarget     string `json:"backupTarget"`
+	Volumes          int    `json:"volumes"`
+	Backups          int    `json:"backups"`
+	Blocks           int    `json:"blocks"`
+	ReferencedBlocks int    `json:"referencedBlocks"`
+	// RecentBlocks are the orphaned blocks kept, a backup in progress may
+	// still reference them
+	RecentBlocks   int   `json:"recentBlocks"`
+	BytesReclaimed int64 `json:"bytesReclaimed"`
+	// RemovedBlocks are the paths of the blocks removed in the target
+	RemovedBlocks []string `json:"removedBlocks"`
+	// Errors are the volumes skipped, their blocks are left untouched
+	Errors []string `json:"errors"`
 }
 
 type BackupVolumeInfo struct {
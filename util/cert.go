@@ -0,0 +1,185 @@
+package util
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// certBackdate covers the clock skew between the hosts, a certificate
+	// just issued is valid on a host with its clock behind
+	certBackdate = 5 * time.Minute
+)
+
+// GenerateCA creates a self-signed CA certificate valid for validity, and
+// returns the certificate and its key in PEM
+func GenerateCA(commonName string, validity time.Duration) (string, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", errors.Wrap(err, "fail to generate CA key")
+	}
+	template, err := certTemplate(commonName, validity)
+	if err != nil {
+		return "", "", err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", errors.Wrap(err, "fail to create CA certificate")
+	}
+	return encodeCertAndKey(der, key)
+}
+
+// IssueCert creates a key and a certificate for the hosts, IPs or DNS names,
+// signed by the CA. The certificate is good for both servers and clients.
+func IssueCert(caCertPEM, caKeyPEM, commonName string, hosts []string, validity time.Duration) (string, string, error) {
+	caCert, err := ParseCertPEM(caCertPEM)
+	if err != nil {
+		return "", "", errors.Wrap(err, "invalid CA certificate")
+	}
+	caKey, err := parseKeyPEM(caKeyPEM)
+	if err != nil {
+		return "", "", errors.Wrap(err, "invalid CA key")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", errors.Wrap(err, "fail to generate key")
+	}
+	template, err := certTemplate(commonName, validity)
+	if err != nil {
+		return "", "", err
+	}
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return "", "", errors.Wrap(err, "fail to create certificate")
+	}
+	return encodeCertAndKey(der, key)
+}
+
+// ParseCertPEM parses the first certificate in certPEM
+func ParseCertPEM(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.Errorf("no certificate found in PEM")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseKeyPEM(keyPEM string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, errors.Errorf("no EC private key found in PEM")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func certTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to generate serial number")
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-certBackdate),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func encodeCertAndKey(der []byte, key *ecdsa.PrivateKey) (string, string, error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", errors.Wrap(err, "fail to marshal key")
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM), nil
+}
+
+// LoadSecretKey derives a key for SealKey from the secret in the file
+func LoadSecretKey(file string) ([]byte, error) {
+	secret, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to read key file %v", file)
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return nil, errors.Errorf("key file %v is empty", file)
+	}
+	key := sha256.Sum256(secret)
+	return key[:], nil
+}
+
+func keyCipher(secret []byte) (cipher.AEAD, error) {
+	if len(secret) == 0 {
+		return nil, errors.Errorf("no secret key to seal private keys with")
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid secret key")
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealKey encrypts the private key in PEM with AES-GCM under the secret key,
+// and returns it in base64, the nonce first
+func SealKey(secret []byte, keyPEM string) (string, error) {
+	gcm, err := keyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "fail to generate nonce")
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(keyPEM), nil)), nil
+}
+
+// OpenKey decrypts the private key sealed by SealKey under the same secret
+// key
+func OpenKey(secret []byte, sealed string) (string, error) {
+	gcm, err := keyCipher(secret)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", errors.Errorf("invalid sealed key")
+	}
+	keyPEM, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.Wrap(err, "fail to open sealed key, wrong secret key?")
+	}
+	return string(keyPEM), nil
+}
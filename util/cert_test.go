@@ -0,0 +1,46 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealKey(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "sealkey")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "secret")
+	assert.Nil(ioutil.WriteFile(file, []byte("secret\n"), 0600))
+	secret, err := LoadSecretKey(file)
+	assert.Nil(err)
+	assert.Len(secret, 32)
+	assert.Nil(ioutil.WriteFile(file, []byte("other"), 0600))
+	other, err := LoadSecretKey(file)
+	assert.Nil(err)
+	assert.Nil(ioutil.WriteFile(file, []byte(" \n"), 0600))
+	_, err = LoadSecretKey(file)
+	assert.NotNil(err)
+
+	_, keyPEM, err := GenerateCA("test-ca", time.Hour)
+	assert.Nil(err)
+	sealed, err := SealKey(secret, keyPEM)
+	assert.Nil(err)
+	assert.NotContains(sealed, "PRIVATE KEY")
+	opened, err := OpenKey(secret, sealed)
+	assert.Nil(err)
+	assert.Equal(keyPEM, opened)
+
+	_, err = OpenKey(other, sealed)
+	assert.NotNil(err)
+	_, err = OpenKey(secret, "invalid")
+	assert.NotNil(err)
+	_, err = SealKey(nil, keyPEM)
+	assert.NotNil(err)
+}
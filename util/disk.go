@@ -0,0 +1,131 @@
+package util
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// DiskUsage returns the apparent size and the allocated bytes of all the
+// files under path. They differ for sparse files.
+func DiskUsage(path string) (apparent, allocated int64, err error) {
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		apparent += info.Size()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			// st_blocks is always in 512-byte units
+			allocated += stat.Blocks * 512
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "fail to get disk usage of %v", path)
+	}
+	return apparent, allocated, nil
+}
+
+// FilesystemCapacity returns the size and the space available to unprivileged
+// users of the filesystem containing path
+func FilesystemCapacity(path string) (total, available int64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, errors.Wrapf(err, "fail to get filesystem capacity of %v", path)
+	}
+	return int64(stat.Blocks) * int64(stat.Bsize), int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// CheckBlockDevice fails unless path is a block device of at least size
+// bytes nothing else uses. Opening a block device exclusively fails while
+// it's mounted, part of device mapper or md, or opened exclusively already.
+func CheckBlockDevice(path string, size int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "cannot find block device %v", path)
+	}
+	if info.Mode()&os.ModeDevice == 0 || info.Mode()&os.ModeCharDevice != 0 {
+		return errors.Errorf("%v is not a block device", path)
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_EXCL, 0)
+	if err != nil {
+		return errors.Wrapf(err, "block device %v is in use", path)
+	}
+	defer f.Close()
+	deviceSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrapf(err, "fail to get size of block device %v", path)
+	}
+	if deviceSize < size {
+		return errors.Errorf("block device %v of %v bytes is smaller than %v bytes", path, deviceSize, size)
+	}
+	return nil
+}
+
+// MountMarker in the root of a filesystem marks it mounted where it's
+// expected, for bind mounts which share the device of their parent
+const MountMarker = ".longhorn-mount"
+
+// CheckMountpoint fails unless path is a writable directory the filesystem
+// of which is mounted there, with at least size bytes free. An unmounted
+// mountpoint would otherwise take the data on the filesystem of its parent,
+// often the root disk. The error names the check failed.
+func CheckMountpoint(path string, size int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "mountpoint %v: cannot find it", path)
+	}
+	if !info.IsDir() {
+		return errors.Errorf("mountpoint %v: not a directory", path)
+	}
+	mounted, err := isMountpoint(path, info)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		if _, err := os.Stat(filepath.Join(path, MountMarker)); err != nil {
+			return errors.Errorf("mountpoint %v: not mounted, it's on the filesystem of %v and has no %v marker",
+				path, filepath.Dir(path), MountMarker)
+		}
+	}
+	f, err := ioutil.TempFile(path, ".longhorn-check-")
+	if err != nil {
+		return errors.Wrapf(err, "mountpoint %v: not writable", path)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	_, available, err := FilesystemCapacity(path)
+	if err != nil {
+		return errors.Wrapf(err, "mountpoint %v", path)
+	}
+	if available < size {
+		return errors.Errorf("mountpoint %v: %v bytes free, %v bytes needed", path, available, size)
+	}
+	return nil
+}
+
+// isMountpoint compares the device of the directory with its parent's
+func isMountpoint(path string, info os.FileInfo) (bool, error) {
+	path = filepath.Clean(path)
+	parent := filepath.Dir(path)
+	if parent == path {
+		return true, nil
+	}
+	parentInfo, err := os.Stat(parent)
+	if err != nil {
+		return false, errors.Wrapf(err, "mountpoint %v: cannot find its parent", path)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	parentStat, parentOK := parentInfo.Sys().(*syscall.Stat_t)
+	if !ok || !parentOK {
+		return false, errors.Errorf("mountpoint %v: cannot get its device", path)
+	}
+	return stat.Dev != parentStat.Dev, nil
+}
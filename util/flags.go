@@ -0,0 +1,110 @@
+package util
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+// ParseDurationFlag parses a duration option such as "30s" or "2m". A bare
+// integer is still taken as seconds, but it's deprecated and will be removed
+// in the next release.
+func ParseDurationFlag(name, value string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		logrus.Warnf("Deprecated: bare integer '%v' for --%v is taken as seconds, use \"%vs\" instead", value, name, secs)
+		return time.Duration(secs) * time.Second, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, errors.Errorf("invalid value '%v' for --%v, expecting a duration such as \"30s\" or \"2m\"", value, name)
+	}
+	return d, nil
+}
+
+const Redacted = "REDACTED"
+
+var secretKeyRegexp = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|key)`)
+
+// RedactURL hides the password of the user info of a URL, such as an etcd
+// server. A value which isn't a URL is returned as it is.
+func RedactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), Redacted)
+	}
+	return u.String()
+}
+
+// RedactOpts hides the values of the options whose key looks like a secret,
+// such as "splunk-token"
+func RedactOpts(opts map[string]string) map[string]string {
+	if opts == nil {
+		return nil
+	}
+	redacted := map[string]string{}
+	for k, v := range opts {
+		if secretKeyRegexp.MatchString(k) {
+			v = Redacted
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// RedactEnv hides the values of the NAME=value variables whose name looks
+// like a secret, and the passwords of the URLs of the others
+func RedactEnv(env []string) []string {
+	if env == nil {
+		return nil
+	}
+	redacted := []string{}
+	for _, v := range env {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) == 2 {
+			if secretKeyRegexp.MatchString(parts[0]) {
+				v = parts[0] + "=" + Redacted
+			} else {
+				v = parts[0] + "=" + RedactURL(parts[1])
+			}
+		}
+		redacted = append(redacted, v)
+	}
+	return redacted
+}
+
+// RedactJSON hides the string values of the fields whose key looks like a
+// secret, at any depth, and indents the JSON for reading
+func RedactJSON(value []byte) ([]byte, error) {
+	var obj interface{}
+	if err := json.Unmarshal(value, &obj); err != nil {
+		return nil, errors.Wrap(err, "invalid JSON")
+	}
+	return json.MarshalIndent(redactJSONValue(obj), "", "  ")
+}
+
+func redactJSONValue(obj interface{}) interface{} {
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if _, ok := field.(string); ok && secretKeyRegexp.MatchString(k) {
+				v[k] = Redacted
+				continue
+			}
+			v[k] = redactJSONValue(field)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactJSONValue(v[i])
+		}
+	}
+	return obj
+}
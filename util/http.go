@@ -6,6 +6,19 @@ import (
 	"net/http"
 )
 
+var (
+	// ManagerScheme and ManagerTransport are used by the requests between
+	// the managers. With internal TLS, they are https and a transport
+	// verifying the peers against the cluster CA.
+	ManagerScheme                      = "http"
+	ManagerTransport http.RoundTripper = http.DefaultTransport
+)
+
+// ManagerURL is the URL of path on the manager at address
+func ManagerURL(address, path string) string {
+	return ManagerScheme + "://" + address + path
+}
+
 func CopyReq(req *http.Request) *http.Request {
 	r := *req
 	buf, _ := ioutil.ReadAll(r.Body)
@@ -0,0 +1,222 @@
+// Package runner gives the background loops of the manager a common
+// lifecycle: each one runs until its context is cancelled, is restarted with
+// a backoff if it crashes, and is waited for on shutdown, the last started
+// first.
+package runner
+
+import (
+	"context"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+var (
+	// DefaultStopDeadline is how long a runner without a deadline of its
+	// own is waited for on shutdown
+	DefaultStopDeadline = 5 * time.Second
+	// RestartBackoff is the wait before restarting a crashed runner,
+	// doubled on every crash in a row up to MaxRestartBackoff
+	RestartBackoff    = time.Second
+	MaxRestartBackoff = time.Minute
+)
+
+// Runner is a background loop
+type Runner struct {
+	Name string
+	// Run loops until ctx is done, and returns nil then. An error returned
+	// before, or a panic, is a crash. A nil returned before is the end of
+	// the loop, e.g. one disabled by the settings.
+	Run func(ctx context.Context) error
+	// StopDeadline is how long the runner is waited for once its context is
+	// cancelled, DefaultStopDeadline if 0
+	StopDeadline time.Duration
+}
+
+// Status is a runner as reported
+type Status struct {
+	Name          string `json:"name"`
+	Running       bool   `json:"running"`
+	Started       string `json:"started,omitempty"`
+	Restarts      int    `json:"restarts"`
+	LastError     string `json:"lastError,omitempty"`
+	LastErrorTime string `json:"lastErrorTime,omitempty"`
+	// NextRestart is set while a crashed runner waits to be restarted
+	NextRestart  string `json:"nextRestart,omitempty"`
+	StopDeadline string `json:"stopDeadline"`
+}
+
+type runner struct {
+	Runner
+
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	status Status
+}
+
+// Group is the runners of a daemon, started one by one and stopped in the
+// reverse order
+type Group struct {
+	mutex   sync.Mutex
+	runners []*runner
+	stopped bool
+}
+
+func NewGroup() *Group {
+	return &Group{}
+}
+
+// Go starts the runner in the group, and returns if it did. A runner added
+// once the group is stopped isn't started.
+func (g *Group) Go(r Runner) bool {
+	if r.StopDeadline <= 0 {
+		r.StopDeadline = DefaultStopDeadline
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &runner{
+		Runner: r,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: Status{
+			Name:         r.Name,
+			StopDeadline: r.StopDeadline.String(),
+		},
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.stopped {
+		cancel()
+		logrus.Warnf("runner %v not started, shutting down", r.Name)
+		return false
+	}
+	g.runners = append(g.runners, run)
+	go run.loop(ctx)
+	return true
+}
+
+// Stop cancels the runners from the last started to the first, each waited
+// for until its stop deadline before moving on to the previous one. The
+// error lists the runners still running past their deadline.
+func (g *Group) Stop() error {
+	g.mutex.Lock()
+	g.stopped = true
+	runners := g.runners
+	g.mutex.Unlock()
+
+	late := []string{}
+	for i := len(runners) - 1; i >= 0; i-- {
+		r := runners[i]
+		r.cancel()
+		select {
+		case <-r.done:
+		case <-time.After(r.StopDeadline):
+			logrus.Warnf("runner %v still running %v after it was stopped", r.Name, r.StopDeadline)
+			late = append(late, r.Name)
+		}
+	}
+	if len(late) != 0 {
+		return errors.Errorf("runners %v didn't stop within their deadline", strings.Join(late, ", "))
+	}
+	return nil
+}
+
+// Status reports the runners in the order they were started
+func (g *Group) Status() []*Status {
+	g.mutex.Lock()
+	runners := g.runners
+	g.mutex.Unlock()
+
+	statuses := []*Status{}
+	for _, r := range runners {
+		r.mutex.Lock()
+		status := r.status
+		r.mutex.Unlock()
+		statuses = append(statuses, &status)
+	}
+	return statuses
+}
+
+func (r *runner) loop(ctx context.Context) {
+	defer close(r.done)
+	backoff := RestartBackoff
+	for {
+		started := time.Now()
+		r.update(func(s *Status) {
+			s.Running = true
+			s.Started = started.UTC().Format(time.RFC3339)
+			s.NextRestart = ""
+		})
+		err := r.runOnce(ctx)
+		r.update(func(s *Status) {
+			s.Running = false
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			logrus.Infof("runner %v ended", r.Name)
+			return
+		}
+
+		// a runner up for a while crashing again isn't crash looping
+		if time.Since(started) > MaxRestartBackoff {
+			backoff = RestartBackoff
+		}
+		logrus.Errorf("%+v", errors.Wrapf(err, "runner %v crashed, restarting in %v", r.Name, backoff))
+		now := time.Now()
+		r.update(func(s *Status) {
+			s.LastError = err.Error()
+			s.LastErrorTime = now.UTC().Format(time.RFC3339)
+			s.NextRestart = now.Add(backoff).UTC().Format(time.RFC3339)
+		})
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		r.update(func(s *Status) {
+			s.Restarts++
+		})
+		if backoff *= 2; backoff > MaxRestartBackoff {
+			backoff = MaxRestartBackoff
+		}
+	}
+}
+
+// runOnce runs the loop, a panic returned as an error
+func (r *runner) runOnce(ctx context.Context) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logrus.Errorf("runner %v panicked: %v\n%s", r.Name, p, debug.Stack())
+			err = errors.Errorf("panic: %v", p)
+		}
+	}()
+	return r.Run(ctx)
+}
+
+func (r *runner) update(f func(s *Status)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	f(&r.status)
+}
+
+// Tick calls f every period until ctx is done, the first time one period
+// after it's called
+func Tick(ctx context.Context, period time.Duration, f func()) error {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
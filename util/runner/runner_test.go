@@ -0,0 +1,121 @@
+package runner
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func waitFor(assert *require.Assertions, f func() bool) {
+	for i := 0; i < 100 && !f(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(f())
+}
+
+func TestGroup(t *testing.T) {
+	assert := require.New(t)
+
+	mutex := sync.Mutex{}
+	stopped := []string{}
+	loop := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			<-ctx.Done()
+			mutex.Lock()
+			defer mutex.Unlock()
+			stopped = append(stopped, name)
+			return nil
+		}
+	}
+
+	g := NewGroup()
+	g.Go(Runner{Name: "first", Run: loop("first")})
+	g.Go(Runner{Name: "second", Run: loop("second"), StopDeadline: time.Second})
+	g.Go(Runner{Name: "disabled", Run: func(ctx context.Context) error { return nil }})
+	g.Go(Runner{Name: "third", Run: loop("third")})
+
+	waitFor(assert, func() bool {
+		s := g.Status()
+		return s[0].Running && s[1].Running && s[3].Running && s[2].Started != "" && !s[2].Running
+	})
+	statuses := g.Status()
+	assert.Len(statuses, 4)
+	assert.Equal("first", statuses[0].Name)
+	assert.True(statuses[0].Running)
+	assert.Equal(DefaultStopDeadline.String(), statuses[0].StopDeadline)
+	assert.Equal("1s", statuses[1].StopDeadline)
+	// ended on its own, not restarted
+	assert.False(statuses[2].Running)
+	assert.Equal(0, statuses[2].Restarts)
+
+	assert.Nil(g.Stop())
+	assert.Equal([]string{"third", "second", "first"}, stopped)
+	for _, status := range g.Status() {
+		assert.False(status.Running)
+	}
+
+	// not started once stopped
+	g.Go(Runner{Name: "late", Run: loop("late")})
+	assert.Len(g.Status(), 4)
+	assert.Nil(g.Stop())
+}
+
+func TestGroupRestart(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(backoff, max time.Duration) {
+		RestartBackoff, MaxRestartBackoff = backoff, max
+	}(RestartBackoff, MaxRestartBackoff)
+	RestartBackoff = 10 * time.Millisecond
+	MaxRestartBackoff = 20 * time.Millisecond
+
+	mutex := sync.Mutex{}
+	runs := 0
+	g := NewGroup()
+	g.Go(Runner{Name: "crashing", Run: func(ctx context.Context) error {
+		mutex.Lock()
+		runs++
+		run := runs
+		mutex.Unlock()
+		switch run {
+		case 1:
+			return errors.Errorf("etcd unavailable")
+		case 2:
+			panic("nil map")
+		}
+		<-ctx.Done()
+		return nil
+	}})
+
+	waitFor(assert, func() bool { return g.Status()[0].Restarts == 2 && g.Status()[0].Running })
+	status := g.Status()[0]
+	assert.Equal("panic: nil map", status.LastError)
+	assert.NotEqual("", status.LastErrorTime)
+	assert.Equal("", status.NextRestart)
+
+	assert.Nil(g.Stop())
+	assert.False(g.Status()[0].Running)
+}
+
+func TestGroupStopDeadline(t *testing.T) {
+	assert := require.New(t)
+
+	release := make(chan struct{})
+	g := NewGroup()
+	g.Go(Runner{Name: "stuck", StopDeadline: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+		<-release
+		return nil
+	}})
+	g.Go(Runner{Name: "tick", Run: func(ctx context.Context) error {
+		return Tick(ctx, time.Millisecond, func() {})
+	}})
+
+	err := g.Stop()
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "runners stuck didn't stop")
+	close(release)
+}
@@ -1,49 +1,123 @@
 package server
 
 import (
-	"github.com/Sirupsen/logrus"
-	"github.com/docker/go-connections/sockets"
-	"github.com/pkg/errors"
+	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/go-connections/sockets"
+	"github.com/pkg/errors"
 )
 
 type UnixServer struct {
 	sockFile string
+	listener net.Listener
+	server   http.Server
 }
 
 func NewUnixServer(sockFile string) *UnixServer {
-	return &UnixServer{sockFile}
+	return &UnixServer{sockFile: sockFile}
 }
 
-func (s *UnixServer) Serve(handler http.Handler) {
+// Listen creates the socket, readable and writable by root only. A stale
+// socket left by a previous run is removed, but not a socket still being
+// served or any other file.
+func (s *UnixServer) Listen() error {
 	if err := os.MkdirAll(filepath.Dir(s.sockFile), 0755); err != nil {
-		logrus.Fatalf("%+v", errors.Wrapf(err, "error creating parent dir for '%s'", s.sockFile))
+		return errors.Wrapf(err, "error creating parent dir for '%s'", s.sockFile)
 	}
-	server := http.Server{
-		Addr:    s.sockFile,
-		Handler: handler,
+	if info, err := os.Lstat(s.sockFile); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return errors.Errorf("'%s' exists and is not a socket", s.sockFile)
+		}
+		if conn, err := net.Dial("unix", s.sockFile); err == nil {
+			conn.Close()
+			return errors.Errorf("'%s' is being served by another process", s.sockFile)
+		}
+		logrus.Infof("Removing stale unix socket %v", s.sockFile)
 	}
 	listener, err := sockets.NewUnixSocket(s.sockFile, 0)
 	if err != nil {
-		logrus.Fatalf("Failed opening unix socket '%s'", s.sockFile)
+		return errors.Wrapf(err, "failed opening unix socket '%s'", s.sockFile)
+	}
+	s.listener = listener
+	return nil
+}
+
+func (s *UnixServer) Serve(handler http.Handler) {
+	if s.listener == nil {
+		if err := s.Listen(); err != nil {
+			logrus.Fatalf("%+v", err)
+		}
 	}
+	s.server.Addr = s.sockFile
+	s.server.Handler = handler
 	logrus.Infof("Unix socket server listening at %v", s.sockFile)
-	err = server.Serve(listener)
+	err := s.server.Serve(s.listener)
+	if err == http.ErrServerClosed {
+		return
+	}
 	logrus.Fatalf("server.Serve returned error: %+v", errors.Wrap(err, "http server error"))
 }
 
+// Shutdown stops accepting connections, and waits for the requests in
+// flight until ctx is done
+func (s *UnixServer) Shutdown(ctx context.Context) error {
+	return errors.Wrapf(s.server.Shutdown(ctx), "fail to shut down server at %v", s.sockFile)
+}
+
 type TCPServer struct {
-	addr string
+	addr      string
+	listener  net.Listener
+	server    http.Server
+	tlsConfig *tls.Config
 }
 
 func NewTCPServer(addrPort string) *TCPServer {
-	return &TCPServer{addrPort}
+	return &TCPServer{addr: addrPort}
+}
+
+func (s *TCPServer) Listen() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return errors.Wrapf(err, "failed listening at %v", s.addr)
+	}
+	s.listener = listener
+	return nil
+}
+
+// SetTLSConfig serves TLS with config, to be called before Serve
+func (s *TCPServer) SetTLSConfig(config *tls.Config) {
+	s.tlsConfig = config
 }
 
 func (s *TCPServer) Serve(handler http.Handler) {
-	logrus.Infof("TCP server listening at %v", s.addr)
-	err := http.ListenAndServe(s.addr, handler)
-	logrus.Fatalf("http.ListenAndServe returned error: %+v", errors.Wrap(err, "http server error"))
+	if s.listener == nil {
+		if err := s.Listen(); err != nil {
+			logrus.Fatalf("%+v", err)
+		}
+	}
+	s.server.Handler = handler
+	listener := s.listener
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+		logrus.Infof("TCP server listening at %v with TLS", s.addr)
+	} else {
+		logrus.Infof("TCP server listening at %v", s.addr)
+	}
+	err := s.server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return
+	}
+	logrus.Fatalf("http.Serve returned error: %+v", errors.Wrap(err, "http server error"))
+}
+
+// Shutdown stops accepting connections, and waits for the requests in
+// flight until ctx is done
+func (s *TCPServer) Shutdown(ctx context.Context) error {
+	return errors.Wrapf(s.server.Shutdown(ctx), "fail to shut down server at %v", s.addr)
 }
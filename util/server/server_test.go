@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnixServerListen(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "server")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	sockFile := filepath.Join(dir, "run", "manager.sock")
+
+	s := NewUnixServer(sockFile)
+	assert.Nil(s.Listen())
+	info, err := os.Stat(sockFile)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0660), info.Mode().Perm())
+
+	// still served
+	assert.NotNil(NewUnixServer(sockFile).Listen())
+
+	// stale after the process is gone
+	s.listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.Nil(s.listener.Close())
+	_, err = os.Stat(sockFile)
+	assert.Nil(err)
+	s = NewUnixServer(sockFile)
+	assert.Nil(s.Listen())
+	assert.Nil(s.listener.Close())
+
+	// not a socket
+	assert.Nil(ioutil.WriteFile(sockFile, []byte("data"), 0600))
+	assert.NotNil(NewUnixServer(sockFile).Listen())
+	data, err := ioutil.ReadFile(sockFile)
+	assert.Nil(err)
+	assert.Equal("data", string(data))
+}
+
+func TestTCPServerShutdown(t *testing.T) {
+	assert := require.New(t)
+
+	s := NewTCPServer("127.0.0.1:0")
+	assert.Nil(s.Listen())
+	addr := s.listener.Addr().String()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	served := make(chan struct{})
+	go func() {
+		s.Serve(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			close(started)
+			<-release
+			rw.Write([]byte("done"))
+		}))
+		close(served)
+	}()
+
+	respCh := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			respCh <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		respCh <- string(body)
+	}()
+	<-started
+
+	// the request in flight finishes, no new connection is accepted
+	shutdownCh := make(chan error)
+	go func() {
+		shutdownCh <- s.Shutdown(context.Background())
+	}()
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err := net.Dial("tcp", addr)
+	assert.NotNil(err)
+	close(release)
+	assert.Equal("done", <-respCh)
+	assert.Nil(<-shutdownCh)
+	<-served
+
+	// but not beyond the deadline
+	s = NewTCPServer("127.0.0.1:0")
+	assert.Nil(s.Listen())
+	addr = s.listener.Addr().String()
+	release = make(chan struct{})
+	defer close(release)
+	started = make(chan struct{})
+	go s.Serve(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	}))
+	go http.Get("http://" + addr + "/")
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.NotNil(s.Shutdown(ctx))
+}
@@ -77,3 +77,35 @@ func ParsePeriod(s string, loc *time.Location) ([2]time.Time, error) {
 
 	return r, fmt.Errorf("Error parsing time interval '%s'", s)
 }
+
+// ParseTimeWindow parses a daily UTC window in the form "HH:MM-HH:MM",
+// returning the start and end as offsets from midnight. The end may be
+// earlier than the start, in which case the window wraps past midnight.
+func ParseTimeWindow(s string) ([2]time.Duration, error) {
+	var w [2]time.Duration
+
+	ts := strings.Split(s, "-")
+	if len(ts) != 2 {
+		return w, fmt.Errorf("Error parsing time window '%s', expecting HH:MM-HH:MM", s)
+	}
+	for i, v := range ts {
+		t, err := time.Parse("15:04", strings.TrimSpace(v))
+		if err != nil {
+			return w, fmt.Errorf("Error parsing time window '%s': %v", s, err)
+		}
+		w[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if w[0] == w[1] {
+		return w, fmt.Errorf("Error parsing time window '%s': empty window", s)
+	}
+	return w, nil
+}
+
+func InTimeWindow(w [2]time.Duration, t time.Time) bool {
+	t = t.UTC()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w[0] < w[1] {
+		return offset >= w[0] && offset < w[1]
+	}
+	return offset >= w[0] || offset < w[1]
+}
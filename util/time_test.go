@@ -58,3 +58,31 @@ func TestParseTime(t *testing.T) {
 
 	assert.Equal("2015-03-01T13:00:00Z", FormatTimeZ(t3))
 }
+
+func TestTimeWindow(t *testing.T) {
+	assert := require.New(t)
+
+	w, err := ParseTimeWindow("02:00-04:30")
+	assert.Nil(err)
+	assert.Equal(2*time.Hour, w[0])
+	assert.Equal(4*time.Hour+30*time.Minute, w[1])
+
+	day := time.Date(2017, 5, 1, 0, 0, 0, 0, time.UTC)
+	assert.False(InTimeWindow(w, day.Add(time.Hour)))
+	assert.True(InTimeWindow(w, day.Add(2*time.Hour)))
+	assert.True(InTimeWindow(w, day.Add(4*time.Hour)))
+	assert.False(InTimeWindow(w, day.Add(4*time.Hour+30*time.Minute)))
+
+	w, err = ParseTimeWindow("23:00-01:00")
+	assert.Nil(err)
+	assert.True(InTimeWindow(w, day.Add(23*time.Hour+15*time.Minute)))
+	assert.True(InTimeWindow(w, day.Add(30*time.Minute)))
+	assert.False(InTimeWindow(w, day.Add(12*time.Hour)))
+
+	_, err = ParseTimeWindow("02:00")
+	assert.NotNil(err)
+	_, err = ParseTimeWindow("02:00-25:00")
+	assert.NotNil(err)
+	_, err = ParseTimeWindow("02:00-02:00")
+	assert.NotNil(err)
+}
@@ -0,0 +1,214 @@
+// Package trace times the steps of an operation as a tree of spans, e.g.
+// the attach of a volume made of scheduling the replicas, creating the
+// controller container and waiting for its device.
+package trace
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span is a step of an operation, with the steps it's made of. A nil span
+// is a step nobody traces: its children are spans on their own, and ending
+// it does nothing, so the code traced works the same without a trace.
+type Span struct {
+	mutex sync.Mutex
+
+	name     string
+	key      string // the key the operation is registered with, roots only
+	start    time.Time
+	end      time.Time
+	err      string
+	children []*Span
+}
+
+// New starts a span on its own
+func New(name string) *Span {
+	return &Span{name: name, start: time.Now()}
+}
+
+// Child starts a step of the span
+func (s *Span) Child(name string) *Span {
+	child := New(name)
+	if s == nil {
+		return child
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.children = append(s.children, child)
+	return child
+}
+
+// Childf starts a step of the span named after the format
+func (s *Span) Childf(format string, args ...interface{}) *Span {
+	return s.Child(fmt.Sprintf(format, args...))
+}
+
+// End ends the span with the error of the step, if any, and returns how
+// long it took. Only the first end counts.
+func (s *Span) End(err error) time.Duration {
+	if s == nil {
+		return 0
+	}
+	s.mutex.Lock()
+	if s.end.IsZero() {
+		s.end = time.Now()
+		if err != nil {
+			s.err = err.Error()
+		}
+	}
+	duration := s.end.Sub(s.start)
+	key := s.key
+	s.mutex.Unlock()
+	if key != "" {
+		unregister(key, s)
+	}
+	return duration
+}
+
+func (s *Span) ended() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return !s.end.IsZero()
+}
+
+// Duration is how long the span took, or has been running for if it's not
+// ended yet
+func (s *Span) Duration() time.Duration {
+	if s == nil {
+		return 0
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.end.IsZero() {
+		return time.Since(s.start)
+	}
+	return s.end.Sub(s.start)
+}
+
+// Record is a span as reported
+type Record struct {
+	Name     string        `json:"name"`
+	Start    string        `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	// Running is set for the steps not ended when recorded
+	Running bool      `json:"running,omitempty"`
+	Steps   []*Record `json:"steps,omitempty"`
+}
+
+// Record copies the span and its steps as they are now
+func (s *Span) Record() *Record {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	r := &Record{
+		Name:  s.name,
+		Start: s.start.UTC().Format(time.RFC3339),
+		Error: s.err,
+	}
+	if s.end.IsZero() {
+		r.Running = true
+		r.Duration = time.Since(s.start)
+	} else {
+		r.Duration = s.end.Sub(s.start)
+	}
+	children := append([]*Span{}, s.children...)
+	s.mutex.Unlock()
+	for _, child := range children {
+		r.Steps = append(r.Steps, child.Record())
+	}
+	return r
+}
+
+// String is the tree of the steps on a single line, for the logs
+func (r *Record) String() string {
+	if r == nil {
+		return ""
+	}
+	s := fmt.Sprintf("%v %v", r.Name, r.Duration)
+	if r.Running {
+		s += " (running)"
+	}
+	if r.Error != "" {
+		s += fmt.Sprintf(" (error: %v)", r.Error)
+	}
+	if len(r.Steps) != 0 {
+		steps := []string{}
+		for _, step := range r.Steps {
+			steps = append(steps, step.String())
+		}
+		s += " [" + strings.Join(steps, ", ") + "]"
+	}
+	return s
+}
+
+// Slowest is the step taking the longest among the steps without steps
+// of their own, the record itself if it has no steps
+func (r *Record) Slowest() *Record {
+	if r == nil || len(r.Steps) == 0 {
+		return r
+	}
+	var slowest *Record
+	for _, step := range r.Steps {
+		if s := step.Slowest(); slowest == nil || s.Duration > slowest.Duration {
+			slowest = s
+		}
+	}
+	return slowest
+}
+
+var (
+	activeMutex sync.Mutex
+	// the operations in progress by key, e.g. by volume name
+	active = map[string]*Span{}
+)
+
+// Begin starts the operation of the key, the code deeper down finds its
+// current step by the key with Current instead of having it passed along.
+// The span isn't registered if the key has an operation in progress
+// already.
+func Begin(key, name string) *Span {
+	s := New(name)
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+	if _, ok := active[key]; !ok {
+		s.key = key
+		active[key] = s
+	}
+	return s
+}
+
+func unregister(key string, s *Span) {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+	if active[key] == s {
+		delete(active, key)
+	}
+}
+
+// Current is the innermost step in progress of the operation of the key,
+// following the last step started at each level, nil if the key has no
+// operation in progress. The steps run in parallel should each have their
+// own span passed along rather than be found this way.
+func Current(key string) *Span {
+	activeMutex.Lock()
+	s := active[key]
+	activeMutex.Unlock()
+	for s != nil {
+		s.mutex.Lock()
+		var last *Span
+		if len(s.children) != 0 {
+			last = s.children[len(s.children)-1]
+		}
+		s.mutex.Unlock()
+		if last == nil || last.ended() {
+			return s
+		}
+		s = last
+	}
+	return nil
+}
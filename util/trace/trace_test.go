@@ -0,0 +1,63 @@
+package trace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpan(t *testing.T) {
+	assert := require.New(t)
+
+	root := Begin("vol", "attach")
+	assert.Equal(root, Current("vol"))
+	// one operation by key at a time
+	other := Begin("vol", "detach")
+	assert.Equal(root, Current("vol"))
+	other.End(nil)
+	assert.Equal(root, Current("vol"))
+
+	replicas := root.Child("start replicas")
+	replicas.Childf("start replica %v", 1).End(nil)
+	replicas.End(nil)
+	ctrl := root.Child("create controller")
+	assert.Equal(ctrl, Current("vol"))
+	create := Current("vol").Child("container create")
+	time.Sleep(10 * time.Millisecond)
+	create.End(nil)
+	Current("vol").Child("wait device").End(errors.Errorf("timed out"))
+	assert.Equal(ctrl, Current("vol"))
+
+	running := root.Record()
+	assert.True(running.Running)
+	assert.True(running.Steps[1].Running)
+
+	ctrl.End(nil)
+	duration := root.End(nil)
+	assert.True(duration >= 10*time.Millisecond)
+	assert.Nil(Current("vol"))
+	// ended once
+	time.Sleep(time.Millisecond)
+	assert.Equal(duration, root.End(nil))
+
+	r := root.Record()
+	assert.False(r.Running)
+	assert.Equal("attach", r.Name)
+	assert.Len(r.Steps, 2)
+	assert.Equal("start replica 1", r.Steps[0].Steps[0].Name)
+	assert.Equal("container create", r.Slowest().Name)
+	assert.Equal("timed out", r.Steps[1].Steps[1].Error)
+	assert.Contains(r.String(), "create controller")
+	assert.Contains(r.String(), "(error: timed out)")
+
+	// untraced
+	var none *Span
+	assert.Nil(Current("missing"))
+	step := none.Child("step")
+	assert.NotNil(step)
+	assert.True(step.End(nil) >= 0)
+	assert.Equal(time.Duration(0), none.End(nil))
+	assert.Nil(none.Record())
+}
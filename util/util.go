@@ -3,10 +3,14 @@ package util
 import (
 	"crypto/md5"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"os/exec"
+	"path"
+	"regexp"
 	"strings"
 	"time"
 
@@ -26,8 +30,50 @@ const (
 
 var (
 	cmdTimeout = time.Minute // one minute by default
+
+	// APIPollInterval between the checks in WaitForAPI
+	APIPollInterval = time.Second
+	// APIErrorRetries of unexpected statuses before WaitForAPI gives up
+	APIErrorRetries = 3
+
+	// EnvNamePatterns are the environment variables the instances may be
+	// given, as path.Match patterns, e.g. the log level and sync tunables of
+	// the engine and the proxy of the backup target
+	EnvNamePatterns = []string{
+		"LOG_*", "SYNC_*", "ENGINE_*", "LONGHORN_*", "GO*", "TZ",
+		"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+	}
+
+	dnsLabelRegexp   = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	dnsInvalidRegexp = regexp.MustCompile(`[^-a-z0-9]+`)
+	envNameRegexp    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 )
 
+const maxDNSLabelLength = 63
+
+// ValidateEnv checks the names of the environment variables, which must
+// match one of EnvNamePatterns
+func ValidateEnv(env map[string]string) error {
+	for name := range env {
+		if !envNameRegexp.MatchString(name) {
+			return errors.Errorf("invalid environment variable name '%s'", name)
+		}
+		if !envNameAllowed(name) {
+			return errors.Errorf("environment variable '%s' is not allowed, expecting one of %v", name, EnvNamePatterns)
+		}
+	}
+	return nil
+}
+
+func envNameAllowed(name string) bool {
+	for _, pattern := range EnvNamePatterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
 type MetadataConfig struct {
 	DriverName          string
 	Image               string
@@ -56,6 +102,29 @@ func VolumeStackName(volumeName string) string {
 	return stackName
 }
 
+// DNSLabel turns the name into a DNS label of lower case letters, digits
+// and dashes. Names too long for a label are cut and suffixed with their
+// hash, so the label stays the same for the same name.
+func DNSLabel(name string) string {
+	label := dnsInvalidRegexp.ReplaceAllString(strings.ToLower(name), "-")
+	label = strings.Trim(label, "-")
+	if len(label) > maxDNSLabelLength {
+		hash := fmt.Sprintf("%x", md5.Sum([]byte(name)))
+		label = strings.TrimRight(label[:maxDNSLabelLength-len(hash)-1], "-") + "-" + hash
+	}
+	return label
+}
+
+func ValidateDNSLabel(label string) error {
+	if len(label) > maxDNSLabelLength {
+		return errors.Errorf("invalid DNS label %q, longer than %v characters", label, maxDNSLabelLength)
+	}
+	if !dnsLabelRegexp.MatchString(label) {
+		return errors.Errorf("invalid DNS label %q, expecting lower case letters, digits and dashes", label)
+	}
+	return nil
+}
+
 func ControllerAddress(volumeName string) string {
 	return fmt.Sprintf("%s.%s.rancher.internal", ControllerServiceName, VolumeStackName(volumeName))
 }
@@ -80,7 +149,8 @@ func ConvertSize(size interface{}) (int64, error) {
 		if size == "" {
 			return 0, nil
 		}
-		sizeInBytes, err := units.RAMInBytes(size)
+		// RAMInBytes is binary based already, so "Gi" is the same as "G"
+		sizeInBytes, err := units.RAMInBytes(strings.TrimSuffix(size, "i"))
 		if err != nil {
 			return 0, errors.Wrapf(err, "error parsing size '%s'", size)
 		}
@@ -128,9 +198,8 @@ func UUID() string {
 	return uuid.NewV4().String()
 }
 
-// WaitForDevice timeout in second
-func WaitForDevice(dev string, timeout int) error {
-	for i := 0; i < timeout; i++ {
+func WaitForDevice(dev string, timeout time.Duration) error {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
 		st, err := os.Stat(dev)
 		if err == nil {
 			if st.Mode()&os.ModeDevice == 0 {
@@ -163,17 +232,62 @@ func GetLocalIPs() ([]string, error) {
 	return results, nil
 }
 
-// WaitForAPI timeout in second
-func WaitForAPI(url string, timeout int) error {
-	for i := 0; i < timeout; i++ {
-		resp, err := http.Get(url)
-		if err == nil {
-			resp.Body.Close()
+// WaitForAPI polls healthPath at url until it returns 2xx. Connection
+// refused or reset and 502/503/504 are expected while the engine starts, so
+// they are retried until the timeout. Any other status fails after
+// APIErrorRetries consecutive responses.
+func WaitForAPI(url, healthPath string, timeout time.Duration) error {
+	endpoint := url + healthPath
+	client := &http.Client{Timeout: APIPollInterval * 5}
+	failures := 0
+	var lastErr error
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(APIPollInterval) {
+		resp, err := client.Get(endpoint)
+		if err != nil {
+			if !isTransientNetError(err) {
+				return errors.Wrapf(err, "fail to reach %v", endpoint)
+			}
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
 			return nil
+		case resp.StatusCode == http.StatusBadGateway ||
+			resp.StatusCode == http.StatusServiceUnavailable ||
+			resp.StatusCode == http.StatusGatewayTimeout:
+			failures = 0
+		default:
+			failures++
+			if failures >= APIErrorRetries {
+				return errors.Errorf("%v returned %v", endpoint, resp.Status)
+			}
 		}
-		time.Sleep(1 * time.Second)
+		lastErr = errors.Errorf("%v returned %v", endpoint, resp.Status)
+	}
+	if lastErr != nil {
+		return errors.Wrapf(lastErr, "timeout waiting for %v", endpoint)
+	}
+	return fmt.Errorf("timeout waiting for %v", endpoint)
+}
+
+// isTransientNetError is true for the errors of a server not listening yet,
+// e.g. connection refused or reset, EOF or timeout
+func isTransientNetError(err error) bool {
+	if uerr, ok := err.(*neturl.Error); ok {
+		err = uerr.Err
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(*net.OpError); ok {
+		return true
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return true
 	}
-	return fmt.Errorf("timeout waiting for %v", url)
+	return false
 }
 
 func Now() string {
@@ -202,7 +316,7 @@ func ExecuteWithTimeout(timeout time.Duration, binary string, args ...string) (s
 
 	select {
 	case <-done:
-	case <-time.After(cmdTimeout):
+	case <-time.After(timeout):
 		if cmd.Process != nil {
 			if err := cmd.Process.Kill(); err != nil {
 				logrus.Warnf("Problem killing process pid=%v: %s", cmd.Process.Pid, err)
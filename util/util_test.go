@@ -1,8 +1,17 @@
 package util
 
 import (
-	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestConvertSize(t *testing.T) {
@@ -19,6 +28,21 @@ func TestConvertSize(t *testing.T) {
 	size, err = ConvertSize("1024")
 	assert.Nil(err)
 	assert.Equal(int64(1024), size)
+
+	size, err = ConvertSize("2Ki")
+	assert.Nil(err)
+	assert.Equal(int64(2048), size)
+
+	size, err = ConvertSize("10Gi")
+	assert.Nil(err)
+	assert.Equal(int64(10*1024*1024*1024), size)
+
+	size, err = ConvertSize("10Mi")
+	assert.Nil(err)
+	assert.Equal(int64(10*1024*1024), size)
+
+	_, err = ConvertSize("10Xi")
+	assert.NotNil(err)
 }
 
 func TestRoundUpSize(t *testing.T) {
@@ -35,3 +59,126 @@ func TestReplicaName(t *testing.T) {
 	assert.Equal("replica-XX", ReplicaName("tcp://replica-XX.rancher.internal:9502", "tt"))
 	assert.Equal("replica-XX", ReplicaName("tcp://replica-XX.volume-tt:9502", "tt"))
 }
+
+func TestDNSLabel(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("vol-1-replica-ab12", DNSLabel("Vol_1-replica-ab12"))
+	assert.Equal("vol-x", DNSLabel("..vol.x--"))
+	assert.Nil(ValidateDNSLabel(DNSLabel("vol-replica")))
+
+	long := strings.Repeat("a", 70)
+	label := DNSLabel(long + "-replica-1")
+	assert.Len(label, 63)
+	assert.Nil(ValidateDNSLabel(label))
+	assert.Equal(label, DNSLabel(long+"-replica-1"))
+	assert.NotEqual(label, DNSLabel(long+"-replica-2"))
+
+	assert.NotNil(ValidateDNSLabel(""))
+	assert.NotNil(ValidateDNSLabel("-vol"))
+	assert.NotNil(ValidateDNSLabel("Vol"))
+	assert.NotNil(ValidateDNSLabel(long))
+}
+
+func TestValidateEnv(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Nil(ValidateEnv(nil))
+	assert.Nil(ValidateEnv(map[string]string{"LOG_LEVEL": "debug", "GOMAXPROCS": "2", "https_proxy": ""}))
+	assert.NotNil(ValidateEnv(map[string]string{"1LOG": "debug"}))
+	assert.NotNil(ValidateEnv(map[string]string{"LD_PRELOAD": "/tmp/x.so"}))
+}
+
+func TestWaitForAPI(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(interval time.Duration) { APIPollInterval = interval }(APIPollInterval)
+	APIPollInterval = 10 * time.Millisecond
+
+	// reserve a port and refuse connections on it until the server starts
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	addr := l.Addr().String()
+	assert.Nil(l.Close())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/health", func(w http.ResponseWriter, r *http.Request) {})
+	errCh := make(chan error)
+	go func() {
+		errCh <- WaitForAPI("http://"+addr, "/v1/health", 5*time.Second)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	l, err = net.Listen("tcp", addr)
+	assert.Nil(err)
+	server := &http.Server{Handler: mux}
+	go server.Serve(l)
+	defer server.Close()
+	assert.Nil(<-errCh)
+
+	// not ready yet, retried until the timeout
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	start := time.Now()
+	err = WaitForAPI(unavailable.URL, "/v1", 200*time.Millisecond)
+	assert.NotNil(err)
+	assert.True(time.Since(start) >= 200*time.Millisecond)
+
+	// an error that won't clear fails early
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	start = time.Now()
+	err = WaitForAPI(broken.URL, "/v1", 5*time.Second)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "500")
+	assert.True(time.Since(start) < time.Second)
+}
+
+func TestCheckBlockDevice(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "block")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "disk.img")
+	assert.Nil(ioutil.WriteFile(file, make([]byte, 4096), 0600))
+
+	err = CheckBlockDevice(file, 4096)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "not a block device")
+	err = CheckBlockDevice(filepath.Join(dir, "missing"), 4096)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "cannot find block device")
+	// a character device
+	err = CheckBlockDevice("/dev/null", 0)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "not a block device")
+}
+
+func TestCheckMountpoint(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "mountpoint")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	// an unmounted mountpoint is a directory on the filesystem of its parent
+	mountpoint := filepath.Join(dir, "data")
+	assert.Nil(os.Mkdir(mountpoint, 0700))
+
+	err = CheckMountpoint(mountpoint, 0)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "mountpoint "+mountpoint+": not mounted")
+	err = CheckMountpoint(filepath.Join(dir, "missing"), 0)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "cannot find it")
+
+	// a bind mount shares the device of its parent, it's marked instead
+	assert.Nil(ioutil.WriteFile(filepath.Join(mountpoint, MountMarker), nil, 0600))
+	assert.Nil(CheckMountpoint(mountpoint, 4096))
+	err = CheckMountpoint(mountpoint, 1<<62)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "bytes needed")
+}
@@ -0,0 +1,200 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0 or 1 if v is older than, the same as or newer than o
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// parsePartialVersion parses "v1.2.3", "1.2", "1.x" or "*", returning the
+// number of parts specified. Pre-release and build suffixes are ignored.
+func parsePartialVersion(s string) (Version, int, error) {
+	v := Version{}
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	if s == "" {
+		return v, 0, errors.New("empty version")
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, 0, errors.Errorf("invalid version '%s'", s)
+	}
+	fields := []*int{&v.Major, &v.Minor, &v.Patch}
+	n := 0
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			break
+		}
+		num, err := strconv.Atoi(p)
+		if err != nil || num < 0 {
+			return v, 0, errors.Errorf("invalid version '%s'", s)
+		}
+		*fields[i] = num
+		n++
+	}
+	return v, n, nil
+}
+
+// ParseVersion parses a full or partial version, the missing parts are 0
+func ParseVersion(s string) (Version, error) {
+	v, n, err := parsePartialVersion(s)
+	if err != nil {
+		return v, err
+	}
+	if n == 0 {
+		return v, errors.Errorf("invalid version '%s'", s)
+	}
+	return v, nil
+}
+
+// ImageVersion extracts the version from the tag of the image, e.g.
+// rancher/longhorn-engine:v0.3.1
+func ImageVersion(image string) (Version, error) {
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	i := strings.LastIndex(name, ":")
+	if i < 0 || strings.Contains(name[i:], "/") {
+		return Version{}, errors.Errorf("image '%s' has no tag", image)
+	}
+	v, err := ParseVersion(name[i+1:])
+	if err != nil {
+		return v, errors.Wrapf(err, "cannot get version of image '%s'", image)
+	}
+	return v, nil
+}
+
+type versionBound struct {
+	op string // one of = > >= < <=
+	v  Version
+}
+
+func (b versionBound) check(v Version) bool {
+	c := v.Compare(b.v)
+	switch b.op {
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return c == 0
+}
+
+// VersionConstraint is a semver range: comparators separated by spaces or
+// commas must all match, ranges separated by "||" are alternatives. The
+// comparators are =, >, >=, <, <=, ^ (compatible with), ~ (same minor), and
+// versions with x wildcards, e.g. ">=0.3.0 <0.5.0 || ^1.2".
+type VersionConstraint [][]versionBound
+
+func ParseVersionConstraint(s string) (VersionConstraint, error) {
+	c := VersionConstraint{}
+	for _, r := range strings.Split(s, "||") {
+		tokens := strings.Fields(strings.Replace(r, ",", " ", -1))
+		bounds := []versionBound{}
+		for i := 0; i < len(tokens); i++ {
+			token := tokens[i]
+			// allow a space between the operator and the version
+			if strings.Trim(token, "=<>^~") == "" && i+1 < len(tokens) {
+				i++
+				token += tokens[i]
+			}
+			b, err := parseComparator(token)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid version constraint '%s'", s)
+			}
+			bounds = append(bounds, b...)
+		}
+		if len(tokens) == 0 {
+			return nil, errors.Errorf("invalid version constraint '%s': empty range", s)
+		}
+		c = append(c, bounds)
+	}
+	return c, nil
+}
+
+func parseComparator(s string) ([]versionBound, error) {
+	op := s[:len(s)-len(strings.TrimLeft(s, "=<>^~"))]
+	v, n, err := parsePartialVersion(s[len(op):])
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "", "=":
+		switch n {
+		case 0:
+			return []versionBound{}, nil
+		case 1:
+			return []versionBound{{">=", v}, {"<", Version{Major: v.Major + 1}}}, nil
+		case 2:
+			return []versionBound{{">=", v}, {"<", Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+		}
+		return []versionBound{{"=", v}}, nil
+	case ">", ">=", "<", "<=":
+		return []versionBound{{op, v}}, nil
+	case "^":
+		upper := Version{Major: v.Major + 1}
+		if v.Major == 0 && n > 1 {
+			upper = Version{Minor: v.Minor + 1}
+			if v.Minor == 0 && n > 2 {
+				upper = Version{Patch: v.Patch + 1}
+			}
+		}
+		return []versionBound{{">=", v}, {"<", upper}}, nil
+	case "~":
+		upper := Version{Major: v.Major + 1}
+		if n > 1 {
+			upper = Version{Major: v.Major, Minor: v.Minor + 1}
+		}
+		return []versionBound{{">=", v}, {"<", upper}}, nil
+	}
+	return nil, errors.Errorf("invalid operator '%s'", op)
+}
+
+// Check if the version is in any of the ranges
+func (c VersionConstraint) Check(v Version) bool {
+	for _, r := range c {
+		matched := true
+		for _, b := range r {
+			if !b.check(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
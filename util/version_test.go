@@ -0,0 +1,54 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageVersion(t *testing.T) {
+	assert := require.New(t)
+
+	v, err := ImageVersion("rancher/longhorn-engine:v0.3.1")
+	assert.Nil(err)
+	assert.Equal(Version{0, 3, 1}, v)
+
+	v, err = ImageVersion("registry:5000/longhorn-engine:1.2-rc1@sha256:abcd")
+	assert.Nil(err)
+	assert.Equal(Version{1, 2, 0}, v)
+
+	_, err = ImageVersion("registry:5000/longhorn-engine")
+	assert.NotNil(err)
+	_, err = ImageVersion("rancher/longhorn-engine:latest")
+	assert.NotNil(err)
+}
+
+func TestVersionConstraint(t *testing.T) {
+	assert := require.New(t)
+
+	cases := map[string]map[string]bool{
+		">=0.3.0 <0.5.0": {"0.2.9": false, "0.3.0": true, "0.4.7": true, "0.5.0": false},
+		">= 1.0, < 2":    {"0.9.0": false, "1.5.0": true, "2.0.0": false},
+		"^1.2":           {"1.1.9": false, "1.2.0": true, "1.9.9": true, "2.0.0": false},
+		"^0.3.1":         {"0.3.0": false, "0.3.1": true, "0.3.9": true, "0.4.0": false},
+		"~1.2.3":         {"1.2.2": false, "1.2.9": true, "1.3.0": false},
+		"1.x":            {"0.9.0": false, "1.0.0": true, "1.7.2": true, "2.0.0": false},
+		"0.3.1":          {"0.3.0": false, "0.3.1": true, "0.3.2": false},
+		"<0.3 || ^2":     {"0.2.5": true, "0.3.0": false, "2.1.0": true, "3.0.0": false},
+		"*":              {"0.0.1": true, "9.9.9": true},
+	}
+	for constraint, versions := range cases {
+		c, err := ParseVersionConstraint(constraint)
+		assert.Nil(err, constraint)
+		for s, expected := range versions {
+			v, err := ParseVersion(s)
+			assert.Nil(err)
+			assert.Equal(expected, c.Check(v), "%v in %v", s, constraint)
+		}
+	}
+
+	for _, invalid := range []string{"", ">=", "1.2.3.4", "=>1.0", "abc", "1.0 ||"} {
+		_, err := ParseVersionConstraint(invalid)
+		assert.NotNil(err, invalid)
+	}
+}